@@ -0,0 +1,168 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PlacementSummaryName is the name of the one PlacementSummary the summary controller maintains
+const PlacementSummaryName = "cluster"
+
+// PlacementSummaryStatus totals the placement of every managed workload in the cluster
+type PlacementSummaryStatus struct {
+	// ManagedDeployments is the number of workloads with a placement strategy
+	ManagedDeployments int32 `json:"managedDeployments"`
+
+	// Pods counts the managed pods by the capacity type of the rule they are placed on
+	Pods CapacityTypePods `json:"pods"`
+
+	// PendingUnschedulable is the number of managed pods the scheduler reported Unschedulable
+	PendingUnschedulable int32 `json:"pendingUnschedulable"`
+
+	// WorstDrift is the managed workload whose placement drifted furthest from its strategy
+	WorstDrift *WorkloadDrift `json:"worstDrift,omitempty"`
+
+	// LastUpdated when the totals were calculated
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+}
+
+// CapacityTypePods counts pods by the capacity type of their rule
+type CapacityTypePods struct {
+	// OnDemand pods are on rules neither preemptible nor selecting spot capacity
+	OnDemand int32 `json:"onDemand"`
+
+	// Spot pods are on rules selecting spot capacity
+	Spot int32 `json:"spot"`
+
+	// Preemptible pods are on other rules marked preemptible=true
+	Preemptible int32 `json:"preemptible"`
+}
+
+// WorkloadDrift names a workload and its placement drift
+type WorkloadDrift struct {
+	// Kind of the workload, Deployment or StatefulSet
+	Kind string `json:"kind"`
+
+	// Namespace of the workload
+	Namespace string `json:"namespace"`
+
+	// Name of the workload
+	Name string `json:"name"`
+
+	// DriftPercentage between the pods per rule and the counts the strategy expects
+	DriftPercentage float64 `json:"driftPercentage"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Deployments",type="integer",JSONPath=".status.managedDeployments"
+//+kubebuilder:printcolumn:name="On-Demand",type="integer",JSONPath=".status.pods.onDemand"
+//+kubebuilder:printcolumn:name="Spot",type="integer",JSONPath=".status.pods.spot"
+//+kubebuilder:printcolumn:name="Unschedulable",type="integer",JSONPath=".status.pendingUnschedulable"
+//+kubebuilder:printcolumn:name="Worst Drift",type="string",JSONPath=".status.worstDrift.driftPercentage"
+//+kubebuilder:printcolumn:name="Updated",type="date",JSONPath=".status.lastUpdated"
+
+// PlacementSummary is the Schema for the placementsummaries API
+type PlacementSummary struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status PlacementSummaryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PlacementSummaryList contains a list of PlacementSummary
+type PlacementSummaryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PlacementSummary `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PlacementSummary{}, &PlacementSummaryList{})
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementSummary) DeepCopyInto(out *PlacementSummary) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementSummary.
+func (in *PlacementSummary) DeepCopy() *PlacementSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PlacementSummary) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementSummaryList) DeepCopyInto(out *PlacementSummaryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PlacementSummary, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementSummaryList.
+func (in *PlacementSummaryList) DeepCopy() *PlacementSummaryList {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementSummaryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PlacementSummaryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementSummaryStatus) DeepCopyInto(out *PlacementSummaryStatus) {
+	*out = *in
+	out.Pods = in.Pods
+	if in.WorstDrift != nil {
+		in, out := &in.WorstDrift, &out.WorstDrift
+		*out = new(WorkloadDrift)
+		**out = **in
+	}
+	if in.LastUpdated != nil {
+		in, out := &in.LastUpdated, &out.LastUpdated
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementSummaryStatus.
+func (in *PlacementSummaryStatus) DeepCopy() *PlacementSummaryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementSummaryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
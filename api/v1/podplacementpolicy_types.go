@@ -3,6 +3,7 @@ package v1
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // PodPlacementPolicySpec defines the desired state of PodPlacementPolicy
@@ -10,22 +11,123 @@ type PodPlacementPolicySpec struct {
 	// Selector defines which deployments this policy applies to
 	Selector *metav1.LabelSelector `json:"selector"`
 
+	// PodSelector limits the strategy to the pods of the selected deployments whose labels match,
+	// e.g. the workers but not the leader. Other pods are admitted unchanged and not counted.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
 	// Strategy defines the placement strategy
 	Strategy PlacementStrategySpec `json:"strategy"`
 
+	// PriorityTiers override Strategy for pods whose priorityClassName matches a tier
+	PriorityTiers []PriorityTierSpec `json:"priorityTiers,omitempty"`
+
 	// Enabled controls whether this policy is active
 	Enabled bool `json:"enabled,omitempty"`
 
 	// Priority defines precedence when multiple policies match (higher = more priority)
 	Priority int32 `json:"priority,omitempty"`
+
+	// Experiment places a percentage of each matched deployment's pods with a candidate strategy,
+	// to compare it with Strategy. Requires the PlacementExperiments feature gate.
+	Experiment *PlacementExperimentSpec `json:"experiment,omitempty"`
+
+	// Propagation exposes each pod's capacity type and placement rule to its containers
+	Propagation *PlacementPropagationSpec `json:"propagation,omitempty"`
+
+	// RuleDisruptionBudget has the operator keep a PodDisruptionBudget with minAvailable set to the
+	// base on the pods of the first rule, selected by the smart-scheduler.io/rule label
+	// +optional
+	RuleDisruptionBudget bool `json:"ruleDisruptionBudget,omitempty"`
+
+	// BasePriorityClassName is given to pods filling the base, so the scheduler preempts the
+	// weighted pods first. Requires the BaseProtection feature gate.
+	// +optional
+	BasePriorityClassName string `json:"basePriorityClassName,omitempty"`
+
+	// Migration paces the rebalancing that follows a change of Strategy, moving a share of the
+	// pods per step instead of all at once
+	// +optional
+	Migration *PlacementMigrationSpec `json:"migration,omitempty"`
+}
+
+// PlacementMigrationSpec moves pods to the distribution of a changed strategy in steps
+type PlacementMigrationSpec struct {
+	// StepPercent of the deployment's replicas moved per step
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	StepPercent int32 `json:"stepPercent"`
+
+	// Interval between steps (default: 1h)
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// PlacementPropagationSpec selects how pods learn where they were placed, so application code can
+// adapt, e.g. checkpoint more often on spot
+type PlacementPropagationSpec struct {
+	// Env sets SMART_SCHEDULER_CAPACITY_TYPE and SMART_SCHEDULER_PLACEMENT_RULE on the pod's containers
+	Env bool `json:"env,omitempty"`
+
+	// Annotations sets the smart-scheduler.io/capacity-type annotation, readable through the downward API
+	Annotations bool `json:"annotations,omitempty"`
+
+	// Labels sets the smart-scheduler.io/rule label to the values of the pod's rule nodeSelector,
+	// e.g. "ondemand", so PodDisruptionBudgets and services can select the pods of one rule
+	Labels bool `json:"labels,omitempty"`
+
+	// CapacityTypeLabel is the nodeSelector key holding the capacity type; when empty the
+	// karpenter.sh/capacity-type, eks.amazonaws.com/capacityType and node-type keys are checked
+	CapacityTypeLabel string `json:"capacityTypeLabel,omitempty"`
+
+	// ExcludeContainers lists containers that get no environment variables, e.g. sidecars
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+}
+
+// PlacementExperimentSpec compares a candidate strategy with the policy's strategy
+type PlacementExperimentSpec struct {
+	// Name identifies the experiment; renaming it starts a new experiment with empty arms
+	Name string `json:"name"`
+
+	// Percentage of pods placed with the candidate strategy
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=99
+	Percentage int32 `json:"percentage"`
+
+	// Strategy is the candidate placement strategy
+	Strategy PlacementStrategySpec `json:"strategy"`
+}
+
+// PriorityTierSpec applies a dedicated strategy to pods of specific priority classes
+type PriorityTierSpec struct {
+	// PriorityClassNames lists the priority classes this tier applies to
+	PriorityClassNames []string `json:"priorityClassNames"`
+
+	// Strategy defines the placement strategy for pods in this tier
+	Strategy PlacementStrategySpec `json:"strategy"`
 }
 
 // PlacementStrategySpec defines the placement strategy
 type PlacementStrategySpec struct {
-	// Base defines minimum pods that should be placed on the first rule
-	Base int `json:"base"`
+	// Base defines minimum pods that should be placed on the first rule, as a pod count or as a
+	// percentage of the deployment's replicas ("20%") that is recomputed when the deployment scales
+	// +kubebuilder:validation:XIntOrString
+	// +kubebuilder:validation:Pattern=`^(100|[1-9]?[0-9])%$`
+	Base intstr.IntOrString `json:"base"`
+
+	// BaseFrom derives the base from another object, falling back to Base when it is absent:
+	// "pdb" uses the pods the deployment's PodDisruptionBudget keeps available
+	// +kubebuilder:validation:Enum=pdb
+	// +optional
+	BaseFrom string `json:"baseFrom,omitempty"`
+
+	// Mode selects how rules are evaluated: "weighted" (default) distributes pods by weight,
+	// "failover" treats rules as an ordered chain and uses the first rule whose node pool is healthy
+	// +kubebuilder:validation:Enum=weighted;failover
+	Mode string `json:"mode,omitempty"`
 
 	// Rules defines the placement rules with weights and constraints
+	// +kubebuilder:validation:MaxItems=64
 	Rules []PlacementRuleSpec `json:"rules"`
 
 	// RebalancePolicy controls how and when rebalancing occurs
@@ -43,11 +145,36 @@ type PlacementRuleSpec struct {
 	// Affinity rules for pod placement
 	Affinity []AffinityRuleSpec `json:"affinity,omitempty"`
 
+	// Tolerations added to the pods placed by this rule, so they run on the tainted nodes of its pool
+	Tolerations []TolerationSpec `json:"tolerations,omitempty"`
+
+	// NodeAffinity narrows this rule's pool to the nodes satisfying every expression. The
+	// expressions are added to the pods placed by this rule as required node affinity.
+	NodeAffinity []NodeAffinityExpressionSpec `json:"nodeAffinity,omitempty"`
+
 	// Name provides a human-readable identifier for this rule
 	Name string `json:"name,omitempty"`
 
 	// Description explains the purpose of this rule
 	Description string `json:"description,omitempty"`
+
+	// Reserve keeps this many low-priority placeholder pods on the rule's pool, so capacity for
+	// new pods is already provisioned. Requires the CapacityReservation feature gate.
+	// +kubebuilder:validation:Minimum=0
+	Reserve int `json:"reserve,omitempty"`
+
+	// Packing adds node affinity preferences toward the most ("most") or least ("least") utilized
+	// nodes of the rule's pool. Requires the BinPacking feature gate.
+	// +kubebuilder:validation:Enum=most;least
+	Packing string `json:"packing,omitempty"`
+
+	// SurgeTarget places the pods a rolling update creates above the deployment's replicas
+	// (within maxSurge) on this rule's pool, and marks them to be removed first
+	SurgeTarget bool `json:"surgeTarget,omitempty"`
+
+	// Preemptible marks the rule's pool as capacity that can be reclaimed at short notice, such as
+	// spot nodes. Pods with large images or init containers are kept off it.
+	Preemptible bool `json:"preemptible,omitempty"`
 }
 
 // AffinityRuleSpec defines pod affinity or anti-affinity constraints
@@ -68,6 +195,39 @@ type AffinityRuleSpec struct {
 	Weight int32 `json:"weight,omitempty"`
 }
 
+// TolerationSpec tolerates a taint of a rule's node pool
+type TolerationSpec struct {
+	// Key of the taint
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// Operator is "Equal" (default) to match the taint's value, or "Exists" to match any value
+	// +kubebuilder:validation:Enum=Equal;Exists
+	Operator string `json:"operator,omitempty"`
+
+	// Value of the taint, for the Equal operator
+	Value string `json:"value,omitempty"`
+
+	// Effect of the taint to tolerate; empty tolerates every effect
+	// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+	Effect string `json:"effect,omitempty"`
+}
+
+// NodeAffinityExpressionSpec is a node affinity expression of a rule's node pool
+type NodeAffinityExpressionSpec struct {
+	// Key is the node label the expression applies to
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// Operator relates the label to the values: In and NotIn take one or more values, Gt and Lt a
+	// single integer, Exists and DoesNotExist none
+	// +kubebuilder:validation:Enum=In;NotIn;Exists;DoesNotExist;Gt;Lt
+	Operator string `json:"operator"`
+
+	// Values of the label
+	Values []string `json:"values,omitempty"`
+}
+
 // RebalancePolicySpec controls rebalancing behavior
 type RebalancePolicySpec struct {
 	// Enabled controls whether automatic rebalancing is active
@@ -117,6 +277,39 @@ type PodPlacementPolicyStatus struct {
 
 	// ObservedGeneration reflects the generation of the most recently observed spec
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Experiment compares the pods of the running experiment's arms
+	Experiment *ExperimentStatus `json:"experiment,omitempty"`
+}
+
+// ExperimentStatus reports how the pods of each experiment arm fare
+type ExperimentStatus struct {
+	// Name of the experiment
+	Name string `json:"name"`
+
+	// StartedAt when the experiment was first applied
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// Arms holds the control and candidate arms
+	Arms []ExperimentArmStatus `json:"arms,omitempty"`
+}
+
+// ExperimentArmStatus summarises the pods of one experiment arm across the matched deployments
+type ExperimentArmStatus struct {
+	// Arm is "control" or "candidate"
+	Arm string `json:"arm"`
+
+	// Pods currently in the arm
+	Pods int32 `json:"pods"`
+
+	// Restarts of the containers of the arm's current pods
+	Restarts int32 `json:"restarts"`
+
+	// Evictions of the arm's pods since the experiment started
+	Evictions int32 `json:"evictions"`
+
+	// AveragePendingTime from creation to scheduling of the arm's current pods
+	AveragePendingTime metav1.Duration `json:"averagePendingTime,omitempty"`
 }
 
 // DeploymentReference identifies a deployment using this policy
@@ -132,6 +325,25 @@ type DeploymentReference struct {
 
 	// LastApplied when the policy was last applied to this deployment
 	LastApplied *metav1.Time `json:"lastApplied,omitempty"`
+
+	// WeightAdjustments are the weights the weight tuning controller lowered for rules whose pods
+	// are preempted often, by rule key, in percent of the configured weight
+	WeightAdjustments map[string]int32 `json:"weightAdjustments,omitempty"`
+
+	// Migration reports the progress of the paced migration to a changed strategy
+	Migration *MigrationStatus `json:"migration,omitempty"`
+}
+
+// MigrationStatus reports how far a deployment's pods moved to a changed strategy
+type MigrationStatus struct {
+	// StartedAt when the strategy changed
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// PodsMoved by the rebalancer since the strategy changed
+	PodsMoved int32 `json:"podsMoved"`
+
+	// PodsRemaining above the expected count of their rule, as of the last rebalance check
+	PodsRemaining int32 `json:"podsRemaining"`
 }
 
 // PolicyStatistics provides metrics about policy effectiveness
@@ -247,7 +459,34 @@ func (in *PodPlacementPolicySpec) DeepCopyInto(out *PodPlacementPolicySpec) {
 		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Strategy.DeepCopyInto(&out.Strategy)
+	if in.PriorityTiers != nil {
+		in, out := &in.PriorityTiers, &out.PriorityTiers
+		*out = make([]PriorityTierSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Experiment != nil {
+		in, out := &in.Experiment, &out.Experiment
+		*out = new(PlacementExperimentSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Propagation != nil {
+		in, out := &in.Propagation, &out.Propagation
+		*out = new(PlacementPropagationSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(PlacementMigrationSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicySpec.
@@ -286,6 +525,11 @@ func (in *PodPlacementPolicyStatus) DeepCopyInto(out *PodPlacementPolicyStatus)
 		in, out := &in.LastRebalance, &out.LastRebalance
 		*out = (*in).DeepCopy()
 	}
+	if in.Experiment != nil {
+		in, out := &in.Experiment, &out.Experiment
+		*out = new(ExperimentStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicyStatus.
@@ -298,6 +542,27 @@ func (in *PodPlacementPolicyStatus) DeepCopy() *PodPlacementPolicyStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PriorityTierSpec) DeepCopyInto(out *PriorityTierSpec) {
+	*out = *in
+	if in.PriorityClassNames != nil {
+		in, out := &in.PriorityClassNames, &out.PriorityClassNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Strategy.DeepCopyInto(&out.Strategy)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PriorityTierSpec.
+func (in *PriorityTierSpec) DeepCopy() *PriorityTierSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PriorityTierSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PlacementStrategySpec) DeepCopyInto(out *PlacementStrategySpec) {
 	*out = *in
@@ -342,6 +607,18 @@ func (in *PlacementRuleSpec) DeepCopyInto(out *PlacementRuleSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]TolerationSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeAffinity != nil {
+		in, out := &in.NodeAffinity, &out.NodeAffinity
+		*out = make([]NodeAffinityExpressionSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementRuleSpec.
@@ -354,6 +631,41 @@ func (in *PlacementRuleSpec) DeepCopy() *PlacementRuleSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TolerationSpec) DeepCopyInto(out *TolerationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TolerationSpec.
+func (in *TolerationSpec) DeepCopy() *TolerationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TolerationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeAffinityExpressionSpec) DeepCopyInto(out *NodeAffinityExpressionSpec) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeAffinityExpressionSpec.
+func (in *NodeAffinityExpressionSpec) DeepCopy() *NodeAffinityExpressionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeAffinityExpressionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AffinityRuleSpec) DeepCopyInto(out *AffinityRuleSpec) {
 	*out = *in
@@ -424,6 +736,18 @@ func (in *DeploymentReference) DeepCopyInto(out *DeploymentReference) {
 		in, out := &in.LastApplied, &out.LastApplied
 		*out = (*in).DeepCopy()
 	}
+	if in.WeightAdjustments != nil {
+		in, out := &in.WeightAdjustments, &out.WeightAdjustments
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(MigrationStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentReference.
@@ -454,3 +778,98 @@ func (in *PolicyStatistics) DeepCopy() *PolicyStatistics {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementExperimentSpec) DeepCopyInto(out *PlacementExperimentSpec) {
+	*out = *in
+	in.Strategy.DeepCopyInto(&out.Strategy)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementExperimentSpec.
+func (in *PlacementExperimentSpec) DeepCopy() *PlacementExperimentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementExperimentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementPropagationSpec) DeepCopyInto(out *PlacementPropagationSpec) {
+	*out = *in
+	if in.ExcludeContainers != nil {
+		in, out := &in.ExcludeContainers, &out.ExcludeContainers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementPropagationSpec.
+func (in *PlacementPropagationSpec) DeepCopy() *PlacementPropagationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementPropagationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExperimentStatus) DeepCopyInto(out *ExperimentStatus) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Arms != nil {
+		in, out := &in.Arms, &out.Arms
+		*out = make([]ExperimentArmStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExperimentStatus.
+func (in *ExperimentStatus) DeepCopy() *ExperimentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ExperimentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementMigrationSpec) DeepCopyInto(out *PlacementMigrationSpec) {
+	*out = *in
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementMigrationSpec.
+func (in *PlacementMigrationSpec) DeepCopy() *PlacementMigrationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementMigrationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MigrationStatus) DeepCopyInto(out *MigrationStatus) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MigrationStatus.
+func (in *MigrationStatus) DeepCopy() *MigrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MigrationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
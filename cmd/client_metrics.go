@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	clientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smart_scheduler_client_requests_total",
+			Help: "Kubernetes client calls made by the operator by verb, kind and result. Get and list of cached kinds are served by the informer cache.",
+		},
+		[]string{"verb", "kind", "result"},
+	)
+	clientRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "smart_scheduler_client_request_duration_seconds",
+			Help:    "Latency of Kubernetes client calls made by the operator by verb and kind",
+			Buckets: []float64{0.0001, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"verb", "kind"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(clientRequests, clientRequestDuration)
+}
+
+// observe records one client call
+func (d *debugClient) observe(verb string, obj runtime.Object, start time.Time, err error) {
+	kind := d.kindOf(obj)
+	clientRequestDuration.WithLabelValues(verb, kind).Observe(time.Since(start).Seconds())
+	clientRequests.WithLabelValues(verb, kind, requestResult(err)).Inc()
+}
+
+// kindOf returns the kind of obj, without the List suffix for lists
+func (d *debugClient) kindOf(obj runtime.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, d.Scheme())
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSuffix(gvk.Kind, "List")
+}
+
+// requestResult classifies an error for the result label
+func requestResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case apierrors.IsNotFound(err):
+		return "not_found"
+	case apierrors.IsConflict(err):
+		return "conflict"
+	case apierrors.IsAlreadyExists(err):
+		return "already_exists"
+	case apierrors.IsForbidden(err):
+		return "forbidden"
+	case apierrors.IsTooManyRequests(err):
+		return "throttled"
+	default:
+		return "error"
+	}
+}
+
+// Status returns a writer for the status subresource that records metrics like the other calls
+func (d *debugClient) Status() client.SubResourceWriter {
+	return &statusWriter{SubResourceWriter: d.Client.Status(), client: d}
+}
+
+// statusWriter records metrics for status subresource writes
+type statusWriter struct {
+	client.SubResourceWriter
+	client *debugClient
+}
+
+func (s *statusWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	start := time.Now()
+	err := s.SubResourceWriter.Create(ctx, obj, subResource, opts...)
+	s.client.observe("create_status", obj, start, err)
+	return err
+}
+
+func (s *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	start := time.Now()
+	err := s.SubResourceWriter.Update(ctx, obj, opts...)
+	s.client.observe("update_status", obj, start, err)
+	return err
+}
+
+func (s *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	start := time.Now()
+	err := s.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+	s.client.observe("patch_status", obj, start, err)
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestRequestResult(t *testing.T) {
+	resource := schema.GroupResource{Resource: "configmaps"}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "success"},
+		{apierrors.NewNotFound(resource, "state"), "not_found"},
+		{apierrors.NewConflict(resource, "state", errors.New("modified")), "conflict"},
+		{apierrors.NewAlreadyExists(resource, "state"), "already_exists"},
+		{apierrors.NewForbidden(resource, "state", errors.New("denied")), "forbidden"},
+		{apierrors.NewTooManyRequests("slow down", 1), "throttled"},
+		{errors.New("connection refused"), "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := requestResult(tt.err); got != tt.want {
+				t.Errorf("requestResult(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugClientRecordsMetrics(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	existing := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "default"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default"}}
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(existing, pod).
+		WithStatusSubresource(pod).
+		Build()
+	// Deletes of pods are refused, as a webhook or RBAC might
+	c := &debugClient{Client: interceptor.NewClient(fakeClient, interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if _, ok := obj.(*corev1.Pod); ok {
+				return apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, obj.GetName(), errors.New("denied"))
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		call   func() error
+		verb   string
+		kind   string
+		result string
+	}{
+		{
+			name:   "Get",
+			call:   func() error { return c.Get(ctx, client.ObjectKeyFromObject(existing), &corev1.ConfigMap{}) },
+			verb:   "get",
+			kind:   "ConfigMap",
+			result: "success",
+		},
+		{
+			name: "Get of a missing object",
+			call: func() error {
+				return c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "missing"}, &corev1.ConfigMap{})
+			},
+			verb:   "get",
+			kind:   "ConfigMap",
+			result: "not_found",
+		},
+		{
+			name:   "List is labeled with the item kind",
+			call:   func() error { return c.List(ctx, &corev1.ConfigMapList{}) },
+			verb:   "list",
+			kind:   "ConfigMap",
+			result: "success",
+		},
+		{
+			name: "Create of an existing object",
+			call: func() error {
+				return c.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "default"}})
+			},
+			verb:   "create",
+			kind:   "ConfigMap",
+			result: "already_exists",
+		},
+		{
+			name: "Status update",
+			call: func() error {
+				current := &corev1.Pod{}
+				if err := fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), current); err != nil {
+					return err
+				}
+				current.Status.Phase = corev1.PodRunning
+				return c.Status().Update(ctx, current)
+			},
+			verb:   "update_status",
+			kind:   "Pod",
+			result: "success",
+		},
+		{
+			name:   "Refused delete",
+			call:   func() error { return c.Delete(ctx, pod.DeepCopy()) },
+			verb:   "delete",
+			kind:   "Pod",
+			result: "forbidden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := clientRequests.WithLabelValues(tt.verb, tt.kind, tt.result)
+			before := testutil.ToFloat64(counter)
+
+			_ = tt.call()
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("smart_scheduler_client_requests_total{verb=%q,kind=%q,result=%q} increased by %v, want 1",
+					tt.verb, tt.kind, tt.result, got)
+			}
+		})
+	}
+
+	if kind := c.kindOf(&metav1.Status{}); kind != "unknown" {
+		t.Errorf("kindOf() = %q for a type outside the scheme, want unknown", kind)
+	}
+}
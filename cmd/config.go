@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/kube-smartscheduler/smart-scheduler/controllers"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/config"
+)
+
+// reloadableFlags can change while the manager runs; other changes need a restart
+var reloadableFlags = map[string]bool{
+	"zap-log-level":                     true,
+	"debug-api-requests":                true,
+	"strategy-change-grace-period":      true,
+	"max-evictions-per-strategy-change": true,
+	"max-managed-deployments":           true,
+	"max-evictions-per-hour":            true,
+	"max-policies-per-namespace":        true,
+}
+
+// applyConfigFile sets every flag the configuration sets, overriding the command line.
+// It returns the values the reloadable flags had before, so removing them from the file reverts them.
+func applyConfigFile(fs *flag.FlagSet, cfg *config.SmartSchedulerConfiguration) (map[string]string, error) {
+	commandLine := make(map[string]string)
+	for name := range reloadableFlags {
+		commandLine[name] = fs.Lookup(name).Value.String()
+	}
+
+	for name, value := range cfg.Flags() {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid configuration value for %s: %w", name, err)
+		}
+	}
+	return commandLine, nil
+}
+
+// parseLogLevel parses a --zap-log-level value; empty means the development default, debug
+func parseLogLevel(value string) (zapcore.Level, error) {
+	if value == "" {
+		return zapcore.DebugLevel, nil
+	}
+	levelOpts := zap.Options{}
+	fs := flag.NewFlagSet("zap", flag.ContinueOnError)
+	levelOpts.BindFlags(fs)
+	if err := fs.Set("zap-log-level", value); err != nil {
+		return 0, err
+	}
+	return levelOpts.Level.(uberzap.AtomicLevel).Level(), nil
+}
+
+// configReloader applies the reloadable settings of a changed configuration file
+type configReloader struct {
+	// commandLine holds the reloadable flags' values before the file was applied
+	commandLine map[string]string
+	logLevel    uberzap.AtomicLevel
+	apiClient   *debugClient
+	// rebalancer is nil when the process runs only the webhook
+	rebalancer *controllers.RebalanceController
+	// limits is nil when the process runs only the webhook
+	limits *controllers.ScopeLimits
+}
+
+// apply is the config.Watcher OnChange callback
+func (c *configReloader) apply(old, new *config.SmartSchedulerConfiguration) {
+	values := new.Flags()
+	valueOf := func(name string) string {
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return c.commandLine[name]
+	}
+
+	limitsChanged, scopeLimitsChanged := false, false
+	for _, name := range config.ChangedFlags(old, new) {
+		if !reloadableFlags[name] {
+			setupLog.Info("Configuration change takes effect after a restart", "flag", name)
+			continue
+		}
+
+		switch name {
+		case "zap-log-level":
+			level, err := parseLogLevel(valueOf(name))
+			if err != nil {
+				setupLog.Error(err, "Invalid log level in configuration file")
+				continue
+			}
+			c.logLevel.SetLevel(level)
+		case "debug-api-requests":
+			enabled, err := strconv.ParseBool(valueOf(name))
+			if err != nil {
+				setupLog.Error(err, "Invalid debugAPIRequests in configuration file")
+				continue
+			}
+			c.apiClient.debug.Store(enabled)
+		case "max-managed-deployments", "max-evictions-per-hour", "max-policies-per-namespace":
+			scopeLimitsChanged = true
+		default:
+			limitsChanged = true
+		}
+		setupLog.Info("Applied configuration change", "flag", name, "value", valueOf(name))
+	}
+
+	if limitsChanged && c.rebalancer != nil {
+		gracePeriod, err := time.ParseDuration(valueOf("strategy-change-grace-period"))
+		if err != nil {
+			setupLog.Error(err, "Invalid strategyChangeGracePeriod in configuration file")
+			return
+		}
+		maxEvictions, err := strconv.Atoi(valueOf("max-evictions-per-strategy-change"))
+		if err != nil {
+			setupLog.Error(err, "Invalid maxEvictionsPerStrategyChange in configuration file")
+			return
+		}
+		c.rebalancer.SetStrategyChangeLimits(gracePeriod, maxEvictions)
+	}
+
+	if scopeLimitsChanged && c.limits != nil {
+		var limits [3]int
+		for i, name := range []string{"max-managed-deployments", "max-evictions-per-hour", "max-policies-per-namespace"} {
+			value, err := strconv.Atoi(valueOf(name))
+			if err != nil {
+				setupLog.Error(err, "Invalid scope limit in configuration file", "flag", name)
+				return
+			}
+			limits[i] = value
+		}
+		c.limits.Set(limits[0], limits[1], limits[2])
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// validateBindAddress checks a host:port listener flag. IPv6 hosts must be bracketed, e.g. [::]:8080;
+// an empty host listens on every IPv4 and IPv6 address. "0" disables the listener when allowDisable is set.
+func validateBindAddress(name, address string, allowDisable bool) error {
+	if allowDisable && address == "0" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return fmt.Errorf("invalid --%s %q: IPv6 addresses must be bracketed, e.g. [::]:8080", name, address)
+		}
+		return fmt.Errorf("invalid --%s %q: %w", name, address, err)
+	}
+	if err := validatePort(port); err != nil {
+		return fmt.Errorf("invalid --%s %q: %w", name, address, err)
+	}
+	if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid --%s %q: host must be an IP address or localhost", name, address)
+	}
+	return nil
+}
+
+// parseBindHost returns the host of a host-only listener flag without brackets, so [::1] and ::1
+// are both accepted. An empty host listens on every IPv4 and IPv6 address.
+func parseBindHost(name, host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid --%s %q: host must be an IP address or localhost", name, host)
+	}
+	return host, nil
+}
+
+// validatePort checks a listener port
+func validatePort(port string) error {
+	value, err := strconv.Atoi(port)
+	if err != nil || value < 0 || value > 65535 {
+		return fmt.Errorf("port %q must be a number between 0 and 65535", port)
+	}
+	return nil
+}
+
+// addressFamilies describes which IP families a listener on an unbracketed host accepts
+func addressFamilies(host string) string {
+	if host == "" {
+		return "dual-stack"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "hostname"
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		// Linux also accepts IPv4 on [::] unless net.ipv6.bindv6only is set
+		return "ipv6"
+	}
+}
+
+// listenerFamilies describes which IP families a host:port listener accepts
+func listenerFamilies(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "disabled"
+	}
+	return addressFamilies(host)
+}
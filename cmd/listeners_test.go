@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBindAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		address      string
+		allowDisable bool
+		// wantErr is a substring of the expected error, or "" when the address is valid
+		wantErr string
+	}{
+		{"Disabled", "0", true, ""},
+		{"Disable not allowed", "0", false, "missing port"},
+		{"Every address", ":8080", false, ""},
+		{"IPv4 address", "127.0.0.1:8080", false, ""},
+		{"Localhost", "localhost:8080", false, ""},
+		{"Bracketed IPv6 address", "[::]:9443", false, ""},
+		{"Bracketed IPv6 loopback", "[::1]:9443", false, ""},
+		{"Any port", ":0", false, ""},
+		{"IPv6 address without brackets", "::1:8080", false, "must be bracketed"},
+		{"IPv6 any address without brackets", ":::8080", false, "must be bracketed"},
+		{"Port out of range", ":65536", false, "between 0 and 65535"},
+		{"Port not a number", ":http", false, "between 0 and 65535"},
+		{"Negative port", ":-1", false, "between 0 and 65535"},
+		{"Missing port", "127.0.0.1", false, "missing port"},
+		{"Hostname", "metrics.example.com:8080", false, "must be an IP address or localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBindAddress("metrics-bind-address", tt.address, tt.allowDisable)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBindAddress(%q) error = %v", tt.address, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateBindAddress(%q) error = %v, want one containing %q", tt.address, err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--metrics-bind-address") {
+				t.Errorf("Expected the error to name the flag, got %v", err)
+			}
+		})
+	}
+}
+
+func TestListenerFamilies(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"0", "disabled"},
+		{":8080", "dual-stack"},
+		{"0.0.0.0:8080", "ipv4"},
+		{"127.0.0.1:8080", "ipv4"},
+		{"[::]:9443", "ipv6"},
+		{"[::1]:9443", "ipv6"},
+		{"localhost:8080", "hostname"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := listenerFamilies(tt.address); got != tt.want {
+				t.Errorf("listenerFamilies(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBindHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"0.0.0.0", "0.0.0.0", false},
+		{"::1", "::1", false},
+		{"[::1]", "::1", false},
+		{"localhost", "localhost", false},
+		{"webhook.example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := parseBindHost("webhook-host", tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBindHost(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBindHost(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
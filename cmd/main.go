@@ -2,13 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	uberzap "go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -18,9 +33,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
 
 	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
 	"github.com/kube-smartscheduler/smart-scheduler/controllers"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/config"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/decision"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/features"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/rbac"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/spotscore"
 	"github.com/kube-smartscheduler/smart-scheduler/pkg/version"
 	smartwebhook "github.com/kube-smartscheduler/smart-scheduler/webhook"
 )
@@ -30,22 +51,33 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
-// debugClient wraps a client.Client to log all API requests for debugging
+// debugClient wraps a client.Client to record metrics for every API request and, when enabled,
+// log them for debugging
 type debugClient struct {
 	client.Client
-	debug bool
+	debug atomic.Bool
+}
+
+// stripManagedFields drops managedFields from objects before they are stored in the informer cache
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
 }
 
 func (d *debugClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST GET ===",
 			"objectKind", obj.GetObjectKind(),
 			"key", key,
 			"namespace", key.Namespace,
 			"name", key.Name)
 	}
+	start := time.Now()
 	err := d.Client.Get(ctx, key, obj, opts...)
-	if d.debug {
+	d.observe("get", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE GET ===",
 			"objectKind", obj.GetObjectKind(),
 			"key", key,
@@ -56,12 +88,14 @@ func (d *debugClient) Get(ctx context.Context, key client.ObjectKey, obj client.
 }
 
 func (d *debugClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST LIST ===",
 			"objectKind", list.GetObjectKind())
 	}
+	start := time.Now()
 	err := d.Client.List(ctx, list, opts...)
-	if d.debug {
+	d.observe("list", list, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE LIST ===",
 			"objectKind", list.GetObjectKind(),
 			"error", err)
@@ -70,14 +104,16 @@ func (d *debugClient) List(ctx context.Context, list client.ObjectList, opts ...
 }
 
 func (d *debugClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST CREATE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
 			"name", obj.GetName())
 	}
+	start := time.Now()
 	err := d.Client.Create(ctx, obj, opts...)
-	if d.debug {
+	d.observe("create", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE CREATE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
@@ -88,14 +124,16 @@ func (d *debugClient) Create(ctx context.Context, obj client.Object, opts ...cli
 }
 
 func (d *debugClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST DELETE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
 			"name", obj.GetName())
 	}
+	start := time.Now()
 	err := d.Client.Delete(ctx, obj, opts...)
-	if d.debug {
+	d.observe("delete", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE DELETE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
@@ -106,15 +144,17 @@ func (d *debugClient) Delete(ctx context.Context, obj client.Object, opts ...cli
 }
 
 func (d *debugClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST UPDATE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
 			"name", obj.GetName(),
 			"resourceVersion", obj.GetResourceVersion())
 	}
+	start := time.Now()
 	err := d.Client.Update(ctx, obj, opts...)
-	if d.debug {
+	d.observe("update", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE UPDATE ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
@@ -125,15 +165,17 @@ func (d *debugClient) Update(ctx context.Context, obj client.Object, opts ...cli
 }
 
 func (d *debugClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST PATCH ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
 			"name", obj.GetName(),
 			"patchType", patch.Type())
 	}
+	start := time.Now()
 	err := d.Client.Patch(ctx, obj, patch, opts...)
-	if d.debug {
+	d.observe("patch", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE PATCH ===",
 			"objectKind", obj.GetObjectKind(),
 			"namespace", obj.GetNamespace(),
@@ -144,12 +186,14 @@ func (d *debugClient) Patch(ctx context.Context, obj client.Object, patch client
 }
 
 func (d *debugClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
-	if d.debug {
+	if d.debug.Load() {
 		setupLog.Info("=== API REQUEST DELETE_ALL_OF ===",
 			"objectKind", obj.GetObjectKind())
 	}
+	start := time.Now()
 	err := d.Client.DeleteAllOf(ctx, obj, opts...)
-	if d.debug {
+	d.observe("delete_all_of", obj, start, err)
+	if d.debug.Load() {
 		setupLog.Info("=== API RESPONSE DELETE_ALL_OF ===",
 			"objectKind", obj.GetObjectKind(),
 			"error", err)
@@ -165,25 +209,278 @@ func init() {
 }
 
 func main() {
+	// Subcommands run once against the cluster and exit
+	if len(os.Args) > 1 && os.Args[1] == simulateOutageCommand {
+		os.Exit(simulateOutage(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	var configFile string
+	var mode string
 	var metricsAddr string
 	var enableLeaderElection bool
+	var leaderElectionID string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
+	var enabledControllers string
 	var probeAddr string
+	var decisionAddr string
+	var decisionClientCAFile string
 	var webhookPort int
 	var certDir string
 	var enableDebugAPILogging bool
 	var showVersion bool
 	var watchNamespaces string
+	var watchLabelSelector string
+	var nodeProblemConditions string
+	var avoidScaleDownNodes bool
+	var scaleDownTaints string
+	var slowStartImageSize string
+	var unschedulableTimeout time.Duration
+	var decisionHookURL string
+	var decisionHookTimeout time.Duration
+	var decisionHookCAFile string
+	var podListPageSize int64
+	var schedulerConcurrency int
+	var rebalanceConcurrency int
+	var policyConcurrency int
+	var placementCleanup string
+	var protectedNamespaces string
+	var impersonateServiceAccount string
+	var impersonationQPS float64
+	var impersonationBurst int
+	var maintenanceConcurrency int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var rebalanceDebounce time.Duration
+	var strategyCacheSize int
+	var stateFlushInterval time.Duration
+	var kubeAPIContentType string
+	var cacheSyncPeriod time.Duration
+	var stateFailureThreshold int
+	var stateDegradedCooldown time.Duration
+	var stateCallTimeout time.Duration
+	var strategyChangeGracePeriod time.Duration
+	var maxEvictionsPerStrategyChange int
+	var evictPreBoundPods bool
+	var maxManagedDeployments int
+	var maxEvictionsPerHour int
+	var maxPoliciesPerNamespace int
+	var manualOverrideWindow time.Duration
+	var janitorTTL time.Duration
+	var janitorInterval time.Duration
+	var summaryInterval time.Duration
+	var weightTuningWindow time.Duration
+	var weightTuningInterval time.Duration
+	var weightTuningPreemptionThreshold float64
+	var weightTuningMinWeightPercent int
+	var spotScoreProvider string
+	var spotScoreEndpoint string
+	var spotScoreInterval time.Duration
+	var spotScoreThreshold int
+	var spotScoreMinWeightPercent int
+	var reservationPriorityClass string
+	var reservationImage string
+	var gracefulShutdownTimeout time.Duration
+	var stateWarmupTimeout time.Duration
+	var webhookTLSMinVersion string
+	var webhookTLSCipherSuites string
+	var webhookEnableHTTP2 bool
+	var webhookHTTP2MaxConcurrentStreams uint
+	var webhookServiceName string
+	var webhookFailurePolicy string
+	var webhookHost string
+	var admissionQueueMaxInFlight int
+	var admissionQueueMaxWait time.Duration
+	var admissionQueueHighPriority int
+	var admissionQueueHighPolicyPriority int
+	var printRBAC bool
+	var rbacClusterRole string
 
+	flag.StringVar(&configFile, "config", "",
+		"Path to a SmartSchedulerConfiguration file. Its values override the matching flags, "+
+			"and changes to log level, API request logging and strategy change limits are reloaded while running.")
+	flag.StringVar(&mode, "mode", "all",
+		"Components this process runs: all, webhook (the admission webhook only, without leader election, "+
+			"so it can be scaled horizontally) or controllers (the controllers only).")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&decisionAddr, "decision-grpc-bind-address", "0",
+		"The address the gRPC decision service binds to, e.g. :9090. It answers placement questions for systems outside "+
+			"Kubernetes over TLS with the certificate in --cert-dir. Set to 0 to disable it.")
+	flag.StringVar(&decisionClientCAFile, "decision-grpc-client-ca-file", "",
+		"CA bundle the decision service verifies client certificates against. If empty, callers are not authenticated, "+
+			"so restrict who can reach the service.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "smart-scheduler-leader",
+		"Name of the leader election Lease. Processes running different --controllers need different IDs "+
+			"so each set elects its own leader.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"How long non-leaders wait after the last renewal before trying to take over leadership.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"How long the leader keeps retrying to renew its lease before giving up leadership.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"How long leader election clients wait between attempts to acquire or renew the lease.")
+	flag.StringVar(&enabledControllers, "controllers", "*",
+		"Comma-separated controllers this process runs: scheduler, rebalance, policy, placementaudit, maintenance, reservation, policymigration, webhookconfig, janitor, disruptionbudget, weighttuning, spotscores, summary, "+
+			"or * for all of them. Running rebalance apart from policy, with its own --leader-election-id, "+
+			"keeps heavy rebalancing from delaying policy reconciliation.")
 	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server serves at.")
+	flag.StringVar(&webhookHost, "webhook-host", "",
+		"The address the webhook server binds to, e.g. 0.0.0.0 or ::. If empty, it listens on every IPv4 and IPv6 address.")
 	flag.StringVar(&certDir, "cert-dir", "/tmp/k8s-webhook-server/serving-certs/", "The directory containing the webhook server certificates.")
+	flag.StringVar(&webhookTLSMinVersion, "webhook-tls-min-version", "VersionTLS12",
+		"Minimum TLS version accepted by the webhook server: VersionTLS12 or VersionTLS13.")
+	flag.StringVar(&webhookTLSCipherSuites, "webhook-tls-cipher-suites", "",
+		"Comma-separated TLS 1.2 cipher suites (IANA names) accepted by the webhook server. If empty, the Go defaults are used.")
+	flag.BoolVar(&webhookEnableHTTP2, "webhook-enable-http2", true, "Offer HTTP/2 to the API server on the webhook server.")
+	flag.UintVar(&webhookHTTP2MaxConcurrentStreams, "webhook-http2-max-concurrent-streams", 0,
+		"Maximum concurrent HTTP/2 streams per webhook connection. If 0, the Go default (250) is used.")
+	flag.StringVar(&webhookServiceName, "webhook-service-name", "",
+		"Name of the webhook Service in the operator namespace. With the DecisionOwnership feature gate, its ready endpoints share placement decisions.")
+	flag.StringVar(&webhookFailurePolicy, "webhook-failure-policy", "Ignore",
+		"The failurePolicy the MutatingWebhookConfiguration is expected to have, Fail or Ignore. Checked with the WebhookConfigurationCheck feature gate.")
+	flag.BoolVar(&printRBAC, "print-rbac", false,
+		"Print the minimal ClusterRole for the enabled controllers and feature gates and exit.")
+	flag.StringVar(&rbacClusterRole, "rbac-cluster-role", "",
+		"Name of the operator's ClusterRole. If set, the leader reduces it to the permissions of the enabled controllers and feature gates.")
 	flag.BoolVar(&enableDebugAPILogging, "debug-api-requests", false, "Enable debug logging for all Kubernetes API requests.")
 	flag.BoolVar(&showVersion, "version", false, "Show version information and exit.")
 	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "Comma-separated list of namespaces to watch. If empty, watches all namespaces.")
+	flag.StringVar(&watchLabelSelector, "watch-label-selector", "",
+		"Label selector restricting the cached Deployments, ReplicaSets and Pods. If empty, all of them are cached.")
+	flag.Var(features.DefaultGates, "feature-gates",
+		"Comma-separated Feature=bool pairs enabling or disabling optional features. Known features: "+
+			strings.Join(features.DefaultGates.Known(), ", ")+".")
+	flag.Var(features.BoolFlag(features.DefaultGates, features.ImageArchCheck), "enable-image-arch-check",
+		"Same as --feature-gates=ImageArchCheck=true. Look up image manifests in their registry to keep pods off node architectures their images don't support. "+
+			"Pods with the smart-scheduler.io/image-arch annotation are never looked up.")
+	flag.StringVar(&nodeProblemConditions, "node-problem-conditions", "",
+		"Comma-separated Node Problem Detector condition types that mark a node unhealthy when True. "+
+			"If empty, the built-in list (KernelDeadlock, ReadonlyFilesystem, NTPProblem, ...) is used.")
+	flag.BoolVar(&avoidScaleDownNodes, "avoid-scale-down-nodes", true,
+		"Steer new pods away from nodes that cluster-autoscaler or Karpenter is about to remove.")
+	flag.StringVar(&slowStartImageSize, "slow-start-image-size", "5Gi",
+		"Total image size, init containers included, from which a pod is kept off the pools of rules marked preemptible=true. "+
+			"Sizes come from the smart-scheduler.io/image-size annotation or the images nodes report. If 0, pods are never treated as slow to start.")
+	flag.DurationVar(&unschedulableTimeout, "unschedulable-timeout", smartwebhook.DefaultUnschedulableTimeout,
+		"How long a pod may be reported Unschedulable before new pods of its deployment are placed on the strategy's other rules, "+
+			"and rebalancing is held. If 0, unschedulable pods are not detected.")
+	flag.StringVar(&decisionHookURL, "decision-hook-url", "",
+		"Endpoint every placement is POSTed to before it is finalized, which may veto it or override its rule. "+
+			"Placements are kept when the endpoint fails or is slow. If empty, placements are not reviewed.")
+	flag.DurationVar(&decisionHookTimeout, "decision-hook-timeout", smartwebhook.DefaultDecisionHookTimeout,
+		"How long an admission waits for the decision hook before keeping the placement.")
+	flag.StringVar(&decisionHookCAFile, "decision-hook-ca-file", "",
+		"CA bundle verifying an https decision hook. If empty, the system roots are used.")
+	flag.StringVar(&scaleDownTaints, "scale-down-taints", "",
+		"Comma-separated taint keys that mark a node as scheduled for removal. "+
+			"If empty, the cluster-autoscaler and Karpenter taints (ToBeDeletedByClusterAutoscaler, DeletionCandidateOfClusterAutoscaler, karpenter.sh/disrupted, ...) are used.")
+
+	flag.Int64Var(&podListPageSize, "pod-list-page-size", 0,
+		"When set, placement state refreshes list pods directly from the API server in pages of this size "+
+			"instead of reading the informer cache. If 0, the cache is used.")
+
+	flag.IntVar(&schedulerConcurrency, "scheduler-concurrency", 1, "Maximum concurrent reconciles for the SchedulerController.")
+	flag.IntVar(&rebalanceConcurrency, "rebalance-concurrency", 1, "Maximum concurrent reconciles for the RebalanceController.")
+	flag.IntVar(&policyConcurrency, "policy-concurrency", 2, "Maximum concurrent reconciles for the PodPlacementPolicyController.")
+	flag.Var(features.BoolFlag(features.DefaultGates, features.PolicyPreflight), "policy-preflight",
+		"Same as --feature-gates=PolicyPreflight=true. Check that every PodPlacementPolicy rule's nodeSelector matches at least one node, "+
+			"setting the RuleMatchesNoNodes condition on policies with rules that match none.")
+	flag.Var(features.BoolFlag(features.DefaultGates, features.PlacementAudit), "enable-placement-audit",
+		"Same as --feature-gates=PlacementAudit=true (enabled by default). Report processed pods whose nodeSelector no longer contains the placement rule recorded on them.")
+	flag.Var(features.BoolFlag(features.DefaultGates, features.PlacementAuditRecreate), "placement-audit-recreate",
+		"Same as --feature-gates=PlacementAuditRecreate=true. Delete pods found by the placement audit so their ReplicaSet recreates them, at most one pod per deployment every 10 minutes.")
+	flag.StringVar(&placementCleanup, "placement-cleanup", "none",
+		"What to do when a deployment's schedule strategy is removed while its pods keep the placement it injected: "+
+			"none leaves them until the next rollout, annotate marks the deployment with smart-scheduler.io/placement-stale, "+
+			"rollout restarts the deployment.")
+	flag.StringVar(&protectedNamespaces, "protected-namespaces", "",
+		"Comma-separated namespaces or glob patterns (e.g. team-*-prod) whose pods are never mutated, evicted or restarted. "+
+			"kube-system, kube-public, kube-node-lease and the operator's own namespace are always protected.")
+	flag.StringVar(&impersonateServiceAccount, "impersonate-service-account", "",
+		"Name of a service account that policy application and evictions impersonate in each namespace, "+
+			"so they are limited to that namespace's RBAC. If empty, the operator's own permissions are used.")
+	flag.Float64Var(&impersonationQPS, "impersonation-qps", 5, "QPS limit for impersonated requests to one namespace.")
+	flag.IntVar(&impersonationBurst, "impersonation-burst", 10, "Burst limit for impersonated requests to one namespace.")
+	flag.IntVar(&maintenanceConcurrency, "maintenance-concurrency", 1, "Maximum concurrent reconciles for the MaintenanceWindowController.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 0, "QPS limit for the Kubernetes API client. If 0, the controller-runtime default (20) is used.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 0, "Burst limit for the Kubernetes API client. If 0, the controller-runtime default (30) is used.")
+	flag.DurationVar(&rebalanceDebounce, "rebalance-debounce", 10*time.Second,
+		"How long pod events for a deployment are coalesced before the RebalanceController reconciles it.")
+	flag.DurationVar(&strategyChangeGracePeriod, "strategy-change-grace-period", 5*time.Minute,
+		"How long the RebalanceController waits after a placement strategy edit before evicting pods.")
+	flag.IntVar(&maxEvictionsPerStrategyChange, "max-evictions-per-strategy-change", 0,
+		"Maximum number of pods the RebalanceController evicts to roll out one placement strategy edit. If 0, there is no limit.")
+	flag.BoolVar(&evictPreBoundPods, "evict-pre-bound-pods", false,
+		"Let the RebalanceController evict pods created with spec.nodeName set, which the webhook does not place.")
+	flag.IntVar(&maxManagedDeployments, "max-managed-deployments", 0,
+		"Maximum number of deployments with a placement strategy across the cluster. Policies are not applied to further deployments. If 0, there is no limit.")
+	flag.IntVar(&maxEvictionsPerHour, "max-evictions-per-hour", 0,
+		"Maximum number of pods the RebalanceController evicts and the PlacementAuditController recreates per hour across the cluster. If 0, there is no limit.")
+	flag.IntVar(&maxPoliciesPerNamespace, "max-policies-per-namespace", 0,
+		"Maximum number of PodPlacementPolicies applied per namespace; newer policies beyond it are not applied. If 0, there is no limit.")
+	flag.DurationVar(&manualOverrideWindow, "manual-override-window", 30*time.Minute,
+		"How long rebalancing and placement audit recreations are deferred after a human cordons a node of a deployment's pools "+
+			"or annotates the deployment with smart-scheduler.io/manual-override. If 0, manual interventions are not detected.")
+	flag.DurationVar(&janitorTTL, "janitor-ttl", controllers.DefaultJanitorTTL,
+		"How long the janitor keeps unrefreshed placement state, the decision annotations of deployments whose strategy was removed "+
+			"and the timestamp-named Events of earlier versions. If 0, nothing is pruned.")
+	flag.DurationVar(&janitorInterval, "janitor-interval", controllers.DefaultJanitorInterval,
+		"How often the janitor prunes stale placement state, decision annotations and Events.")
+	flag.DurationVar(&summaryInterval, "summary-interval", controllers.DefaultSummaryInterval,
+		"How often the summary controller refreshes the cluster's PlacementSummary.")
+	flag.DurationVar(&weightTuningWindow, "weight-tuning-window", controllers.DefaultWeightTuningWindow,
+		"How long the preemptions of a rule's pods count against its weight when the WeightTuning feature is enabled.")
+	flag.DurationVar(&weightTuningInterval, "weight-tuning-interval", controllers.DefaultWeightTuningInterval,
+		"How often the weight of a rule is stepped down or back up when the WeightTuning feature is enabled.")
+	flag.Float64Var(&weightTuningPreemptionThreshold, "weight-tuning-preemption-threshold", controllers.DefaultPreemptionThreshold,
+		"Preemptions per pod of a rule within the weight tuning window above which its weight is lowered.")
+	flag.IntVar(&weightTuningMinWeightPercent, "weight-tuning-min-weight-percent", controllers.DefaultMinWeightPercent,
+		"Lowest weight the WeightTuning feature gives a rule, in percent of its configured weight (1-100).")
+	flag.StringVar(&spotScoreProvider, "spot-score-provider", "aws",
+		"Provider of the spot placement scores of the SpotPlacementScores feature: "+strings.Join(spotscore.Providers, ", ")+".")
+	flag.StringVar(&spotScoreEndpoint, "spot-score-endpoint", "",
+		"URL the external spot score provider posts spot pools to.")
+	flag.DurationVar(&spotScoreInterval, "spot-score-interval", controllers.DefaultSpotScoreInterval,
+		"How often the SpotPlacementScores feature scores the pools of spot rules.")
+	flag.IntVar(&spotScoreThreshold, "spot-score-threshold", controllers.DefaultSpotScoreThreshold,
+		"Spot placement score (1-10) below which the SpotPlacementScores feature lowers the weight of a spot rule.")
+	flag.IntVar(&spotScoreMinWeightPercent, "spot-score-min-weight-percent", controllers.DefaultSpotScoreMinWeightPercent,
+		"Weight a spot rule keeps at a spot placement score of 1, in percent of its configured weight (1-100).")
+	flag.StringVar(&reservationPriorityClass, "reservation-priority-class", controllers.DefaultReservationPriorityClass,
+		"PriorityClass of the placeholder pods that reserve capacity for rules with a reserve count. It must rank below every workload.")
+	flag.StringVar(&reservationImage, "reservation-image", controllers.DefaultReservationImage,
+		"Image of the placeholder pods that reserve capacity for rules with a reserve count.")
+	flag.DurationVar(&gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second,
+		"How long the manager waits on shutdown for in-flight admissions, rebalancing and state writes to finish.")
+	flag.DurationVar(&stateWarmupTimeout, "state-warmup-timeout", smartwebhook.DefaultWarmupTimeout,
+		"How long the webhook loads managed deployments' strategies and placement state at startup before reporting ready. If 0, there is no warm-up.")
+	flag.IntVar(&strategyCacheSize, "strategy-cache-size", smartwebhook.DefaultStrategyCacheSize,
+		"Number of parsed placement strategies kept in the shared LRU cache.")
+	flag.StringVar(&kubeAPIContentType, "kube-api-content-type", "protobuf",
+		"Encoding used for built-in Kubernetes types: protobuf or json. Custom resources always use json.")
+	flag.DurationVar(&cacheSyncPeriod, "cache-sync-period", 10*time.Hour,
+		"Minimum interval at which the informer cache resyncs all watched objects.")
+	flag.DurationVar(&stateFlushInterval, "state-flush-interval", 500*time.Millisecond,
+		"How often buffered placement counts are written, one ConfigMap update per deployment. If 0, every admitted pod is written immediately.")
+	flag.IntVar(&stateFailureThreshold, "state-failure-threshold", smartwebhook.DefaultStateFailureThreshold,
+		"Consecutive placement state store failures after which the webhook places pods from informer pod counts.")
+	flag.DurationVar(&stateDegradedCooldown, "state-degraded-cooldown", smartwebhook.DefaultStateDegradedCooldown,
+		"How long the webhook skips the placement state store after it fails, before probing it again.")
+	flag.DurationVar(&stateCallTimeout, "state-call-timeout", smartwebhook.DefaultStateCallTimeout,
+		"Timeout of each placement state store call made during admission.")
+	flag.IntVar(&admissionQueueMaxInFlight, "admission-queue-max-in-flight", 0,
+		"Maximum admissions placed with the placement state store at once. Beyond it, high-priority pods wait for a slot "+
+			"and other pods are placed from informer pod counts. If 0, admissions are not limited.")
+	flag.DurationVar(&admissionQueueMaxWait, "admission-queue-max-wait", smartwebhook.DefaultAdmissionQueueMaxWait,
+		"How long a high-priority admission waits for a slot before it is placed from informer pod counts.")
+	flag.IntVar(&admissionQueueHighPriority, "admission-queue-high-priority", smartwebhook.DefaultHighPriorityThreshold,
+		"Pod priority (from its priorityClassName) from which admissions wait for a slot when the webhook is overloaded.")
+	flag.IntVar(&admissionQueueHighPolicyPriority, "admission-queue-high-policy-priority", smartwebhook.DefaultHighPolicyPriority,
+		"PodPlacementPolicy priority from which admissions of its deployments wait for a slot when the webhook is overloaded.")
 
 	opts := zap.Options{
 		Development: true,
@@ -199,8 +496,32 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The configuration file takes precedence over flags, which act as its defaults
+	var fileConfig *config.SmartSchedulerConfiguration
+	var commandLineValues map[string]string
+	var configErr error
+	if configFile != "" {
+		fileConfig, configErr = config.Load(configFile)
+		if configErr == nil {
+			commandLineValues, configErr = applyConfigFile(flag.CommandLine, fileConfig)
+		}
+	}
+
+	// The level is atomic so a reloaded configuration can change it
+	level, err := parseLogLevel(flag.Lookup("zap-log-level").Value.String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log level: %v\n", err)
+		os.Exit(1)
+	}
+	logLevel := uberzap.NewAtomicLevelAt(level)
+	opts.Level = logLevel
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if configErr != nil {
+		setupLog.Error(configErr, "unable to load configuration file", "path", configFile)
+		os.Exit(1)
+	}
+
 	versionInfo := version.Get()
 	setupLog.Info("Starting Smart Scheduler Manager",
 		"version", versionInfo.Version,
@@ -213,7 +534,10 @@ func main() {
 		"webhookPort", webhookPort,
 		"enableLeaderElection", enableLeaderElection,
 		"enableDebugAPILogging", enableDebugAPILogging,
-		"watchNamespaces", watchNamespaces)
+		"watchNamespaces", watchNamespaces,
+		"watchLabelSelector", watchLabelSelector,
+		"featureGates", features.DefaultGates.String())
+	features.DefaultGates.RecordMetrics()
 
 	// Parse watch namespaces
 	var namespaces []string
@@ -227,19 +551,96 @@ func main() {
 		setupLog.Info("Watching all namespaces")
 	}
 
+	var runWebhook, runControllers bool
+	switch mode {
+	case "all":
+		runWebhook, runControllers = true, true
+	case "webhook":
+		runWebhook = true
+	case "controllers":
+		runControllers = true
+	default:
+		setupLog.Error(fmt.Errorf("unknown mode %q, expected all, webhook or controllers", mode), "invalid --mode")
+		os.Exit(1)
+	}
+	// Every webhook replica serves admissions; only controllers need a leader
+	if !runControllers && enableLeaderElection {
+		setupLog.Info("Leader election is not used in webhook mode")
+		enableLeaderElection = false
+	}
+	controllerSet, err := parseControllers(enabledControllers)
+	if err != nil {
+		setupLog.Error(err, "invalid --controllers")
+		os.Exit(1)
+	}
+	if renewDeadline >= leaseDuration || retryPeriod >= renewDeadline {
+		setupLog.Error(fmt.Errorf("expected retry period %s < renew deadline %s < lease duration %s", retryPeriod, renewDeadline, leaseDuration),
+			"invalid leader election timing")
+		os.Exit(1)
+	}
+	setupLog.Info("Running components", "mode", mode, "webhook", runWebhook, "controllers", runControllers, "controllerSet", enabledControllers)
+
+	// Listeners accept bracketed IPv6 addresses; an empty host listens on both IP families
+	if err := validateBindAddress("metrics-bind-address", metricsAddr, true); err != nil {
+		setupLog.Error(err, "invalid listener address")
+		os.Exit(1)
+	}
+	if err := validateBindAddress("health-probe-bind-address", probeAddr, true); err != nil {
+		setupLog.Error(err, "invalid listener address")
+		os.Exit(1)
+	}
+	if err := validateBindAddress("decision-grpc-bind-address", decisionAddr, true); err != nil {
+		setupLog.Error(err, "invalid listener address")
+		os.Exit(1)
+	}
+	webhookHost, err = parseBindHost("webhook-host", webhookHost)
+	if err != nil {
+		setupLog.Error(err, "invalid listener address")
+		os.Exit(1)
+	}
+	if err := validatePort(strconv.Itoa(webhookPort)); err != nil {
+		setupLog.Error(fmt.Errorf("invalid --webhook-port: %w", err), "invalid listener address")
+		os.Exit(1)
+	}
+	setupLog.Info("Configured listeners",
+		"metrics", metricsAddr, "metricsFamilies", listenerFamilies(metricsAddr),
+		"probes", probeAddr, "probeFamilies", listenerFamilies(probeAddr),
+		"decisions", decisionAddr, "decisionFamilies", listenerFamilies(decisionAddr),
+		"webhook", net.JoinHostPort(webhookHost, strconv.Itoa(webhookPort)), "webhookFamilies", addressFamilies(webhookHost))
+
 	// Configure manager options
 	managerOpts := ctrl.Options{
 		Scheme: scheme,
-		WebhookServer: webhook.NewServer(webhook.Options{
-			Port:    webhookPort,
-			CertDir: certDir,
-		}),
+		Cache: cache.Options{
+			SyncPeriod: &cacheSyncPeriod,
+			// Managed fields are never read but make up a large share of every cached object
+			DefaultTransform: stripManagedFields,
+		},
 		Metrics: server.Options{
 			BindAddress: metricsAddr,
 		},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "smart-scheduler-leader",
+		LeaderElectionID:       leaderElectionID,
+		LeaseDuration:          &leaseDuration,
+		RenewDeadline:          &renewDeadline,
+		RetryPeriod:            &retryPeriod,
+		// Leaves time to drain admissions and flush state; the pod's terminationGracePeriodSeconds must be longer
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
+	}
+
+	if runWebhook {
+		tlsOpts, err := smartwebhook.ParseServerTLSOptions(webhookTLSMinVersion, webhookTLSCipherSuites,
+			webhookEnableHTTP2, uint32(webhookHTTP2MaxConcurrentStreams))
+		if err != nil {
+			setupLog.Error(err, "invalid webhook TLS options")
+			os.Exit(1)
+		}
+		managerOpts.WebhookServer = smartwebhook.NewServer(webhook.Options{
+			Host:    webhookHost,
+			Port:    webhookPort,
+			CertDir: certDir,
+		}, tlsOpts, ctrl.Log.WithName("webhook").WithName("Server"))
 	}
 
 	// Set up namespace scoping if specific namespaces are requested
@@ -261,61 +662,644 @@ func main() {
 		}
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), managerOpts)
+	// Restrict the workload cache to labelled objects. Pods and ReplicaSets inherit the labels of the
+	// Deployment's pod template, so the selector should match template labels as well.
+	// Nodes and Smart Scheduler resources are always cached in full.
+	var scopedLabelKeys []string
+	if watchLabelSelector != "" {
+		selector, err := labels.Parse(watchLabelSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid watch label selector", "selector", watchLabelSelector)
+			os.Exit(1)
+		}
+		managerOpts.Cache.ByObject = map[client.Object]cache.ByObject{
+			&corev1.Pod{}:        {Label: selector},
+			&appsv1.ReplicaSet{}: {Label: selector},
+			&appsv1.Deployment{}: {Label: selector},
+		}
+		setupLog.Info("Configured label-scoped cache", "selector", selector.String())
+		requirements, _ := selector.Requirements()
+		for _, requirement := range requirements {
+			scopedLabelKeys = append(scopedLabelKeys, requirement.Key())
+		}
+	}
+
+	cleanupMode, err := controllers.ParsePlacementCleanupMode(placementCleanup)
+	if err != nil {
+		setupLog.Error(err, "invalid --placement-cleanup")
+		os.Exit(1)
+	}
+
+	// The operator only needs the permissions of what is enabled
+	permissions := rbac.Rules(rbac.Components{
+		Controllers:               controllerSet,
+		Gates:                     features.DefaultGates,
+		PlacementCleanup:          cleanupMode != controllers.PlacementCleanupNone,
+		ManualOverrides:           manualOverrideWindow > 0,
+		Janitor:                   janitorTTL > 0,
+		ImpersonateServiceAccount: impersonateServiceAccount,
+		ClusterRoleName:           rbacClusterRole,
+	})
+	if printRBAC {
+		name := rbacClusterRole
+		if name == "" {
+			name = "smart-scheduler-manager-role"
+		}
+		data, err := yaml.Marshal(rbac.ClusterRole(name, permissions))
+		if err != nil {
+			setupLog.Error(err, "unable to generate ClusterRole")
+			os.Exit(1)
+		}
+		fmt.Print(string(data))
+		os.Exit(0)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	if kubeAPIQPS > 0 {
+		restConfig.QPS = float32(kubeAPIQPS)
+	}
+	if kubeAPIBurst > 0 {
+		restConfig.Burst = kubeAPIBurst
+	}
+	// With no content type set, controller-runtime uses protobuf for built-in types and json for
+	// custom resources. Watches always request bookmarks, so informers resume without relisting.
+	switch kubeAPIContentType {
+	case "protobuf":
+	case "json":
+		restConfig.ContentType = runtime.ContentTypeJSON
+	default:
+		setupLog.Error(fmt.Errorf("unsupported content type %q", kubeAPIContentType), "invalid --kube-api-content-type")
+		os.Exit(1)
+	}
+	// Every client made from the config, including impersonated tenant clients, backs off together
+	// while API Priority and Fairness throttles the operator
+	restConfig.Wrap(smartwebhook.Throttle.WrapTransport)
+	setupLog.Info("Configured Kubernetes API client",
+		"qps", restConfig.QPS,
+		"burst", restConfig.Burst,
+		"contentType", kubeAPIContentType,
+		"cacheSyncPeriod", cacheSyncPeriod)
+
+	namespaceGuard, err := smartwebhook.NewNamespaceGuard(smartwebhook.OperatorNamespace(), strings.Split(protectedNamespaces, ","))
+	if err != nil {
+		setupLog.Error(err, "invalid --protected-namespaces")
+		os.Exit(1)
+	}
+	setupLog.Info("Configured protected namespaces",
+		"system", smartwebhook.SystemNamespaces,
+		"operatorNamespace", smartwebhook.OperatorNamespace(),
+		"denyList", protectedNamespaces)
+
+	mgr, err := ctrl.NewManager(restConfig, managerOpts)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	// Wrap client with debug logging if enabled
-	var debugClientWrapper client.Client = mgr.GetClient()
+	smartwebhook.SetStrategyCacheSize(strategyCacheSize)
+
+	// Register field indexes for owner lookups before any controller starts the cache
+	if err = smartwebhook.SetupIndexers(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		setupLog.Error(err, "unable to set up field indexers")
+		os.Exit(1)
+	}
+
+	// Every smartscheduler.io CRD is optional. Controllers of kinds that are not installed are not
+	// started, so users who only want annotation-driven placement need not install the CRDs.
+	var installedAPIs *smartwebhook.InstalledAPIs
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err == nil {
+		installedAPIs, err = smartwebhook.DetectInstalledAPIs(discoveryClient)
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to detect installed CRDs, assuming all of them are installed")
+	}
+	disableUninstalledControllers(controllerSet, installedAPIs)
+	if installedAPIs.AnnotationOnly() {
+		setupLog.Info("Running in annotation-only mode: the PodPlacementPolicy CRD is not installed, so strategies "+
+			"come from schedule-strategy annotations only. Install the CRDs and restart to use policies.",
+			"missingCRDs", installedAPIs.Missing())
+	}
+
+	// Wrap client with debug logging, which the configuration file can toggle while running
+	apiClient := &debugClient{Client: mgr.GetClient()}
+	apiClient.debug.Store(enableDebugAPILogging)
+	var debugClientWrapper client.Client = apiClient
 	if enableDebugAPILogging {
 		setupLog.Info("Debug API logging enabled - will log all Kubernetes API requests")
-		debugClientWrapper = &debugClient{
-			Client: mgr.GetClient(),
-			debug:  true,
+	}
+
+	// The webhook and the rebalancer skip MaintenanceWindows when their CRD is not installed
+	maintenance := smartwebhook.NewMaintenanceTracker(debugClientWrapper, ctrl.Log.WithName("Maintenance"))
+	maintenance.APIs = installedAPIs
+
+	// Registry lookups are shared by the webhook and the rebalancer so both see the same platform
+	var imageInspector smartwebhook.ImageInspector
+	if features.DefaultGates.Enabled(features.ImageArchCheck) {
+		setupLog.Info("Image architecture check enabled - will query registries for image manifests")
+		imageInspector = smartwebhook.NewRegistryInspector(5*time.Second, 30*time.Minute)
+	}
+
+	// Pool health is shared by the webhook and the rebalancer so both agree on which nodes are unhealthy
+	poolHealth := smartwebhook.NewPoolHealthChecker(debugClientWrapper, ctrl.Log.WithName("PoolHealth"))
+	if nodeProblemConditions != "" {
+		poolHealth.ProblemConditions = nil
+		for _, condition := range strings.Split(nodeProblemConditions, ",") {
+			if condition = strings.TrimSpace(condition); condition != "" {
+				poolHealth.ProblemConditions = append(poolHealth.ProblemConditions, corev1.NodeConditionType(condition))
+			}
 		}
 	}
+	setupLog.Info("Configured node problem conditions", "conditions", poolHealth.ProblemConditions)
 
-	// Setup controllers
-	if err = (&controllers.SchedulerController{
-		Client: debugClientWrapper,
-		Scheme: mgr.GetScheme(),
-		Log:    ctrl.Log.WithName("controllers").WithName("SchedulerController"),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "SchedulerController")
+	// Nodes an autoscaler is about to remove are avoided by the webhook and left out of bin-packing
+	var scaleDown *smartwebhook.ScaleDownTracker
+	if avoidScaleDownNodes {
+		scaleDown = smartwebhook.NewScaleDownTracker(debugClientWrapper, ctrl.Log.WithName("ScaleDown"))
+		if scaleDownTaints != "" {
+			scaleDown.Taints = nil
+			for _, taint := range strings.Split(scaleDownTaints, ",") {
+				if taint = strings.TrimSpace(taint); taint != "" {
+					scaleDown.Taints = append(scaleDown.Taints, taint)
+				}
+			}
+		}
+		setupLog.Info("Configured scale-down taints", "taints", scaleDown.Taints)
+	}
+
+	// Pods with large images are kept off preemptible rules by the webhook and the rebalancer alike
+	slowStartThreshold, err := resource.ParseQuantity(slowStartImageSize)
+	if err != nil {
+		setupLog.Error(err, "invalid --slow-start-image-size")
 		os.Exit(1)
 	}
+	var slowStart *smartwebhook.SlowStartDetector
+	if slowStartThreshold.Value() > 0 {
+		slowStart = smartwebhook.NewSlowStartDetector(debugClientWrapper, slowStartThreshold.Value(), ctrl.Log.WithName("SlowStart"))
+		setupLog.Info("Slow-starting pods avoid preemptible rules", "imageSize", slowStartThreshold.String())
+	}
+
+	// Rules whose pods cannot be scheduled are avoided by the webhook and not rebalanced onto
+	var unschedulable *smartwebhook.UnschedulableSpill
+	if unschedulableTimeout > 0 {
+		unschedulable = smartwebhook.NewUnschedulableSpill(unschedulableTimeout, ctrl.Log.WithName("Unschedulable"))
+		setupLog.Info("Pods spill away from rules with unschedulable pods", "timeout", unschedulableTimeout.String())
+	}
+
+	// A single StateManager is shared by the webhook and the controllers
+	stateManager := smartwebhook.NewStateManager(debugClientWrapper, ctrl.Log.WithName("StateManager"))
+	if podListPageSize > 0 {
+		stateManager.APIReader = mgr.GetAPIReader()
+		stateManager.PodListPageSize = podListPageSize
+		setupLog.Info("Placement state refreshes will page pods from the API server", "pageSize", podListPageSize)
+	}
+	if stateFlushInterval > 0 {
+		stateManager.FlushInterval = stateFlushInterval
+		setupLog.Info("Placement state writes will be batched", "flushInterval", stateFlushInterval)
+	}
+	// Each deployment's counters are kept in the memory of the replica holding its Lease
+	if runWebhook && features.DefaultGates.Enabled(features.CounterLeases) {
+		podName := os.Getenv("POD_NAME")
+		if podName == "" {
+			setupLog.Error(errors.New("the POD_NAME environment variable is required"), "unable to set up counter Leases")
+			os.Exit(1)
+		}
+		stateManager.Counters = smartwebhook.NewCounterLeases(debugClientWrapper, mgr.GetAPIReader(), podName,
+			ctrl.Log.WithName("StateManager").WithName("CounterLeases"))
+		setupLog.Info("Placement counters will be held by Lease", "identity", podName,
+			"leaseDuration", stateManager.Counters.LeaseDuration, "resyncInterval", stateManager.Counters.ResyncInterval)
+	}
+	if stateManager.FlushInterval > 0 || stateManager.Counters != nil {
+		if err := mgr.Add(stateManager); err != nil {
+			setupLog.Error(err, "unable to add placement state flusher")
+			os.Exit(1)
+		}
+	}
 
 	// Setup webhook
-	podMutator := &smartwebhook.PodMutator{
-		Client: debugClientWrapper,
-		Log:    ctrl.Log.WithName("webhook").WithName("PodMutator"),
+	var drainer *smartwebhook.AdmissionDrainer
+	var warmer *smartwebhook.StateWarmer
+	if runWebhook {
+		// On shutdown, stop being ready for admissions and wait for the ones in flight
+		drainer = smartwebhook.NewAdmissionDrainer(gracefulShutdownTimeout, ctrl.Log.WithName("webhook").WithName("AdmissionDrainer"))
+		if err := mgr.Add(drainer); err != nil {
+			setupLog.Error(err, "unable to add admission drainer")
+			os.Exit(1)
+		}
+		stateManager.Drainer = drainer
+
+		// Under overload, high-priority pods wait for the state store and the others degrade
+		var admissionQueue *smartwebhook.AdmissionQueue
+		if admissionQueueMaxInFlight > 0 {
+			admissionQueue = smartwebhook.NewAdmissionQueue(admissionQueueMaxInFlight, admissionQueueMaxWait,
+				ctrl.Log.WithName("webhook").WithName("AdmissionQueue"))
+			admissionQueue.HighPriority = int32(admissionQueueHighPriority)
+			admissionQueue.HighPolicyPriority = int32(admissionQueueHighPolicyPriority)
+			setupLog.Info("Admission queueing enabled", "maxInFlight", admissionQueueMaxInFlight, "maxWait", admissionQueue.MaxWait,
+				"highPriority", admissionQueueHighPriority, "highPolicyPriority", admissionQueueHighPolicyPriority)
+		}
+
+		// Load every managed deployment's strategy and state before the first admissions
+		if stateWarmupTimeout > 0 {
+			warmer = smartwebhook.NewStateWarmer(debugClientWrapper, stateManager, stateWarmupTimeout,
+				ctrl.Log.WithName("webhook").WithName("StateWarmer"))
+			if err := mgr.Add(warmer); err != nil {
+				setupLog.Error(err, "unable to add placement state warm-up")
+				os.Exit(1)
+			}
+		}
+
+		// Each deployment's placements are decided by one replica, the others forward its admissions
+		var forwarder *smartwebhook.DecisionForwarder
+		if features.DefaultGates.Enabled(features.DecisionOwnership) {
+			podName := os.Getenv("POD_NAME")
+			if webhookServiceName == "" || podName == "" {
+				setupLog.Error(errors.New("--webhook-service-name and the POD_NAME environment variable are required"),
+					"unable to set up decision ownership")
+				os.Exit(1)
+			}
+			ring := smartwebhook.NewDecisionRing(mgr.GetAPIReader(), smartwebhook.OperatorNamespace(), webhookServiceName, podName,
+				ctrl.Log.WithName("webhook").WithName("DecisionRing"))
+			if err := mgr.Add(ring); err != nil {
+				setupLog.Error(err, "unable to add decision ring")
+				os.Exit(1)
+			}
+			serverName := webhookServiceName + "." + smartwebhook.OperatorNamespace() + ".svc"
+			forwarder, err = smartwebhook.NewDecisionForwarder(ring, webhookPort, filepath.Join(certDir, "ca.crt"), serverName,
+				smartwebhook.DefaultForwardTimeout, ctrl.Log.WithName("webhook").WithName("DecisionForwarder"))
+			if err != nil {
+				setupLog.Error(err, "unable to set up decision ownership")
+				os.Exit(1)
+			}
+			setupLog.Info("Placement decisions are owned per deployment by webhook replicas", "service", webhookServiceName, "self", podName)
+		}
+
+		// Rules with a packing mode rank their pool's nodes by a cached utilization model
+		var packing *smartwebhook.NodeUtilization
+		if features.DefaultGates.Enabled(features.BinPacking) {
+			packing = smartwebhook.NewNodeUtilization(debugClientWrapper, ctrl.Log.WithName("webhook").WithName("NodeUtilization"))
+			packing.ScaleDown = scaleDown
+			packing.Sidecars = smartwebhook.NewSidecarTracker()
+			setupLog.Info("Bin-packing enabled for rules with a packing mode", "refreshInterval", packing.RefreshInterval)
+		}
+
+		// Placements can be vetoed or overridden by an external endpoint
+		var decisionHook *smartwebhook.DecisionHook
+		if decisionHookURL != "" {
+			decisionHook, err = smartwebhook.NewDecisionHook(decisionHookURL, decisionHookCAFile, decisionHookTimeout,
+				ctrl.Log.WithName("webhook").WithName("DecisionHook"))
+			if err != nil {
+				setupLog.Error(err, "unable to set up decision hook")
+				os.Exit(1)
+			}
+			setupLog.Info("Placements are reviewed by a decision hook", "url", decisionHookURL, "timeout", decisionHookTimeout.String())
+		}
+
+		podMutator := &smartwebhook.PodMutator{
+			Client:         debugClientWrapper,
+			Log:            ctrl.Log.WithName("webhook").WithName("PodMutator"),
+			StateManager:   stateManager,
+			PoolHealth:     poolHealth,
+			Maintenance:    maintenance,
+			ImageInspector: imageInspector,
+			StateBreaker: smartwebhook.NewStateCircuitBreaker(stateFailureThreshold, stateDegradedCooldown, stateCallTimeout,
+				ctrl.Log.WithName("webhook").WithName("StateBreaker")),
+			Namespaces:     namespaceGuard,
+			Drainer:        drainer,
+			Forwarder:      forwarder,
+			Packing:        packing,
+			ScaleDown:      scaleDown,
+			SlowStart:      slowStart,
+			Experiments:    features.DefaultGates.Enabled(features.PlacementExperiments),
+			Forecast:       features.DefaultGates.Enabled(features.ReplicaForecast),
+			BaseProtection: features.DefaultGates.Enabled(features.BaseProtection),
+			WeightTuning:   features.DefaultGates.Enabled(features.WeightTuning),
+			SpotScores:     features.DefaultGates.Enabled(features.SpotPlacementScores),
+			StatefulSets:   features.DefaultGates.Enabled(features.StatefulSetPlacement),
+			Queue:          admissionQueue,
+			Unschedulable:  unschedulable,
+			DecisionHook:   decisionHook,
+			APIs:           installedAPIs,
+		}
+
+		if err = podMutator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to setup webhook", "webhook", "PodMutator")
+			os.Exit(1)
+		}
 	}
 
-	if err = podMutator.SetupWebhookWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to setup webhook", "webhook", "PodMutator")
-		os.Exit(1)
+	// Setup controllers
+	var rebalancer *controllers.RebalanceController
+	var scopeLimits *controllers.ScopeLimits
+	if runControllers {
+		// Limits are shared so evictions of every controller count against one hourly budget
+		scopeLimits = controllers.NewScopeLimits(maxManagedDeployments, maxEvictionsPerHour, maxPoliciesPerNamespace)
+
+		var tenants *controllers.TenantClients
+		if impersonateServiceAccount != "" {
+			tenants = controllers.NewTenantClients(restConfig, mgr.GetScheme(), mgr.GetRESTMapper(),
+				impersonateServiceAccount, float32(impersonationQPS), impersonationBurst)
+			setupLog.Info("Impersonating a service account per namespace for policy application and evictions",
+				"serviceAccount", impersonateServiceAccount, "qps", impersonationQPS, "burst", impersonationBurst)
+		}
+
+		// Rebalancing and recreations wait for humans moving pods by hand
+		var manualOverrides *controllers.ManualOverrideTracker
+		if manualOverrideWindow > 0 {
+			manualOverrides = controllers.NewManualOverrideTracker(debugClientWrapper, mgr.GetAPIReader(), manualOverrideWindow,
+				ctrl.Log.WithName("controllers").WithName("ManualOverrides"))
+		}
+
+		// Setup SchedulerController
+		if controllerSet["scheduler"] {
+			if err = (&controllers.SchedulerController{
+				Client:                  debugClientWrapper,
+				Scheme:                  mgr.GetScheme(),
+				Log:                     ctrl.Log.WithName("controllers").WithName("SchedulerController"),
+				MaxConcurrentReconciles: schedulerConcurrency,
+				CleanupMode:             cleanupMode,
+				Namespaces:              namespaceGuard,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "SchedulerController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup RebalanceController
+		if controllerSet["rebalance"] {
+			rebalancer = &controllers.RebalanceController{
+				Client:                        debugClientWrapper,
+				Log:                           ctrl.Log.WithName("controllers").WithName("RebalanceController"),
+				Scheme:                        mgr.GetScheme(),
+				StateManager:                  stateManager,
+				PoolHealth:                    poolHealth,
+				Maintenance:                   maintenance,
+				ImageInspector:                imageInspector,
+				MaxConcurrentReconciles:       rebalanceConcurrency,
+				DebounceWindow:                rebalanceDebounce,
+				StrategyChangeGracePeriod:     strategyChangeGracePeriod,
+				MaxEvictionsPerStrategyChange: maxEvictionsPerStrategyChange,
+				Namespaces:                    namespaceGuard,
+				Tenants:                       tenants,
+				ManualOverrides:               manualOverrides,
+				SlowStart:                     slowStart,
+				ScaleDownHints:                features.DefaultGates.Enabled(features.ScaleDownHints),
+				Unschedulable:                 unschedulable,
+				Limits:                        scopeLimits,
+				EvictPreBound:                 evictPreBoundPods,
+				WeightTuning:                  features.DefaultGates.Enabled(features.WeightTuning),
+				SpotScores:                    features.DefaultGates.Enabled(features.SpotPlacementScores),
+				StatefulSets:                  features.DefaultGates.Enabled(features.StatefulSetPlacement),
+			}
+			if err = rebalancer.SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "RebalanceController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup PodPlacementPolicyController
+		if controllerSet["policy"] {
+			if err = (&controllers.PodPlacementPolicyController{
+				Client:                  debugClientWrapper,
+				Log:                     ctrl.Log.WithName("controllers").WithName("PodPlacementPolicyController"),
+				Scheme:                  mgr.GetScheme(),
+				StateManager:            stateManager,
+				MaxConcurrentReconciles: policyConcurrency,
+				Preflight:               features.DefaultGates.Enabled(features.PolicyPreflight),
+				Namespaces:              namespaceGuard,
+				Tenants:                 tenants,
+				Experiments:             features.DefaultGates.Enabled(features.PlacementExperiments),
+				Limits:                  scopeLimits,
+				RuleRekey:               features.DefaultGates.Enabled(features.RuleRekey),
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "PodPlacementPolicyController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup PlacementAuditController
+		if controllerSet["placementaudit"] && features.DefaultGates.Enabled(features.PlacementAudit) {
+			if err = (&controllers.PlacementAuditController{
+				Client:          debugClientWrapper,
+				Log:             ctrl.Log.WithName("controllers").WithName("PlacementAuditController"),
+				Scheme:          mgr.GetScheme(),
+				Recreate:        features.DefaultGates.Enabled(features.PlacementAuditRecreate),
+				BindAudit:       features.DefaultGates.Enabled(features.PlacementBindAudit),
+				Namespaces:      namespaceGuard,
+				Tenants:         tenants,
+				ManualOverrides: manualOverrides,
+				Limits:          scopeLimits,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "PlacementAuditController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup MaintenanceWindowController
+		if controllerSet["maintenance"] {
+			if err = (&controllers.MaintenanceWindowController{
+				Client:                  debugClientWrapper,
+				Log:                     ctrl.Log.WithName("controllers").WithName("MaintenanceWindowController"),
+				Scheme:                  mgr.GetScheme(),
+				MaxConcurrentReconciles: maintenanceConcurrency,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "MaintenanceWindowController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup ReservationController
+		if controllerSet["reservation"] && features.DefaultGates.Enabled(features.CapacityReservation) {
+			if err = (&controllers.ReservationController{
+				Client:            debugClientWrapper,
+				Log:               ctrl.Log.WithName("controllers").WithName("ReservationController"),
+				Scheme:            mgr.GetScheme(),
+				PriorityClassName: reservationPriorityClass,
+				Image:             reservationImage,
+				InheritLabels:     scopedLabelKeys,
+				Namespaces:        namespaceGuard,
+				Tenants:           tenants,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "ReservationController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup PolicyMigrationController
+		if controllerSet["policymigration"] && features.DefaultGates.Enabled(features.PolicyMigration) {
+			if err = (&controllers.PolicyMigrationController{
+				Client:     debugClientWrapper,
+				Log:        ctrl.Log.WithName("controllers").WithName("PolicyMigrationController"),
+				Scheme:     mgr.GetScheme(),
+				Namespaces: namespaceGuard,
+				Tenants:    tenants,
+				Limits:     scopeLimits,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "PolicyMigrationController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup WebhookConfigurationController
+		if controllerSet["webhookconfig"] && features.DefaultGates.Enabled(features.WebhookConfigurationCheck) {
+			failurePolicy := admissionregistrationv1.FailurePolicyType(webhookFailurePolicy)
+			if webhookServiceName == "" || failurePolicy != admissionregistrationv1.Fail && failurePolicy != admissionregistrationv1.Ignore {
+				setupLog.Error(errors.New("--webhook-service-name and a --webhook-failure-policy of Fail or Ignore are required"),
+					"unable to create controller", "controller", "WebhookConfigurationController")
+				os.Exit(1)
+			}
+			if err = (&controllers.WebhookConfigurationController{
+				Client:          debugClientWrapper,
+				Reader:          mgr.GetAPIReader(),
+				Log:             ctrl.Log.WithName("controllers").WithName("WebhookConfigurationController"),
+				Scheme:          mgr.GetScheme(),
+				Namespace:       smartwebhook.OperatorNamespace(),
+				ServiceName:     webhookServiceName,
+				FailurePolicy:   failurePolicy,
+				ServingCertFile: filepath.Join(certDir, "tls.crt"),
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "WebhookConfigurationController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup Janitor
+		if controllerSet["janitor"] && janitorTTL > 0 {
+			if err = (&controllers.Janitor{
+				Client:       debugClientWrapper,
+				Reader:       mgr.GetAPIReader(),
+				Log:          ctrl.Log.WithName("controllers").WithName("Janitor"),
+				Scheme:       mgr.GetScheme(),
+				TTL:          janitorTTL,
+				Interval:     janitorInterval,
+				StatefulSets: features.DefaultGates.Enabled(features.StatefulSetPlacement),
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "Janitor")
+				os.Exit(1)
+			}
+		}
+
+		// Setup WeightTuningController
+		if controllerSet["weighttuning"] && features.DefaultGates.Enabled(features.WeightTuning) {
+			if err = (&controllers.WeightTuningController{
+				Client:              debugClientWrapper,
+				Log:                 ctrl.Log.WithName("controllers").WithName("WeightTuningController"),
+				Scheme:              mgr.GetScheme(),
+				Window:              weightTuningWindow,
+				Interval:            weightTuningInterval,
+				PreemptionThreshold: weightTuningPreemptionThreshold,
+				MinWeightPercent:    weightTuningMinWeightPercent,
+				Namespaces:          namespaceGuard,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "WeightTuningController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup SpotScoreController
+		if controllerSet["spotscores"] && features.DefaultGates.Enabled(features.SpotPlacementScores) {
+			provider, err := spotscore.New(spotScoreProvider, spotscore.Options{Endpoint: spotScoreEndpoint})
+			if err != nil {
+				setupLog.Error(err, "unable to create spot score provider")
+				os.Exit(1)
+			}
+			if err = (&controllers.SpotScoreController{
+				Client:           debugClientWrapper,
+				Log:              ctrl.Log.WithName("controllers").WithName("SpotScoreController"),
+				Scheme:           mgr.GetScheme(),
+				Provider:         provider,
+				ProviderName:     spotScoreProvider,
+				Interval:         spotScoreInterval,
+				Threshold:        spotScoreThreshold,
+				MinWeightPercent: spotScoreMinWeightPercent,
+				Namespaces:       namespaceGuard,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "SpotScoreController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup PlacementSummaryController
+		if controllerSet["summary"] {
+			if err = (&controllers.PlacementSummaryController{
+				Client:       debugClientWrapper,
+				Log:          ctrl.Log.WithName("controllers").WithName("PlacementSummaryController"),
+				Scheme:       mgr.GetScheme(),
+				PoolHealth:   poolHealth,
+				Interval:     summaryInterval,
+				Namespaces:   namespaceGuard,
+				StatefulSets: features.DefaultGates.Enabled(features.StatefulSetPlacement),
+				WeightTuning: features.DefaultGates.Enabled(features.WeightTuning),
+				SpotScores:   features.DefaultGates.Enabled(features.SpotPlacementScores),
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "PlacementSummaryController")
+				os.Exit(1)
+			}
+		}
+
+		// Setup RuleDisruptionBudgetController
+		if controllerSet["disruptionbudget"] {
+			if err = (&controllers.RuleDisruptionBudgetController{
+				Client:     debugClientWrapper,
+				Log:        ctrl.Log.WithName("controllers").WithName("RuleDisruptionBudgetController"),
+				Scheme:     mgr.GetScheme(),
+				Namespaces: namespaceGuard,
+				Tenants:    tenants,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "RuleDisruptionBudgetController")
+				os.Exit(1)
+			}
+		}
 	}
 
-	// Setup RebalanceController
-	if err = (&controllers.RebalanceController{
-		Client: debugClientWrapper,
-		Log:    ctrl.Log.WithName("controllers").WithName("RebalanceController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "RebalanceController")
-		os.Exit(1)
+	// Drop permissions of disabled controllers and features from the operator's ClusterRole
+	if runControllers && rbacClusterRole != "" {
+		if err := mgr.Add(&rbac.Reconciler{
+			Reader: mgr.GetAPIReader(),
+			Client: debugClientWrapper,
+			Name:   rbacClusterRole,
+			Rules:  permissions,
+			Log:    ctrl.Log.WithName("rbac"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add ClusterRole reconciler")
+			os.Exit(1)
+		}
+		setupLog.Info("Reconciling the operator ClusterRole to the permissions of enabled features",
+			"clusterRole", rbacClusterRole, "rules", len(permissions))
 	}
 
-	// Setup PodPlacementPolicyController
-	if err = (&controllers.PodPlacementPolicyController{
-		Client: debugClientWrapper,
-		Log:    ctrl.Log.WithName("controllers").WithName("PodPlacementPolicyController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "PodPlacementPolicyController")
-		os.Exit(1)
+	// Answer placement questions from outside the cluster over gRPC
+	if decisionAddr != "0" {
+		if err := mgr.Add(&decision.Server{
+			Engine: &decision.Engine{Client: debugClientWrapper, PoolHealth: poolHealth, Unschedulable: unschedulable,
+				Forecast: features.DefaultGates.Enabled(features.ReplicaForecast)},
+			BindAddress:  decisionAddr,
+			CertDir:      certDir,
+			ClientCAFile: decisionClientCAFile,
+			Log:          ctrl.Log.WithName("decision"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add decision service")
+			os.Exit(1)
+		}
+		setupLog.Info("Decision service enabled", "address", decisionAddr)
+	}
+
+	// Reload the configuration file when it changes
+	if fileConfig != nil {
+		reloader := &configReloader{
+			commandLine: commandLineValues,
+			logLevel:    logLevel,
+			apiClient:   apiClient,
+			rebalancer:  rebalancer,
+			limits:      scopeLimits,
+		}
+		watcher := config.NewWatcher(configFile, fileConfig, ctrl.Log.WithName("config"))
+		watcher.OnChange = reloader.apply
+		if err := mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to add configuration watcher")
+			os.Exit(1)
+		}
+		setupLog.Info("Loaded configuration file", "path", configFile, "reloadInterval", watcher.Interval)
 	}
 
 	// Add health checks
@@ -327,6 +1311,23 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	// Webhook replicas only receive admissions once their server is listening
+	if runWebhook {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("admissions", drainer.Checker); err != nil {
+			setupLog.Error(err, "unable to set up admission drain check")
+			os.Exit(1)
+		}
+		if warmer != nil {
+			if err := mgr.AddReadyzCheck("state-warmup", warmer.Checker); err != nil {
+				setupLog.Error(err, "unable to set up state warm-up check")
+				os.Exit(1)
+			}
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -334,3 +1335,44 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// knownControllers are the controllers --controllers can select
+var knownControllers = []string{"scheduler", "rebalance", "policy", "placementaudit", "maintenance", "reservation", "policymigration", "webhookconfig", "janitor", "disruptionbudget", "weighttuning", "spotscores", "summary"}
+
+// controllerKinds maps the controllers reconciling a smartscheduler.io kind to that kind
+var controllerKinds = map[string]string{
+	"policy":          smartwebhook.KindPodPlacementPolicy,
+	"policymigration": smartwebhook.KindPodPlacementPolicy,
+	"maintenance":     smartwebhook.KindMaintenanceWindow,
+	"summary":         smartwebhook.KindPlacementSummary,
+}
+
+// disableUninstalledControllers removes the controllers whose kind's CRD is not installed from the set
+func disableUninstalledControllers(controllerSet map[string]bool, apis *smartwebhook.InstalledAPIs) {
+	for _, name := range knownControllers {
+		if kind, ok := controllerKinds[name]; ok && controllerSet[name] && !apis.Installed(kind) {
+			delete(controllerSet, name)
+			setupLog.Info("CRD is not installed, not starting its controller", "controller", name, "kind", kind)
+		}
+	}
+}
+
+// parseControllers returns the set of controllers named by a --controllers value
+func parseControllers(value string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "*":
+			for _, known := range knownControllers {
+				set[known] = true
+			}
+		case slices.Contains(knownControllers, name):
+			set[name] = true
+		default:
+			return nil, fmt.Errorf("unknown controller %q, expected one of %s", name, strings.Join(knownControllers, ", "))
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/decision"
+	smartwebhook "github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// simulateOutageCommand is the subcommand reporting the impact of a zone outage
+const simulateOutageCommand = "simulate-outage"
+
+// simulateOutage runs `manager simulate-outage`: it reads the cluster once, removes the zone's
+// nodes from the model and prints the managed deployments that would lose pods or breach their
+// base guarantee. It returns the process exit code: 1 on errors and, with --fail-on-breach, 2 when
+// a base guarantee would be breached.
+func simulateOutage(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(simulateOutageCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var req decision.SimulateOutageRequest
+	var kubeconfig, output string
+	var failOnBreach bool
+	var timeout time.Duration
+	flags.StringVar(&req.Zone, "zone", "", "The zone whose nodes are removed, e.g. us-west-2a.")
+	flags.StringVar(&req.ZoneLabel, "zone-label", "", "The node label holding the zone. If empty, topology.kubernetes.io/zone is used.")
+	flags.StringVar(&req.Namespace, "namespace", "", "Only simulate the deployments of this namespace. If empty, all namespaces are simulated.")
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. If empty, the in-cluster configuration or $KUBECONFIG is used.")
+	flags.StringVar(&output, "output", "table", "Output format: table or json.")
+	flags.BoolVar(&failOnBreach, "fail-on-breach", false, "Exit with status 2 when a base guarantee would be breached.")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "How long to wait for the cluster to be read.")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if req.Zone == "" || output != "table" && output != "json" {
+		fmt.Fprintln(stderr, "--zone is required and --output must be table or json")
+		return 1
+	}
+
+	var restConfig *rest.Config
+	var err error
+	if kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restConfig, err = ctrl.GetConfig()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to load the cluster configuration: %v\n", err)
+		return 1
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to create a client: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	engine := &decision.Engine{Client: c, PoolHealth: smartwebhook.NewPoolHealthChecker(c, ctrl.Log.WithName("pool-health"))}
+	resp, err := engine.SimulateOutage(ctx, &req)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to simulate the outage: %v\n", err)
+		return 1
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(resp); err != nil {
+			fmt.Fprintf(stderr, "unable to write the report: %v\n", err)
+			return 1
+		}
+	} else {
+		printOutageReport(stdout, resp)
+	}
+
+	if failOnBreach && resp.Breaches > 0 {
+		return 2
+	}
+	return 0
+}
+
+// printOutageReport writes the impact of the outage as a table
+func printOutageReport(w io.Writer, resp *decision.SimulateOutageResponse) {
+	fmt.Fprintf(w, "Outage of zone %s removes %d nodes: %d managed deployments affected, %d breach their base guarantee\n",
+		resp.Zone, resp.RemovedNodes, len(resp.Deployments), resp.Breaches)
+	if len(resp.Deployments) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NAMESPACE\tDEPLOYMENT\tLOST PODS\tBASE\tBASE PODS LEFT\tBASE NODES LEFT\tBREACH")
+	for _, impact := range resp.Deployments {
+		base, basePodsLeft, baseNodesLeft := "-", "-", "-"
+		if impact.Base > 0 {
+			base = fmt.Sprint(impact.Base)
+			basePodsLeft = fmt.Sprintf("%d/%d", impact.BasePodsLeft, impact.BasePods)
+			baseNodesLeft = fmt.Sprint(impact.BaseRuleNodesLeft)
+		}
+		breach := impact.Breach
+		if breach == "" {
+			breach = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			impact.Namespace, impact.Deployment, impact.LostPods, base, basePodsLeft, baseNodesLeft, breach)
+	}
+	table.Flush()
+}
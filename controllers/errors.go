@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+var reconcileErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_reconcile_errors_total",
+		Help: "Reconcile errors by controller and error class (strategy_invalid, state_conflict, pool_unhealthy, pdb_blocked, throttled or unknown)",
+	},
+	[]string{"controller", "reason"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(reconcileErrors)
+}
+
+// resultForError counts err and maps its class to a reconcile result: classes with a retry delay,
+// and requests the API server throttled, requeue after it, ErrStrategyInvalid waits for the object to change, and anything else is
+// returned so the controller retries with backoff
+func resultForError(controller string, err error, log logr.Logger) (ctrl.Result, error) {
+	reason := webhook.ErrorReason(err)
+	reconcileErrors.WithLabelValues(controller, reason).Inc()
+
+	after, retry := webhook.RetryAfter(err)
+	switch {
+	case !retry:
+		log.Info("Not retrying until the object changes", "reason", reason, "error", err.Error())
+		return ctrl.Result{}, nil
+	case after > 0:
+		log.Info("Retrying later", "reason", reason, "retryAfter", after.String(), "error", err.Error())
+		return ctrl.Result{RequeueAfter: after}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// testPodTemplateHash is the pod-template-hash of the ReplicaSets built by testWorkload
+const testPodTemplateHash = "abc12"
+
+// builderIndexer registers the webhook's field indexes on a fake client builder
+type builderIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (b builderIndexer) IndexField(_ context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	b.builder.WithIndex(obj, field, extract)
+	return nil
+}
+
+// newFakeClient returns a fake client holding the objects, with the SmartScheduler types, their
+// status subresources and the webhook's field indexes
+func newFakeClient(t *testing.T, objects ...client.Object) client.WithWatch {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := smartschedulerv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	builder := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&smartschedulerv1.PodPlacementPolicy{}, &smartschedulerv1.MaintenanceWindow{}, &smartschedulerv1.PlacementSummary{})
+	if err := webhook.SetupIndexers(context.Background(), builderIndexer{builder}); err != nil {
+		t.Fatal(err)
+	}
+	return builder.Build()
+}
+
+// testWorkload returns a deployment with the annotations, its ReplicaSet and a running pod on each
+// of the node types
+func testWorkload(name string, annotations map[string]string, nodeTypes ...string) (*appsv1.Deployment, []client.Object) {
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(name + "-uid"),
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: "nginx:1.25"}}},
+			},
+		},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-" + testPodTemplateHash,
+			Namespace: deployment.Namespace,
+			UID:       types.UID(name + "-rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: int32(len(nodeTypes))},
+	}
+
+	objects := []client.Object{deployment, rs}
+	for i, nodeType := range nodeTypes {
+		objects = append(objects, testPod(rs, i, nodeType))
+	}
+	return deployment, objects
+}
+
+// testPod returns the i-th running pod of the ReplicaSet, placed on the node type
+func testPod(rs *appsv1.ReplicaSet, i int, nodeType string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", rs.Name, i),
+			Namespace: rs.Namespace,
+			UID:       types.UID(fmt.Sprintf("%s-pod-uid-%d", rs.Name, i)),
+			Labels: map[string]string{
+				"app":                                  rs.OwnerReferences[0].Name,
+				appsv1.DefaultDeploymentUniqueLabelKey: testPodTemplateHash,
+			},
+			Annotations: map[string]string{"smart-scheduler.io/placement-rule": "node-type=" + nodeType},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"node-type": nodeType},
+			Containers:   []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// DefaultJanitorTTL is how long placement state, decision annotations and legacy Events are
+	// kept once nothing refreshes them
+	DefaultJanitorTTL = 7 * 24 * time.Hour
+	// DefaultJanitorInterval is how often the janitor sweeps the cluster
+	DefaultJanitorInterval = time.Hour
+	// janitorEventPageSize is how many Events are read per list call
+	janitorEventPageSize = 500
+)
+
+// Kinds of pruned objects, as named in metrics
+const (
+	prunedPlacementState = "placement_state"
+	prunedAnnotations    = "annotations"
+	prunedEvents         = "events"
+)
+
+// legacyEventName matches the Events earlier versions of the rebalancer named after the current
+// Unix second, e.g. smart-scheduler-1700000000
+var legacyEventName = regexp.MustCompile(`^smart-scheduler-[0-9]+$`)
+
+var janitorPruned = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_janitor_pruned_total",
+		Help: "Objects the janitor removed, by kind: placement_state (ConfigMaps), annotations (deployments cleaned) or events",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(janitorPruned)
+}
+
+// Janitor keeps what the operator writes from piling up in etcd on long-lived clusters. Every
+// Interval it deletes placement state ConfigMaps not updated within TTL whose deployment is gone or
+// no longer has a strategy, removes the rebalancer's decision annotations from deployments whose
+// strategy was removed once they are older than TTL, and deletes the timestamp-named Events of
+// earlier versions older than TTL. Managed deployments are left alone.
+type Janitor struct {
+	client.Client
+	// Reader lists Events uncached and page by page, so they are not all held in memory
+	Reader client.Reader
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// TTL is how long unrefreshed objects are kept (default: DefaultJanitorTTL)
+	TTL time.Duration
+	// Interval is how often the cluster is swept (default: DefaultJanitorInterval)
+	Interval time.Duration
+	// StatefulSets prunes the placement state of StatefulSets too
+	StatefulSets bool
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=list;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=list;delete
+
+// Reconcile sweeps the cluster once. A failing kind does not keep the others from being pruned.
+func (r *Janitor) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cutoff := time.Now().Add(-r.TTL)
+	err := errors.Join(
+		r.prunePlacementStates(ctx, cutoff),
+		r.pruneDecisionAnnotations(ctx, cutoff),
+		r.pruneLegacyEvents(ctx, cutoff),
+	)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.Interval}, nil
+}
+
+// prunePlacementStates deletes placement state ConfigMaps older than the cutoff whose deployment,
+// or StatefulSet, is gone or has no strategy
+func (r *Janitor) prunePlacementStates(ctx context.Context, cutoff time.Time) error {
+	configMaps := &corev1.ConfigMapList{}
+	err := r.List(ctx, configMaps, client.MatchingLabels{
+		"app.kubernetes.io/name":      "smart-scheduler",
+		"app.kubernetes.io/component": "placement-state",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list placement state ConfigMaps: %w", err)
+	}
+
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		updated, err := time.Parse(time.RFC3339, configMap.Data["last-updated"])
+		if err != nil {
+			updated = configMap.CreationTimestamp.Time
+		}
+		kind := webhook.WorkloadDeployment
+		deploymentName, ok := configMap.Labels["smart-scheduler.io/deployment"]
+		if name, statefulSet := configMap.Labels[webhook.StatefulSetStateLabel]; statefulSet && r.StatefulSets {
+			kind, deploymentName, ok = webhook.WorkloadStatefulSet, name, true
+		}
+		if !ok || updated.After(cutoff) {
+			continue
+		}
+
+		workload, err := webhook.GetWorkload(ctx, r, kind, types.NamespacedName{Namespace: configMap.Namespace, Name: deploymentName})
+		if err == nil && webhook.HasScheduleStrategy(workload.Annotations) {
+			continue
+		} else if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get %s %s/%s: %w", strings.ToLower(kind), configMap.Namespace, deploymentName, err)
+		}
+
+		if err := r.Delete(ctx, configMap); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete placement state ConfigMap %s/%s: %w", configMap.Namespace, configMap.Name, err)
+		}
+		r.Log.Info("Pruned placement state", "configMap", configMap.Name, "namespace", configMap.Namespace, "lastUpdated", updated)
+		janitorPruned.WithLabelValues(prunedPlacementState).Inc()
+	}
+	return nil
+}
+
+// pruneDecisionAnnotations removes the strategy rollout and manual-override annotations the
+// rebalancer left on deployments whose strategy was removed, once they are older than the cutoff.
+// The observed strategy goes with them, so a strategy added again later is a first observation
+// and starts no rollout.
+func (r *Janitor) pruneDecisionAnnotations(ctx context.Context, cutoff time.Time) error {
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments); err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		if webhook.HasScheduleStrategy(deployment.Annotations) {
+			continue
+		}
+		stale := staleDecisionAnnotations(deployment.Annotations, cutoff)
+		if len(stale) == 0 {
+			continue
+		}
+
+		patch := client.MergeFrom(deployment.DeepCopy())
+		for _, annotation := range stale {
+			delete(deployment.Annotations, annotation)
+		}
+		if err := r.Patch(ctx, deployment, patch); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to prune annotations of deployment %s/%s: %w", deployment.Namespace, deployment.Name, err)
+		}
+		r.Log.Info("Pruned decision annotations", "deployment", deployment.Name, "namespace", deployment.Namespace, "annotations", stale)
+		janitorPruned.WithLabelValues(prunedAnnotations).Inc()
+	}
+	return nil
+}
+
+// staleDecisionAnnotations returns the decision annotations older than the cutoff. The rollout
+// annotations earlier versions left are dated by their start, or are stale when no rollout is active.
+func staleDecisionAnnotations(annotations map[string]string, cutoff time.Time) []string {
+	var stale []string
+	if _, observed := annotations[rolloutStrategyAnnotation]; observed {
+		if rollout := parseStrategyRollout(annotations); !rollout.Active() || rollout.Started.Before(cutoff) {
+			for _, annotation := range []string{rolloutStrategyAnnotation, rolloutStartedAnnotation, rolloutEvictionsAnnotation, rolloutRemainingAnnotation} {
+				if _, ok := annotations[annotation]; ok {
+					stale = append(stale, annotation)
+				}
+			}
+		}
+	}
+	if value, ok := annotations[manualOverrideAnnotation]; ok {
+		if started, err := time.Parse(time.RFC3339, value); err == nil && started.Before(cutoff) {
+			stale = append(stale, manualOverrideAnnotation)
+		}
+	}
+	return stale
+}
+
+// pruneLegacyEvents deletes the rebalancer's timestamp-named Events last seen before the cutoff.
+// Events created since are named after their deployment and expire with the API server's event TTL.
+func (r *Janitor) pruneLegacyEvents(ctx context.Context, cutoff time.Time) error {
+	continueToken := ""
+	for {
+		events := &corev1.EventList{}
+		if err := r.Reader.List(ctx, events, client.Limit(janitorEventPageSize), client.Continue(continueToken)); err != nil {
+			return fmt.Errorf("failed to list events: %w", err)
+		}
+
+		for i := range events.Items {
+			e := &events.Items[i]
+			if !legacyEventName.MatchString(e.Name) || e.Source.Component != "smart-scheduler-rebalancer" {
+				continue
+			}
+			lastSeen := e.LastTimestamp.Time
+			if lastSeen.IsZero() {
+				lastSeen = e.CreationTimestamp.Time
+			}
+			if lastSeen.After(cutoff) {
+				continue
+			}
+			if err := r.Delete(ctx, e); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete event %s/%s: %w", e.Namespace, e.Name, err)
+			}
+			janitorPruned.WithLabelValues(prunedEvents).Inc()
+		}
+
+		continueToken = events.Continue
+		if continueToken == "" {
+			return nil
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. Its one request is queued at start
+// and requeued every Interval.
+func (r *Janitor) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Reader == nil {
+		r.Reader = mgr.GetAPIReader()
+	}
+	if r.TTL <= 0 {
+		r.TTL = DefaultJanitorTTL
+	}
+	if r.Interval <= 0 {
+		r.Interval = DefaultJanitorInterval
+	}
+
+	start := make(chan event.GenericEvent, 1)
+	start <- event.GenericEvent{Object: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "janitor"}}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("janitor").
+		WatchesRawSource(&source.Channel{Source: start}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+func TestJanitorReconcile(t *testing.T) {
+	now := time.Now()
+	stale := now.Add(-8 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	fresh := now.Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	state := func(name string, labels map[string]string, lastUpdated string) *corev1.ConfigMap {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{
+				"app.kubernetes.io/name":      "smart-scheduler",
+				"app.kubernetes.io/component": "placement-state",
+			}},
+			Data: map[string]string{"last-updated": lastUpdated},
+		}
+		for key, value := range labels {
+			configMap.Labels[key] = value
+		}
+		return configMap
+	}
+	deployment := func(name string, annotations map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}}
+	}
+	event := func(name, component string, lastSeen time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:    metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Source:        corev1.EventSource{Component: component},
+			LastTimestamp: metav1.NewTime(lastSeen),
+		}
+	}
+	deploymentLabel := func(name string) map[string]string {
+		return map[string]string{"smart-scheduler.io/deployment": name}
+	}
+
+	objects := []client.Object{
+		deployment("web", map[string]string{
+			webhook.ScheduleStrategyAnnotation: "weight=1,nodeSelector=node-type:spot",
+			rolloutStrategyAnnotation:          "weight=1,nodeSelector=node-type:ondemand",
+			manualOverrideAnnotation:           stale,
+		}),
+		// The strategy of plain was removed long ago, the one of recent within the TTL
+		deployment("plain", map[string]string{
+			rolloutStrategyAnnotation: "weight=1,nodeSelector=node-type:spot",
+			manualOverrideAnnotation:  stale,
+		}),
+		deployment("recent", map[string]string{
+			rolloutStrategyAnnotation: "weight=1,nodeSelector=node-type:spot",
+			rolloutStartedAnnotation:  fresh,
+			manualOverrideAnnotation:  fresh,
+		}),
+
+		state("smart-scheduler-web", deploymentLabel("web"), stale),
+		state("smart-scheduler-plain", deploymentLabel("plain"), stale),
+		state("smart-scheduler-gone", deploymentLabel("gone"), stale),
+		state("smart-scheduler-gone-recently", deploymentLabel("gone-recently"), fresh),
+		state("smart-scheduler-sts-db", map[string]string{webhook.StatefulSetStateLabel: "db"}, stale),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}},
+
+		event("smart-scheduler-1700000000", "smart-scheduler-rebalancer", now.Add(-8*24*time.Hour)),
+		event("smart-scheduler-1700000001", "smart-scheduler-rebalancer", now.Add(-9*24*time.Hour)),
+		event("smart-scheduler-1800000000", "smart-scheduler-rebalancer", now.Add(-time.Hour)),
+		event("smart-scheduler-1700000002", "kubelet", now.Add(-8*24*time.Hour)),
+		event("web.17a2b3c4d5e6f7a8", "smart-scheduler-rebalancer", now.Add(-8*24*time.Hour)),
+	}
+	c := newFakeClient(t, objects...)
+
+	// The fake client does not paginate, so the reader serves pages of two events by name, continuing
+	// after the last name served as the API server does, which deletions do not shift
+	var tokens []string
+	reader := interceptor.NewClient(c, interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			listOpts := &client.ListOptions{}
+			listOpts.ApplyOptions(opts)
+			if listOpts.Limit != janitorEventPageSize {
+				t.Errorf("Events listed with limit %d, want %d", listOpts.Limit, janitorEventPageSize)
+			}
+			tokens = append(tokens, listOpts.Continue)
+			events := list.(*corev1.EventList)
+			if err := c.List(ctx, events); err != nil {
+				return err
+			}
+			all := events.Items
+			sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+			offset := sort.Search(len(all), func(i int) bool { return all[i].Name > listOpts.Continue })
+			end := min(offset+2, len(all))
+			events.Items = all[offset:end]
+			if end < len(all) {
+				events.Continue = all[end-1].Name
+			}
+			return nil
+		},
+	})
+
+	r := &Janitor{Client: c, Reader: reader, Log: logr.Discard(), TTL: DefaultJanitorTTL, Interval: DefaultJanitorInterval, StatefulSets: true}
+	ctx := context.Background()
+	result, err := r.Reconcile(ctx, ctrl.Request{})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter != DefaultJanitorInterval {
+		t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, DefaultJanitorInterval)
+	}
+	if want := []string{"", "smart-scheduler-1700000001", "smart-scheduler-1800000000"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("Events listed with continue tokens %q, want %q", tokens, want)
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := c.List(ctx, configMaps); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, configMap := range configMaps.Items {
+		kept = append(kept, configMap.Name)
+	}
+	sort.Strings(kept)
+	if want := []string{"smart-scheduler-gone-recently", "smart-scheduler-web", "unrelated"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("ConfigMaps kept = %v, want %v", kept, want)
+	}
+
+	events := &corev1.EventList{}
+	if err := c.List(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	kept = nil
+	for _, event := range events.Items {
+		kept = append(kept, event.Name)
+	}
+	sort.Strings(kept)
+	if want := []string{"smart-scheduler-1700000002", "smart-scheduler-1800000000", "web.17a2b3c4d5e6f7a8"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("Events kept = %v, want %v", kept, want)
+	}
+
+	annotations := map[string][]string{
+		"web":    {manualOverrideAnnotation, rolloutStrategyAnnotation, webhook.ScheduleStrategyAnnotation},
+		"plain":  nil,
+		"recent": {manualOverrideAnnotation, rolloutStrategyAnnotation, rolloutStartedAnnotation},
+	}
+	for name, want := range annotations {
+		current := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, current); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for annotation := range current.Annotations {
+			got = append(got, annotation)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Annotations of %s = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// MaintenanceWindowController keeps the status of MaintenanceWindow objects up to date
+type MaintenanceWindowController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// MaxConcurrentReconciles limits parallel reconciles (default: 1)
+	MaxConcurrentReconciles int
+}
+
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=maintenancewindows,verbs=get;list;watch
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=maintenancewindows/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// Reconcile refreshes a window's status and requeues at its next start or end transition
+func (r *MaintenanceWindowController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("maintenancewindow", req.Name)
+
+	window := &smartschedulerv1.MaintenanceWindow{}
+	if err := r.Get(ctx, req.NamespacedName, window); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("MaintenanceWindow not found, likely deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get MaintenanceWindow")
+		return ctrl.Result{}, err
+	}
+
+	if !window.Spec.EndTime.After(window.Spec.StartTime.Time) {
+		log.Info("MaintenanceWindow ends before it starts, ignoring",
+			"startTime", window.Spec.StartTime, "endTime", window.Spec.EndTime)
+		return ctrl.Result{}, nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	err := r.List(ctx, nodeList, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(window.Spec.NodeSelector),
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	now := metav1.Now()
+	active := window.IsActive(now)
+
+	if active != window.Status.Active || int32(len(nodeList.Items)) != window.Status.MatchedNodes {
+		window.Status.Active = active
+		window.Status.MatchedNodes = int32(len(nodeList.Items))
+		window.Status.LastUpdated = &now
+
+		if err := r.Status().Update(ctx, window); err != nil {
+			log.Error(err, "Failed to update MaintenanceWindow status")
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Updated MaintenanceWindow status",
+			"active", active,
+			"matchedNodes", window.Status.MatchedNodes,
+			"reason", window.Spec.Reason)
+	}
+
+	// Requeue at the next transition, checking matched nodes periodically while active
+	switch {
+	case now.Before(&window.Spec.StartTime):
+		return ctrl.Result{RequeueAfter: window.Spec.StartTime.Sub(now.Time)}, nil
+	case active:
+		untilEnd := window.Spec.EndTime.Sub(now.Time)
+		if untilEnd > time.Minute*5 {
+			untilEnd = time.Minute * 5
+		}
+		return ctrl.Result{RequeueAfter: untilEnd}, nil
+	default:
+		return ctrl.Result{}, nil
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *MaintenanceWindowController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&smartschedulerv1.MaintenanceWindow{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
+		Complete(r)
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// manualOverrideAnnotation marks a deployment whose pods a human moved by hand. Any value starts
+	// the human-override window; the controllers replace it with the time the window started.
+	manualOverrideAnnotation = "smart-scheduler.io/manual-override"
+	// nodeCordonedReason is the reason of the event the kubelet records when its node is cordoned
+	nodeCordonedReason = "NodeNotSchedulable"
+	// cordonRefreshInterval is how long listed cordon events are reused before listing them again
+	cordonRefreshInterval = 30 * time.Second
+)
+
+var manualOverrideHolds = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_manual_override_holds_total",
+		Help: "Automated corrections deferred by the human-override window, by controller and source (annotation or cordon)",
+	},
+	[]string{"controller", "source"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(manualOverrideHolds)
+}
+
+// ManualOverrideTracker detects manual placement changes and defers automated correction for a
+// human-override window afterwards, so the controllers do not fight an operator who is cordoning,
+// draining or moving pods by hand. A deployment is held when it carries the manual-override
+// annotation or when a node of one of its rules was cordoned within the window.
+type ManualOverrideTracker struct {
+	// Client reads nodes and records the window start on annotated deployments
+	Client client.Client
+	// Reader lists node events without caching every event in the cluster
+	Reader client.Reader
+	// Window is how long automated correction is deferred after an intervention; 0 disables it
+	Window time.Duration
+	Log    logr.Logger
+
+	mu sync.Mutex
+	// cordons holds when each node was last cordoned, listed at most every cordonRefreshInterval
+	cordons  map[string]time.Time
+	listedAt time.Time
+}
+
+// NewManualOverrideTracker creates a tracker deferring correction for window after an intervention
+func NewManualOverrideTracker(client client.Client, reader client.Reader, window time.Duration, log logr.Logger) *ManualOverrideTracker {
+	return &ManualOverrideTracker{
+		Client: client,
+		Reader: reader,
+		Window: window,
+		Log:    log,
+	}
+}
+
+// Hold returns how long automated correction of the deployment must still wait and what started
+// the window. selectors are the nodeSelectors of the pools the correction would touch.
+func (t *ManualOverrideTracker) Hold(ctx context.Context, controller string, deployment *appsv1.Deployment, selectors []map[string]string) (time.Duration, string, error) {
+	if t == nil || t.Window <= 0 {
+		return 0, "", nil
+	}
+
+	wait, err := t.annotationHold(ctx, deployment)
+	if err != nil {
+		return 0, "", err
+	}
+	if wait > 0 {
+		manualOverrideHolds.WithLabelValues(controller, "annotation").Inc()
+		return wait, "deployment annotated with " + manualOverrideAnnotation, nil
+	}
+
+	wait, node, err := t.cordonHold(ctx, selectors)
+	if err != nil {
+		return 0, "", err
+	}
+	if wait > 0 {
+		manualOverrideHolds.WithLabelValues(controller, "cordon").Inc()
+		return wait, fmt.Sprintf("node %s was cordoned", node), nil
+	}
+	return 0, "", nil
+}
+
+// annotationHold returns the remaining window of the deployment's manual-override annotation. An
+// annotation without a timestamp starts the window now; an expired one is removed.
+func (t *ManualOverrideTracker) annotationHold(ctx context.Context, deployment *appsv1.Deployment) (time.Duration, error) {
+	value, ok := deployment.Annotations[manualOverrideAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	started, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		started = time.Now()
+		if err := t.patchOverride(ctx, deployment, started.UTC().Format(time.RFC3339)); err != nil {
+			return 0, err
+		}
+		t.Log.Info("Manual override started, deferring automated correction",
+			"deployment", deployment.Name, "namespace", deployment.Namespace, "window", t.Window.String())
+	}
+
+	wait := time.Until(started.Add(t.Window))
+	if wait <= 0 {
+		t.Log.Info("Manual override window ended, resuming automated correction",
+			"deployment", deployment.Name, "namespace", deployment.Namespace)
+		return 0, t.patchOverride(ctx, deployment, "")
+	}
+	return wait, nil
+}
+
+// patchOverride sets the deployment's manual-override annotation, removing it when value is empty
+func (t *ManualOverrideTracker) patchOverride(ctx context.Context, deployment *appsv1.Deployment, value string) error {
+	patch := client.MergeFrom(deployment.DeepCopy())
+	if value == "" {
+		delete(deployment.Annotations, manualOverrideAnnotation)
+	} else {
+		deployment.Annotations[manualOverrideAnnotation] = value
+	}
+	if err := webhook.PatchWorkload(ctx, t.Client, deployment, patch); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to record manual override on deployment: %w", err)
+	}
+	return nil
+}
+
+// cordonHold returns the remaining window of the latest cordon of a node matching any selector
+func (t *ManualOverrideTracker) cordonHold(ctx context.Context, selectors []map[string]string) (time.Duration, string, error) {
+	cordons, err := t.recentCordons(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var longest time.Duration
+	var longestNode string
+	for nodeName, cordonedAt := range cordons {
+		wait := time.Until(cordonedAt.Add(t.Window))
+		if wait <= longest {
+			continue
+		}
+		node := &corev1.Node{}
+		if err := t.Client.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, "", err
+		}
+		if !matchesAnySelector(node.Labels, selectors) {
+			continue
+		}
+		longest, longestNode = wait, nodeName
+	}
+	return longest, longestNode, nil
+}
+
+// recentCordons returns the nodes cordoned within the window, by the time of their latest cordon
+func (t *ManualOverrideTracker) recentCordons(ctx context.Context) (map[string]time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.listedAt) < cordonRefreshInterval {
+		return t.cordons, nil
+	}
+
+	events := &corev1.EventList{}
+	err := t.Reader.List(ctx, events, client.MatchingFields{
+		"involvedObject.kind": "Node",
+		"reason":              nodeCordonedReason,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list node cordon events: %w", err)
+	}
+
+	cutoff := time.Now().Add(-t.Window)
+	cordons := make(map[string]time.Time)
+	for _, event := range events.Items {
+		at := eventTime(&event)
+		if at.Before(cutoff) {
+			continue
+		}
+		if at.After(cordons[event.InvolvedObject.Name]) {
+			cordons[event.InvolvedObject.Name] = at
+		}
+	}
+	t.cordons, t.listedAt = cordons, time.Now()
+	return cordons, nil
+}
+
+// eventTime returns when an event last occurred, whichever event API recorded it
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case event.Series != nil:
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	default:
+		return event.EventTime.Time
+	}
+}
+
+// matchesAnySelector reports whether the node labels satisfy any of the nodeSelectors
+func matchesAnySelector(nodeLabels map[string]string, selectors []map[string]string) bool {
+	for _, selector := range selectors {
+		if len(selector) > 0 && labels.SelectorFromSet(selector).Matches(labels.Set(nodeLabels)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// placementRuleAnnotation records the rule the webhook applied to a pod
+	placementRuleAnnotation = "smart-scheduler.io/placement-rule"
+	// placementOutcomeAnnotation records whether the node a pod was bound to matches its placement rule
+	placementOutcomeAnnotation = "smart-scheduler.io/placement-outcome"
+)
+
+var managedFieldConflicts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_managed_field_conflicts_total",
+		Help: "Pods whose fields set by the webhook were changed by someone else, by namespace and field manager (unknown when the change was made at creation, e.g. by a later mutating webhook)",
+	},
+	[]string{"namespace", "manager"},
+)
+
+var placementBindOutcomes = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_placement_bind_outcomes_total",
+		Help: "Bound pods whose node matched (matched) or did not match (mismatched) the nodeSelector of their placement rule, by namespace",
+	},
+	[]string{"namespace", "outcome"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(managedFieldConflicts, placementBindOutcomes)
+}
+
+// defaultRecreateCooldown limits how often pods of one deployment are recreated, so a webhook that
+// keeps stripping the nodeSelector cannot cause a deletion loop
+const defaultRecreateCooldown = 10 * time.Minute
+
+// PlacementAuditController finds pods marked as processed by the webhook whose nodeSelector no longer
+// contains the rule recorded on them, e.g. because a later webhook stripped it. Mismatches are
+// reported with an event and, when Recreate is set, the pod is deleted so its ReplicaSet recreates it.
+// Changes to any field recorded in the pod's webhook.ManagedFieldsAnnotation, at creation or by
+// later updates, are reported with an event naming the field manager that made them when known.
+// With BindAudit, the node each processed pod is bound to is compared with its rule's nodeSelector.
+type PlacementAuditController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Recreate deletes mismatched pods owned by a Deployment so they are admitted again
+	Recreate bool
+	// BindAudit records in placementOutcomeAnnotation whether bound pods landed on a node of their rule
+	BindAudit bool
+	// RecreateCooldown is the minimum time between recreations for one deployment (default: 10m)
+	RecreateCooldown time.Duration
+	// Owners maps pods to their parent deployment for the recreation cooldown
+	Owners *webhook.OwnerResolver
+	// Namespaces lists namespaces whose pods are never recreated; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, deletes pods impersonating a service account of their namespace
+	Tenants *TenantClients
+	// ManualOverrides, if set, defers recreations after pods were moved by hand
+	ManualOverrides *ManualOverrideTracker
+	// Limits, if set, counts recreations against the cluster's hourly eviction limit
+	Limits *ScopeLimits
+
+	mu sync.Mutex
+	// lastRecreated records when a pod of each deployment was last recreated
+	lastRecreated map[types.NamespacedName]time.Time
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch;delete
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile checks that a processed pod still carries the nodeSelector of its recorded rule
+func (r *PlacementAuditController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("pod", req.NamespacedName)
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if pod.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// The outcome annotation makes the bind audit safe to retry, unlike the events reported below
+	if err := r.auditBinding(ctx, pod, log); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.auditManagedFields(ctx, pod, log)
+
+	ruleKey := webhook.RuleKey(pod.Annotations[placementRuleAnnotation])
+	missing, ok := missingRuleSelector(pod, ruleKey)
+	if !ok || len(missing) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("Processed pod does not carry the nodeSelector of its placement rule",
+		"placementRule", ruleKey,
+		"missing", missing,
+		"nodeSelector", pod.Spec.NodeSelector)
+	r.createPodEvent(ctx, pod, "PlacementMismatch",
+		fmt.Sprintf("Pod was placed on rule %q but its nodeSelector is missing %v", ruleKey, missing))
+
+	if !r.Recreate || r.Namespaces.Protected(pod.Namespace) {
+		return ctrl.Result{}, nil
+	}
+	return r.recreate(ctx, pod, ruleKey, log)
+}
+
+// auditManagedFields reports the fields set by the webhook that no longer have the value it set
+func (r *PlacementAuditController) auditManagedFields(ctx context.Context, pod *corev1.Pod, log logr.Logger) {
+	conflicts, err := webhook.ManagedFieldConflicts(pod)
+	if err != nil {
+		log.Error(err, "Ignoring managed fields")
+		return
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+
+	manager := webhook.ConflictingFieldManager(pod, conflicts)
+	log.Info("Fields set by the webhook were changed", "fields", conflicts, "manager", manager)
+	changedBy := "another mutator"
+	if manager != "" {
+		changedBy = fmt.Sprintf("field manager %q", manager)
+	} else {
+		manager = "unknown"
+	}
+	managedFieldConflicts.WithLabelValues(pod.Namespace, manager).Inc()
+	r.createPodEvent(ctx, pod, "ManagedFieldConflict",
+		fmt.Sprintf("Fields set by smart-scheduler were changed by %s: %s", changedBy, strings.Join(conflicts, ", ")))
+}
+
+// auditBinding compares the labels of the node a bound pod landed on with the nodeSelector of its
+// placement rule, once per pod. The outcome is recorded in placementOutcomeAnnotation next to the
+// rule, and mismatches, e.g. from a typo in a rule's selector or another webhook changing the pod,
+// are reported with an event.
+func (r *PlacementAuditController) auditBinding(ctx context.Context, pod *corev1.Pod, log logr.Logger) error {
+	if !r.BindAudit || pod.Spec.NodeName == "" || pod.Annotations[placementOutcomeAnnotation] != "" {
+		return nil
+	}
+	ruleKey := webhook.CanonicalRuleKey(pod.Annotations[placementRuleAnnotation])
+	ruleSelector, ok := ruleKey.NodeSelector()
+	if !ok || len(ruleSelector) == 0 {
+		return nil
+	}
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	mismatches := nodeLabelMismatches(node.Labels, ruleSelector)
+	outcome := "matched " + node.Name
+	if len(mismatches) > 0 {
+		outcome = fmt.Sprintf("mismatched %s: %s", node.Name, strings.Join(mismatches, ", "))
+	}
+
+	writer, err := tenantWriter(r.Tenants, r.Client, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	pod.Annotations[placementOutcomeAnnotation] = outcome
+	if err := writer.Patch(ctx, pod, patch); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if len(mismatches) == 0 {
+		placementBindOutcomes.WithLabelValues(pod.Namespace, "matched").Inc()
+		return nil
+	}
+	placementBindOutcomes.WithLabelValues(pod.Namespace, "mismatched").Inc()
+	log.Info("Pod was bound to a node its placement rule does not select",
+		"placementRule", ruleKey,
+		"node", node.Name,
+		"mismatches", mismatches,
+		"nodeSelector", pod.Spec.NodeSelector)
+	r.createPodEvent(ctx, pod, "PlacementBindMismatch",
+		fmt.Sprintf("Pod was placed on rule %q but bound to node %s with %s", ruleKey, node.Name, strings.Join(mismatches, ", ")))
+	return nil
+}
+
+// nodeLabelMismatches describes the pairs of the rule's nodeSelector the node's labels lack, as the
+// node's value next to the rule's, in key order
+func nodeLabelMismatches(labels, ruleSelector map[string]string) []string {
+	var mismatches []string
+	for key, value := range ruleSelector {
+		actual, ok := labels[key]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s unset (rule %s=%s)", key, key, value))
+		case actual != value:
+			mismatches = append(mismatches, fmt.Sprintf("%s=%s (rule %s=%s)", key, actual, key, value))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// recreate deletes the pod so its ReplicaSet replaces it, at most once per deployment and cooldown
+func (r *PlacementAuditController) recreate(ctx context.Context, pod *corev1.Pod, ruleKey webhook.RuleKey, log logr.Logger) (ctrl.Result, error) {
+	deploymentName, ok, err := r.Owners.DeploymentFor(ctx, pod)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !ok {
+		log.Info("Pod is not controlled by a deployment, not recreating it")
+		return ctrl.Result{}, nil
+	}
+
+	deployment := types.NamespacedName{Namespace: pod.Namespace, Name: deploymentName}
+	if wait := r.recreateWait(deployment); wait > 0 {
+		log.Info("Recently recreated a pod of this deployment, waiting", "deployment", deploymentName, "remaining", wait.String())
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	if wait, err := r.manualOverrideWait(ctx, deployment, ruleKey, log); err != nil || wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, err
+	}
+
+	if wait := r.Limits.TakeEviction(); wait > 0 {
+		log.Info("Cluster eviction limit reached, deferring recreation", "deployment", deploymentName, "remaining", wait.String())
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	writer, err := tenantWriter(r.Tenants, r.Client, pod.Namespace)
+	if err != nil {
+		r.Limits.ReturnEviction()
+		return ctrl.Result{}, err
+	}
+	if err := writer.Delete(ctx, pod); err != nil {
+		r.Limits.ReturnEviction()
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete mismatched pod: %w", err)
+		}
+	}
+	r.markRecreated(deployment)
+
+	log.Info("Deleted mismatched pod so it is admitted again", "deployment", deploymentName)
+	r.createPodEvent(ctx, pod, "PlacementMismatchRecreated", "Pod deleted so its ReplicaSet recreates it with its placement rule")
+	return ctrl.Result{}, nil
+}
+
+// manualOverrideWait returns how long the human-override window defers recreating pods of the deployment
+func (r *PlacementAuditController) manualOverrideWait(ctx context.Context, key types.NamespacedName, ruleKey webhook.RuleKey, log logr.Logger) (time.Duration, error) {
+	if r.ManualOverrides == nil {
+		return 0, nil
+	}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		return 0, client.IgnoreNotFound(err)
+	}
+
+	ruleSelector, _ := ruleKey.NodeSelector()
+	wait, reason, err := r.ManualOverrides.Hold(ctx, "placementaudit", deployment, []map[string]string{ruleSelector})
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		log.Info("Recreation deferred by human-override window", "deployment", key.Name, "reason", reason, "remaining", wait.String())
+	}
+	return wait, nil
+}
+
+// recreateWait returns how long the deployment must wait before another pod is recreated
+func (r *PlacementAuditController) recreateWait(deployment types.NamespacedName) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last, ok := r.lastRecreated[deployment]
+	if !ok {
+		return 0
+	}
+	return time.Until(last.Add(r.RecreateCooldown))
+}
+
+// markRecreated starts the deployment's recreation cooldown
+func (r *PlacementAuditController) markRecreated(deployment types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastRecreated == nil {
+		r.lastRecreated = make(map[types.NamespacedName]time.Time)
+	}
+	r.lastRecreated[deployment] = time.Now()
+}
+
+// missingRuleSelector returns the pairs of the rule's nodeSelector the pod does not carry.
+// It reports false when the recorded rule cannot be interpreted.
+func missingRuleSelector(pod *corev1.Pod, ruleKey webhook.RuleKey) (map[string]string, bool) {
+	ruleSelector, ok := ruleKey.NodeSelector()
+	if !ok {
+		return nil, false
+	}
+
+	missing := make(map[string]string)
+	for key, value := range ruleSelector {
+		if pod.Spec.NodeSelector[key] != value {
+			missing[key] = value
+		}
+	}
+	return missing, true
+}
+
+// createPodEvent records an event on the pod
+func (r *PlacementAuditController) createPodEvent(ctx context.Context, pod *corev1.Pod, reason, message string) {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + "-",
+			Namespace:    pod.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			Name:       pod.Name,
+			Namespace:  pod.Namespace,
+			UID:        pod.UID,
+			APIVersion: "v1",
+		},
+		Reason:  reason,
+		Message: message,
+		Type:    corev1.EventTypeWarning,
+		Source: corev1.EventSource{
+			Component: "smart-scheduler-placement-audit",
+		},
+		FirstTimestamp: metav1.NewTime(time.Now()),
+		LastTimestamp:  metav1.NewTime(time.Now()),
+	}
+
+	if err := r.Create(ctx, event); err != nil {
+		r.Log.Error(err, "Failed to create placement audit event")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *PlacementAuditController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.RecreateCooldown <= 0 {
+		r.RecreateCooldown = defaultRecreateCooldown
+	}
+	if r.Owners == nil {
+		r.Owners = webhook.NewOwnerResolver(mgr.GetClient())
+	}
+	if err := r.Owners.RegisterInvalidation(context.Background(), mgr.GetCache()); err != nil {
+		return err
+	}
+
+	// A pod's nodeSelector cannot change after creation, so each processed pod is checked once, and
+	// again when it is bound to a node or an update changes which of its managed fields conflict
+	processedPods := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			annotations := e.Object.GetAnnotations()
+			return annotations[placementRuleAnnotation] != "" || annotations[webhook.ManagedFieldsAnnotation] != ""
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, oldOk := e.ObjectOld.(*corev1.Pod)
+			newPod, newOk := e.ObjectNew.(*corev1.Pod)
+			if !oldOk || !newOk {
+				return false
+			}
+			if r.BindAudit && oldPod.Spec.NodeName == "" && newPod.Spec.NodeName != "" &&
+				newPod.Annotations[placementRuleAnnotation] != "" {
+				return true
+			}
+			oldConflicts, _ := webhook.ManagedFieldConflicts(oldPod)
+			newConflicts, _ := webhook.ManagedFieldConflicts(newPod)
+			return len(newConflicts) > 0 && !slices.Equal(oldConflicts, newConflicts)
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("placementaudit").
+		For(&corev1.Pod{}, builder.WithPredicates(processedPods)).
+		WithOptions(controller.Options{
+			// Auditing is background work and must not compete with the other controllers
+			MaxConcurrentReconciles: 1,
+		}).
+		Complete(r)
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// PlacementCleanupMode selects what happens to pods that keep placements injected by a strategy
+// after the strategy is removed from their deployment
+type PlacementCleanupMode string
+
+const (
+	// PlacementCleanupNone leaves the pods alone; the next rollout drops the injected placement
+	PlacementCleanupNone PlacementCleanupMode = "none"
+	// PlacementCleanupAnnotate marks the deployment with placementStaleAnnotation until the pods are gone
+	PlacementCleanupAnnotate PlacementCleanupMode = "annotate"
+	// PlacementCleanupRollout restarts the deployment so its pods are recreated without the placement
+	PlacementCleanupRollout PlacementCleanupMode = "rollout"
+)
+
+const (
+	// placementStaleAnnotation describes the pods still carrying a removed strategy's placement
+	placementStaleAnnotation = "smart-scheduler.io/placement-stale"
+	// placementCleanupAnnotation is set on the pod template to restart a deployment for cleanup
+	placementCleanupAnnotation = "smart-scheduler.io/placement-cleanup-at"
+	// processedAnnotation marks pods the webhook placed
+	processedAnnotation = "smart-scheduler.io/processed"
+)
+
+// ParsePlacementCleanupMode validates a cleanup mode flag value
+func ParsePlacementCleanupMode(mode string) (PlacementCleanupMode, error) {
+	switch PlacementCleanupMode(mode) {
+	case PlacementCleanupNone, PlacementCleanupAnnotate, PlacementCleanupRollout:
+		return PlacementCleanupMode(mode), nil
+	case "":
+		return PlacementCleanupNone, nil
+	default:
+		return "", fmt.Errorf("unknown placement cleanup mode %q, expected none, annotate or rollout", mode)
+	}
+}
+
+// cleanupRemovedStrategy handles a deployment without a strategy whose pods were placed by one.
+// Depending on CleanupMode the deployment is annotated or restarted, so the placement change that
+// removing a strategy implies happens explicitly instead of on the next unrelated rollout.
+func (r *SchedulerController) cleanupRemovedStrategy(ctx context.Context, deployment *appsv1.Deployment, log logr.Logger) (ctrl.Result, error) {
+	if r.CleanupMode == "" || r.CleanupMode == PlacementCleanupNone || r.Namespaces.Protected(deployment.Namespace) {
+		return ctrl.Result{}, nil
+	}
+
+	stale, err := r.countStalePods(ctx, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if stale == 0 {
+		return ctrl.Result{}, r.clearStaleAnnotation(ctx, deployment)
+	}
+
+	log.Info("Pods keep the placement of a removed strategy", "stalePods", stale, "cleanupMode", r.CleanupMode)
+
+	switch r.CleanupMode {
+	case PlacementCleanupAnnotate:
+		message := fmt.Sprintf("%d pods keep placement injected by a removed schedule strategy until the next rollout", stale)
+		if deployment.Annotations[placementStaleAnnotation] != message {
+			patch := client.MergeFrom(deployment.DeepCopy())
+			if deployment.Annotations == nil {
+				deployment.Annotations = make(map[string]string)
+			}
+			deployment.Annotations[placementStaleAnnotation] = message
+			if err := r.Patch(ctx, deployment, patch); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to annotate deployment: %w", err)
+			}
+		}
+		// Pods leave on their own; check back to drop the annotation once they are gone
+		return ctrl.Result{RequeueAfter: time.Minute * 10}, nil
+
+	case PlacementCleanupRollout:
+		if deploymentRollingOut(deployment) {
+			// The restart (or another rollout) is still replacing pods
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+		patch := client.MergeFrom(deployment.DeepCopy())
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = make(map[string]string)
+		}
+		deployment.Spec.Template.Annotations[placementCleanupAnnotation] = time.Now().Format(time.RFC3339)
+		if err := r.Patch(ctx, deployment, patch); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to restart deployment: %w", err)
+		}
+		log.Info("Restarted deployment to drop the placement of a removed strategy", "stalePods", stale)
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// countStalePods counts the deployment's pods that were placed by the webhook
+func (r *SchedulerController) countStalePods(ctx context.Context, deployment *appsv1.Deployment) (int, error) {
+	pods, err := webhook.ListDeploymentPods(ctx, r.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		return 0, err
+	}
+
+	stale := 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && pod.Annotations[processedAnnotation] != "" {
+			stale++
+		}
+	}
+	return stale, nil
+}
+
+// clearStaleAnnotation removes placementStaleAnnotation from the deployment if present
+func (r *SchedulerController) clearStaleAnnotation(ctx context.Context, deployment *appsv1.Deployment) error {
+	if _, ok := deployment.Annotations[placementStaleAnnotation]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(deployment.DeepCopy())
+	delete(deployment.Annotations, placementStaleAnnotation)
+	if err := r.Patch(ctx, deployment, patch); err != nil {
+		return fmt.Errorf("failed to remove %s annotation: %w", placementStaleAnnotation, err)
+	}
+	return nil
+}
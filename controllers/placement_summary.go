@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// DefaultSummaryInterval is how often the placement summary is refreshed
+const DefaultSummaryInterval = time.Minute
+
+// PlacementSummaryController maintains the cluster's one PlacementSummary, named
+// smartschedulerv1.PlacementSummaryName: the workloads with a placement strategy, their pods by
+// capacity type, the pods stuck Unschedulable and the workload drifted furthest. Drift is measured
+// like the rebalancer measures it, leaving out workloads rolling out or running an experiment.
+type PlacementSummaryController struct {
+	client.Client
+	Log        logr.Logger
+	Scheme     *runtime.Scheme
+	PoolHealth *webhook.PoolHealthChecker
+	// Interval is how often the summary is refreshed (default: 1m)
+	Interval time.Duration
+	// Namespaces lists namespaces whose workloads are not summarized; nil leaves out system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// StatefulSets summarizes StatefulSets with a strategy too
+	StatefulSets bool
+	// WeightTuning and SpotScores measure drift against the lowered weights, like the rebalancer
+	WeightTuning bool
+	SpotScores   bool
+}
+
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=placementsummaries,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=placementsummaries/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// Reconcile totals the managed workloads and writes the summary
+func (r *PlacementSummaryController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	workloads, err := r.workloads(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := smartschedulerv1.PlacementSummaryStatus{}
+	for _, workload := range workloads {
+		if err := r.summarize(ctx, workload, &status); err != nil {
+			r.Log.Error(err, "Failed to summarize workload", "kind", webhook.WorkloadKind(workload),
+				"workload", workload.Namespace+"/"+workload.Name)
+		}
+	}
+	now := metav1.Now()
+	status.LastUpdated = &now
+
+	if err := r.writeSummary(ctx, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.Interval}, nil
+}
+
+// workloads lists the Deployments, and StatefulSets if enabled, with a placement strategy outside
+// the protected namespaces
+func (r *PlacementSummaryController) workloads(ctx context.Context) ([]*appsv1.Deployment, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments); err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	var workloads []*appsv1.Deployment
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+	if r.StatefulSets {
+		statefulSets := &appsv1.StatefulSetList{}
+		if err := r.List(ctx, statefulSets); err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+		}
+		for i := range statefulSets.Items {
+			workloads = append(workloads, webhook.StatefulSetWorkload(&statefulSets.Items[i]))
+		}
+	}
+
+	managed := workloads[:0]
+	for _, workload := range workloads {
+		if r.Namespaces.Protected(workload.Namespace) || workload.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok, _ := webhook.ResolveScheduleStrategy(workload.Annotations, workload.Spec.Template.Spec.PriorityClassName); ok {
+			managed = append(managed, workload)
+		}
+	}
+	return managed, nil
+}
+
+// summarize adds the workload's pods, and its drift when it is the worst so far, to the status
+func (r *PlacementSummaryController) summarize(ctx context.Context, workload *appsv1.Deployment, status *smartschedulerv1.PlacementSummaryStatus) error {
+	status.ManagedDeployments++
+
+	strategy, err := r.strategy(ctx, workload)
+	if err != nil {
+		return err
+	}
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, workload)
+	if err != nil {
+		return err
+	}
+
+	actual := webhook.CountPodsByRule(pods, strategy)
+	for _, rule := range strategy.Rules {
+		count := int32(actual[rule.Key()])
+		switch {
+		case webhook.SpotRule(rule):
+			status.Pods.Spot += count
+		case rule.Preemptible:
+			status.Pods.Preemptible += count
+		default:
+			status.Pods.OnDemand += count
+		}
+	}
+	for _, count := range webhook.CountUnschedulableByRule(pods, strategy, time.Now()) {
+		status.PendingUnschedulable += int32(count)
+	}
+
+	if _, ok := workload.Annotations[webhook.ExperimentAnnotation]; ok || deploymentRollingOut(workload) {
+		return nil
+	}
+	expected := webhook.ExpectedCounts(strategy, actual, r.PoolHealth.HealthFunc(ctx))
+	if floor, err := webhook.MinOnDemand(workload); err == nil {
+		expected = webhook.ExpectedMinOnDemand(strategy, expected, floor)
+	}
+	drift := webhook.DriftPercentage(expected, actual)
+	if status.WorstDrift == nil || drift > status.WorstDrift.DriftPercentage {
+		status.WorstDrift = &smartschedulerv1.WorkloadDrift{
+			Kind:            webhook.WorkloadKind(workload),
+			Namespace:       workload.Namespace,
+			Name:            workload.Name,
+			DriftPercentage: drift,
+		}
+	}
+	return nil
+}
+
+// strategy returns the workload's strategy with the base and weights the rebalancer applies
+func (r *PlacementSummaryController) strategy(ctx context.Context, workload *appsv1.Deployment) (*webhook.PlacementStrategy, error) {
+	annotation, _, _ := webhook.ResolveScheduleStrategy(workload.Annotations, workload.Spec.Template.Spec.PriorityClassName)
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := webhook.ResolveBase(ctx, r.Client, workload, strategy); err == nil {
+		strategy = resolved
+	}
+	if r.WeightTuning {
+		if adjustments, err := webhook.ParseWeightAdjustments(workload.Annotations[webhook.WeightAdjustmentsAnnotation]); err == nil {
+			strategy = webhook.ApplyWeightAdjustments(strategy, adjustments)
+		}
+	}
+	if r.SpotScores {
+		if scored, err := webhook.WithSpotScoreWeights(workload, strategy); err == nil {
+			strategy = scored
+		}
+	}
+	if renamed, err := webhook.WithRuleRenames(workload, strategy); err == nil {
+		strategy = renamed
+	}
+	return strategy, nil
+}
+
+// writeSummary creates the summary if it is missing and replaces its status
+func (r *PlacementSummaryController) writeSummary(ctx context.Context, status smartschedulerv1.PlacementSummaryStatus) error {
+	summary := &smartschedulerv1.PlacementSummary{}
+	err := r.Get(ctx, client.ObjectKey{Name: smartschedulerv1.PlacementSummaryName}, summary)
+	if apierrors.IsNotFound(err) {
+		summary = &smartschedulerv1.PlacementSummary{ObjectMeta: metav1.ObjectMeta{Name: smartschedulerv1.PlacementSummaryName}}
+		err = r.Create(ctx, summary)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get placement summary: %w", err)
+	}
+
+	summary.Status = status
+	if err := r.Status().Update(ctx, summary); err != nil {
+		return fmt.Errorf("failed to update placement summary: %w", err)
+	}
+	r.Log.V(1).Info("Updated placement summary", "managedDeployments", status.ManagedDeployments,
+		"pods", status.Pods, "pendingUnschedulable", status.PendingUnschedulable)
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager. Its one request is queued at start
+// and requeued every Interval.
+func (r *PlacementSummaryController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Interval <= 0 {
+		r.Interval = DefaultSummaryInterval
+	}
+	if r.PoolHealth == nil {
+		r.PoolHealth = webhook.NewPoolHealthChecker(mgr.GetClient(), r.Log.WithName("PoolHealth"))
+	}
+
+	start := make(chan event.GenericEvent, 1)
+	start <- event.GenericEvent{Object: &smartschedulerv1.PlacementSummary{ObjectMeta: metav1.ObjectMeta{Name: smartschedulerv1.PlacementSummaryName}}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("summary").
+		WatchesRawSource(&source.Channel{Source: start}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -28,12 +30,32 @@ type PodPlacementPolicyController struct {
 	Log          logr.Logger
 	Scheme       *runtime.Scheme
 	StateManager *webhook.StateManager
+	// MaxConcurrentReconciles limits parallel reconciles (default: 2)
+	MaxConcurrentReconciles int
+	// Preflight checks that every rule's nodeSelector matches an existing node, reporting
+	// misses with the RuleMatchesNoNodes condition
+	Preflight bool
+	// Namespaces lists namespaces where policies are never applied; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, applies policies impersonating a service account of the deployment's namespace
+	Tenants *TenantClients
+	// Experiments applies policy experiments to deployments and reports per-arm status
+	Experiments bool
+	// Limits, if set, caps the policies applied per namespace and the deployments given a strategy
+	Limits *ScopeLimits
+	// RuleRekey watches node label changes, reports rules whose nodes were relabeled with the
+	// RuleSelectorStale condition and records the renames on the matched deployments
+	RuleRekey bool
+
+	evictions *experimentEvictions
+	relabels  *nodeRelabels
 }
 
 //+kubebuilder:rbac:groups=smartscheduler.io,resources=podplacementpolicies,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=smartscheduler.io,resources=podplacementpolicies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=smartscheduler.io,resources=podplacementpolicies/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
 
 // Reconcile handles PodPlacementPolicy changes and applies them to matching deployments
 func (r *PodPlacementPolicyController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -52,38 +74,139 @@ func (r *PodPlacementPolicyController) Reconcile(ctx context.Context, req ctrl.R
 
 	log.Info("Processing PodPlacementPolicy", "enabled", policy.Spec.Enabled, "priority", policy.Spec.Priority)
 
+	if r.Namespaces.Protected(policy.Namespace) {
+		log.Info("Policy is in a protected namespace, not applying it")
+		return r.updateProtectedPolicyStatus(ctx, policy, log)
+	}
+
+	// Catch nodeSelector typos before pods that use them become unschedulable
+	if err := r.applyPreflight(ctx, policy); err != nil {
+		log.Error(err, "Failed to run preflight checks")
+	} else if meta.IsStatusConditionTrue(policy.Status.Conditions, RuleMatchesNoNodesCondition) {
+		log.Info("Policy has rules that match no nodes",
+			"message", meta.FindStatusCondition(policy.Status.Conditions, RuleMatchesNoNodesCondition).Message)
+	}
+
+	// Pool renames leave rules selecting no node, and the pods placed with them with no rule
+	renames, err := r.applyRuleSelectorCheck(ctx, policy)
+	if err != nil {
+		log.Error(err, "Failed to check rule selectors against relabeled nodes")
+	} else if meta.IsStatusConditionTrue(policy.Status.Conditions, RuleSelectorStaleCondition) {
+		log.Info("Policy has rules whose nodes were relabeled",
+			"message", meta.FindStatusCondition(policy.Status.Conditions, RuleSelectorStaleCondition).Message)
+	}
+
 	// Skip disabled policies
 	if !policy.Spec.Enabled {
 		log.Info("Policy is disabled, skipping")
+		setLimitExceeded(policy, "", "")
 		return r.updatePolicyStatus(ctx, policy, nil, log)
 	}
 
+	// Policies beyond the namespace's limit are not applied; the oldest ones keep working
+	withinLimit, err := r.Limits.PolicyWithinLimit(ctx, r.Client, policy)
+	if err != nil {
+		log.Error(err, "Failed to check the policy limit of the namespace")
+		return ctrl.Result{}, err
+	}
+	if !withinLimit {
+		log.Info("Namespace has more policies than the limit allows, not applying the policy")
+		return r.updateLimitedPolicyStatus(ctx, policy, log)
+	}
+
 	// Find matching deployments
 	matchedDeployments, err := r.findMatchingDeployments(ctx, policy)
 	if err != nil {
 		log.Error(err, "Failed to find matching deployments")
-		return ctrl.Result{RequeueAfter: time.Minute * 2}, err
+		return resultForError("policy", err, log)
 	}
 
 	log.Info("Found matching deployments", "count", len(matchedDeployments))
 
-	// Apply policy to each matching deployment
+	// Apply policy to each matching deployment. Deployments without a strategy are only taken on
+	// while the cluster's managed deployment limit allows.
 	var deploymentRefs []smartschedulerv1.DeploymentReference
+	managedLeft, counted, limited := -1, false, 0
 	for _, deployment := range matchedDeployments {
-		ref, err := r.applyPolicyToDeployment(ctx, policy, &deployment, log)
+		newlyManaged := !webhook.HasScheduleStrategy(deployment.Annotations)
+		if newlyManaged && !counted {
+			if managedLeft, err = r.Limits.ManagedDeploymentsLeft(ctx, r.Client); err != nil {
+				log.Error(err, "Failed to check the managed deployment limit")
+				return ctrl.Result{}, err
+			}
+			counted = true
+		}
+		if newlyManaged && managedLeft == 0 {
+			limited++
+			continue
+		}
+
+		ref, err := r.applyPolicyToDeployment(ctx, policy, &deployment, renames, log)
 		if err != nil {
 			log.Error(err, "Failed to apply policy to deployment", "deployment", deployment.Name)
+			reconcileErrors.WithLabelValues("policy", webhook.ErrorReason(err)).Inc()
 			continue
 		}
+		if newlyManaged && managedLeft > 0 {
+			managedLeft--
+		}
 		if ref != nil {
 			deploymentRefs = append(deploymentRefs, *ref)
 		}
 	}
 
+	if limited > 0 {
+		log.Info("Managed deployment limit reached, not applying the policy to some deployments", "skipped", limited)
+		scopeLimitHits.WithLabelValues(limitManagedDeployments).Inc()
+		setLimitExceeded(policy, "MaxManagedDeployments",
+			fmt.Sprintf("The cluster manages the maximum number of deployments, the policy is not applied to %d matching deployments", limited))
+	} else {
+		setLimitExceeded(policy, "", "")
+	}
+
 	// Update policy status
 	return r.updatePolicyStatus(ctx, policy, deploymentRefs, log)
 }
 
+// updateLimitedPolicyStatus marks a policy beyond its namespace's policy limit as not applied
+func (r *PodPlacementPolicyController) updateLimitedPolicyStatus(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy, log logr.Logger) (ctrl.Result, error) {
+	message := "The namespace has the maximum number of policies, only older policies are applied"
+	policy.Status.MatchedDeployments = nil
+	setLimitExceeded(policy, "MaxPoliciesPerNamespace", message)
+	meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+		Type:    "Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  "LimitExceeded",
+		Message: message,
+	})
+	policy.Status.ObservedGeneration = policy.Generation
+
+	if err := r.Status().Update(ctx, policy); err != nil {
+		log.Error(err, "Failed to update policy status")
+		return ctrl.Result{RequeueAfter: time.Minute}, err
+	}
+	// Deleting an older policy frees a slot without an event for this one
+	return ctrl.Result{RequeueAfter: time.Minute * 10}, nil
+}
+
+// updateProtectedPolicyStatus marks a policy in a protected namespace as not applied
+func (r *PodPlacementPolicyController) updateProtectedPolicyStatus(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy, log logr.Logger) (ctrl.Result, error) {
+	policy.Status.MatchedDeployments = nil
+	meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+		Type:    "Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  "NamespaceProtected",
+		Message: fmt.Sprintf("Namespace %s is protected, the policy is not applied", policy.Namespace),
+	})
+	policy.Status.ObservedGeneration = policy.Generation
+
+	if err := r.Status().Update(ctx, policy); err != nil {
+		log.Error(err, "Failed to update policy status")
+		return ctrl.Result{RequeueAfter: time.Minute}, err
+	}
+	return ctrl.Result{}, nil
+}
+
 // findMatchingDeployments finds deployments that match the policy selector
 func (r *PodPlacementPolicyController) findMatchingDeployments(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) ([]appsv1.Deployment, error) {
 	deploymentList := &appsv1.DeploymentList{}
@@ -110,8 +233,9 @@ func (r *PodPlacementPolicyController) findMatchingDeployments(ctx context.Conte
 	return deploymentList.Items, nil
 }
 
-// applyPolicyToDeployment applies the placement policy to a specific deployment
-func (r *PodPlacementPolicyController) applyPolicyToDeployment(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy, deployment *appsv1.Deployment, log logr.Logger) (*smartschedulerv1.DeploymentReference, error) {
+// applyPolicyToDeployment applies the placement policy to a specific deployment, recording the
+// renames of the policy's rules
+func (r *PodPlacementPolicyController) applyPolicyToDeployment(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy, deployment *appsv1.Deployment, renames map[webhook.RuleKey]webhook.RuleKey, log logr.Logger) (*smartschedulerv1.DeploymentReference, error) {
 	deploymentLog := log.WithValues("deployment", deployment.Name)
 
 	// Check if deployment already has a higher priority policy
@@ -126,6 +250,22 @@ func (r *PodPlacementPolicyController) applyPolicyToDeployment(ctx context.Conte
 		return nil, fmt.Errorf("failed to convert strategy to annotation: %w", err)
 	}
 
+	// Convert priority tiers to the priority-strategies annotation
+	priorityStrategies, err := r.convertPriorityTiersToAnnotation(policy.Spec.PriorityTiers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert priority tiers to annotation: %w", err)
+	}
+
+	// An empty pod selector selects every pod and is not written
+	podSelector := ""
+	if policy.Spec.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod selector: %w", err)
+		}
+		podSelector = selector.String()
+	}
+
 	// Update deployment annotations
 	if deployment.Annotations == nil {
 		deployment.Annotations = make(map[string]string)
@@ -133,11 +273,78 @@ func (r *PodPlacementPolicyController) applyPolicyToDeployment(ctx context.Conte
 
 	// Apply the strategy annotation
 	deployment.Annotations["smart-scheduler.io/schedule-strategy"] = strategyAnnotation
+	if priorityStrategies != "" {
+		deployment.Annotations[webhook.PriorityStrategiesAnnotation] = priorityStrategies
+	} else {
+		delete(deployment.Annotations, webhook.PriorityStrategiesAnnotation)
+	}
+	if r.Experiments && policy.Spec.Experiment != nil {
+		experiment, err := r.convertExperimentToAnnotation(policy.Spec.Experiment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert experiment to annotation: %w", err)
+		}
+		deployment.Annotations[webhook.ExperimentAnnotation] = experiment
+	} else {
+		delete(deployment.Annotations, webhook.ExperimentAnnotation)
+	}
+	if policy.Spec.Propagation != nil {
+		propagation, err := json.Marshal(webhook.Propagation{
+			Env:               policy.Spec.Propagation.Env,
+			Annotations:       policy.Spec.Propagation.Annotations,
+			Labels:            policy.Spec.Propagation.Labels,
+			CapacityTypeLabel: policy.Spec.Propagation.CapacityTypeLabel,
+			ExcludeContainers: policy.Spec.Propagation.ExcludeContainers,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert propagation to annotation: %w", err)
+		}
+		deployment.Annotations[webhook.PropagationAnnotation] = string(propagation)
+	} else {
+		delete(deployment.Annotations, webhook.PropagationAnnotation)
+	}
+	if podSelector != "" {
+		deployment.Annotations[webhook.PodSelectorAnnotation] = podSelector
+	} else {
+		delete(deployment.Annotations, webhook.PodSelectorAnnotation)
+	}
+	if policy.Spec.RuleDisruptionBudget {
+		deployment.Annotations[webhook.RuleDisruptionBudgetAnnotation] = "true"
+	} else {
+		delete(deployment.Annotations, webhook.RuleDisruptionBudgetAnnotation)
+	}
+	if policy.Spec.BasePriorityClassName != "" {
+		deployment.Annotations[webhook.BasePriorityClassAnnotation] = policy.Spec.BasePriorityClassName
+	} else {
+		delete(deployment.Annotations, webhook.BasePriorityClassAnnotation)
+	}
+	if policy.Spec.Migration != nil {
+		migration, err := json.Marshal(webhook.Migration{
+			StepPercent: int(policy.Spec.Migration.StepPercent),
+			Interval:    policy.Spec.Migration.Interval,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert migration to annotation: %w", err)
+		}
+		deployment.Annotations[webhook.MigrationAnnotation] = string(migration)
+	} else {
+		delete(deployment.Annotations, webhook.MigrationAnnotation)
+	}
+	if len(renames) > 0 {
+		merged, err := mergeRuleRenames(deployment.Annotations, renames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert rule renames to annotation: %w", err)
+		}
+		deployment.Annotations[webhook.RuleRenamesAnnotation] = merged
+	}
 	deployment.Annotations["smart-scheduler.io/policy-name"] = policy.Name
 	deployment.Annotations["smart-scheduler.io/policy-priority"] = fmt.Sprintf("%d", policy.Spec.Priority)
 	deployment.Annotations["smart-scheduler.io/policy-applied"] = time.Now().Format(time.RFC3339)
 
-	err = r.Update(ctx, deployment)
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Update(ctx, deployment)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update deployment: %w", err)
 	}
@@ -152,13 +359,50 @@ func (r *PodPlacementPolicyController) applyPolicyToDeployment(ctx context.Conte
 	}
 
 	return &smartschedulerv1.DeploymentReference{
-		Name:         deployment.Name,
-		Namespace:    deployment.Namespace,
-		CurrentDrift: drift,
-		LastApplied:  &metav1.Time{Time: time.Now()},
+		Name:              deployment.Name,
+		Namespace:         deployment.Namespace,
+		CurrentDrift:      drift,
+		LastApplied:       &metav1.Time{Time: time.Now()},
+		WeightAdjustments: weightAdjustmentsStatus(deployment),
+		Migration:         r.migrationStatus(ctx, deployment, deploymentLog),
 	}, nil
 }
 
+// migrationStatus returns the progress of the deployment's paced migration, or nil when no
+// migration is under way
+func (r *PodPlacementPolicyController) migrationStatus(ctx context.Context, deployment *appsv1.Deployment, log logr.Logger) *smartschedulerv1.MigrationStatus {
+	if _, ok := deployment.Annotations[webhook.MigrationAnnotation]; !ok {
+		return nil
+	}
+	rollout, _, err := loadStrategyRollout(ctx, r.StateManager, deployment)
+	if err != nil {
+		log.Error(err, "Failed to read migration progress")
+		return nil
+	}
+	if !rollout.Active() {
+		return nil
+	}
+	return &smartschedulerv1.MigrationStatus{
+		StartedAt:     &metav1.Time{Time: rollout.Started},
+		PodsMoved:     int32(rollout.Evictions),
+		PodsRemaining: int32(max(rollout.Remaining, 0)),
+	}
+}
+
+// weightAdjustmentsStatus returns the weights the weight tuning controller lowered for the
+// deployment's rules, for the policy status
+func weightAdjustmentsStatus(deployment *appsv1.Deployment) map[string]int32 {
+	adjustments, err := webhook.ParseWeightAdjustments(deployment.Annotations[webhook.WeightAdjustmentsAnnotation])
+	if err != nil || len(adjustments) == 0 {
+		return nil
+	}
+	status := make(map[string]int32, len(adjustments))
+	for key, percent := range adjustments {
+		status[key.String()] = int32(percent)
+	}
+	return status
+}
+
 // hasHigherPriorityPolicy checks if deployment already has a higher priority policy applied
 func (r *PodPlacementPolicyController) hasHigherPriorityPolicy(deployment *appsv1.Deployment, policy *smartschedulerv1.PodPlacementPolicy) bool {
 	if deployment.Annotations == nil {
@@ -179,19 +423,70 @@ func (r *PodPlacementPolicyController) hasHigherPriorityPolicy(deployment *appsv
 	return currentPriority > policy.Spec.Priority
 }
 
+// tolerationsAnnotation converts a rule's tolerations to the annotation format
+func tolerationsAnnotation(specs []smartschedulerv1.TolerationSpec) string {
+	tolerations := make([]corev1.Toleration, 0, len(specs))
+	for _, spec := range specs {
+		tolerations = append(tolerations, corev1.Toleration{
+			Key:      spec.Key,
+			Operator: corev1.TolerationOperator(spec.Operator),
+			Value:    spec.Value,
+			Effect:   corev1.TaintEffect(spec.Effect),
+		})
+	}
+	return webhook.FormatTolerations(tolerations)
+}
+
+// nodeAffinityAnnotation converts a rule's node affinity expressions to the annotation format
+func nodeAffinityAnnotation(specs []smartschedulerv1.NodeAffinityExpressionSpec) string {
+	requirements := make([]corev1.NodeSelectorRequirement, 0, len(specs))
+	for _, spec := range specs {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      spec.Key,
+			Operator: corev1.NodeSelectorOperator(spec.Operator),
+			Values:   spec.Values,
+		})
+	}
+	return webhook.FormatNodeAffinity(requirements)
+}
+
 // convertStrategyToAnnotation converts CRD strategy to annotation format
 func (r *PodPlacementPolicyController) convertStrategyToAnnotation(strategy smartschedulerv1.PlacementStrategySpec) (string, error) {
 	// Convert to the annotation format: "base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot"
 
 	if len(strategy.Rules) == 0 {
-		return "", fmt.Errorf("strategy must have at least one rule")
+		return "", webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("strategy must have at least one rule"))
 	}
 
 	var parts []string
 
 	// First rule includes base
 	firstRule := strategy.Rules[0]
-	firstPart := fmt.Sprintf("base=%d,weight=%d", strategy.Base, firstRule.Weight)
+	firstPart := fmt.Sprintf("base=%s,weight=%d", strategy.Base.String(), firstRule.Weight)
+	if strategy.Mode != "" {
+		firstPart += fmt.Sprintf(",mode=%s", strategy.Mode)
+	}
+	if strategy.BaseFrom != "" {
+		firstPart += fmt.Sprintf(",baseFrom=%s", strategy.BaseFrom)
+	}
+	if firstRule.Reserve > 0 {
+		firstPart += fmt.Sprintf(",reserve=%d", firstRule.Reserve)
+	}
+	if firstRule.Packing != "" {
+		firstPart += fmt.Sprintf(",packing=%s", firstRule.Packing)
+	}
+	if firstRule.SurgeTarget {
+		firstPart += ",surgeTarget=true"
+	}
+	if firstRule.Preemptible {
+		firstPart += ",preemptible=true"
+	}
+	if len(firstRule.Tolerations) > 0 {
+		firstPart += ",tolerations=" + tolerationsAnnotation(firstRule.Tolerations)
+	}
+	if len(firstRule.NodeAffinity) > 0 {
+		firstPart += ",nodeAffinity=" + nodeAffinityAnnotation(firstRule.NodeAffinity)
+	}
 
 	if len(firstRule.NodeSelector) > 0 {
 		nodeSelectorPart := ""
@@ -225,6 +520,24 @@ func (r *PodPlacementPolicyController) convertStrategyToAnnotation(strategy smar
 	// Additional rules
 	for _, rule := range strategy.Rules[1:] {
 		rulePart := fmt.Sprintf("weight=%d", rule.Weight)
+		if rule.Reserve > 0 {
+			rulePart += fmt.Sprintf(",reserve=%d", rule.Reserve)
+		}
+		if rule.Packing != "" {
+			rulePart += fmt.Sprintf(",packing=%s", rule.Packing)
+		}
+		if rule.SurgeTarget {
+			rulePart += ",surgeTarget=true"
+		}
+		if rule.Preemptible {
+			rulePart += ",preemptible=true"
+		}
+		if len(rule.Tolerations) > 0 {
+			rulePart += ",tolerations=" + tolerationsAnnotation(rule.Tolerations)
+		}
+		if len(rule.NodeAffinity) > 0 {
+			rulePart += ",nodeAffinity=" + nodeAffinityAnnotation(rule.NodeAffinity)
+		}
 
 		if len(rule.NodeSelector) > 0 {
 			nodeSelectorPart := ""
@@ -266,29 +579,46 @@ func (r *PodPlacementPolicyController) convertStrategyToAnnotation(strategy smar
 	return result, nil
 }
 
+// convertPriorityTiersToAnnotation converts priority tiers to the JSON priority-strategies annotation,
+// returning an empty string when the policy has no tiers
+func (r *PodPlacementPolicyController) convertPriorityTiersToAnnotation(tiers []smartschedulerv1.PriorityTierSpec) (string, error) {
+	if len(tiers) == 0 {
+		return "", nil
+	}
+
+	strategies := make(map[string]string)
+	for i, tier := range tiers {
+		strategyAnnotation, err := r.convertStrategyToAnnotation(tier.Strategy)
+		if err != nil {
+			return "", fmt.Errorf("priority tier %d: %w", i, err)
+		}
+		for _, priorityClassName := range tier.PriorityClassNames {
+			if _, exists := strategies[priorityClassName]; exists {
+				return "", webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("priority class %s appears in more than one tier", priorityClassName))
+			}
+			strategies[priorityClassName] = strategyAnnotation
+		}
+	}
+
+	data, err := json.Marshal(strategies)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // calculateDeploymentDrift calculates current placement drift for a deployment
 func (r *PodPlacementPolicyController) calculateDeploymentDrift(ctx context.Context, deployment *appsv1.Deployment, policy *smartschedulerv1.PodPlacementPolicy) (float64, error) {
 	// This is a simplified drift calculation
 	// In a full implementation, this would use the StateManager and RebalanceController logic
 
-	// Get pods for this deployment
-	podList := &corev1.PodList{}
-	labelSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
-
-	err := r.List(ctx, podList, &client.ListOptions{
-		Namespace:     deployment.Namespace,
-		LabelSelector: labelSelector,
-	})
+	// Only the total is needed here, so count from ReplicaSet status instead of listing pods
+	totalPods, err := webhook.CountDeploymentPods(ctx, r.Client, deployment)
 	if err != nil {
-		return 0, fmt.Errorf("failed to list pods: %w", err)
+		return 0, err
 	}
-
-	totalPods := 0
-	for _, pod := range podList.Items {
-		if pod.DeletionTimestamp == nil &&
-			(pod.Status.Phase == corev1.PodRunning || pod.Status.Phase == corev1.PodPending) {
-			totalPods++
-		}
+	if totalPods == 0 {
+		return 0.0, nil
 	}
 
 	// For simplicity, return 0 drift for now
@@ -322,6 +652,19 @@ func (r *PodPlacementPolicyController) updatePolicyStatus(ctx context.Context, p
 		LastUpdated:      &now,
 	}
 
+	if r.Experiments && policy.Spec.Experiment != nil {
+		// Keep the last summary if the arms cannot be counted
+		experiment, err := r.experimentStatus(ctx, policy, deploymentRefs)
+		if err != nil {
+			log.Error(err, "Failed to summarise experiment arms")
+		} else {
+			policy.Status.Experiment = experiment
+		}
+	} else {
+		policy.Status.Experiment = nil
+	}
+	restoreEvictions := r.takeExperimentEvictions(policy)
+
 	// Update conditions
 	condition := metav1.Condition{
 		Type:               "Ready",
@@ -331,12 +674,13 @@ func (r *PodPlacementPolicyController) updatePolicyStatus(ctx context.Context, p
 		LastTransitionTime: now,
 	}
 
-	// Update or add condition
-	policy.Status.Conditions = []metav1.Condition{condition}
+	// Update or add condition, keeping the preflight condition
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
 	policy.Status.ObservedGeneration = policy.Generation
 
 	err := r.Status().Update(ctx, policy)
 	if err != nil {
+		restoreEvictions()
 		log.Error(err, "Failed to update policy status")
 		return ctrl.Result{RequeueAfter: time.Minute}, err
 	}
@@ -358,17 +702,29 @@ func (r *PodPlacementPolicyController) handlePolicyDeletion(ctx context.Context,
 		log.Error(err, "Failed to list deployments for cleanup")
 		return ctrl.Result{}, err
 	}
+	writer, err := tenantWriter(r.Tenants, r.Client, policyKey.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
 	for _, deployment := range deploymentList.Items {
 		if deployment.Annotations != nil {
 			if policyName, exists := deployment.Annotations["smart-scheduler.io/policy-name"]; exists && policyName == policyKey.Name {
 				// Remove policy annotations
 				delete(deployment.Annotations, "smart-scheduler.io/schedule-strategy")
+				delete(deployment.Annotations, webhook.PriorityStrategiesAnnotation)
+				delete(deployment.Annotations, webhook.ExperimentAnnotation)
+				delete(deployment.Annotations, webhook.PropagationAnnotation)
+				delete(deployment.Annotations, webhook.PodSelectorAnnotation)
+				delete(deployment.Annotations, webhook.RuleDisruptionBudgetAnnotation)
+				delete(deployment.Annotations, webhook.BasePriorityClassAnnotation)
+				delete(deployment.Annotations, webhook.MigrationAnnotation)
+				delete(deployment.Annotations, webhook.RuleRenamesAnnotation)
 				delete(deployment.Annotations, "smart-scheduler.io/policy-name")
 				delete(deployment.Annotations, "smart-scheduler.io/policy-priority")
 				delete(deployment.Annotations, "smart-scheduler.io/policy-applied")
 
-				err = r.Update(ctx, &deployment)
+				err = writer.Update(ctx, &deployment)
 				if err != nil {
 					log.Error(err, "Failed to clean up deployment annotations", "deployment", deployment.Name)
 				} else {
@@ -388,14 +744,30 @@ func (r *PodPlacementPolicyController) SetupWithManager(mgr ctrl.Manager) error
 		r.StateManager = webhook.NewStateManager(mgr.GetClient(), r.Log.WithName("StateManager"))
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	if r.MaxConcurrentReconciles == 0 {
+		r.MaxConcurrentReconciles = 2
+	}
+
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&smartschedulerv1.PodPlacementPolicy{}).
 		Watches(
 			&appsv1.Deployment{},
 			handler.EnqueueRequestsFromMapFunc(r.mapDeploymentToPolicy),
-		).
+		)
+	if r.Experiments {
+		// Evicted pods are counted as they are deleted; the next status update records them
+		r.evictions = newExperimentEvictions()
+		builder = builder.Watches(&corev1.Pod{}, r.evictions.handler())
+	}
+	if r.RuleRekey {
+		// Relabeled nodes are remembered so the rules selecting them by old labels can be renamed
+		r.relabels = newNodeRelabels()
+		builder = builder.Watches(&corev1.Node{}, r.relabels.handler(mgr.GetClient()))
+	}
+
+	return builder.
 		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 2,
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
 		}).
 		Complete(r)
 }
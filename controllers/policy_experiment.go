@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// experimentKey identifies an experiment arm in a namespace
+type experimentKey struct {
+	namespace, experiment, arm string
+}
+
+// experimentEvictions counts evicted pods of experiment arms until the policy status records them.
+// Evicted pods are gone by the next reconcile, so they are counted when their deletion is observed.
+type experimentEvictions struct {
+	mu      sync.Mutex
+	pending map[experimentKey]int32
+}
+
+// newExperimentEvictions creates an empty eviction counter
+func newExperimentEvictions() *experimentEvictions {
+	return &experimentEvictions{pending: make(map[experimentKey]int32)}
+}
+
+// record counts the eviction of a pod admitted under an experiment
+func (e *experimentEvictions) record(pod *corev1.Pod) {
+	name, arm := pod.Labels[webhook.ExperimentLabel], pod.Labels[webhook.ExperimentArmLabel]
+	if name == "" || arm == "" || !podEvicted(pod) {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[experimentKey{pod.Namespace, name, arm}]++
+}
+
+// take returns and clears the evictions counted for an experiment arm
+func (e *experimentEvictions) take(namespace, experiment, arm string) int32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := experimentKey{namespace, experiment, arm}
+	count := e.pending[key]
+	delete(e.pending, key)
+	return count
+}
+
+// restore puts back evictions taken for a status update that failed
+func (e *experimentEvictions) restore(namespace, experiment, arm string, count int32) {
+	if count == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[experimentKey{namespace, experiment, arm}] += count
+}
+
+// handler returns an event handler counting evicted experiment pods as they are deleted
+func (e *experimentEvictions) handler() handler.EventHandler {
+	return handler.Funcs{
+		DeleteFunc: func(_ context.Context, evt event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+			if pod, ok := evt.Object.(*corev1.Pod); ok {
+				e.record(pod)
+			}
+		},
+	}
+}
+
+// podEvicted reports whether the pod was evicted through the Eviction API or by the kubelet
+func podEvicted(pod *corev1.Pod) bool {
+	if pod.Status.Reason == "Evicted" {
+		return true
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.DisruptionTarget && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// convertExperimentToAnnotation converts the policy's experiment to the experiment annotation
+func (r *PodPlacementPolicyController) convertExperimentToAnnotation(experiment *smartschedulerv1.PlacementExperimentSpec) (string, error) {
+	strategy, err := r.convertStrategyToAnnotation(experiment.Strategy)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(webhook.Experiment{
+		Name:       experiment.Name,
+		Percentage: int(experiment.Percentage),
+		Strategy:   strategy,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// experimentStatus summarises the pods of each arm of the policy's experiment across the deployments
+// it was applied to. Pods admitted before the experiment started count toward the control arm.
+func (r *PodPlacementPolicyController) experimentStatus(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy, deploymentRefs []smartschedulerv1.DeploymentReference) (*smartschedulerv1.ExperimentStatus, error) {
+	experiment := policy.Spec.Experiment
+	status := &smartschedulerv1.ExperimentStatus{Name: experiment.Name}
+	previous := map[string]int32{}
+	if policy.Status.Experiment != nil && policy.Status.Experiment.Name == experiment.Name {
+		status.StartedAt = policy.Status.Experiment.StartedAt
+		for _, arm := range policy.Status.Experiment.Arms {
+			previous[arm.Arm] = arm.Evictions
+		}
+	}
+	if status.StartedAt == nil {
+		now := metav1.Now()
+		status.StartedAt = &now
+	}
+
+	type armTotals struct {
+		pods, restarts, scheduled int32
+		pending                   time.Duration
+	}
+	totals := map[string]*armTotals{webhook.ArmControl: {}, webhook.ArmCandidate: {}}
+	for _, ref := range deploymentRefs {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, deployment); err != nil {
+			return nil, err
+		}
+		pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment, client.UnsafeDisableDeepCopy)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			arm := totals[webhook.ExperimentArm(pod, &webhook.Experiment{Name: experiment.Name})]
+			arm.pods++
+			for _, container := range pod.Status.ContainerStatuses {
+				arm.restarts += container.RestartCount
+			}
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionTrue {
+					arm.scheduled++
+					arm.pending += condition.LastTransitionTime.Sub(pod.CreationTimestamp.Time)
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{webhook.ArmControl, webhook.ArmCandidate} {
+		arm := totals[name]
+		armStatus := smartschedulerv1.ExperimentArmStatus{
+			Arm:       name,
+			Pods:      arm.pods,
+			Restarts:  arm.restarts,
+			Evictions: previous[name],
+		}
+		if arm.scheduled > 0 {
+			armStatus.AveragePendingTime = metav1.Duration{Duration: (arm.pending / time.Duration(arm.scheduled)).Round(time.Second)}
+		}
+		status.Arms = append(status.Arms, armStatus)
+	}
+	return status, nil
+}
+
+// takeExperimentEvictions adds the evictions observed since the last status update to the status,
+// returning a function that puts them back if the update fails
+func (r *PodPlacementPolicyController) takeExperimentEvictions(policy *smartschedulerv1.PodPlacementPolicy) func() {
+	status := policy.Status.Experiment
+	if r.evictions == nil || status == nil {
+		return func() {}
+	}
+
+	taken := make([]int32, len(status.Arms))
+	for i := range status.Arms {
+		taken[i] = r.evictions.take(policy.Namespace, status.Name, status.Arms[i].Arm)
+		status.Arms[i].Evictions += taken[i]
+	}
+	return func() {
+		for i := range status.Arms {
+			r.evictions.restore(policy.Namespace, status.Name, status.Arms[i].Arm, taken[i])
+		}
+	}
+}
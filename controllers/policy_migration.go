@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// MigratedPolicyLabel is set on migrated deployments to the name of the policy generated for
+	// them; the policy selects its deployments by it
+	MigratedPolicyLabel = "smart-scheduler.io/migrated-policy"
+	// policyNameAnnotation names the policy a deployment's strategy annotations are applied from
+	policyNameAnnotation = "smart-scheduler.io/policy-name"
+	// migratedPolicyPrefix starts the names of generated policies
+	migratedPolicyPrefix = "migrated-"
+)
+
+var policyMigrations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_policy_migrations_total",
+		Help: "Deployments with strategy annotations handled by the policy migration, by namespace and result (migrated, unconvertible or limited)",
+	},
+	[]string{"namespace", "result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(policyMigrations)
+}
+
+// errPolicyLimit is returned when the generated policy would exceed the namespace's policy limit
+var errPolicyLimit = errors.New("namespace has the maximum number of policies")
+
+// PolicyMigrationController moves deployments configured with raw strategy annotations to
+// PodPlacementPolicies. Each distinct strategy of a namespace gets one generated policy selecting
+// its deployments by MigratedPolicyLabel, and each deployment is marked as applied from that policy,
+// after which the PodPlacementPolicyController owns its strategy annotations. A deployment is only
+// migrated when the policy converts back to the strategy it already has, so placements do not change.
+type PolicyMigrationController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Namespaces lists namespaces whose deployments are never migrated; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, creates policies and updates deployments impersonating a service account of their namespace
+	Tenants *TenantClients
+	// Limits, if set, keeps the migration from creating more policies than a namespace may have
+	Limits *ScopeLimits
+}
+
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=podplacementpolicies,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update
+
+// Reconcile migrates a deployment with strategy annotations that no policy applied
+func (r *PolicyMigrationController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("deployment", req.NamespacedName)
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, req.NamespacedName, deployment); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !migratable(deployment) || r.Namespaces.Protected(deployment.Namespace) {
+		return ctrl.Result{}, nil
+	}
+	// Experiments are run from policies, so an annotated one is left to finish first
+	if _, ok := deployment.Annotations[webhook.ExperimentAnnotation]; ok {
+		log.Info("Placement experiment running, not migrating the deployment yet")
+		return ctrl.Result{}, nil
+	}
+
+	spec, err := policySpecFromAnnotations(deployment.Annotations)
+	if err != nil {
+		log.Info("Strategy annotations cannot be expressed as a policy, leaving them", "reason", err.Error())
+		policyMigrations.WithLabelValues(deployment.Namespace, "unconvertible").Inc()
+		return ctrl.Result{}, nil
+	}
+	name, err := migratedPolicyName(spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.ensurePolicy(ctx, writer, deployment.Namespace, name, spec); err != nil {
+		if errors.Is(err, errPolicyLimit) {
+			log.Info("Namespace has the maximum number of policies, not migrating the deployment", "policy", name)
+			policyMigrations.WithLabelValues(deployment.Namespace, "limited").Inc()
+			return ctrl.Result{RequeueAfter: time.Minute * 30}, nil
+		}
+		return resultForError("policymigration", err, log)
+	}
+
+	// The label brings the deployment under the policy's selector, and the policy-name annotation
+	// hands its strategy annotations to the PodPlacementPolicyController
+	if deployment.Labels == nil {
+		deployment.Labels = make(map[string]string)
+	}
+	deployment.Labels[MigratedPolicyLabel] = name
+	deployment.Annotations[policyNameAnnotation] = name
+	if err := writer.Update(ctx, deployment); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to mark deployment as migrated: %w", err)
+	}
+
+	log.Info("Migrated strategy annotations to a PodPlacementPolicy", "policy", name)
+	policyMigrations.WithLabelValues(deployment.Namespace, "migrated").Inc()
+	return ctrl.Result{}, nil
+}
+
+// ensurePolicy creates the generated policy unless it exists. A policy of that name that was not
+// generated by the migration is never taken over.
+func (r *PolicyMigrationController) ensurePolicy(ctx context.Context, writer client.Client, namespace, name string, spec *smartschedulerv1.PodPlacementPolicySpec) error {
+	existing := &smartschedulerv1.PodPlacementPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, existing)
+	if err == nil {
+		if existing.Labels[MigratedPolicyLabel] != name {
+			return webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("policy %s exists and was not generated by the migration", name))
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get policy %s: %w", name, err)
+	}
+	allowed, err := r.Limits.PolicyCreationAllowed(ctx, r.Client, namespace)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errPolicyLimit
+	}
+
+	policy := &smartschedulerv1.PodPlacementPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{MigratedPolicyLabel: name},
+		},
+		Spec: *spec,
+	}
+	policy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{MigratedPolicyLabel: name}}
+	if err := writer.Create(ctx, policy); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create policy %s: %w", name, err)
+	}
+	return nil
+}
+
+// migratable reports whether the deployment has a default strategy annotation no policy applied
+func migratable(deployment *appsv1.Deployment) bool {
+	if deployment.DeletionTimestamp != nil {
+		return false
+	}
+	if _, ok := deployment.Annotations[policyNameAnnotation]; ok {
+		return false
+	}
+	return deployment.Annotations[webhook.ScheduleStrategyAnnotation] != ""
+}
+
+// migratedPolicyName derives the policy name from the spec, so deployments of a namespace with the
+// same strategy share a policy
+func migratedPolicyName(spec *smartschedulerv1.PodPlacementPolicySpec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return fmt.Sprintf("%s%016x", migratedPolicyPrefix, hash.Sum64()), nil
+}
+
+// policySpecFromAnnotations converts the strategy, priority tier, propagation, pod selector, rule
+// PodDisruptionBudget, base PriorityClass and migration annotations into a policy spec without a
+// selector. It fails, classified as ErrStrategyInvalid, when the policy would not apply the same
+// strategies back.
+func policySpecFromAnnotations(annotations map[string]string) (*smartschedulerv1.PodPlacementPolicySpec, error) {
+	strategy, err := webhook.ParsePlacementStrategy(annotations[webhook.ScheduleStrategyAnnotation])
+	if err != nil {
+		return nil, err
+	}
+	spec := &smartschedulerv1.PodPlacementPolicySpec{Strategy: strategySpec(strategy), Enabled: true}
+
+	var tiers map[string]string
+	if data, ok := annotations[webhook.PriorityStrategiesAnnotation]; ok {
+		if tiers, err = webhook.ParsePriorityStrategies(data); err != nil {
+			return nil, err
+		}
+		if spec.PriorityTiers, err = priorityTierSpecs(tiers); err != nil {
+			return nil, err
+		}
+	}
+
+	if data, ok := annotations[webhook.PropagationAnnotation]; ok {
+		propagation, err := webhook.ParsePropagation(data)
+		if err != nil {
+			return nil, webhook.Classify(webhook.ErrStrategyInvalid, err)
+		}
+		spec.Propagation = &smartschedulerv1.PlacementPropagationSpec{
+			Env:               propagation.Env,
+			Annotations:       propagation.Annotations,
+			Labels:            propagation.Labels,
+			CapacityTypeLabel: propagation.CapacityTypeLabel,
+			ExcludeContainers: propagation.ExcludeContainers,
+		}
+	}
+
+	spec.RuleDisruptionBudget = annotations[webhook.RuleDisruptionBudgetAnnotation] == "true"
+	spec.BasePriorityClassName = annotations[webhook.BasePriorityClassAnnotation]
+
+	if data, ok := annotations[webhook.MigrationAnnotation]; ok {
+		migration, err := webhook.ParseMigration(data)
+		if err != nil {
+			return nil, err
+		}
+		spec.Migration = &smartschedulerv1.PlacementMigrationSpec{
+			StepPercent: int32(migration.StepPercent),
+			Interval:    migration.Interval,
+		}
+	}
+
+	if data, ok := annotations[webhook.PodSelectorAnnotation]; ok {
+		if spec.PodSelector, err = metav1.ParseToLabelSelector(data); err != nil {
+			return nil, webhook.Classify(webhook.ErrStrategyInvalid, err)
+		}
+	}
+
+	if err := verifyRoundTrip(spec, strategy, tiers); err != nil {
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, err)
+	}
+	return spec, nil
+}
+
+// priorityTierSpecs groups priority classes with the same strategy into one tier each, in a stable order
+func priorityTierSpecs(tiers map[string]string) ([]smartschedulerv1.PriorityTierSpec, error) {
+	classes := make(map[string][]string)
+	for priorityClassName, annotation := range tiers {
+		classes[annotation] = append(classes[annotation], priorityClassName)
+	}
+	annotations := make([]string, 0, len(classes))
+	for annotation := range classes {
+		annotations = append(annotations, annotation)
+	}
+	sort.Strings(annotations)
+
+	specs := make([]smartschedulerv1.PriorityTierSpec, 0, len(annotations))
+	for _, annotation := range annotations {
+		strategy, err := webhook.ParsePlacementStrategy(annotation)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(classes[annotation])
+		specs = append(specs, smartschedulerv1.PriorityTierSpec{PriorityClassNames: classes[annotation], Strategy: strategySpec(strategy)})
+	}
+	return specs, nil
+}
+
+// strategySpec converts a parsed strategy into its policy form
+func strategySpec(strategy *webhook.PlacementStrategy) smartschedulerv1.PlacementStrategySpec {
+	spec := smartschedulerv1.PlacementStrategySpec{
+		Base:     intstr.FromInt(strategy.Base),
+		BaseFrom: strategy.BaseFrom,
+		Mode:     strategy.Mode,
+		Rules:    make([]smartschedulerv1.PlacementRuleSpec, 0, len(strategy.Rules)),
+	}
+	if strategy.BasePercent > 0 {
+		spec.Base = intstr.FromString(strconv.Itoa(strategy.BasePercent) + "%")
+	}
+	for _, rule := range strategy.Rules {
+		ruleSpec := smartschedulerv1.PlacementRuleSpec{
+			Weight:       rule.Weight,
+			NodeSelector: rule.NodeSelector,
+			Reserve:      rule.Reserve,
+			Packing:      rule.Packing,
+			SurgeTarget:  rule.SurgeTarget,
+			Preemptible:  rule.Preemptible,
+		}
+		for _, toleration := range rule.Tolerations {
+			ruleSpec.Tolerations = append(ruleSpec.Tolerations, smartschedulerv1.TolerationSpec{
+				Key:      toleration.Key,
+				Operator: string(toleration.Operator),
+				Value:    toleration.Value,
+				Effect:   string(toleration.Effect),
+			})
+		}
+		for _, requirement := range rule.NodeAffinity {
+			ruleSpec.NodeAffinity = append(ruleSpec.NodeAffinity, smartschedulerv1.NodeAffinityExpressionSpec{
+				Key:      requirement.Key,
+				Operator: string(requirement.Operator),
+				Values:   append([]string(nil), requirement.Values...),
+			})
+		}
+		for _, affinity := range rule.Affinity {
+			ruleSpec.Affinity = append(ruleSpec.Affinity, smartschedulerv1.AffinityRuleSpec{
+				Type:                     affinity.Type,
+				LabelSelector:            affinity.LabelSelector,
+				TopologyKey:              affinity.TopologyKey,
+				RequiredDuringScheduling: affinity.RequiredDuringScheduling,
+			})
+		}
+		spec.Rules = append(spec.Rules, ruleSpec)
+	}
+	return spec
+}
+
+// verifyRoundTrip checks that the PodPlacementPolicyController would write annotations parsing to
+// the same strategies the deployment has
+func verifyRoundTrip(spec *smartschedulerv1.PodPlacementPolicySpec, strategy *webhook.PlacementStrategy, tiers map[string]string) error {
+	policies := &PodPlacementPolicyController{}
+	annotation, err := policies.convertStrategyToAnnotation(spec.Strategy)
+	if err != nil {
+		return err
+	}
+	if err := sameStrategy(annotation, strategy); err != nil {
+		return err
+	}
+
+	tiersData, err := policies.convertPriorityTiersToAnnotation(spec.PriorityTiers)
+	if err != nil || tiersData == "" {
+		return err
+	}
+	converted, err := webhook.ParsePriorityStrategies(tiersData)
+	if err != nil {
+		return err
+	}
+	if len(converted) != len(tiers) {
+		return fmt.Errorf("priority tiers changed in conversion")
+	}
+	for priorityClassName, annotation := range tiers {
+		original, err := webhook.ParsePlacementStrategy(annotation)
+		if err != nil {
+			return err
+		}
+		if err := sameStrategy(converted[priorityClassName], original); err != nil {
+			return fmt.Errorf("priority class %s: %w", priorityClassName, err)
+		}
+	}
+	return nil
+}
+
+// sameStrategy reports an error when the annotation does not parse to the strategy
+func sameStrategy(annotation string, strategy *webhook.PlacementStrategy) error {
+	converted, err := webhook.ParsePlacementStrategy(annotation)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(converted, strategy) {
+		return fmt.Errorf("strategy changes in conversion to %q", annotation)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *PolicyMigrationController) SetupWithManager(mgr ctrl.Manager) error {
+	// Only deployments that carry an annotation strategy of their own need migrating
+	annotated := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		deployment, ok := obj.(*appsv1.Deployment)
+		return ok && migratable(deployment)
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("policymigration").
+		For(&appsv1.Deployment{}, builder.WithPredicates(annotated)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const migratedStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// expectedPolicyName returns the name of the policy the migration generates for the annotations
+func expectedPolicyName(t *testing.T, annotations map[string]string) string {
+	t.Helper()
+	spec, err := policySpecFromAnnotations(annotations)
+	if err != nil {
+		t.Fatalf("policySpecFromAnnotations() error = %v", err)
+	}
+	name, err := migratedPolicyName(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestPolicyMigrationReconcile(t *testing.T) {
+	strategy := map[string]string{webhook.ScheduleStrategyAnnotation: migratedStrategy}
+	name := expectedPolicyName(t, strategy)
+	otherPolicy := &smartschedulerv1.PodPlacementPolicy{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		existing    []client.Object
+		limits      *ScopeLimits
+		// policies are the names of the namespace's policies after the reconcile
+		policies []string
+		migrated bool
+		requeue  time.Duration
+	}{
+		{
+			name:        "Deployment with a strategy is migrated",
+			annotations: strategy,
+			policies:    []string{name},
+			migrated:    true,
+		},
+		{
+			name:        "Generated policy of another deployment is shared",
+			annotations: strategy,
+			existing: []client.Object{&smartschedulerv1.PodPlacementPolicy{ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "default", Labels: map[string]string{MigratedPolicyLabel: name},
+			}}},
+			policies: []string{name},
+			migrated: true,
+		},
+		{
+			name: "Deployment applied from a policy is left alone",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: migratedStrategy,
+				policyNameAnnotation:               "web",
+			},
+		},
+		{
+			name:        "Strategy that cannot be expressed as a policy is left alone",
+			annotations: map[string]string{webhook.ScheduleStrategyAnnotation: "weight=one,nodeSelector=node-type:spot"},
+		},
+		{
+			name: "Running experiment is left to finish",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: migratedStrategy,
+				webhook.ExperimentAnnotation:       "{}",
+			},
+		},
+		{
+			name:        "Namespace at the policy limit is retried later",
+			annotations: strategy,
+			existing:    []client.Object{otherPolicy},
+			limits:      NewScopeLimits(0, 0, 1),
+			policies:    []string{"batch"},
+			requeue:     30 * time.Minute,
+		},
+		{
+			name:        "Namesake policy not generated by the migration is not taken over",
+			annotations: strategy,
+			existing: []client.Object{&smartschedulerv1.PodPlacementPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			}},
+			policies: []string{name},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment, objects := testWorkload("web", tt.annotations, "ondemand", "spot")
+			c := newFakeClient(t, append(objects, tt.existing...)...)
+			r := &PolicyMigrationController{Client: c, Log: logr.Discard(), Limits: tt.limits}
+			ctx := context.Background()
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.RequeueAfter != tt.requeue {
+				t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, tt.requeue)
+			}
+
+			policies := &smartschedulerv1.PodPlacementPolicyList{}
+			if err := c.List(ctx, policies, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, policy := range policies.Items {
+				names = append(names, policy.Name)
+			}
+			if !reflect.DeepEqual(names, tt.policies) {
+				t.Errorf("Policies = %v, want %v", names, tt.policies)
+			}
+
+			current := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), current); err != nil {
+				t.Fatal(err)
+			}
+			migrated := current.Labels[MigratedPolicyLabel] == name && current.Annotations[policyNameAnnotation] == name
+			if migrated != tt.migrated {
+				t.Errorf("Deployment migrated = %v, want %v (labels %v, annotations %v)",
+					migrated, tt.migrated, current.Labels, current.Annotations)
+			}
+			if !tt.migrated && current.Labels[MigratedPolicyLabel] != "" {
+				t.Errorf("Expected no %s label on a deployment left alone, got %v", MigratedPolicyLabel, current.Labels)
+			}
+		})
+	}
+}
+
+func TestPolicyMigrationGeneratesSelectingPolicy(t *testing.T) {
+	strategy := map[string]string{webhook.ScheduleStrategyAnnotation: migratedStrategy}
+	deployment, objects := testWorkload("web", strategy, "ondemand", "spot")
+	c := newFakeClient(t, objects...)
+	r := &PolicyMigrationController{Client: c, Log: logr.Discard()}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	name := expectedPolicyName(t, strategy)
+	policy := &smartschedulerv1.PodPlacementPolicy{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, policy); err != nil {
+		t.Fatalf("Expected the generated policy %s, got %v", name, err)
+	}
+	if policy.Labels[MigratedPolicyLabel] != name {
+		t.Errorf("Policy labels = %v, want %s=%s", policy.Labels, MigratedPolicyLabel, name)
+	}
+	if want := map[string]string{MigratedPolicyLabel: name}; policy.Spec.Selector == nil || !reflect.DeepEqual(policy.Spec.Selector.MatchLabels, want) {
+		t.Errorf("Policy selector = %v, want the deployments labeled %v", policy.Spec.Selector, want)
+	}
+	if !policy.Spec.Enabled {
+		t.Error("Expected the generated policy enabled")
+	}
+
+	// The policy applies the deployment's strategy back unchanged
+	applied, err := webhook.ParsePlacementStrategy(migratedStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRoundTrip(&policy.Spec, applied, nil); err != nil {
+		t.Errorf("Generated policy does not round-trip: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// RuleMatchesNoNodesCondition is set on a policy when a rule's nodeSelector selects no existing node
+const RuleMatchesNoNodesCondition = "RuleMatchesNoNodes"
+
+// preflightCondition checks that every rule of the policy, including its priority tiers, selects at
+// least one node. Rules without a nodeSelector match any node and are not checked.
+func (r *PodPlacementPolicyController) preflightCondition(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) (metav1.Condition, error) {
+	strategies := map[string]smartschedulerv1.PlacementStrategySpec{"strategy": policy.Spec.Strategy}
+	for i, tier := range policy.Spec.PriorityTiers {
+		strategies[fmt.Sprintf("priorityTiers[%d].strategy", i)] = tier.Strategy
+	}
+
+	var unmatched []string
+	for path, strategy := range strategies {
+		for i, rule := range strategy.Rules {
+			if len(rule.NodeSelector) == 0 {
+				continue
+			}
+
+			nodeList := &corev1.NodeList{}
+			err := r.List(ctx, nodeList, client.MatchingLabels(rule.NodeSelector))
+			if err != nil {
+				return metav1.Condition{}, fmt.Errorf("failed to list nodes: %w", err)
+			}
+			if len(nodeList.Items) > 0 {
+				continue
+			}
+
+			name := fmt.Sprintf("%s.rules[%d]", path, i)
+			if rule.Name != "" {
+				name += fmt.Sprintf(" (%s)", rule.Name)
+			}
+			unmatched = append(unmatched, fmt.Sprintf("%s selects %v", name, rule.NodeSelector))
+		}
+	}
+
+	if len(unmatched) == 0 {
+		return metav1.Condition{
+			Type:               RuleMatchesNoNodesCondition,
+			Status:             metav1.ConditionFalse,
+			Reason:             "AllRulesMatchNodes",
+			Message:            "Every rule's nodeSelector matches at least one node",
+			ObservedGeneration: policy.Generation,
+		}, nil
+	}
+
+	sort.Strings(unmatched)
+	return metav1.Condition{
+		Type:               RuleMatchesNoNodesCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "NoMatchingNodes",
+		Message:            "No node matches: " + strings.Join(unmatched, "; "),
+		ObservedGeneration: policy.Generation,
+	}, nil
+}
+
+// applyPreflight records the preflight result on the policy status, or drops it when preflight is off
+func (r *PodPlacementPolicyController) applyPreflight(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) error {
+	if !r.Preflight {
+		meta.RemoveStatusCondition(&policy.Status.Conditions, RuleMatchesNoNodesCondition)
+		return nil
+	}
+
+	condition, err := r.preflightCondition(ctx, policy)
+	if err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	return nil
+}
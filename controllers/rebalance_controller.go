@@ -2,17 +2,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -30,47 +34,100 @@ type RebalanceController struct {
 	Log          logr.Logger
 	Scheme       *runtime.Scheme
 	StateManager *webhook.StateManager
+	PoolHealth   *webhook.PoolHealthChecker
+	Maintenance  *webhook.MaintenanceTracker
+	// MaxConcurrentReconciles limits parallel reconciles (default: 1)
+	MaxConcurrentReconciles int
+	// ImageInspector must match the webhook's so both agree on which rules a pod may use
+	ImageInspector webhook.ImageInspector
+	// DebounceWindow collapses pod events for the same deployment into one reconcile (default: 10s)
+	DebounceWindow time.Duration
+	// Owners maps pod events to their parent deployment
+	Owners *webhook.OwnerResolver
+	// StrategyChangeGracePeriod delays evictions after a strategy edit (0: none)
+	StrategyChangeGracePeriod time.Duration
+	// MaxEvictionsPerStrategyChange caps the pods evicted to roll out one strategy edit (0: unlimited)
+	MaxEvictionsPerStrategyChange int
+
+	// Namespaces lists namespaces whose pods are never evicted; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, evicts pods impersonating a service account of their namespace
+	Tenants *TenantClients
+	// ManualOverrides, if set, defers rebalancing after pods were moved by hand
+	ManualOverrides *ManualOverrideTracker
+	// SlowStart, if set, drops preemptible rules for slow-starting pods like the webhook does
+	SlowStart *webhook.SlowStartDetector
+	// ScaleDownHints lowers the deletion cost of pods on over-represented rules so scale-downs
+	// remove them first
+	ScaleDownHints bool
+	// Unschedulable, if set, holds rebalancing while pods are stuck Unschedulable, since the webhook
+	// spills new pods away from their rules
+	Unschedulable *webhook.UnschedulableSpill
+	// Limits, if set, caps the evictions per hour across all deployments
+	Limits *ScopeLimits
+	// EvictPreBound lets drift evict pods bound at creation, which the webhook could not place and
+	// whose replacements may be bound to the same node again
+	EvictPreBound bool
+	// WeightTuning measures drift against the weights the weight tuning controller lowered, like the
+	// webhook places pods with them
+	WeightTuning bool
+	// SpotScores measures drift against the weights the spot score controller lowered for poorly
+	// scored spot pools
+	SpotScores bool
+	// StatefulSets rebalances the pods of StatefulSets too, evicting only pods off their ordinal's rule
+	StatefulSets bool
+
+	// limitsMu guards the strategy change limits, which can be reloaded while running
+	limitsMu sync.RWMutex
 }
 
+// defaultDebounceWindow is how long pod events for a deployment are coalesced before reconciling
+const defaultDebounceWindow = 10 * time.Second
+
 // DriftReport represents placement drift for a deployment
 type DriftReport struct {
-	DeploymentName      string         `json:"deploymentName"`
-	DeploymentNamespace string         `json:"deploymentNamespace"`
-	ExpectedCounts      map[string]int `json:"expectedCounts"`
-	ActualCounts        map[string]int `json:"actualCounts"`
-	DriftPercentage     float64        `json:"driftPercentage"`
-	RequiresRebalance   bool           `json:"requiresRebalance"`
-	Timestamp           time.Time      `json:"timestamp"`
+	DeploymentName      string                  `json:"deploymentName"`
+	DeploymentNamespace string                  `json:"deploymentNamespace"`
+	ExpectedCounts      map[webhook.RuleKey]int `json:"expectedCounts"`
+	ActualCounts        map[webhook.RuleKey]int `json:"actualCounts"`
+	DriftPercentage     float64                 `json:"driftPercentage"`
+	RequiresRebalance   bool                    `json:"requiresRebalance"`
+	// UnschedulableCounts are the pods per rule stuck Unschedulable, counted in ActualCounts too
+	UnschedulableCounts map[webhook.RuleKey]int `json:"unschedulableCounts,omitempty"`
+	// MinOnDemand is the number of pods the on-demand rules must keep, from the deployment's
+	// min-on-demand annotation
+	MinOnDemand int       `json:"minOnDemand,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
 }
 
-//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=maintenancewindows,verbs=get;list;watch
 
-// ruleToString converts a placement rule to a string key for tracking
-func ruleToString(rule webhook.PlacementRule) string {
-	return nodeSelector2String(rule.NodeSelector)
+// Reconcile handles rebalancing requests and placement drift detection
+func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, webhook.WorkloadDeployment)
 }
 
-// nodeSelector2String converts a nodeSelector map to a string key for tracking
-func nodeSelector2String(nodeSelector map[string]string) string {
-	if len(nodeSelector) == 0 {
-		return ""
-	}
-
-	var parts []string
-	for key, value := range nodeSelector {
-		parts = append(parts, fmt.Sprintf("%s=%s", key, value))
-	}
+// statefulSetRebalancer reconciles StatefulSets with the RebalanceController
+type statefulSetRebalancer struct {
+	*RebalanceController
+}
 
-	return fmt.Sprintf("%v", parts) // Simple string representation
+// Reconcile handles rebalancing requests and placement drift detection for a StatefulSet
+func (r statefulSetRebalancer) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, webhook.WorkloadStatefulSet)
 }
 
-// Reconcile handles rebalancing requests and placement drift detection
-func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+// reconcile checks the placement drift of the Deployment or StatefulSet and rebalances its pods
+func (r *RebalanceController) reconcile(ctx context.Context, req ctrl.Request, kind string) (ctrl.Result, error) {
 	startTime := time.Now()
-	log := r.Log.WithValues("rebalance", req.NamespacedName, "reconcileID", generateRebalanceReconcileID())
+	log := r.Log.WithValues("rebalance", req.NamespacedName, "kind", kind, "reconcileID", generateRebalanceReconcileID())
 
 	// Add comprehensive reconciliation logging
 	log.Info("=== REBALANCE RECONCILE START ===",
@@ -85,10 +142,21 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 			"durationMs", duration.Milliseconds())
 	}()
 
+	if r.Namespaces.Protected(req.Namespace) {
+		log.Info("Namespace is protected, skipping rebalance")
+		return ctrl.Result{}, nil
+	}
+
 	// Check if this is a Deployment or Pod event
-	deployment := &appsv1.Deployment{}
-	err := r.Get(ctx, req.NamespacedName, deployment)
+	deployment, err := webhook.GetWorkload(ctx, r.Client, kind, req.NamespacedName)
 	if err != nil {
+		if apierrors.IsNotFound(err) && kind == webhook.WorkloadStatefulSet {
+			log.Info("StatefulSet not found, cleaning up placement state")
+			if err := r.StateManager.DeleteStatefulSetState(ctx, req.Namespace, req.Name); err != nil {
+				log.Error(err, "Failed to delete placement state")
+			}
+			return ctrl.Result{}, nil
+		}
 		if apierrors.IsNotFound(err) {
 			log.Info("Deployment not found, likely deleted")
 			// Object deleted, clean up state
@@ -121,7 +189,10 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 		"hasPolicyName", deployment.Annotations["smart-scheduler.io/policy-name"] != "",
 		"hasProcessed", deployment.Annotations["smart-scheduler.io/processed"] != "")
 
-	scheduleStrategy, exists := deployment.Annotations["smart-scheduler.io/schedule-strategy"]
+	scheduleStrategy, exists, err := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+	if err != nil {
+		log.Error(err, "Ignoring priority strategies, using default schedule strategy")
+	}
 	if !exists {
 		log.Info("No schedule strategy annotation found, skipping rebalance")
 		return ctrl.Result{}, nil
@@ -129,22 +200,85 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	log.Info("Processing rebalance check for deployment", "strategy", scheduleStrategy)
 
+	// Strategy edits are rolled out gradually: after a grace period and within an eviction budget
+	rollout, err := r.observeStrategyChange(ctx, deployment, scheduleStrategy)
+	if err != nil {
+		log.Error(err, "Failed to track strategy change")
+		return ctrl.Result{}, err
+	}
+
 	// Parse the strategy
-	strategy, err := webhook.ParsePlacementStrategy(scheduleStrategy)
+	strategy, err := webhook.ParsePlacementStrategyCached(scheduleStrategy)
 	if err != nil {
 		log.Error(err, "Failed to parse placement strategy")
-		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+		return resultForError("rebalance", err, log)
+	}
+	strategy, err = webhook.ResolveBase(ctx, r.Client, deployment, strategy)
+	if err != nil {
+		log.Error(err, "Failed to derive the base, using the configured base", "baseFrom", strategy.BaseFrom)
+	}
+	if r.WeightTuning {
+		if adjustments, err := webhook.ParseWeightAdjustments(deployment.Annotations[webhook.WeightAdjustmentsAnnotation]); err != nil {
+			log.Error(err, "Using the configured weights")
+		} else {
+			strategy = webhook.ApplyWeightAdjustments(strategy, adjustments)
+		}
+	}
+	if r.SpotScores {
+		if strategy, err = webhook.WithSpotScoreWeights(deployment, strategy); err != nil {
+			log.Error(err, "Ignoring spot placement scores")
+		}
+	}
+	if strategy, err = webhook.WithRuleRenames(deployment, strategy); err != nil {
+		log.Error(err, "Ignoring rule renames")
 	}
 
 	log.Info("Parsed strategy for rebalance",
 		"base", strategy.Base,
 		"rulesCount", len(strategy.Rules))
 
-	// Get current placement state
+	// Drop rules whose nodes cannot run the deployment's pods
+	templatePod := &corev1.Pod{
+		ObjectMeta: deployment.Spec.Template.ObjectMeta,
+		Spec:       deployment.Spec.Template.Spec,
+	}
+	platform, err := webhook.ResolvePlatform(ctx, r.ImageInspector, templatePod)
+	if err != nil {
+		log.Info("Skipping image architecture check", "reason", err.Error())
+	}
+	strategy, err = webhook.FilterCompatibleRules(platform, strategy)
+	if err != nil {
+		log.Error(err, "No placement rule is compatible with the deployment's pods")
+		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+	}
+	if strategy.HasPreemptibleRules() && r.SlowStart.IsSlowStart(ctx, templatePod) {
+		strategy = webhook.AvoidPreemptibleRules(strategy)
+	}
+
+	// A rollout replaces every pod anyway, and the webhook places the new ReplicaSet on its own
+	if deploymentRollingOut(deployment) {
+		log.Info("Deployment rollout in progress, deferring rebalance check",
+			"updatedReplicas", deployment.Status.UpdatedReplicas,
+			"replicas", deployment.Status.Replicas)
+		return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
+	}
+
+	// Experiment arms follow different strategies, so the deployment-wide drift is meaningless
+	if experiment, ok := deployment.Annotations[webhook.ExperimentAnnotation]; ok {
+		log.Info("Placement experiment running, pausing rebalance", "experiment", experiment)
+		return ctrl.Result{RequeueAfter: time.Minute * 10}, nil
+	}
+
+	// Get current placement state, waiting for admissions of the deployment in flight
+	unlock, err := r.StateManager.LockDeployment(ctx, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 	placementState, err := r.StateManager.GetPlacementState(ctx, deployment, strategy)
+	unlock()
 	if err != nil {
 		log.Error(err, "Failed to get placement state")
-		return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
+		return resultForError("rebalance", err, log)
 	}
 
 	log.Info("Current placement state for rebalance",
@@ -153,7 +287,7 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 		"lastUpdated", placementState.LastUpdated)
 
 	// Calculate drift
-	driftReport, err := r.calculateDrift(ctx, deployment, strategy, placementState)
+	driftReport, err := r.calculateDrift(ctx, deployment, strategy)
 	if err != nil {
 		log.Error(err, "Failed to calculate drift")
 		return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
@@ -163,12 +297,82 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 		"driftPercentage", driftReport.DriftPercentage,
 		"requiresRebalance", driftReport.RequiresRebalance,
 		"expectedCounts", driftReport.ExpectedCounts,
-		"actualCounts", driftReport.ActualCounts)
+		"actualCounts", driftReport.ActualCounts,
+		"unschedulableCounts", driftReport.UnschedulableCounts)
+
+	// Scale-downs trim over-represented rules first, correcting drift without evictions. StatefulSets
+	// scale down by ordinal and ignore deletion costs.
+	if r.ScaleDownHints && !strategy.IsFailover() && !webhook.IsStatefulSet(deployment) {
+		unhealthyNodes, err := r.PoolHealth.UnhealthyNodes(ctx)
+		if err != nil {
+			log.Error(err, "Failed to list unhealthy nodes, hinting scale-down without node health")
+		}
+		if err := r.hintScaleDown(ctx, deployment, strategy, unhealthyNodes, log); err != nil {
+			log.Error(err, "Failed to hint scale-down")
+		}
+	}
 
 	// Handle rebalancing if needed
 	if driftReport.RequiresRebalance {
+		// Pods spilled away from a rule whose pods cannot be scheduled would be evicted back onto it
+		if len(driftReport.UnschedulableCounts) > 0 {
+			log.Info("Pods stuck Unschedulable, holding rebalance", "unschedulableCounts", driftReport.UnschedulableCounts)
+			return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+		}
+
+		// Planned maintenance holds every eviction until the window closes
+		held, windowName, err := r.Maintenance.EvictionsHeld(ctx)
+		if err != nil {
+			log.Error(err, "Failed to check maintenance windows, holding rebalance")
+			return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
+		}
+		if held {
+			log.Info("Rebalancing held by active maintenance window", "maintenanceWindow", windowName)
+			return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+		}
+
+		// A human moving pods by hand wins over the strategy until the override window ends
+		overrideWait, overrideReason, err := r.ManualOverrides.Hold(ctx, "rebalance", deployment, ruleSelectors(strategy))
+		if err != nil {
+			log.Error(err, "Failed to check for manual overrides, holding rebalance")
+			return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
+		}
+		if overrideWait > 0 {
+			log.Info("Rebalancing deferred by human-override window", "reason", overrideReason, "remaining", overrideWait.String())
+			return ctrl.Result{RequeueAfter: overrideWait}, nil
+		}
+
+		wait, exhausted := r.rolloutHold(rollout)
+		if wait > 0 {
+			log.Info("Rebalancing held by strategy change grace period", "remaining", wait.String())
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		if exhausted {
+			_, maxEvictions := r.strategyChangeLimits()
+			log.Info("Strategy change eviction budget used up, holding rebalance",
+				"evictions", rollout.Evictions,
+				"budget", maxEvictions)
+			return ctrl.Result{RequeueAfter: time.Minute * 10}, nil
+		}
+
+		// A paced migration moves its step's pods, then waits for the next step
+		rollout, err = r.recordMigrationProgress(ctx, deployment, rollout, driftReport)
+		if err != nil {
+			log.Error(err, "Failed to record migration progress")
+		}
+		if left, nextStep := r.migrationEvictionsLeft(deployment, rollout, time.Now()); left == 0 {
+			log.Info("Migration step complete, holding rebalance until the next step",
+				"evictions", rollout.Evictions,
+				"nextStep", nextStep.String())
+			return ctrl.Result{RequeueAfter: nextStep}, nil
+		}
+
 		log.Info("Rebalancing required, proceeding with rebalance operation")
-		return r.performRebalancing(ctx, deployment, strategy, driftReport, log)
+		return r.performRebalancing(ctx, deployment, strategy, driftReport, placementState.PodRules(), rollout, log)
+	}
+
+	if err := r.finishRollout(ctx, deployment, rollout); err != nil {
+		log.Error(err, "Failed to finish strategy rollout")
 	}
 
 	log.Info("No rebalancing required, scheduling next check")
@@ -176,39 +380,37 @@ func (r *RebalanceController) Reconcile(ctx context.Context, req ctrl.Request) (
 	return ctrl.Result{RequeueAfter: time.Minute * 10}, nil
 }
 
+// deploymentRollingOut reports whether the deployment still runs pods of an older ReplicaSet
+func deploymentRollingOut(deployment *appsv1.Deployment) bool {
+	return deployment.Status.ObservedGeneration < deployment.Generation ||
+		deployment.Status.UpdatedReplicas != deployment.Status.Replicas
+}
+
 // generateRebalanceReconcileID creates a unique ID for each rebalance reconciliation
 func generateRebalanceReconcileID() string {
 	return "rebalance-" + time.Now().Format("20060102150405.000000")
 }
 
 // calculateDrift analyzes the current placement vs expected placement
-func (r *RebalanceController) calculateDrift(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy, state *webhook.PlacementState) (*DriftReport, error) {
+func (r *RebalanceController) calculateDrift(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy) (*DriftReport, error) {
 	// Get actual pod counts by querying current pods
-	actualCounts, err := r.getActualPodCounts(ctx, deployment, strategy)
+	actualCounts, unschedulableCounts, err := r.getActualPodCounts(ctx, deployment, strategy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get actual pod counts: %w", err)
 	}
 
-	// Calculate expected distribution
-	expectedCounts := r.calculateExpectedDistribution(strategy, state.TotalPods)
-
-	// Calculate drift percentage
-	totalDrift := 0
-	totalExpected := 0
-	for ruleKey, expected := range expectedCounts {
-		actual := actualCounts[ruleKey]
-		drift := abs(expected - actual)
-		totalDrift += drift
-		totalExpected += expected
-	}
-
-	driftPercentage := 0.0
-	if totalExpected > 0 {
-		driftPercentage = float64(totalDrift) / float64(totalExpected) * 100
+	expectedCounts := webhook.ExpectedCounts(strategy, actualCounts, r.PoolHealth.HealthFunc(ctx))
+	minOnDemand, err := webhook.MinOnDemand(deployment)
+	if err != nil {
+		r.Log.Error(err, "Ignoring the on-demand floor", "deployment", deployment.Name)
 	}
+	expectedCounts = webhook.ExpectedMinOnDemand(strategy, expectedCounts, minOnDemand)
+	driftPercentage := webhook.DriftPercentage(expectedCounts, actualCounts)
 
-	// Determine if rebalancing is required (>20% drift)
-	requiresRebalance := driftPercentage > 20.0
+	// Determine if rebalancing is required (>20% drift), or pods can be moved up to the on-demand floor
+	onDemand := webhook.OnDemandCount(strategy, actualCounts)
+	requiresRebalance := driftPercentage > webhook.RebalanceDriftThreshold ||
+		onDemand < minOnDemand && onDemand < webhook.OnDemandCount(strategy, expectedCounts)
 
 	return &DriftReport{
 		DeploymentName:      deployment.Name,
@@ -217,91 +419,114 @@ func (r *RebalanceController) calculateDrift(ctx context.Context, deployment *ap
 		ActualCounts:        actualCounts,
 		DriftPercentage:     driftPercentage,
 		RequiresRebalance:   requiresRebalance,
+		UnschedulableCounts: unschedulableCounts,
+		MinOnDemand:         minOnDemand,
 		Timestamp:           time.Now(),
 	}, nil
 }
 
-// calculateExpectedDistribution calculates expected pod distribution based on strategy
-func (r *RebalanceController) calculateExpectedDistribution(strategy *webhook.PlacementStrategy, totalPods int) map[string]int {
-	expected := make(map[string]int)
-
-	// Initialize all rule counts
-	for _, rule := range strategy.Rules {
-		ruleKey := ruleToString(rule)
-		expected[ruleKey] = 0
-	}
+// performRebalancing performs the actual rebalancing by selectively deleting pods
+func (r *RebalanceController) performRebalancing(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy, drift *DriftReport, podRules map[types.UID]webhook.RuleKey, rollout strategyRollout, log logr.Logger) (ctrl.Result, error) {
+	log.Info("Starting rebalancing process", "driftPercentage", drift.DriftPercentage)
 
-	if totalPods <= strategy.Base {
-		// All pods should be on first rule
-		if len(strategy.Rules) > 0 {
-			firstRuleKey := ruleToString(strategy.Rules[0])
-			expected[firstRuleKey] = totalPods
-		}
-		return expected
+	// Get all pods for this deployment
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// Base pods on first rule
-	if len(strategy.Rules) > 0 {
-		firstRuleKey := ruleToString(strategy.Rules[0])
-		expected[firstRuleKey] = strategy.Base
+	// Nodes reporting problems are relieved first
+	unhealthyNodes, err := r.PoolHealth.UnhealthyNodes(ctx)
+	if err != nil {
+		log.Error(err, "Failed to list unhealthy nodes, selecting pods without node health")
 	}
 
-	// Distribute remaining pods by weight
-	remainingPods := totalPods - strategy.Base
-	totalWeight := 0
-	for _, rule := range strategy.Rules {
-		totalWeight += rule.Weight
+	// Identify pods to delete for rebalancing
+	if webhook.IsStatefulSet(deployment) {
+		pods = offOrdinalRule(pods, deployment, strategy, drift)
 	}
+	podsToDelete := r.selectPodsForRebalancing(pods, strategy, drift, podRules, unhealthyNodes)
 
-	if totalWeight > 0 {
-		for _, rule := range strategy.Rules {
-			ruleKey := ruleToString(rule)
-			weightedCount := int(float64(remainingPods) * float64(rule.Weight) / float64(totalWeight))
-			expected[ruleKey] += weightedCount
+	// Evicted pods are recreated on the under-allocated rules; hold off while none of them can take a pod
+	if len(podsToDelete) > 0 {
+		if err := r.PoolHealth.RequireHealthy(ctx, underAllocatedRules(strategy, drift)); err != nil {
+			log.Error(err, "Target pools cannot take evicted pods, holding rebalance")
+			return resultForError("rebalance", err, log)
 		}
 	}
 
-	return expected
-}
-
-// performRebalancing performs the actual rebalancing by selectively deleting pods
-func (r *RebalanceController) performRebalancing(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy, drift *DriftReport, log logr.Logger) (ctrl.Result, error) {
-	log.Info("Starting rebalancing process", "driftPercentage", drift.DriftPercentage)
-
-	// Get all pods for this deployment
-	podList := &corev1.PodList{}
-	labelSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
+	// Evictions take no more pods down than the deployment's rolling update allows, sharing that
+	// allowance with a rollout that starts meanwhile, and stay within the strategy change's budget
+	// and the current step of a paced migration
+	maxDeletions := webhook.DisruptionAllowance(deployment)
+	if left := r.rolloutEvictionsLeft(rollout); left >= 0 {
+		maxDeletions = min(maxDeletions, left)
+	}
+	if left, _ := r.migrationEvictionsLeft(deployment, rollout, time.Now()); left >= 0 {
+		maxDeletions = min(maxDeletions, left)
+	}
+	if len(podsToDelete) > 0 && maxDeletions == 0 {
+		log.Info("Deployment's rolling update allows no more unavailable pods, holding rebalance",
+			"availableReplicas", deployment.Status.AvailableReplicas,
+			"maxUnavailable", webhook.MaxUnavailable(deployment))
+		return ctrl.Result{RequeueAfter: time.Minute * 2}, nil
+	}
 
-	err := r.List(ctx, podList, &client.ListOptions{
-		Namespace:     deployment.Namespace,
-		LabelSelector: labelSelector,
-	})
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to list pods: %w", err)
+		return ctrl.Result{}, err
 	}
 
-	// Identify pods to delete for rebalancing
-	podsToDelete := r.selectPodsForRebalancing(podList.Items, drift)
-
-	// Delete pods gradually (max 1 at a time to avoid disruption)
 	deletedCount := 0
-	maxDeletions := 1
+
+	interrupted := false
 
 	for _, pod := range podsToDelete {
 		if deletedCount >= maxDeletions {
 			break
 		}
 
+		// The manager is shutting down; stop between evictions rather than mid-sequence
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+
 		// Skip pods already being deleted
 		if pod.DeletionTimestamp != nil {
 			continue
 		}
 
-		log.Info("Deleting pod for rebalancing", "pod", pod.Name, "nodeSelector", pod.Spec.NodeSelector)
+		// The cluster-wide eviction budget protects against a misconfigured strategy churning every workload
+		if wait := r.Limits.TakeEviction(); wait > 0 {
+			log.Info("Cluster eviction limit reached, holding rebalance", "remaining", wait.String())
+			r.createRebalanceEvent(ctx, deployment, "", "EvictionLimitReached",
+				fmt.Sprintf("Rebalancing held, the cluster's hourly eviction limit is used up for another %s", wait.Round(time.Second)))
+			if err := r.recordRolloutEvictions(ctx, deployment, rollout, deletedCount); err != nil {
+				log.Error(err, "Failed to record strategy rollout evictions")
+			}
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+
+		log.Info("Evicting pod for rebalancing", "pod", pod.Name, "nodeSelector", pod.Spec.NodeSelector)
 
-		err = r.Delete(ctx, &pod)
+		err = webhook.ClassifyEviction(writer.SubResource("eviction").Create(ctx, &pod, &policyv1.Eviction{}))
+		if err != nil {
+			r.Limits.ReturnEviction()
+		}
+		if errors.Is(err, webhook.ErrPDBBlocked) || errors.Is(err, webhook.ErrThrottled) {
+			// Evicting the next pod would be refused the same way
+			log.Info("Eviction refused, retrying later", "pod", pod.Name, "reason", webhook.ErrorReason(err))
+			if recordErr := r.recordRolloutEvictions(ctx, deployment, rollout, deletedCount); recordErr != nil {
+				log.Error(recordErr, "Failed to record strategy rollout evictions")
+			}
+			return resultForError("rebalance", err, log)
+		}
+		if apierrors.IsNotFound(err) {
+			continue
+		}
 		if err != nil {
-			log.Error(err, "Failed to delete pod", "pod", pod.Name)
+			log.Error(err, "Failed to evict pod", "pod", pod.Name)
 			continue
 		}
 
@@ -309,7 +534,15 @@ func (r *RebalanceController) performRebalancing(ctx context.Context, deployment
 
 		// Create event for visibility
 		r.createRebalanceEvent(ctx, deployment, pod.Name, "PodDeleted",
-			fmt.Sprintf("Pod deleted for placement rebalancing, drift: %.1f%%", drift.DriftPercentage))
+			fmt.Sprintf("Pod evicted for placement rebalancing, drift: %.1f%%", drift.DriftPercentage))
+	}
+
+	if interrupted {
+		return r.abortRebalancing(ctx, deployment, rollout, deletedCount, len(podsToDelete), log)
+	}
+
+	if err := r.recordRolloutEvictions(ctx, deployment, rollout, deletedCount); err != nil {
+		log.Error(err, "Failed to record strategy rollout evictions")
 	}
 
 	if deletedCount > 0 {
@@ -322,12 +555,79 @@ func (r *RebalanceController) performRebalancing(ctx context.Context, deployment
 	return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
 }
 
-// selectPodsForRebalancing identifies which pods should be deleted for rebalancing
-func (r *RebalanceController) selectPodsForRebalancing(pods []corev1.Pod, drift *DriftReport) []corev1.Pod {
+// abortRebalancing records the evictions done before shutdown interrupted rebalancing and leaves a
+// note on the deployment; the next leader picks up from the stored state
+func (r *RebalanceController) abortRebalancing(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout, deleted, selected int, log logr.Logger) (ctrl.Result, error) {
+	// The reconcile context is cancelled; give the bookkeeping a moment of its own
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := r.recordRolloutEvictions(writeCtx, deployment, rollout, deleted); err != nil {
+		log.Error(err, "Failed to record strategy rollout evictions")
+	}
+
+	message := fmt.Sprintf("Rebalancing interrupted by operator shutdown after %d of %d selected pods were deleted", deleted, selected)
+	log.Info(message)
+	r.createRebalanceEvent(writeCtx, deployment, "", "RebalanceInterrupted", message)
+	return ctrl.Result{}, nil
+}
+
+// ruleSelectors returns the nodeSelectors of the strategy's rules
+func ruleSelectors(strategy *webhook.PlacementStrategy) []map[string]string {
+	selectors := make([]map[string]string, 0, len(strategy.Rules))
+	for _, rule := range strategy.Rules {
+		selectors = append(selectors, rule.NodeSelector)
+	}
+	return selectors
+}
+
+// underAllocatedRules returns the rules holding fewer pods than expected
+func underAllocatedRules(strategy *webhook.PlacementStrategy, drift *DriftReport) []webhook.PlacementRule {
+	var rules []webhook.PlacementRule
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		if drift.ActualCounts[key] < drift.ExpectedCounts[key] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// offOrdinalRule returns the StatefulSet's pods away from the rule of their ordinal, which the
+// webhook places them back on when they are recreated; while the on-demand rules hold fewer pods
+// than the floor, pods off them too. Evicting other pods would bring them back where they were.
+func offOrdinalRule(pods []corev1.Pod, statefulSet *appsv1.Deployment, strategy *webhook.PlacementStrategy, drift *DriftReport) []corev1.Pod {
+	belowFloor := webhook.OnDemandCount(strategy, drift.ActualCounts) < drift.MinOnDemand
+	matcher := webhook.NewRuleMatcher(strategy)
+	var off []corev1.Pod
+	for _, pod := range pods {
+		ordinal, ok := webhook.StatefulSetOrdinal(&pod, statefulSet.Name)
+		if !ok {
+			continue
+		}
+		ruleKey, ok := matcher.Match(webhook.PlacementSelector(&pod))
+		if !ok {
+			continue
+		}
+		rule := strategy.Rules[webhook.OrdinalRule(strategy, ordinal)]
+		if ruleKey != rule.Key() || belowFloor && !webhook.OnDemandRule(rule) {
+			off = append(off, pod)
+		}
+	}
+	return off
+}
+
+// selectPodsForRebalancing identifies which pods should be deleted for rebalancing.
+// Pods are grouped by the rule the placement state attributes them to, and pods admitted since its
+// last refresh by their nodeSelector. Within each over-allocated rule, pods on unhealthy nodes are
+// selected first, then surge pods of past rollouts, then the rest. On-demand pods are only selected
+// while the on-demand rules keep the deployment's floor.
+func (r *RebalanceController) selectPodsForRebalancing(pods []corev1.Pod, strategy *webhook.PlacementStrategy, drift *DriftReport, podRules map[types.UID]webhook.RuleKey, unhealthyNodes map[string]bool) []corev1.Pod {
 	var podsToDelete []corev1.Pod
 
 	// Group pods by rule key
-	podsByRule := make(map[string][]corev1.Pod)
+	podsByRule := make(map[webhook.RuleKey][]corev1.Pod)
+	matcher := webhook.NewRuleMatcher(strategy)
 	for _, pod := range pods {
 		if pod.DeletionTimestamp != nil {
 			continue
@@ -335,18 +635,41 @@ func (r *RebalanceController) selectPodsForRebalancing(pods []corev1.Pod, drift
 		if pod.Status.Phase != corev1.PodRunning {
 			continue
 		}
+		if !r.EvictPreBound && pod.Annotations[webhook.SkipReasonAnnotation] == webhook.SkipReasonPreBound {
+			continue
+		}
 
-		ruleKey := nodeSelector2String(pod.Spec.NodeSelector)
+		ruleKey, ok := podRules[pod.UID]
+		if !ok {
+			ruleKey, ok = matcher.Match(webhook.PlacementSelector(&pod))
+		}
+		if !ok {
+			continue
+		}
 		podsByRule[ruleKey] = append(podsByRule[ruleKey], pod)
 	}
 
-	// Delete pods from over-allocated rules
+	// Delete pods from over-allocated rules, keeping the on-demand floor
+	onDemandRules := make(map[webhook.RuleKey]bool)
+	for _, rule := range strategy.Rules {
+		if webhook.OnDemandRule(rule) {
+			onDemandRules[rule.Key()] = true
+		}
+	}
+	spareOnDemand := webhook.OnDemandCount(strategy, drift.ActualCounts) - drift.MinOnDemand
 	for ruleKey, actual := range drift.ActualCounts {
 		expected := drift.ExpectedCounts[ruleKey]
 		if actual > expected {
 			// This rule has too many pods
 			excess := actual - expected
+			if onDemandRules[ruleKey] {
+				excess = max(min(excess, spareOnDemand), 0)
+				spareOnDemand -= excess
+			}
 			rulePods := podsByRule[ruleKey]
+			sort.SliceStable(rulePods, func(i, j int) bool {
+				return evictionRank(&rulePods[i], unhealthyNodes) < evictionRank(&rulePods[j], unhealthyNodes)
+			})
 
 			// Sort pods by creation time (delete newest first to preserve disruption)
 			if len(rulePods) > 0 {
@@ -360,52 +683,32 @@ func (r *RebalanceController) selectPodsForRebalancing(pods []corev1.Pod, drift
 	return podsToDelete
 }
 
-// getActualPodCounts gets current pod counts from the cluster
-func (r *RebalanceController) getActualPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy) (map[string]int, error) {
-	counts := make(map[string]int)
-
-	// Initialize counts for all rules
-	for _, rule := range strategy.Rules {
-		ruleKey := ruleToString(rule)
-		counts[ruleKey] = 0
+// evictionRank orders pods for rebalancing evictions: pods on unhealthy nodes, then surge pods, and
+// protected base pods last
+func evictionRank(pod *corev1.Pod, unhealthyNodes map[string]bool) int {
+	switch {
+	case unhealthyNodes[pod.Spec.NodeName]:
+		return 0
+	case pod.Annotations[webhook.SurgePodAnnotation] == "true":
+		return 1
+	case pod.Annotations[webhook.BasePodAnnotation] == "true":
+		return 3
+	default:
+		return 2
 	}
+}
 
+// getActualPodCounts gets current pod counts from the cluster, and the pods per rule stuck
+// Unschedulable among them
+func (r *RebalanceController) getActualPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy) (map[webhook.RuleKey]int, map[webhook.RuleKey]int, error) {
 	// Get all pods for this deployment
-	podList := &corev1.PodList{}
-	labelSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
-
-	err := r.List(ctx, podList, &client.ListOptions{
-		Namespace:     deployment.Namespace,
-		LabelSelector: labelSelector,
-	})
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
+		return nil, nil, err
 	}
 
-	// Count pods by their nodeSelector
-	for _, pod := range podList.Items {
-		// Skip pods that are being deleted or failed
-		if pod.DeletionTimestamp != nil {
-			continue
-		}
-		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
-			continue
-		}
-
-		// Convert pod's nodeSelector to string key
-		podKey := nodeSelector2String(pod.Spec.NodeSelector)
-
-		// Find matching rule
-		for _, rule := range strategy.Rules {
-			ruleKey := ruleToString(rule)
-			if podKey == ruleKey {
-				counts[ruleKey]++
-				break
-			}
-		}
-	}
-
-	return counts, nil
+	// Pods are attributed to rules exactly as the webhook counts them
+	return webhook.CountPodsByRule(pods, strategy), r.Unschedulable.Stuck(pods, strategy), nil
 }
 
 // handleDeploymentDeletion cleans up state when deployment is deleted
@@ -424,11 +727,11 @@ func (r *RebalanceController) handleDeploymentDeletion(ctx context.Context, depl
 func (r *RebalanceController) createRebalanceEvent(ctx context.Context, deployment *appsv1.Deployment, podName, reason, message string) {
 	event := &corev1.Event{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("smart-scheduler-%d", time.Now().Unix()),
-			Namespace: deployment.Namespace,
+			GenerateName: deployment.Name + "-",
+			Namespace:    deployment.Namespace,
 		},
 		InvolvedObject: corev1.ObjectReference{
-			Kind:       "Deployment",
+			Kind:       webhook.WorkloadKind(deployment),
 			Name:       deployment.Name,
 			Namespace:  deployment.Namespace,
 			UID:        deployment.UID,
@@ -457,6 +760,28 @@ func (r *RebalanceController) SetupWithManager(mgr ctrl.Manager) error {
 		r.StateManager = webhook.NewStateManager(mgr.GetClient(), r.Log.WithName("StateManager"))
 	}
 
+	// Initialize PoolHealthChecker if not provided
+	if r.PoolHealth == nil {
+		r.PoolHealth = webhook.NewPoolHealthChecker(mgr.GetClient(), r.Log.WithName("PoolHealth"))
+	}
+
+	// Initialize MaintenanceTracker if not provided
+	if r.Maintenance == nil {
+		r.Maintenance = webhook.NewMaintenanceTracker(mgr.GetClient(), r.Log.WithName("Maintenance"))
+	}
+
+	if r.DebounceWindow <= 0 {
+		r.DebounceWindow = defaultDebounceWindow
+	}
+
+	// Initialize the owner resolver and keep its ReplicaSet edges in sync with the cache
+	if r.Owners == nil {
+		r.Owners = webhook.NewOwnerResolver(mgr.GetClient())
+	}
+	if err := r.Owners.RegisterInvalidation(context.Background(), mgr.GetCache()); err != nil {
+		return err
+	}
+
 	// Create deployment-specific predicates
 	deploymentPredicates := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
@@ -494,7 +819,8 @@ func (r *RebalanceController) SetupWithManager(mgr ctrl.Manager) error {
 			}
 
 			hasStrategy := newStrategy != ""
-			strategyChanged := oldStrategy != newStrategy
+			strategyChanged := oldStrategy != newStrategy ||
+				oldDep.Annotations[webhook.PriorityStrategiesAnnotation] != newDep.Annotations[webhook.PriorityStrategiesAnnotation]
 			generationChanged := oldDep.Generation != newDep.Generation
 			statusChanged := oldDep.Status.ReadyReplicas != newDep.Status.ReadyReplicas ||
 				oldDep.Status.AvailableReplicas != newDep.Status.AvailableReplicas
@@ -606,60 +932,129 @@ func (r *RebalanceController) SetupWithManager(mgr ctrl.Manager) error {
 		},
 	}
 
+	if r.StatefulSets {
+		if err := r.setupStatefulSets(mgr, podPredicates); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.Deployment{}).
-		WithEventFilter(deploymentPredicates).
+		For(&appsv1.Deployment{}, builder.WithPredicates(deploymentPredicates)).
 		Watches(
 			&corev1.Pod{},
-			handler.EnqueueRequestsFromMapFunc(r.mapPodToDeployment),
+			r.debouncedPodHandler(r.mapPodToDeployment),
 			builder.WithPredicates(podPredicates),
 		).
 		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 1, // Reduce concurrency to avoid overlapping reconciliations
+			// Defaults to 1 to avoid overlapping reconciliations
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
 		}).
 		Complete(r)
 }
 
-// mapPodToDeployment maps pod events to deployment reconcile requests
-func (r *RebalanceController) mapPodToDeployment(ctx context.Context, obj client.Object) []ctrl.Request {
-	pod := obj.(*corev1.Pod)
-
-	// Find parent deployment
-	for _, ownerRef := range pod.OwnerReferences {
-		if ownerRef.Kind == "ReplicaSet" {
-			// Get the ReplicaSet to find parent deployment
-			rs := &appsv1.ReplicaSet{}
-			err := r.Get(context.Background(), types.NamespacedName{
-				Namespace: pod.Namespace,
-				Name:      ownerRef.Name,
-			}, rs)
-			if err != nil {
-				continue
+// setupStatefulSets sets up a second controller rebalancing StatefulSets with a strategy, watching
+// the same pod events
+func (r *RebalanceController) setupStatefulSets(mgr ctrl.Manager, podPredicates predicate.Funcs) error {
+	hasStrategy := func(obj client.Object) bool {
+		return obj.GetAnnotations()["smart-scheduler.io/schedule-strategy"] != ""
+	}
+	statefulSetPredicates := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return hasStrategy(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSts, oldOk := e.ObjectOld.(*appsv1.StatefulSet)
+			newSts, newOk := e.ObjectNew.(*appsv1.StatefulSet)
+			if !oldOk || !newOk || !hasStrategy(newSts) {
+				return false
 			}
+			return oldSts.Annotations["smart-scheduler.io/schedule-strategy"] != newSts.Annotations["smart-scheduler.io/schedule-strategy"] ||
+				oldSts.Annotations[webhook.PriorityStrategiesAnnotation] != newSts.Annotations[webhook.PriorityStrategiesAnnotation] ||
+				oldSts.Generation != newSts.Generation ||
+				oldSts.Status.ReadyReplicas != newSts.Status.ReadyReplicas ||
+				oldSts.Status.AvailableReplicas != newSts.Status.AvailableReplicas
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return hasStrategy(e.Object)
+		},
+	}
 
-			// Check if ReplicaSet has a Deployment owner
-			for _, rsOwnerRef := range rs.OwnerReferences {
-				if rsOwnerRef.Kind == "Deployment" {
-					return []ctrl.Request{
-						{
-							NamespacedName: types.NamespacedName{
-								Namespace: pod.Namespace,
-								Name:      rsOwnerRef.Name,
-							},
-						},
-					}
-				}
-			}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("rebalance-statefulset").
+		For(&appsv1.StatefulSet{}, builder.WithPredicates(statefulSetPredicates)).
+		Watches(
+			&corev1.Pod{},
+			r.debouncedPodHandler(mapPodToStatefulSet),
+			builder.WithPredicates(podPredicates),
+		).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
+		Complete(statefulSetRebalancer{r})
+}
+
+// debouncedPodHandler enqueues the workload mapped from a pod after DebounceWindow.
+// The delaying queue keeps the earliest deadline for a pending item, so a storm of pod events
+// during a rollout collapses into a single reconcile per deployment and window.
+func (r *RebalanceController) debouncedPodHandler(mapPod handler.MapFunc) handler.EventHandler {
+	enqueue := func(ctx context.Context, obj client.Object, q workqueue.RateLimitingInterface) {
+		for _, req := range mapPod(ctx, obj) {
+			q.AddAfter(req, r.DebounceWindow)
 		}
 	}
 
-	return []ctrl.Request{}
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(ctx, e.Object, q)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(ctx, e.ObjectNew, q)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			enqueue(ctx, e.Object, q)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			enqueue(ctx, e.Object, q)
+		},
+	}
+}
+
+// mapPodToDeployment maps pod events to deployment reconcile requests. When the ReplicaSet cannot
+// be read the deployment is derived from the pod-template-hash so the event is not dropped.
+func (r *RebalanceController) mapPodToDeployment(ctx context.Context, obj client.Object) []ctrl.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	log := r.Log.WithValues("podName", pod.Name, "namespace", pod.Namespace)
+
+	deploymentName, ok, err := r.Owners.DeploymentFor(ctx, pod)
+	if err != nil {
+		log.Error(err, "Failed to resolve parent deployment, falling back to the pod-template-hash")
+		if ownerRef := metav1.GetControllerOf(pod); ownerRef != nil {
+			deploymentName, ok = webhook.DeploymentNameFromPodTemplateHash(ownerRef.Name, pod)
+		}
+	}
+	if !ok {
+		log.V(1).Info("Pod is not controlled by a deployment, ignoring event")
+		return nil
+	}
+
+	return []ctrl.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Namespace: pod.Namespace,
+				Name:      deploymentName,
+			},
+		},
+	}
 }
 
-// abs returns absolute value of an integer
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// mapPodToStatefulSet maps events of a StatefulSet's pods to reconcile requests of the StatefulSet
+func mapPodToStatefulSet(ctx context.Context, obj client.Object) []ctrl.Request {
+	ownerRef := metav1.GetControllerOf(obj)
+	if ownerRef == nil || ownerRef.Kind != webhook.WorkloadStatefulSet {
+		return nil
 	}
-	return x
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: ownerRef.Name}}}
 }
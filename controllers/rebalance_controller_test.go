@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestDebouncedPodHandler(t *testing.T) {
+	const window = 500 * time.Millisecond
+	r := &RebalanceController{Log: logr.Discard(), DebounceWindow: window}
+	// Pods are mapped to the deployment named by their app label; pods without one map to nothing
+	handler := r.debouncedPodHandler(func(_ context.Context, obj client.Object) []ctrl.Request {
+		app := obj.GetLabels()["app"]
+		if app == "" {
+			return nil
+		}
+		return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: app}}}
+	})
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	pod := func(name, app string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}}}
+	}
+	ctx := context.Background()
+	start := time.Now()
+
+	// A rollout of web sends a storm of events for its pods, one pod of api changes and a bare pod
+	// is created
+	for i := 0; i < 10; i++ {
+		handler.Create(ctx, event.CreateEvent{Object: pod("web-new", "web")}, q)
+		handler.Update(ctx, event.UpdateEvent{ObjectOld: pod("web-old", "web"), ObjectNew: pod("web-old", "web")}, q)
+		handler.Delete(ctx, event.DeleteEvent{Object: pod("web-old", "web")}, q)
+	}
+	handler.Generic(ctx, event.GenericEvent{Object: pod("api-0", "api")}, q)
+	handler.Create(ctx, event.CreateEvent{Object: pod("bare", "")}, q)
+
+	if q.Len() != 0 {
+		t.Fatalf("Expected no reconcile before the debounce window, got %d queued", q.Len())
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		item, shutdown := q.Get()
+		if shutdown {
+			t.Fatal("queue shut down")
+		}
+		got[item.(ctrl.Request).Name] = true
+		q.Done(item)
+	}
+	if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("Expected the reconciles after the %v window, got them after %v", window, elapsed)
+	}
+	if !got["web"] || !got["api"] {
+		t.Errorf("Expected one reconcile each of web and api, got %v", got)
+	}
+
+	// The storm of web events collapsed into the one reconcile
+	time.Sleep(window)
+	if q.Len() != 0 {
+		t.Errorf("Expected the events of a deployment coalesced, got %d more reconciles", q.Len())
+	}
+}
@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// reservationForLabel carries the UID of the deployment a placeholder pod reserves capacity for
+	reservationForLabel = "smart-scheduler.io/reservation-for"
+	// reservationRuleAnnotation records the rule whose pool a placeholder pod holds
+	reservationRuleAnnotation = "smart-scheduler.io/reservation-rule"
+	// DefaultReservationPriorityClass is the PriorityClass of placeholder pods; it must rank below every workload
+	DefaultReservationPriorityClass = "smart-scheduler-reservation"
+	// DefaultReservationImage runs placeholder pods
+	DefaultReservationImage = "registry.k8s.io/pause:3.9"
+	// reservationResyncInterval is how often reservations are checked without any event
+	reservationResyncInterval = 5 * time.Minute
+)
+
+var reservationSwaps = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_reservation_swaps_total",
+		Help: "Placeholder pods deleted to make room for unschedulable pods of their deployment, by namespace",
+	},
+	[]string{"namespace"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(reservationSwaps)
+}
+
+// ReservationController keeps low-priority placeholder pods on the pools of rules with a reserve
+// count, so their capacity is provisioned before the deployment needs it. When a pod of the
+// deployment cannot be scheduled, a placeholder on its rule's pool is deleted to free its node and
+// a replacement is created, which stays pending until the pool scales up again.
+type ReservationController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// PriorityClassName is the PriorityClass of placeholder pods (default: DefaultReservationPriorityClass)
+	PriorityClassName string
+	// Image runs placeholder pods (default: DefaultReservationImage)
+	Image string
+	// InheritLabels are copied from the deployment's labels, so a label-scoped cache sees placeholders.
+	// Template labels are never copied: Services would route traffic to the placeholders.
+	InheritLabels []string
+	// Owners maps unschedulable pods to their parent deployment
+	Owners *webhook.OwnerResolver
+	// Namespaces lists namespaces where no capacity is reserved; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, creates and deletes placeholders impersonating a service account of their namespace
+	Tenants *TenantClients
+
+	mu sync.Mutex
+	// released holds, per deployment, the unschedulable pods a placeholder was already deleted for,
+	// so a pod the scheduler has not retried yet does not release a second one
+	released map[types.NamespacedName]map[types.UID]bool
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile brings the deployment's placeholder pods to the reserve count of each rule
+func (r *ReservationController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("deployment", req.NamespacedName)
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, req.NamespacedName, deployment); err != nil {
+		// Placeholders are owned by the deployment and garbage collected with it
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if deployment.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	placeholders := &corev1.PodList{}
+	if err := r.List(ctx, placeholders, client.InNamespace(deployment.Namespace),
+		client.MatchingLabels{reservationForLabel: string(deployment.UID)}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list placeholder pods: %w", err)
+	}
+
+	reserves, strategy := r.reserves(deployment, log)
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	byRule := make(map[webhook.RuleKey][]corev1.Pod)
+	for _, pod := range placeholders.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		key := webhook.RuleKey(pod.Annotations[reservationRuleAnnotation])
+		if _, ok := reserves[key]; !ok {
+			// The rule was removed or no longer reserves capacity
+			if err := deletePlaceholder(ctx, writer, &pod); err != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		byRule[key] = append(byRule[key], pod)
+	}
+	if len(reserves) == 0 {
+		r.setReleased(req.NamespacedName, nil)
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	unschedulable := unschedulablePods(pods, strategy)
+	released := r.pruneReleased(req.NamespacedName, unschedulable)
+	requests, err := r.placeholderRequests(ctx, deployment, pods)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		if reserves[key] == 0 {
+			continue
+		}
+		var claims []types.UID
+		for _, uid := range unschedulable[key] {
+			if !released[uid] {
+				claims = append(claims, uid)
+			}
+		}
+		freed, err := r.reconcileRule(ctx, writer, deployment, rule, requests, byRule[key], claims, log)
+		for _, uid := range freed {
+			released[uid] = true
+		}
+		if err != nil {
+			r.setReleased(req.NamespacedName, released)
+			return ctrl.Result{}, err
+		}
+	}
+	r.setReleased(req.NamespacedName, released)
+	return ctrl.Result{RequeueAfter: reservationResyncInterval}, nil
+}
+
+// pruneReleased returns the deployment's released pods that are still unschedulable
+func (r *ReservationController) pruneReleased(deployment types.NamespacedName, unschedulable map[webhook.RuleKey][]types.UID) map[types.UID]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pruned := make(map[types.UID]bool)
+	for _, uids := range unschedulable {
+		for _, uid := range uids {
+			if r.released[deployment][uid] {
+				pruned[uid] = true
+			}
+		}
+	}
+	return pruned
+}
+
+// setReleased records the deployment's released pods, forgetting the deployment when there are none
+func (r *ReservationController) setReleased(deployment types.NamespacedName, released map[types.UID]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(released) == 0 {
+		delete(r.released, deployment)
+		return
+	}
+	if r.released == nil {
+		r.released = make(map[types.NamespacedName]map[types.UID]bool)
+	}
+	r.released[deployment] = released
+}
+
+// reserves returns the reserve count of each rule of the deployment's strategy, and the strategy.
+// Deployments in protected namespaces or without a valid strategy reserve nothing.
+func (r *ReservationController) reserves(deployment *appsv1.Deployment, log logr.Logger) (map[webhook.RuleKey]int, *webhook.PlacementStrategy) {
+	if r.Namespaces.Protected(deployment.Namespace) {
+		return nil, nil
+	}
+	annotation, ok, err := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+	if err != nil {
+		log.Error(err, "Ignoring priority strategies, using default schedule strategy")
+	}
+	if !ok {
+		return nil, nil
+	}
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		log.Info("Not reserving capacity for an invalid strategy", "error", err.Error())
+		return nil, nil
+	}
+
+	reserves := make(map[webhook.RuleKey]int)
+	for _, rule := range strategy.Rules {
+		if rule.Reserve > 0 {
+			reserves[rule.Key()] = rule.Reserve
+		}
+	}
+	return reserves, strategy
+}
+
+// unschedulablePods returns the deployment's pods the scheduler could not place, per rule
+func unschedulablePods(pods []corev1.Pod, strategy *webhook.PlacementStrategy) map[webhook.RuleKey][]types.UID {
+	unschedulable := make(map[webhook.RuleKey][]types.UID)
+	matcher := webhook.NewRuleMatcher(strategy)
+	for i := range pods {
+		if !podUnschedulable(&pods[i]) {
+			continue
+		}
+		if key, ok := matcher.Match(pods[i].Spec.NodeSelector); ok {
+			unschedulable[key] = append(unschedulable[key], pods[i].UID)
+		}
+	}
+	return unschedulable
+}
+
+// placeholderRequests returns the resources a placeholder holds for one pod of the deployment: the
+// requests of its pod template with the overhead of its RuntimeClass, raised to those of its
+// largest pod, which include the sidecars other webhooks injected at admission
+func (r *ReservationController) placeholderRequests(ctx context.Context, deployment *appsv1.Deployment, pods []corev1.Pod) (corev1.ResourceList, error) {
+	template := deployment.Spec.Template.Spec
+	overhead, err := webhook.RuntimeClassOverhead(ctx, r.Client, &template)
+	if err != nil {
+		return nil, err
+	}
+	template.Overhead = overhead
+	requests := podRequests(&template)
+	for i := range pods {
+		if pods[i].DeletionTimestamp != nil || pods[i].Status.Phase == corev1.PodSucceeded || pods[i].Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for name, quantity := range podRequests(&pods[i].Spec) {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity
+			}
+		}
+	}
+	return requests, nil
+}
+
+// reconcileRule frees one scheduled placeholder per claiming unschedulable pod of the rule, then
+// creates or deletes placeholders until the rule holds its reserve count. It returns the pods a
+// placeholder was freed for.
+func (r *ReservationController) reconcileRule(ctx context.Context, writer client.Client, deployment *appsv1.Deployment, rule webhook.PlacementRule, requests corev1.ResourceList, placeholders []corev1.Pod, claims []types.UID, log logr.Logger) ([]types.UID, error) {
+	// Scheduled placeholders free a node when deleted; pending ones are dropped first when shrinking
+	sort.SliceStable(placeholders, func(i, j int) bool {
+		return placeholders[i].Spec.NodeName != "" && placeholders[j].Spec.NodeName == ""
+	})
+
+	swapped := 0
+	for swapped < len(claims) && swapped < len(placeholders) && placeholders[swapped].Spec.NodeName != "" {
+		if err := deletePlaceholder(ctx, writer, &placeholders[swapped]); err != nil {
+			return claims[:swapped], err
+		}
+		swapped++
+	}
+	freed := claims[:swapped]
+	if swapped > 0 {
+		reservationSwaps.WithLabelValues(deployment.Namespace).Add(float64(swapped))
+		log.Info("Released reserved capacity for unschedulable pods", "rule", rule.Key(), "placeholders", swapped)
+	}
+	remaining := placeholders[swapped:]
+
+	for i := len(remaining) - 1; i >= rule.Reserve; i-- {
+		if err := deletePlaceholder(ctx, writer, &remaining[i]); err != nil {
+			return freed, err
+		}
+	}
+	for i := len(remaining); i < rule.Reserve; i++ {
+		placeholder, err := r.placeholderPod(deployment, rule, requests)
+		if err != nil {
+			return freed, err
+		}
+		if err := writer.Create(ctx, placeholder); err != nil {
+			return freed, fmt.Errorf("failed to create placeholder pod: %w", err)
+		}
+	}
+	return freed, nil
+}
+
+// placeholderPod returns a pause pod requesting the resources of one pod of the deployment on the rule's pool
+func (r *ReservationController) placeholderPod(deployment *appsv1.Deployment, rule webhook.PlacementRule, requests corev1.ResourceList) (*corev1.Pod, error) {
+	template := deployment.Spec.Template.Spec
+	automount := false
+	gracePeriod := int64(0)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployment.Name + "-reservation-",
+			Namespace:    deployment.Namespace,
+			Labels:       map[string]string{reservationForLabel: string(deployment.UID)},
+			Annotations:  map[string]string{reservationRuleAnnotation: rule.Key().String()},
+		},
+		Spec: corev1.PodSpec{
+			PriorityClassName:             r.PriorityClassName,
+			NodeSelector:                  make(map[string]string, len(rule.NodeSelector)),
+			Tolerations:                   append(append([]corev1.Toleration(nil), template.Tolerations...), rule.Tolerations...),
+			AutomountServiceAccountToken:  &automount,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers: []corev1.Container{{
+				Name:      "reservation",
+				Image:     r.Image,
+				Resources: corev1.ResourceRequirements{Requests: requests.DeepCopy()},
+			}},
+		},
+	}
+	for key, value := range rule.NodeSelector {
+		pod.Spec.NodeSelector[key] = value
+	}
+	webhook.RequireNodeAffinity(pod, rule.NodeAffinity)
+	for _, key := range r.InheritLabels {
+		if value, ok := deployment.Labels[key]; ok {
+			pod.Labels[key] = value
+		}
+	}
+	if err := controllerutil.SetControllerReference(deployment, pod, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// podRequests returns the resources the scheduler reserves for a pod: the sum of its containers'
+// and native sidecars' requests, or what its init containers need while they run when that is
+// higher, plus its overhead
+func podRequests(spec *corev1.PodSpec) corev1.ResourceList {
+	add := func(list corev1.ResourceList, requests corev1.ResourceList) {
+		for name, quantity := range requests {
+			total := list[name]
+			total.Add(quantity)
+			list[name] = total
+		}
+	}
+	raise := func(list corev1.ResourceList, requests corev1.ResourceList) {
+		for name, quantity := range requests {
+			if current, ok := list[name]; !ok || quantity.Cmp(current) > 0 {
+				list[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	requests := corev1.ResourceList{}
+	for _, container := range spec.Containers {
+		add(requests, container.Resources.Requests)
+	}
+	// Native sidecars keep running next to the init containers started after them and the app
+	sidecars, init := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, container := range spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			add(sidecars, container.Resources.Requests)
+			raise(init, sidecars)
+			continue
+		}
+		running := sidecars.DeepCopy()
+		add(running, container.Resources.Requests)
+		raise(init, running)
+	}
+	add(requests, sidecars)
+	raise(requests, init)
+	add(requests, spec.Overhead)
+	if len(requests) == 0 {
+		// A pod without requests would reserve nothing; hold at least a token amount of CPU
+		requests[corev1.ResourceCPU] = resource.MustParse("10m")
+	}
+	return requests
+}
+
+// deletePlaceholder deletes a placeholder pod, ignoring pods already gone
+func deletePlaceholder(ctx context.Context, writer client.Client, pod *corev1.Pod) error {
+	if err := writer.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete placeholder pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// podUnschedulable reports whether the scheduler found no node for the pod
+func podUnschedulable(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName != "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled {
+			return condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *ReservationController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.PriorityClassName == "" {
+		r.PriorityClassName = DefaultReservationPriorityClass
+	}
+	if r.Image == "" {
+		r.Image = DefaultReservationImage
+	}
+	if r.Owners == nil {
+		r.Owners = webhook.NewOwnerResolver(mgr.GetClient())
+	}
+	if err := r.Owners.RegisterInvalidation(context.Background(), mgr.GetCache()); err != nil {
+		return err
+	}
+
+	// Reserve counts only change with the strategy annotations
+	strategyChanged := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldAnnotations, newAnnotations := e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()
+			return oldAnnotations[webhook.ScheduleStrategyAnnotation] != newAnnotations[webhook.ScheduleStrategyAnnotation] ||
+				oldAnnotations[webhook.PriorityStrategiesAnnotation] != newAnnotations[webhook.PriorityStrategiesAnnotation]
+		},
+	}
+
+	// Pods of a deployment the scheduler cannot place claim reserved capacity
+	becameUnschedulable := predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, oldOk := e.ObjectOld.(*corev1.Pod)
+			newPod, newOk := e.ObjectNew.(*corev1.Pod)
+			return oldOk && newOk && podUnschedulable(newPod) && !podUnschedulable(oldPod)
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("reservation").
+		For(&appsv1.Deployment{}, builder.WithPredicates(strategyChanged)).
+		// Placeholders preempted or deleted by hand are replaced
+		Owns(&corev1.Pod{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.mapPodToDeployment), builder.WithPredicates(becameUnschedulable)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
+
+// mapPodToDeployment maps an unschedulable pod to its parent deployment
+func (r *ReservationController) mapPodToDeployment(ctx context.Context, obj client.Object) []ctrl.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	deploymentName, ok, err := r.Owners.DeploymentFor(ctx, pod)
+	if err != nil {
+		r.Log.Error(err, "Failed to resolve parent deployment of unschedulable pod", "pod", pod.Name, "namespace", pod.Namespace)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: deploymentName}}}
+}
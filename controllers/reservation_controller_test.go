@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const reservedStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,reserve=2,nodeSelector=node-type:spot"
+
+// placeholder returns a placeholder pod of the deployment holding the rule's pool, scheduled when
+// nodeName is set
+func placeholder(deployment *appsv1.Deployment, name, rule, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   deployment.Namespace,
+			Labels:      map[string]string{reservationForLabel: string(deployment.UID)},
+			Annotations: map[string]string{reservationRuleAnnotation: rule},
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName, Containers: []corev1.Container{{Name: "reservation", Image: DefaultReservationImage}}},
+	}
+}
+
+// unschedulable marks the pod as one the scheduler found no node for
+func unschedulable(pod *corev1.Pod) *corev1.Pod {
+	pod.Status.Phase = corev1.PodPending
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+	}}
+	return pod
+}
+
+// listPlaceholders returns the deployment's placeholder pods sorted by name
+func listPlaceholders(t *testing.T, c client.Client, deployment *appsv1.Deployment) []corev1.Pod {
+	t.Helper()
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods, client.InNamespace(deployment.Namespace),
+		client.MatchingLabels{reservationForLabel: string(deployment.UID)}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+	return pods.Items
+}
+
+func TestReservationReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// placeholders returns the deployment's placeholders before the reconcile
+		placeholders func(deployment *appsv1.Deployment) []client.Object
+		// want is the number of placeholders after the reconcile
+		want int
+		// kept are placeholders expected to survive the reconcile
+		kept    []string
+		requeue time.Duration
+	}{
+		{
+			name:     "Placeholders are created up to the reserve",
+			strategy: reservedStrategy,
+			want:     2,
+			requeue:  reservationResyncInterval,
+		},
+		{
+			name:     "Surplus placeholders are deleted, pending ones first",
+			strategy: reservedStrategy,
+			placeholders: func(deployment *appsv1.Deployment) []client.Object {
+				return []client.Object{
+					placeholder(deployment, "web-reservation-a", "node-type=spot", ""),
+					placeholder(deployment, "web-reservation-b", "node-type=spot", "spot-0"),
+					placeholder(deployment, "web-reservation-c", "node-type=spot", "spot-1"),
+				}
+			},
+			want:    2,
+			kept:    []string{"web-reservation-b", "web-reservation-c"},
+			requeue: reservationResyncInterval,
+		},
+		{
+			name:     "Placeholders of a rule that no longer reserves are deleted",
+			strategy: reservedStrategy,
+			placeholders: func(deployment *appsv1.Deployment) []client.Object {
+				return []client.Object{
+					placeholder(deployment, "web-reservation-a", "node-type=ondemand", "ondemand-0"),
+					placeholder(deployment, "web-reservation-b", "node-type=spot", "spot-0"),
+					placeholder(deployment, "web-reservation-c", "node-type=spot", "spot-1"),
+				}
+			},
+			want:    2,
+			kept:    []string{"web-reservation-b", "web-reservation-c"},
+			requeue: reservationResyncInterval,
+		},
+		{
+			name:     "Strategy without reserves releases every placeholder",
+			strategy: "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+			placeholders: func(deployment *appsv1.Deployment) []client.Object {
+				return []client.Object{placeholder(deployment, "web-reservation-a", "node-type=spot", "spot-0")}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment, objects := testWorkload("web", map[string]string{webhook.ScheduleStrategyAnnotation: tt.strategy},
+				"ondemand", "spot")
+			if tt.placeholders != nil {
+				objects = append(objects, tt.placeholders(deployment)...)
+			}
+			c := newFakeClient(t, objects...)
+			r := &ReservationController{
+				Client: c, Log: logr.Discard(), Scheme: c.Scheme(),
+				PriorityClassName: DefaultReservationPriorityClass, Image: DefaultReservationImage,
+			}
+
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.RequeueAfter != tt.requeue {
+				t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, tt.requeue)
+			}
+
+			placeholders := listPlaceholders(t, c, deployment)
+			if len(placeholders) != tt.want {
+				t.Fatalf("Expected %d placeholders, got %d", tt.want, len(placeholders))
+			}
+			names := make(map[string]bool)
+			for _, pod := range placeholders {
+				names[pod.Name] = true
+				if rule := pod.Annotations[reservationRuleAnnotation]; rule != "node-type=spot" {
+					t.Errorf("Placeholder %s holds rule %q, want node-type=spot", pod.Name, rule)
+				}
+			}
+			for _, name := range tt.kept {
+				if !names[name] {
+					t.Errorf("Expected placeholder %s kept, got %v", name, names)
+				}
+			}
+		})
+	}
+}
+
+func TestReservationPlaceholderPod(t *testing.T) {
+	deployment, objects := testWorkload("web", map[string]string{webhook.ScheduleStrategyAnnotation: reservedStrategy},
+		"ondemand", "spot")
+	deployment.Spec.Template.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("250m"),
+	}
+	c := newFakeClient(t, objects...)
+	r := &ReservationController{
+		Client: c, Log: logr.Discard(), Scheme: c.Scheme(),
+		PriorityClassName: DefaultReservationPriorityClass, Image: DefaultReservationImage,
+	}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	placeholders := listPlaceholders(t, c, deployment)
+	if len(placeholders) == 0 {
+		t.Fatal("Expected placeholders created")
+	}
+	pod := placeholders[0]
+	if pod.Spec.NodeSelector["node-type"] != "spot" {
+		t.Errorf("NodeSelector = %v, want the spot pool", pod.Spec.NodeSelector)
+	}
+	if pod.Spec.PriorityClassName != DefaultReservationPriorityClass {
+		t.Errorf("PriorityClassName = %q, want %q", pod.Spec.PriorityClassName, DefaultReservationPriorityClass)
+	}
+	if owner := metav1.GetControllerOf(&pod); owner == nil || owner.UID != deployment.UID {
+		t.Errorf("Expected the placeholder controlled by the deployment, got %v", pod.OwnerReferences)
+	}
+	if cpu := pod.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("250m")) != 0 {
+		t.Errorf("Placeholder requests %s CPU, want the 250m of a pod of the deployment", cpu.String())
+	}
+	if pod.Labels["app"] != "" {
+		t.Errorf("Expected no template labels on a placeholder, got %v", pod.Labels)
+	}
+}
+
+func TestReservationReleasesCapacityForUnschedulablePods(t *testing.T) {
+	deployment, objects := testWorkload("web", map[string]string{webhook.ScheduleStrategyAnnotation: reservedStrategy},
+		"ondemand", "spot")
+	rs := objects[1].(*appsv1.ReplicaSet)
+	objects = append(objects,
+		unschedulable(testPod(rs, 2, "spot")),
+		placeholder(deployment, "web-reservation-a", "node-type=spot", "spot-0"),
+		placeholder(deployment, "web-reservation-b", "node-type=spot", "spot-1"),
+	)
+	c := newFakeClient(t, objects...)
+	r := &ReservationController{
+		Client: c, Log: logr.Discard(), Scheme: c.Scheme(),
+		PriorityClassName: DefaultReservationPriorityClass, Image: DefaultReservationImage,
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	// One scheduled placeholder freed its node for the pod and a pending one replaced it
+	after := listPlaceholders(t, c, deployment)
+	scheduled := 0
+	for _, pod := range after {
+		if pod.Spec.NodeName != "" {
+			scheduled++
+		}
+	}
+	if len(after) != 2 || scheduled != 1 {
+		t.Fatalf("Expected one scheduled placeholder and its pending replacement, got %d placeholders, %d scheduled", len(after), scheduled)
+	}
+
+	// The pod the scheduler has not retried yet does not release a second placeholder
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	again := listPlaceholders(t, c, deployment)
+	if len(again) != 2 || again[0].Name != after[0].Name || again[1].Name != after[1].Name {
+		t.Errorf("Expected the placeholders unchanged by a second reconcile, got %d placeholders", len(again))
+	}
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// disruptionBudgetForLabel carries the UID of the deployment a rule PodDisruptionBudget guards
+	disruptionBudgetForLabel = "smart-scheduler.io/disruption-budget-for"
+	// disruptionBudgetRuleAnnotation records the rule whose pods a rule PodDisruptionBudget selects
+	disruptionBudgetRuleAnnotation = "smart-scheduler.io/disruption-budget-rule"
+	// disruptionBudgetResyncInterval is how often budgets are checked without any event, so a base
+	// derived from another PodDisruptionBudget is followed
+	disruptionBudgetResyncInterval = 10 * time.Minute
+)
+
+// RuleDisruptionBudgetController keeps a PodDisruptionBudget with minAvailable set to the base on
+// the pods of the first rule of deployments annotated with webhook.RuleDisruptionBudgetAnnotation,
+// so drains and other voluntary disruptions honor the base as the rebalancer does. The budget
+// selects the deployment's pods carrying the rule's webhook.RuleLabel, which the webhook sets on
+// the pods of these deployments. Rules without a base, failover strategies and rules without a
+// nodeSelector get no budget.
+type RuleDisruptionBudgetController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Namespaces lists namespaces where no budgets are managed; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// Tenants, if set, writes budgets impersonating a service account of their namespace
+	Tenants *TenantClients
+}
+
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile brings the deployment's rule PodDisruptionBudgets to the base of its strategy
+func (r *RuleDisruptionBudgetController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("deployment", req.NamespacedName)
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, req.NamespacedName, deployment); err != nil {
+		// Budgets are owned by the deployment and garbage collected with it
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if deployment.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	budgets := &policyv1.PodDisruptionBudgetList{}
+	if err := r.List(ctx, budgets, client.InNamespace(deployment.Namespace),
+		client.MatchingLabels{disruptionBudgetForLabel: string(deployment.UID)}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list PodDisruptionBudgets: %w", err)
+	}
+
+	desired := r.desiredBudgets(ctx, deployment, log)
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range budgets.Items {
+		budget := &budgets.Items[i]
+		key := webhook.RuleKey(budget.Annotations[disruptionBudgetRuleAnnotation])
+		want, ok := desired[key]
+		if !ok {
+			// The rule was removed, lost its base, or already has a budget
+			if err := writer.Delete(ctx, budget); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("failed to delete PodDisruptionBudget %s: %w", budget.Name, err)
+			}
+			log.Info("Deleted rule PodDisruptionBudget", "name", budget.Name, "rule", key)
+			continue
+		}
+		delete(desired, key)
+
+		if equality.Semantic.DeepEqual(budget.Spec, want.Spec) {
+			continue
+		}
+		budget.Spec = want.Spec
+		if err := writer.Update(ctx, budget); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update PodDisruptionBudget %s: %w", budget.Name, err)
+		}
+		log.Info("Updated rule PodDisruptionBudget", "name", budget.Name, "rule", key, "minAvailable", want.Spec.MinAvailable.IntValue())
+	}
+
+	for key, budget := range desired {
+		if err := writer.Create(ctx, budget); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create PodDisruptionBudget for rule %s: %w", key, err)
+		}
+		log.Info("Created rule PodDisruptionBudget", "rule", key, "minAvailable", budget.Spec.MinAvailable.IntValue())
+	}
+	return ctrl.Result{RequeueAfter: disruptionBudgetResyncInterval}, nil
+}
+
+// desiredBudgets returns the budgets the deployment should have, by rule. Deployments in protected
+// namespaces, without the annotation or without a valid strategy have none.
+func (r *RuleDisruptionBudgetController) desiredBudgets(ctx context.Context, deployment *appsv1.Deployment, log logr.Logger) map[webhook.RuleKey]*policyv1.PodDisruptionBudget {
+	if r.Namespaces.Protected(deployment.Namespace) || deployment.Annotations[webhook.RuleDisruptionBudgetAnnotation] != "true" {
+		return nil
+	}
+	annotation, ok, err := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+	if err != nil {
+		log.Error(err, "Ignoring priority strategies, using default schedule strategy")
+	}
+	if !ok {
+		return nil
+	}
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		log.Info("Not guarding the base of an invalid strategy", "error", err.Error())
+		return nil
+	}
+	if strategy.IsFailover() || len(strategy.Rules) == 0 {
+		return nil
+	}
+	// Percentage and PodDisruptionBudget bases follow the deployment as it scales
+	if resolved, err := webhook.ResolveBase(ctx, r.Client, deployment, strategy); err != nil {
+		log.Error(err, "Failed to derive the base, using the configured base", "baseFrom", strategy.BaseFrom)
+	} else {
+		strategy = resolved
+	}
+
+	rule := strategy.Rules[0]
+	value := webhook.RuleLabelValue(rule.Key())
+	if strategy.Base <= 0 || value == "" || deployment.Spec.Selector == nil {
+		return nil
+	}
+
+	selector := deployment.Spec.Selector.DeepCopy()
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = make(map[string]string, 1)
+	}
+	selector.MatchLabels[webhook.RuleLabel] = value
+	minAvailable := intstr.FromInt32(int32(strategy.Base))
+
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployment.Name + "-rule-",
+			Namespace:    deployment.Namespace,
+			Labels:       map[string]string{disruptionBudgetForLabel: string(deployment.UID)},
+			Annotations:  map[string]string{disruptionBudgetRuleAnnotation: rule.Key().String()},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     selector,
+		},
+	}
+	if err := controllerutil.SetControllerReference(deployment, budget, r.Scheme); err != nil {
+		log.Error(err, "Not guarding the base without an owner reference")
+		return nil
+	}
+	return map[webhook.RuleKey]*policyv1.PodDisruptionBudget{rule.Key(): budget}
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *RuleDisruptionBudgetController) SetupWithManager(mgr ctrl.Manager) error {
+	// Budgets change with the strategy, the opt-in and, for percentage bases, the replicas
+	budgetInputsChanged := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldDeployment, oldOk := e.ObjectOld.(*appsv1.Deployment)
+			newDeployment, newOk := e.ObjectNew.(*appsv1.Deployment)
+			if !oldOk || !newOk {
+				return false
+			}
+			for _, annotation := range []string{webhook.ScheduleStrategyAnnotation, webhook.PriorityStrategiesAnnotation, webhook.RuleDisruptionBudgetAnnotation} {
+				if oldDeployment.Annotations[annotation] != newDeployment.Annotations[annotation] {
+					return true
+				}
+			}
+			return !equality.Semantic.DeepEqual(oldDeployment.Spec.Replicas, newDeployment.Spec.Replicas) ||
+				!equality.Semantic.DeepEqual(oldDeployment.Spec.Selector, newDeployment.Spec.Selector)
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("disruptionbudget").
+		For(&appsv1.Deployment{}, builder.WithPredicates(budgetInputsChanged)).
+		// Budgets edited or deleted by hand are restored
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Complete(r)
+}
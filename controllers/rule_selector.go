@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// RuleSelectorStaleCondition is set on a policy when a rule's nodeSelector no longer matches any
+// node because its nodes were relabeled
+const RuleSelectorStaleCondition = "RuleSelectorStale"
+
+// maxLabelChanges bounds the distinct node label changes remembered for re-keying
+const maxLabelChanges = 100
+
+// labelChange is a change of node labels: the pairs removed and the pairs added, a changed value
+// counting as both
+type labelChange struct {
+	removed map[string]string
+	added   map[string]string
+}
+
+// nodeRelabels remembers the distinct label changes of nodes seen since the controller started,
+// so rules left selecting no node can be mapped to the selector their nodes have now
+type nodeRelabels struct {
+	mu      sync.Mutex
+	seen    map[string]bool
+	changes []labelChange
+}
+
+func newNodeRelabels() *nodeRelabels {
+	return &nodeRelabels{seen: make(map[string]bool)}
+}
+
+// record remembers the change between the node's old and new labels, dropping the oldest change
+// once maxLabelChanges are kept
+func (n *nodeRelabels) record(oldLabels, newLabels map[string]string) bool {
+	change := labelChange{removed: make(map[string]string), added: make(map[string]string)}
+	for key, value := range oldLabels {
+		if newValue, ok := newLabels[key]; !ok || newValue != value {
+			change.removed[key] = value
+		}
+	}
+	for key, value := range newLabels {
+		if oldValue, ok := oldLabels[key]; !ok || oldValue != value {
+			change.added[key] = value
+		}
+	}
+	if len(change.removed) == 0 && len(change.added) == 0 {
+		return false
+	}
+
+	id := string(webhook.NodeSelectorKey(change.removed)) + "->" + string(webhook.NodeSelectorKey(change.added))
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen[id] {
+		return true
+	}
+	n.seen[id] = true
+	n.changes = append(n.changes, change)
+	if len(n.changes) > maxLabelChanges {
+		n.changes = n.changes[1:]
+	}
+	return true
+}
+
+// snapshot returns the remembered changes, oldest first
+func (n *nodeRelabels) snapshot() []labelChange {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]labelChange(nil), n.changes...)
+}
+
+// handler returns an event handler remembering node label changes and queueing every policy when
+// nodes are added, removed or relabeled, since the nodes their rules select changed
+func (n *nodeRelabels) handler(c client.Client) handler.EventHandler {
+	enqueuePolicies := func(ctx context.Context, q workqueue.RateLimitingInterface) {
+		policies := &smartschedulerv1.PodPlacementPolicyList{}
+		if err := c.List(ctx, policies); err != nil {
+			return
+		}
+		for _, policy := range policies.Items {
+			q.Add(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}})
+		}
+	}
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, _ event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueuePolicies(ctx, q)
+		},
+		UpdateFunc: func(ctx context.Context, evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if n.record(evt.ObjectOld.GetLabels(), evt.ObjectNew.GetLabels()) {
+				enqueuePolicies(ctx, q)
+			}
+		},
+		DeleteFunc: func(ctx context.Context, _ event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			enqueuePolicies(ctx, q)
+		},
+	}
+}
+
+// ruleRenames maps the rules of the policy, including its priority tiers, whose nodes were
+// relabeled to their new selectors. A rule is renamed once no node matches its old selector and
+// some node matches the new one; this covers rules still using the old selector, which are
+// reported as stale, and rules already changed to the new one. Rules without a nodeSelector match
+// any node and are never renamed.
+func (r *PodPlacementPolicyController) ruleRenames(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) (map[webhook.RuleKey]webhook.RuleKey, []string, error) {
+	strategies := map[string]smartschedulerv1.PlacementStrategySpec{"strategy": policy.Spec.Strategy}
+	for i, tier := range policy.Spec.PriorityTiers {
+		strategies[fmt.Sprintf("priorityTiers[%d].strategy", i)] = tier.Strategy
+	}
+
+	matches := make(map[webhook.RuleKey]bool)
+	matchesNodes := func(selector map[string]string) (bool, error) {
+		key := webhook.NodeSelectorKey(selector)
+		if matched, ok := matches[key]; ok {
+			return matched, nil
+		}
+		nodeList := &corev1.NodeList{}
+		if err := r.List(ctx, nodeList, client.MatchingLabels(selector)); err != nil {
+			return false, fmt.Errorf("failed to list nodes: %w", err)
+		}
+		matches[key] = len(nodeList.Items) > 0
+		return matches[key], nil
+	}
+
+	changes := r.relabels.snapshot()
+	renames := make(map[webhook.RuleKey]webhook.RuleKey)
+	var stale []string
+	for path, strategy := range strategies {
+		for i, rule := range strategy.Rules {
+			if len(rule.NodeSelector) == 0 {
+				continue
+			}
+			matched, err := matchesNodes(rule.NodeSelector)
+			if err != nil {
+				return nil, nil, err
+			}
+			key := webhook.NodeSelectorKey(rule.NodeSelector)
+
+			for _, change := range changes {
+				// A rule selecting no node may have the old selector, one selecting nodes the new one
+				from, to := rule.NodeSelector, rule.NodeSelector
+				var ok bool
+				if matched {
+					from, ok = webhook.RenamedSelector(rule.NodeSelector, change.added, change.removed)
+				} else {
+					to, ok = webhook.RenamedSelector(rule.NodeSelector, change.removed, change.added)
+				}
+				if !ok {
+					continue
+				}
+				fromMatched, err := matchesNodes(from)
+				if err != nil {
+					return nil, nil, err
+				}
+				toMatched, err := matchesNodes(to)
+				if err != nil {
+					return nil, nil, err
+				}
+				if fromMatched || !toMatched {
+					continue
+				}
+
+				renames[webhook.NodeSelectorKey(from)] = webhook.NodeSelectorKey(to)
+				if !matched {
+					name := fmt.Sprintf("%s.rules[%d]", path, i)
+					if rule.Name != "" {
+						name += fmt.Sprintf(" (%s)", rule.Name)
+					}
+					stale = append(stale, fmt.Sprintf("%s selects %s, its nodes now have %s", name, key, webhook.NodeSelectorKey(to)))
+				}
+				break
+			}
+		}
+	}
+	sort.Strings(stale)
+	return renames, stale, nil
+}
+
+// applyRuleSelectorCheck records on the policy status whether rules were left selecting no node by
+// relabeled nodes, and returns the renames of the policy's rules, or drops the condition when
+// re-keying is off
+func (r *PodPlacementPolicyController) applyRuleSelectorCheck(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) (map[webhook.RuleKey]webhook.RuleKey, error) {
+	if !r.RuleRekey {
+		meta.RemoveStatusCondition(&policy.Status.Conditions, RuleSelectorStaleCondition)
+		return nil, nil
+	}
+
+	renames, stale, err := r.ruleRenames(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	condition := metav1.Condition{
+		Type:               RuleSelectorStaleCondition,
+		Status:             metav1.ConditionFalse,
+		Reason:             "SelectorsCurrent",
+		Message:            "No rule selects nodes by labels they no longer have",
+		ObservedGeneration: policy.Generation,
+	}
+	if len(stale) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NodesRelabeled"
+		condition.Message = "Nodes were relabeled: " + strings.Join(stale, "; ")
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	return renames, nil
+}
+
+// mergeRuleRenames adds renames to the deployment's rule renames annotation. Renames already on the
+// deployment are kept, so pods placed before an operator restart are still matched.
+func mergeRuleRenames(annotations map[string]string, renames map[webhook.RuleKey]webhook.RuleKey) (string, error) {
+	merged, err := webhook.ParseRuleRenames(annotations[webhook.RuleRenamesAnnotation])
+	if err != nil || merged == nil {
+		merged = make(map[webhook.RuleKey]webhook.RuleKey, len(renames))
+	}
+	for from, to := range renames {
+		merged[from] = to
+	}
+	if len(merged) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// hintScaleDown lowers the pod-deletion-cost of pods on over-represented rules, so when the
+// deployment scales down the ReplicaSet removes them first and the drift shrinks without a single
+// eviction. Pods removed earlier in webhook.ScaleDownOrder get lower costs. Deletion costs set by
+// others, such as those of surge pods, are left alone, and hints no longer needed are removed.
+func (r *RebalanceController) hintScaleDown(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy, unhealthyNodes map[string]bool, log logr.Logger) error {
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment)
+	if err != nil {
+		return err
+	}
+	order := webhook.ScaleDownOrder(strategy, webhook.CountPodsByRule(pods, strategy))
+
+	// Within a rule, pods the rebalancer would evict first are hinted first
+	podsByRule := make(map[webhook.RuleKey][]*corev1.Pod)
+	matcher := webhook.NewRuleMatcher(strategy)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || (pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending) {
+			continue
+		}
+		if ruleKey, ok := matcher.Match(webhook.PlacementSelector(pod)); ok {
+			podsByRule[ruleKey] = append(podsByRule[ruleKey], pod)
+		}
+	}
+	for _, rulePods := range podsByRule {
+		sort.SliceStable(rulePods, func(i, j int) bool {
+			return evictionRank(rulePods[i], unhealthyNodes) < evictionRank(rulePods[j], unhealthyNodes)
+		})
+	}
+
+	costs := make(map[string]string, len(order))
+	for step, ruleKey := range order {
+		rulePods := podsByRule[ruleKey]
+		if len(rulePods) == 0 {
+			continue
+		}
+		costs[rulePods[0].Name] = strconv.Itoa(step - len(order))
+		podsByRule[ruleKey] = rulePods[1:]
+	}
+
+	writer, err := tenantWriter(r.Tenants, r.Client, deployment.Namespace)
+	if err != nil {
+		return err
+	}
+	hinted := 0
+	for i := range pods {
+		pod := &pods[i]
+		cost, wanted := costs[pod.Name]
+		_, isHint := pod.Annotations[webhook.ScaleDownHintAnnotation]
+		_, hasCost := pod.Annotations[webhook.PodDeletionCostAnnotation]
+
+		patch := client.MergeFrom(pod.DeepCopy())
+		switch {
+		case wanted && hasCost && !isHint:
+			continue
+		case wanted:
+			if isHint && pod.Annotations[webhook.PodDeletionCostAnnotation] == cost {
+				hinted++
+				continue
+			}
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[webhook.PodDeletionCostAnnotation] = cost
+			pod.Annotations[webhook.ScaleDownHintAnnotation] = "true"
+		case isHint:
+			delete(pod.Annotations, webhook.PodDeletionCostAnnotation)
+			delete(pod.Annotations, webhook.ScaleDownHintAnnotation)
+		default:
+			continue
+		}
+
+		if err := writer.Patch(ctx, pod, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if wanted {
+			hinted++
+		}
+	}
+
+	if hinted > 0 {
+		log.Info("Hinted scale-down toward the placement strategy", "hintedPods", hinted, "order", order)
+	}
+	return nil
+}
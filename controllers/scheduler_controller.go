@@ -6,6 +6,8 @@ import (
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -13,6 +15,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
 )
 
 // SchedulerController reconciles Deployment objects with custom scheduling annotations
@@ -20,6 +24,12 @@ type SchedulerController struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// MaxConcurrentReconciles limits parallel reconciles (default: 1)
+	MaxConcurrentReconciles int
+	// CleanupMode selects how pods placed by a since removed strategy are handled (default: none)
+	CleanupMode PlacementCleanupMode
+	// Namespaces lists namespaces whose deployments are never restarted; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
 }
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
@@ -67,8 +77,8 @@ func (r *SchedulerController) Reconcile(ctx context.Context, req ctrl.Request) (
 	annotations := deployment.Annotations
 	if annotations == nil {
 		log.Info("No annotations found on deployment, skipping")
-		// No custom scheduling, nothing to do
-		return ctrl.Result{}, nil
+		// No custom scheduling, but pods may still carry a removed strategy's placement
+		return r.cleanupRemovedStrategy(ctx, &deployment, log)
 	}
 
 	log.Info("Deployment annotations",
@@ -78,20 +88,75 @@ func (r *SchedulerController) Reconcile(ctx context.Context, req ctrl.Request) (
 	scheduleStrategy, exists := annotations["smart-scheduler.io/schedule-strategy"]
 	if !exists {
 		log.Info("No smart-scheduler.io/schedule-strategy annotation found, skipping")
-		// No custom scheduling strategy, nothing to do
-		return ctrl.Result{}, nil
+		if webhook.HasScheduleStrategy(annotations) {
+			return ctrl.Result{}, nil
+		}
+		// No custom scheduling strategy, but pods may still carry a removed strategy's placement
+		return r.cleanupRemovedStrategy(ctx, &deployment, log)
+	}
+
+	// A strategy was added back, the pods' placement is managed again
+	if err := r.clearStaleAnnotation(ctx, &deployment); err != nil {
+		return ctrl.Result{}, err
 	}
 
 	log.Info("Found scheduling strategy", "strategy", scheduleStrategy, "deployment", deployment.Name)
 
-	// TODO: Parse and validate the scheduling strategy
-	// TODO: Update deployment status or create configuration for webhook
-	// For now, just log that we found a strategy
+	// Invalid strategies are left to the webhook, which admits their pods unplaced; valid but
+	// suspicious ones are reported as Warning events so users get feedback before pods pile up
+	strategy, err := webhook.ParsePlacementStrategyCached(scheduleStrategy)
+	if err != nil {
+		log.Info("Invalid scheduling strategy", "error", err.Error())
+		r.createStrategyEvent(ctx, &deployment, "InvalidPlacementStrategy", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if resolved, err := webhook.ResolveBase(ctx, r.Client, &deployment, strategy); err != nil {
+		log.Error(err, "Failed to derive the base, linting the configured base", "baseFrom", strategy.BaseFrom)
+	} else {
+		strategy = resolved
+	}
+	replicas := 1
+	if deployment.Spec.Replicas != nil {
+		replicas = int(*deployment.Spec.Replicas)
+	}
+	for _, lint := range webhook.LintPlacementStrategy(strategy, replicas) {
+		log.Info("Suspicious scheduling strategy", "reason", lint.Reason, "message", lint.Message)
+		r.createStrategyEvent(ctx, &deployment, lint.Reason, lint.Message)
+	}
 
 	log.Info("Strategy processing complete, no further action needed")
 	return ctrl.Result{}, nil
 }
 
+// createStrategyEvent records a Warning event about the deployment's strategy
+func (r *SchedulerController) createStrategyEvent(ctx context.Context, deployment *appsv1.Deployment, reason, message string) {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployment.Name + "-",
+			Namespace:    deployment.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Deployment",
+			Name:       deployment.Name,
+			Namespace:  deployment.Namespace,
+			UID:        deployment.UID,
+			APIVersion: "apps/v1",
+		},
+		Reason:  reason,
+		Message: message,
+		Type:    corev1.EventTypeWarning,
+		Source: corev1.EventSource{
+			Component: "smart-scheduler-controller",
+		},
+		FirstTimestamp: metav1.NewTime(time.Now()),
+		LastTimestamp:  metav1.NewTime(time.Now()),
+	}
+
+	if err := r.Create(ctx, event); err != nil {
+		r.Log.Error(err, "Failed to create strategy event")
+	}
+}
+
 // generateReconcileID creates a unique ID for each reconciliation
 func generateReconcileID() string {
 	return time.Now().Format("20060102150405.000000")
@@ -101,55 +166,58 @@ func generateReconcileID() string {
 func (r *SchedulerController) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1.Deployment{}).
-		WithOptions(controller.Options{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
 		WithEventFilter(predicate.Funcs{
 			CreateFunc: func(e event.CreateEvent) bool {
+				hasStrategy := webhook.HasScheduleStrategy(e.Object.GetAnnotations())
 				log := r.Log.WithValues("eventType", "CREATE", "deploymentName", e.Object.GetName())
-				log.Info("Deployment CREATE event", "namespace", e.Object.GetNamespace())
-				return true
+				log.Info("Deployment CREATE event", "namespace", e.Object.GetNamespace(), "hasStrategy", hasStrategy)
+				return hasStrategy
 			},
 			UpdateFunc: func(e event.UpdateEvent) bool {
-				oldDep := e.ObjectOld.(*appsv1.Deployment)
-				newDep := e.ObjectNew.(*appsv1.Deployment)
+				oldDep, oldOk := e.ObjectOld.(*appsv1.Deployment)
+				newDep, newOk := e.ObjectNew.(*appsv1.Deployment)
+				if !oldOk || !newOk {
+					return false
+				}
 
 				log := r.Log.WithValues("eventType", "UPDATE", "deploymentName", newDep.Name)
 
-				// Check if scheduling-related annotations changed
-				oldAnnotations := oldDep.Annotations
-				newAnnotations := newDep.Annotations
-
-				oldStrategy := ""
-				newStrategy := ""
-				if oldAnnotations != nil {
-					oldStrategy = oldAnnotations["smart-scheduler.io/schedule-strategy"]
-				}
-				if newAnnotations != nil {
-					newStrategy = newAnnotations["smart-scheduler.io/schedule-strategy"]
+				// Deployments that never carried a strategy are not ours, whatever changed
+				hadStrategy := webhook.HasScheduleStrategy(oldDep.Annotations)
+				hasStrategy := webhook.HasScheduleStrategy(newDep.Annotations)
+				if !hadStrategy && !hasStrategy {
+					return false
 				}
 
-				strategyChanged := oldStrategy != newStrategy
-				generationChanged := oldDep.Generation != newDep.Generation
-				replicasChanged := oldDep.Spec.Replicas != newDep.Spec.Replicas
+				// Strategy added, changed or just removed; otherwise only spec changes matter,
+				// status-only updates don't bump the generation
+				strategyChanged := webhook.StrategyAnnotationsChanged(oldDep.Annotations, newDep.Annotations)
+				generationChanged := hasStrategy && oldDep.Generation != newDep.Generation
+				shouldReconcile := strategyChanged || generationChanged
 
 				log.Info("Deployment UPDATE event evaluation",
 					"namespace", newDep.Namespace,
+					"hadStrategy", hadStrategy,
+					"hasStrategy", hasStrategy,
 					"strategyChanged", strategyChanged,
 					"generationChanged", generationChanged,
-					"replicasChanged", replicasChanged,
-					"oldStrategy", oldStrategy,
-					"newStrategy", newStrategy,
 					"oldGeneration", oldDep.Generation,
 					"newGeneration", newDep.Generation,
-					"oldReplicas", oldDep.Spec.Replicas,
-					"newReplicas", newDep.Spec.Replicas,
-					"shouldReconcile", strategyChanged || generationChanged || replicasChanged)
+					"shouldReconcile", shouldReconcile)
 
-				return strategyChanged || generationChanged || replicasChanged
+				return shouldReconcile
 			},
 			DeleteFunc: func(e event.DeleteEvent) bool {
+				hasStrategy := webhook.HasScheduleStrategy(e.Object.GetAnnotations())
 				log := r.Log.WithValues("eventType", "DELETE", "deploymentName", e.Object.GetName())
-				log.Info("Deployment DELETE event", "namespace", e.Object.GetNamespace())
-				return true
+				log.Info("Deployment DELETE event", "namespace", e.Object.GetNamespace(), "hasStrategy", hasStrategy)
+				return hasStrategy
+			},
+			GenericFunc: func(e event.GenericEvent) bool {
+				return webhook.HasScheduleStrategy(e.Object.GetAnnotations())
 			},
 		}).
 		// Remove the Owns() directive that was causing unnecessary reconciliations
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// LimitExceededCondition is set on a policy that a scope limit keeps from being applied, in full or
+// to some of its deployments
+const LimitExceededCondition = "LimitExceeded"
+
+// Scope limits, as named in metrics
+const (
+	limitManagedDeployments   = "max_managed_deployments"
+	limitEvictionsPerHour     = "max_evictions_per_hour"
+	limitPoliciesPerNamespace = "max_policies_per_namespace"
+)
+
+var (
+	scopeLimitHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smart_scheduler_scope_limit_hits_total",
+			Help: "Actions held back by a scope limit: max_managed_deployments, max_evictions_per_hour or max_policies_per_namespace",
+		},
+		[]string{"limit"},
+	)
+	scopeLimitUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smart_scheduler_scope_limit_usage",
+			Help: "Current usage of the cluster-wide scope limits: managed deployments and evictions in the last hour",
+		},
+		[]string{"limit"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(scopeLimitHits, scopeLimitUsage)
+}
+
+// ScopeLimits caps how much of the cluster the controllers manage and disrupt, so a misconfigured
+// policy cannot take over or churn every workload. A zero limit is off, and a nil ScopeLimits
+// limits nothing. One ScopeLimits is shared by the controllers; its limits can be replaced while
+// they run.
+type ScopeLimits struct {
+	mu                      sync.Mutex
+	maxManagedDeployments   int
+	maxEvictionsPerHour     int
+	maxPoliciesPerNamespace int
+	// evictions are the times of the evictions of the last hour, oldest first. They are kept in
+	// memory, so a new leader starts with an unused budget.
+	evictions []time.Time
+}
+
+// NewScopeLimits returns limits on the deployments managed cluster-wide, the pods evicted or
+// recreated per hour cluster-wide and the policies applied per namespace
+func NewScopeLimits(maxManagedDeployments, maxEvictionsPerHour, maxPoliciesPerNamespace int) *ScopeLimits {
+	l := &ScopeLimits{}
+	l.Set(maxManagedDeployments, maxEvictionsPerHour, maxPoliciesPerNamespace)
+	return l
+}
+
+// Set replaces the limits
+func (l *ScopeLimits) Set(maxManagedDeployments, maxEvictionsPerHour, maxPoliciesPerNamespace int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxManagedDeployments = maxManagedDeployments
+	l.maxEvictionsPerHour = maxEvictionsPerHour
+	l.maxPoliciesPerNamespace = maxPoliciesPerNamespace
+}
+
+// TakeEviction takes one eviction from the hourly budget. When the budget is used up it takes
+// nothing and returns how long until an eviction is possible again.
+func (l *ScopeLimits) TakeEviction() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for expired < len(l.evictions) && now.Sub(l.evictions[expired]) >= time.Hour {
+		expired++
+	}
+	l.evictions = l.evictions[expired:]
+
+	if l.maxEvictionsPerHour > 0 && len(l.evictions) >= l.maxEvictionsPerHour {
+		scopeLimitHits.WithLabelValues(limitEvictionsPerHour).Inc()
+		scopeLimitUsage.WithLabelValues(limitEvictionsPerHour).Set(float64(len(l.evictions)))
+		// The oldest eviction that must expire for the count to drop below the limit
+		oldest := l.evictions[len(l.evictions)-l.maxEvictionsPerHour]
+		return time.Until(oldest.Add(time.Hour))
+	}
+	l.evictions = append(l.evictions, now)
+	scopeLimitUsage.WithLabelValues(limitEvictionsPerHour).Set(float64(len(l.evictions)))
+	return 0
+}
+
+// ReturnEviction gives back the last eviction taken when it did not happen
+func (l *ScopeLimits) ReturnEviction() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.evictions) > 0 {
+		l.evictions = l.evictions[:len(l.evictions)-1]
+	}
+	scopeLimitUsage.WithLabelValues(limitEvictionsPerHour).Set(float64(len(l.evictions)))
+}
+
+// ManagedDeploymentsLeft returns how many more deployments may be given a strategy, or -1 when
+// there is no limit. Deployments are counted from the informer cache, so policies reconciled
+// concurrently can overshoot the limit by a few deployments.
+func (l *ScopeLimits) ManagedDeploymentsLeft(ctx context.Context, c client.Reader) (int, error) {
+	if l == nil {
+		return -1, nil
+	}
+	l.mu.Lock()
+	limit := l.maxManagedDeployments
+	l.mu.Unlock()
+	if limit <= 0 {
+		return -1, nil
+	}
+
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deployments, client.UnsafeDisableDeepCopy); err != nil {
+		return 0, fmt.Errorf("failed to count managed deployments: %w", err)
+	}
+	managed := 0
+	for i := range deployments.Items {
+		if webhook.HasScheduleStrategy(deployments.Items[i].Annotations) {
+			managed++
+		}
+	}
+	scopeLimitUsage.WithLabelValues(limitManagedDeployments).Set(float64(managed))
+	return max(limit-managed, 0), nil
+}
+
+// PolicyWithinLimit reports whether the policy is among the oldest policies of its namespace that
+// the limit allows. Later policies are not applied, so a new policy never displaces an older one.
+func (l *ScopeLimits) PolicyWithinLimit(ctx context.Context, c client.Reader, policy *smartschedulerv1.PodPlacementPolicy) (bool, error) {
+	limit := l.policiesPerNamespace()
+	if limit <= 0 {
+		return true, nil
+	}
+
+	policies := &smartschedulerv1.PodPlacementPolicyList{}
+	if err := c.List(ctx, policies, client.InNamespace(policy.Namespace)); err != nil {
+		return false, fmt.Errorf("failed to list policies: %w", err)
+	}
+	if len(policies.Items) <= limit {
+		return true, nil
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		a, b := &policies.Items[i], &policies.Items[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Name < b.Name
+	})
+	for _, allowed := range policies.Items[:limit] {
+		if allowed.Name == policy.Name {
+			return true, nil
+		}
+	}
+	scopeLimitHits.WithLabelValues(limitPoliciesPerNamespace).Inc()
+	return false, nil
+}
+
+// PolicyCreationAllowed reports whether one more policy fits in the namespace
+func (l *ScopeLimits) PolicyCreationAllowed(ctx context.Context, c client.Reader, namespace string) (bool, error) {
+	limit := l.policiesPerNamespace()
+	if limit <= 0 {
+		return true, nil
+	}
+
+	policies := &smartschedulerv1.PodPlacementPolicyList{}
+	if err := c.List(ctx, policies, client.InNamespace(namespace)); err != nil {
+		return false, fmt.Errorf("failed to list policies: %w", err)
+	}
+	if len(policies.Items) >= limit {
+		scopeLimitHits.WithLabelValues(limitPoliciesPerNamespace).Inc()
+		return false, nil
+	}
+	return true, nil
+}
+
+// policiesPerNamespace returns the limit on policies per namespace
+func (l *ScopeLimits) policiesPerNamespace() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxPoliciesPerNamespace
+}
+
+// setLimitExceeded sets the LimitExceeded condition with the message, or removes it when the
+// message is empty
+func setLimitExceeded(policy *smartschedulerv1.PodPlacementPolicy, reason, message string) {
+	if message == "" {
+		meta.RemoveStatusCondition(&policy.Status.Conditions, LimitExceededCondition)
+		return
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+		Type:               LimitExceededCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: policy.Generation,
+	})
+}
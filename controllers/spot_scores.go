@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/spotscore"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// DefaultSpotScoreInterval is how often spot pools are scored
+	DefaultSpotScoreInterval = 30 * time.Minute
+	// DefaultSpotScoreThreshold is the spot placement score below which a rule's weight is lowered
+	DefaultSpotScoreThreshold = 7
+	// DefaultSpotScoreMinWeightPercent is the weight a spot rule keeps at a score of 1, in percent
+	// of its configured weight
+	DefaultSpotScoreMinWeightPercent = 25
+)
+
+var (
+	spotPlacementScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smart_scheduler_spot_placement_score",
+			Help: "Spot placement score of the pool each spot rule selects, from 1 (unlikely to be fulfilled) to 10",
+		},
+		[]string{"rule"},
+	)
+	spotScoreErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smart_scheduler_spot_score_errors_total",
+			Help: "Failed attempts to fetch spot placement scores, by provider",
+		},
+		[]string{"provider"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(spotPlacementScore, spotScoreErrors)
+}
+
+// SpotScoreController lowers the weights of spot rules whose pools a cloud provider is unlikely to
+// fill, so fewer new pods go Pending waiting for spot capacity. Every Interval it describes the pool
+// of each spot rule of a weighted strategy by the region, zones and instance types of the nodes the
+// rule selects, and has the Provider score it. A rule scoring below Threshold keeps a share of its
+// weight falling linearly to MinWeightPercent at a score of 1. The weights are written to the
+// deployment's webhook.SpotScoreWeightsAnnotation, which the webhook and the rebalancer apply, and
+// every change is reported as an event on the deployment. Pools selecting no node are not scored;
+// rules whose score could not be fetched keep their last weight.
+type SpotScoreController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Provider scores the spot pools
+	Provider spotscore.Provider
+	// ProviderName names the provider in metrics
+	ProviderName string
+	// Interval is how often the pools are scored (default: 30m)
+	Interval time.Duration
+	// Threshold is the score below which a rule's weight is lowered (default: 7)
+	Threshold int
+	// MinWeightPercent is the weight a rule keeps at a score of 1, in percent of its configured
+	// weight (default: 25)
+	MinWeightPercent int
+	// Namespaces lists namespaces whose deployments are not adjusted; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+}
+
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// scoredDeployment is a deployment with spot rules and its strategy
+type scoredDeployment struct {
+	deployment *appsv1.Deployment
+	strategy   *webhook.PlacementStrategy
+}
+
+// Reconcile scores the spot pools once and updates the weights of every deployment with spot rules
+func (r *SpotScoreController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	var scored []scoredDeployment
+	spotRules := make(map[webhook.RuleKey]webhook.PlacementRule)
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		strategy := r.scoredStrategy(deployment)
+		if strategy == nil {
+			if _, ok := deployment.Annotations[webhook.SpotScoreWeightsAnnotation]; ok {
+				r.Log.Info("Removing spot score weights of a deployment without spot rules", "deployment", deployment.Namespace+"/"+deployment.Name)
+				if err := r.patchWeights(ctx, deployment, nil); err != nil {
+					r.Log.Error(err, "Failed to remove spot score weights", "deployment", deployment.Namespace+"/"+deployment.Name)
+				}
+			}
+			continue
+		}
+		scored = append(scored, scoredDeployment{deployment: deployment, strategy: strategy})
+		for _, rule := range strategy.Rules {
+			if webhook.SpotRule(rule) {
+				spotRules[rule.Key()] = rule
+			}
+		}
+	}
+
+	pools, err := r.pools(ctx, spotRules)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	scores, fetchErr := r.Provider.Scores(ctx, pools)
+	if fetchErr != nil {
+		r.Log.Error(fetchErr, "Failed to fetch spot placement scores, keeping the last weights of unscored rules", "provider", r.ProviderName)
+		spotScoreErrors.WithLabelValues(r.ProviderName).Inc()
+	}
+	spotPlacementScore.Reset()
+	for key, score := range scores {
+		spotPlacementScore.WithLabelValues(key).Set(float64(score))
+	}
+
+	// Deployments failing to update wait for the next interval rather than calling the provider again
+	for _, item := range scored {
+		if err := r.adjust(ctx, item.deployment, item.strategy, scores, fetchErr != nil); err != nil {
+			r.Log.Error(err, "Failed to adjust spot rule weights", "deployment", item.deployment.Namespace+"/"+item.deployment.Name)
+		}
+	}
+	return ctrl.Result{RequeueAfter: r.Interval}, nil
+}
+
+// adjust sets the weight of each spot rule of the deployment by its score. Rules without a score
+// get their configured weight back, unless fetching failed.
+func (r *SpotScoreController) adjust(ctx context.Context, deployment *appsv1.Deployment, strategy *webhook.PlacementStrategy, scores map[string]int, fetchFailed bool) error {
+	current, err := webhook.ParseSpotScoreWeights(deployment.Annotations[webhook.SpotScoreWeightsAnnotation])
+	if err != nil {
+		r.Log.Error(err, "Discarding invalid spot score weights", "deployment", deployment.Namespace+"/"+deployment.Name)
+		current = nil
+	}
+
+	desired := make(map[webhook.RuleKey]int)
+	var changes []string
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		if !webhook.SpotRule(rule) || rule.Weight == 0 {
+			continue
+		}
+		before, ok := current[key]
+		if !ok {
+			before = 100
+		}
+		percent := before
+		score, scoredRule := scores[key.String()]
+		switch {
+		case scoredRule:
+			percent = webhook.SpotScorePercent(score, r.Threshold, r.MinWeightPercent)
+		case !fetchFailed:
+			percent = 100
+		}
+		if percent != before {
+			if scoredRule {
+				changes = append(changes, fmt.Sprintf("rule %s from %d%% to %d%% of weight %d at spot placement score %d", key, before, percent, rule.Weight, score))
+			} else {
+				changes = append(changes, fmt.Sprintf("rule %s back to weight %d, its pool has no score", key, rule.Weight))
+			}
+		}
+		if percent < 100 {
+			desired[key] = percent
+		}
+	}
+
+	if !maps.Equal(desired, current) {
+		if err := r.patchWeights(ctx, deployment, desired); err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		r.Log.Info("Adjusted spot rule weights", "deployment", deployment.Namespace+"/"+deployment.Name, "changes", changes)
+		r.createScoreEvent(ctx, deployment, "Adjusted the weight of "+strings.Join(changes, "; "))
+	}
+	return nil
+}
+
+// scoredStrategy returns the deployment's strategy if its spot rules can be adjusted: a valid
+// weighted strategy with a spot rule and another rule, outside the protected namespaces
+func (r *SpotScoreController) scoredStrategy(deployment *appsv1.Deployment) *webhook.PlacementStrategy {
+	if r.Namespaces.Protected(deployment.Namespace) || deployment.DeletionTimestamp != nil {
+		return nil
+	}
+	annotation, ok, _ := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+	if !ok {
+		return nil
+	}
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil || strategy.IsFailover() || len(strategy.Rules) < 2 {
+		return nil
+	}
+	for _, rule := range strategy.Rules {
+		if webhook.SpotRule(rule) {
+			return strategy
+		}
+	}
+	return nil
+}
+
+// pools describes the pool of each spot rule by the nodes it selects, sorted by key. Rules
+// selecting no node are left out.
+func (r *SpotScoreController) pools(ctx context.Context, rules map[webhook.RuleKey]webhook.PlacementRule) ([]spotscore.Pool, error) {
+	var pools []spotscore.Pool
+	for key, rule := range rules {
+		nodes := &corev1.NodeList{}
+		if err := r.List(ctx, nodes, client.MatchingLabels(rule.NodeSelector)); err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %w", err)
+		}
+		if len(nodes.Items) == 0 {
+			continue
+		}
+
+		pool := spotscore.Pool{Key: key.String(), Nodes: len(nodes.Items)}
+		zones := make(map[string]bool)
+		instanceTypes := make(map[string]bool)
+		for _, node := range nodes.Items {
+			if region := node.Labels[corev1.LabelTopologyRegion]; region != "" && pool.Region == "" {
+				pool.Region = region
+			}
+			if zone := node.Labels[corev1.LabelTopologyZone]; zone != "" {
+				zones[zone] = true
+			}
+			if instanceType := node.Labels[corev1.LabelInstanceTypeStable]; instanceType != "" {
+				instanceTypes[instanceType] = true
+			}
+		}
+		pool.Zones = sortedKeys(zones)
+		pool.InstanceTypes = sortedKeys(instanceTypes)
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Key < pools[j].Key })
+	return pools, nil
+}
+
+// sortedKeys returns the keys of the set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// patchWeights writes the deployment's spot score weights, removing the annotation when there are none
+func (r *SpotScoreController) patchWeights(ctx context.Context, deployment *appsv1.Deployment, weights map[webhook.RuleKey]int) error {
+	patch := client.MergeFrom(deployment.DeepCopy())
+	if len(weights) == 0 {
+		delete(deployment.Annotations, webhook.SpotScoreWeightsAnnotation)
+	} else {
+		data, err := json.Marshal(weights)
+		if err != nil {
+			return err
+		}
+		if deployment.Annotations == nil {
+			deployment.Annotations = make(map[string]string)
+		}
+		deployment.Annotations[webhook.SpotScoreWeightsAnnotation] = string(data)
+	}
+	if err := r.Patch(ctx, deployment, patch); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to record spot score weights on deployment: %w", err)
+	}
+	return nil
+}
+
+// createScoreEvent reports spot score weight changes as a Normal event on the deployment
+func (r *SpotScoreController) createScoreEvent(ctx context.Context, deployment *appsv1.Deployment, message string) {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployment.Name + "-",
+			Namespace:    deployment.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Deployment",
+			Name:       deployment.Name,
+			Namespace:  deployment.Namespace,
+			UID:        deployment.UID,
+			APIVersion: "apps/v1",
+		},
+		Reason:  "SpotScoreWeightAdjusted",
+		Message: message,
+		Type:    corev1.EventTypeNormal,
+		Source: corev1.EventSource{
+			Component: "smart-scheduler-controller",
+		},
+		FirstTimestamp: metav1.NewTime(time.Now()),
+		LastTimestamp:  metav1.NewTime(time.Now()),
+	}
+
+	if err := r.Create(ctx, event); err != nil {
+		r.Log.Error(err, "Failed to create spot score event")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. Its one request is queued at start
+// and requeued every Interval.
+func (r *SpotScoreController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Interval <= 0 {
+		r.Interval = DefaultSpotScoreInterval
+	}
+	if r.Threshold <= 0 || r.Threshold > 10 {
+		r.Threshold = DefaultSpotScoreThreshold
+	}
+	if r.MinWeightPercent <= 0 || r.MinWeightPercent > 100 {
+		r.MinWeightPercent = DefaultSpotScoreMinWeightPercent
+	}
+
+	start := make(chan event.GenericEvent, 1)
+	start <- event.GenericEvent{Object: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "spotscores"}}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("spotscores").
+		WatchesRawSource(&source.Channel{Source: start}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
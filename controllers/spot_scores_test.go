@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/spotscore"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const scoredStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// fakeScoreProvider returns fixed scores and records the pools it was asked to score
+type fakeScoreProvider struct {
+	scores map[string]int
+	err    error
+	pools  [][]spotscore.Pool
+}
+
+func (p *fakeScoreProvider) Scores(_ context.Context, pools []spotscore.Pool) (map[string]int, error) {
+	p.pools = append(p.pools, pools)
+	return p.scores, p.err
+}
+
+// spotNode returns a node of the pool in the zone of us-east-1
+func spotNode(name, pool, zone, instanceType string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+		"node-type":                    pool,
+		corev1.LabelTopologyRegion:     "us-east-1",
+		corev1.LabelTopologyZone:       "us-east-1" + zone,
+		corev1.LabelInstanceTypeStable: instanceType,
+	}}}
+}
+
+func TestSpotScoreReconcile(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		scores      map[string]int
+		fetchErr    error
+		// want is the spot score weights annotation after the reconcile, or "" when it is removed
+		want      string
+		wantEvent bool
+	}{
+		{
+			name:        "Low score lowers the spot rule",
+			annotations: map[string]string{webhook.ScheduleStrategyAnnotation: scoredStrategy},
+			scores:      map[string]int{"node-type=spot": 4},
+			want:        `{"node-type=spot":62}`,
+			wantEvent:   true,
+		},
+		{
+			name: "Score at the threshold restores the weight",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: scoredStrategy,
+				webhook.SpotScoreWeightsAnnotation: `{"node-type=spot":62}`,
+			},
+			scores:    map[string]int{"node-type=spot": 7},
+			wantEvent: true,
+		},
+		{
+			name: "Unchanged score leaves the weight",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: scoredStrategy,
+				webhook.SpotScoreWeightsAnnotation: `{"node-type=spot":62}`,
+			},
+			scores: map[string]int{"node-type=spot": 4},
+			want:   `{"node-type=spot":62}`,
+		},
+		{
+			name: "Failed fetch keeps the last weight",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: scoredStrategy,
+				webhook.SpotScoreWeightsAnnotation: `{"node-type=spot":62}`,
+			},
+			fetchErr: errors.New("throttled"),
+			want:     `{"node-type=spot":62}`,
+		},
+		{
+			name: "Pool the provider has no score for gets its weight back",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation: scoredStrategy,
+				webhook.SpotScoreWeightsAnnotation: `{"node-type=spot":62}`,
+			},
+			wantEvent: true,
+		},
+		{
+			name:        "Deployment without spot rules drops its weights",
+			annotations: map[string]string{webhook.SpotScoreWeightsAnnotation: `{"node-type=spot":62}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment, objects := testWorkload("web", tt.annotations, "ondemand", "spot")
+			objects = append(objects, spotNode("spot-0", "spot", "a", "m5.large"), spotNode("ondemand-0", "ondemand", "a", "m5.large"))
+			c := newFakeClient(t, objects...)
+			provider := &fakeScoreProvider{scores: tt.scores, err: tt.fetchErr}
+			r := &SpotScoreController{
+				Client: c, Log: logr.Discard(), Provider: provider, ProviderName: "fake",
+				Interval: DefaultSpotScoreInterval, Threshold: DefaultSpotScoreThreshold, MinWeightPercent: DefaultSpotScoreMinWeightPercent,
+			}
+			ctx := context.Background()
+
+			result, err := r.Reconcile(ctx, ctrl.Request{})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.RequeueAfter != DefaultSpotScoreInterval {
+				t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, DefaultSpotScoreInterval)
+			}
+
+			current := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), current); err != nil {
+				t.Fatal(err)
+			}
+			if got := current.Annotations[webhook.SpotScoreWeightsAnnotation]; got != tt.want {
+				t.Errorf("%s = %q, want %q", webhook.SpotScoreWeightsAnnotation, got, tt.want)
+			}
+
+			events := &corev1.EventList{}
+			if err := c.List(ctx, events, client.InNamespace(deployment.Namespace)); err != nil {
+				t.Fatal(err)
+			}
+			adjusted := false
+			for _, event := range events.Items {
+				if event.Reason == "SpotScoreWeightAdjusted" && event.InvolvedObject.Name == deployment.Name {
+					adjusted = true
+				}
+			}
+			if adjusted != tt.wantEvent {
+				t.Errorf("SpotScoreWeightAdjusted event created = %v, want %v", adjusted, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestSpotScorePools(t *testing.T) {
+	// Two deployments share the spot rule, whose pool is scored once; the GKE spot rule selects no node
+	web, objects := testWorkload("web", map[string]string{webhook.ScheduleStrategyAnnotation: scoredStrategy}, "spot")
+	api, apiObjects := testWorkload("api", map[string]string{
+		webhook.ScheduleStrategyAnnotation: scoredStrategy + ";weight=1,nodeSelector=cloud.google.com/gke-spot:true",
+	}, "spot")
+	objects = append(objects, apiObjects...)
+	objects = append(objects,
+		spotNode("spot-0", "spot", "a", "m5.large"),
+		spotNode("spot-1", "spot", "b", "m5.xlarge"),
+		spotNode("spot-2", "spot", "b", "m5.large"),
+		spotNode("ondemand-0", "ondemand", "a", "m5.large"),
+	)
+	c := newFakeClient(t, objects...)
+	provider := &fakeScoreProvider{scores: map[string]int{"node-type=spot": 1}}
+	r := &SpotScoreController{
+		Client: c, Log: logr.Discard(), Provider: provider, ProviderName: "fake",
+		Interval: DefaultSpotScoreInterval, Threshold: DefaultSpotScoreThreshold, MinWeightPercent: DefaultSpotScoreMinWeightPercent,
+	}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	want := [][]spotscore.Pool{{{
+		Key:           "node-type=spot",
+		Region:        "us-east-1",
+		Zones:         []string{"us-east-1a", "us-east-1b"},
+		InstanceTypes: []string{"m5.large", "m5.xlarge"},
+		Nodes:         3,
+	}}}
+	if !reflect.DeepEqual(provider.pools, want) {
+		t.Errorf("Scored pools = %+v, want %+v", provider.pools, want)
+	}
+
+	for _, deployment := range []*appsv1.Deployment{web, api} {
+		current := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), current); err != nil {
+			t.Fatal(err)
+		}
+		if got := current.Annotations[webhook.SpotScoreWeightsAnnotation]; got != `{"node-type=spot":25}` {
+			t.Errorf("%s of %s = %q, want the minimum weight at a score of 1", webhook.SpotScoreWeightsAnnotation, deployment.Name, got)
+		}
+	}
+}
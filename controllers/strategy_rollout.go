@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// strategyRolloutKey keys the rollout in the deployment's placement state ConfigMap
+const strategyRolloutKey = "strategy-rollout"
+
+// Annotations earlier versions recorded the rollout in on the deployment. They are moved to the
+// placement state ConfigMap when the rebalancer next observes the deployment.
+const (
+	// rolloutStrategyAnnotation records the strategy the rebalancer last observed on the deployment
+	rolloutStrategyAnnotation = "smart-scheduler.io/rebalance-observed-strategy"
+	// rolloutStartedAnnotation records when the rebalancer noticed the strategy change
+	rolloutStartedAnnotation = "smart-scheduler.io/rebalance-rollout-started"
+	// rolloutEvictionsAnnotation counts pods evicted since the strategy change
+	rolloutEvictionsAnnotation = "smart-scheduler.io/rebalance-rollout-evictions"
+	// rolloutRemainingAnnotation counts the pods a paced migration still has to move
+	rolloutRemainingAnnotation = "smart-scheduler.io/rebalance-rollout-remaining"
+)
+
+// strategyRollout tracks the rebalancing that follows a strategy edit on one deployment
+type strategyRollout struct {
+	// Strategy is the strategy the rebalancer last observed on the deployment
+	Strategy string `json:"strategy"`
+	// Started is zero when no strategy change is being rolled out
+	Started   time.Time `json:"started,omitempty"`
+	Evictions int       `json:"evictions,omitempty"`
+	// Remaining are the pods a paced migration still has to move, as of the last rebalance check;
+	// -1 when not known
+	Remaining int `json:"remaining"`
+}
+
+// Active reports whether a strategy change is still being rolled out
+func (s strategyRollout) Active() bool {
+	return !s.Started.IsZero()
+}
+
+// observeStrategyChange compares the deployment's strategy with the one last observed and starts a
+// rollout when it changed. The first observation of a deployment records its strategy without a
+// rollout, so upgrading the operator does not hold rebalancing of existing deployments.
+func (r *RebalanceController) observeStrategyChange(ctx context.Context, deployment *appsv1.Deployment, scheduleStrategy string) (strategyRollout, error) {
+	rollout, seen, err := loadStrategyRollout(ctx, r.StateManager, deployment)
+	if err != nil {
+		return strategyRollout{}, err
+	}
+	if _, legacy := deployment.Annotations[rolloutStrategyAnnotation]; legacy {
+		if err := r.migrateRolloutAnnotations(ctx, deployment, rollout); err != nil {
+			return strategyRollout{}, err
+		}
+	}
+	if seen && rollout.Strategy == scheduleStrategy {
+		return rollout, nil
+	}
+
+	rollout = strategyRollout{Strategy: scheduleStrategy, Remaining: -1}
+	if seen {
+		rollout.Started = time.Now()
+	}
+	if err := r.saveStrategyRollout(ctx, deployment, rollout); err != nil {
+		return strategyRollout{}, err
+	}
+
+	if rollout.Active() {
+		gracePeriod, maxEvictions := r.strategyChangeLimits()
+		message := fmt.Sprintf("Placement strategy changed, rebalancing starts after %s", gracePeriod)
+		if maxEvictions > 0 {
+			message += fmt.Sprintf(" and evicts at most %d pods", maxEvictions)
+		}
+		if migration := r.migrationFor(deployment); migration != nil {
+			message += fmt.Sprintf(", moving %d pods every %s", migration.StepPods(deployment), migration.StepInterval())
+		}
+		r.createRebalanceEvent(ctx, deployment, "", "StrategyChanged", message)
+	}
+	return rollout, nil
+}
+
+// recordRolloutEvictions adds evicted pods to the active rollout's budget
+func (r *RebalanceController) recordRolloutEvictions(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout, evicted int) error {
+	if !rollout.Active() || evicted == 0 {
+		return nil
+	}
+	rollout.Evictions += evicted
+	return r.saveStrategyRollout(ctx, deployment, rollout)
+}
+
+// finishRollout ends the rollout once the deployment no longer drifts from its strategy
+func (r *RebalanceController) finishRollout(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout) error {
+	if !rollout.Active() {
+		return nil
+	}
+	return r.saveStrategyRollout(ctx, deployment, strategyRollout{Strategy: rollout.Strategy, Remaining: -1})
+}
+
+// migrationFor returns the deployment's paced migration, or nil when rollouts are not paced. An
+// invalid migration annotation is ignored.
+func (r *RebalanceController) migrationFor(deployment *appsv1.Deployment) *webhook.Migration {
+	data, ok := deployment.Annotations[webhook.MigrationAnnotation]
+	if !ok {
+		return nil
+	}
+	migration, err := webhook.ParseMigration(data)
+	if err != nil {
+		r.Log.Error(err, "Ignoring migration, rebalancing without a pace", "deployment", deployment.Name, "namespace", deployment.Namespace)
+		return nil
+	}
+	return migration
+}
+
+// migrationEvictionsLeft returns how many more pods the active rollout may evict in the steps of
+// the deployment's migration so far, and how long until the next step. Steps start when the grace
+// period ends, so a rollout held by it does not start with several steps at once. It returns -1
+// when the rollout is not paced.
+func (r *RebalanceController) migrationEvictionsLeft(deployment *appsv1.Deployment, rollout strategyRollout, now time.Time) (int, time.Duration) {
+	migration := r.migrationFor(deployment)
+	if !rollout.Active() || migration == nil {
+		return -1, 0
+	}
+	gracePeriod, _ := r.strategyChangeLimits()
+	start := rollout.Started.Add(gracePeriod)
+	if now.Before(start) {
+		return 0, start.Sub(now)
+	}
+
+	interval := migration.StepInterval()
+	steps := int(now.Sub(start)/interval) + 1
+	left := max(steps*migration.StepPods(deployment)-rollout.Evictions, 0)
+	return left, start.Add(time.Duration(steps) * interval).Sub(now)
+}
+
+// recordMigrationProgress records the pods the active rollout still has to move: the pods above
+// the expected count of their rule. It returns the rollout with the recorded progress.
+func (r *RebalanceController) recordMigrationProgress(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout, drift *DriftReport) (strategyRollout, error) {
+	if !rollout.Active() || r.migrationFor(deployment) == nil {
+		return rollout, nil
+	}
+	remaining := 0
+	for key, actual := range drift.ActualCounts {
+		remaining += max(actual-drift.ExpectedCounts[key], 0)
+	}
+	if remaining == rollout.Remaining {
+		return rollout, nil
+	}
+	rollout.Remaining = remaining
+	return rollout, r.saveStrategyRollout(ctx, deployment, rollout)
+}
+
+// rolloutHold returns how long evictions must still wait for the strategy change grace period
+// and whether the rollout's eviction budget is used up
+func (r *RebalanceController) rolloutHold(rollout strategyRollout) (time.Duration, bool) {
+	if !rollout.Active() {
+		return 0, false
+	}
+	gracePeriod, maxEvictions := r.strategyChangeLimits()
+	wait := time.Until(rollout.Started.Add(gracePeriod))
+	exhausted := maxEvictions > 0 && rollout.Evictions >= maxEvictions
+	return wait, exhausted
+}
+
+// rolloutEvictionsLeft returns how many more pods the active rollout may evict, or -1 when its
+// evictions are not limited
+func (r *RebalanceController) rolloutEvictionsLeft(rollout strategyRollout) int {
+	_, maxEvictions := r.strategyChangeLimits()
+	if !rollout.Active() || maxEvictions <= 0 {
+		return -1
+	}
+	return max(maxEvictions-rollout.Evictions, 0)
+}
+
+// SetStrategyChangeLimits replaces the grace period and eviction budget of strategy changes,
+// including rollouts already in progress
+func (r *RebalanceController) SetStrategyChangeLimits(gracePeriod time.Duration, maxEvictions int) {
+	r.limitsMu.Lock()
+	defer r.limitsMu.Unlock()
+	r.StrategyChangeGracePeriod = gracePeriod
+	r.MaxEvictionsPerStrategyChange = maxEvictions
+}
+
+// strategyChangeLimits returns the grace period and eviction budget of strategy changes
+func (r *RebalanceController) strategyChangeLimits() (time.Duration, int) {
+	r.limitsMu.RLock()
+	defer r.limitsMu.RUnlock()
+	return r.StrategyChangeGracePeriod, r.MaxEvictionsPerStrategyChange
+}
+
+// saveStrategyRollout records the rollout in the deployment's placement state ConfigMap
+func (r *RebalanceController) saveStrategyRollout(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout) error {
+	data, err := json.Marshal(rollout)
+	if err != nil {
+		return fmt.Errorf("failed to marshal strategy rollout: %w", err)
+	}
+	if err := r.StateManager.WriteStateEntry(ctx, deployment, strategyRolloutKey, string(data)); err != nil {
+		return fmt.Errorf("failed to record strategy rollout: %w", err)
+	}
+	return nil
+}
+
+// migrateRolloutAnnotations records the rollout read from the annotations of earlier versions in
+// the placement state ConfigMap and removes them from the deployment
+func (r *RebalanceController) migrateRolloutAnnotations(ctx context.Context, deployment *appsv1.Deployment, rollout strategyRollout) error {
+	if err := r.saveStrategyRollout(ctx, deployment, rollout); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(deployment.DeepCopy())
+	for _, annotation := range []string{rolloutStrategyAnnotation, rolloutStartedAnnotation, rolloutEvictionsAnnotation, rolloutRemainingAnnotation} {
+		delete(deployment.Annotations, annotation)
+	}
+	if err := webhook.PatchWorkload(ctx, r.Client, deployment, patch); err != nil {
+		return fmt.Errorf("failed to remove strategy rollout annotations from deployment: %w", err)
+	}
+	return nil
+}
+
+// loadStrategyRollout reads the deployment's rollout from its placement state ConfigMap, or from
+// the annotations of earlier versions when the ConfigMap has none. It reports whether a strategy
+// was observed before.
+func loadStrategyRollout(ctx context.Context, states *webhook.StateManager, deployment *appsv1.Deployment) (strategyRollout, bool, error) {
+	data, err := states.ReadStateEntry(ctx, deployment, strategyRolloutKey)
+	if err != nil {
+		return strategyRollout{}, false, fmt.Errorf("failed to read strategy rollout: %w", err)
+	}
+	if data == "" {
+		observed, seen := deployment.Annotations[rolloutStrategyAnnotation]
+		rollout := parseStrategyRollout(deployment.Annotations)
+		rollout.Strategy = observed
+		return rollout, seen, nil
+	}
+
+	rollout := strategyRollout{Remaining: -1}
+	if err := json.Unmarshal([]byte(data), &rollout); err != nil {
+		return strategyRollout{}, false, fmt.Errorf("failed to parse strategy rollout: %w", err)
+	}
+	return rollout, true, nil
+}
+
+// parseStrategyRollout reads the rollout earlier versions recorded in the deployment's annotations
+func parseStrategyRollout(annotations map[string]string) strategyRollout {
+	rollout := strategyRollout{Remaining: -1}
+	if started, err := time.Parse(time.RFC3339, annotations[rolloutStartedAnnotation]); err == nil {
+		rollout.Started = started
+	}
+	if evictions, err := strconv.Atoi(annotations[rolloutEvictionsAnnotation]); err == nil {
+		rollout.Evictions = evictions
+	}
+	if remaining, err := strconv.Atoi(annotations[rolloutRemainingAnnotation]); err == nil {
+		rollout.Remaining = remaining
+	}
+	return rollout
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultTenantQPS and defaultTenantBurst limit the API requests made for one namespace
+	defaultTenantQPS   = 5
+	defaultTenantBurst = 10
+)
+
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+
+// TenantClients hands out clients that impersonate a service account in the namespace they
+// write to. Policy application and evictions then run with that tenant's RBAC, so a policy can
+// never change objects in a namespace whose service account is not allowed to.
+// Each namespace has its own rate limiter, so one busy tenant cannot starve the others.
+type TenantClients struct {
+	config         *rest.Config
+	scheme         *runtime.Scheme
+	mapper         meta.RESTMapper
+	serviceAccount string
+
+	mu      sync.Mutex
+	clients map[string]client.Client
+}
+
+// NewTenantClients returns clients impersonating serviceAccount in each namespace, limited to
+// qps and burst requests per namespace (default: 5 and 10)
+func NewTenantClients(config *rest.Config, scheme *runtime.Scheme, mapper meta.RESTMapper, serviceAccount string, qps float32, burst int) *TenantClients {
+	config = rest.CopyConfig(config)
+	config.QPS = qps
+	if config.QPS <= 0 {
+		config.QPS = defaultTenantQPS
+	}
+	config.Burst = burst
+	if config.Burst <= 0 {
+		config.Burst = defaultTenantBurst
+	}
+
+	return &TenantClients{
+		config:         config,
+		scheme:         scheme,
+		mapper:         mapper,
+		serviceAccount: serviceAccount,
+		clients:        make(map[string]client.Client),
+	}
+}
+
+// ServiceAccount returns the name of the impersonated service account
+func (t *TenantClients) ServiceAccount() string {
+	return t.serviceAccount
+}
+
+// For returns the client for writes in namespace
+func (t *TenantClients) For(namespace string) (client.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[namespace]; ok {
+		return c, nil
+	}
+
+	config := rest.CopyConfig(t.config)
+	config.Impersonate = rest.ImpersonationConfig{
+		// The API server adds the system:serviceaccounts groups to a service account user itself,
+		// so the operator needs no permission to impersonate groups
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, t.serviceAccount),
+	}
+	c, err := client.New(config, client.Options{Scheme: t.scheme, Mapper: t.mapper})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client impersonating %s in namespace %s: %w", t.serviceAccount, namespace, err)
+	}
+	t.clients[namespace] = c
+	return c, nil
+}
+
+// tenantWriter returns the client that writes to namespace: an impersonating one when tenants
+// is set, the manager's otherwise
+func tenantWriter(tenants *TenantClients, fallback client.Client, namespace string) (client.Client, error) {
+	if tenants == nil {
+		return fallback, nil
+	}
+	return tenants.For(namespace)
+}
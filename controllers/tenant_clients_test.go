@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+func TestTenantClientsImpersonateUserOnly(t *testing.T) {
+	var mu sync.Mutex
+	var users []string
+	var groups []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		users = append(users, r.Header.Values("Impersonate-User")...)
+		groups = append(groups, r.Header.Values("Impersonate-Group")...)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"state","namespace":"team-a"}}`))
+	}))
+	defer server.Close()
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	tenants := NewTenantClients(&rest.Config{Host: server.URL}, clientgoscheme.Scheme, mapper, "placer", 0, 0)
+	c, err := tenants.For("team-a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again, _ := tenants.For("team-a"); again != c {
+		t.Error("Expected the client of a namespace to be reused")
+	}
+
+	var cm corev1.ConfigMap
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "state"}, &cm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(users) != 1 || users[0] != "system:serviceaccount:team-a:placer" {
+		t.Errorf("Expected the service account of the namespace to be impersonated, got %v", users)
+	}
+	if len(groups) != 0 {
+		t.Errorf("Expected no groups to be impersonated, got %v", groups)
+	}
+}
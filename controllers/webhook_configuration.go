@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// WebhookConfiguredCondition reports whether the API server is set up to call the pod webhook
+	WebhookConfiguredCondition = "WebhookConfigured"
+	// ManagerStatusConfigMap holds the manager's conditions in the operator namespace
+	ManagerStatusConfigMap = "smart-scheduler-manager-status"
+	// managerConditionsKey is the key of the JSON conditions in the manager status ConfigMap
+	managerConditionsKey = "conditions"
+	// webhookPath is the path the pod webhook is served at
+	webhookPath = "/mutate-v1-pod"
+	// webhookConfigurationCheckInterval is how often the configuration is checked without changes,
+	// catching expired certificates and lost endpoints
+	webhookConfigurationCheckInterval = time.Minute
+)
+
+// webhookChecks are the checks of the webhook configuration, as named in metrics
+var webhookChecks = []string{"registered", "ca_bundle", "endpoints", "failure_policy", "rules"}
+
+var (
+	webhookConfigurationProblems = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smart_scheduler_webhook_configuration_problems",
+			Help: "1 while a check of the configuration calling the pod webhook fails, by check: registered, ca_bundle, endpoints, failure_policy or rules",
+		},
+		[]string{"check"},
+	)
+	webhookCABundleExpiry = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "smart_scheduler_webhook_ca_bundle_expiry_timestamp_seconds",
+			Help: "Earliest expiry of the certificates in the pod webhook's caBundle, as a Unix timestamp",
+		},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(webhookConfigurationProblems, webhookCABundleExpiry)
+}
+
+// webhookProblem is a failed check of the webhook configuration
+type webhookProblem struct {
+	check   string
+	reason  string
+	message string
+}
+
+// WebhookConfigurationController checks that the API server actually calls the pod webhook: a
+// MutatingWebhookConfiguration must reference the webhook Service with a caBundle that signs the
+// serving certificate, the expected failurePolicy and rules covering pod creation, and the Service
+// must have ready endpoints. A webhook that is silently not called admits every pod unplaced, so
+// failed checks are exported as metrics and as the WebhookConfigured condition of the manager
+// status ConfigMap.
+type WebhookConfigurationController struct {
+	client.Client
+	// Reader reads the webhook Service, its EndpointSlices and the status ConfigMap uncached, since
+	// the operator namespace may be outside the watched namespaces
+	Reader client.Reader
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Namespace and ServiceName name the webhook Service
+	Namespace   string
+	ServiceName string
+	// FailurePolicy is the failurePolicy the configuration is expected to have
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// ServingCertFile, when it can be read, must be signed by the caBundle. Processes that do not
+	// serve the webhook have no certificate and skip the check.
+	ServingCertFile string
+}
+
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=list
+
+// Reconcile checks the webhook configuration and records the result
+func (r *WebhookConfigurationController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("service", req.NamespacedName)
+
+	problems, err := r.check(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	failed := make(map[string]bool, len(problems))
+	for _, problem := range problems {
+		failed[problem.check] = true
+	}
+	for _, check := range webhookChecks {
+		value := 0.0
+		if failed[check] {
+			value = 1
+		}
+		webhookConfigurationProblems.WithLabelValues(check).Set(value)
+	}
+
+	condition := metav1.Condition{
+		Type:    WebhookConfiguredCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Configured",
+		Message: fmt.Sprintf("The API server calls the webhook through service %s/%s", r.Namespace, r.ServiceName),
+	}
+	if len(problems) > 0 {
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.message)
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = problems[0].reason
+		condition.Message = strings.Join(messages, "; ")
+	}
+
+	changed, err := r.recordCondition(ctx, condition)
+	if err != nil {
+		log.Error(err, "Failed to record the webhook configuration condition")
+		return ctrl.Result{}, err
+	}
+	if changed {
+		if len(problems) > 0 {
+			log.Info("Webhook configuration has problems, pods may be admitted without placement",
+				"reason", condition.Reason, "message", condition.Message)
+		} else {
+			log.Info("Webhook configuration is healthy")
+		}
+	}
+	return ctrl.Result{RequeueAfter: webhookConfigurationCheckInterval}, nil
+}
+
+// check returns the failed checks of every webhook calling the Service, and of the Service itself
+func (r *WebhookConfigurationController) check(ctx context.Context) ([]webhookProblem, error) {
+	configurations := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := r.List(ctx, configurations); err != nil {
+		return nil, fmt.Errorf("failed to list mutating webhook configurations: %w", err)
+	}
+
+	var problems []webhookProblem
+	registered := false
+	for _, configuration := range configurations.Items {
+		for i := range configuration.Webhooks {
+			hook := &configuration.Webhooks[i]
+			service := hook.ClientConfig.Service
+			if service == nil || service.Namespace != r.Namespace || service.Name != r.ServiceName {
+				continue
+			}
+			registered = true
+			problems = append(problems, r.checkWebhook(configuration.Name, hook)...)
+		}
+	}
+	if !registered {
+		problems = append(problems, webhookProblem{"registered", "NotRegistered",
+			fmt.Sprintf("No MutatingWebhookConfiguration calls service %s/%s", r.Namespace, r.ServiceName)})
+	}
+
+	problem, err := r.checkEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil {
+		problems = append(problems, *problem)
+	}
+	return problems, nil
+}
+
+// checkWebhook returns the failed checks of one webhook calling the Service
+func (r *WebhookConfigurationController) checkWebhook(configuration string, hook *admissionregistrationv1.MutatingWebhook) []webhookProblem {
+	name := configuration + "/" + hook.Name
+	var problems []webhookProblem
+
+	if err := r.checkCABundle(hook.ClientConfig.CABundle, time.Now()); err != nil {
+		problems = append(problems, webhookProblem{"ca_bundle", "CABundleInvalid", fmt.Sprintf("%s: %v", name, err)})
+	}
+
+	// The API server defaults a missing failurePolicy to Fail
+	failurePolicy := admissionregistrationv1.Fail
+	if hook.FailurePolicy != nil {
+		failurePolicy = *hook.FailurePolicy
+	}
+	if r.FailurePolicy != "" && failurePolicy != r.FailurePolicy {
+		problems = append(problems, webhookProblem{"failure_policy", "FailurePolicyMismatch",
+			fmt.Sprintf("%s: failurePolicy is %s, expected %s", name, failurePolicy, r.FailurePolicy)})
+	}
+
+	if path := hook.ClientConfig.Service.Path; path == nil || *path != webhookPath {
+		problems = append(problems, webhookProblem{"rules", "WrongPath", fmt.Sprintf("%s: path is not %s", name, webhookPath)})
+	} else if !coversPodCreation(hook.Rules) {
+		problems = append(problems, webhookProblem{"rules", "PodsNotCovered", fmt.Sprintf("%s: rules do not cover pod creation", name)})
+	}
+	return problems
+}
+
+// checkCABundle checks that the bundle holds certificates valid at now that sign the serving
+// certificate, and exports the earliest expiry
+func (r *WebhookConfigurationController) checkCABundle(bundle []byte, now time.Time) error {
+	if len(bytes.TrimSpace(bundle)) == 0 {
+		return errors.New("caBundle is empty")
+	}
+
+	roots := x509.NewCertPool()
+	var expiry time.Time
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("caBundle holds an invalid certificate: %w", err)
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("caBundle certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		}
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("caBundle certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+		}
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+		roots.AddCert(cert)
+	}
+	if expiry.IsZero() {
+		return errors.New("caBundle holds no PEM certificate")
+	}
+	webhookCABundleExpiry.Set(float64(expiry.Unix()))
+
+	if r.ServingCertFile == "" {
+		return nil
+	}
+	servingCert, err := os.ReadFile(r.ServingCertFile)
+	if err != nil {
+		// Only processes serving the webhook have the certificate
+		return nil
+	}
+	return verifyServingCert(servingCert, roots, r.ServiceName+"."+r.Namespace+".svc", now)
+}
+
+// verifyServingCert checks that the PEM serving certificate chains to roots for the host
+func verifyServingCert(data []byte, roots *x509.CertPool, host string, now time.Time) error {
+	var chain []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid serving certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return errors.New("serving certificate holds no PEM certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       host,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		return fmt.Errorf("serving certificate is not trusted by the caBundle: %w", err)
+	}
+	return nil
+}
+
+// coversPodCreation reports whether the rules send the creation of v1 pods to the webhook
+func coversPodCreation(rules []admissionregistrationv1.RuleWithOperations) bool {
+	matches := func(values []string, want string) bool {
+		for _, value := range values {
+			if value == want || value == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range rules {
+		operation := false
+		for _, op := range rule.Operations {
+			if op == admissionregistrationv1.Create || op == admissionregistrationv1.OperationAll {
+				operation = true
+			}
+		}
+		if operation && matches(rule.APIGroups, "") && matches(rule.APIVersions, "v1") &&
+			(matches(rule.Resources, "pods") || matches(rule.Resources, "*/*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEndpoints returns a problem when the Service is missing or has no ready endpoint
+func (r *WebhookConfigurationController) checkEndpoints(ctx context.Context) (*webhookProblem, error) {
+	service := &corev1.Service{}
+	if err := r.Reader.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: r.ServiceName}, service); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &webhookProblem{"endpoints", "ServiceMissing", fmt.Sprintf("Service %s/%s does not exist", r.Namespace, r.ServiceName)}, nil
+		}
+		return nil, fmt.Errorf("failed to get webhook service: %w", err)
+	}
+
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.Reader.List(ctx, slices, client.InNamespace(r.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: r.ServiceName}); err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return nil, nil
+			}
+		}
+	}
+	return &webhookProblem{"endpoints", "NoReadyEndpoints", fmt.Sprintf("Service %s/%s has no ready endpoint", r.Namespace, r.ServiceName)}, nil
+}
+
+// recordCondition sets the condition in the manager status ConfigMap, reporting whether it changed
+func (r *WebhookConfigurationController) recordCondition(ctx context.Context, condition metav1.Condition) (bool, error) {
+	configMap := &corev1.ConfigMap{}
+	err := r.Reader.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: ManagerStatusConfigMap}, configMap)
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	var conditions []metav1.Condition
+	if data := configMap.Data[managerConditionsKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &conditions); err != nil {
+			r.Log.Error(err, "Replacing unreadable manager conditions")
+			conditions = nil
+		}
+	}
+	meta.SetStatusCondition(&conditions, condition)
+	data, err := json.Marshal(conditions)
+	if err != nil {
+		return false, err
+	}
+	if exists && configMap.Data[managerConditionsKey] == string(data) {
+		return false, nil
+	}
+
+	if !exists {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ManagerStatusConfigMap,
+				Namespace: r.Namespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":      "smart-scheduler",
+					"app.kubernetes.io/component": "manager-status",
+				},
+			},
+			Data: map[string]string{managerConditionsKey: string(data)},
+		}
+		return true, r.Create(ctx, configMap)
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[managerConditionsKey] = string(data)
+	return true, r.Update(ctx, configMap)
+}
+
+// SetupWithManager sets up the controller with the Manager. Every event checks the one Service.
+func (r *WebhookConfigurationController) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Reader == nil {
+		r.Reader = mgr.GetAPIReader()
+	}
+
+	service := types.NamespacedName{Namespace: r.Namespace, Name: r.ServiceName}
+	toService := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []ctrl.Request {
+		return []ctrl.Request{{NamespacedName: service}}
+	})
+	// The first check runs at start, so a missing configuration is reported too
+	start := make(chan event.GenericEvent, 1)
+	start <- event.GenericEvent{Object: &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: r.Namespace, Name: r.ServiceName}}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("webhookconfig").
+		Watches(&admissionregistrationv1.MutatingWebhookConfiguration{}, toService).
+		WatchesRawSource(&source.Channel{Source: start}, toService).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
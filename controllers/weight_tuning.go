@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const (
+	// DefaultWeightTuningWindow is how long preemptions count against a rule
+	DefaultWeightTuningWindow = time.Hour
+	// DefaultWeightTuningInterval is how often a rule's weight is stepped
+	DefaultWeightTuningInterval = 10 * time.Minute
+	// DefaultPreemptionThreshold is the preemptions per pod of a rule within the window above
+	// which its weight is lowered
+	DefaultPreemptionThreshold = 0.1
+	// DefaultMinWeightPercent is how far a rule's weight can be lowered, in percent of its configured weight
+	DefaultMinWeightPercent = 50
+
+	// weightTuningStep is the percent of the configured weight a rule's weight moves per interval
+	weightTuningStep = 10
+)
+
+var weightAdjustments = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_weight_adjustments_total",
+		Help: "Rule weight adjustments by the weight tuning controller, by namespace and direction: lowered (the rule's pods were preempted often) or restored (a window passed without preemptions)",
+	},
+	[]string{"namespace", "direction"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(weightAdjustments)
+}
+
+// rulePreemptionKey identifies the pods of a deployment placed by one rule
+type rulePreemptionKey struct {
+	namespace, deployment string
+	rule                  webhook.RuleKey
+}
+
+// preemptionHistory remembers when the pods of each rule were preempted, and when each deployment's
+// weights were last stepped. Preempted pods are gone by the next reconcile, so they are recorded
+// when their deletion is observed; the history starts over when the controller restarts.
+type preemptionHistory struct {
+	mu      sync.Mutex
+	times   map[rulePreemptionKey][]time.Time
+	stepped map[types.NamespacedName]time.Time
+}
+
+// newPreemptionHistory creates an empty preemption history
+func newPreemptionHistory() *preemptionHistory {
+	return &preemptionHistory{
+		times:   make(map[rulePreemptionKey][]time.Time),
+		stepped: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// record remembers the preemption of a placed pod of a deployment
+func (h *preemptionHistory) record(pod *corev1.Pod, now time.Time) {
+	rule, ok := pod.Annotations["smart-scheduler.io/placement-rule"]
+	if !ok || !podPreempted(pod) {
+		return
+	}
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "ReplicaSet" {
+		return
+	}
+	deployment, ok := webhook.DeploymentNameFromPodTemplateHash(ownerRef.Name, pod)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := rulePreemptionKey{pod.Namespace, deployment, webhook.CanonicalRuleKey(rule)}
+	h.times[key] = append(h.times[key], now)
+}
+
+// count returns the preemptions of a rule's pods since the given time, forgetting older ones
+func (h *preemptionHistory) count(namespace, deployment string, rule webhook.RuleKey, since time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := rulePreemptionKey{namespace, deployment, rule}
+	times := h.times[key]
+	expired := 0
+	for expired < len(times) && !times[expired].After(since) {
+		expired++
+	}
+	if expired == len(times) {
+		delete(h.times, key)
+		return 0
+	}
+	h.times[key] = times[expired:]
+	return len(times) - expired
+}
+
+// due reports whether a deployment's weights were last stepped at least an interval ago, and if so
+// records that they are stepped now
+func (h *preemptionHistory) due(deployment types.NamespacedName, interval time.Duration, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if last, ok := h.stepped[deployment]; ok && now.Sub(last) < interval {
+		return false
+	}
+	h.stepped[deployment] = now
+	return true
+}
+
+// forget drops the history of a deleted deployment
+func (h *preemptionHistory) forget(deployment types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.stepped, deployment)
+	for key := range h.times {
+		if key.namespace == deployment.Namespace && key.deployment == deployment.Name {
+			delete(h.times, key)
+		}
+	}
+}
+
+// handler returns an event handler recording preempted pods as they are deleted
+func (h *preemptionHistory) handler() handler.EventHandler {
+	return handler.Funcs{
+		DeleteFunc: func(_ context.Context, evt event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+			if pod, ok := evt.Object.(*corev1.Pod); ok {
+				h.record(pod, time.Now())
+			}
+		},
+	}
+}
+
+// podPreempted reports whether the pod was taken down involuntarily: preempted by the scheduler,
+// removed with its lost or reclaimed node, or terminated by the kubelet. Evictions through the
+// Eviction API are drains and rebalancing, which say nothing about the pool.
+func podPreempted(pod *corev1.Pod) bool {
+	if pod.Status.Reason == "Evicted" {
+		return true
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != corev1.DisruptionTarget || condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Reason {
+		case corev1.PodReasonPreemptionByScheduler, corev1.PodReasonTerminationByKubelet, "DeletionByTaintManager", "DeletionByPodGC":
+			return true
+		}
+	}
+	return false
+}
+
+// WeightTuningController lowers the weights of a deployment's rules whose pods are preempted often,
+// so fewer new pods land on unreliable pools, and restores them once the pools settle. Every
+// interval a rule's weight steps down while its preemptions per pod within the window exceed the
+// threshold, never below MinWeightPercent of its configured weight, and steps back up after a
+// window without preemptions. The weights are written to the deployment's
+// webhook.WeightAdjustmentsAnnotation, which the webhook and the rebalancer apply, and every change
+// is logged and reported as an event on the deployment.
+type WeightTuningController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Window is how long preemptions count against a rule (default: 1h)
+	Window time.Duration
+	// Interval is how often a rule's weight is stepped (default: 10m)
+	Interval time.Duration
+	// PreemptionThreshold is the preemptions per pod of a rule within the window above which its
+	// weight is lowered (default: 0.1)
+	PreemptionThreshold float64
+	// MinWeightPercent bounds how far a rule's weight is lowered, in percent of its configured
+	// weight (default: 50)
+	MinWeightPercent int
+	// Namespaces lists namespaces whose deployments are not tuned; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+
+	history *preemptionHistory
+}
+
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile steps the weights of the deployment's rules by the preemptions of their pods
+func (r *WeightTuningController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("deployment", req.NamespacedName)
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, req.NamespacedName, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.history.forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if deployment.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	current, err := webhook.ParseWeightAdjustments(deployment.Annotations[webhook.WeightAdjustmentsAnnotation])
+	if err != nil {
+		log.Error(err, "Discarding invalid weight adjustments")
+		current = nil
+	}
+	strategy := r.tunedStrategy(deployment, log)
+	if strategy == nil {
+		if _, ok := deployment.Annotations[webhook.WeightAdjustmentsAnnotation]; ok {
+			log.Info("Removing weight adjustments of a deployment without a weighted strategy")
+			return ctrl.Result{}, r.patchAdjustments(ctx, deployment, nil)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := webhook.ListStrategyPods(ctx, r.Client, deployment)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list pods: %w", err)
+	}
+	counts := webhook.CountPodsByRule(pods, strategy)
+
+	now := time.Now()
+	step := r.history.due(req.NamespacedName, r.interval(), now)
+	desired := make(map[webhook.RuleKey]int)
+	var changes []string
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		percent, ok := current[key]
+		if !ok {
+			percent = 100
+		}
+		if step && rule.Weight > 0 {
+			preemptions := r.history.count(deployment.Namespace, deployment.Name, key, now.Add(-r.window()))
+			next := r.nextPercent(percent, preemptions, counts[key])
+			if next != percent {
+				direction := "lowered"
+				if next > percent {
+					direction = "restored"
+				}
+				log.Info("Adjusted rule weight", "rule", key, "fromPercent", percent, "toPercent", next,
+					"preemptions", preemptions, "pods", counts[key], "window", r.window().String())
+				weightAdjustments.WithLabelValues(deployment.Namespace, direction).Inc()
+				changes = append(changes, fmt.Sprintf("rule %s %s from %d%% to %d%% of weight %d after %d preemptions of %d pods in %s",
+					key, direction, percent, next, rule.Weight, preemptions, counts[key], r.window()))
+				percent = next
+			}
+		}
+		if percent < 100 {
+			desired[key] = percent
+		}
+	}
+
+	// Adjustments of removed rules are dropped along with the changes
+	if !maps.Equal(desired, current) {
+		if err := r.patchAdjustments(ctx, deployment, desired); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if len(changes) > 0 {
+		r.createTuningEvent(ctx, deployment, "Adjusted the weight of "+strings.Join(changes, "; "))
+	}
+	return ctrl.Result{RequeueAfter: r.interval()}, nil
+}
+
+// tunedStrategy returns the deployment's strategy if its weights can be tuned: a valid weighted
+// strategy with more than one rule, outside the protected namespaces
+func (r *WeightTuningController) tunedStrategy(deployment *appsv1.Deployment, log logr.Logger) *webhook.PlacementStrategy {
+	if r.Namespaces.Protected(deployment.Namespace) {
+		return nil
+	}
+	annotation, ok, err := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+	if err != nil {
+		log.Error(err, "Ignoring priority strategies, using default schedule strategy")
+	}
+	if !ok {
+		return nil
+	}
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		log.Info("Not tuning the weights of an invalid strategy", "error", err.Error())
+		return nil
+	}
+	if strategy.IsFailover() || len(strategy.Rules) < 2 {
+		return nil
+	}
+	return strategy
+}
+
+// nextPercent steps a rule's weight down while its preemptions per pod exceed the threshold, and
+// back up once a window passes without any, within MinWeightPercent and 100 percent
+func (r *WeightTuningController) nextPercent(percent, preemptions, pods int) int {
+	threshold := r.PreemptionThreshold
+	if threshold <= 0 {
+		threshold = DefaultPreemptionThreshold
+	}
+	minPercent := r.MinWeightPercent
+	if minPercent <= 0 || minPercent > 100 {
+		minPercent = DefaultMinWeightPercent
+	}
+
+	switch {
+	case float64(preemptions) > threshold*float64(max(pods, 1)):
+		return max(percent-weightTuningStep, minPercent)
+	case preemptions == 0:
+		return min(percent+weightTuningStep, 100)
+	}
+	return percent
+}
+
+// patchAdjustments writes the deployment's weight adjustments, removing the annotation when there
+// are none
+func (r *WeightTuningController) patchAdjustments(ctx context.Context, deployment *appsv1.Deployment, adjustments map[webhook.RuleKey]int) error {
+	patch := client.MergeFrom(deployment.DeepCopy())
+	if len(adjustments) == 0 {
+		delete(deployment.Annotations, webhook.WeightAdjustmentsAnnotation)
+	} else {
+		data, err := json.Marshal(adjustments)
+		if err != nil {
+			return err
+		}
+		if deployment.Annotations == nil {
+			deployment.Annotations = make(map[string]string)
+		}
+		deployment.Annotations[webhook.WeightAdjustmentsAnnotation] = string(data)
+	}
+	if err := r.Patch(ctx, deployment, patch); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to record weight adjustments on deployment: %w", err)
+	}
+	return nil
+}
+
+// createTuningEvent reports weight adjustments as a Normal event on the deployment
+func (r *WeightTuningController) createTuningEvent(ctx context.Context, deployment *appsv1.Deployment, message string) {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployment.Name + "-",
+			Namespace:    deployment.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Deployment",
+			Name:       deployment.Name,
+			Namespace:  deployment.Namespace,
+			UID:        deployment.UID,
+			APIVersion: "apps/v1",
+		},
+		Reason:  "RuleWeightAdjusted",
+		Message: message,
+		Type:    corev1.EventTypeNormal,
+		Source: corev1.EventSource{
+			Component: "smart-scheduler-controller",
+		},
+		FirstTimestamp: metav1.NewTime(time.Now()),
+		LastTimestamp:  metav1.NewTime(time.Now()),
+	}
+
+	if err := r.Create(ctx, event); err != nil {
+		r.Log.Error(err, "Failed to create weight tuning event")
+	}
+}
+
+// window returns the configured window or its default
+func (r *WeightTuningController) window() time.Duration {
+	if r.Window > 0 {
+		return r.Window
+	}
+	return DefaultWeightTuningWindow
+}
+
+// interval returns the configured interval or its default
+func (r *WeightTuningController) interval() time.Duration {
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return DefaultWeightTuningInterval
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *WeightTuningController) SetupWithManager(mgr ctrl.Manager) error {
+	r.history = newPreemptionHistory()
+
+	// Weights are stepped on a timer; deployment events only pick up new and changed strategies
+	strategyChanged := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldDeployment, oldOk := e.ObjectOld.(*appsv1.Deployment)
+			newDeployment, newOk := e.ObjectNew.(*appsv1.Deployment)
+			if !oldOk || !newOk {
+				return false
+			}
+			for _, annotation := range []string{webhook.ScheduleStrategyAnnotation, webhook.PriorityStrategiesAnnotation} {
+				if oldDeployment.Annotations[annotation] != newDeployment.Annotations[annotation] {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("weighttuning").
+		For(&appsv1.Deployment{}, builder.WithPredicates(strategyChanged)).
+		Watches(&corev1.Pod{}, r.history.handler()).
+		Complete(r)
+}
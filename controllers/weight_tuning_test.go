@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const tunedStrategy = "weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// preempted returns a pod of the ReplicaSet on the node type as it is deleted after its preemption
+func preempted(rs *appsv1.ReplicaSet, i int, nodeType string) *corev1.Pod {
+	pod := testPod(rs, i, nodeType)
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type: corev1.DisruptionTarget, Status: corev1.ConditionTrue, Reason: corev1.PodReasonPreemptionByScheduler,
+	}}
+	return pod
+}
+
+func TestWeightTuningReconcile(t *testing.T) {
+	const interval = time.Minute
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		nodeTypes   []string
+		preemptions int
+		// want is the weight adjustments annotation after the reconcile, or "" when it is removed
+		want      string
+		wantEvent bool
+		requeue   time.Duration
+	}{
+		{
+			name:        "Rule preempted above the threshold is lowered",
+			annotations: map[string]string{webhook.ScheduleStrategyAnnotation: tunedStrategy},
+			nodeTypes:   []string{"ondemand", "spot", "spot"},
+			preemptions: 1,
+			want:        `{"node-type=spot":90}`,
+			wantEvent:   true,
+			requeue:     interval,
+		},
+		{
+			name: "Lowered rule without preemptions is restored",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation:  tunedStrategy,
+				webhook.WeightAdjustmentsAnnotation: `{"node-type=spot":90}`,
+			},
+			nodeTypes: []string{"ondemand", "spot", "spot"},
+			wantEvent: true,
+			requeue:   interval,
+		},
+		{
+			name: "Lowered rule stays at the minimum",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation:  tunedStrategy,
+				webhook.WeightAdjustmentsAnnotation: `{"node-type=spot":50}`,
+			},
+			nodeTypes:   []string{"ondemand", "spot", "spot"},
+			preemptions: 2,
+			want:        `{"node-type=spot":50}`,
+			requeue:     interval,
+		},
+		{
+			name: "Preemptions within the threshold keep the weight",
+			annotations: map[string]string{
+				webhook.ScheduleStrategyAnnotation:  tunedStrategy,
+				webhook.WeightAdjustmentsAnnotation: `{"node-type=spot":80}`,
+			},
+			nodeTypes: []string{
+				"ondemand", "spot", "spot", "spot", "spot", "spot", "spot", "spot", "spot", "spot", "spot",
+			},
+			preemptions: 1,
+			want:        `{"node-type=spot":80}`,
+			requeue:     interval,
+		},
+		{
+			name:        "Deployment without a strategy drops its adjustments",
+			annotations: map[string]string{webhook.WeightAdjustmentsAnnotation: `{"node-type=spot":90}`},
+			nodeTypes:   []string{"ondemand", "spot"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment, objects := testWorkload("web", tt.annotations, tt.nodeTypes...)
+			c := newFakeClient(t, objects...)
+			r := &WeightTuningController{Client: c, Log: logr.Discard(), Interval: interval, history: newPreemptionHistory()}
+			rs := objects[1].(*appsv1.ReplicaSet)
+			for i := 0; i < tt.preemptions; i++ {
+				r.history.record(preempted(rs, len(tt.nodeTypes)+i, "spot"), time.Now())
+			}
+			ctx := context.Background()
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.RequeueAfter != tt.requeue {
+				t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, tt.requeue)
+			}
+
+			current := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(deployment), current); err != nil {
+				t.Fatal(err)
+			}
+			if got := current.Annotations[webhook.WeightAdjustmentsAnnotation]; got != tt.want {
+				t.Errorf("%s = %q, want %q", webhook.WeightAdjustmentsAnnotation, got, tt.want)
+			}
+
+			events := &corev1.EventList{}
+			if err := c.List(ctx, events, client.InNamespace(deployment.Namespace)); err != nil {
+				t.Fatal(err)
+			}
+			adjusted := false
+			for _, event := range events.Items {
+				if event.Reason == "RuleWeightAdjusted" && event.InvolvedObject.Name == deployment.Name {
+					adjusted = true
+				}
+			}
+			if adjusted != tt.wantEvent {
+				t.Errorf("RuleWeightAdjusted event created = %v, want %v", adjusted, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestWeightTuningStepsOncePerInterval(t *testing.T) {
+	deployment, objects := testWorkload("web", map[string]string{webhook.ScheduleStrategyAnnotation: tunedStrategy},
+		"ondemand", "spot", "spot")
+	c := newFakeClient(t, objects...)
+	r := &WeightTuningController{Client: c, Log: logr.Discard(), Interval: time.Hour, history: newPreemptionHistory()}
+	rs := objects[1].(*appsv1.ReplicaSet)
+	r.history.record(preempted(rs, 3, "spot"), time.Now())
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	current := &appsv1.Deployment{}
+	if err := c.Get(ctx, req.NamespacedName, current); err != nil {
+		t.Fatal(err)
+	}
+	if got := current.Annotations[webhook.WeightAdjustmentsAnnotation]; got != `{"node-type=spot":90}` {
+		t.Errorf("Expected the weight stepped once within the interval, got %s", got)
+	}
+
+	// The history of a deleted deployment is forgotten
+	if err := c.Delete(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() of a deleted deployment error = %v", err)
+	}
+	if len(r.history.times) != 0 || len(r.history.stepped) != 0 {
+		t.Errorf("Expected the history of the deleted deployment forgotten, got %v and %v", r.history.times, r.history.stepped)
+	}
+}
@@ -135,4 +135,190 @@ Ignore
 {{- else }}
 {{- .Values.webhook.failurePolicy }}
 {{- end }}
-{{- end }} 
\ No newline at end of file
+{{- end }}
+
+{{/*
+Listener address for a port on operator.bindHost, bracketing IPv6 hosts
+*/}}
+{{- define "smart-scheduler.bindAddress" -}}
+{{- $host := .context.Values.operator.bindHost | default "" -}}
+{{- if contains ":" $host }}[{{ $host }}]{{ else }}{{ $host }}{{ end }}:{{ .port }}
+{{- end }}
+
+{{/*
+Webhook server arguments for the Deployments serving admissions
+*/}}
+{{- define "smart-scheduler.webhookArgs" -}}
+- --webhook-port={{ .Values.webhook.port }}
+- --cert-dir={{ .Values.webhook.certDir }}
+{{- with .Values.operator.bindHost }}
+- --webhook-host={{ . }}
+{{- end }}
+{{- with .Values.webhook.tls }}
+- --webhook-tls-min-version={{ .minVersion }}
+{{- if .cipherSuites }}
+- --webhook-tls-cipher-suites={{ join "," .cipherSuites }}
+{{- end }}
+- --webhook-enable-http2={{ .enableHTTP2 }}
+{{- if .maxConcurrentStreams }}
+- --webhook-http2-max-concurrent-streams={{ .maxConcurrentStreams }}
+{{- end }}
+{{- end }}
+{{- with .Values.webhook.decisionHook }}
+{{- if .url }}
+- --decision-hook-url={{ .url }}
+- --decision-hook-timeout={{ .timeout }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Manager arguments shared by the controller and webhook Deployments
+*/}}
+{{- define "smart-scheduler.managerArgs" -}}
+{{- if .Values.operator.config }}
+- --config=/etc/smart-scheduler/config.yaml
+{{- end }}
+- --metrics-bind-address={{ include "smart-scheduler.bindAddress" (dict "context" . "port" .Values.operator.metrics.port) }}
+- --health-probe-bind-address={{ include "smart-scheduler.bindAddress" (dict "context" . "port" .Values.operator.health.port) }}
+{{- if .Values.operator.decisionService.enabled }}
+- --decision-grpc-bind-address={{ include "smart-scheduler.bindAddress" (dict "context" . "port" .Values.operator.decisionService.port) }}
+{{- if include "smart-scheduler.decisionClientCA" . }}
+- --decision-grpc-client-ca-file=/etc/smart-scheduler-decision-ca/ca.crt
+{{- end }}
+{{- end }}
+{{- if .Values.development.debug }}
+- --zap-log-level=debug
+{{- else }}
+- --zap-log-level={{ .Values.logging.level }}
+{{- end }}
+{{- if .Values.logging.development }}
+- --zap-devel
+{{- end }}
+{{- if eq .Values.logging.encoder "console" }}
+- --zap-encoder=console
+{{- end }}
+{{- if .Values.development.debugApiRequests }}
+- --debug-api-requests
+{{- end }}
+{{- if .Values.webhook.enabled }}
+- --webhook-service-name={{ include "smart-scheduler.webhookServiceName" . }}
+- --webhook-failure-policy={{ include "smart-scheduler.webhookFailurePolicy" . }}
+{{- end }}
+{{- if .Values.multiNamespace.enabled }}
+{{- if .Values.multiNamespace.watchNamespaces }}
+- --watch-namespaces={{ join "," .Values.multiNamespace.watchNamespaces }}
+{{- end }}
+{{- end }}
+{{- if .Values.multiNamespace.watchLabelSelector }}
+- --watch-label-selector={{ .Values.multiNamespace.watchLabelSelector }}
+{{- end }}
+{{- with concat .Values.webhook.excludeNamespaces .Values.multiNamespace.protectedNamespaces | uniq }}
+- --protected-namespaces={{ join "," . }}
+{{- end }}
+{{- with .Values.multiNamespace.impersonation }}
+{{- if .serviceAccount }}
+- --impersonate-service-account={{ .serviceAccount }}
+- --impersonation-qps={{ .qps }}
+- --impersonation-burst={{ .burst }}
+{{- end }}
+{{- end }}
+{{- if .Values.features.imageArchCheck }}
+- --enable-image-arch-check
+{{- end }}
+{{- if .Values.features.policyPreflight }}
+- --policy-preflight
+{{- end }}
+- --enable-placement-audit={{ .Values.features.placementAudit.enabled }}
+{{- if .Values.features.placementAudit.recreate }}
+- --placement-audit-recreate
+{{- end }}
+- --placement-cleanup={{ .Values.features.placementCleanup }}
+- --reservation-priority-class={{ .Values.features.capacityReservation.priorityClass }}
+- --reservation-image={{ .Values.features.capacityReservation.image }}
+{{- with .Values.features.featureGates }}
+- --feature-gates={{ range $name, $enabled := . }}{{ $name }}={{ $enabled }},{{ end }}
+{{- end }}
+- --scheduler-concurrency={{ .Values.operator.tuning.concurrency.scheduler }}
+- --rebalance-concurrency={{ .Values.operator.tuning.concurrency.rebalance }}
+- --policy-concurrency={{ .Values.operator.tuning.concurrency.policy }}
+- --maintenance-concurrency={{ .Values.operator.tuning.concurrency.maintenance }}
+- --rebalance-debounce={{ .Values.operator.tuning.rebalanceDebounce }}
+- --strategy-change-grace-period={{ .Values.operator.tuning.strategyChangeGracePeriod }}
+- --max-evictions-per-strategy-change={{ .Values.operator.tuning.maxEvictionsPerStrategyChange }}
+{{- if .Values.operator.tuning.evictPreBoundPods }}
+- --evict-pre-bound-pods
+{{- end }}
+- --manual-override-window={{ .Values.operator.tuning.manualOverrideWindow }}
+- --janitor-ttl={{ .Values.operator.tuning.janitor.ttl }}
+- --janitor-interval={{ .Values.operator.tuning.janitor.interval }}
+- --weight-tuning-window={{ .Values.operator.tuning.weightTuning.window }}
+- --weight-tuning-interval={{ .Values.operator.tuning.weightTuning.interval }}
+- --weight-tuning-preemption-threshold={{ .Values.operator.tuning.weightTuning.preemptionThreshold }}
+- --weight-tuning-min-weight-percent={{ .Values.operator.tuning.weightTuning.minWeightPercent }}
+- --spot-score-provider={{ .Values.operator.tuning.spotScores.provider }}
+{{- with .Values.operator.tuning.spotScores.endpoint }}
+- --spot-score-endpoint={{ . }}
+{{- end }}
+- --spot-score-interval={{ .Values.operator.tuning.spotScores.interval }}
+- --spot-score-threshold={{ .Values.operator.tuning.spotScores.threshold }}
+- --spot-score-min-weight-percent={{ .Values.operator.tuning.spotScores.minWeightPercent }}
+- --summary-interval={{ .Values.operator.tuning.summaryInterval }}
+- --max-managed-deployments={{ .Values.operator.tuning.limits.maxManagedDeployments }}
+- --max-evictions-per-hour={{ .Values.operator.tuning.limits.maxEvictionsPerHour }}
+- --max-policies-per-namespace={{ .Values.operator.tuning.limits.maxPoliciesPerNamespace }}
+- --state-flush-interval={{ .Values.operator.tuning.stateFlushInterval }}
+- --state-failure-threshold={{ .Values.operator.tuning.stateFailureThreshold }}
+- --state-degraded-cooldown={{ .Values.operator.tuning.stateDegradedCooldown }}
+- --state-call-timeout={{ .Values.operator.tuning.stateCallTimeout }}
+- --state-warmup-timeout={{ .Values.operator.tuning.stateWarmupTimeout }}
+{{- with .Values.operator.tuning.admissionQueue }}
+- --admission-queue-max-in-flight={{ .maxInFlight }}
+- --admission-queue-max-wait={{ .maxWait }}
+- --admission-queue-high-priority={{ .highPriority }}
+- --admission-queue-high-policy-priority={{ .highPolicyPriority }}
+{{- end }}
+- --graceful-shutdown-timeout={{ .Values.operator.tuning.gracefulShutdownTimeout }}
+- --kube-api-content-type={{ .Values.operator.tuning.kubeAPIContentType }}
+- --cache-sync-period={{ .Values.operator.tuning.cacheSyncPeriod }}
+{{- if .Values.operator.tuning.kubeAPIQPS }}
+- --kube-api-qps={{ .Values.operator.tuning.kubeAPIQPS }}
+{{- end }}
+{{- if .Values.operator.tuning.kubeAPIBurst }}
+- --kube-api-burst={{ .Values.operator.tuning.kubeAPIBurst }}
+{{- end }}
+{{- if .Values.operator.tuning.podListPageSize }}
+- --pod-list-page-size={{ .Values.operator.tuning.podListPageSize }}
+{{- end }}
+{{- if .Values.features.nodeProblemConditions }}
+- --node-problem-conditions={{ join "," .Values.features.nodeProblemConditions }}
+{{- end }}
+- --avoid-scale-down-nodes={{ .Values.features.scaleDownAvoidance.enabled }}
+{{- if .Values.features.scaleDownAvoidance.taints }}
+- --scale-down-taints={{ join "," .Values.features.scaleDownAvoidance.taints }}
+{{- end }}
+- --slow-start-image-size={{ .Values.features.slowStartImageSize }}
+- --unschedulable-timeout={{ .Values.features.unschedulableTimeout }}
+{{- end }}
+
+{{/*
+Selector labels of the separate webhook Deployment
+*/}}
+{{- define "smart-scheduler.webhookSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "smart-scheduler.name" . }}-webhook
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+
+{{/*
+Whether the main Deployment serves the webhook
+*/}}
+{{- define "smart-scheduler.servesWebhook" -}}
+{{- if and .Values.webhook.enabled (not .Values.webhook.separateDeployment.enabled) }}true{{ end }}
+{{- end }}
+
+{{/*
+Secret holding the CA the decision service verifies client certificates against, if any
+*/}}
+{{- define "smart-scheduler.decisionClientCA" -}}
+{{- if .Values.operator.decisionService.enabled }}{{ .Values.operator.decisionService.clientCASecret }}{{ end }}
+{{- end }}
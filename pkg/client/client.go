@@ -0,0 +1,180 @@
+// Package client gives other operators typed access to the SmartScheduler custom resources and to
+// the placement of managed deployments. It is built on controller-runtime: a Clientset reads through
+// a direct client, or through an informer cache for listers that watch instead of polling the
+// API server.
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// NewScheme returns a scheme holding the built-in Kubernetes types and the SmartScheduler types
+func NewScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := smartschedulerv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// Clientset reads and writes SmartScheduler resources and reports the placement of deployments
+type Clientset struct {
+	client ctrlclient.Client
+}
+
+// New creates a Clientset talking to the API server directly, which also supports Watch
+func New(config *rest.Config) (*Clientset, error) {
+	scheme, err := NewScheme()
+	if err != nil {
+		return nil, err
+	}
+	c, err := ctrlclient.NewWithWatch(config, ctrlclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return &Clientset{client: c}, nil
+}
+
+// NewForClient creates a Clientset on an existing client, such as a manager's cached client. The
+// client's scheme must hold the SmartScheduler types.
+func NewForClient(c ctrlclient.Client) *Clientset {
+	return &Clientset{client: c}
+}
+
+// NewInformerCache creates an informer cache for SmartScheduler resources. Start it, wait for it
+// to sync, and pass it to NewCached so reads are served from the watched objects.
+func NewInformerCache(config *rest.Config, options cache.Options) (cache.Cache, error) {
+	if options.Scheme == nil {
+		scheme, err := NewScheme()
+		if err != nil {
+			return nil, err
+		}
+		options.Scheme = scheme
+	}
+	return cache.New(config, options)
+}
+
+// NewCached creates a Clientset reading from an informer cache created by NewInformerCache and
+// writing to the API server
+func NewCached(config *rest.Config, reader ctrlclient.Reader) (*Clientset, error) {
+	scheme, err := NewScheme()
+	if err != nil {
+		return nil, err
+	}
+	c, err := ctrlclient.New(config, ctrlclient.Options{
+		Scheme: scheme,
+		Cache:  &ctrlclient.CacheOptions{Reader: reader},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Clientset{client: c}, nil
+}
+
+// Client returns the underlying controller-runtime client
+func (c *Clientset) Client() ctrlclient.Client {
+	return c.client
+}
+
+// PodPlacementPolicies returns the PodPlacementPolicies of a namespace
+func (c *Clientset) PodPlacementPolicies(namespace string) *PodPlacementPolicies {
+	return &PodPlacementPolicies{client: c.client, namespace: namespace}
+}
+
+// MaintenanceWindows returns the cluster's MaintenanceWindows
+func (c *Clientset) MaintenanceWindows() *MaintenanceWindows {
+	return &MaintenanceWindows{client: c.client}
+}
+
+// PlacementSummary returns the cluster's PlacementSummary, which the summary controller keeps up to date
+func (c *Clientset) PlacementSummary(ctx context.Context) (*smartschedulerv1.PlacementSummary, error) {
+	summary := &smartschedulerv1.PlacementSummary{}
+	if err := c.client.Get(ctx, ctrlclient.ObjectKey{Name: smartschedulerv1.PlacementSummaryName}, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// watcher returns the client as a client.WithWatch, or an error if it cannot watch
+func watcher(c ctrlclient.Client) (ctrlclient.WithWatch, error) {
+	w, ok := c.(ctrlclient.WithWatch)
+	if !ok {
+		return nil, fmt.Errorf("client does not support watch, create the Clientset with New")
+	}
+	return w, nil
+}
+
+// Placement is how a deployment's pods are spread across its strategy's rules
+type Placement struct {
+	// Strategy is the deployment's parsed schedule strategy, with its base resolved
+	Strategy *webhook.PlacementStrategy
+	// Expected and Actual are the pods each rule should and does hold, keyed by rule
+	Expected map[webhook.RuleKey]int
+	Actual   map[webhook.RuleKey]int
+	// DriftPercentage is the rebalancer's measure of how far Actual is from Expected
+	DriftPercentage float64
+	// RequiresRebalance reports whether the drift is large enough for the rebalancer to evict pods
+	RequiresRebalance bool
+}
+
+// Placement reports the placement of the named deployment as the rebalancer sees it. It returns
+// nil without an error when the deployment has no schedule strategy.
+func (c *Clientset) Placement(ctx context.Context, namespace, name string) (*Placement, error) {
+	deployment := &appsv1.Deployment{}
+	if err := c.client.Get(ctx, ctrlclient.ObjectKey{Namespace: namespace, Name: name}, deployment); err != nil {
+		return nil, err
+	}
+	annotation, ok := deployment.Annotations[webhook.ScheduleStrategyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return nil, err
+	}
+	if strategy, err = webhook.ResolveBase(ctx, c.client, deployment, strategy); err != nil {
+		return nil, err
+	}
+	if strategy, err = webhook.WithRuleRenames(deployment, strategy); err != nil {
+		return nil, err
+	}
+	pods, err := webhook.ListStrategyPods(ctx, c.client, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := webhook.CountPodsByRule(pods, strategy)
+	poolHealth := webhook.NewPoolHealthChecker(c.client, logr.Discard())
+	expected := webhook.ExpectedCounts(strategy, actual, poolHealth.HealthFunc(ctx))
+	drift := webhook.DriftPercentage(expected, actual)
+	return &Placement{
+		Strategy:          strategy,
+		Expected:          expected,
+		Actual:            actual,
+		DriftPercentage:   drift,
+		RequiresRebalance: drift > webhook.RebalanceDriftThreshold,
+	}, nil
+}
+
+// PlacementState returns the placement state stored for the named deployment, including the pods
+// attributed to each rule, without listing pods. It returns nil without an error when no state is
+// stored.
+func (c *Clientset) PlacementState(ctx context.Context, namespace, name string) (*webhook.PlacementState, error) {
+	return webhook.NewStateManager(c.client, logr.Discard()).ReadPlacementState(ctx, namespace, name)
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const testStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot"
+
+// builderIndexer registers the webhook's field indexes on a fake client builder
+type builderIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (b builderIndexer) IndexField(_ context.Context, obj ctrlclient.Object, field string, extract ctrlclient.IndexerFunc) error {
+	b.builder.WithIndex(obj, field, extract)
+	return nil
+}
+
+// newTestClientset returns a Clientset over a fake client holding the objects, with the
+// SmartScheduler status subresources and the webhook's field indexes
+func newTestClientset(t *testing.T, objects ...ctrlclient.Object) *Clientset {
+	t.Helper()
+	scheme, err := NewScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&smartschedulerv1.PodPlacementPolicy{}, &smartschedulerv1.MaintenanceWindow{})
+	if err := webhook.SetupIndexers(context.Background(), builderIndexer{builder}); err != nil {
+		t.Fatal(err)
+	}
+	return NewForClient(builder.Build())
+}
+
+func testNode(name, nodeType string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-type": nodeType}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// testWorkload returns a deployment "web" with the strategy annotation, unless empty, its
+// ReplicaSet and a running pod of it on each of the node types
+func testWorkload(strategy string, nodeTypes ...string) []ctrlclient.Object {
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("deployment-uid")},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	if strategy != "" {
+		deployment.Annotations = map[string]string{webhook.ScheduleStrategyAnnotation: strategy}
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc",
+			Namespace: "default",
+			UID:       types.UID("rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+	}
+
+	objects := []ctrlclient.Object{deployment, rs}
+	for i, nodeType := range nodeTypes {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("web-abc-%d", i),
+				Namespace: "default",
+				UID:       types.UID(fmt.Sprintf("pod-%d", i)),
+				Labels:    map[string]string{"app": "web"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+				}},
+			},
+			Spec:   corev1.PodSpec{NodeSelector: map[string]string{"node-type": nodeType}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+	return objects
+}
+
+func TestPlacement(t *testing.T) {
+	nodes := []ctrlclient.Object{testNode("ondemand-0", "ondemand"), testNode("spot-0", "spot")}
+
+	tests := []struct {
+		name      string
+		objects   []ctrlclient.Object
+		wantNil   bool
+		expected  map[webhook.RuleKey]int
+		actual    map[webhook.RuleKey]int
+		rebalance bool
+	}{
+		{
+			name:     "Pods spread as the strategy expects",
+			objects:  testWorkload(testStrategy, "ondemand", "ondemand", "spot"),
+			expected: map[webhook.RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 1},
+			actual:   map[webhook.RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 1},
+		},
+		{
+			name:      "Pods all on the base pool",
+			objects:   testWorkload(testStrategy, "ondemand", "ondemand", "ondemand", "ondemand", "ondemand"),
+			expected:  map[webhook.RuleKey]int{"node-type=ondemand": 3, "node-type=spot": 2},
+			actual:    map[webhook.RuleKey]int{"node-type=ondemand": 5, "node-type=spot": 0},
+			rebalance: true,
+		},
+		{
+			name:    "Deployment without a strategy",
+			objects: testWorkload("", "ondemand"),
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := newTestClientset(t, append(tt.objects, nodes...)...)
+			placement, err := clientset.Placement(context.Background(), "default", "web")
+			if err != nil {
+				t.Fatalf("Placement() error = %v", err)
+			}
+			if tt.wantNil {
+				if placement != nil {
+					t.Errorf("Expected no placement for an unmanaged deployment, got %+v", placement)
+				}
+				return
+			}
+			if placement == nil {
+				t.Fatal("Expected a placement, got nil")
+			}
+			for _, rule := range placement.Strategy.Rules {
+				key := rule.Key()
+				if placement.Expected[key] != tt.expected[key] || placement.Actual[key] != tt.actual[key] {
+					t.Errorf("rule %s: expected %d and actual %d pods, want %d and %d",
+						key, placement.Expected[key], placement.Actual[key], tt.expected[key], tt.actual[key])
+				}
+			}
+			if placement.RequiresRebalance != tt.rebalance {
+				t.Errorf("RequiresRebalance = %v with drift %.1f%%, want %v", placement.RequiresRebalance, placement.DriftPercentage, tt.rebalance)
+			}
+		})
+	}
+
+	if _, err := newTestClientset(t).Placement(context.Background(), "default", "web"); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected NotFound for a missing deployment, got %v", err)
+	}
+}
+
+func TestPlacementState(t *testing.T) {
+	clientset := newTestClientset(t, testWorkload(testStrategy)...)
+	ctx := context.Background()
+
+	state, err := clientset.PlacementState(ctx, "default", "web")
+	if err != nil || state != nil {
+		t.Fatalf("PlacementState() = %+v, %v, want no state before one is stored", state, err)
+	}
+
+	strategy, err := webhook.ParsePlacementStrategy(testStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &webhook.PlacementState{
+		DeploymentName:      "web",
+		DeploymentNamespace: "default",
+		Strategy:            strategy,
+		PodCounts:           map[webhook.RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 1},
+		TotalPods:           3,
+		RulePods:            map[webhook.RuleKey][]types.UID{"node-type=spot": {"pod-2"}},
+	}
+	if err := webhook.NewStateManager(clientset.Client(), logr.Discard()).UpdatePlacementState(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = clientset.PlacementState(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("PlacementState() error = %v", err)
+	}
+	if state.TotalPods != 3 || state.PodCounts["node-type=ondemand"] != 2 || state.PodCounts["node-type=spot"] != 1 {
+		t.Errorf("PlacementState() counts = %v, total %d, want the stored counts", state.PodCounts, state.TotalPods)
+	}
+	if rules := state.PodRules(); rules["pod-2"] != "node-type=spot" {
+		t.Errorf("PodRules() = %v, want pod-2 on spot", rules)
+	}
+}
+
+func TestPlacementSummary(t *testing.T) {
+	ctx := context.Background()
+	if _, err := newTestClientset(t).PlacementSummary(ctx); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected NotFound before the summary controller creates the summary, got %v", err)
+	}
+
+	summary := &smartschedulerv1.PlacementSummary{ObjectMeta: metav1.ObjectMeta{Name: smartschedulerv1.PlacementSummaryName}}
+	got, err := newTestClientset(t, summary).PlacementSummary(ctx)
+	if err != nil {
+		t.Fatalf("PlacementSummary() error = %v", err)
+	}
+	if got.Name != smartschedulerv1.PlacementSummaryName {
+		t.Errorf("PlacementSummary() name = %q, want %q", got.Name, smartschedulerv1.PlacementSummaryName)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/watch"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// PodPlacementPolicies reads and writes the PodPlacementPolicies of one namespace
+type PodPlacementPolicies struct {
+	client    ctrlclient.Client
+	namespace string
+}
+
+// Get returns the named policy
+func (p *PodPlacementPolicies) Get(ctx context.Context, name string) (*smartschedulerv1.PodPlacementPolicy, error) {
+	policy := &smartschedulerv1.PodPlacementPolicy{}
+	if err := p.client.Get(ctx, ctrlclient.ObjectKey{Namespace: p.namespace, Name: name}, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// List returns the namespace's policies, narrowed by opts such as client.MatchingLabels
+func (p *PodPlacementPolicies) List(ctx context.Context, opts ...ctrlclient.ListOption) (*smartschedulerv1.PodPlacementPolicyList, error) {
+	list := &smartschedulerv1.PodPlacementPolicyList{}
+	if err := p.client.List(ctx, list, append(opts, ctrlclient.InNamespace(p.namespace))...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Watch watches the namespace's policies. It needs a Clientset created with New.
+func (p *PodPlacementPolicies) Watch(ctx context.Context, opts ...ctrlclient.ListOption) (watch.Interface, error) {
+	w, err := watcher(p.client)
+	if err != nil {
+		return nil, err
+	}
+	return w.Watch(ctx, &smartschedulerv1.PodPlacementPolicyList{}, append(opts, ctrlclient.InNamespace(p.namespace))...)
+}
+
+// Create creates the policy in the namespace
+func (p *PodPlacementPolicies) Create(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) error {
+	policy.Namespace = p.namespace
+	return p.client.Create(ctx, policy)
+}
+
+// Update updates the policy's spec
+func (p *PodPlacementPolicies) Update(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) error {
+	policy.Namespace = p.namespace
+	return p.client.Update(ctx, policy)
+}
+
+// UpdateStatus updates the policy's status
+func (p *PodPlacementPolicies) UpdateStatus(ctx context.Context, policy *smartschedulerv1.PodPlacementPolicy) error {
+	policy.Namespace = p.namespace
+	return p.client.Status().Update(ctx, policy)
+}
+
+// Delete deletes the named policy
+func (p *PodPlacementPolicies) Delete(ctx context.Context, name string) error {
+	policy := &smartschedulerv1.PodPlacementPolicy{}
+	policy.Namespace, policy.Name = p.namespace, name
+	return p.client.Delete(ctx, policy)
+}
+
+// MaintenanceWindows reads and writes the cluster-scoped MaintenanceWindows
+type MaintenanceWindows struct {
+	client ctrlclient.Client
+}
+
+// Get returns the named maintenance window
+func (m *MaintenanceWindows) Get(ctx context.Context, name string) (*smartschedulerv1.MaintenanceWindow, error) {
+	window := &smartschedulerv1.MaintenanceWindow{}
+	if err := m.client.Get(ctx, ctrlclient.ObjectKey{Name: name}, window); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+// List returns the maintenance windows, narrowed by opts such as client.MatchingLabels
+func (m *MaintenanceWindows) List(ctx context.Context, opts ...ctrlclient.ListOption) (*smartschedulerv1.MaintenanceWindowList, error) {
+	list := &smartschedulerv1.MaintenanceWindowList{}
+	if err := m.client.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Watch watches the maintenance windows. It needs a Clientset created with New.
+func (m *MaintenanceWindows) Watch(ctx context.Context, opts ...ctrlclient.ListOption) (watch.Interface, error) {
+	w, err := watcher(m.client)
+	if err != nil {
+		return nil, err
+	}
+	return w.Watch(ctx, &smartschedulerv1.MaintenanceWindowList{}, opts...)
+}
+
+// Create creates the maintenance window
+func (m *MaintenanceWindows) Create(ctx context.Context, window *smartschedulerv1.MaintenanceWindow) error {
+	return m.client.Create(ctx, window)
+}
+
+// Update updates the maintenance window's spec
+func (m *MaintenanceWindows) Update(ctx context.Context, window *smartschedulerv1.MaintenanceWindow) error {
+	return m.client.Update(ctx, window)
+}
+
+// UpdateStatus updates the maintenance window's status
+func (m *MaintenanceWindows) UpdateStatus(ctx context.Context, window *smartschedulerv1.MaintenanceWindow) error {
+	return m.client.Status().Update(ctx, window)
+}
+
+// Delete deletes the named maintenance window
+func (m *MaintenanceWindows) Delete(ctx context.Context, name string) error {
+	window := &smartschedulerv1.MaintenanceWindow{}
+	window.Name = name
+	return m.client.Delete(ctx, window)
+}
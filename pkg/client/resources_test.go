@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// withoutWatch hides the fake client's Watch, like a cached client
+type withoutWatch struct {
+	ctrlclient.Client
+}
+
+// nextEvent returns the next event of w, failing the test if none arrives
+func nextEvent(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case event := <-w.ResultChan():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a watch event, got none")
+		return watch.Event{}
+	}
+}
+
+func TestPodPlacementPolicies(t *testing.T) {
+	clientset := newTestClientset(t)
+	policies := clientset.PodPlacementPolicies("shop")
+	ctx := context.Background()
+
+	w, err := policies.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Stop()
+
+	// Create places the policy in the accessor's namespace, whatever it carried
+	policy := &smartschedulerv1.PodPlacementPolicy{ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "other"}}
+	policy.Spec.Priority = 10
+	if err := policies.Create(ctx, policy); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if policy.Namespace != "shop" {
+		t.Errorf("Create() namespace = %q, want shop", policy.Namespace)
+	}
+	if event := nextEvent(t, w); event.Type != watch.Added {
+		t.Errorf("Watch() event = %s, want %s", event.Type, watch.Added)
+	}
+	if err := clientset.PodPlacementPolicies("other").Create(ctx, &smartschedulerv1.PodPlacementPolicy{ObjectMeta: metav1.ObjectMeta{Name: "elsewhere"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := policies.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "spot" {
+		t.Errorf("List() = %d policies, want only the namespace's spot policy", len(list.Items))
+	}
+
+	got, err := policies.Get(ctx, "spot")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got.Spec.Priority = 20
+	if err := policies.Update(ctx, got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got.Status.MatchedDeployments = []smartschedulerv1.DeploymentReference{{Name: "web", Namespace: "shop"}}
+	if err := policies.UpdateStatus(ctx, got); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	got, err = policies.Get(ctx, "spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Priority != 20 || len(got.Status.MatchedDeployments) != 1 {
+		t.Errorf("Get() = priority %d, %d matched deployments, want the updated spec and status",
+			got.Spec.Priority, len(got.Status.MatchedDeployments))
+	}
+
+	if err := policies.Delete(ctx, "spot"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := policies.Get(ctx, "spot"); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected NotFound after Delete(), got %v", err)
+	}
+	if _, err := clientset.PodPlacementPolicies("other").Get(ctx, "elsewhere"); err != nil {
+		t.Errorf("Expected the other namespace's policy kept, got %v", err)
+	}
+
+	if _, err := NewForClient(withoutWatch{clientset.Client()}).PodPlacementPolicies("shop").Watch(ctx); err == nil {
+		t.Error("Expected Watch() to fail on a client that cannot watch")
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	clientset := newTestClientset(t)
+	windows := clientset.MaintenanceWindows()
+	ctx := context.Background()
+
+	w, err := windows.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Stop()
+
+	start := metav1.NewTime(time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC))
+	window := &smartschedulerv1.MaintenanceWindow{
+		ObjectMeta: metav1.ObjectMeta{Name: "patching"},
+		Spec: smartschedulerv1.MaintenanceWindowSpec{
+			NodeSelector: map[string]string{"node-type": "spot"},
+			StartTime:    start,
+			EndTime:      metav1.NewTime(start.Add(time.Hour)),
+		},
+	}
+	if err := windows.Create(ctx, window); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if event := nextEvent(t, w); event.Type != watch.Added {
+		t.Errorf("Watch() event = %s, want %s", event.Type, watch.Added)
+	}
+
+	got, err := windows.Get(ctx, "patching")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got.Spec.Reason = "kernel upgrade"
+	if err := windows.Update(ctx, got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got.Status.Active, got.Status.MatchedNodes = true, 3
+	if err := windows.UpdateStatus(ctx, got); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	list, err := windows.List(ctx, ctrlclient.MatchingLabels{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("List() = %d windows, want 1", len(list.Items))
+	}
+	if item := list.Items[0]; item.Spec.Reason != "kernel upgrade" || !item.Status.Active || item.Status.MatchedNodes != 3 {
+		t.Errorf("List() = %+v, want the updated spec and status", item)
+	}
+
+	if err := windows.Delete(ctx, "patching"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := windows.Get(ctx, "patching"); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected NotFound after Delete(), got %v", err)
+	}
+
+	if _, err := NewForClient(withoutWatch{clientset.Client()}).MaintenanceWindows().Watch(ctx); err == nil {
+		t.Error("Expected Watch() to fail on a client that cannot watch")
+	}
+}
@@ -0,0 +1,403 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/features"
+)
+
+const (
+	// APIVersion is the only supported version of the configuration file
+	APIVersion = "config.smart-scheduler.io/v1alpha1"
+	// Kind is the kind of the configuration file
+	Kind = "SmartSchedulerConfiguration"
+)
+
+// SmartSchedulerConfiguration configures the manager. Every field is optional and mirrors a
+// command line flag; fields left unset keep the flag's value.
+type SmartSchedulerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Webhook configures pod admission and the placement state it keeps
+	Webhook WebhookConfiguration `json:"webhook,omitempty"`
+	// Rebalance configures the defaults of the RebalanceController
+	Rebalance RebalanceConfiguration `json:"rebalance,omitempty"`
+	// Logging configures log verbosity
+	Logging LoggingConfiguration `json:"logging,omitempty"`
+	// Scoping restricts the objects the manager watches
+	Scoping ScopingConfiguration `json:"scoping,omitempty"`
+	// Concurrency limits parallel reconciles per controller
+	Concurrency ConcurrencyConfiguration `json:"concurrency,omitempty"`
+	// KubeAPI configures the Kubernetes API client and informer cache
+	KubeAPI KubeAPIConfiguration `json:"kubeAPI,omitempty"`
+	// LeaderElection configures leader election of the controllers
+	LeaderElection LeaderElectionConfiguration `json:"leaderElection,omitempty"`
+	// Shutdown configures graceful shutdown
+	Shutdown ShutdownConfiguration `json:"shutdown,omitempty"`
+	// RBAC configures reconciliation of the operator's own permissions
+	RBAC RBACConfiguration `json:"rbac,omitempty"`
+	// Reservation configures the placeholder pods of rules with a reserve count
+	Reservation ReservationConfiguration `json:"reservation,omitempty"`
+	// Limits caps the deployments, evictions and policies the controllers act on
+	Limits LimitsConfiguration `json:"limits,omitempty"`
+	// Janitor configures the pruning of stale placement state, decision annotations and Events
+	Janitor JanitorConfiguration `json:"janitor,omitempty"`
+	// WeightTuning configures how the WeightTuning feature lowers the weights of often preempted rules
+	WeightTuning WeightTuningConfiguration `json:"weightTuning,omitempty"`
+	// SpotScores configures how the SpotPlacementScores feature scores spot pools
+	SpotScores SpotScoreConfiguration `json:"spotScores,omitempty"`
+	// FeatureGates turns optional features on or off, like --feature-gates
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// WebhookConfiguration configures pod admission
+type WebhookConfiguration struct {
+	StateFailureThreshold *int             `json:"stateFailureThreshold,omitempty"`
+	StateDegradedCooldown *metav1.Duration `json:"stateDegradedCooldown,omitempty"`
+	StateCallTimeout      *metav1.Duration `json:"stateCallTimeout,omitempty"`
+	StateFlushInterval    *metav1.Duration `json:"stateFlushInterval,omitempty"`
+	StateWarmupTimeout    *metav1.Duration `json:"stateWarmupTimeout,omitempty"`
+	StrategyCacheSize     *int             `json:"strategyCacheSize,omitempty"`
+	PodListPageSize       *int64           `json:"podListPageSize,omitempty"`
+	NodeProblemConditions []string         `json:"nodeProblemConditions,omitempty"`
+	AvoidScaleDownNodes   *bool            `json:"avoidScaleDownNodes,omitempty"`
+	ScaleDownTaints       []string         `json:"scaleDownTaints,omitempty"`
+	// SlowStartImageSize is the total image size from which pods avoid preemptible rules, e.g. "5Gi"
+	SlowStartImageSize *string `json:"slowStartImageSize,omitempty"`
+	// UnschedulableTimeout is how long pods may be Unschedulable before new pods avoid their rule
+	UnschedulableTimeout *metav1.Duration `json:"unschedulableTimeout,omitempty"`
+	// AdmissionQueue limits admissions using the state store under overload
+	AdmissionQueue AdmissionQueueConfiguration `json:"admissionQueue,omitempty"`
+	// DecisionHook posts placements to an external endpoint that may veto or override them
+	DecisionHook DecisionHookConfiguration `json:"decisionHook,omitempty"`
+	// TLSMinVersion is VersionTLS12 or VersionTLS13
+	TLSMinVersion             *string  `json:"tlsMinVersion,omitempty"`
+	TLSCipherSuites           []string `json:"tlsCipherSuites,omitempty"`
+	EnableHTTP2               *bool    `json:"enableHTTP2,omitempty"`
+	HTTP2MaxConcurrentStreams *int     `json:"http2MaxConcurrentStreams,omitempty"`
+	// ServiceName is the webhook Service whose endpoints share placement decisions
+	ServiceName *string `json:"serviceName,omitempty"`
+}
+
+// AdmissionQueueConfiguration configures priority-aware admission queueing
+type AdmissionQueueConfiguration struct {
+	MaxInFlight        *int             `json:"maxInFlight,omitempty"`
+	MaxWait            *metav1.Duration `json:"maxWait,omitempty"`
+	HighPriority       *int             `json:"highPriority,omitempty"`
+	HighPolicyPriority *int             `json:"highPolicyPriority,omitempty"`
+}
+
+// DecisionHookConfiguration configures the external placement decision hook
+type DecisionHookConfiguration struct {
+	// URL is the endpoint placements are POSTed to; empty disables the hook
+	URL     *string          `json:"url,omitempty"`
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// CAFile verifies an https endpoint; the system roots are used when empty
+	CAFile *string `json:"caFile,omitempty"`
+}
+
+// RebalanceConfiguration configures the RebalanceController defaults
+type RebalanceConfiguration struct {
+	Debounce                      *metav1.Duration `json:"debounce,omitempty"`
+	StrategyChangeGracePeriod     *metav1.Duration `json:"strategyChangeGracePeriod,omitempty"`
+	MaxEvictionsPerStrategyChange *int             `json:"maxEvictionsPerStrategyChange,omitempty"`
+	PlacementCleanup              *string          `json:"placementCleanup,omitempty"`
+	// ManualOverrideWindow defers automated correction after manual pod moves; 0 disables detection
+	ManualOverrideWindow *metav1.Duration `json:"manualOverrideWindow,omitempty"`
+	// EvictPreBoundPods lets drift evict pods created with spec.nodeName set
+	EvictPreBoundPods *bool `json:"evictPreBoundPods,omitempty"`
+}
+
+// LoggingConfiguration configures log verbosity
+type LoggingConfiguration struct {
+	// Level is debug, info, error or a positive verbosity
+	Level *string `json:"level,omitempty"`
+	// DebugAPIRequests logs every Kubernetes API request
+	DebugAPIRequests *bool `json:"debugAPIRequests,omitempty"`
+}
+
+// ScopingConfiguration restricts the watched objects
+type ScopingConfiguration struct {
+	WatchNamespaces    []string `json:"watchNamespaces,omitempty"`
+	WatchLabelSelector *string  `json:"watchLabelSelector,omitempty"`
+	// ProtectedNamespaces are never mutated or evicted, in addition to the system namespaces
+	ProtectedNamespaces []string `json:"protectedNamespaces,omitempty"`
+	// ImpersonateServiceAccount is impersonated in each namespace for policy application and evictions
+	ImpersonateServiceAccount *string  `json:"impersonateServiceAccount,omitempty"`
+	ImpersonationQPS          *float64 `json:"impersonationQPS,omitempty"`
+	ImpersonationBurst        *int     `json:"impersonationBurst,omitempty"`
+}
+
+// ConcurrencyConfiguration limits parallel reconciles
+type ConcurrencyConfiguration struct {
+	Scheduler   *int `json:"scheduler,omitempty"`
+	Rebalance   *int `json:"rebalance,omitempty"`
+	Policy      *int `json:"policy,omitempty"`
+	Maintenance *int `json:"maintenance,omitempty"`
+}
+
+// KubeAPIConfiguration configures the API client and cache
+type KubeAPIConfiguration struct {
+	QPS             *float64         `json:"qps,omitempty"`
+	Burst           *int             `json:"burst,omitempty"`
+	ContentType     *string          `json:"contentType,omitempty"`
+	CacheSyncPeriod *metav1.Duration `json:"cacheSyncPeriod,omitempty"`
+}
+
+// ShutdownConfiguration configures graceful shutdown
+type ShutdownConfiguration struct {
+	// GracefulTimeout bounds the wait for in-flight admissions, rebalancing and state writes
+	GracefulTimeout *metav1.Duration `json:"gracefulTimeout,omitempty"`
+}
+
+// RBACConfiguration configures reconciliation of the operator's ClusterRole
+type RBACConfiguration struct {
+	// ClusterRole is reduced to the permissions of the enabled controllers and feature gates
+	ClusterRole *string `json:"clusterRole,omitempty"`
+}
+
+// ReservationConfiguration configures the placeholder pods of the ReservationController
+type ReservationConfiguration struct {
+	PriorityClass *string `json:"priorityClass,omitempty"`
+	Image         *string `json:"image,omitempty"`
+}
+
+// LimitsConfiguration caps the scope of the controllers; 0 leaves a limit off
+type LimitsConfiguration struct {
+	MaxManagedDeployments   *int `json:"maxManagedDeployments,omitempty"`
+	MaxEvictionsPerHour     *int `json:"maxEvictionsPerHour,omitempty"`
+	MaxPoliciesPerNamespace *int `json:"maxPoliciesPerNamespace,omitempty"`
+}
+
+// JanitorConfiguration configures the janitor; a TTL of 0 prunes nothing
+type JanitorConfiguration struct {
+	TTL      *metav1.Duration `json:"ttl,omitempty"`
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// WeightTuningConfiguration configures the WeightTuningController
+type WeightTuningConfiguration struct {
+	Window              *metav1.Duration `json:"window,omitempty"`
+	Interval            *metav1.Duration `json:"interval,omitempty"`
+	PreemptionThreshold *float64         `json:"preemptionThreshold,omitempty"`
+	MinWeightPercent    *int             `json:"minWeightPercent,omitempty"`
+}
+
+// SpotScoreConfiguration configures the SpotScoreController
+type SpotScoreConfiguration struct {
+	Provider         *string          `json:"provider,omitempty"`
+	Endpoint         *string          `json:"endpoint,omitempty"`
+	Interval         *metav1.Duration `json:"interval,omitempty"`
+	Threshold        *int             `json:"threshold,omitempty"`
+	MinWeightPercent *int             `json:"minWeightPercent,omitempty"`
+}
+
+// LeaderElectionConfiguration configures leader election
+type LeaderElectionConfiguration struct {
+	LeaderElect   *bool            `json:"leaderElect,omitempty"`
+	ResourceName  *string          `json:"resourceName,omitempty"`
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+	RenewDeadline *metav1.Duration `json:"renewDeadline,omitempty"`
+	RetryPeriod   *metav1.Duration `json:"retryPeriod,omitempty"`
+	// Controllers lists the controllers this process runs, like --controllers
+	Controllers []string `json:"controllers,omitempty"`
+}
+
+// Load reads and validates a configuration file. Unknown fields are rejected so typos don't
+// silently fall back to flag values.
+func Load(path string) (*SmartSchedulerConfiguration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a configuration
+func Parse(data []byte) (*SmartSchedulerConfiguration, error) {
+	cfg := &SmartSchedulerConfiguration{}
+	if err := yaml.UnmarshalStrict(bytes.TrimSpace(data), cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return nil, fmt.Errorf("unsupported configuration %s/%s, expected %s/%s", cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	for gate := range cfg.FeatureGates {
+		if !features.DefaultGates.IsKnown(features.Feature(gate)) {
+			return nil, fmt.Errorf("unknown feature gate %q", gate)
+		}
+	}
+	return cfg, nil
+}
+
+// Flags returns the set fields as flag names and values, ready for flag.FlagSet.Set
+func (c *SmartSchedulerConfiguration) Flags() map[string]string {
+	flags := make(map[string]string)
+	setInt := func(name string, value *int) {
+		if value != nil {
+			flags[name] = strconv.Itoa(*value)
+		}
+	}
+	setDuration := func(name string, value *metav1.Duration) {
+		if value != nil {
+			flags[name] = value.Duration.String()
+		}
+	}
+	setString := func(name string, value *string) {
+		if value != nil {
+			flags[name] = *value
+		}
+	}
+
+	setInt("state-failure-threshold", c.Webhook.StateFailureThreshold)
+	setDuration("state-degraded-cooldown", c.Webhook.StateDegradedCooldown)
+	setDuration("state-call-timeout", c.Webhook.StateCallTimeout)
+	setDuration("state-flush-interval", c.Webhook.StateFlushInterval)
+	setDuration("state-warmup-timeout", c.Webhook.StateWarmupTimeout)
+	setInt("strategy-cache-size", c.Webhook.StrategyCacheSize)
+	if c.Webhook.PodListPageSize != nil {
+		flags["pod-list-page-size"] = strconv.FormatInt(*c.Webhook.PodListPageSize, 10)
+	}
+	if c.Webhook.NodeProblemConditions != nil {
+		flags["node-problem-conditions"] = strings.Join(c.Webhook.NodeProblemConditions, ",")
+	}
+	if c.Webhook.AvoidScaleDownNodes != nil {
+		flags["avoid-scale-down-nodes"] = strconv.FormatBool(*c.Webhook.AvoidScaleDownNodes)
+	}
+	if c.Webhook.ScaleDownTaints != nil {
+		flags["scale-down-taints"] = strings.Join(c.Webhook.ScaleDownTaints, ",")
+	}
+	setString("slow-start-image-size", c.Webhook.SlowStartImageSize)
+	setDuration("unschedulable-timeout", c.Webhook.UnschedulableTimeout)
+	setInt("admission-queue-max-in-flight", c.Webhook.AdmissionQueue.MaxInFlight)
+	setDuration("admission-queue-max-wait", c.Webhook.AdmissionQueue.MaxWait)
+	setInt("admission-queue-high-priority", c.Webhook.AdmissionQueue.HighPriority)
+	setInt("admission-queue-high-policy-priority", c.Webhook.AdmissionQueue.HighPolicyPriority)
+	setString("decision-hook-url", c.Webhook.DecisionHook.URL)
+	setDuration("decision-hook-timeout", c.Webhook.DecisionHook.Timeout)
+	setString("decision-hook-ca-file", c.Webhook.DecisionHook.CAFile)
+	setString("webhook-tls-min-version", c.Webhook.TLSMinVersion)
+	if c.Webhook.TLSCipherSuites != nil {
+		flags["webhook-tls-cipher-suites"] = strings.Join(c.Webhook.TLSCipherSuites, ",")
+	}
+	if c.Webhook.EnableHTTP2 != nil {
+		flags["webhook-enable-http2"] = strconv.FormatBool(*c.Webhook.EnableHTTP2)
+	}
+	setInt("webhook-http2-max-concurrent-streams", c.Webhook.HTTP2MaxConcurrentStreams)
+	setString("webhook-service-name", c.Webhook.ServiceName)
+
+	setDuration("rebalance-debounce", c.Rebalance.Debounce)
+	setDuration("strategy-change-grace-period", c.Rebalance.StrategyChangeGracePeriod)
+	setInt("max-evictions-per-strategy-change", c.Rebalance.MaxEvictionsPerStrategyChange)
+	if c.Rebalance.EvictPreBoundPods != nil {
+		flags["evict-pre-bound-pods"] = strconv.FormatBool(*c.Rebalance.EvictPreBoundPods)
+	}
+	setString("placement-cleanup", c.Rebalance.PlacementCleanup)
+	setDuration("manual-override-window", c.Rebalance.ManualOverrideWindow)
+
+	setString("zap-log-level", c.Logging.Level)
+	if c.Logging.DebugAPIRequests != nil {
+		flags["debug-api-requests"] = strconv.FormatBool(*c.Logging.DebugAPIRequests)
+	}
+
+	if c.Scoping.WatchNamespaces != nil {
+		flags["watch-namespaces"] = strings.Join(c.Scoping.WatchNamespaces, ",")
+	}
+	setString("watch-label-selector", c.Scoping.WatchLabelSelector)
+	if c.Scoping.ProtectedNamespaces != nil {
+		flags["protected-namespaces"] = strings.Join(c.Scoping.ProtectedNamespaces, ",")
+	}
+	setString("impersonate-service-account", c.Scoping.ImpersonateServiceAccount)
+	if c.Scoping.ImpersonationQPS != nil {
+		flags["impersonation-qps"] = strconv.FormatFloat(*c.Scoping.ImpersonationQPS, 'f', -1, 64)
+	}
+	setInt("impersonation-burst", c.Scoping.ImpersonationBurst)
+
+	setInt("scheduler-concurrency", c.Concurrency.Scheduler)
+	setInt("rebalance-concurrency", c.Concurrency.Rebalance)
+	setInt("policy-concurrency", c.Concurrency.Policy)
+	setInt("maintenance-concurrency", c.Concurrency.Maintenance)
+
+	if c.KubeAPI.QPS != nil {
+		flags["kube-api-qps"] = strconv.FormatFloat(*c.KubeAPI.QPS, 'f', -1, 64)
+	}
+	setInt("kube-api-burst", c.KubeAPI.Burst)
+	setString("kube-api-content-type", c.KubeAPI.ContentType)
+	setDuration("cache-sync-period", c.KubeAPI.CacheSyncPeriod)
+
+	if c.LeaderElection.LeaderElect != nil {
+		flags["leader-elect"] = strconv.FormatBool(*c.LeaderElection.LeaderElect)
+	}
+	setString("leader-election-id", c.LeaderElection.ResourceName)
+	setDuration("leader-elect-lease-duration", c.LeaderElection.LeaseDuration)
+	setDuration("leader-elect-renew-deadline", c.LeaderElection.RenewDeadline)
+	setDuration("leader-elect-retry-period", c.LeaderElection.RetryPeriod)
+	if c.LeaderElection.Controllers != nil {
+		flags["controllers"] = strings.Join(c.LeaderElection.Controllers, ",")
+	}
+
+	setDuration("graceful-shutdown-timeout", c.Shutdown.GracefulTimeout)
+
+	setString("rbac-cluster-role", c.RBAC.ClusterRole)
+
+	setString("reservation-priority-class", c.Reservation.PriorityClass)
+	setString("reservation-image", c.Reservation.Image)
+
+	setInt("max-managed-deployments", c.Limits.MaxManagedDeployments)
+	setInt("max-evictions-per-hour", c.Limits.MaxEvictionsPerHour)
+	setInt("max-policies-per-namespace", c.Limits.MaxPoliciesPerNamespace)
+
+	setDuration("janitor-ttl", c.Janitor.TTL)
+	setDuration("janitor-interval", c.Janitor.Interval)
+
+	setDuration("weight-tuning-window", c.WeightTuning.Window)
+	setDuration("weight-tuning-interval", c.WeightTuning.Interval)
+	if c.WeightTuning.PreemptionThreshold != nil {
+		flags["weight-tuning-preemption-threshold"] = strconv.FormatFloat(*c.WeightTuning.PreemptionThreshold, 'f', -1, 64)
+	}
+	setInt("weight-tuning-min-weight-percent", c.WeightTuning.MinWeightPercent)
+
+	setString("spot-score-provider", c.SpotScores.Provider)
+	setString("spot-score-endpoint", c.SpotScores.Endpoint)
+	setDuration("spot-score-interval", c.SpotScores.Interval)
+	setInt("spot-score-threshold", c.SpotScores.Threshold)
+	setInt("spot-score-min-weight-percent", c.SpotScores.MinWeightPercent)
+
+	if len(c.FeatureGates) > 0 {
+		var gates []string
+		for gate, enabled := range c.FeatureGates {
+			gates = append(gates, fmt.Sprintf("%s=%t", gate, enabled))
+		}
+		sort.Strings(gates)
+		flags["feature-gates"] = strings.Join(gates, ",")
+	}
+	return flags
+}
+
+// ChangedFlags returns the sorted names of flags whose value differs between two configurations,
+// including flags set in only one of them
+func ChangedFlags(old, new *SmartSchedulerConfiguration) []string {
+	oldFlags, newFlags := old.Flags(), new.Flags()
+	var changed []string
+	for name, value := range newFlags {
+		if oldValue, ok := oldFlags[name]; !ok || oldValue != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldFlags {
+		if _, ok := newFlags[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
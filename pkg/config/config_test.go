@@ -0,0 +1,177 @@
+package config
+
+import (
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// registeredFlags returns the flags the manager binary registers: those of cmd/main.go and the
+// zap flags it binds
+func registeredFlags(t *testing.T) map[string]bool {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), filepath.Join("..", "..", "cmd", "main.go"), nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse cmd/main.go: %v", err)
+	}
+
+	flags := make(map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if pkg, ok := selector.X.(*ast.Ident); !ok || pkg.Name != "flag" {
+			return true
+		}
+		if name, ok := call.Args[1].(*ast.BasicLit); ok && name.Kind == token.STRING {
+			value, err := strconv.Unquote(name.Value)
+			if err != nil {
+				t.Fatalf("Failed to read flag name %s: %v", name.Value, err)
+			}
+			flags[value] = true
+		}
+		return true
+	})
+
+	fs := flag.NewFlagSet("zap", flag.ContinueOnError)
+	(&zap.Options{}).BindFlags(fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+	return flags
+}
+
+func TestFlagsMapToRegisteredFlags(t *testing.T) {
+	cfg, err := Load(filepath.Join("testdata", "config.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to load the sample configuration: %v", err)
+	}
+
+	// The sample sets every field, so a field added without a flag mapping shows up here
+	assertAllSet(t, "", reflect.ValueOf(*cfg))
+
+	tests := []struct {
+		flag  string
+		value string
+	}{
+		{"state-failure-threshold", "3"},
+		{"state-degraded-cooldown", "30s"},
+		{"state-call-timeout", "2s"},
+		{"state-flush-interval", "250ms"},
+		{"state-warmup-timeout", "20s"},
+		{"strategy-cache-size", "512"},
+		{"pod-list-page-size", "500"},
+		{"node-problem-conditions", "KernelDeadlock,ReadonlyFilesystem"},
+		{"avoid-scale-down-nodes", "false"},
+		{"scale-down-taints", "ToBeDeletedByClusterAutoscaler,DeletionCandidateOfClusterAutoscaler"},
+		{"slow-start-image-size", "2Gi"},
+		{"unschedulable-timeout", "3m0s"},
+		{"admission-queue-max-in-flight", "64"},
+		{"admission-queue-max-wait", "5s"},
+		{"admission-queue-high-priority", "1000"},
+		{"admission-queue-high-policy-priority", "100"},
+		{"decision-hook-url", "https://placement.example.com/decide"},
+		{"decision-hook-timeout", "500ms"},
+		{"decision-hook-ca-file", "/etc/decision-hook/ca.crt"},
+		{"webhook-tls-min-version", "VersionTLS13"},
+		{"webhook-tls-cipher-suites", "TLS_AES_128_GCM_SHA256"},
+		{"webhook-enable-http2", "false"},
+		{"webhook-http2-max-concurrent-streams", "100"},
+		{"webhook-service-name", "smart-scheduler-webhook"},
+		{"rebalance-debounce", "15s"},
+		{"strategy-change-grace-period", "10m0s"},
+		{"max-evictions-per-strategy-change", "20"},
+		{"placement-cleanup", "annotate"},
+		{"manual-override-window", "1h0m0s"},
+		{"evict-pre-bound-pods", "true"},
+		{"zap-log-level", "info"},
+		{"debug-api-requests", "true"},
+		{"watch-namespaces", "shop,payments"},
+		{"watch-label-selector", "team=platform"},
+		{"protected-namespaces", "monitoring"},
+		{"impersonate-service-account", "smart-scheduler-placer"},
+		{"impersonation-qps", "2.5"},
+		{"impersonation-burst", "5"},
+		{"scheduler-concurrency", "2"},
+		{"rebalance-concurrency", "3"},
+		{"policy-concurrency", "4"},
+		{"maintenance-concurrency", "5"},
+		{"kube-api-qps", "50"},
+		{"kube-api-burst", "100"},
+		{"kube-api-content-type", "json"},
+		{"cache-sync-period", "1h0m0s"},
+		{"leader-elect", "true"},
+		{"leader-election-id", "smart-scheduler"},
+		{"leader-elect-lease-duration", "30s"},
+		{"leader-elect-renew-deadline", "20s"},
+		{"leader-elect-retry-period", "5s"},
+		{"controllers", "rebalance,policy"},
+		{"graceful-shutdown-timeout", "45s"},
+		{"rbac-cluster-role", "smart-scheduler-manager"},
+		{"reservation-priority-class", "smart-scheduler-reservation"},
+		{"reservation-image", "registry.k8s.io/pause:3.9"},
+		{"max-managed-deployments", "200"},
+		{"max-evictions-per-hour", "60"},
+		{"max-policies-per-namespace", "10"},
+		{"janitor-ttl", "72h0m0s"},
+		{"janitor-interval", "30m0s"},
+		{"weight-tuning-window", "2h0m0s"},
+		{"weight-tuning-interval", "10m0s"},
+		{"weight-tuning-preemption-threshold", "0.25"},
+		{"weight-tuning-min-weight-percent", "20"},
+		{"spot-score-provider", "aws"},
+		{"spot-score-endpoint", "https://spot-scores.example.com"},
+		{"spot-score-interval", "15m0s"},
+		{"spot-score-threshold", "4"},
+		{"spot-score-min-weight-percent", "30"},
+		{"feature-gates", "PlacementAudit=false,PolicyPreflight=true"},
+	}
+
+	flags := cfg.Flags()
+	registered := registeredFlags(t)
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			if !registered[tt.flag] {
+				t.Errorf("Expected --%s to be registered by the manager", tt.flag)
+			}
+			if got, ok := flags[tt.flag]; !ok || got != tt.value {
+				t.Errorf("Expected %s=%q, got %q (set: %t)", tt.flag, tt.value, got, ok)
+			}
+		})
+	}
+	if len(flags) != len(tests) {
+		t.Errorf("Expected %d flags from the sample configuration, got %d: %v", len(tests), len(flags), flags)
+	}
+}
+
+// assertAllSet fails for every optional field of the configuration the sample leaves unset
+func assertAllSet(t *testing.T, path string, value reflect.Value) {
+	t.Helper()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Anonymous {
+			continue
+		}
+		name := path + field.Name
+		switch value.Field(i).Kind() {
+		case reflect.Struct:
+			assertAllSet(t, name+".", value.Field(i))
+		case reflect.Pointer, reflect.Slice, reflect.Map:
+			if value.Field(i).IsNil() {
+				t.Errorf("Expected the sample configuration to set %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultReloadInterval is how often the configuration file is checked for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// Watcher reloads the configuration file when its content changes. The file is polled rather than
+// watched, because ConfigMap volumes replace it through a symlink swap that inotify watches miss.
+// Invalid content is logged and ignored, keeping the last valid configuration.
+type Watcher struct {
+	Path     string
+	Interval time.Duration
+	Log      logr.Logger
+	// OnChange is called with the previous and the new configuration after a valid change
+	OnChange func(old, new *SmartSchedulerConfiguration)
+
+	current *SmartSchedulerConfiguration
+	data    []byte
+}
+
+// NewWatcher returns a watcher for the file the current configuration was loaded from
+func NewWatcher(path string, current *SmartSchedulerConfiguration, log logr.Logger) *Watcher {
+	data, _ := os.ReadFile(path)
+	return &Watcher{
+		Path:     path,
+		Interval: DefaultReloadInterval,
+		Log:      log,
+		current:  current,
+		data:     data,
+	}
+}
+
+// Start polls the file every Interval until the context is cancelled. It implements manager.Runnable.
+func (w *Watcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.reload()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection returns false because every replica applies the configuration to itself
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload applies the file if its content changed since the last check
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		w.Log.Error(err, "Failed to read configuration file, keeping the current configuration", "path", w.Path)
+		return
+	}
+	if bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+
+	cfg, err := Parse(data)
+	if err != nil {
+		w.Log.Error(err, "Invalid configuration file, keeping the current configuration", "path", w.Path)
+		return
+	}
+
+	old := w.current
+	w.current = cfg
+	w.Log.Info("Configuration file changed", "path", w.Path, "changedFlags", ChangedFlags(old, cfg))
+	if w.OnChange != nil {
+		w.OnChange(old, cfg)
+	}
+}
@@ -0,0 +1,55 @@
+package decision
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Client calls the decision service over a gRPC connection
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient returns a Client using conn
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// DecidePlacement returns the rule the next pod would be placed by
+func (c *Client) DecidePlacement(ctx context.Context, req *DecidePlacementRequest, opts ...grpc.CallOption) (*DecidePlacementResponse, error) {
+	resp := &DecidePlacementResponse{}
+	return resp, c.invoke(ctx, "DecidePlacement", req, resp, opts...)
+}
+
+// SimulateStrategy returns how a strategy spreads a number of replicas
+func (c *Client) SimulateStrategy(ctx context.Context, req *SimulateStrategyRequest, opts ...grpc.CallOption) (*SimulateStrategyResponse, error) {
+	resp := &SimulateStrategyResponse{}
+	return resp, c.invoke(ctx, "SimulateStrategy", req, resp, opts...)
+}
+
+// GetDrift returns the placement drift of a deployment
+func (c *Client) GetDrift(ctx context.Context, req *GetDriftRequest, opts ...grpc.CallOption) (*GetDriftResponse, error) {
+	resp := &GetDriftResponse{}
+	return resp, c.invoke(ctx, "GetDrift", req, resp, opts...)
+}
+
+// SimulateOutage returns the managed deployments an outage of a zone would affect
+func (c *Client) SimulateOutage(ctx context.Context, req *SimulateOutageRequest, opts ...grpc.CallOption) (*SimulateOutageResponse, error) {
+	resp := &SimulateOutageResponse{}
+	return resp, c.invoke(ctx, "SimulateOutage", req, resp, opts...)
+}
+
+// invoke calls a method of the service, converting the request and response through Structs
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}, opts ...grpc.CallOption) error {
+	in, err := toStruct(req)
+	if err != nil {
+		return err
+	}
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, in, out, opts...); err != nil {
+		return err
+	}
+	return fromStruct(out, resp)
+}
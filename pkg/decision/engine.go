@@ -0,0 +1,317 @@
+// Package decision serves the placement engine over gRPC so systems outside Kubernetes, such as
+// capacity planners and internal portals, can ask how pods would be placed without sending
+// admission requests. Decisions are made with the same functions the webhook and the rebalancer
+// use, against pods read from the informer cache.
+//
+// Decisions are deterministic: rules are visited in strategy order, ties go to the earlier rule
+// (the later one when scaling down), counts are summed rather than iterated in map order, and
+// responses list rules in strategy order and deployments sorted by name. The only input besides the
+// request and the cluster is the time stuck pods are measured against, which Engine.Now fixes.
+package decision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ssclient "github.com/kube-smartscheduler/smart-scheduler/pkg/client"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// ErrUnmanaged is returned for deployments without a schedule strategy
+var ErrUnmanaged = errors.New("deployment has no schedule strategy")
+
+// Rule describes a rule of a strategy
+type Rule struct {
+	Key          string            `json:"key"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Weight       int               `json:"weight"`
+}
+
+// DecidePlacementRequest asks where the next pod would be placed. Either Namespace and Deployment
+// name a managed deployment, whose strategy and pods are used, or Strategy gives a strategy in the
+// schedule-strategy format with Counts as the pods each rule holds.
+type DecidePlacementRequest struct {
+	Namespace  string `json:"namespace,omitempty"`
+	Deployment string `json:"deployment,omitempty"`
+	// PriorityClassName selects a priority tier of the deployment's strategy
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	Strategy string `json:"strategy,omitempty"`
+	// Counts are keyed by rule key, e.g. "node-type=spot"
+	Counts map[string]int `json:"counts,omitempty"`
+	// Replicas sizes a percentage base of Strategy; it defaults to the pods in Counts plus the
+	// pod being placed
+	Replicas int `json:"replicas,omitempty"`
+}
+
+// DecidePlacementResponse is the rule the next pod would be placed by
+type DecidePlacementResponse struct {
+	Rule      Rule `json:"rule"`
+	RuleIndex int  `json:"ruleIndex"`
+	// Counts are the pods per rule the decision was made against
+	Counts map[string]int `json:"counts"`
+	// Unschedulable are the pods per rule stuck Unschedulable, whose rules the decision avoided
+	Unschedulable map[string]int `json:"unschedulable,omitempty"`
+}
+
+// SimulateStrategyRequest asks how a strategy spreads a number of replicas
+type SimulateStrategyRequest struct {
+	Strategy string `json:"strategy"`
+	Replicas int    `json:"replicas"`
+}
+
+// SimulateStrategyResponse holds the pods each rule is expected to hold at the requested replicas
+type SimulateStrategyResponse struct {
+	Base  int             `json:"base"`
+	Rules []SimulatedRule `json:"rules"`
+}
+
+// SimulatedRule is a rule with the pods it is expected to hold
+type SimulatedRule struct {
+	Rule
+	Expected int `json:"expected"`
+}
+
+// GetDriftRequest names the deployment whose drift is reported
+type GetDriftRequest struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+}
+
+// GetDriftResponse is the placement of a deployment as the rebalancer sees it
+type GetDriftResponse struct {
+	Expected          map[string]int `json:"expected"`
+	Actual            map[string]int `json:"actual"`
+	DriftPercentage   float64        `json:"driftPercentage"`
+	RequiresRebalance bool           `json:"requiresRebalance"`
+}
+
+// Decider answers placement questions. Engine decides against the cluster; the gRPC server serves
+// any Decider, so embedders and tests can put their own in front of it.
+type Decider interface {
+	DecidePlacement(ctx context.Context, req *DecidePlacementRequest) (*DecidePlacementResponse, error)
+	SimulateStrategy(ctx context.Context, req *SimulateStrategyRequest) (*SimulateStrategyResponse, error)
+	GetDrift(ctx context.Context, req *GetDriftRequest) (*GetDriftResponse, error)
+	SimulateOutage(ctx context.Context, req *SimulateOutageRequest) (*SimulateOutageResponse, error)
+}
+
+var _ Decider = (*Engine)(nil)
+
+// Engine answers placement questions for the decision service
+type Engine struct {
+	// Client reads deployments, pods, nodes and PodDisruptionBudgets, normally through the
+	// manager's cache
+	Client     client.Client
+	PoolHealth *webhook.PoolHealthChecker
+	// Unschedulable, when set, avoids rules of a deployment whose pods are stuck Unschedulable, as
+	// the webhook does
+	Unschedulable *webhook.UnschedulableSpill
+	// Forecast decides deployments by the distribution planned for their replicas, as the webhook
+	// does with the ReplicaForecast feature gate
+	Forecast bool
+	// Now returns the time pods stuck Unschedulable are measured against; nil uses time.Now. Tests
+	// fix it so decisions do not depend on when they run.
+	Now func() time.Time
+}
+
+// now returns the time decisions are made at
+func (e *Engine) now() time.Time {
+	if e.Now == nil {
+		return time.Now()
+	}
+	return e.Now()
+}
+
+// DecidePlacement returns the rule the webhook would place the next pod by. Deployments are decided
+// against the pods in the informer cache, as in the webhook's fallback mode, so a decision can
+// differ from the webhook's while admissions recorded in the placement state are still being
+// observed.
+func (e *Engine) DecidePlacement(ctx context.Context, req *DecidePlacementRequest) (*DecidePlacementResponse, error) {
+	var strategy *webhook.PlacementStrategy
+	var counts, stuck map[webhook.RuleKey]int
+	var replicas int
+	var err error
+	switch {
+	case req.Strategy != "" && req.Deployment != "":
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("set either a deployment or a strategy"))
+	case req.Deployment != "":
+		strategy, counts, stuck, replicas, err = e.deploymentCounts(ctx, req)
+	case req.Strategy != "":
+		strategy, counts, err = standaloneCounts(req)
+	default:
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("a deployment or a strategy is required"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &corev1.Pod{}
+	if strategy.IsFailover() {
+		err = webhook.ApplyFailoverStrategy(pod, strategy, e.PoolHealth.HealthFunc(ctx))
+	} else if replicas > 0 {
+		err = webhook.ApplyForecastStrategy(pod, strategy, counts, replicas)
+	} else {
+		err = webhook.ApplyPlacementStrategy(pod, strategy, counts)
+	}
+	if err != nil {
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, err)
+	}
+
+	ruleKey, _ := webhook.MatchRuleKey(strategy, pod.Spec.NodeSelector)
+	index := 0
+	for i, rule := range strategy.Rules {
+		if rule.Key() == ruleKey {
+			index = i
+			break
+		}
+	}
+	return &DecidePlacementResponse{
+		Rule:          describeRule(strategy.Rules[index]),
+		RuleIndex:     index,
+		Counts:        countsByKey(counts),
+		Unschedulable: countsByKey(stuck),
+	}, nil
+}
+
+// deploymentCounts returns the resolved strategy of the requested deployment without the rules
+// whose pods are stuck Unschedulable, its pods per rule, its stuck pods per rule and the replicas
+// its placements are planned for, 0 without a forecast
+func (e *Engine) deploymentCounts(ctx context.Context, req *DecidePlacementRequest) (*webhook.PlacementStrategy, map[webhook.RuleKey]int, map[webhook.RuleKey]int, int, error) {
+	deployment := &appsv1.Deployment{}
+	if err := e.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: req.Deployment}, deployment); err != nil {
+		return nil, nil, nil, 0, err
+	}
+
+	priorityClassName := req.PriorityClassName
+	if priorityClassName == "" {
+		priorityClassName = deployment.Spec.Template.Spec.PriorityClassName
+	}
+	annotation, exists, err := webhook.ResolveScheduleStrategy(deployment.Annotations, priorityClassName)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if !exists {
+		return nil, nil, nil, 0, ErrUnmanaged
+	}
+
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if strategy, err = webhook.ResolveBase(ctx, e.Client, deployment, strategy); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if strategy, err = webhook.WithRuleRenames(deployment, strategy); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	pods, err := webhook.ListStrategyPods(ctx, e.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	counts := webhook.CountPodsByRule(pods, strategy)
+	strategy, stuck := e.Unschedulable.SpillAt(pods, strategy, e.now())
+	replicas := 0
+	if e.Forecast {
+		replicas = webhook.ForecastReplicas(deployment)
+	}
+	return strategy, counts, stuck, replicas, nil
+}
+
+// standaloneCounts returns the requested strategy and the requested pods per rule
+func standaloneCounts(req *DecidePlacementRequest) (*webhook.PlacementStrategy, map[webhook.RuleKey]int, error) {
+	counts := make(map[webhook.RuleKey]int, len(req.Counts))
+	replicas := 1
+	for key, count := range req.Counts {
+		counts[webhook.CanonicalRuleKey(key)] += count
+		replicas += count
+	}
+	if req.Replicas > 0 {
+		replicas = req.Replicas
+	}
+
+	strategy, err := standaloneStrategy(req.Strategy, replicas)
+	if err != nil {
+		return nil, nil, err
+	}
+	return strategy, counts, nil
+}
+
+// standaloneStrategy parses a strategy that is not attached to a deployment and sizes a percentage
+// base for the given replicas. There is no deployment to find a PodDisruptionBudget for, so a base
+// derived from one falls back to the configured base.
+func standaloneStrategy(annotation string, replicas int) (*webhook.PlacementStrategy, error) {
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return nil, err
+	}
+	if strategy.BasePercent == 0 {
+		return strategy, nil
+	}
+	resolved := *strategy
+	resolved.Base = webhook.PercentBase(strategy.BasePercent, replicas)
+	return &resolved, nil
+}
+
+// SimulateStrategy returns how many of the requested replicas each rule of the strategy would hold.
+// Failover chains place every replica on the first rule whose pool is currently healthy.
+func (e *Engine) SimulateStrategy(ctx context.Context, req *SimulateStrategyRequest) (*SimulateStrategyResponse, error) {
+	if req.Replicas < 0 {
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("replicas must not be negative: %d", req.Replicas))
+	}
+	strategy, err := standaloneStrategy(req.Strategy, req.Replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	var expected map[webhook.RuleKey]int
+	if strategy.IsFailover() {
+		expected = make(map[webhook.RuleKey]int, len(strategy.Rules))
+		expected[strategy.Rules[webhook.SelectFailoverRule(strategy, e.PoolHealth.HealthFunc(ctx))].Key()] = req.Replicas
+	} else {
+		expected = webhook.ExpectedDistribution(strategy, req.Replicas)
+	}
+
+	resp := &SimulateStrategyResponse{Base: strategy.Base, Rules: make([]SimulatedRule, 0, len(strategy.Rules))}
+	for _, rule := range strategy.Rules {
+		resp.Rules = append(resp.Rules, SimulatedRule{Rule: describeRule(rule), Expected: expected[rule.Key()]})
+	}
+	return resp, nil
+}
+
+// GetDrift returns the expected and actual pods per rule of a deployment and its drift
+func (e *Engine) GetDrift(ctx context.Context, req *GetDriftRequest) (*GetDriftResponse, error) {
+	placement, err := ssclient.NewForClient(e.Client).Placement(ctx, req.Namespace, req.Deployment)
+	if err != nil {
+		return nil, err
+	}
+	if placement == nil {
+		return nil, ErrUnmanaged
+	}
+	return &GetDriftResponse{
+		Expected:          countsByKey(placement.Expected),
+		Actual:            countsByKey(placement.Actual),
+		DriftPercentage:   placement.DriftPercentage,
+		RequiresRebalance: placement.RequiresRebalance,
+	}, nil
+}
+
+// describeRule converts a parsed rule into its response form
+func describeRule(rule webhook.PlacementRule) Rule {
+	return Rule{Key: rule.Key().String(), NodeSelector: rule.NodeSelector, Weight: rule.Weight}
+}
+
+// countsByKey converts counts keyed by RuleKey into counts keyed by the key's string form
+func countsByKey(counts map[webhook.RuleKey]int) map[string]int {
+	out := make(map[string]int, len(counts))
+	for key, count := range counts {
+		out[key.String()] = count
+	}
+	return out
+}
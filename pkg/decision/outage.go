@@ -0,0 +1,224 @@
+package decision
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// Outage breaches, as reported in OutageImpact.Breach
+const (
+	// BreachBasePodsLost means base pods run in the zone. Replacements go to the base rule's
+	// remaining nodes, so the base is restored once they are scheduled.
+	BreachBasePodsLost = "BasePodsLost"
+	// BreachBasePoolLost means no healthy node of the base rule's pool is outside the zone, so
+	// replacement base pods stay Pending until the zone recovers
+	BreachBasePoolLost = "BasePoolLost"
+	// BreachNoHealthyPool means no rule of a failover chain has a healthy node outside the zone
+	BreachNoHealthyPool = "NoHealthyPool"
+)
+
+// SimulateOutageRequest names the zone whose nodes are removed from the model
+type SimulateOutageRequest struct {
+	Zone string `json:"zone"`
+	// ZoneLabel is the node label holding the zone; it defaults to topology.kubernetes.io/zone
+	ZoneLabel string `json:"zoneLabel,omitempty"`
+	// Namespace limits the simulation to the deployments of one namespace
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SimulateOutageResponse lists the managed deployments an outage of the zone would affect
+type SimulateOutageResponse struct {
+	Zone         string `json:"zone"`
+	RemovedNodes int    `json:"removedNodes"`
+	// Deployments lose pods in the zone or breach their base guarantee, ordered by namespace and name
+	Deployments []OutageImpact `json:"deployments"`
+	// Breaches counts the deployments whose base guarantee would be breached
+	Breaches int `json:"breaches"`
+}
+
+// OutageImpact is what an outage does to one managed deployment
+type OutageImpact struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	// Base is the number of pods the strategy guarantees on its first rule
+	Base int `json:"base"`
+	// BasePods are the running pods of the first rule, and BasePodsLeft those outside the zone
+	BasePods     int `json:"basePods"`
+	BasePodsLeft int `json:"basePodsLeft"`
+	// LostPods are the deployment's running pods in the zone
+	LostPods int `json:"lostPods"`
+	// BaseRuleNodesLeft are the healthy nodes of the first rule's pool outside the zone
+	BaseRuleNodesLeft int `json:"baseRuleNodesLeft"`
+	// Breach is BasePodsLost, BasePoolLost or NoHealthyPool when the base guarantee is breached
+	Breach string `json:"breach,omitempty"`
+}
+
+// SimulateOutage removes the nodes of a zone from the model and reports which managed deployments
+// would lose pods and which would breach their base guarantee. A weighted strategy breaches it when
+// the zone holds base pods the first rule cannot keep above the base, and cannot restore it when
+// the first rule's pool has no healthy node left. A failover chain breaches it when no rule has a
+// healthy node left. Pods are counted as in DecidePlacement, from the client's pods.
+func (e *Engine) SimulateOutage(ctx context.Context, req *SimulateOutageRequest) (*SimulateOutageResponse, error) {
+	if req.Zone == "" {
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("a zone is required"))
+	}
+	zoneLabel := req.ZoneLabel
+	if zoneLabel == "" {
+		zoneLabel = corev1.LabelTopologyZone
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := e.Client.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	inZone := make(map[string]bool)
+	var remaining []corev1.Node
+	for _, node := range nodes.Items {
+		if node.Labels[zoneLabel] == req.Zone {
+			inZone[node.Name] = true
+		} else if e.PoolHealth.NodeHealthy(&node) {
+			remaining = append(remaining, node)
+		}
+	}
+	if len(inZone) == 0 {
+		return nil, webhook.Classify(webhook.ErrStrategyInvalid, fmt.Errorf("no node has %s=%s", zoneLabel, req.Zone))
+	}
+	// healthyLeft counts the healthy nodes outside the zone a rule's pods can be scheduled on
+	healthyLeft := func(rule webhook.PlacementRule) int {
+		selector := rule.NodeLabelSelector()
+		count := 0
+		for _, node := range remaining {
+			if selector.Matches(labels.Set(node.Labels)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	podsByDeployment, err := e.podsByDeployment(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := e.Client.List(ctx, deployments, client.InNamespace(req.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	resp := &SimulateOutageResponse{Zone: req.Zone, RemovedNodes: len(inZone), Deployments: []OutageImpact{}}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		annotation, exists, err := webhook.ResolveScheduleStrategy(deployment.Annotations, deployment.Spec.Template.Spec.PriorityClassName)
+		if err != nil || !exists {
+			continue
+		}
+		strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+		if err != nil {
+			continue
+		}
+		if resolved, err := webhook.ResolveBase(ctx, e.Client, deployment, strategy); err == nil {
+			strategy = resolved
+		}
+
+		impact := OutageImpact{Namespace: deployment.Namespace, Deployment: deployment.Name}
+		baseKey := strategy.Rules[0].Key()
+		pods := webhook.SelectStrategyPods(deployment, podsByDeployment[types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}])
+		matcher := webhook.NewRuleMatcher(strategy)
+		for _, pod := range pods {
+			lost := inZone[pod.Spec.NodeName]
+			if lost {
+				impact.LostPods++
+			}
+			if ruleKey, ok := matcher.Match(webhook.PlacementSelector(&pod)); ok && ruleKey == baseKey {
+				impact.BasePods++
+				if !lost {
+					impact.BasePodsLeft++
+				}
+			}
+		}
+
+		if strategy.IsFailover() {
+			placeable := false
+			for _, rule := range strategy.Rules {
+				if healthyLeft(rule) > 0 {
+					placeable = true
+					break
+				}
+			}
+			if !placeable {
+				impact.Breach = BreachNoHealthyPool
+			}
+		} else if strategy.Base > 0 {
+			impact.Base = strategy.Base
+			impact.BaseRuleNodesLeft = healthyLeft(strategy.Rules[0])
+			switch {
+			case impact.BaseRuleNodesLeft == 0:
+				impact.Breach = BreachBasePoolLost
+			case impact.BasePodsLeft < impact.Base && impact.BasePodsLeft < impact.BasePods:
+				impact.Breach = BreachBasePodsLost
+			}
+		}
+
+		if impact.LostPods == 0 && impact.Breach == "" {
+			continue
+		}
+		if impact.Breach != "" {
+			resp.Breaches++
+		}
+		resp.Deployments = append(resp.Deployments, impact)
+	}
+
+	sort.Slice(resp.Deployments, func(i, j int) bool {
+		a, b := resp.Deployments[i], resp.Deployments[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Deployment < b.Deployment
+	})
+	return resp, nil
+}
+
+// podsByDeployment returns the scheduled, running pods of every deployment in the namespace, or in
+// all namespaces. Pods and ReplicaSets are listed once and matched by owner, which works without
+// the cache's owner indexes.
+func (e *Engine) podsByDeployment(ctx context.Context, namespace string) (map[types.NamespacedName][]corev1.Pod, error) {
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := e.Client.List(ctx, replicaSets, client.InNamespace(namespace), client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list replicasets: %w", err)
+	}
+	owners := make(map[types.UID]types.NamespacedName, len(replicaSets.Items))
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if ownerRef := metav1.GetControllerOf(rs); ownerRef != nil && ownerRef.Kind == "Deployment" {
+			owners[rs.UID] = types.NamespacedName{Namespace: rs.Namespace, Name: ownerRef.Name}
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := e.Client.List(ctx, pods, client.InNamespace(namespace), client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	byDeployment := make(map[types.NamespacedName][]corev1.Pod)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		ownerRef := metav1.GetControllerOf(&pod)
+		if ownerRef == nil {
+			continue
+		}
+		if deployment, ok := owners[ownerRef.UID]; ok {
+			byDeployment[deployment] = append(byDeployment[deployment], pod)
+		}
+	}
+	return byDeployment, nil
+}
@@ -0,0 +1,213 @@
+package decision
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// ServiceName is the gRPC service declared in decision.proto
+const ServiceName = "smartscheduler.decision.v1.DecisionService"
+
+var decisionRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_decision_requests_total",
+		Help: "Requests served by the gRPC decision service, by method and status code",
+	},
+	[]string{"method", "code"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(decisionRequests)
+}
+
+// serviceDesc describes the decision service. Requests and responses are google.protobuf.Struct
+// messages holding the JSON form of the request and response types, so no generated code is needed
+// and the service can be called with any gRPC client.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Decider)(nil),
+	Methods: []grpc.MethodDesc{
+		method("DecidePlacement", Decider.DecidePlacement),
+		method("SimulateStrategy", Decider.SimulateStrategy),
+		method("GetDrift", Decider.GetDrift),
+		method("SimulateOutage", Decider.SimulateOutage),
+	},
+	Metadata: "pkg/decision/decision.proto",
+}
+
+// method adapts a Decider method to a unary gRPC method taking and returning Structs
+func method[Req, Resp any](name string, call func(Decider, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	handle := func(srv interface{}, ctx context.Context, in interface{}) (interface{}, error) {
+		req := new(Req)
+		if err := fromStruct(in.(*structpb.Struct), req); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s request: %v", name, err)
+		}
+		resp, err := call(srv.(Decider), ctx, req)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return toStruct(resp)
+	}
+
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &structpb.Struct{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handle(srv, ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, in, info, func(ctx context.Context, in interface{}) (interface{}, error) {
+				return handle(srv, ctx, in)
+			})
+		},
+	}
+}
+
+// fromStruct decodes a Struct into a request through its JSON form
+func fromStruct(in *structpb.Struct, out interface{}) error {
+	data, err := in.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// toStruct encodes a response as a Struct through its JSON form
+func toStruct(in interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := &structpb.Struct{}
+	if err := out.UnmarshalJSON(data); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// statusError maps engine errors to gRPC status codes
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, webhook.ErrStrategyInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUnmanaged):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case apierrors.IsNotFound(err):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// countRequests records the method and status code of every request
+func countRequests(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	decisionRequests.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Inc()
+	return resp, err
+}
+
+// NewGRPCServer returns a gRPC server with the decision service of decider registered
+func NewGRPCServer(decider Decider, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(countRequests)}, opts...)...)
+	server.RegisterService(&serviceDesc, decider)
+	return server
+}
+
+// Server serves the decision service over TLS on BindAddress until the manager stops.
+// It implements manager.Runnable.
+type Server struct {
+	Engine      Decider
+	BindAddress string
+	// CertDir holds the serving certificate, tls.crt and tls.key, which is reloaded when rotated
+	CertDir string
+	// ClientCAFile, if set, is the CA bundle that must sign the client certificate of every caller
+	ClientCAFile string
+	Log          logr.Logger
+}
+
+// Start listens on BindAddress and serves requests, stopping gracefully when ctx is done
+func (s *Server) Start(ctx context.Context) error {
+	config, err := s.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return err
+	}
+
+	server := NewGRPCServer(s.Engine, grpc.Creds(credentials.NewTLS(config)))
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	if s.ClientCAFile == "" {
+		s.Log.Info("The decision service answers callers without client certificates; restrict who can reach it")
+	}
+	s.Log.Info("Serving the decision service", "address", listener.Addr().String(), "clientCAFile", s.ClientCAFile)
+	return server.Serve(listener)
+}
+
+// tlsConfig serves the certificate in CertDir, watched until ctx is done, and requires client
+// certificates signed by ClientCAFile when set
+func (s *Server) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	watcher, err := certwatcher.New(filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the decision service certificate: %w", err)
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			s.Log.Error(err, "certificate watcher error")
+		}
+	}()
+	config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: watcher.GetCertificate}
+	if s.ClientCAFile == "" {
+		return config, nil
+	}
+
+	ca, err := os.ReadFile(s.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the decision service client CA: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", s.ClientCAFile)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// NeedLeaderElection returns false because every replica can answer decisions from its own cache
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
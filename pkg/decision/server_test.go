@@ -0,0 +1,448 @@
+package decision
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const testStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// echoDecider answers DecidePlacement with the request it received and fails the other methods
+// with err
+type echoDecider struct {
+	received *DecidePlacementRequest
+	err      error
+}
+
+func (d *echoDecider) DecidePlacement(_ context.Context, req *DecidePlacementRequest) (*DecidePlacementResponse, error) {
+	d.received = req
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &DecidePlacementResponse{
+		Rule:      Rule{Key: req.Strategy, NodeSelector: map[string]string{"node-type": "spot"}, Weight: 3},
+		RuleIndex: req.Replicas,
+		Counts:    req.Counts,
+	}, nil
+}
+
+func (d *echoDecider) SimulateStrategy(context.Context, *SimulateStrategyRequest) (*SimulateStrategyResponse, error) {
+	return nil, d.err
+}
+
+func (d *echoDecider) GetDrift(context.Context, *GetDriftRequest) (*GetDriftResponse, error) {
+	return nil, d.err
+}
+
+func (d *echoDecider) SimulateOutage(context.Context, *SimulateOutageRequest) (*SimulateOutageResponse, error) {
+	return nil, d.err
+}
+
+// serve serves the decision service of decider in memory and returns a connection to it
+func serve(t *testing.T, decider Decider) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(decider)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestStatusError(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web")
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"Invalid strategy", webhook.Classify(webhook.ErrStrategyInvalid, errors.New("bad weight")), codes.InvalidArgument},
+		{"Unmanaged deployment", ErrUnmanaged, codes.FailedPrecondition},
+		{"Wrapped unmanaged deployment", fmt.Errorf("web: %w", ErrUnmanaged), codes.FailedPrecondition},
+		{"Missing deployment", notFound, codes.NotFound},
+		{"Deadline exceeded", fmt.Errorf("listing pods: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"Canceled", context.Canceled, codes.Canceled},
+		{"Other error", errors.New("cache not synced"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := statusError(tt.err)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("statusError() code = %v, want %v", got, tt.want)
+			}
+			if got := status.Convert(err).Message(); got != tt.err.Error() {
+				t.Errorf("statusError() message = %q, want %q", got, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestMethodStructRoundTrip(t *testing.T) {
+	decider := &echoDecider{}
+	conn := serve(t, decider)
+	ctx := context.Background()
+
+	req := &DecidePlacementRequest{Strategy: testStrategy, Counts: map[string]int{"node-type=spot": 2}, Replicas: 4}
+	resp, err := NewClient(conn).DecidePlacement(ctx, req)
+	if err != nil {
+		t.Fatalf("DecidePlacement() error = %v", err)
+	}
+	if !reflect.DeepEqual(decider.received, req) {
+		t.Errorf("Decider received %+v, want %+v", decider.received, req)
+	}
+	want := &DecidePlacementResponse{
+		Rule:      Rule{Key: testStrategy, NodeSelector: map[string]string{"node-type": "spot"}, Weight: 3},
+		RuleIndex: 4,
+		Counts:    map[string]int{"node-type=spot": 2},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("DecidePlacement() = %+v, want %+v", resp, want)
+	}
+
+	// A request that does not decode into the request type is refused before the decider is called
+	decider.received = nil
+	malformed, err := structpb.NewStruct(map[string]interface{}{"strategy": testStrategy, "replicas": "four"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Invoke(ctx, "/"+ServiceName+"/DecidePlacement", malformed, &structpb.Struct{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a malformed request, got %v", err)
+	}
+	if decider.received != nil {
+		t.Errorf("Expected the decider not called, got %+v", decider.received)
+	}
+
+	// Decider errors reach the caller as status codes
+	decider.err = ErrUnmanaged
+	if _, err := NewClient(conn).SimulateStrategy(ctx, &SimulateStrategyRequest{Strategy: testStrategy}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+}
+
+// builderIndexer registers the webhook's field indexes on a fake client builder
+type builderIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (b builderIndexer) IndexField(_ context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	b.builder.WithIndex(obj, field, extract)
+	return nil
+}
+
+// newTestEngine returns an engine over a fake client holding a deployment "web" with testStrategy,
+// whose pods carry the nodeSelectors, and an unmanaged deployment "plain"
+func newTestEngine(t *testing.T, selectors ...map[string]string) *Engine {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         types.UID("deployment-uid"),
+			Annotations: map[string]string{webhook.ScheduleStrategyAnnotation: testStrategy},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc",
+			Namespace: "default",
+			UID:       types.UID("rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+	}
+	plain := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"}}
+
+	objects := []client.Object{deployment, rs, plain}
+	for i, selector := range selectors {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("web-abc-%d", i),
+				Namespace: "default",
+				UID:       types.UID(fmt.Sprintf("pod-%d", i)),
+				Labels:    map[string]string{"app": "web"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+				}},
+			},
+			Spec:   corev1.PodSpec{NodeSelector: selector},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...)
+	if err := webhook.SetupIndexers(context.Background(), builderIndexer{builder}); err != nil {
+		t.Fatal(err)
+	}
+	c := builder.Build()
+	return &Engine{Client: c, PoolHealth: webhook.NewPoolHealthChecker(c, logr.Discard())}
+}
+
+func TestDecidePlacementThroughService(t *testing.T) {
+	onDemand := map[string]string{"node-type": "ondemand"}
+	spot := map[string]string{"node-type": "spot"}
+
+	tests := []struct {
+		name     string
+		pods     []map[string]string
+		req      *DecidePlacementRequest
+		wantRule string
+		wantCode codes.Code
+	}{
+		{
+			name:     "First pod of a deployment fills the base",
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "web"},
+			wantRule: "node-type=ondemand",
+		},
+		{
+			name:     "Deployment weighted once its base is filled",
+			pods:     []map[string]string{onDemand},
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "web"},
+			wantRule: "node-type=spot",
+		},
+		{
+			name:     "Deployment weights follow its pods",
+			pods:     []map[string]string{onDemand, spot, spot, spot},
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "web"},
+			wantRule: "node-type=ondemand",
+		},
+		{
+			name:     "Standalone strategy decided against the requested counts",
+			req:      &DecidePlacementRequest{Strategy: testStrategy, Counts: map[string]int{"node-type=ondemand": 1}},
+			wantRule: "node-type=spot",
+		},
+		{
+			name:     "Missing deployment",
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "missing"},
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "Deployment without a strategy",
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "plain"},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "Both a deployment and a strategy",
+			req:      &DecidePlacementRequest{Namespace: "default", Deployment: "web", Strategy: testStrategy},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "Malformed strategy",
+			req:      &DecidePlacementRequest{Strategy: "weight=x"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := NewClient(serve(t, newTestEngine(t, tt.pods...)))
+			resp, err := decisions.DecidePlacement(context.Background(), tt.req)
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Errorf("Expected %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecidePlacement() error = %v", err)
+			}
+			if resp.Rule.Key != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", resp.Rule.Key, tt.wantRule)
+			}
+			if got := resp.Counts["node-type=ondemand"] + resp.Counts["node-type=spot"]; tt.req.Deployment != "" && got != len(tt.pods) {
+				t.Errorf("Counts = %v, want the deployment's %d pods", resp.Counts, len(tt.pods))
+			}
+		})
+	}
+}
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate and key signed by the CA, in PEM
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestServerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	serverCert, serverKey := ca.issue(t, "decision.test", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "portal", x509.ExtKeyUsageClientAuth)
+	for name, data := range map[string][]byte{"tls.crt": serverCert, "tls.key": serverKey, "ca.crt": ca.pem} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certificate, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		// withCert presents the client certificate
+		withCert bool
+		wantOK   bool
+	}{
+		{"Without a client CA callers need no certificate", "", false, true},
+		{"Client certificate signed by the client CA", filepath.Join(dir, "ca.crt"), true, true},
+		{"Caller without a certificate refused", filepath.Join(dir, "ca.crt"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := &Server{CertDir: dir, ClientCAFile: tt.clientCAFile, Log: logr.Discard()}
+			config, err := s.tlsConfig(ctx)
+			if err != nil {
+				t.Fatalf("tlsConfig() error = %v", err)
+			}
+			listener := bufconn.Listen(1 << 20)
+			server := NewGRPCServer(&echoDecider{}, grpc.Creds(credentials.NewTLS(config)))
+			go func() { _ = server.Serve(listener) }()
+			defer server.Stop()
+
+			clientConfig := &tls.Config{RootCAs: roots, ServerName: "decision.test", MinVersion: tls.VersionTLS12}
+			if tt.withCert {
+				clientConfig.Certificates = []tls.Certificate{certificate}
+			}
+			conn, err := grpc.Dial("bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+				grpc.WithTransportCredentials(credentials.NewTLS(clientConfig)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer callCancel()
+			_, err = NewClient(conn).DecidePlacement(callCtx, &DecidePlacementRequest{Strategy: testStrategy})
+			if tt.wantOK && err != nil {
+				t.Errorf("DecidePlacement() error = %v", err)
+			}
+			if !tt.wantOK && status.Code(err) != codes.Unavailable {
+				t.Errorf("Expected the caller refused, got %v", err)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := (&Server{CertDir: t.TempDir(), Log: logr.Discard()}).tlsConfig(ctx); err == nil {
+		t.Error("Expected an error without a serving certificate")
+	}
+	if _, err := (&Server{CertDir: dir, ClientCAFile: filepath.Join(dir, "tls.key"), Log: logr.Discard()}).tlsConfig(ctx); err == nil {
+		t.Error("Expected an error for a client CA file without certificates")
+	}
+}
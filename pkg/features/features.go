@@ -0,0 +1,261 @@
+package features
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Feature names an optional subsystem that can be turned on or off per cluster
+type Feature string
+
+// Stage describes how mature a feature is
+type Stage string
+
+const (
+	// Alpha features are experimental, off by default and may change or be removed
+	Alpha Stage = "ALPHA"
+	// Beta features are well tested and may be on by default
+	Beta Stage = "BETA"
+	// GA features are stable; their gate only exists for compatibility
+	GA Stage = "GA"
+)
+
+const (
+	// ImageArchCheck looks up image manifests to keep pods off unsupported node architectures
+	ImageArchCheck Feature = "ImageArchCheck"
+	// PolicyPreflight reports PodPlacementPolicy rules whose nodeSelector matches no node
+	PolicyPreflight Feature = "PolicyPreflight"
+	// PlacementAudit reports processed pods whose nodeSelector lost their placement rule
+	PlacementAudit Feature = "PlacementAudit"
+	// PlacementAuditRecreate deletes pods found by the placement audit so they are admitted again
+	PlacementAuditRecreate Feature = "PlacementAuditRecreate"
+	// PlacementBindAudit records whether the node each processed pod was bound to matches its placement rule
+	PlacementBindAudit Feature = "PlacementBindAudit"
+	// SpotPlacementScores lowers the weights of spot rules whose pools a cloud provider scores as unlikely to be filled
+	SpotPlacementScores Feature = "SpotPlacementScores"
+	// StatefulSetPlacement places and rebalances the pods of StatefulSets with a strategy by their ordinals
+	StatefulSetPlacement Feature = "StatefulSetPlacement"
+	// DecisionOwnership lets one webhook replica decide each deployment's placements, forwarding the others
+	DecisionOwnership Feature = "DecisionOwnership"
+	// CapacityReservation keeps placeholder pods on the pools of rules with a reserve count
+	CapacityReservation Feature = "CapacityReservation"
+	// BinPacking prefers nodes of a rule's pool by utilization for rules with a packing mode
+	BinPacking Feature = "BinPacking"
+	// PlacementExperiments splits a deployment's pods between its strategy and a candidate strategy
+	PlacementExperiments Feature = "PlacementExperiments"
+	// ScaleDownHints sets pod deletion costs so scale-downs remove pods from over-represented rules first
+	ScaleDownHints Feature = "ScaleDownHints"
+	// PolicyMigration moves deployments configured with strategy annotations to generated PodPlacementPolicies
+	PolicyMigration Feature = "PolicyMigration"
+	// WebhookConfigurationCheck reports mutating webhook configurations that keep the API server from calling the webhook
+	WebhookConfigurationCheck Feature = "WebhookConfigurationCheck"
+	// ReplicaForecast places each pod in its slot of the distribution planned for the deployment's replicas
+	ReplicaForecast Feature = "ReplicaForecast"
+	// BaseProtection marks pods filling the base so scale-downs and preemption take weighted pods first
+	BaseProtection Feature = "BaseProtection"
+	// WeightTuning lowers the weights of rules whose pods are preempted often, within configured bounds
+	WeightTuning Feature = "WeightTuning"
+	// RuleRekey reports rules whose nodes were relabeled and moves their pods and placement state to the new selectors
+	RuleRekey Feature = "RuleRekey"
+	// CounterLeases keeps each deployment's placement counters in the memory of the webhook replica holding its Lease
+	CounterLeases Feature = "CounterLeases"
+)
+
+// FeatureSpec is the default and maturity of a feature
+type FeatureSpec struct {
+	Default bool
+	Stage   Stage
+}
+
+// defaultFeatures lists every known feature. New experimental subsystems register here as Alpha
+// so they ship disabled and are turned on per cluster with --feature-gates.
+var defaultFeatures = map[Feature]FeatureSpec{
+	ImageArchCheck:            {Default: false, Stage: Beta},
+	PolicyPreflight:           {Default: false, Stage: Alpha},
+	PlacementAudit:            {Default: true, Stage: Beta},
+	PlacementAuditRecreate:    {Default: false, Stage: Alpha},
+	DecisionOwnership:         {Default: false, Stage: Alpha},
+	CapacityReservation:       {Default: false, Stage: Alpha},
+	BinPacking:                {Default: false, Stage: Alpha},
+	PlacementExperiments:      {Default: false, Stage: Alpha},
+	ScaleDownHints:            {Default: false, Stage: Alpha},
+	PolicyMigration:           {Default: false, Stage: Alpha},
+	WebhookConfigurationCheck: {Default: false, Stage: Alpha},
+	ReplicaForecast:           {Default: false, Stage: Alpha},
+	BaseProtection:            {Default: false, Stage: Alpha},
+	WeightTuning:              {Default: false, Stage: Alpha},
+	RuleRekey:                 {Default: false, Stage: Alpha},
+	PlacementBindAudit:        {Default: false, Stage: Alpha},
+	SpotPlacementScores:       {Default: false, Stage: Alpha},
+	StatefulSetPlacement:      {Default: false, Stage: Alpha},
+	CounterLeases:             {Default: false, Stage: Alpha},
+}
+
+var featureEnabled = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "smart_scheduler_feature_enabled",
+		Help: "Whether a feature gate is enabled (1) or disabled (0)",
+	},
+	[]string{"name", "stage"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(featureEnabled)
+}
+
+// Gates holds the enabled state of every known feature. It implements flag.Value for
+// --feature-gates, accepting a comma-separated list of Feature=bool pairs.
+type Gates struct {
+	mu      sync.RWMutex
+	known   map[Feature]FeatureSpec
+	enabled map[Feature]bool
+}
+
+// DefaultGates is the process-wide feature gate set by --feature-gates
+var DefaultGates = NewGates(defaultFeatures)
+
+// NewGates returns gates for the given features, each at its default
+func NewGates(known map[Feature]FeatureSpec) *Gates {
+	g := &Gates{
+		known:   known,
+		enabled: make(map[Feature]bool, len(known)),
+	}
+	for feature, spec := range known {
+		g.enabled[feature] = spec.Default
+	}
+	return g
+}
+
+// Enabled reports whether the feature is enabled. Unknown features are disabled.
+func (g *Gates) Enabled(feature Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.enabled[feature]
+}
+
+// SetEnabled enables or disables one feature
+func (g *Gates) SetEnabled(feature Feature, enabled bool) error {
+	if _, ok := g.known[feature]; !ok {
+		return fmt.Errorf("unknown feature gate %q", feature)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.enabled[feature] = enabled
+	return nil
+}
+
+// Set parses a comma-separated list of Feature=bool pairs. Nothing is changed if any pair is invalid.
+func (g *Gates) Set(value string) error {
+	parsed := make(map[Feature]bool)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, rawEnabled, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("missing bool value for feature gate %q", pair)
+		}
+		feature := Feature(strings.TrimSpace(name))
+		if _, known := g.known[feature]; !known {
+			return fmt.Errorf("unknown feature gate %q", feature)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(rawEnabled))
+		if err != nil {
+			return fmt.Errorf("invalid value %q for feature gate %q", rawEnabled, feature)
+		}
+		parsed[feature] = enabled
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for feature, enabled := range parsed {
+		g.enabled[feature] = enabled
+	}
+	return nil
+}
+
+// String returns the features that differ from their default, in --feature-gates format
+func (g *Gates) String() string {
+	if g == nil {
+		return ""
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var pairs []string
+	for feature, enabled := range g.enabled {
+		if enabled != g.known[feature].Default {
+			pairs = append(pairs, fmt.Sprintf("%s=%t", feature, enabled))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Known returns a sorted description of every feature for flag help
+func (g *Gates) Known() []string {
+	var known []string
+	for feature, spec := range g.known {
+		known = append(known, fmt.Sprintf("%s=true|false (%s - default=%t)", feature, spec.Stage, spec.Default))
+	}
+	sort.Strings(known)
+	return known
+}
+
+// IsKnown reports whether the feature is registered
+func (g *Gates) IsKnown(feature Feature) bool {
+	_, ok := g.known[feature]
+	return ok
+}
+
+// RecordMetrics publishes the enabled state of every feature
+func (g *Gates) RecordMetrics() {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for feature, enabled := range g.enabled {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		featureEnabled.WithLabelValues(string(feature), string(g.known[feature].Stage)).Set(value)
+	}
+}
+
+// boolFlag adapts one feature to a boolean flag, so older per-feature flags keep working
+type boolFlag struct {
+	gates   *Gates
+	feature Feature
+}
+
+// BoolFlag returns a flag.Value that enables or disables feature on gates
+func BoolFlag(gates *Gates, feature Feature) flag.Value {
+	return &boolFlag{gates: gates, feature: feature}
+}
+
+func (f *boolFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	return f.gates.SetEnabled(f.feature, enabled)
+}
+
+func (f *boolFlag) String() string {
+	if f == nil || f.gates == nil {
+		return "false"
+	}
+	return strconv.FormatBool(f.gates.Enabled(f.feature))
+}
+
+// IsBoolFlag lets the flag be given without a value
+func (f *boolFlag) IsBoolFlag() bool {
+	return true
+}
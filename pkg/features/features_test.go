@@ -0,0 +1,114 @@
+package features
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestGatesSet(t *testing.T) {
+	known := map[Feature]FeatureSpec{
+		PlacementAudit:  {Default: true, Stage: Beta},
+		PolicyPreflight: {Default: false, Stage: Alpha},
+		BinPacking:      {Default: false, Stage: Alpha},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    map[Feature]bool
+		wantErr bool
+		// wantString is the --feature-gates value of the result
+		wantString string
+	}{
+		{
+			name:  "Defaults",
+			value: "",
+			want:  map[Feature]bool{PlacementAudit: true, PolicyPreflight: false, BinPacking: false},
+		},
+		{
+			name:       "Enable and disable",
+			value:      "PolicyPreflight=true,PlacementAudit=false",
+			want:       map[Feature]bool{PlacementAudit: false, PolicyPreflight: true, BinPacking: false},
+			wantString: "PlacementAudit=false,PolicyPreflight=true",
+		},
+		{
+			name:       "Spaces and empty pairs",
+			value:      " BinPacking = 1 ,, PlacementAudit=true",
+			want:       map[Feature]bool{PlacementAudit: true, PolicyPreflight: false, BinPacking: true},
+			wantString: "BinPacking=true",
+		},
+		{
+			name:    "Unknown gate",
+			value:   "PolicyPreflight=true,TimeTravel=true",
+			want:    map[Feature]bool{PlacementAudit: true, PolicyPreflight: false, BinPacking: false},
+			wantErr: true,
+		},
+		{
+			name:    "Bad boolean",
+			value:   "PolicyPreflight=yes",
+			want:    map[Feature]bool{PlacementAudit: true, PolicyPreflight: false, BinPacking: false},
+			wantErr: true,
+		},
+		{
+			name:    "Missing value",
+			value:   "BinPacking",
+			want:    map[Feature]bool{PlacementAudit: true, PolicyPreflight: false, BinPacking: false},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gates := NewGates(known)
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Var(gates, "feature-gates", "")
+			err := fs.Set("feature-gates", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t, got %v", tt.wantErr, err)
+			}
+			// An invalid list changes no gate, not even its valid pairs
+			for feature, want := range tt.want {
+				if got := gates.Enabled(feature); got != want {
+					t.Errorf("Expected %s enabled = %t, got %t", feature, want, got)
+				}
+			}
+			if got := gates.String(); got != tt.wantString {
+				t.Errorf("Expected %q, got %q", tt.wantString, got)
+			}
+		})
+	}
+
+	if NewGates(known).Enabled("TimeTravel") {
+		t.Error("Expected an unknown feature to be disabled")
+	}
+}
+
+func TestBoolFlag(t *testing.T) {
+	gates := NewGates(map[Feature]FeatureSpec{ImageArchCheck: {Default: false, Stage: Beta}})
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(BoolFlag(gates, ImageArchCheck), "enable-image-arch-check", "")
+
+	if err := fs.Parse([]string{"-enable-image-arch-check"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !gates.Enabled(ImageArchCheck) {
+		t.Error("Expected the bare flag to enable the feature")
+	}
+	if err := fs.Set("enable-image-arch-check", "maybe"); err == nil {
+		t.Error("Expected an error for a bad boolean")
+	}
+	if err := BoolFlag(gates, BinPacking).Set("true"); err == nil {
+		t.Error("Expected an error for an unknown feature")
+	}
+}
+
+func TestDefaultFeaturesAreAlphaOff(t *testing.T) {
+	for feature, spec := range defaultFeatures {
+		if spec.Stage == Alpha && spec.Default {
+			t.Errorf("Expected alpha feature %s to be off by default", feature)
+		}
+		if !DefaultGates.IsKnown(feature) || DefaultGates.Enabled(feature) != spec.Default {
+			t.Errorf("Expected %s at its default %t", feature, spec.Default)
+		}
+	}
+}
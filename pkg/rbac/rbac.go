@@ -0,0 +1,292 @@
+package rbac
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/features"
+)
+
+// DefaultResyncInterval is how often the reconciled ClusterRole is checked for drift
+const DefaultResyncInterval = 10 * time.Minute
+
+// Components describes what the processes sharing the operator's service account run. The
+// webhook and the placement state it shares with the controllers are always covered.
+type Components struct {
+	// Controllers are the enabled controllers: scheduler, rebalance, policy, placementaudit, maintenance,
+	// reservation, policymigration, webhookconfig, janitor, disruptionbudget, weighttuning or spotscores
+	Controllers map[string]bool
+	Gates       *features.Gates
+	// PlacementCleanup is set when the SchedulerController restarts deployments on strategy removal
+	PlacementCleanup bool
+	// ImpersonateServiceAccount moves policy writes, evictions and audit deletions to the tenant service accounts
+	ImpersonateServiceAccount string
+	// ManualOverrides is set when node cordons and manual-override annotations defer automated correction
+	ManualOverrides bool
+	// Janitor is set when the janitor prunes stale placement state, decision annotations and Events
+	Janitor bool
+	// ClusterRoleName is the ClusterRole reconciled by the operator, which it then needs to update
+	ClusterRoleName string
+}
+
+// rule is a permission before rules with the same resources are merged
+type rule struct {
+	group         string
+	resource      string
+	resourceNames []string
+	verbs         []string
+}
+
+var (
+	readOnly     = []string{"get", "list", "watch"}
+	statusWriter = []string{"get", "update", "patch"}
+)
+
+// Rules returns the minimal rules for the components, merged per resource and sorted
+func Rules(c Components) []rbacv1.PolicyRule {
+	rules := []rule{
+		// Placement state, shared by the webhook and the controllers
+		{"", "configmaps", nil, []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+		// Informers of the webhook, the state recounts and the pool health checks
+		{"", "pods", nil, readOnly},
+		{"", "nodes", nil, readOnly},
+		{"apps", "deployments", nil, readOnly},
+		{"apps", "replicasets", nil, readOnly},
+		{"smartscheduler.io", "maintenancewindows", nil, readOnly},
+		// Bases derived from PodDisruptionBudgets (baseFrom=pdb)
+		{"policy", "poddisruptionbudgets", nil, readOnly},
+		// Zonal volumes a pod's rules must be able to mount
+		{"", "persistentvolumeclaims", nil, readOnly},
+		{"", "persistentvolumes", nil, readOnly},
+		{"", "events", nil, []string{"create", "patch"}},
+		// Leader election and placement counter Leases
+		{"coordination.k8s.io", "leases", nil, []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+	}
+	// Writes in tenant namespaces are made as the tenant's service account when impersonating
+	tenantWrites := c.ImpersonateServiceAccount == ""
+
+	if c.Controllers["scheduler"] && c.PlacementCleanup {
+		rules = append(rules, rule{"apps", "deployments", nil, []string{"patch"}})
+	}
+	if c.Controllers["rebalance"] {
+		rules = append(rules, rule{"apps", "deployments", nil, []string{"patch"}})
+		if tenantWrites {
+			rules = append(rules, rule{"", "pods/eviction", nil, []string{"create"}})
+		}
+		if c.Gates.Enabled(features.ScaleDownHints) && tenantWrites {
+			rules = append(rules, rule{"", "pods", nil, []string{"patch"}})
+		}
+	}
+	if c.Gates.Enabled(features.StatefulSetPlacement) {
+		rules = append(rules, rule{"apps", "statefulsets", nil, readOnly})
+		if c.Controllers["rebalance"] {
+			// Strategy rollouts and manual overrides are recorded on the StatefulSet
+			rules = append(rules, rule{"apps", "statefulsets", nil, []string{"patch"}})
+		}
+	}
+	if c.Controllers["policy"] {
+		rules = append(rules,
+			rule{"smartscheduler.io", "podplacementpolicies", nil, readOnly},
+			rule{"smartscheduler.io", "podplacementpolicies/status", nil, statusWriter},
+		)
+		if tenantWrites {
+			rules = append(rules, rule{"apps", "deployments", nil, []string{"update"}})
+		}
+	}
+	if c.Controllers["placementaudit"] && c.Gates.Enabled(features.PlacementAuditRecreate) && tenantWrites {
+		rules = append(rules, rule{"", "pods", nil, []string{"delete"}})
+	}
+	if c.Controllers["placementaudit"] && c.Gates.Enabled(features.PlacementBindAudit) && tenantWrites {
+		// The outcome of the bind audit is recorded on the pod
+		rules = append(rules, rule{"", "pods", nil, []string{"patch"}})
+	}
+	if c.Controllers["reservation"] && c.Gates.Enabled(features.CapacityReservation) && tenantWrites {
+		rules = append(rules, rule{"", "pods", nil, []string{"create", "delete"}})
+	}
+	if c.ManualOverrides && (c.Controllers["rebalance"] || c.Controllers["placementaudit"] && c.Gates.Enabled(features.PlacementAuditRecreate)) {
+		// Cordon events are listed uncached, and the window start is recorded on annotated deployments
+		rules = append(rules,
+			rule{"", "events", nil, []string{"list"}},
+			rule{"apps", "deployments", nil, []string{"patch"}},
+		)
+	}
+	if c.Controllers["policymigration"] && c.Gates.Enabled(features.PolicyMigration) {
+		rules = append(rules, rule{"smartscheduler.io", "podplacementpolicies", nil, readOnly})
+		if tenantWrites {
+			rules = append(rules,
+				rule{"smartscheduler.io", "podplacementpolicies", nil, []string{"create"}},
+				rule{"apps", "deployments", nil, []string{"update"}},
+			)
+		}
+	}
+	if c.Controllers["maintenance"] {
+		rules = append(rules, rule{"smartscheduler.io", "maintenancewindows/status", nil, statusWriter})
+	}
+	if c.Controllers["summary"] {
+		rules = append(rules,
+			rule{"smartscheduler.io", "placementsummaries", nil, []string{"get", "list", "watch", "create"}},
+			rule{"smartscheduler.io", "placementsummaries/status", nil, statusWriter},
+		)
+	}
+	if c.Controllers["webhookconfig"] && c.Gates.Enabled(features.WebhookConfigurationCheck) {
+		// The webhook Service and its endpoints are read uncached in the operator namespace
+		rules = append(rules,
+			rule{"admissionregistration.k8s.io", "mutatingwebhookconfigurations", nil, readOnly},
+			rule{"", "services", nil, []string{"get"}},
+			rule{"discovery.k8s.io", "endpointslices", nil, []string{"list"}},
+		)
+	}
+	if c.Controllers["janitor"] && c.Janitor {
+		// Events are listed uncached, page by page
+		rules = append(rules,
+			rule{"", "events", nil, []string{"list", "delete"}},
+			rule{"apps", "deployments", nil, []string{"patch"}},
+		)
+	}
+	if c.Controllers["weighttuning"] && c.Gates.Enabled(features.WeightTuning) {
+		// The lowered weights are recorded on the deployment
+		rules = append(rules, rule{"apps", "deployments", nil, []string{"patch"}})
+	}
+	if c.Controllers["spotscores"] && c.Gates.Enabled(features.SpotPlacementScores) {
+		// The weights lowered for poorly scored spot pools are recorded on the deployment
+		rules = append(rules, rule{"apps", "deployments", nil, []string{"patch"}})
+	}
+	if c.Controllers["disruptionbudget"] && tenantWrites {
+		rules = append(rules, rule{"policy", "poddisruptionbudgets", nil, []string{"create", "update", "delete"}})
+	}
+	if c.ImpersonateServiceAccount != "" {
+		// The API server adds the service account groups itself, so groups are never impersonated
+		rules = append(rules, rule{"", "serviceaccounts", []string{c.ImpersonateServiceAccount}, []string{"impersonate"}})
+	}
+	if c.Gates.Enabled(features.DecisionOwnership) {
+		rules = append(rules, rule{"discovery.k8s.io", "endpointslices", nil, readOnly})
+	}
+	if c.Gates.Enabled(features.BinPacking) || c.Controllers["reservation"] && c.Gates.Enabled(features.CapacityReservation) {
+		// Pods and placeholders are sized with the overhead of their RuntimeClass
+		rules = append(rules, rule{"node.k8s.io", "runtimeclasses", nil, readOnly})
+	}
+	if c.Gates.Enabled(features.BaseProtection) {
+		// Base pods are given the priority of the deployment's base PriorityClass
+		rules = append(rules, rule{"scheduling.k8s.io", "priorityclasses", nil, readOnly})
+	}
+	if c.ClusterRoleName != "" {
+		rules = append(rules, rule{"rbac.authorization.k8s.io", "clusterroles", []string{c.ClusterRoleName}, []string{"get", "update", "patch"}})
+	}
+	return merge(rules)
+}
+
+// merge combines the verbs of rules for the same resource and sorts rules and verbs
+func merge(rules []rule) []rbacv1.PolicyRule {
+	merged := make(map[string]*rbacv1.PolicyRule)
+	verbs := make(map[string]map[string]bool)
+	for _, r := range rules {
+		id := r.group + "/" + r.resource + "/" + strings.Join(r.resourceNames, ",")
+		if merged[id] == nil {
+			merged[id] = &rbacv1.PolicyRule{APIGroups: []string{r.group}, Resources: []string{r.resource}, ResourceNames: r.resourceNames}
+			verbs[id] = make(map[string]bool)
+		}
+		for _, verb := range r.verbs {
+			verbs[id][verb] = true
+		}
+	}
+
+	ids := make([]string, 0, len(merged))
+	for id := range merged {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	result := make([]rbacv1.PolicyRule, 0, len(ids))
+	for _, id := range ids {
+		policyRule := merged[id]
+		for verb := range verbs[id] {
+			policyRule.Verbs = append(policyRule.Verbs, verb)
+		}
+		sort.Strings(policyRule.Verbs)
+		result = append(result, *policyRule)
+	}
+	return result
+}
+
+// ClusterRole returns a ClusterRole named name granting rules
+func ClusterRole(name string, rules []rbacv1.PolicyRule) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      rules,
+	}
+}
+
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=get;update;patch
+
+// Reconciler keeps the operator's ClusterRole at the rules its enabled components need, so
+// permissions of disabled features are removed and reviewers see the minimal set. It only ever
+// narrows the role it runs with, which the API server allows without the escalate verb.
+// It implements manager.Runnable.
+type Reconciler struct {
+	// Reader reads the ClusterRole without caching every ClusterRole in the cluster
+	Reader client.Reader
+	Client client.Client
+	Name   string
+	Rules  []rbacv1.PolicyRule
+	Log    logr.Logger
+	// Interval is how often the role is checked for drift (default: DefaultResyncInterval)
+	Interval time.Duration
+}
+
+// Start reconciles the ClusterRole until the context is cancelled
+func (r *Reconciler) Start(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultResyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.reconcile(ctx); err != nil && ctx.Err() == nil {
+			r.Log.Error(err, "Failed to reconcile operator ClusterRole", "clusterRole", r.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns true so processes sharing the role do not write it concurrently
+func (r *Reconciler) NeedLeaderElection() bool {
+	return true
+}
+
+// reconcile replaces the role's rules when they differ from the generated ones
+func (r *Reconciler) reconcile(ctx context.Context) error {
+	role := &rbacv1.ClusterRole{}
+	if err := r.Reader.Get(ctx, client.ObjectKey{Name: r.Name}, role); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Log.Info("Operator ClusterRole not found, not reconciling it", "clusterRole", r.Name)
+			return nil
+		}
+		return err
+	}
+	if equality.Semantic.DeepEqual(role.Rules, r.Rules) {
+		return nil
+	}
+
+	role.Rules = r.Rules
+	if err := r.Client.Update(ctx, role); err != nil {
+		return err
+	}
+	r.Log.Info("Reduced operator ClusterRole to the permissions of enabled features", "clusterRole", r.Name, "rules", len(r.Rules))
+	return nil
+}
@@ -0,0 +1,116 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/features"
+)
+
+func TestRules(t *testing.T) {
+	gates := func(enabled ...features.Feature) *features.Gates {
+		g := features.NewGates(map[features.Feature]features.FeatureSpec{
+			features.PlacementAuditRecreate: {Stage: features.Alpha},
+			features.StatefulSetPlacement:   {Stage: features.Alpha},
+			features.ScaleDownHints:         {Stage: features.Alpha},
+		})
+		for _, feature := range enabled {
+			if err := g.SetEnabled(feature, true); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		return g
+	}
+
+	tests := []struct {
+		name       string
+		components Components
+		// want maps group/resource[/resourceNames] to the expected verbs
+		want   map[string]string
+		absent []string
+	}{
+		{
+			name:       "Webhook only",
+			components: Components{Gates: gates()},
+			want: map[string]string{
+				"/configmaps":                "create,delete,get,list,patch,update,watch",
+				"apps/deployments":           "get,list,watch",
+				"coordination.k8s.io/leases": "create,delete,get,list,patch,update,watch",
+			},
+			absent: []string{"/pods/eviction", "apps/statefulsets", "/serviceaccounts", "rbac.authorization.k8s.io/clusterroles"},
+		},
+		{
+			name:       "Rebalancing with scale-down hints",
+			components: Components{Controllers: map[string]bool{"rebalance": true}, Gates: gates(features.ScaleDownHints)},
+			want: map[string]string{
+				"/pods/eviction":   "create",
+				"/pods":            "get,list,patch,watch",
+				"apps/deployments": "get,list,patch,watch",
+			},
+			absent: []string{"apps/statefulsets"},
+		},
+		{
+			name: "Rebalancing as the tenant service account",
+			components: Components{
+				Controllers:               map[string]bool{"rebalance": true, "placementaudit": true},
+				Gates:                     gates(features.ScaleDownHints, features.PlacementAuditRecreate),
+				ImpersonateServiceAccount: "placer",
+			},
+			want: map[string]string{
+				"/serviceaccounts/placer": "impersonate",
+				"/pods":                   "get,list,watch",
+			},
+			absent: []string{"/pods/eviction", "/groups"},
+		},
+		{
+			name:       "Audit recreation and StatefulSets",
+			components: Components{Controllers: map[string]bool{"rebalance": true, "placementaudit": true}, Gates: gates(features.PlacementAuditRecreate, features.StatefulSetPlacement)},
+			want: map[string]string{
+				"/pods":             "delete,get,list,watch",
+				"apps/statefulsets": "get,list,patch,watch",
+			},
+		},
+		{
+			name:       "Reconciled role",
+			components: Components{Gates: gates(), ClusterRoleName: "smart-scheduler"},
+			want: map[string]string{
+				"rbac.authorization.k8s.io/clusterroles/smart-scheduler": "get,patch,update",
+			},
+			absent: []string{"rbac.authorization.k8s.io/clusterroles"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			for _, rule := range Rules(tt.components) {
+				id := ruleID(rule)
+				if _, ok := got[id]; ok {
+					t.Errorf("Expected one merged rule for %s", id)
+				}
+				got[id] = strings.Join(rule.Verbs, ",")
+			}
+			for id, verbs := range tt.want {
+				if got[id] != verbs {
+					t.Errorf("Expected %s to grant %q, got %q", id, verbs, got[id])
+				}
+			}
+			for _, id := range tt.absent {
+				if verbs, ok := got[id]; ok {
+					t.Errorf("Expected no rule for %s, got %q", id, verbs)
+				}
+			}
+		})
+	}
+}
+
+// ruleID returns group/resource[/resourceNames] of a merged rule
+func ruleID(rule rbacv1.PolicyRule) string {
+	id := rule.APIGroups[0] + "/" + rule.Resources[0]
+	if len(rule.ResourceNames) > 0 {
+		id += "/" + strings.Join(rule.ResourceNames, ",")
+	}
+	return id
+}
@@ -0,0 +1,329 @@
+// Package schedulerplugin places pods of managed workloads from inside kube-scheduler, for clusters
+// that run SmartScheduler as a secondary scheduler profile instead of, or next to, the mutating
+// webhook. Rather than setting a pod's nodeSelector at admission, the plugin filters nodes to the
+// pools of the pod's strategy, scores the nodes of the rule the webhook would have applied highest
+// and records the rule of the node the pod is bound to in the same placement state the webhook
+// keeps.
+//
+// Decisions are made here, with the webhook's strategy and state functions, so they can be tested
+// without the scheduler framework. The framework adapter lives in the scheduler module, which
+// depends on k8s.io/kubernetes.
+package schedulerplugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// Name is the name the plugin is registered and configured under in a scheduler profile
+const Name = "SmartScheduler"
+
+// MaxNodeScore is the score of the nodes of a pod's rule; the nodes of its other rules score 0
+const MaxNodeScore = 100
+
+// Placer decides the rule each pod is placed by and records the rule of the node it is bound to
+type Placer struct {
+	Client       client.Client
+	Log          logr.Logger
+	StateManager *webhook.StateManager
+	PoolHealth   *webhook.PoolHealthChecker
+	// Namespaces lists namespaces whose pods are never placed; nil protects system namespaces only
+	Namespaces *webhook.NamespaceGuard
+	// StatefulSets places the pods of StatefulSets with a strategy, by their ordinals
+	StatefulSets bool
+
+	mu sync.Mutex
+	// assumed holds the rules of pods reserved on a node but not yet recorded, keyed by pod UID, so
+	// pods decided while others are still binding see them
+	assumed map[types.UID]assumedPlacement
+}
+
+// assumedPlacement is the rule a reserved pod of a workload is placed on
+type assumedPlacement struct {
+	workload string
+	rule     webhook.RuleKey
+}
+
+// Decision is the placement of one pod: its workload, the workload's strategy and the key of the
+// rule whose nodes it prefers
+type Decision struct {
+	Workload *appsv1.Deployment
+	Strategy *webhook.PlacementStrategy
+	// Annotation is the schedule strategy the pod is placed by, after priority tiers
+	Annotation string
+	Rule       webhook.RuleKey
+}
+
+// Decide returns the placement of the pod, or nil when its workload has no strategy or the webhook
+// already placed it. Pods are placed against the workload's placement state and the pods reserved
+// since, like admissions: failover chains by the health of their pools, StatefulSet pods by their
+// ordinals and the others by the base and weights, holding the workload's on-demand floor.
+func (p *Placer) Decide(ctx context.Context, pod *corev1.Pod) (*Decision, error) {
+	if p.Namespaces.Protected(pod.Namespace) || pod.Annotations["smart-scheduler.io/processed"] != "" {
+		return nil, nil
+	}
+	if _, unsupported := webhook.UnsupportedPodReason(pod); unsupported {
+		return nil, nil
+	}
+
+	kind, name, ok := webhook.ParentWorkload(ctx, p.Client, pod, p.StatefulSets)
+	if !ok {
+		return nil, nil
+	}
+	workload, err := webhook.GetWorkload(ctx, p.Client, kind, types.NamespacedName{Namespace: pod.Namespace, Name: name})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", strings.ToLower(kind), name, err)
+	}
+
+	annotation, exists, err := webhook.ResolveScheduleStrategy(workload.Annotations, pod.Spec.PriorityClassName)
+	if err != nil {
+		p.Log.Error(err, "Ignoring priority strategies, using default schedule strategy", "workload", workload.Name)
+	}
+	if !exists {
+		return nil, nil
+	}
+	if podSelector, err := webhook.StrategyPodSelector(workload); err == nil && !podSelector.Matches(labels.Set(pod.Labels)) {
+		return nil, nil
+	}
+
+	strategy, err := p.strategy(ctx, workload, annotation)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := p.counts(ctx, workload, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	placed := &corev1.Pod{}
+	ordinal, ordered := 0, false
+	if webhook.IsStatefulSet(workload) {
+		ordinal, ordered = webhook.StatefulSetOrdinal(pod, workload.Name)
+	}
+	switch {
+	case strategy.IsFailover():
+		err = webhook.ApplyFailoverStrategy(placed, strategy, p.PoolHealth.HealthFunc(ctx))
+	case ordered:
+		err = webhook.ApplyOrdinalStrategy(placed, strategy, ordinal)
+	default:
+		held := strategy
+		if floor, err := webhook.MinOnDemand(workload); err == nil {
+			held, _ = webhook.HoldMinOnDemand(strategy, counts, floor)
+		}
+		err = webhook.ApplyPlacementStrategy(placed, held, counts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply placement strategy: %w", err)
+	}
+
+	rule, _ := webhook.MatchRuleKey(strategy, placed.Spec.NodeSelector)
+	return &Decision{Workload: workload, Strategy: strategy, Annotation: annotation, Rule: rule}, nil
+}
+
+// strategy parses the workload's strategy with its base resolved and its rules renamed
+func (p *Placer) strategy(ctx context.Context, workload *appsv1.Deployment, annotation string) (*webhook.PlacementStrategy, error) {
+	strategy, err := webhook.ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := webhook.ResolveBase(ctx, p.Client, workload, strategy); err == nil {
+		strategy = resolved
+	} else {
+		p.Log.Error(err, "Failed to derive the base, using the configured base", "workload", workload.Name)
+	}
+	if renamed, err := webhook.WithRuleRenames(workload, strategy); err == nil {
+		strategy = renamed
+	} else {
+		p.Log.Error(err, "Ignoring rule renames", "workload", workload.Name)
+	}
+	return strategy, nil
+}
+
+// counts returns the pods per rule of the workload's placement state with the pods reserved since
+func (p *Placer) counts(ctx context.Context, workload *appsv1.Deployment, strategy *webhook.PlacementStrategy) (map[webhook.RuleKey]int, error) {
+	unlock, err := p.StateManager.LockDeployment(ctx, workload)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := p.StateManager.GetPlacementState(ctx, workload, strategy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get placement state: %w", err)
+	}
+	counts := make(map[webhook.RuleKey]int, len(state.PodCounts))
+	for key, count := range state.PodCounts {
+		counts[key] = count
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, assumed := range p.assumed {
+		if assumed.workload == workloadKey(workload) {
+			counts[assumed.rule]++
+		}
+	}
+	return counts, nil
+}
+
+// Filter reports whether the node belongs to the pool of any of the decision's rules
+func (d *Decision) Filter(node *corev1.Node) bool {
+	for _, rule := range d.Strategy.Rules {
+		if ruleMatches(node, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Score returns MaxNodeScore for the nodes of the decided rule and 0 for the others. Pods whose rule
+// has no room are bound to the nodes of another rule rather than left Pending, and the rebalancer
+// moves them back.
+func (d *Decision) Score(node *corev1.Node) int64 {
+	for _, rule := range d.Strategy.Rules {
+		if rule.Key() == d.Rule && ruleMatches(node, rule) {
+			return MaxNodeScore
+		}
+	}
+	return 0
+}
+
+// NodeRule returns the key of the rule whose pool the node belongs to: the decided rule when it
+// does, else the first rule of the strategy that does
+func (d *Decision) NodeRule(node *corev1.Node) (webhook.RuleKey, bool) {
+	if d.Score(node) == MaxNodeScore {
+		return d.Rule, true
+	}
+	for _, rule := range d.Strategy.Rules {
+		if ruleMatches(node, rule) {
+			return rule.Key(), true
+		}
+	}
+	return "", false
+}
+
+// Assume counts the pod on the rule of the node it is reserved on until Record or Forget
+func (p *Placer) Assume(pod *corev1.Pod, decision *Decision, node *corev1.Node) {
+	rule, ok := decision.NodeRule(node)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.assumed == nil {
+		p.assumed = make(map[types.UID]assumedPlacement)
+	}
+	p.assumed[pod.UID] = assumedPlacement{workload: workloadKey(decision.Workload), rule: rule}
+}
+
+// Forget drops the pod's assumed rule, when its binding failed or it was recorded
+func (p *Placer) Forget(pod *corev1.Pod) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.assumed, pod.UID)
+}
+
+// Record annotates the pod with the rule of the node it is about to be bound to and adds it to the
+// workload's placement state. The pod keeps the nodeSelector of its template; PlacementSelector
+// matches it to its rule by the annotation.
+func (p *Placer) Record(ctx context.Context, pod *corev1.Pod, decision *Decision, node *corev1.Node) error {
+	defer p.Forget(pod)
+
+	rule, ok := decision.NodeRule(node)
+	if !ok {
+		return fmt.Errorf("node %s is in the pool of no rule of the strategy", node.Name)
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[webhook.PlacedRuleAnnotation] = rule.String()
+	pod.Annotations["smart-scheduler.io/strategy-applied"] = decision.Annotation
+	if err := p.Client.Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to annotate pod with its rule: %w", err)
+	}
+
+	unlock, err := p.StateManager.LockDeployment(ctx, decision.Workload)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := p.StateManager.IncrementPodCount(ctx, decision.Workload, decision.Strategy, rule); err != nil {
+		// The state is rebuilt from the pods' annotations when it is next recounted
+		p.Log.Error(err, "Failed to update placement state", "pod", pod.Name, "rule", rule)
+	}
+	p.Log.V(1).Info("Placed pod", "pod", pod.Namespace+"/"+pod.Name, "node", node.Name,
+		"rule", rule, "decidedRule", decision.Rule)
+	return nil
+}
+
+// ruleMatches reports whether the node belongs to the rule's pool: it carries every label of the
+// rule's nodeSelector and satisfies its node affinity
+func ruleMatches(node *corev1.Node, rule webhook.PlacementRule) bool {
+	return rule.NodeLabelSelector().Matches(labels.Set(node.Labels))
+}
+
+// workloadKey identifies a workload among the pods reserved by the placer
+func workloadKey(workload *appsv1.Deployment) string {
+	return webhook.WorkloadKind(workload) + "/" + workload.Namespace + "/" + workload.Name
+}
+
+// NewPlacer returns a placer reading workloads, pods and nodes through a cache of its own, indexed
+// like the webhook's, and started in the background until ctx is done. Placement state is written
+// directly, as the plugin has no flush loop. Pods only reach the plugin through a profile they opt
+// into, so StatefulSets are placed without a feature gate.
+func NewPlacer(ctx context.Context, config *rest.Config, log logr.Logger) (*Placer, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	config = rest.CopyConfig(config)
+	config.Wrap(webhook.Throttle.WrapTransport)
+	c, err := cluster.New(config, func(o *cluster.Options) { o.Scheme = scheme })
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster client: %w", err)
+	}
+	if err := webhook.SetupIndexers(ctx, c.GetFieldIndexer()); err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := c.Start(ctx); err != nil {
+			log.Error(err, "Cluster cache stopped")
+		}
+	}()
+	if !c.GetCache().WaitForCacheSync(ctx) {
+		return nil, fmt.Errorf("failed to sync the cluster cache")
+	}
+
+	namespaces, err := webhook.NewNamespaceGuard(webhook.OperatorNamespace(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Placer{
+		Client:       c.GetClient(),
+		Log:          log,
+		StateManager: webhook.NewStateManager(c.GetClient(), log.WithName("StateManager")),
+		PoolHealth:   webhook.NewPoolHealthChecker(c.GetClient(), log.WithName("PoolHealth")),
+		Namespaces:   namespaces,
+		StatefulSets: true,
+	}, nil
+}
@@ -0,0 +1,288 @@
+package schedulerplugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+const testStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// builderIndexer registers the webhook's field indexes on a fake client builder
+type builderIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (b builderIndexer) IndexField(_ context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	b.builder.WithIndex(obj, field, extract)
+	return nil
+}
+
+func testNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// newTestPlacer returns a placer over a fake client holding the nodes and a deployment "web" with
+// the strategy, whose existing pods carry the nodeSelectors, and a pending pod of the deployment
+func newTestPlacer(t *testing.T, strategy string, nodes []*corev1.Node, existing ...map[string]string) (*Placer, *corev1.Pod) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("deployment-uid")},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	if strategy != "" {
+		deployment.Annotations = map[string]string{webhook.ScheduleStrategyAnnotation: strategy}
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc",
+			Namespace: "default",
+			UID:       types.UID("rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: int32(len(existing))},
+	}
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc-pending",
+			Namespace: "default",
+			UID:       types.UID("pending-uid"),
+			Labels:    map[string]string{"app": "web"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+			}},
+		},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}}},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+
+	objects := []client.Object{deployment, rs, pending}
+	for _, node := range nodes {
+		objects = append(objects, node)
+	}
+	for i, selector := range existing {
+		pod := pending.DeepCopy()
+		pod.Name = fmt.Sprintf("web-abc-%d", i)
+		pod.UID = types.UID(pod.Name)
+		pod.Spec.NodeSelector = selector
+		pod.Status.Phase = corev1.PodRunning
+		objects = append(objects, pod)
+	}
+
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...)
+	if err := webhook.SetupIndexers(context.Background(), builderIndexer{builder}); err != nil {
+		t.Fatal(err)
+	}
+	c := builder.Build()
+	log := logr.Discard()
+	return &Placer{
+		Client:       c,
+		Log:          log,
+		StateManager: webhook.NewStateManager(c, log),
+		PoolHealth:   webhook.NewPoolHealthChecker(c, log),
+	}, pending
+}
+
+func TestPlacerDecide(t *testing.T) {
+	onDemand := map[string]string{"node-type": "ondemand"}
+	spot := map[string]string{"node-type": "spot"}
+	pools := []*corev1.Node{testNode("ondemand-0", onDemand), testNode("spot-0", spot)}
+
+	tests := []struct {
+		name     string
+		strategy string
+		nodes    []*corev1.Node
+		existing []map[string]string
+		// annotations are set on the pending pod
+		annotations map[string]string
+		// want is the decided rule, or "" when the pod is left to the scheduler
+		want webhook.RuleKey
+	}{
+		{
+			name:     "First pod fills the base",
+			strategy: testStrategy,
+			nodes:    pools,
+			want:     "node-type=ondemand",
+		},
+		{
+			name:     "Weighted once the base is filled",
+			strategy: testStrategy,
+			nodes:    pools,
+			existing: []map[string]string{onDemand},
+			want:     "node-type=spot",
+		},
+		{
+			name:     "Weights follow the placement state",
+			strategy: testStrategy,
+			nodes:    pools,
+			existing: []map[string]string{onDemand, spot, spot, spot},
+			want:     "node-type=ondemand",
+		},
+		{
+			name:     "Failover to the first healthy pool",
+			strategy: "mode=failover,nodeSelector=zone:a;nodeSelector=zone:b",
+			nodes:    []*corev1.Node{testNode("b-0", map[string]string{"zone": "b"})},
+			want:     "zone=b",
+		},
+		{
+			name:     "Failover keeps the first pool while healthy",
+			strategy: "mode=failover,nodeSelector=zone:a;nodeSelector=zone:b",
+			nodes:    []*corev1.Node{testNode("a-0", map[string]string{"zone": "a"}), testNode("b-0", map[string]string{"zone": "b"})},
+			want:     "zone=a",
+		},
+		{
+			name:  "Workload without a strategy",
+			nodes: pools,
+		},
+		{
+			name:        "Pod the webhook already placed",
+			strategy:    testStrategy,
+			nodes:       pools,
+			annotations: map[string]string{"smart-scheduler.io/processed": "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placer, pod := newTestPlacer(t, tt.strategy, tt.nodes, tt.existing...)
+			pod.Annotations = tt.annotations
+			decision, err := placer.Decide(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("Decide() error = %v", err)
+			}
+			if tt.want == "" {
+				if decision != nil {
+					t.Errorf("Expected the pod left to the scheduler, got rule %q", decision.Rule)
+				}
+				return
+			}
+			if decision == nil {
+				t.Fatalf("Expected rule %q, got no decision", tt.want)
+			}
+			if decision.Rule != tt.want {
+				t.Errorf("Rule = %q, want %q", decision.Rule, tt.want)
+			}
+			if decision.Annotation != tt.strategy {
+				t.Errorf("Annotation = %q, want %q", decision.Annotation, tt.strategy)
+			}
+		})
+	}
+}
+
+func TestDecisionFilterAndScore(t *testing.T) {
+	strategy, err := webhook.ParsePlacementStrategy(testStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision := &Decision{Strategy: strategy, Rule: "node-type=ondemand"}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		filter   bool
+		score    int64
+		nodeRule webhook.RuleKey
+	}{
+		{"Node of the decided rule", map[string]string{"node-type": "ondemand", "zone": "a"}, true, MaxNodeScore, "node-type=ondemand"},
+		{"Node of another rule", map[string]string{"node-type": "spot"}, true, 0, "node-type=spot"},
+		{"Node of no rule", map[string]string{"node-type": "gpu"}, false, 0, ""},
+		{"Unlabeled node", nil, false, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := testNode("node", tt.labels)
+			if got := decision.Filter(node); got != tt.filter {
+				t.Errorf("Filter() = %v, want %v", got, tt.filter)
+			}
+			if got := decision.Score(node); got != tt.score {
+				t.Errorf("Score() = %d, want %d", got, tt.score)
+			}
+			rule, ok := decision.NodeRule(node)
+			if rule != tt.nodeRule || ok != (tt.nodeRule != "") {
+				t.Errorf("NodeRule() = %q, %v, want %q", rule, ok, tt.nodeRule)
+			}
+		})
+	}
+}
+
+func TestPlacerRecord(t *testing.T) {
+	onDemand := testNode("ondemand-0", map[string]string{"node-type": "ondemand"})
+	spot := testNode("spot-0", map[string]string{"node-type": "spot"})
+	gpu := testNode("gpu-0", map[string]string{"node-type": "gpu"})
+	placer, pod := newTestPlacer(t, testStrategy, []*corev1.Node{onDemand, spot, gpu})
+	ctx := context.Background()
+
+	decision, err := placer.Decide(ctx, pod)
+	if err != nil || decision == nil {
+		t.Fatalf("Decide() = %v, %v", decision, err)
+	}
+	if decision.Rule != "node-type=ondemand" {
+		t.Fatalf("Rule = %q, want the base rule", decision.Rule)
+	}
+
+	// A pod reserved on the base pool counts toward it for the pods decided meanwhile
+	placer.Assume(pod, decision, onDemand)
+	next := pod.DeepCopy()
+	next.Name, next.UID = "web-abc-next", "next-uid"
+	if nextDecision, err := placer.Decide(ctx, next); err != nil || nextDecision.Rule != "node-type=spot" {
+		t.Errorf("Expected the next pod weighted onto spot while the first is reserved, got %+v, %v", nextDecision, err)
+	}
+
+	// A node outside every pool is refused, and the reservation dropped
+	if err := placer.Record(ctx, pod, decision, gpu); err == nil {
+		t.Error("Expected an error recording a pod bound outside the strategy's pools")
+	}
+	if nextDecision, _ := placer.Decide(ctx, next); nextDecision.Rule != "node-type=ondemand" {
+		t.Errorf("Expected the reservation forgotten, next pod decided %q", nextDecision.Rule)
+	}
+
+	// A pod bound to another rule's pool than decided is recorded on the rule of its node
+	if err := placer.Record(ctx, pod, decision, spot); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	recorded := &corev1.Pod{}
+	if err := placer.Client.Get(ctx, client.ObjectKeyFromObject(pod), recorded); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorded.Annotations[webhook.PlacedRuleAnnotation]; got != "node-type=spot" {
+		t.Errorf("Expected the pod annotated with the spot rule, got %q", got)
+	}
+	if got := recorded.Annotations["smart-scheduler.io/strategy-applied"]; got != testStrategy {
+		t.Errorf("Expected the applied strategy recorded, got %q", got)
+	}
+	state, err := placer.StateManager.ReadPlacementState(ctx, "default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.PodCounts["node-type=spot"] != 1 || state.PodCounts["node-type=ondemand"] != 0 {
+		t.Errorf("placement state counts = %v, want the pod on spot", state.PodCounts)
+	}
+}
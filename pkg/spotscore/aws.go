@@ -0,0 +1,284 @@
+package spotscore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ec2APIVersion = "2016-11-15"
+	stsAPIVersion = "2011-06-15"
+	// credentialRefreshMargin is how long before they expire temporary credentials are renewed
+	credentialRefreshMargin = 5 * time.Minute
+)
+
+// awsCredentials are the keys requests are signed with; temporary ones expire
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	expires         time.Time
+}
+
+// awsProvider scores pools with the EC2 GetSpotPlacementScores API, which scores the instance
+// types of a pool together, as one diversified request for its capacity. Credentials come from the
+// environment: static keys (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN), EKS
+// Pod Identity (AWS_CONTAINER_CREDENTIALS_FULL_URI) or IAM roles for service accounts (AWS_ROLE_ARN
+// and AWS_WEB_IDENTITY_TOKEN_FILE). The role needs ec2:GetSpotPlacementScores.
+type awsProvider struct {
+	client *http.Client
+	getenv func(string) string
+	now    func() time.Time
+
+	mu          sync.Mutex
+	credentials *awsCredentials
+}
+
+// Scores scores each pool with a region and instance types in its region
+func (p *awsProvider) Scores(ctx context.Context, pools []Pool) (map[string]int, error) {
+	scores := make(map[string]int, len(pools))
+	var errs []error
+	for _, pool := range pools {
+		if pool.Region == "" || len(pool.InstanceTypes) == 0 {
+			continue
+		}
+		score, ok, err := p.score(ctx, pool)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pool.Key, err))
+			continue
+		}
+		if ok {
+			scores[pool.Key] = score
+		}
+	}
+	return scores, errors.Join(errs...)
+}
+
+// score fetches the regional score of the pool's instance types for its node count
+func (p *awsProvider) score(ctx context.Context, pool Pool) (int, bool, error) {
+	form := url.Values{
+		"Action":         {"GetSpotPlacementScores"},
+		"Version":        {ec2APIVersion},
+		"TargetCapacity": {strconv.Itoa(max(pool.Nodes, 1))},
+		"RegionName.1":   {pool.Region},
+	}
+	for i, instanceType := range pool.InstanceTypes {
+		form.Set(fmt.Sprintf("InstanceType.%d", i+1), instanceType)
+	}
+	body := []byte(form.Encode())
+
+	credentials, err := p.resolveCredentials(ctx, pool.Region)
+	if err != nil {
+		return 0, false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://ec2."+pool.Region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, credentials, pool.Region, "ec2", p.now())
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return 0, false, err
+	}
+	var response struct {
+		Scores []struct {
+			Region string `xml:"regionName"`
+			Score  int    `xml:"score"`
+		} `xml:"spotPlacementScoreSet>item"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return 0, false, fmt.Errorf("failed to decode spot placement scores: %w", err)
+	}
+	for _, score := range response.Scores {
+		if score.Region == pool.Region && score.Score > 0 {
+			return score.Score, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// resolveCredentials returns the credentials from the environment, reusing temporary credentials
+// until shortly before they expire
+func (p *awsProvider) resolveCredentials(ctx context.Context, region string) (*awsCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.credentials; c != nil && (c.expires.IsZero() || p.now().Before(c.expires.Add(-credentialRefreshMargin))) {
+		return c, nil
+	}
+
+	var credentials *awsCredentials
+	var err error
+	switch {
+	case p.getenv("AWS_ACCESS_KEY_ID") != "":
+		credentials = &awsCredentials{
+			accessKeyID:     p.getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: p.getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    p.getenv("AWS_SESSION_TOKEN"),
+		}
+	case p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		credentials, err = p.podIdentityCredentials(ctx)
+	case p.getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+		credentials, err = p.webIdentityCredentials(ctx, region)
+	default:
+		err = errors.New("no AWS credentials found: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or use EKS Pod Identity or IAM roles for service accounts")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.credentials = credentials
+	return credentials, nil
+}
+
+// podIdentityCredentials fetches temporary credentials from the EKS Pod Identity agent
+func (p *awsProvider) podIdentityCredentials(ctx context.Context) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), nil)
+	if err != nil {
+		return nil, err
+	}
+	token := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Pod Identity token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Pod Identity credentials: %w", err)
+	}
+	var response struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode Pod Identity credentials: %w", err)
+	}
+	return &awsCredentials{
+		accessKeyID:     response.AccessKeyID,
+		secretAccessKey: response.SecretAccessKey,
+		sessionToken:    response.Token,
+		expires:         response.Expiration,
+	}, nil
+}
+
+// webIdentityCredentials assumes AWS_ROLE_ARN with the service account token, as IAM roles for
+// service accounts do. The regional STS endpoint of AWS_REGION, or else of the pool, is used.
+func (p *awsProvider) webIdentityCredentials(ctx context.Context, region string) (*awsCredentials, error) {
+	token, err := os.ReadFile(p.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the web identity token: %w", err)
+	}
+	if envRegion := p.getenv("AWS_REGION"); envRegion != "" {
+		region = envRegion
+	}
+	sessionName := p.getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "smart-scheduler"
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {stsAPIVersion},
+		"RoleArn":          {p.getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://sts."+region+".amazonaws.com/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role %s: %w", p.getenv("AWS_ROLE_ARN"), err)
+	}
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode assumed role credentials: %w", err)
+	}
+	return &awsCredentials{
+		accessKeyID:     response.Credentials.AccessKeyID,
+		secretAccessKey: response.Credentials.SecretAccessKey,
+		sessionToken:    response.Credentials.SessionToken,
+		expires:         response.Credentials.Expiration,
+	}, nil
+}
+
+// signV4 signs a request without a query string with AWS Signature Version 4
+func signV4(req *http.Request, body []byte, credentials *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, "", canonicalHeaders.String(), signedHeaders, sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,221 @@
+package spotscore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSignV4 checks the signature against the post-x-www-form-urlencoded vectors of the AWS
+// Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	credentials := &awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signedAt := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		contentType string
+		signature   string
+	}{
+		{"post-x-www-form-urlencoded", "application/x-www-form-urlencoded", "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+		{"post-x-www-form-urlencoded-parameters", "application/x-www-form-urlencoded; charset=utf8", "1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte("Param1=value1")
+			req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			signV4(req, body, credentials, "us-east-1", "service", signedAt)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+		})
+	}
+
+	// Temporary credentials sign their session token too
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := *credentials
+	session.sessionToken = "token"
+	signV4(req, nil, &session, "us-east-1", "service", signedAt)
+	if req.Header.Get("X-Amz-Security-Token") != "token" ||
+		!strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("Expected the session token sent and signed, got %v", req.Header)
+	}
+}
+
+func TestAWSProviderScores(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Host != "ec2."+r.PostForm.Get("RegionName.1")+".amazonaws.com" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			http.Error(w, "unsigned request to "+r.Host, http.StatusForbidden)
+			return
+		}
+		if r.PostForm.Get("Action") != "GetSpotPlacementScores" || r.PostForm.Get("InstanceType.2") != "m5.xlarge" {
+			http.Error(w, "unexpected request "+r.PostForm.Encode(), http.StatusBadRequest)
+			return
+		}
+		switch r.PostForm.Get("RegionName.1") {
+		case "us-east-1":
+			_, _ = w.Write([]byte(`<GetSpotPlacementScoresResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <spotPlacementScoreSet>
+    <item><regionName>us-west-2</regionName><score>9</score></item>
+    <item><regionName>us-east-1</regionName><score>` + r.PostForm.Get("TargetCapacity") + `</score></item>
+  </spotPlacementScoreSet>
+</GetSpotPlacementScoresResponse>`))
+		case "eu-west-1":
+			_, _ = w.Write([]byte(`<GetSpotPlacementScoresResponse><spotPlacementScoreSet/></GetSpotPlacementScoresResponse>`))
+		default:
+			http.Error(w, "<Response><Errors><Error><Code>UnauthorizedOperation</Code></Error></Errors></Response>", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	provider := &awsProvider{
+		client: redirect(server),
+		getenv: env(map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret"}),
+		now:    time.Now,
+	}
+	types := []string{"m5.large", "m5.xlarge"}
+	scores, err := provider.Scores(context.Background(), []Pool{
+		{Key: "spot-east", Region: "us-east-1", InstanceTypes: types, Nodes: 4},
+		{Key: "spot-eu", Region: "eu-west-1", InstanceTypes: types},
+		{Key: "spot-denied", Region: "ap-south-1", InstanceTypes: types},
+		{Key: "spot-unlabeled", InstanceTypes: types},
+	})
+	if err == nil || !strings.Contains(err.Error(), "pool spot-denied") || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected the refused pool reported, got %v", err)
+	}
+	if len(scores) != 1 || scores["spot-east"] != 4 {
+		t.Errorf("Scores() = %v, want spot-east scored 4 by its own region", scores)
+	}
+}
+
+func TestAWSPodIdentityCredentialRefresh(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("pod-identity-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clock := &clock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "pod-identity-token" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"AccessKeyId":"ASIA%d","SecretAccessKey":"secret","Token":"session","Expiration":%q}`,
+			fetches.Add(1), clock.now.Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	provider := &awsProvider{
+		client: server.Client(),
+		getenv: env(map[string]string{
+			"AWS_CONTAINER_CREDENTIALS_FULL_URI":     server.URL + "/v1/credentials",
+			"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": tokenFile,
+		}),
+		now: clock.Now,
+	}
+	resolve := func() *awsCredentials {
+		t.Helper()
+		credentials, err := provider.resolveCredentials(context.Background(), "us-east-1")
+		if err != nil {
+			t.Fatalf("resolveCredentials() error = %v", err)
+		}
+		return credentials
+	}
+
+	first := resolve()
+	if first.accessKeyID != "ASIA1" || first.sessionToken != "session" || !first.expires.Equal(clock.now.Add(time.Hour)) {
+		t.Errorf("Expected the Pod Identity credentials, got %+v", first)
+	}
+
+	// Credentials are reused until the refresh margin before they expire
+	clock.now = clock.now.Add(time.Hour - credentialRefreshMargin - time.Second)
+	if resolve() != first || fetches.Load() != 1 {
+		t.Errorf("Expected the credentials reused outside the refresh margin, %d fetches", fetches.Load())
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+	if second := resolve(); second.accessKeyID != "ASIA2" || fetches.Load() != 2 {
+		t.Errorf("Expected the credentials renewed within the refresh margin, got %s after %d fetches", second.accessKeyID, fetches.Load())
+	}
+}
+
+func TestAWSWebIdentityCredentials(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("service-account-jwt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Host != "sts.eu-central-1.amazonaws.com" || query.Get("Action") != "AssumeRoleWithWebIdentity" ||
+			query.Get("RoleArn") != "arn:aws:iam::123456789012:role/placer" ||
+			query.Get("WebIdentityToken") != "service-account-jwt" || query.Get("RoleSessionName") != "smart-scheduler" {
+			http.Error(w, "unexpected request to "+r.Host+": "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAWEB</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>2026-01-01T13:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer server.Close()
+
+	provider := &awsProvider{
+		client: redirect(server),
+		getenv: env(map[string]string{
+			"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/placer",
+			"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+			"AWS_REGION":                  "eu-central-1",
+		}),
+		now: time.Now,
+	}
+	credentials, err := provider.resolveCredentials(context.Background(), "us-east-1")
+	if err != nil {
+		t.Fatalf("resolveCredentials() error = %v", err)
+	}
+	want := awsCredentials{
+		accessKeyID:     "ASIAWEB",
+		secretAccessKey: "secret",
+		sessionToken:    "session",
+		expires:         time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC),
+	}
+	if *credentials != want {
+		t.Errorf("credentials = %+v, want %+v", *credentials, want)
+	}
+
+	// Without any source of credentials, scoring fails before calling EC2
+	provider = &awsProvider{client: redirect(server), getenv: env(nil), now: time.Now}
+	if _, err := provider.resolveCredentials(context.Background(), "us-east-1"); err == nil || !strings.Contains(err.Error(), "no AWS credentials found") {
+		t.Errorf("Expected missing credentials reported, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package spotscore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	azureSpotScoreAPIVersion = "2025-06-05"
+	azureManagementScope     = "https://management.azure.com/.default"
+	azureDefaultAuthority    = "https://login.microsoftonline.com/"
+)
+
+// azureScoreLevels maps the placement score levels of Azure onto the 1-10 scale. Levels meaning
+// the size has no data or is restricted give no score.
+var azureScoreLevels = map[string]int{
+	"High":   10,
+	"Medium": 6,
+	"Low":    3,
+}
+
+// azureProvider scores pools with the Azure Spot Placement Score API, which rates each VM size of a
+// region High, Medium or Low; a pool scores as its best size. It authenticates with Microsoft Entra
+// Workload ID, through the AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE the
+// workload identity webhook sets, and AZURE_SUBSCRIPTION_ID names the subscription. The identity
+// needs Microsoft.Compute/locations/placementScores/generate/action.
+type azureProvider struct {
+	client *http.Client
+	getenv func(string) string
+	now    func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Scores scores each pool with a region and VM sizes
+func (p *azureProvider) Scores(ctx context.Context, pools []Pool) (map[string]int, error) {
+	subscription := p.getenv("AZURE_SUBSCRIPTION_ID")
+	if subscription == "" {
+		return nil, errors.New("AZURE_SUBSCRIPTION_ID is not set")
+	}
+	scores := make(map[string]int, len(pools))
+	var errs []error
+	for _, pool := range pools {
+		if pool.Region == "" || len(pool.InstanceTypes) == 0 {
+			continue
+		}
+		score, ok, err := p.score(ctx, subscription, pool)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pool.Key, err))
+			continue
+		}
+		if ok {
+			scores[pool.Key] = score
+		}
+	}
+	return scores, errors.Join(errs...)
+}
+
+// score fetches the scores of the pool's VM sizes in its region for its node count
+func (p *azureProvider) score(ctx context.Context, subscription string, pool Pool) (int, bool, error) {
+	type desiredSize struct {
+		SKU string `json:"sku"`
+	}
+	request := struct {
+		DesiredLocations  []string      `json:"desiredLocations"`
+		DesiredSizes      []desiredSize `json:"desiredSizes"`
+		DesiredCount      int           `json:"desiredCount"`
+		AvailabilityZones bool          `json:"availabilityZones"`
+	}{
+		DesiredLocations: []string{pool.Region},
+		DesiredCount:     max(pool.Nodes, 1),
+	}
+	for _, instanceType := range pool.InstanceTypes {
+		request.DesiredSizes = append(request.DesiredSizes, desiredSize{SKU: instanceType})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, false, err
+	}
+
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	endpoint := fmt.Sprintf("https://management.azure.com/subscriptions/%s/providers/Microsoft.Compute/locations/%s/placementScores/spot/generate?api-version=%s",
+		url.PathEscape(subscription), url.PathEscape(pool.Region), azureSpotScoreAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return 0, false, err
+	}
+	var response struct {
+		PlacementScores []struct {
+			SKU   string `json:"sku"`
+			Score string `json:"score"`
+		} `json:"placementScores"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, false, fmt.Errorf("failed to decode spot placement scores: %w", err)
+	}
+	best := 0
+	for _, score := range response.PlacementScores {
+		best = max(best, azureScoreLevels[score.Score])
+	}
+	return best, best > 0, nil
+}
+
+// accessToken returns a token for the Azure Resource Manager, exchanging the federated service
+// account token again shortly before the last one expires
+func (p *azureProvider) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && p.now().Before(p.expires.Add(-credentialRefreshMargin)) {
+		return p.token, nil
+	}
+
+	tenant, clientID, tokenFile := p.getenv("AZURE_TENANT_ID"), p.getenv("AZURE_CLIENT_ID"), p.getenv("AZURE_FEDERATED_TOKEN_FILE")
+	if tenant == "" || clientID == "" || tokenFile == "" {
+		return "", errors.New("no Azure workload identity found: AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE must be set")
+	}
+	assertion, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the federated token: %w", err)
+	}
+	authority := p.getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = azureDefaultAuthority
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"scope":                 {azureManagementScope},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	endpoint := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get an Azure access token: %w", err)
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to decode the Azure access token: %w", err)
+	}
+	p.token = response.AccessToken
+	p.expires = p.now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return p.token, nil
+}
@@ -0,0 +1,107 @@
+package spotscore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAzureProviderScores(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clock := &clock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	var exchanges atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host == "login.example" && r.URL.Path == "/tenant-a/oauth2/v2.0/token":
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("client_assertion") != "federated-jwt" ||
+				r.PostForm.Get("client_id") != "client-a" || r.PostForm.Get("scope") != azureManagementScope {
+				http.Error(w, "invalid_client", http.StatusUnauthorized)
+				return
+			}
+			exchanges.Add(1)
+			_, _ = w.Write([]byte(`{"access_token":"arm-token","expires_in":3600}`))
+		case r.Host == "management.azure.com":
+			if r.Header.Get("Authorization") != "Bearer arm-token" || r.URL.Query().Get("api-version") != azureSpotScoreAPIVersion {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			var request struct {
+				DesiredLocations []string `json:"desiredLocations"`
+				DesiredCount     int      `json:"desiredCount"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.DesiredCount != 3 {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			if !strings.HasSuffix(r.URL.Path, "/subscriptions/sub-a/providers/Microsoft.Compute/locations/"+request.DesiredLocations[0]+"/placementScores/spot/generate") {
+				http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+				return
+			}
+			switch request.DesiredLocations[0] {
+			case "eastus":
+				_, _ = w.Write([]byte(`{"placementScores":[{"sku":"Standard_D2s_v5","score":"Low"},{"sku":"Standard_D4s_v5","score":"Medium"}]}`))
+			default:
+				_, _ = w.Write([]byte(`{"placementScores":[{"sku":"Standard_D2s_v5","score":"DataNotFoundOrStale"}]}`))
+			}
+		default:
+			http.Error(w, "unexpected host "+r.Host, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := &azureProvider{
+		client: redirect(server),
+		getenv: env(map[string]string{
+			"AZURE_SUBSCRIPTION_ID":      "sub-a",
+			"AZURE_TENANT_ID":            "tenant-a",
+			"AZURE_CLIENT_ID":            "client-a",
+			"AZURE_FEDERATED_TOKEN_FILE": tokenFile,
+			"AZURE_AUTHORITY_HOST":       "https://login.example/",
+		}),
+		now: clock.Now,
+	}
+	pools := []Pool{
+		{Key: "spot-east", Region: "eastus", InstanceTypes: []string{"Standard_D2s_v5", "Standard_D4s_v5"}, Nodes: 3},
+		{Key: "spot-west", Region: "westus", InstanceTypes: []string{"Standard_D2s_v5"}, Nodes: 3},
+	}
+
+	// A pool scores as its best size; sizes without data give no score
+	scores, err := provider.Scores(context.Background(), pools)
+	if err != nil {
+		t.Fatalf("Scores() error = %v", err)
+	}
+	if len(scores) != 1 || scores["spot-east"] != 6 {
+		t.Errorf("Scores() = %v, want spot-east scored 6 (Medium)", scores)
+	}
+
+	// The token is exchanged again only within the refresh margin before it expires
+	clock.now = clock.now.Add(time.Hour - credentialRefreshMargin - time.Second)
+	if _, err := provider.Scores(context.Background(), pools); err != nil || exchanges.Load() != 1 {
+		t.Errorf("Expected the token reused, %d exchanges, error %v", exchanges.Load(), err)
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+	if _, err := provider.Scores(context.Background(), pools); err != nil || exchanges.Load() != 2 {
+		t.Errorf("Expected the token renewed, %d exchanges, error %v", exchanges.Load(), err)
+	}
+
+	// A missing subscription or identity fails before calling Azure
+	provider.getenv = env(map[string]string{"AZURE_SUBSCRIPTION_ID": "sub-a"})
+	provider.token = ""
+	if _, err := provider.Scores(context.Background(), pools); err == nil || !strings.Contains(err.Error(), "no Azure workload identity found") {
+		t.Errorf("Expected the missing identity reported, got %v", err)
+	}
+	provider.getenv = env(nil)
+	if _, err := provider.Scores(context.Background(), pools); err == nil || !strings.Contains(err.Error(), "AZURE_SUBSCRIPTION_ID") {
+		t.Errorf("Expected the missing subscription reported, got %v", err)
+	}
+}
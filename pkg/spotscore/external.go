@@ -0,0 +1,59 @@
+package spotscore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ScoreRequest is the body the external provider posts to its endpoint
+type ScoreRequest struct {
+	Pools []Pool `json:"pools"`
+}
+
+// ScoreResponse is the answer of the external provider's endpoint: the score of each pool from 1
+// to 10, by pool key. Pools left out, and scores outside 1-10, are not scored.
+type ScoreResponse struct {
+	Scores map[string]int `json:"scores"`
+}
+
+// externalProvider posts the pools to an HTTP endpoint scoring them. It serves clouds without a
+// placement score API, such as GCP, from signals like recent preemption rates.
+type externalProvider struct {
+	client   *http.Client
+	endpoint string
+}
+
+// Scores posts every pool in one request
+func (p *externalProvider) Scores(ctx context.Context, pools []Pool) (map[string]int, error) {
+	if len(pools) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(&ScoreRequest{Pools: pools})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	data, err := do(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+	response := &ScoreResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("failed to decode spot placement scores: %w", err)
+	}
+	scores := make(map[string]int, len(response.Scores))
+	for key, score := range response.Scores {
+		if score >= 1 && score <= 10 {
+			scores[key] = score
+		}
+	}
+	return scores, nil
+}
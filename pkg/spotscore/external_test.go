@@ -0,0 +1,58 @@
+package spotscore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExternalProviderScores(t *testing.T) {
+	var received ScoreRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(received.Pools) == 1 {
+			http.Error(w, "scoring backend unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"scores":{"spot-a":7,"spot-b":0,"spot-c":11,"spot-d":1}}`))
+	}))
+	defer server.Close()
+
+	provider, err := New("external", Options{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := []Pool{
+		{Key: "spot-a", Region: "europe-west1", Zones: []string{"europe-west1-b"}, InstanceTypes: []string{"n2-standard-4"}, Nodes: 2},
+		{Key: "spot-b", Region: "europe-west1", InstanceTypes: []string{"n2-standard-8"}, Nodes: 1},
+	}
+
+	// Scores outside 1-10 are dropped
+	scores, err := provider.Scores(context.Background(), pools)
+	if err != nil {
+		t.Fatalf("Scores() error = %v", err)
+	}
+	if len(scores) != 2 || scores["spot-a"] != 7 || scores["spot-d"] != 1 {
+		t.Errorf("Scores() = %v, want spot-a 7 and spot-d 1", scores)
+	}
+	if len(received.Pools) != 2 || received.Pools[0].Zones[0] != "europe-west1-b" || received.Pools[1].Nodes != 1 {
+		t.Errorf("Expected every pool posted, got %+v", received.Pools)
+	}
+
+	if _, err := provider.Scores(context.Background(), pools[:1]); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the endpoint's status reported, got %v", err)
+	}
+	if scores, err := provider.Scores(context.Background(), nil); err != nil || scores != nil {
+		t.Errorf("Expected no request without pools, got %v, %v", scores, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package spotscore fetches spot placement scores: how likely a cloud provider is to fulfill a
+// request for spot capacity in a pool of instance types, from 1 (unlikely) to 10 (very likely).
+package spotscore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Providers are the names of the supported providers
+var Providers = []string{"aws", "azure", "external"}
+
+// defaultRequestTimeout bounds every call to a provider's API
+const defaultRequestTimeout = 30 * time.Second
+
+// Pool is the spot capacity a placement rule selects, described by the nodes it selects now
+type Pool struct {
+	// Key identifies the pool, the key of the rule selecting it
+	Key string `json:"key"`
+	// Region is the cloud region of the pool's nodes
+	Region string `json:"region"`
+	// Zones are the zones of the pool's nodes
+	Zones []string `json:"zones,omitempty"`
+	// InstanceTypes are the instance types of the pool's nodes
+	InstanceTypes []string `json:"instanceTypes"`
+	// Nodes is the number of nodes in the pool, the capacity that is scored
+	Nodes int `json:"nodes"`
+}
+
+// Provider scores spot pools
+type Provider interface {
+	// Scores returns the score of each pool from 1 to 10, by pool key. Pools the provider has no
+	// score for are left out. Scores fetched before an error are returned with it.
+	Scores(ctx context.Context, pools []Pool) (map[string]int, error)
+}
+
+// Options configures a provider
+type Options struct {
+	// Endpoint is the URL the external provider posts pools to
+	Endpoint string
+	// Timeout bounds every call to the provider's API (default: 30s)
+	Timeout time.Duration
+}
+
+// New returns the named provider: aws, azure or external
+func New(name string, options Options) (Provider, error) {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch name {
+	case "aws":
+		return &awsProvider{client: client, getenv: os.Getenv, now: time.Now}, nil
+	case "azure":
+		return &azureProvider{client: client, getenv: os.Getenv, now: time.Now}, nil
+	case "external":
+		if options.Endpoint == "" {
+			return nil, fmt.Errorf("the external spot score provider needs an endpoint")
+		}
+		return &externalProvider{client: client, endpoint: options.Endpoint}, nil
+	default:
+		return nil, fmt.Errorf("unknown spot score provider %q, expected one of %s", name, strings.Join(Providers, ", "))
+	}
+}
+
+// do sends the request and returns the response body, or an error naming the status and the start
+// of the body when the status is not 200
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s answered %s: %s", req.Method, req.URL.Host, resp.Status, truncate(string(body), 256))
+	}
+	return body, nil
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
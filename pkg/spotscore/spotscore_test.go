@@ -0,0 +1,69 @@
+package spotscore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirect returns a client sending every request to the test server instead of its cloud
+// endpoint. The server sees the endpoint's host in r.Host.
+func redirect(server *httptest.Server) *http.Client {
+	target, _ := url.Parse(server.URL)
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Host = req.URL.Host
+		req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// env returns a getenv reading from the map
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// clock is a now that tests move forward
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{
+		{name: "aws"},
+		{name: "azure"},
+		{name: "external", options: Options{Endpoint: "http://scores.example"}},
+		{name: "external", wantErr: "needs an endpoint"},
+		{name: "gcp", wantErr: `unknown spot score provider "gcp"`},
+	}
+
+	for _, tt := range tests {
+		provider, err := New(tt.name, tt.options)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || provider == nil {
+			t.Errorf("New(%q) = %v, %v", tt.name, provider, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// CreatePodRequest wraps pod in a CREATE admission request as the API server sends it
+func CreatePodRequest(pod *corev1.Pod) (admission.Request, error) {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Request{}, err
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       types.UID("request-" + pod.GenerateName + pod.Name),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}, nil
+}
+
+// AdmittedPod applies the admission response's patches to pod and returns the pod the API server
+// would store. It fails for denied admissions.
+func AdmittedPod(pod *corev1.Pod, resp admission.Response) (*corev1.Pod, error) {
+	if !resp.Allowed {
+		return nil, fmt.Errorf("admission denied: %v", resp.Result)
+	}
+
+	patch := resp.Patch
+	if len(resp.Patches) > 0 {
+		var err error
+		if patch, err = json.Marshal(resp.Patches); err != nil {
+			return nil, err
+		}
+	}
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return pod.DeepCopy(), nil
+	}
+
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admission patch: %w", err)
+	}
+	patched, err := decoded.Apply(original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply admission patch: %w", err)
+	}
+
+	admitted := &corev1.Pod{}
+	if err := json.Unmarshal(patched, admitted); err != nil {
+		return nil, err
+	}
+	return admitted, nil
+}
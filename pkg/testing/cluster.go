@@ -0,0 +1,103 @@
+// Package testing helps users embedding Smart Scheduler types test their placement strategies,
+// policies and admissions. It provides a fake cluster builder, workload and strategy fixtures,
+// admission request builders and an envtest API server with the Smart Scheduler CRDs installed.
+package testing
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// TB is the part of testing.TB the helpers use
+type TB interface {
+	Helper()
+	Skip(args ...any)
+	Fatalf(format string, args ...any)
+	Cleanup(func())
+}
+
+// NewScheme returns a scheme with the built-in Kubernetes types and the Smart Scheduler types
+func NewScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(smartschedulerv1.AddToScheme(scheme))
+	return scheme
+}
+
+// Cluster builds a fake client holding nodes, workloads and policies. The client has the owner
+// indexes the webhook and the controllers look pods and ReplicaSets up with.
+type Cluster struct {
+	scheme  *runtime.Scheme
+	objects []client.Object
+}
+
+// NewCluster returns an empty cluster
+func NewCluster() *Cluster {
+	return &Cluster{scheme: NewScheme()}
+}
+
+// Scheme returns the scheme of the cluster's client
+func (c *Cluster) Scheme() *runtime.Scheme {
+	return c.scheme
+}
+
+// WithObjects adds objects to the cluster
+func (c *Cluster) WithObjects(objects ...client.Object) *Cluster {
+	c.objects = append(c.objects, objects...)
+	return c
+}
+
+// WithNodes adds count ready nodes carrying labels, named after prefix
+func (c *Cluster) WithNodes(prefix string, count int, labels map[string]string) *Cluster {
+	for i := 0; i < count; i++ {
+		c.objects = append(c.objects, Node(prefixedName(prefix, i), labels))
+	}
+	return c
+}
+
+// WithWorkload adds the workload's deployment, ReplicaSet and pods
+func (c *Cluster) WithWorkload(w *Workload) *Cluster {
+	c.objects = append(c.objects, w.Objects()...)
+	return c
+}
+
+// Build returns a fake client holding the cluster's objects
+func (c *Cluster) Build() client.WithWatch {
+	builder := fake.NewClientBuilder().
+		WithScheme(c.scheme).
+		WithObjects(c.objects...).
+		WithStatusSubresource(&smartschedulerv1.PodPlacementPolicy{}, &smartschedulerv1.MaintenanceWindow{}, &smartschedulerv1.PlacementSummary{})
+	// The fake builder registers indexes the same way the manager cache does
+	utilruntime.Must(webhook.SetupIndexers(context.Background(), builderIndexer{builder}))
+	return builder.Build()
+}
+
+// builderIndexer registers field indexes on a fake client builder
+type builderIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (b builderIndexer) IndexField(_ context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	b.builder.WithIndex(obj, field, extract)
+	return nil
+}
+
+// Node returns a ready, schedulable node carrying labels
+func Node(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: copyLabels(labels)},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
@@ -0,0 +1,212 @@
+//go:build e2e
+
+package testing_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kube-smartscheduler/smart-scheduler/controllers"
+	sstesting "github.com/kube-smartscheduler/smart-scheduler/pkg/testing"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// convergenceTimeout bounds how long a workload may take to converge. Rebalancing moves a quarter
+// of the replicas per round, so strategy changes take several rounds.
+const convergenceTimeout = 2 * time.Minute
+
+// startOperator runs the webhook and the rebalancer sharing one placement state, as the manager
+// binary does, with the simulator standing in for the controllers, the scheduler and the kubelet
+func startOperator(t *testing.T, env *sstesting.Environment) {
+	t.Helper()
+	mgr := env.NewManager(t)
+	log := logr.Discard()
+	stateManager := webhook.NewStateManager(mgr.GetClient(), log)
+
+	mutator := &webhook.PodMutator{Client: mgr.GetClient(), Log: log, StateManager: stateManager}
+	if err := mutator.SetupWebhookWithManager(mgr); err != nil {
+		t.Fatalf("failed to set up webhook: %v", err)
+	}
+	rebalancer := &controllers.RebalanceController{
+		Client:         mgr.GetClient(),
+		Log:            log,
+		Scheme:         mgr.GetScheme(),
+		StateManager:   stateManager,
+		DebounceWindow: time.Second,
+	}
+	if err := rebalancer.SetupWithManager(mgr); err != nil {
+		t.Fatalf("failed to set up rebalancer: %v", err)
+	}
+	if err := (&sstesting.Simulator{}).SetupWithManager(mgr); err != nil {
+		t.Fatalf("failed to set up simulator: %v", err)
+	}
+	env.Start(t, mgr)
+}
+
+// startCluster starts an operator against an environment with 4 on-demand and 8 spot nodes
+func startCluster(t *testing.T) *sstesting.Environment {
+	t.Helper()
+	env := sstesting.StartEnvironmentWithWebhook(t)
+	env.CreateNodes(t, "ondemand", 4, onDemand)
+	env.CreateNodes(t, "spot", 8, spot)
+	startOperator(t, env)
+	return env
+}
+
+// placedByRule counts the workload's running pods by the rule of the strategy they are placed on
+func placedByRule(ctx context.Context, env *sstesting.Environment, w *sstesting.Workload, strategy *webhook.PlacementStrategy) (map[webhook.RuleKey]int, int, error) {
+	placed, err := env.PlacedPods(ctx, w, "node-type")
+	if err != nil {
+		return nil, 0, err
+	}
+	counts := make(map[webhook.RuleKey]int)
+	total := 0
+	for value, count := range placed {
+		total += count
+		if key, ok := webhook.MatchRuleKey(strategy, map[string]string{"node-type": value}); ok {
+			counts[key] += count
+		}
+	}
+	return counts, total, nil
+}
+
+// waitForConvergence waits until the workload runs replicas pods drifting from the strategy's
+// expected distribution by at most maxDrift percent
+func waitForConvergence(t *testing.T, env *sstesting.Environment, w *sstesting.Workload, annotation string, replicas int, maxDrift float64) {
+	t.Helper()
+	strategy, err := webhook.ParsePlacementStrategy(annotation)
+	if err != nil {
+		t.Fatalf("failed to parse strategy: %v", err)
+	}
+	expected := webhook.ExpectedDistribution(strategy, replicas)
+
+	ctx, cancel := context.WithTimeout(context.Background(), convergenceTimeout)
+	defer cancel()
+	var counts map[webhook.RuleKey]int
+	var total int
+	err = sstesting.Eventually(ctx, 500*time.Millisecond, func() (bool, error) {
+		counts, total, err = placedByRule(ctx, env, w, strategy)
+		if err != nil {
+			return false, err
+		}
+		return total == replicas && webhook.DriftPercentage(expected, counts) <= maxDrift, nil
+	})
+	if err != nil {
+		t.Fatalf("%s did not converge within %s: %d of %d pods running, placed %v, expected %v (%v)",
+			w.Deployment.Name, convergenceTimeout, total, replicas, counts, expected, err)
+	}
+}
+
+// scale sets the workload's replicas
+func scale(t *testing.T, env *sstesting.Environment, w *sstesting.Workload, replicas int32) {
+	t.Helper()
+	patch := client.MergeFrom(w.Deployment.DeepCopy())
+	*w.Deployment.Spec.Replicas = replicas
+	if err := env.Client.Patch(context.Background(), w.Deployment, patch); err != nil {
+		t.Fatalf("failed to scale %s to %d: %v", w.Deployment.Name, replicas, err)
+	}
+}
+
+// killPods deletes the workload's pods placed on nodes with the nodeSelector value, as when spot
+// capacity is reclaimed, and returns how many were deleted
+func killPods(t *testing.T, env *sstesting.Environment, w *sstesting.Workload, value string) int {
+	t.Helper()
+	ctx := context.Background()
+	pods := &corev1.PodList{}
+	if err := env.Client.List(ctx, pods, client.InNamespace(w.Deployment.Namespace), client.MatchingLabels(w.Deployment.Spec.Selector.MatchLabels)); err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	killed := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeSelector["node-type"] != value {
+			continue
+		}
+		if err := env.Client.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+			t.Fatalf("failed to delete pod %s: %v", pod.Name, err)
+		}
+		killed++
+	}
+	return killed
+}
+
+func TestConvergenceOnCreate(t *testing.T) {
+	env := startCluster(t)
+	annotation := sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String()
+	w := sstesting.NewWorkload("default", "checkout", annotation)
+	env.CreateWorkload(t, w, 9)
+
+	// Pods admitted one by one against the placement state land exactly on the expected distribution
+	waitForConvergence(t, env, w, annotation, 9, 0)
+	placed, err := env.PlacedPods(context.Background(), w, "node-type")
+	if err != nil {
+		t.Fatalf("failed to count pods: %v", err)
+	}
+	if placed["ondemand"] != 3 || placed["spot"] != 6 {
+		t.Errorf("placed %v, want 3 on-demand and 6 spot pods", placed)
+	}
+}
+
+func TestConvergenceOnScale(t *testing.T) {
+	env := startCluster(t)
+	annotation := sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String()
+	w := sstesting.NewWorkload("default", "checkout", annotation)
+	env.CreateWorkload(t, w, 4)
+	waitForConvergence(t, env, w, annotation, 4, 0)
+
+	for _, replicas := range []int32{12, 20} {
+		t.Run(fmt.Sprintf("up to %d", replicas), func(t *testing.T) {
+			scale(t, env, w, replicas)
+			waitForConvergence(t, env, w, annotation, int(replicas), 0)
+		})
+	}
+	// Scale-downs remove pods regardless of their rule, the rebalancer corrects what drifts too far
+	for _, replicas := range []int32{10, 5} {
+		t.Run(fmt.Sprintf("down to %d", replicas), func(t *testing.T) {
+			scale(t, env, w, replicas)
+			waitForConvergence(t, env, w, annotation, int(replicas), webhook.RebalanceDriftThreshold)
+		})
+	}
+}
+
+func TestConvergenceAfterPodsAreKilled(t *testing.T) {
+	env := startCluster(t)
+	annotation := sstesting.Strategy(2).Rule(1, onDemand).Rule(1, spot).String()
+	w := sstesting.NewWorkload("default", "checkout", annotation)
+	env.CreateWorkload(t, w, 10)
+	waitForConvergence(t, env, w, annotation, 10, 0)
+
+	for _, value := range []string{"spot", "ondemand"} {
+		t.Run("kill "+value, func(t *testing.T) {
+			if killed := killPods(t, env, w, value); killed == 0 {
+				t.Fatalf("no %s pods to kill", value)
+			}
+			waitForConvergence(t, env, w, annotation, 10, webhook.RebalanceDriftThreshold)
+		})
+	}
+}
+
+func TestConvergenceAfterStrategyChange(t *testing.T) {
+	env := startCluster(t)
+	before := sstesting.Strategy(0).Rule(1, onDemand).String()
+	w := sstesting.NewWorkload("default", "checkout", before)
+	env.CreateWorkload(t, w, 8)
+	waitForConvergence(t, env, w, before, 8, 0)
+
+	after := sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String()
+	patch := client.MergeFrom(w.Deployment.DeepCopy())
+	w.Deployment.Annotations[webhook.ScheduleStrategyAnnotation] = after
+	if err := env.Client.Patch(context.Background(), w.Deployment, patch); err != nil {
+		t.Fatalf("failed to change strategy: %v", err)
+	}
+
+	// The rebalancer evicts on-demand pods within the disruption allowance until the drift is
+	// within its threshold, and the webhook places their replacements on spot
+	waitForConvergence(t, env, w, after, 8, webhook.RebalanceDriftThreshold)
+}
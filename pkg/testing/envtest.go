@@ -0,0 +1,199 @@
+package testing
+
+import (
+	"context"
+	"os"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// cacheSyncTimeout bounds how long Start waits for the manager's informers
+const cacheSyncTimeout = 30 * time.Second
+
+// Environment is a local API server and etcd with the Smart Scheduler CRDs installed
+type Environment struct {
+	*envtest.Environment
+	Config *rest.Config
+	// Client reads from and writes to the API server directly
+	Client client.Client
+}
+
+// StartEnvironment starts an envtest API server for the test and stops it on cleanup. The test is
+// skipped when KUBEBUILDER_ASSETS is unset; `make test` sets it through setup-envtest.
+func StartEnvironment(t TB) *Environment {
+	t.Helper()
+	return startEnvironment(t, &envtest.Environment{
+		CRDs:                  CRDs(),
+		ErrorIfCRDPathMissing: false,
+	})
+}
+
+// StartEnvironmentWithWebhook starts an envtest API server that calls the pod mutating webhook of
+// the manager returned by NewManager, so pods created through the API server are placed by it
+func StartEnvironmentWithWebhook(t TB) *Environment {
+	t.Helper()
+	return startEnvironment(t, &envtest.Environment{
+		CRDs:                  CRDs(),
+		ErrorIfCRDPathMissing: false,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			MutatingWebhooks: []*admissionregistrationv1.MutatingWebhookConfiguration{PodWebhookConfiguration()},
+		},
+	})
+}
+
+// startEnvironment starts env for the test
+func startEnvironment(t TB, env *envtest.Environment) *Environment {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, skipping envtest scenario")
+	}
+
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+	t.Cleanup(func() { _ = env.Stop() })
+
+	c, err := client.New(cfg, client.Options{Scheme: NewScheme()})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &Environment{Environment: env, Config: cfg, Client: c}
+}
+
+// NewManager returns a manager for the environment with the indexes the webhook and the
+// controllers rely on. Metrics, health probes and leader election are disabled. In an environment
+// started with StartEnvironmentWithWebhook, its webhook server serves the API server's calls.
+func (e *Environment) NewManager(t TB) manager.Manager {
+	t.Helper()
+	options := ctrl.Options{
+		Scheme:                 NewScheme(),
+		Metrics:                server.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	}
+	if install := e.WebhookInstallOptions; len(install.MutatingWebhooks) > 0 {
+		options.WebhookServer = ctrlwebhook.NewServer(ctrlwebhook.Options{
+			Host:    install.LocalServingHost,
+			Port:    install.LocalServingPort,
+			CertDir: install.LocalServingCertDir,
+		})
+	}
+	mgr, err := ctrl.NewManager(e.Config, options)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	if err := webhook.SetupIndexers(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		t.Fatalf("failed to set up indexers: %v", err)
+	}
+	return mgr
+}
+
+// Start runs the manager until the test ends and waits for its cache to sync
+func (e *Environment) Start(t TB, mgr manager.Manager) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- mgr.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer syncCancel()
+	if !mgr.GetCache().WaitForCacheSync(syncCtx) {
+		t.Fatalf("manager cache did not sync within %s", cacheSyncTimeout)
+	}
+
+	// Pods created before the webhook server listens would be rejected by the API server
+	if len(e.WebhookInstallOptions.MutatingWebhooks) > 0 {
+		started := mgr.GetWebhookServer().StartedChecker()
+		if err := Eventually(syncCtx, 100*time.Millisecond, func() (bool, error) {
+			return started(nil) == nil, nil
+		}); err != nil {
+			t.Fatalf("webhook server did not start within %s: %v", cacheSyncTimeout, err)
+		}
+	}
+}
+
+// PodWebhookConfiguration returns the configuration calling the pod mutating webhook on pod
+// creation, as the Helm chart installs it. envtest points it at the local webhook server.
+func PodWebhookConfiguration() *admissionregistrationv1.MutatingWebhookConfiguration {
+	path := "/mutate-v1-pod"
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "smart-scheduler-mutating-webhook"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: "mpod.smart-scheduler.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{Name: "smart-scheduler-webhook", Namespace: "default", Path: &path},
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods"},
+				},
+			}},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+}
+
+// CRDs returns the PodPlacementPolicy and MaintenanceWindow CRDs with the status subresource and
+// an unvalidated schema, so tests do not depend on the Helm chart's rendered manifests
+func CRDs() []*apiextensionsv1.CustomResourceDefinition {
+	return []*apiextensionsv1.CustomResourceDefinition{
+		crd("PodPlacementPolicy", "podplacementpolicies", "podplacementpolicy", apiextensionsv1.NamespaceScoped),
+		crd("MaintenanceWindow", "maintenancewindows", "maintenancewindow", apiextensionsv1.ClusterScoped),
+	}
+}
+
+// crd returns a CRD of the smartscheduler.io group serving the v1 version
+func crd(kind, plural, singular string, scope apiextensionsv1.ResourceScope) *apiextensionsv1.CustomResourceDefinition {
+	preserveUnknownFields := true
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: plural + "." + smartschedulerv1.GroupVersion.Group},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: smartschedulerv1.GroupVersion.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Kind:     kind,
+				ListKind: kind + "List",
+				Plural:   plural,
+				Singular: singular,
+			},
+			Scope: scope,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    smartschedulerv1.GroupVersion.Version,
+				Served:  true,
+				Storage: true,
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type:                   "object",
+						XPreserveUnknownFields: &preserveUnknownFields,
+					},
+				},
+				Subresources: &apiextensionsv1.CustomResourceSubresources{
+					Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+				},
+			}},
+		},
+	}
+}
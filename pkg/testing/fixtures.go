@@ -0,0 +1,208 @@
+package testing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// StrategyBuilder builds placement strategies, both as the schedule-strategy annotation and as
+// a PodPlacementPolicy strategy
+type StrategyBuilder struct {
+	base  int
+	rules []smartschedulerv1.PlacementRuleSpec
+}
+
+// Strategy starts a weighted strategy placing the first base pods on the first rule
+func Strategy(base int) *StrategyBuilder {
+	return &StrategyBuilder{base: base}
+}
+
+// Rule adds a rule with a weight and a nodeSelector
+func (s *StrategyBuilder) Rule(weight int, nodeSelector map[string]string) *StrategyBuilder {
+	s.rules = append(s.rules, smartschedulerv1.PlacementRuleSpec{Weight: weight, NodeSelector: copyLabels(nodeSelector)})
+	return s
+}
+
+// String returns the strategy in the smart-scheduler.io/schedule-strategy annotation format
+func (s *StrategyBuilder) String() string {
+	rules := make([]string, 0, len(s.rules))
+	for i, rule := range s.rules {
+		var fields []string
+		if i == 0 {
+			fields = append(fields, "base="+strconv.Itoa(s.base))
+		}
+		fields = append(fields, "weight="+strconv.Itoa(rule.Weight))
+		if rule.Reserve > 0 {
+			fields = append(fields, "reserve="+strconv.Itoa(rule.Reserve))
+		}
+
+		keys := make([]string, 0, len(rule.NodeSelector))
+		for key := range rule.NodeSelector {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key+":"+rule.NodeSelector[key])
+		}
+		if len(pairs) > 0 {
+			fields = append(fields, "nodeSelector="+strings.Join(pairs, ","))
+		}
+		rules = append(rules, strings.Join(fields, ","))
+	}
+	return strings.Join(rules, ";")
+}
+
+// Reserve keeps count placeholder pods on the pool of the last added rule
+func (s *StrategyBuilder) Reserve(count int) *StrategyBuilder {
+	if len(s.rules) > 0 {
+		s.rules[len(s.rules)-1].Reserve = count
+	}
+	return s
+}
+
+// Spec returns the strategy for a PodPlacementPolicy
+func (s *StrategyBuilder) Spec() smartschedulerv1.PlacementStrategySpec {
+	spec := smartschedulerv1.PlacementStrategySpec{Base: intstr.FromInt(s.base)}
+	for _, rule := range s.rules {
+		rule.NodeSelector = copyLabels(rule.NodeSelector)
+		spec.Rules = append(spec.Rules, rule)
+	}
+	return spec
+}
+
+// Policy returns an enabled PodPlacementPolicy applying strategy to deployments matching labels
+func Policy(namespace, name string, labels map[string]string, strategy *StrategyBuilder) *smartschedulerv1.PodPlacementPolicy {
+	return &smartschedulerv1.PodPlacementPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: smartschedulerv1.PodPlacementPolicySpec{
+			Selector: &metav1.LabelSelector{MatchLabels: copyLabels(labels)},
+			Strategy: strategy.Spec(),
+			Enabled:  true,
+		},
+	}
+}
+
+// Workload is a deployment with its current ReplicaSet and pods
+type Workload struct {
+	Deployment *appsv1.Deployment
+	ReplicaSet *appsv1.ReplicaSet
+	Pods       []*corev1.Pod
+}
+
+// NewWorkload returns a deployment labelled app=name with one ReplicaSet and no pods. A non-empty
+// strategy is set as its schedule-strategy annotation.
+func NewWorkload(namespace, name, strategy string) *Workload {
+	controller := true
+	labels := map[string]string{"app": name}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(namespace + "-" + name + "-uid"),
+			Labels:    copyLabels(labels),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: new(int32),
+			Selector: &metav1.LabelSelector{MatchLabels: copyLabels(labels)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: copyLabels(labels)},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: "registry.k8s.io/pause:3.9"}}},
+			},
+		},
+	}
+	if strategy != "" {
+		deployment.Annotations = map[string]string{webhook.ScheduleStrategyAnnotation: strategy}
+	}
+
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-7d9f8b6c5",
+			Namespace: namespace,
+			UID:       types.UID(namespace + "-" + name + "-rs-uid"),
+			Labels:    copyLabels(labels),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: new(int32),
+			Selector: &metav1.LabelSelector{MatchLabels: copyLabels(labels)},
+			Template: deployment.Spec.Template,
+		},
+	}
+	return &Workload{Deployment: deployment, ReplicaSet: rs}
+}
+
+// WithPods adds count running pods with nodeSelector, as if they had been placed earlier
+func (w *Workload) WithPods(count int, nodeSelector map[string]string) *Workload {
+	for i := 0; i < count; i++ {
+		pod := w.PendingPod()
+		pod.GenerateName = ""
+		pod.Name = prefixedName(w.ReplicaSet.Name, len(w.Pods))
+		pod.Spec.NodeSelector = copyLabels(nodeSelector)
+		pod.Status.Phase = corev1.PodRunning
+		w.Pods = append(w.Pods, pod)
+	}
+
+	replicas := int32(len(w.Pods))
+	*w.Deployment.Spec.Replicas = replicas
+	*w.ReplicaSet.Spec.Replicas = replicas
+	w.ReplicaSet.Status.Replicas = replicas
+	return w
+}
+
+// PendingPod returns a new pod of the ReplicaSet as it reaches admission: generated name, no placement
+func (w *Workload) PendingPod() *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: w.ReplicaSet.Name + "-",
+			Namespace:    w.ReplicaSet.Namespace,
+			Labels:       copyLabels(w.ReplicaSet.Spec.Template.Labels),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: w.ReplicaSet.Name, UID: w.ReplicaSet.UID, Controller: &controller,
+			}},
+		},
+		Spec: *w.ReplicaSet.Spec.Template.Spec.DeepCopy(),
+	}
+}
+
+// Objects returns the deployment, ReplicaSet and pods
+func (w *Workload) Objects() []client.Object {
+	objects := []client.Object{w.Deployment, w.ReplicaSet}
+	for _, pod := range w.Pods {
+		objects = append(objects, pod)
+	}
+	return objects
+}
+
+// prefixedName returns the name of the i-th generated object
+func prefixedName(prefix string, i int) string {
+	return fmt.Sprintf("%s-%d", prefix, i)
+}
+
+// copyLabels copies a label map, returning nil for an empty one
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,1386 @@
+package testing_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+	"github.com/kube-smartscheduler/smart-scheduler/controllers"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/decision"
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/schedulerplugin"
+	sstesting "github.com/kube-smartscheduler/smart-scheduler/pkg/testing"
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+var (
+	onDemand = map[string]string{"node-type": "ondemand"}
+	spot     = map[string]string{"node-type": "spot"}
+)
+
+// newMutator returns a PodMutator reading and writing through c
+func newMutator(t *testing.T, c client.Client, scheme *runtime.Scheme) *webhook.PodMutator {
+	t.Helper()
+	log := logr.Discard()
+	mutator := &webhook.PodMutator{
+		Client:       c,
+		Log:          log,
+		StateManager: webhook.NewStateManager(c, log),
+		PoolHealth:   webhook.NewPoolHealthChecker(c, log),
+		Maintenance:  webhook.NewMaintenanceTracker(c, log),
+	}
+	if err := mutator.InjectDecoder(admission.NewDecoder(scheme)); err != nil {
+		t.Fatal(err)
+	}
+	return mutator
+}
+
+// admit sends count new pods of the workload through the mutator and returns how many landed per node type
+func admit(t *testing.T, mutator *webhook.PodMutator, w *sstesting.Workload, count int) map[string]int {
+	t.Helper()
+	placed := make(map[string]int)
+	for i := 0; i < count; i++ {
+		pod := w.PendingPod()
+		req, err := sstesting.CreatePodRequest(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admitted, err := sstesting.AdmittedPod(pod, mutator.Handle(context.Background(), req))
+		if err != nil {
+			t.Fatalf("pod %d: %v", i, err)
+		}
+		placed[admitted.Spec.NodeSelector["node-type"]]++
+	}
+	return placed
+}
+
+func TestPolicyPlacesPodsThroughWebhook(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "checkout", "")
+	policy := sstesting.Policy("shop", "checkout", map[string]string{"app": "checkout"},
+		sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot))
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 4, spot).
+		WithWorkload(workload).
+		WithObjects(policy)
+	c := cluster.Build()
+
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+	}
+	if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: "checkout"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(workload.Deployment), deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Annotations[webhook.ScheduleStrategyAnnotation] == "" {
+		t.Fatalf("policy was not applied to the deployment, annotations = %v", deployment.Annotations)
+	}
+
+	placed := admit(t, newMutator(t, c, cluster.Scheme()), workload, 9)
+	if placed["ondemand"] != 3 || placed["spot"] != 6 {
+		t.Errorf("placed = %v, want 3 ondemand (base 1 + 2 weighted) and 6 spot", placed)
+	}
+}
+
+func TestPolicyTolerationsReachPods(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "checkout", "")
+	policy := sstesting.Policy("shop", "checkout", map[string]string{"app": "checkout"},
+		sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot))
+	policy.Spec.Strategy.Rules[1].Tolerations = []smartschedulerv1.TolerationSpec{
+		{Key: "spot", Operator: "Equal", Value: "true", Effect: "NoSchedule"},
+	}
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 1, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload).
+		WithObjects(policy)
+	c := cluster.Build()
+
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+	}
+	if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: "checkout"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	mutator := newMutator(t, c, cluster.Scheme())
+	for i := 0; i < 4; i++ {
+		pod := workload.PendingPod()
+		req, err := sstesting.CreatePodRequest(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admitted, err := sstesting.AdmittedPod(pod, mutator.Handle(context.Background(), req))
+		if err != nil {
+			t.Fatalf("pod %d: %v", i, err)
+		}
+		tolerated := len(admitted.Spec.Tolerations) == 1 && admitted.Spec.Tolerations[0].Key == "spot"
+		if spotPod := admitted.Spec.NodeSelector["node-type"] == "spot"; tolerated != spotPod {
+			t.Errorf("pod %d on %s has tolerations %v, want the spot toleration on spot pods only",
+				i, admitted.Spec.NodeSelector["node-type"], admitted.Spec.Tolerations)
+		}
+	}
+}
+
+func TestPolicyNodeAffinityReachesPods(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "checkout", "")
+	policy := sstesting.Policy("shop", "checkout", map[string]string{"app": "checkout"},
+		sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot))
+	policy.Spec.Strategy.Rules[1].NodeAffinity = []smartschedulerv1.NodeAffinityExpressionSpec{
+		{Key: "topology.kubernetes.io/zone", Operator: "NotIn", Values: []string{"us-east-1c"}},
+	}
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 1, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload).
+		WithObjects(policy)
+	c := cluster.Build()
+
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+	}
+	if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: "checkout"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	mutator := newMutator(t, c, cluster.Scheme())
+	for i := 0; i < 4; i++ {
+		pod := workload.PendingPod()
+		req, err := sstesting.CreatePodRequest(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admitted, err := sstesting.AdmittedPod(pod, mutator.Handle(context.Background(), req))
+		if err != nil {
+			t.Fatalf("pod %d: %v", i, err)
+		}
+		required := admitted.Spec.Affinity != nil && admitted.Spec.Affinity.NodeAffinity != nil &&
+			admitted.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil
+		if spotPod := admitted.Spec.NodeSelector["node-type"] == "spot"; required != spotPod {
+			t.Errorf("pod %d on %s has affinity %v, want required node affinity on spot pods only",
+				i, admitted.Spec.NodeSelector["node-type"], admitted.Spec.Affinity)
+		}
+	}
+}
+
+func TestAnnotatedDeploymentKeepsWeightsWithExistingPods(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "cart", sstesting.Strategy(0).Rule(1, onDemand).Rule(1, spot).String()).
+		WithPods(4, onDemand)
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+
+	placed := admit(t, newMutator(t, c, cluster.Scheme()), workload, 4)
+	if placed["spot"] != 4 {
+		t.Errorf("placed = %v, want every new pod on spot to even out the existing ondemand pods", placed)
+	}
+}
+
+func TestPolicyMigrationKeepsPlacement(t *testing.T) {
+	strategy := sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String()
+	cart := sstesting.NewWorkload("shop", "cart", strategy)
+	checkout := sstesting.NewWorkload("shop", "checkout", strategy)
+	search := sstesting.NewWorkload("shop", "search", sstesting.Strategy(0).Rule(1, spot).String())
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 4, spot).
+		WithWorkload(cart).
+		WithWorkload(checkout).
+		WithWorkload(search)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	migration := &controllers.PolicyMigrationController{Client: c, Log: logr.Discard(), Scheme: cluster.Scheme()}
+	for _, w := range []*sstesting.Workload{cart, checkout, search} {
+		if _, err := migration.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(w.Deployment)}); err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", w.Deployment.Name, err)
+		}
+	}
+
+	// Deployments sharing a strategy share a policy
+	policies := &smartschedulerv1.PodPlacementPolicyList{}
+	if err := c.List(ctx, policies, client.InNamespace("shop")); err != nil {
+		t.Fatal(err)
+	}
+	if len(policies.Items) != 2 {
+		t.Fatalf("got %d policies, want one per distinct strategy", len(policies.Items))
+	}
+	migrated := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cart.Deployment), migrated); err != nil {
+		t.Fatal(err)
+	}
+	policyName := migrated.Labels[controllers.MigratedPolicyLabel]
+	if policyName == "" || migrated.Annotations["smart-scheduler.io/policy-name"] != policyName {
+		t.Fatalf("cart was not marked as migrated, labels = %v, annotations = %v", migrated.Labels, migrated.Annotations)
+	}
+
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+	}
+	if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: policyName}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	placed := admit(t, newMutator(t, c, cluster.Scheme()), checkout, 9)
+	if placed["ondemand"] != 3 || placed["spot"] != 6 {
+		t.Errorf("placed = %v, want the annotation's 3 ondemand and 6 spot after migration", placed)
+	}
+}
+
+func TestScopeLimitsHoldBackPolicies(t *testing.T) {
+	search := sstesting.NewWorkload("shop", "search", sstesting.Strategy(0).Rule(1, spot).String())
+	cart := sstesting.NewWorkload("shop", "cart", "")
+	checkout := sstesting.NewWorkload("shop", "checkout", "")
+	older := sstesting.Policy("shop", "cart", map[string]string{"app": "cart"}, sstesting.Strategy(1).Rule(1, onDemand))
+	older.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	newer := sstesting.Policy("shop", "checkout", map[string]string{"app": "checkout"}, sstesting.Strategy(1).Rule(1, onDemand))
+	newer.CreationTimestamp = metav1.NewTime(time.Now())
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithWorkload(search).
+		WithWorkload(cart).
+		WithWorkload(checkout).
+		WithObjects(older, newer)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	// search already has a strategy, leaving room for one more managed deployment
+	limits := controllers.NewScopeLimits(2, 0, 1)
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+		Limits:       limits,
+	}
+	reconcile := func(name string) *smartschedulerv1.PodPlacementPolicy {
+		t.Helper()
+		key := types.NamespacedName{Namespace: "shop", Name: name}
+		if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", name, err)
+		}
+		policy := &smartschedulerv1.PodPlacementPolicy{}
+		if err := c.Get(ctx, key, policy); err != nil {
+			t.Fatal(err)
+		}
+		return policy
+	}
+	managed := func(w *sstesting.Workload) bool {
+		t.Helper()
+		deployment := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(w.Deployment), deployment); err != nil {
+			t.Fatal(err)
+		}
+		return webhook.HasScheduleStrategy(deployment.Annotations)
+	}
+
+	// Only the oldest policy of the namespace is applied
+	if policy := reconcile("cart"); meta.IsStatusConditionTrue(policy.Status.Conditions, controllers.LimitExceededCondition) || !managed(cart) {
+		t.Fatalf("the oldest policy was not applied, conditions = %v", policy.Status.Conditions)
+	}
+	policy := reconcile("checkout")
+	if condition := meta.FindStatusCondition(policy.Status.Conditions, controllers.LimitExceededCondition); condition == nil || condition.Reason != "MaxPoliciesPerNamespace" {
+		t.Errorf("LimitExceeded = %v, want reason MaxPoliciesPerNamespace", condition)
+	}
+	if managed(checkout) {
+		t.Error("a policy beyond the namespace limit was applied")
+	}
+
+	// Lifting the policy limit runs into the managed deployment limit
+	limits.Set(2, 0, 0)
+	policy = reconcile("checkout")
+	if condition := meta.FindStatusCondition(policy.Status.Conditions, controllers.LimitExceededCondition); condition == nil || condition.Reason != "MaxManagedDeployments" {
+		t.Errorf("LimitExceeded = %v, want reason MaxManagedDeployments", condition)
+	}
+	if managed(checkout) {
+		t.Error("the policy was applied beyond the managed deployment limit")
+	}
+
+	limits.Set(0, 0, 0)
+	if policy := reconcile("checkout"); meta.FindStatusCondition(policy.Status.Conditions, controllers.LimitExceededCondition) != nil || !managed(checkout) {
+		t.Errorf("the policy was not applied without limits, conditions = %v", policy.Status.Conditions)
+	}
+}
+
+func TestPolicyPreflightReportsRulesWithoutNodes(t *testing.T) {
+	gpu := map[string]string{"node-type": "gpu"}
+	workload := sstesting.NewWorkload("shop", "checkout", "")
+	policy := sstesting.Policy("shop", "checkout", map[string]string{"app": "checkout"},
+		sstesting.Strategy(1).Rule(1, onDemand).Rule(3, gpu))
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithWorkload(workload).
+		WithObjects(policy)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	reconciler := &controllers.PodPlacementPolicyController{
+		Client:       c,
+		Log:          logr.Discard(),
+		Scheme:       cluster.Scheme(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+		Preflight:    true,
+	}
+	reconcile := func() *metav1.Condition {
+		t.Helper()
+		key := types.NamespacedName{Namespace: "shop", Name: "checkout"}
+		if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		policy := &smartschedulerv1.PodPlacementPolicy{}
+		if err := c.Get(ctx, key, policy); err != nil {
+			t.Fatal(err)
+		}
+		return meta.FindStatusCondition(policy.Status.Conditions, controllers.RuleMatchesNoNodesCondition)
+	}
+
+	// No gpu node exists yet
+	condition := reconcile()
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != "NoMatchingNodes" {
+		t.Fatalf("RuleMatchesNoNodes = %v, want True with reason NoMatchingNodes", condition)
+	}
+	if !strings.Contains(condition.Message, "strategy.rules[1]") || strings.Contains(condition.Message, "strategy.rules[0]") {
+		t.Errorf("message = %q, want only the gpu rule reported", condition.Message)
+	}
+
+	if err := c.Create(ctx, sstesting.Node("gpu-0", gpu)); err != nil {
+		t.Fatal(err)
+	}
+	if condition := reconcile(); condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("RuleMatchesNoNodes = %v, want False once a gpu node exists", condition)
+	}
+
+	// Turning preflight off drops the condition
+	reconciler.Preflight = false
+	if condition := reconcile(); condition != nil {
+		t.Errorf("RuleMatchesNoNodes = %v, want none with preflight off", condition)
+	}
+}
+
+func TestPlacementCleanupAfterStrategyRemoval(t *testing.T) {
+	placed := func(w *sstesting.Workload) *sstesting.Workload {
+		for _, pod := range w.Pods {
+			pod.Annotations = map[string]string{"smart-scheduler.io/processed": "true"}
+		}
+		return w
+	}
+	web := placed(sstesting.NewWorkload("shop", "web", "").WithPods(2, spot))
+	batch := placed(sstesting.NewWorkload("shop", "batch", "").WithPods(2, spot))
+
+	cluster := sstesting.NewCluster().
+		WithNodes("spot", 2, spot).
+		WithWorkload(web).
+		WithWorkload(batch)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	scheduler := &controllers.SchedulerController{Client: c, Log: logr.Discard(), Scheme: cluster.Scheme()}
+	reconcile := func(w *sstesting.Workload) (ctrl.Result, *appsv1.Deployment) {
+		t.Helper()
+		result, err := scheduler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(w.Deployment)})
+		if err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", w.Deployment.Name, err)
+		}
+		deployment := &appsv1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(w.Deployment), deployment); err != nil {
+			t.Fatal(err)
+		}
+		return result, deployment
+	}
+
+	// annotate marks the deployment while its pods keep the removed strategy's placement
+	scheduler.CleanupMode = controllers.PlacementCleanupAnnotate
+	result, deployment := reconcile(web)
+	if stale := deployment.Annotations["smart-scheduler.io/placement-stale"]; !strings.HasPrefix(stale, "2 pods") {
+		t.Errorf("placement-stale = %q, want 2 pods reported", stale)
+	}
+	if result.RequeueAfter != 10*time.Minute {
+		t.Errorf("RequeueAfter = %s, want 10m to check on the pods again", result.RequeueAfter)
+	}
+	for _, pod := range web.Pods {
+		if err := c.Delete(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, deployment := reconcile(web); deployment.Annotations["smart-scheduler.io/placement-stale"] != "" {
+		t.Errorf("Expected the annotation removed once the pods are gone, got %v", deployment.Annotations)
+	}
+
+	// rollout restarts the deployment instead
+	scheduler.CleanupMode = controllers.PlacementCleanupRollout
+	if _, deployment := reconcile(batch); deployment.Spec.Template.Annotations["smart-scheduler.io/placement-cleanup-at"] == "" {
+		t.Errorf("Expected the deployment restarted, template annotations = %v", deployment.Spec.Template.Annotations)
+	}
+
+	// none leaves deployments alone
+	scheduler.CleanupMode = controllers.PlacementCleanupNone
+	restarted := batch.Deployment.DeepCopy()
+	if err := c.Get(ctx, client.ObjectKeyFromObject(restarted), restarted); err != nil {
+		t.Fatal(err)
+	}
+	if _, deployment := reconcile(batch); deployment.ResourceVersion != restarted.ResourceVersion {
+		t.Error("Expected no change to the deployment with cleanup off")
+	}
+}
+
+func TestBindAuditReportsPodsOnForeignNodes(t *testing.T) {
+	workload := sstesting.NewWorkload("default", "web", sstesting.Strategy(0).Rule(1, onDemand).String())
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 1, onDemand).
+		WithNodes("spot", 1, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	// The webhook records the rule it applied; the pods are then bound, one of them to a spot node
+	pod := workload.PendingPod()
+	req, err := sstesting.CreatePodRequest(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted, err := sstesting.AdmittedPod(pod, newMutator(t, c, cluster.Scheme()).Handle(ctx, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, node := range map[string]string{"web-matched": "ondemand-0", "web-mismatched": "spot-0", "web-pending": ""} {
+		bound := admitted.DeepCopy()
+		bound.GenerateName, bound.Name = "", name
+		bound.Spec.NodeName = node
+		if err := c.Create(ctx, bound); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auditor := &controllers.PlacementAuditController{
+		Client:    c,
+		Log:       logr.Discard(),
+		Scheme:    cluster.Scheme(),
+		BindAudit: true,
+	}
+	audit := func(name string) map[string]string {
+		t.Helper()
+		if _, err := auditor.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		pod := &corev1.Pod{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, pod); err != nil {
+			t.Fatal(err)
+		}
+		return pod.Annotations
+	}
+	mismatchEvents := func() int {
+		t.Helper()
+		events := &corev1.EventList{}
+		if err := c.List(ctx, events, client.InNamespace("default")); err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for _, event := range events.Items {
+			if event.Reason == "PlacementBindMismatch" {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := audit("web-matched")["smart-scheduler.io/placement-outcome"]; got != "matched ondemand-0" {
+		t.Errorf("Expected the matched node recorded, got %q", got)
+	}
+	if got := audit("web-pending")["smart-scheduler.io/placement-outcome"]; got != "" {
+		t.Errorf("Expected no outcome before the pod is bound, got %q", got)
+	}
+	if mismatchEvents() != 0 {
+		t.Errorf("Expected no mismatch reported for pods on their rule's nodes")
+	}
+
+	want := "mismatched spot-0: node-type=spot (rule node-type=ondemand)"
+	if got := audit("web-mismatched")["smart-scheduler.io/placement-outcome"]; got != want {
+		t.Errorf("Expected outcome %q, got %q", want, got)
+	}
+	// The recorded outcome keeps a requeued pod from being reported again
+	audit("web-mismatched")
+	if events := mismatchEvents(); events != 1 {
+		t.Errorf("Expected one PlacementBindMismatch event, got %d", events)
+	}
+}
+
+func TestWebhookConfigurationCheckReportsMismatches(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smart-scheduler-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/mutate-v1-pod"
+	fail := admissionregistrationv1.Fail
+	configuration := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "smart-scheduler"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: "mpod.smart-scheduler.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service:  &admissionregistrationv1.ServiceReference{Namespace: "smart-scheduler-system", Name: "smart-scheduler-webhook", Path: &path},
+				CABundle: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+				Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+			}},
+			FailurePolicy: &fail,
+		}},
+	}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "smart-scheduler-system", Name: "smart-scheduler-webhook"}}
+
+	cluster := sstesting.NewCluster().WithObjects(configuration, service)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	reconciler := &controllers.WebhookConfigurationController{
+		Client:        c,
+		Reader:        c,
+		Log:           logr.Discard(),
+		Scheme:        cluster.Scheme(),
+		Namespace:     "smart-scheduler-system",
+		ServiceName:   "smart-scheduler-webhook",
+		FailurePolicy: admissionregistrationv1.Ignore,
+	}
+	check := func() metav1.Condition {
+		t.Helper()
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(service)}
+		if _, err := reconciler.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		status := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "smart-scheduler-system", Name: controllers.ManagerStatusConfigMap}, status); err != nil {
+			t.Fatal(err)
+		}
+		var conditions []metav1.Condition
+		if err := json.Unmarshal([]byte(status.Data["conditions"]), &conditions); err != nil {
+			t.Fatal(err)
+		}
+		condition := meta.FindStatusCondition(conditions, controllers.WebhookConfiguredCondition)
+		if condition == nil {
+			t.Fatalf("no %s condition in %v", controllers.WebhookConfiguredCondition, conditions)
+		}
+		return *condition
+	}
+
+	// The Service has no endpoints and the failurePolicy is not the expected one
+	condition := check()
+	if condition.Status != metav1.ConditionFalse || condition.Reason != "FailurePolicyMismatch" {
+		t.Errorf("condition = %s/%s, want False/FailurePolicyMismatch", condition.Status, condition.Reason)
+	}
+	if !strings.Contains(condition.Message, "no ready endpoint") {
+		t.Errorf("condition message %q does not report the missing endpoints", condition.Message)
+	}
+
+	ready := true
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "smart-scheduler-system",
+			Name:      "smart-scheduler-webhook-abcde",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "smart-scheduler-webhook"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}},
+	}
+	if err := c.Create(ctx, slice); err != nil {
+		t.Fatal(err)
+	}
+	ignore := admissionregistrationv1.Ignore
+	configuration.Webhooks[0].FailurePolicy = &ignore
+	if err := c.Update(ctx, configuration); err != nil {
+		t.Fatal(err)
+	}
+	if condition := check(); condition.Status != metav1.ConditionTrue {
+		t.Errorf("condition = %s/%s %q, want True", condition.Status, condition.Reason, condition.Message)
+	}
+
+	// A configuration that stops covering pods is reported
+	configuration.Webhooks[0].Rules[0].Resources = []string{"deployments"}
+	if err := c.Update(ctx, configuration); err != nil {
+		t.Fatal(err)
+	}
+	if condition := check(); condition.Status != metav1.ConditionFalse || condition.Reason != "PodsNotCovered" {
+		t.Errorf("condition = %s/%s, want False/PodsNotCovered", condition.Status, condition.Reason)
+	}
+}
+
+func TestDecisionServiceAnswersFromCluster(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "search", sstesting.Strategy(1).Rule(1, onDemand).Rule(1, spot).String()).
+		WithPods(3, onDemand)
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+	decisions := dialDecisionService(t, &decision.Engine{Client: c, PoolHealth: webhook.NewPoolHealthChecker(c, logr.Discard())})
+	ctx := context.Background()
+
+	decided, err := decisions.DecidePlacement(ctx, &decision.DecidePlacementRequest{Namespace: "shop", Deployment: "search"})
+	if err != nil {
+		t.Fatalf("DecidePlacement() error = %v", err)
+	}
+	if decided.Rule.Key != "node-type=spot" || decided.RuleIndex != 1 || decided.Counts["node-type=ondemand"] != 3 {
+		t.Errorf("DecidePlacement() = %+v, want the spot rule against 3 ondemand pods", decided)
+	}
+
+	simulated, err := decisions.SimulateStrategy(ctx, &decision.SimulateStrategyRequest{
+		Strategy: sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String(),
+		Replicas: 9,
+	})
+	if err != nil {
+		t.Fatalf("SimulateStrategy() error = %v", err)
+	}
+	if len(simulated.Rules) != 2 || simulated.Rules[0].Expected != 3 || simulated.Rules[1].Expected != 6 {
+		t.Errorf("SimulateStrategy() = %+v, want 3 ondemand and 6 spot", simulated)
+	}
+
+	drift, err := decisions.GetDrift(ctx, &decision.GetDriftRequest{Namespace: "shop", Deployment: "search"})
+	if err != nil {
+		t.Fatalf("GetDrift() error = %v", err)
+	}
+	if drift.Expected["node-type=ondemand"] != 2 || drift.Actual["node-type=ondemand"] != 3 || !drift.RequiresRebalance {
+		t.Errorf("GetDrift() = %+v, want 2 of 3 pods expected on ondemand and a rebalance", drift)
+	}
+
+	_, err = decisions.GetDrift(ctx, &decision.GetDriftRequest{Namespace: "shop", Deployment: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetDrift() of a missing deployment error = %v, want NotFound", err)
+	}
+	_, err = decisions.SimulateStrategy(ctx, &decision.SimulateStrategyRequest{Strategy: "base=x", Replicas: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SimulateStrategy() of an invalid strategy error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestDecisionServiceSimulatesZoneOutage(t *testing.T) {
+	zoneA := map[string]string{"node-type": "ondemand", corev1.LabelTopologyZone: "us-west-2a"}
+	zoneB := map[string]string{"node-type": "ondemand", corev1.LabelTopologyZone: "us-west-2b"}
+	spotB := map[string]string{"node-type": "spot", corev1.LabelTopologyZone: "us-west-2b"}
+	pinnedA := map[string]string{corev1.LabelTopologyZone: "us-west-2a"}
+
+	// schedule puts the workload's pods on the nodes, in order
+	schedule := func(w *sstesting.Workload, nodes ...string) *sstesting.Workload {
+		for i, node := range nodes {
+			w.Pods[i].Spec.NodeName = node
+		}
+		return w
+	}
+	// web keeps one of its two base pods in zone b
+	web := schedule(sstesting.NewWorkload("shop", "web", sstesting.Strategy(2).Rule(1, onDemand).Rule(1, spot).String()).
+		WithPods(2, onDemand).WithPods(1, spot), "ondemand-a-0", "ondemand-b-0", "spot-b-0")
+	// cache pins its base to zone a
+	cache := schedule(sstesting.NewWorkload("shop", "cache", sstesting.Strategy(1).Rule(1, pinnedA).Rule(1, spot).String()).
+		WithPods(1, pinnedA), "ondemand-a-0")
+	// search runs in zone b only
+	search := schedule(sstesting.NewWorkload("shop", "search", sstesting.Strategy(1).Rule(1, onDemand).String()).
+		WithPods(1, onDemand), "ondemand-b-0")
+
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand-a", 1, zoneA).
+		WithNodes("ondemand-b", 1, zoneB).
+		WithNodes("spot-b", 1, spotB).
+		WithWorkload(web).
+		WithWorkload(cache).
+		WithWorkload(search)
+	c := cluster.Build()
+	decisions := dialDecisionService(t, &decision.Engine{Client: c, PoolHealth: webhook.NewPoolHealthChecker(c, logr.Discard())})
+	ctx := context.Background()
+
+	outage, err := decisions.SimulateOutage(ctx, &decision.SimulateOutageRequest{Zone: "us-west-2a"})
+	if err != nil {
+		t.Fatalf("SimulateOutage() error = %v", err)
+	}
+	if outage.RemovedNodes != 1 || outage.Breaches != 2 || len(outage.Deployments) != 2 {
+		t.Fatalf("SimulateOutage() = %+v, want 1 node removed and 2 breaching deployments", outage)
+	}
+	for _, impact := range outage.Deployments {
+		switch impact.Deployment {
+		case "cache":
+			if impact.Breach != decision.BreachBasePoolLost || impact.LostPods != 1 {
+				t.Errorf("cache impact = %+v, want its base pool lost", impact)
+			}
+		case "web":
+			if impact.Breach != decision.BreachBasePodsLost || impact.BasePodsLeft != 1 || impact.BaseRuleNodesLeft != 1 {
+				t.Errorf("web impact = %+v, want 1 of 2 base pods left on 1 node", impact)
+			}
+		default:
+			t.Errorf("unaffected deployment %s reported", impact.Deployment)
+		}
+	}
+
+	_, err = decisions.SimulateOutage(ctx, &decision.SimulateOutageRequest{Zone: "us-east-1a"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SimulateOutage() of an unknown zone error = %v, want InvalidArgument", err)
+	}
+}
+
+// dialDecisionService serves engine's decision service in memory and returns a client for it
+func dialDecisionService(t *testing.T, engine *decision.Engine) *decision.Client {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := decision.NewGRPCServer(engine)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return decision.NewClient(conn)
+}
+
+func TestEnvironmentAdmitsAgainstAPIServer(t *testing.T) {
+	env := sstesting.StartEnvironment(t)
+
+	workload := sstesting.NewWorkload("default", "api", sstesting.Strategy(0).Rule(1, onDemand).Rule(1, spot).String())
+	ctx := context.Background()
+	for _, node := range []*corev1.Node{sstesting.Node("ondemand-0", onDemand), sstesting.Node("spot-0", spot)} {
+		// Create drops the status, which carries the Ready condition
+		status := node.Status
+		if err := env.Client.Create(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+		node.Status = status
+		if err := env.Client.Status().Update(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, obj := range workload.Objects() {
+		if err := env.Client.Create(ctx, obj); err != nil {
+			t.Fatalf("failed to create %T %s: %v", obj, obj.GetName(), err)
+		}
+	}
+	// The ReplicaSet must reference the deployment's server-assigned UID
+	rs := workload.ReplicaSet
+	rs.OwnerReferences[0].UID = workload.Deployment.UID
+	if err := env.Client.Update(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := env.NewManager(t)
+	env.Start(t, mgr)
+	if err := waitForReplicaSet(ctx, mgr.GetClient(), rs); err != nil {
+		t.Fatal(err)
+	}
+
+	placed := admit(t, newMutator(t, mgr.GetClient(), mgr.GetScheme()), workload, 4)
+	if placed["ondemand"] != 2 || placed["spot"] != 2 {
+		t.Errorf("placed = %v, want 2 ondemand and 2 spot", placed)
+	}
+}
+
+// waitForReplicaSet waits until the manager's cache holds the ReplicaSet with its owner
+func waitForReplicaSet(ctx context.Context, c client.Client, rs *appsv1.ReplicaSet) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		cached := &appsv1.ReplicaSet{}
+		err := c.Get(ctx, client.ObjectKeyFromObject(rs), cached)
+		if err == nil && len(cached.OwnerReferences) > 0 && cached.OwnerReferences[0].UID == rs.OwnerReferences[0].UID {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ReplicaSet %s not cached with its owner: %v", rs.Name, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestJanitorPrunesStaleObjects(t *testing.T) {
+	longAgo := time.Now().Add(-30 * 24 * time.Hour)
+	placementState := func(deployment string, updated time.Time) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "smart-scheduler-" + deployment,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":        "smart-scheduler",
+					"app.kubernetes.io/component":   "placement-state",
+					"smart-scheduler.io/deployment": deployment,
+				},
+			},
+			Data: map[string]string{"last-updated": updated.Format(time.RFC3339)},
+		}
+	}
+	rebalanceEvent := func(name string, lastSeen time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:    metav1.ObjectMeta{Namespace: "default", Name: name},
+			Source:        corev1.EventSource{Component: "smart-scheduler-rebalancer"},
+			LastTimestamp: metav1.NewTime(lastSeen),
+		}
+	}
+
+	managed := sstesting.NewWorkload("default", "web", sstesting.Strategy(1).Rule(1, onDemand).String())
+	removed := sstesting.NewWorkload("default", "batch", "")
+	removed.Deployment.Annotations = map[string]string{
+		"smart-scheduler.io/rebalance-observed-strategy": "base=1,weight=1,nodeSelector=node-type:ondemand",
+		"smart-scheduler.io/rebalance-rollout-started":   longAgo.Format(time.RFC3339),
+		"smart-scheduler.io/rebalance-rollout-evictions": "3",
+		"smart-scheduler.io/manual-override":             longAgo.Format(time.RFC3339),
+		"team":                                           "data",
+	}
+
+	cluster := sstesting.NewCluster().
+		WithWorkload(managed).
+		WithWorkload(removed).
+		WithObjects(
+			placementState("web", longAgo),
+			placementState("batch", longAgo),
+			placementState("deleted", longAgo),
+			placementState("recent", time.Now()),
+			rebalanceEvent("smart-scheduler-1600000000", longAgo),
+			rebalanceEvent("smart-scheduler-1700000000", time.Now()),
+			rebalanceEvent("web-x7k2p", longAgo),
+		)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	janitor := &controllers.Janitor{Client: c, Reader: c, Log: logr.Discard(), Scheme: cluster.Scheme(), TTL: 7 * 24 * time.Hour, Interval: time.Hour}
+	result, err := janitor.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "janitor"}})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("RequeueAfter = %s, want 1h", result.RequeueAfter)
+	}
+
+	exists := func(obj client.Object, name string) bool {
+		t.Helper()
+		err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, obj)
+		if err != nil && client.IgnoreNotFound(err) != nil {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	for name, want := range map[string]bool{
+		"smart-scheduler-web":     true,
+		"smart-scheduler-batch":   false,
+		"smart-scheduler-deleted": false,
+		"smart-scheduler-recent":  true,
+	} {
+		if got := exists(&corev1.ConfigMap{}, name); got != want {
+			t.Errorf("ConfigMap %s exists = %t, want %t", name, got, want)
+		}
+	}
+	for name, want := range map[string]bool{
+		"smart-scheduler-1600000000": false,
+		"smart-scheduler-1700000000": true,
+		"web-x7k2p":                  true,
+	} {
+		if got := exists(&corev1.Event{}, name); got != want {
+			t.Errorf("Event %s exists = %t, want %t", name, got, want)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	if !exists(deployment, "batch") {
+		t.Fatal("deployment batch was deleted")
+	}
+	if len(deployment.Annotations) != 1 || deployment.Annotations["team"] != "data" {
+		t.Errorf("annotations of the deployment without strategy = %v, want only team=data", deployment.Annotations)
+	}
+}
+
+func TestPlacementSummaryTotalsManagedWorkloads(t *testing.T) {
+	strategy := sstesting.Strategy(0).Rule(1, onDemand).Rule(3, spot).String()
+	web := sstesting.NewWorkload("default", "web", strategy).WithPods(1, onDemand).WithPods(3, spot)
+	stuck := web.Pods[3]
+	stuck.Status.Phase = corev1.PodPending
+	stuck.Status.Conditions = []corev1.PodCondition{{
+		Type:               corev1.PodScheduled,
+		Status:             corev1.ConditionFalse,
+		Reason:             corev1.PodReasonUnschedulable,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+	}}
+	api := sstesting.NewWorkload("default", "api", strategy).WithPods(2, onDemand).WithPods(2, spot)
+	// A rollout in progress drifts further than api, but is left out of the worst drift
+	batch := sstesting.NewWorkload("default", "batch", strategy).WithPods(4, onDemand)
+	batch.Deployment.Generation = 2
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(web).
+		WithWorkload(api).
+		WithWorkload(batch).
+		WithWorkload(sstesting.NewWorkload("default", "plain", "").WithPods(2, nil)).
+		WithWorkload(sstesting.NewWorkload("kube-system", "dns", strategy).WithPods(2, onDemand))
+	c := cluster.Build()
+	ctx := context.Background()
+
+	summarizer := &controllers.PlacementSummaryController{
+		Client:     c,
+		Log:        logr.Discard(),
+		Scheme:     cluster.Scheme(),
+		PoolHealth: webhook.NewPoolHealthChecker(c, logr.Discard()),
+		Interval:   time.Minute,
+	}
+	summarize := func() smartschedulerv1.PlacementSummaryStatus {
+		t.Helper()
+		result, err := summarizer.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: smartschedulerv1.PlacementSummaryName}})
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if result.RequeueAfter != time.Minute {
+			t.Errorf("RequeueAfter = %s, want the 1m interval", result.RequeueAfter)
+		}
+		summary := &smartschedulerv1.PlacementSummary{}
+		if err := c.Get(ctx, types.NamespacedName{Name: smartschedulerv1.PlacementSummaryName}, summary); err != nil {
+			t.Fatal(err)
+		}
+		return summary.Status
+	}
+
+	// The first refresh creates the summary; workloads without a strategy or in system namespaces are left out
+	status := summarize()
+	if status.ManagedDeployments != 3 {
+		t.Errorf("ManagedDeployments = %d, want 3", status.ManagedDeployments)
+	}
+	if status.Pods.OnDemand != 7 || status.Pods.Spot != 5 || status.Pods.Preemptible != 0 {
+		t.Errorf("Pods = %+v, want 7 on-demand and 5 spot", status.Pods)
+	}
+	if status.PendingUnschedulable != 1 {
+		t.Errorf("PendingUnschedulable = %d, want 1", status.PendingUnschedulable)
+	}
+	if status.WorstDrift == nil || status.WorstDrift.Name != "api" || status.WorstDrift.DriftPercentage <= 0 {
+		t.Errorf("Expected api as the worst drift, got %+v", status.WorstDrift)
+	}
+	if status.LastUpdated == nil {
+		t.Error("Expected LastUpdated to be set")
+	}
+
+	// Later refreshes replace the totals
+	if err := c.Delete(ctx, api.Deployment); err != nil {
+		t.Fatal(err)
+	}
+	status = summarize()
+	if status.ManagedDeployments != 2 || status.Pods.OnDemand != 5 || status.Pods.Spot != 3 {
+		t.Errorf("Expected api dropped from the totals, got %d workloads with %+v", status.ManagedDeployments, status.Pods)
+	}
+	if status.WorstDrift == nil || status.WorstDrift.Name != "web" {
+		t.Errorf("Expected web as the worst drift, got %+v", status.WorstDrift)
+	}
+}
+
+func TestStrategyChangeRolloutIsHeldAndCapped(t *testing.T) {
+	workload := sstesting.NewWorkload("default", "web", sstesting.Strategy(0).Rule(1, onDemand).Rule(3, spot).String()).
+		WithPods(2, onDemand).
+		WithPods(6, spot)
+	workload.Deployment.Status = appsv1.DeploymentStatus{Replicas: 8, UpdatedReplicas: 8, ReadyReplicas: 8, AvailableReplicas: 8}
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	log := logr.Discard()
+	rebalancer := &controllers.RebalanceController{
+		Client:                        c,
+		Log:                           log,
+		Scheme:                        cluster.Scheme(),
+		StateManager:                  webhook.NewStateManager(c, log),
+		PoolHealth:                    webhook.NewPoolHealthChecker(c, log),
+		Maintenance:                   webhook.NewMaintenanceTracker(c, log),
+		StrategyChangeGracePeriod:     time.Hour,
+		MaxEvictionsPerStrategyChange: 3,
+	}
+	reconcile := func() (ctrl.Result, int) {
+		t.Helper()
+		result, err := rebalancer.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}})
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace("default")); err != nil {
+			t.Fatal(err)
+		}
+		return result, len(pods.Items)
+	}
+
+	// The first observation records the strategy without a rollout
+	if _, pods := reconcile(); pods != 8 {
+		t.Fatalf("Expected no evictions without drift, %d pods left", pods)
+	}
+
+	// Moving most pods to on-demand waits for the grace period
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Annotations[webhook.ScheduleStrategyAnnotation] = sstesting.Strategy(0).Rule(3, onDemand).Rule(1, spot).String()
+	if err := c.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	result, pods := reconcile()
+	if pods != 8 {
+		t.Errorf("Expected no evictions within the grace period, %d pods left", pods)
+	}
+	if result.RequeueAfter <= 50*time.Minute || result.RequeueAfter > time.Hour {
+		t.Errorf("RequeueAfter = %s, want the rest of the 1h grace period", result.RequeueAfter)
+	}
+
+	// Once it ends, evictions stop at the budget of 3, at most 2 at a time as the rolling update allows
+	rebalancer.SetStrategyChangeLimits(0, 3)
+	if _, pods := reconcile(); pods != 6 {
+		t.Errorf("Expected 2 pods evicted, %d pods left", pods)
+	}
+	if _, pods := reconcile(); pods != 5 {
+		t.Errorf("Expected the 3rd pod evicted, %d pods left", pods)
+	}
+	result, pods = reconcile()
+	if pods != 5 {
+		t.Errorf("Expected no evictions past the budget, %d pods left", pods)
+	}
+	if result.RequeueAfter != 10*time.Minute {
+		t.Errorf("RequeueAfter = %s, want 10m with the budget used up", result.RequeueAfter)
+	}
+
+	// The bookkeeping stays off the deployment
+	state := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "smart-scheduler-web"}, state); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(state.Data["strategy-rollout"], `"evictions":3`) {
+		t.Errorf("Expected 3 evictions in the placement state, got %q", state.Data["strategy-rollout"])
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	for annotation := range deployment.Annotations {
+		if strings.HasPrefix(annotation, "smart-scheduler.io/rebalance-") {
+			t.Errorf("Expected no rollout annotations on the deployment, got %s", annotation)
+		}
+	}
+}
+
+func TestManualOverrideDefersRebalancing(t *testing.T) {
+	workload := sstesting.NewWorkload("default", "web", sstesting.Strategy(0).Rule(3, onDemand).Rule(1, spot).String()).
+		WithPods(2, onDemand).
+		WithPods(6, spot)
+	workload.Deployment.Status = appsv1.DeploymentStatus{Replicas: 8, UpdatedReplicas: 8, ReadyReplicas: 8, AvailableReplicas: 8}
+	workload.Deployment.Annotations["smart-scheduler.io/manual-override"] = "true"
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	// Cordon events are listed straight from the API server, which selects on several event fields
+	// at once where the fake client supports only one
+	events := func(objects ...client.Object) client.Reader {
+		return interceptor.NewClient(fake.NewClientBuilder().WithScheme(cluster.Scheme()).WithObjects(objects...).Build(), interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				options := (&client.ListOptions{}).ApplyOptions(opts)
+				if err := c.List(ctx, list); err != nil {
+					return err
+				}
+				eventList := list.(*corev1.EventList)
+				var selected []corev1.Event
+				for _, event := range eventList.Items {
+					if options.FieldSelector.Matches(fields.Set{"involvedObject.kind": event.InvolvedObject.Kind, "reason": event.Reason}) {
+						selected = append(selected, event)
+					}
+				}
+				eventList.Items = selected
+				return nil
+			},
+		})
+	}
+	cordon := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "spot-0.cordon"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: "spot-0"},
+		Reason:         "NodeNotSchedulable",
+		LastTimestamp:  metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+	}
+
+	log := logr.Discard()
+	rebalancer := &controllers.RebalanceController{
+		Client:          c,
+		Log:             log,
+		Scheme:          cluster.Scheme(),
+		StateManager:    webhook.NewStateManager(c, log),
+		PoolHealth:      webhook.NewPoolHealthChecker(c, log),
+		Maintenance:     webhook.NewMaintenanceTracker(c, log),
+		ManualOverrides: controllers.NewManualOverrideTracker(c, events(cordon), 30*time.Minute, log),
+	}
+	reconcile := func() (ctrl.Result, int) {
+		t.Helper()
+		result, err := rebalancer.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}})
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace("default")); err != nil {
+			t.Fatal(err)
+		}
+		return result, len(pods.Items)
+	}
+	getDeployment := func() *appsv1.Deployment {
+		t.Helper()
+		deployment := &appsv1.Deployment{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, deployment); err != nil {
+			t.Fatal(err)
+		}
+		return deployment
+	}
+
+	// The annotation starts the window and is replaced with its start time
+	result, pods := reconcile()
+	if pods != 8 {
+		t.Errorf("Expected no evictions within the override window, %d pods left", pods)
+	}
+	if result.RequeueAfter <= 29*time.Minute || result.RequeueAfter > 30*time.Minute {
+		t.Errorf("RequeueAfter = %s, want the rest of the 30m window", result.RequeueAfter)
+	}
+	deployment := getDeployment()
+	if _, err := time.Parse(time.RFC3339, deployment.Annotations["smart-scheduler.io/manual-override"]); err != nil {
+		t.Errorf("Expected the window start recorded on the deployment, got %q", deployment.Annotations["smart-scheduler.io/manual-override"])
+	}
+
+	// An expired window is removed, and a recently cordoned spot node holds the rest of its own window
+	deployment.Annotations["smart-scheduler.io/manual-override"] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if err := c.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	result, pods = reconcile()
+	if pods != 8 {
+		t.Errorf("Expected no evictions after a recent cordon, %d pods left", pods)
+	}
+	if result.RequeueAfter <= 19*time.Minute || result.RequeueAfter > 20*time.Minute {
+		t.Errorf("RequeueAfter = %s, want the rest of the cordon's 30m window", result.RequeueAfter)
+	}
+	if value, ok := getDeployment().Annotations["smart-scheduler.io/manual-override"]; ok {
+		t.Errorf("Expected the expired override removed, got %q", value)
+	}
+
+	// Without an intervention, rebalancing resumes
+	rebalancer.ManualOverrides = controllers.NewManualOverrideTracker(c, events(), 30*time.Minute, log)
+	if _, pods := reconcile(); pods >= 8 {
+		t.Errorf("Expected rebalancing to evict spot pods, %d pods left", pods)
+	}
+}
+
+func TestRuleDisruptionBudgetGuardsTheBase(t *testing.T) {
+	workload := sstesting.NewWorkload("default", "web", sstesting.Strategy(2).Rule(1, onDemand).Rule(3, spot).String())
+	workload.Deployment.Annotations[webhook.RuleDisruptionBudgetAnnotation] = "true"
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithWorkload(workload)
+	c := cluster.Build()
+	ctx := context.Background()
+
+	// The webhook labels the pods the budget selects
+	pod := workload.PendingPod()
+	req, err := sstesting.CreatePodRequest(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted, err := sstesting.AdmittedPod(pod, newMutator(t, c, cluster.Scheme()).Handle(ctx, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := admitted.Labels[webhook.RuleLabel]; got != "ondemand" {
+		t.Errorf("Expected the base pod labelled %s=ondemand, got %q", webhook.RuleLabel, got)
+	}
+
+	budgets := &controllers.RuleDisruptionBudgetController{Client: c, Log: logr.Discard(), Scheme: cluster.Scheme()}
+	reconcile := func() []policyv1.PodDisruptionBudget {
+		t.Helper()
+		if _, err := budgets.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		list := &policyv1.PodDisruptionBudgetList{}
+		if err := c.List(ctx, list, client.InNamespace("default")); err != nil {
+			t.Fatal(err)
+		}
+		return list.Items
+	}
+
+	list := reconcile()
+	if len(list) != 1 {
+		t.Fatalf("Expected one rule PodDisruptionBudget, got %d", len(list))
+	}
+	if got := list[0].Spec.MinAvailable.IntValue(); got != 2 {
+		t.Errorf("minAvailable = %d, want the base of 2", got)
+	}
+	if want := map[string]string{"app": "web", webhook.RuleLabel: "ondemand"}; !equality.Semantic.DeepEqual(list[0].Spec.Selector.MatchLabels, want) {
+		t.Errorf("selector = %v, want %v", list[0].Spec.Selector.MatchLabels, want)
+	}
+	if !metav1.IsControlledBy(&list[0], workload.Deployment) {
+		t.Error("Expected the budget owned by the deployment")
+	}
+
+	// A new base updates the budget in place
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Annotations[webhook.ScheduleStrategyAnnotation] = sstesting.Strategy(3).Rule(1, onDemand).Rule(3, spot).String()
+	if err := c.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	updated := reconcile()
+	if len(updated) != 1 || updated[0].Name != list[0].Name || updated[0].Spec.MinAvailable.IntValue() != 3 {
+		t.Errorf("Expected %s updated to minAvailable 3, got %+v", list[0].Name, updated)
+	}
+
+	// Opting out deletes it
+	delete(deployment.Annotations, webhook.RuleDisruptionBudgetAnnotation)
+	if err := c.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := reconcile(); len(remaining) != 0 {
+		t.Errorf("Expected the budget deleted, got %d", len(remaining))
+	}
+}
+
+func TestSchedulerPluginPlacesPodsByStrategy(t *testing.T) {
+	workload := sstesting.NewWorkload("shop", "orders", sstesting.Strategy(1).Rule(1, onDemand).Rule(3, spot).String())
+	cluster := sstesting.NewCluster().
+		WithNodes("ondemand", 2, onDemand).
+		WithNodes("spot", 2, spot).
+		WithNodes("gpu", 1, map[string]string{"node-type": "gpu"}).
+		WithWorkload(workload)
+	c := cluster.Build()
+	ctx := context.Background()
+	placer := &schedulerplugin.Placer{
+		Client:       c,
+		Log:          logr.Discard(),
+		StateManager: webhook.NewStateManager(c, logr.Discard()),
+		PoolHealth:   webhook.NewPoolHealthChecker(c, logr.Discard()),
+	}
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each pod is scheduled as kube-scheduler would: filtered, bound to its best scoring node and recorded
+	placed := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		pod := workload.PendingPod()
+		pod.Name = fmt.Sprintf("orders-%d", i)
+		pod.UID = types.UID(pod.Name)
+		pod.Status.Phase = corev1.PodPending
+		if err := c.Create(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+		decided, err := placer.Decide(ctx, pod)
+		if err != nil || decided == nil {
+			t.Fatalf("pod %d: Decide() = %v, %v", i, decided, err)
+		}
+
+		var best *corev1.Node
+		for j := range nodes.Items {
+			node := &nodes.Items[j]
+			if decided.Filter(node) && (best == nil || decided.Score(node) > decided.Score(best)) {
+				best = node
+			}
+		}
+		if best.Labels["node-type"] == "gpu" {
+			t.Fatalf("pod %d bound to %s, outside the strategy's pools", i, best.Name)
+		}
+		placer.Assume(pod, decided, best)
+		if err := placer.Record(ctx, pod, decided, best); err != nil {
+			t.Fatalf("pod %d: Record() error = %v", i, err)
+		}
+		placed[best.Labels["node-type"]]++
+	}
+	if placed["ondemand"] != 3 || placed["spot"] != 6 {
+		t.Errorf("placed = %v, want 3 ondemand (base 1 + 2 weighted) and 6 spot", placed)
+	}
+
+	// The pods keep their template's nodeSelector and are counted by their recorded rule
+	pods, err := webhook.ListStrategyPods(ctx, c, workload.Deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := webhook.ParsePlacementStrategy(workload.Deployment.Annotations[webhook.ScheduleStrategyAnnotation])
+	counts := webhook.CountPodsByRule(pods, strategy)
+	if counts["node-type=ondemand"] != 3 || counts["node-type=spot"] != 6 {
+		t.Errorf("CountPodsByRule() = %v, want the recorded rules", counts)
+	}
+	state, err := placer.StateManager.ReadPlacementState(ctx, "shop", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.PodCounts["node-type=ondemand"] != 3 || state.PodCounts["node-type=spot"] != 6 {
+		t.Errorf("placement state counts = %v, want the recorded rules", state.PodCounts)
+	}
+}
@@ -0,0 +1,373 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/kube-smartscheduler/smart-scheduler/webhook"
+)
+
+// simulatorRequeue is how soon the Simulator checks again on a deployment that has not settled
+const simulatorRequeue = 200 * time.Millisecond
+
+// podDeletionCostAnnotation ranks the pods the ReplicaSet controller removes first on scale-down
+const podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+
+// Simulator stands in for the components envtest does not run, for deployments whose pods it
+// manages: the deployment and ReplicaSet controllers keep the ReplicaSet's pods at the deployment's
+// replicas and report its status, the scheduler binds pending pods to a node their nodeSelector
+// selects, and the kubelet runs bound pods and finishes deleting them. Pods it creates go through
+// the API server, and so through the webhook of an environment started with
+// StartEnvironmentWithWebhook.
+type Simulator struct {
+	Client client.Client
+	// Reader lists pods and ReplicaSets from the API server, so a lagging cache does not make the
+	// Simulator create or remove pods twice
+	Reader client.Reader
+}
+
+// SetupWithManager runs the Simulator in the manager
+func (s *Simulator) SetupWithManager(mgr ctrl.Manager) error {
+	s.Client = mgr.GetClient()
+	s.Reader = mgr.GetAPIReader()
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("simulator").
+		For(&appsv1.Deployment{}).
+		Owns(&appsv1.ReplicaSet{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(s.podDeployment)).
+		Complete(s)
+}
+
+// podDeployment maps a pod to the deployment owning its ReplicaSet
+func (s *Simulator) podDeployment(ctx context.Context, obj client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return nil
+	}
+	rs := &appsv1.ReplicaSet{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: owner.Name}, rs); err != nil {
+		return nil
+	}
+	if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == "Deployment" {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: rs.Namespace, Name: owner.Name}}}
+	}
+	return nil
+}
+
+// Reconcile moves the deployment's pods one step toward its replicas
+func (s *Simulator) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	deployment := &appsv1.Deployment{}
+	if err := s.Client.Get(ctx, req.NamespacedName, deployment); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	rs, err := s.replicaSet(ctx, deployment)
+	if err != nil || rs == nil {
+		return ctrl.Result{}, err
+	}
+
+	// Deployment controller: the ReplicaSet follows the deployment's replicas
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if rs.Spec.Replicas == nil || *rs.Spec.Replicas != replicas {
+		rs.Spec.Replicas = &replicas
+		if err := s.Client.Update(ctx, rs); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	pods, err := s.pods(ctx, rs)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	settled := true
+	var active []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		switch {
+		case pod.DeletionTimestamp != nil:
+			// Kubelet: the containers stopped, the pod can go
+			if err := s.Client.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			settled = false
+		case pod.Spec.NodeName == "":
+			// Scheduler: bind to a node the nodeSelector selects, or leave Pending
+			bound, err := s.bind(ctx, pod)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			settled = settled && !bound
+			active = append(active, pod)
+		case pod.Status.Phase != corev1.PodRunning:
+			// Kubelet: start the pod
+			if err := s.run(ctx, pod); err != nil {
+				return ctrl.Result{}, err
+			}
+			settled = false
+			active = append(active, pod)
+		default:
+			active = append(active, pod)
+		}
+	}
+
+	// ReplicaSet controller: create missing pods, and remove the cheapest pods beyond the replicas
+	for i := len(active); i < int(replicas); i++ {
+		if err := s.Client.Create(ctx, newPod(rs)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create pod of %s: %w", rs.Name, err)
+		}
+		settled = false
+	}
+	if excess := len(active) - int(replicas); excess > 0 {
+		sort.SliceStable(active, func(i, j int) bool { return scaleDownBefore(active[i], active[j]) })
+		for _, pod := range active[:excess] {
+			if err := s.Client.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		active = active[excess:]
+		settled = false
+	}
+
+	if err := s.updateStatus(ctx, deployment, active); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !settled {
+		return ctrl.Result{RequeueAfter: simulatorRequeue}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// replicaSet returns the ReplicaSet the deployment controls, or nil when it has none
+func (s *Simulator) replicaSet(ctx context.Context, deployment *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	list := &appsv1.ReplicaSetList{}
+	if err := s.Reader.List(ctx, list, client.InNamespace(deployment.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if owner := metav1.GetControllerOf(&list.Items[i]); owner != nil && owner.UID == deployment.UID {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// pods returns the pods the ReplicaSet controls
+func (s *Simulator) pods(ctx context.Context, rs *appsv1.ReplicaSet) ([]corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(rs.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.PodList{}
+	if err := s.Reader.List(ctx, list, client.InNamespace(rs.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range list.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.UID == rs.UID {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// bind binds the pod to the node its nodeSelector selects running the fewest pods, reporting
+// whether one did
+func (s *Simulator) bind(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	nodes := &corev1.NodeList{}
+	if err := s.Client.List(ctx, nodes); err != nil {
+		return false, err
+	}
+	pods := &corev1.PodList{}
+	if err := s.Client.List(ctx, pods); err != nil {
+		return false, err
+	}
+	running := make(map[string]int)
+	for _, p := range pods.Items {
+		running[p.Spec.NodeName]++
+	}
+
+	selector := labels.SelectorFromSet(pod.Spec.NodeSelector)
+	target := ""
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable || !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if target == "" || running[node.Name] < running[target] {
+			target = node.Name
+		}
+	}
+	if target == "" {
+		return false, nil
+	}
+
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: target},
+	}
+	if err := s.Client.SubResource("binding").Create(ctx, pod, binding); err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to bind pod %s: %w", pod.Name, err)
+	}
+	return true, nil
+}
+
+// run marks the bound pod running and ready
+func (s *Simulator) run(ctx context.Context, pod *corev1.Pod) error {
+	now := metav1.Now()
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.StartTime = &now
+	pod.Status.Conditions = []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: now},
+		{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: now},
+	}
+	if err := s.Client.Status().Update(ctx, pod); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to start pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// updateStatus reports the deployment's pods as its status, the running ones as available
+func (s *Simulator) updateStatus(ctx context.Context, deployment *appsv1.Deployment, active []*corev1.Pod) error {
+	running := int32(0)
+	for _, pod := range active {
+		if pod.Status.Phase == corev1.PodRunning {
+			running++
+		}
+	}
+	status := appsv1.DeploymentStatus{
+		ObservedGeneration: deployment.Generation,
+		Replicas:           int32(len(active)),
+		UpdatedReplicas:    int32(len(active)),
+		ReadyReplicas:      running,
+		AvailableReplicas:  running,
+	}
+	if equality.Semantic.DeepEqual(deployment.Status, status) {
+		return nil
+	}
+	deployment.Status = status
+	return s.Client.Status().Update(ctx, deployment)
+}
+
+// newPod returns a new pod of the ReplicaSet as its controller creates it
+func newPod(rs *appsv1.ReplicaSet) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: rs.Name + "-",
+			Namespace:    rs.Namespace,
+			Labels:       copyLabels(rs.Spec.Template.Labels),
+			Annotations:  copyLabels(rs.Spec.Template.Annotations),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+			}},
+		},
+		Spec: *rs.Spec.Template.Spec.DeepCopy(),
+	}
+}
+
+// scaleDownBefore reports whether the ReplicaSet controller removes a before b: unscheduled pods
+// first, then pods of lower deletion cost
+func scaleDownBefore(a, b *corev1.Pod) bool {
+	if (a.Spec.NodeName == "") != (b.Spec.NodeName == "") {
+		return a.Spec.NodeName == ""
+	}
+	return deletionCost(a) < deletionCost(b)
+}
+
+// deletionCost returns the pod's deletion cost, 0 when it has none
+func deletionCost(pod *corev1.Pod) int {
+	cost, err := strconv.Atoi(pod.Annotations[podDeletionCostAnnotation])
+	if err != nil {
+		return 0
+	}
+	return cost
+}
+
+// CreateNodes creates count ready nodes carrying labels, named after prefix
+func (e *Environment) CreateNodes(t TB, prefix string, count int, labels map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < count; i++ {
+		node := Node(prefixedName(prefix, i), labels)
+		// Create drops the status, which carries the Ready condition
+		status := node.Status
+		if err := e.Client.Create(ctx, node); err != nil {
+			t.Fatalf("failed to create node %s: %v", node.Name, err)
+		}
+		node.Status = status
+		if err := e.Client.Status().Update(ctx, node); err != nil {
+			t.Fatalf("failed to mark node %s ready: %v", node.Name, err)
+		}
+	}
+}
+
+// CreateWorkload creates the workload's deployment and ReplicaSet with replicas. The workload's
+// objects are updated with the server-assigned UIDs.
+func (e *Environment) CreateWorkload(t TB, w *Workload, replicas int32) {
+	t.Helper()
+	ctx := context.Background()
+	*w.Deployment.Spec.Replicas = replicas
+	if err := e.Client.Create(ctx, w.Deployment); err != nil {
+		t.Fatalf("failed to create deployment %s: %v", w.Deployment.Name, err)
+	}
+	// The ReplicaSet must reference the deployment's server-assigned UID
+	w.ReplicaSet.OwnerReferences[0].UID = w.Deployment.UID
+	if err := e.Client.Create(ctx, w.ReplicaSet); err != nil {
+		t.Fatalf("failed to create ReplicaSet %s: %v", w.ReplicaSet.Name, err)
+	}
+}
+
+// PlacedPods counts the running pods of the workload by the value of their nodeSelector's key
+func (e *Environment) PlacedPods(ctx context.Context, w *Workload, key string) (map[string]int, error) {
+	selector, err := webhook.DeploymentPodSelector(w.Deployment)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := e.Client.List(ctx, pods, client.InNamespace(w.Deployment.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	placed := make(map[string]int)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning {
+			placed[pod.Spec.NodeSelector[key]]++
+		}
+	}
+	return placed, nil
+}
+
+// Eventually calls condition every interval until it holds, returns an error or ctx ends
+func Eventually(ctx context.Context, interval time.Duration, condition func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done, err := condition()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Command kube-scheduler is kube-scheduler with the SmartScheduler plugin registered. Enable it in a
+// profile of the scheduler's configuration, see the README's "Scheduler Plugin Mode".
+package main
+
+import (
+	"os"
+
+	"k8s.io/component-base/cli"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/schedulerplugin"
+)
+
+func main() {
+	command := app.NewSchedulerCommand(app.WithPlugin(schedulerplugin.Name, New))
+	os.Exit(cli.Run(command))
+}
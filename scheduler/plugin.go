@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/kube-smartscheduler/smart-scheduler/pkg/schedulerplugin"
+)
+
+// stateKey is the cycle state key the pod's decision is kept under between extension points
+const stateKey framework.StateKey = schedulerplugin.Name
+
+// Plugin adapts a schedulerplugin.Placer to the scheduler framework: PreFilter decides the pod's
+// rule, Filter keeps the nodes of the strategy's rules, Score prefers the nodes of the decided rule,
+// Reserve and Unreserve count the pod while it binds and PreBind records its rule
+type Plugin struct {
+	handle framework.Handle
+	placer *schedulerplugin.Placer
+}
+
+var (
+	_ framework.PreFilterPlugin = (*Plugin)(nil)
+	_ framework.FilterPlugin    = (*Plugin)(nil)
+	_ framework.ScorePlugin     = (*Plugin)(nil)
+	_ framework.ReservePlugin   = (*Plugin)(nil)
+	_ framework.PreBindPlugin   = (*Plugin)(nil)
+)
+
+// New creates the plugin, reading the cluster with the scheduler's kubeconfig
+func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	placer, err := schedulerplugin.NewPlacer(context.Background(), handle.KubeConfig(), klog.Background().WithName(schedulerplugin.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &Plugin{handle: handle, placer: placer}, nil
+}
+
+// Name returns the plugin's name
+func (p *Plugin) Name() string {
+	return schedulerplugin.Name
+}
+
+// decisionState holds a pod's decision for the rest of its scheduling cycle
+type decisionState struct {
+	decision *schedulerplugin.Decision
+}
+
+// Clone returns the state itself; a decision is never modified once made
+func (s *decisionState) Clone() framework.StateData {
+	return s
+}
+
+// PreFilter decides the pod's rule. Pods without a strategy skip the plugin.
+func (p *Plugin) PreFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	decision, err := p.placer.Decide(ctx, pod)
+	if err != nil {
+		// Like the webhook, a pod that cannot be placed by its strategy is left to the other plugins
+		klog.FromContext(ctx).Error(err, "Failed to decide placement, scheduling without the strategy", "pod", klog.KObj(pod))
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	if decision == nil {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	state.Write(stateKey, &decisionState{decision: decision})
+	return nil, nil
+}
+
+// PreFilterExtensions returns nil; the decision does not depend on other pods on the node
+func (p *Plugin) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter rejects nodes outside the pools of the strategy's rules
+func (p *Plugin) Filter(_ context.Context, state *framework.CycleState, _ *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	decision := readDecision(state)
+	if decision == nil {
+		return nil
+	}
+	if node := nodeInfo.Node(); node == nil || !decision.Filter(node) {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, "node is in the pool of no rule of the placement strategy")
+	}
+	return nil
+}
+
+// Score prefers the nodes of the decided rule
+func (p *Plugin) Score(_ context.Context, state *framework.CycleState, _ *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	decision := readDecision(state)
+	if decision == nil {
+		return 0, nil
+	}
+	node, err := p.node(nodeName)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	return decision.Score(node), nil
+}
+
+// ScoreExtensions returns nil; scores are already between 0 and MaxNodeScore
+func (p *Plugin) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+// Reserve counts the pod on its node's rule for the pods decided while it binds
+func (p *Plugin) Reserve(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	decision := readDecision(state)
+	if decision == nil {
+		return nil
+	}
+	node, err := p.node(nodeName)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	p.placer.Assume(pod, decision, node)
+	return nil
+}
+
+// Unreserve stops counting a pod whose binding failed
+func (p *Plugin) Unreserve(_ context.Context, _ *framework.CycleState, pod *corev1.Pod, _ string) {
+	p.placer.Forget(pod)
+}
+
+// PreBind records the rule of the pod's node on the pod and in the placement state
+func (p *Plugin) PreBind(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	decision := readDecision(state)
+	if decision == nil {
+		return nil
+	}
+	node, err := p.node(nodeName)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	if err := p.placer.Record(ctx, pod, decision, node); err != nil {
+		return framework.AsStatus(err)
+	}
+	return nil
+}
+
+// node returns the named node from the scheduler's snapshot
+func (p *Plugin) node(nodeName string) (*corev1.Node, error) {
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s from snapshot: %w", nodeName, err)
+	}
+	if nodeInfo.Node() == nil {
+		return nil, fmt.Errorf("node %s not found", nodeName)
+	}
+	return nodeInfo.Node(), nil
+}
+
+// readDecision returns the pod's decision, or nil when the plugin does not place it
+func readDecision(state *framework.CycleState) *schedulerplugin.Decision {
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return nil
+	}
+	s, ok := data.(*decisionState)
+	if !ok {
+		return nil
+	}
+	return s.decision
+}
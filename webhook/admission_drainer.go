@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultDrainTimeout bounds how long shutdown waits for in-flight admissions
+const DefaultDrainTimeout = 20 * time.Second
+
+// AdmissionDrainer tracks in-flight admissions so a shutting down manager stops being ready for
+// new ones and waits for those already running. The manager stops its runnables concurrently, so
+// the StateManager's final flush waits for Drained rather than for the drainer to be stopped first.
+// It implements manager.Runnable; a nil drainer tracks nothing.
+type AdmissionDrainer struct {
+	Log logr.Logger
+	// Timeout bounds the wait for in-flight admissions (default: DefaultDrainTimeout)
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	draining bool
+	// idle is closed when the last in-flight admission finishes while draining
+	idle chan struct{}
+	// drained is closed when Start returns
+	drained chan struct{}
+}
+
+// NewAdmissionDrainer returns a drainer waiting at most timeout for in-flight admissions
+func NewAdmissionDrainer(timeout time.Duration, log logr.Logger) *AdmissionDrainer {
+	return &AdmissionDrainer{Log: log, Timeout: timeout}
+}
+
+// track records an admission as in flight until the returned function is called
+func (d *AdmissionDrainer) track() func() {
+	if d == nil {
+		return func() {}
+	}
+	d.mu.Lock()
+	d.inFlight++
+	d.mu.Unlock()
+
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.inFlight--
+		if d.draining && d.inFlight == 0 && d.idle != nil {
+			close(d.idle)
+			d.idle = nil
+		}
+	}
+}
+
+// Drained returns a channel closed once shutdown drained the in-flight admissions, or gave up
+// after the timeout. It is closed already for a nil drainer.
+func (d *AdmissionDrainer) Drained() <-chan struct{} {
+	if d == nil {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+	return d.drainedChan()
+}
+
+// drainedChan returns the channel Start closes when it returns
+func (d *AdmissionDrainer) drainedChan() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.drained == nil {
+		d.drained = make(chan struct{})
+	}
+	return d.drained
+}
+
+// Start waits for the manager to stop, then drains in-flight admissions
+func (d *AdmissionDrainer) Start(ctx context.Context) error {
+	defer close(d.drainedChan())
+	<-ctx.Done()
+
+	d.mu.Lock()
+	d.draining = true
+	inFlight := d.inFlight
+	var idle chan struct{}
+	if inFlight > 0 {
+		idle = make(chan struct{})
+		d.idle = idle
+	}
+	d.mu.Unlock()
+
+	if idle == nil {
+		d.Log.Info("No admissions in flight at shutdown")
+		return nil
+	}
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	d.Log.Info("Draining in-flight admissions", "inFlight", inFlight, "timeout", timeout.String())
+
+	select {
+	case <-idle:
+		d.Log.Info("Drained in-flight admissions")
+	case <-time.After(timeout):
+		d.mu.Lock()
+		remaining := d.inFlight
+		d.mu.Unlock()
+		d.Log.Info("Timed out draining admissions", "remaining", remaining)
+	}
+	return nil
+}
+
+// NeedLeaderElection returns false because every replica serves admissions
+func (d *AdmissionDrainer) NeedLeaderElection() bool {
+	return false
+}
+
+// Checker is a readiness check that fails once shutdown began, so no new admissions are routed here
+func (d *AdmissionDrainer) Checker(_ *http.Request) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return errors.New("shutting down, draining in-flight admissions")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package webhook
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultAdmissionQueueMaxWait bounds how long a high-priority admission waits for a slot
+	DefaultAdmissionQueueMaxWait = 2 * time.Second
+	// DefaultHighPriorityThreshold is the pod priority from which admissions wait for a slot
+	DefaultHighPriorityThreshold = 1000
+	// DefaultHighPolicyPriority is the PodPlacementPolicy priority from which admissions wait for a slot
+	DefaultHighPolicyPriority = 100
+
+	// policyPriorityAnnotation is set on deployments by the PodPlacementPolicy controller
+	policyPriorityAnnotation = "smart-scheduler.io/policy-priority"
+)
+
+var (
+	admissionQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smart_scheduler_admission_queue_depth",
+		Help: "Number of high-priority admissions waiting for a placement slot",
+	})
+	admissionQueueDegraded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smart_scheduler_admission_queue_degraded_total",
+			Help: "Admissions placed with informer pod counts because the webhook was overloaded, by reason (low_priority or timeout)",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(admissionQueueDepth, admissionQueueDegraded)
+}
+
+// AdmissionPriority orders admissions waiting for a placement slot: by pod priority, then by the
+// priority of the PodPlacementPolicy that set the deployment's strategy
+type AdmissionPriority struct {
+	Pod    int32
+	Policy int32
+}
+
+// AdmissionPriorityOf returns the priority of a pod's admission
+func AdmissionPriorityOf(pod *corev1.Pod, deployment *appsv1.Deployment) AdmissionPriority {
+	var priority AdmissionPriority
+	if pod.Spec.Priority != nil {
+		priority.Pod = *pod.Spec.Priority
+	}
+	if value, ok := deployment.Annotations[policyPriorityAnnotation]; ok {
+		if policy, err := strconv.ParseInt(value, 10, 32); err == nil {
+			priority.Policy = int32(policy)
+		}
+	}
+	return priority
+}
+
+// before reports whether p is served before other
+func (p AdmissionPriority) before(other AdmissionPriority) bool {
+	if p.Pod != other.Pod {
+		return p.Pod > other.Pod
+	}
+	return p.Policy > other.Policy
+}
+
+// admissionWaiter is an admission queued for a slot; granted is closed when it gets one
+type admissionWaiter struct {
+	priority AdmissionPriority
+	seq      uint64
+	granted  chan struct{}
+	index    int
+}
+
+// waiterHeap orders waiters by priority, then by arrival
+type waiterHeap []*admissionWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority.before(h[j].priority)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *waiterHeap) Push(x interface{}) {
+	waiter := x.(*admissionWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	waiter.index = -1
+	return waiter
+}
+
+// AdmissionQueue limits how many admissions use the placement state store at once. When every slot
+// is taken, high-priority admissions wait for one in priority order, while low-priority admissions
+// degrade right away to stateless placement from informer pod counts. A nil queue admits everything.
+type AdmissionQueue struct {
+	// MaxInFlight is the number of admissions placed with the state store at once
+	MaxInFlight int
+	// MaxWait bounds how long a high-priority admission waits before it degrades too
+	MaxWait time.Duration
+	// HighPriority is the pod priority from which admissions wait for a slot
+	HighPriority int32
+	// HighPolicyPriority is the policy priority from which admissions wait for a slot
+	HighPolicyPriority int32
+	Log                logr.Logger
+
+	mu       sync.Mutex
+	inFlight int
+	seq      uint64
+	waiters  waiterHeap
+}
+
+// NewAdmissionQueue creates a queue with maxInFlight slots and the default priority thresholds
+func NewAdmissionQueue(maxInFlight int, maxWait time.Duration, log logr.Logger) *AdmissionQueue {
+	if maxWait <= 0 {
+		maxWait = DefaultAdmissionQueueMaxWait
+	}
+	return &AdmissionQueue{
+		MaxInFlight:        maxInFlight,
+		MaxWait:            maxWait,
+		HighPriority:       DefaultHighPriorityThreshold,
+		HighPolicyPriority: DefaultHighPolicyPriority,
+		Log:                log,
+	}
+}
+
+// high reports whether the admission may wait for a slot
+func (q *AdmissionQueue) high(priority AdmissionPriority) bool {
+	return priority.Pod >= q.HighPriority || priority.Policy >= q.HighPolicyPriority
+}
+
+// Acquire takes a slot for the admission. It returns false when the admission should degrade to
+// stateless placement; otherwise the returned function must be called to release the slot.
+func (q *AdmissionQueue) Acquire(ctx context.Context, priority AdmissionPriority) (func(), bool) {
+	if q == nil || q.MaxInFlight <= 0 {
+		return func() {}, true
+	}
+
+	q.mu.Lock()
+	if q.inFlight < q.MaxInFlight && len(q.waiters) == 0 {
+		q.inFlight++
+		q.mu.Unlock()
+		return q.release, true
+	}
+	if !q.high(priority) {
+		q.mu.Unlock()
+		admissionQueueDegraded.WithLabelValues("low_priority").Inc()
+		return nil, false
+	}
+	q.seq++
+	waiter := &admissionWaiter{priority: priority, seq: q.seq, granted: make(chan struct{})}
+	heap.Push(&q.waiters, waiter)
+	admissionQueueDepth.Set(float64(len(q.waiters)))
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.MaxWait)
+	defer timer.Stop()
+	select {
+	case <-waiter.granted:
+		return q.release, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if waiter.index < 0 {
+		// The slot was granted while giving up
+		return q.release, true
+	}
+	heap.Remove(&q.waiters, waiter.index)
+	admissionQueueDepth.Set(float64(len(q.waiters)))
+	admissionQueueDegraded.WithLabelValues("timeout").Inc()
+	return nil, false
+}
+
+// release hands the slot to the highest-priority waiter, or frees it
+func (q *AdmissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) > 0 {
+		waiter := heap.Pop(&q.waiters).(*admissionWaiter)
+		admissionQueueDepth.Set(float64(len(q.waiters)))
+		close(waiter.granted)
+		return
+	}
+	q.inFlight--
+}
@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// BaseFromPDB derives a strategy's base from the PodDisruptionBudget covering the deployment's pods
+const BaseFromPDB = "pdb"
+
+// ResolveBase returns the strategy with the base sized for the deployment's current replicas, or the
+// strategy itself when its base is fixed. A percentage base is scaled to the replicas; a base derived
+// from a PodDisruptionBudget takes precedence when one exists. Cached strategies are never modified;
+// a copy is returned instead.
+func ResolveBase(ctx context.Context, c client.Client, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	if strategy.BasePercent == 0 && strategy.BaseFrom != BaseFromPDB {
+		return strategy, nil
+	}
+
+	resolved := *strategy
+	if strategy.BasePercent > 0 {
+		resolved.Base = PercentBase(strategy.BasePercent, int(deploymentReplicas(deployment)))
+	}
+	if strategy.BaseFrom == BaseFromPDB {
+		base, found, err := PDBBase(ctx, c, deployment)
+		if err != nil {
+			return &resolved, err
+		}
+		if found {
+			resolved.Base = base
+		}
+	}
+	return &resolved, nil
+}
+
+// PercentBase returns percent of replicas, rounded up so a non-zero percentage always protects a pod
+func PercentBase(percent, replicas int) int {
+	return (percent*replicas + 99) / 100
+}
+
+// PDBBase returns the number of pods the PodDisruptionBudgets selecting the deployment's pods keep
+// available at its desired replicas: minAvailable, or replicas minus maxUnavailable. With several
+// budgets the strictest one wins. It reports false when no budget selects the pods.
+func PDBBase(ctx context.Context, c client.Client, deployment *appsv1.Deployment) (int, bool, error) {
+	pdbList := &policyv1.PodDisruptionBudgetList{}
+	if err := c.List(ctx, pdbList, client.InNamespace(deployment.Namespace)); err != nil {
+		return 0, false, fmt.Errorf("failed to list PodDisruptionBudgets: %w", err)
+	}
+
+	replicas := int(deploymentReplicas(deployment))
+	podLabels := labels.Set(deployment.Spec.Template.Labels)
+	base, found := 0, false
+	for i := range pdbList.Items {
+		pdb := &pdbList.Items[i]
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(podLabels) {
+			continue
+		}
+
+		available, err := pdbAvailable(pdb, replicas)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid PodDisruptionBudget %s: %w", pdb.Name, err)
+		}
+		if !found || available > base {
+			base, found = available, true
+		}
+	}
+	return base, found, nil
+}
+
+// pdbAvailable returns how many of replicas pods the budget keeps available. Percentages round up,
+// as the disruption controller rounds them.
+func pdbAvailable(pdb *policyv1.PodDisruptionBudget, replicas int) (int, error) {
+	var available int
+	switch {
+	case pdb.Spec.MinAvailable != nil:
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MinAvailable, replicas, true)
+		if err != nil {
+			return 0, err
+		}
+		available = minAvailable
+	case pdb.Spec.MaxUnavailable != nil:
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MaxUnavailable, replicas, true)
+		if err != nil {
+			return 0, err
+		}
+		available = replicas - maxUnavailable
+	}
+
+	if available < 0 {
+		return 0, nil
+	}
+	if available > replicas {
+		return replicas, nil
+	}
+	return available, nil
+}
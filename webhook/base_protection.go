@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// BasePodAnnotation marks pods placed in the base of their strategy's first rule
+	BasePodAnnotation = "smart-scheduler.io/base-pod"
+	// BasePriorityClassAnnotation names the PriorityClass given to a deployment's base pods, so the
+	// scheduler preempts its weighted pods before them
+	BasePriorityClassAnnotation = "smart-scheduler.io/base-priority-class"
+	// basePodDeletionCost makes base pods the last ones removed on scale-down
+	basePodDeletionCost = "100"
+)
+
+// IsBasePlacement reports whether the pod, as placed, fills the base of the strategy: it is on the
+// first rule while that rule holds fewer pods than the base. Failover strategies have no base.
+func IsBasePlacement(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[RuleKey]int) bool {
+	if strategy.IsFailover() || len(strategy.Rules) == 0 || strategy.Base <= 0 {
+		return false
+	}
+	ruleKey, ok := MatchRuleKey(strategy, pod.Spec.NodeSelector)
+	first := strategy.Rules[0].Key()
+	return ok && ruleKey == first && currentCounts[first] < strategy.Base
+}
+
+// protectBasePod marks a pod filling the base so ReplicaSet scale-downs and the rebalancer remove it
+// last, and gives it the deployment's base PriorityClass when that outranks the pod's own priority.
+// Deletion costs set by others are kept, and a missing PriorityClass leaves the priority unchanged.
+func (pm *PodMutator) protectBasePod(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy, currentCounts map[RuleKey]int) {
+	if !pm.BaseProtection || !IsBasePlacement(pod, strategy, currentCounts) {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[BasePodAnnotation] = "true"
+	if _, ok := pod.Annotations[PodDeletionCostAnnotation]; !ok {
+		pod.Annotations[PodDeletionCostAnnotation] = basePodDeletionCost
+	}
+
+	name := deployment.Annotations[BasePriorityClassAnnotation]
+	if name == "" || name == pod.Spec.PriorityClassName {
+		return
+	}
+	class := &schedulingv1.PriorityClass{}
+	if err := pm.Client.Get(ctx, client.ObjectKey{Name: name}, class); err != nil {
+		pm.Log.Error(err, "Not raising the priority of a base pod", "deployment", deployment.Name, "priorityClass", name)
+		return
+	}
+	if pod.Spec.Priority != nil && *pod.Spec.Priority >= class.Value {
+		return
+	}
+	// The priority admission plugin resolved the priority before this webhook, so it is set too
+	priority := class.Value
+	pod.Spec.PriorityClassName = class.Name
+	pod.Spec.Priority = &priority
+	if class.PreemptionPolicy != nil {
+		pod.Spec.PreemptionPolicy = class.PreemptionPolicy
+	}
+}
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Packing modes a rule can select with "packing=<mode>"
+const (
+	// PackingMostAllocated prefers the most utilized nodes of the rule's pool, so emptier nodes
+	// drain and the cluster autoscaler can remove them
+	PackingMostAllocated = "most"
+	// PackingLeastAllocated prefers the least utilized nodes of the rule's pool, spreading pods
+	PackingLeastAllocated = "least"
+)
+
+const (
+	// DefaultUtilizationRefreshInterval is how long the node utilization model is reused
+	DefaultUtilizationRefreshInterval = 30 * time.Second
+	// packingCandidates is how many nodes get a preference term; the weights step down from the first
+	packingCandidates = 5
+	// packingMaxWeight is the weight of the best candidate, below the weight AvoidNodes uses so
+	// unhealthy nodes are avoided before utilization is considered
+	packingMaxWeight = 50
+)
+
+// nodeUsage is the allocatable and requested CPU and memory of a node
+type nodeUsage struct {
+	labels            map[string]string
+	allocatableCPU    int64
+	allocatableMemory int64
+	requestedCPU      int64
+	requestedMemory   int64
+	schedulable       bool
+	// utilization is the mean of the requested CPU and memory shares
+	utilization float64
+}
+
+// NodeUtilization models the share of each node's allocatable CPU and memory requested by its pods.
+// The model is rebuilt from the cached nodes and pods at most every RefreshInterval, and is used to
+// rank the nodes of a rule's pool for bin-packing or spreading.
+type NodeUtilization struct {
+	Client client.Client
+	Log    logr.Logger
+	// RefreshInterval is how long a model is reused before nodes and pods are listed again
+	RefreshInterval time.Duration
+	// ScaleDown, when set, keeps nodes scheduled for removal out of the ranking
+	ScaleDown *ScaleDownTracker
+	// Sidecars, when set, adds the sidecars injected after placement to the size of a pod
+	Sidecars *SidecarTracker
+
+	mu          sync.Mutex
+	nodes       map[string]*nodeUsage
+	refreshedAt time.Time
+}
+
+// NewNodeUtilization creates a node utilization model refreshed every DefaultUtilizationRefreshInterval
+func NewNodeUtilization(client client.Client, log logr.Logger) *NodeUtilization {
+	return &NodeUtilization{
+		Client:          client,
+		Log:             log,
+		RefreshInterval: DefaultUtilizationRefreshInterval,
+	}
+}
+
+// Prefer adds preferred node affinity terms toward the nodes of the rule's pool ranked by the rule's
+// packing mode. Nodes the pod does not fit on are skipped. It is nil-safe and does nothing for rules
+// without a packing mode.
+func (nu *NodeUtilization) Prefer(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, rule PlacementRule) error {
+	if nu == nil || rule.Packing == "" {
+		return nil
+	}
+
+	nodes, err := nu.model(ctx)
+	if err != nil {
+		return err
+	}
+
+	cpu, memory, err := nu.podSize(ctx, pod, deployment)
+	if err != nil {
+		return err
+	}
+	selector := rule.NodeLabelSelector()
+	type candidate struct {
+		name        string
+		utilization float64
+	}
+	var candidates []candidate
+	for name, usage := range nodes {
+		if !usage.schedulable || !selector.Matches(labels.Set(usage.labels)) {
+			continue
+		}
+		if usage.requestedCPU+cpu > usage.allocatableCPU || usage.requestedMemory+memory > usage.allocatableMemory {
+			continue
+		}
+		candidates = append(candidates, candidate{name: name, utilization: usage.utilization})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].utilization != candidates[j].utilization {
+			if rule.Packing == PackingLeastAllocated {
+				return candidates[i].utilization < candidates[j].utilization
+			}
+			return candidates[i].utilization > candidates[j].utilization
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) > packingCandidates {
+		candidates = candidates[:packingCandidates]
+	}
+
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, c.name)
+	}
+	PreferNodes(pod, names)
+	return nil
+}
+
+// PreferNodes adds preferred node affinity terms toward the given nodes, the first one weighted highest
+func PreferNodes(pod *corev1.Pod, nodeNames []string) {
+	if len(nodeNames) == 0 {
+		return
+	}
+
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	step := int32(packingMaxWeight / packingCandidates)
+	for i, name := range nodeNames {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+			corev1.PreferredSchedulingTerm{
+				Weight: packingMaxWeight - int32(i)*step,
+				Preference: corev1.NodeSelectorTerm{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{name},
+					}},
+				},
+			})
+	}
+}
+
+// model returns the node utilization model, rebuilding it once RefreshInterval has passed
+func (nu *NodeUtilization) model(ctx context.Context) (map[string]*nodeUsage, error) {
+	nu.mu.Lock()
+	defer nu.mu.Unlock()
+	if nu.nodes != nil && time.Since(nu.refreshedAt) < nu.RefreshInterval {
+		return nu.nodes, nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := nu.Client.List(ctx, nodeList, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	nodes := make(map[string]*nodeUsage, len(nodeList.Items))
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		nodes[node.Name] = &nodeUsage{
+			labels:            node.Labels,
+			allocatableCPU:    node.Status.Allocatable.Cpu().MilliValue(),
+			allocatableMemory: node.Status.Allocatable.Memory().Value(),
+			schedulable:       isNodeHealthy(node) && !nu.ScaleDown.ScheduledForRemoval(node),
+		}
+	}
+
+	podList := &corev1.PodList{}
+	if err := nu.Client.List(ctx, podList, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		usage, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		cpu, memory := podCPUMemory(&pod.Spec)
+		usage.requestedCPU += cpu
+		usage.requestedMemory += memory
+	}
+
+	for _, usage := range nodes {
+		usage.utilization = (fraction(usage.requestedCPU, usage.allocatableCPU) + fraction(usage.requestedMemory, usage.allocatableMemory)) / 2
+	}
+
+	nu.nodes, nu.refreshedAt = nodes, time.Now()
+	nu.Log.V(1).Info("Refreshed node utilization", "nodes", len(nodes))
+	return nodes, nil
+}
+
+// podSize returns the CPU (in millicores) and memory the scheduler will reserve for the pod being
+// admitted: its requests, the overhead of its RuntimeClass and the sidecars injected into the
+// deployment's pods after placement
+func (nu *NodeUtilization) podSize(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment) (int64, int64, error) {
+	cpu, memory := podCPUMemory(&pod.Spec)
+	if pod.Spec.Overhead == nil {
+		overhead, err := RuntimeClassOverhead(ctx, nu.Client, &pod.Spec)
+		if err != nil {
+			return 0, 0, err
+		}
+		cpu += overhead.Cpu().MilliValue()
+		memory += overhead.Memory().Value()
+	}
+	sidecarCPU, sidecarMemory := nu.Sidecars.Requests(pod, deployment)
+	return cpu + sidecarCPU, memory + sidecarMemory, nil
+}
+
+// podCPUMemory returns the CPU (in millicores) and memory the scheduler reserves for a pod: the sum
+// of its containers' and native sidecars' requests, or what its init containers need while they
+// run when that is higher, plus its overhead
+func podCPUMemory(spec *corev1.PodSpec) (int64, int64) {
+	var cpu, memory int64
+	for _, container := range spec.Containers {
+		cpu += container.Resources.Requests.Cpu().MilliValue()
+		memory += container.Resources.Requests.Memory().Value()
+	}
+	// Native sidecars keep running next to the init containers started after them and the app
+	var sidecarCPU, sidecarMemory, initCPU, initMemory int64
+	for _, container := range spec.InitContainers {
+		containerCPU := container.Resources.Requests.Cpu().MilliValue()
+		containerMemory := container.Resources.Requests.Memory().Value()
+		if restartableInitContainer(container) {
+			sidecarCPU += containerCPU
+			sidecarMemory += containerMemory
+			containerCPU, containerMemory = 0, 0
+		}
+		initCPU = max(initCPU, sidecarCPU+containerCPU)
+		initMemory = max(initMemory, sidecarMemory+containerMemory)
+	}
+	cpu = max(cpu+sidecarCPU, initCPU)
+	memory = max(memory+sidecarMemory, initMemory)
+	if spec.Overhead != nil {
+		cpu += spec.Overhead.Cpu().MilliValue()
+		memory += spec.Overhead.Memory().Value()
+	}
+	return cpu, memory
+}
+
+// fraction returns requested/allocatable, treating a node without the resource as full
+func fraction(requested, allocatable int64) float64 {
+	if allocatable <= 0 {
+		return 1
+	}
+	return float64(requested) / float64(allocatable)
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultStateFailureThreshold is the number of consecutive state store failures that trips the breaker
+	DefaultStateFailureThreshold = 5
+	// DefaultStateDegradedCooldown is how long the webhook skips the state store once the breaker trips
+	DefaultStateDegradedCooldown = 30 * time.Second
+	// DefaultStateCallTimeout bounds each state store call made during admission
+	DefaultStateCallTimeout = 2 * time.Second
+)
+
+// ErrStateStoreDegraded is returned instead of calling the state store while the breaker is open
+var ErrStateStoreDegraded = errors.New("placement state store is degraded")
+
+var (
+	stateStoreDegraded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smart_scheduler_state_store_degraded",
+		Help: "1 while the webhook bypasses the placement state store after repeated failures, 0 otherwise",
+	})
+	stateStoreTrips = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "smart_scheduler_state_store_breaker_trips_total",
+		Help: "Number of times repeated placement state store failures switched the webhook to informer counts",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(stateStoreDegraded, stateStoreTrips)
+}
+
+// StateCircuitBreaker stops the webhook from calling the placement state store after repeated
+// failures. While open, admissions use pod counts from the informer cache; after the cooldown a
+// single call is let through to probe the store, closing the breaker again when it succeeds.
+type StateCircuitBreaker struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+	// CallTimeout bounds each state store call so a hanging API server fails fast
+	CallTimeout time.Duration
+	Log         logr.Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewStateCircuitBreaker creates a closed breaker, using defaults for non-positive settings
+func NewStateCircuitBreaker(failureThreshold int, cooldown, callTimeout time.Duration, log logr.Logger) *StateCircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = DefaultStateFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultStateDegradedCooldown
+	}
+	if callTimeout <= 0 {
+		callTimeout = DefaultStateCallTimeout
+	}
+	return &StateCircuitBreaker{
+		FailureThreshold: failureThreshold,
+		Cooldown:         cooldown,
+		CallTimeout:      callTimeout,
+		Log:              log,
+	}
+}
+
+// Do runs fn against the state store unless the breaker is open, recording its outcome.
+// It returns ErrStateStoreDegraded without calling fn while the breaker is open.
+func (cb *StateCircuitBreaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !cb.allow() {
+		return ErrStateStoreDegraded
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, cb.CallTimeout)
+	defer cancel()
+
+	err := fn(callCtx)
+	cb.record(err)
+	return err
+}
+
+// Degraded reports whether the breaker is currently open
+func (cb *StateCircuitBreaker) Degraded() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return !cb.openUntil.IsZero()
+}
+
+// allow reports whether a call may go to the state store. Once the cooldown has passed, one
+// probing call is allowed at a time until its outcome is recorded.
+func (cb *StateCircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openUntil.IsZero() {
+		return true
+	}
+	if cb.probing || time.Now().Before(cb.openUntil) {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of a state store call
+func (cb *StateCircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	wasOpen := !cb.openUntil.IsZero()
+	cb.probing = false
+
+	if err == nil {
+		cb.failures = 0
+		if wasOpen {
+			cb.openUntil = time.Time{}
+			stateStoreDegraded.Set(0)
+			cb.Log.Info("Placement state store recovered, leaving degraded mode")
+		}
+		return
+	}
+
+	cb.failures++
+	if wasOpen || cb.failures >= cb.FailureThreshold {
+		cb.openUntil = time.Now().Add(cb.Cooldown)
+		if !wasOpen {
+			stateStoreDegraded.Set(1)
+			stateStoreTrips.Inc()
+			cb.Log.Error(err, "Placement state store keeps failing, using informer pod counts",
+				"consecutiveFailures", cb.failures, "cooldown", cb.Cooldown.String())
+		}
+	}
+}
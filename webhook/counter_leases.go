@@ -0,0 +1,568 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Defaults of CounterLeases
+const (
+	// DefaultCounterLeaseDuration is how long a counter Lease stays held without being renewed, and
+	// how long a replica keeps the Lease of a deployment it stopped admitting pods of
+	DefaultCounterLeaseDuration = 15 * time.Second
+	// DefaultCounterResyncInterval is how often held counters are recounted from pods
+	DefaultCounterResyncInterval = 30 * time.Second
+	// DefaultCounterLeaseWait bounds how long an admission retries taking a counter Lease that
+	// replicas race for
+	DefaultCounterLeaseWait = time.Second
+)
+
+const (
+	// counterQuietPeriod is how long a deployment goes without admissions before its counters are
+	// recounted from pods, so the pods admitted last are in the informer cache
+	counterQuietPeriod = 5 * time.Second
+	// counterLeasePoll is how often an admission racing for a Lease retries it
+	counterLeasePoll = 50 * time.Millisecond
+)
+
+var (
+	counterLeaseEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smart_scheduler_counter_lease_events_total",
+		Help: "Placement counter Lease events by event: acquired, renewed, released, lost (taken over or deleted while held), contended (held by another replica), merged (pods of other replicas added to held counters) and resynced (counters recounted from pods)",
+	}, []string{"event"})
+	counterLeasesHeld = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smart_scheduler_counter_leases_held",
+		Help: "Deployments whose placement counters this replica holds the Lease of",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(counterLeaseEvents, counterLeasesHeld)
+}
+
+// ErrCountersHeld is returned when another webhook replica kept a deployment's counter Lease
+var ErrCountersHeld = errors.New("placement counters held by another replica")
+
+// CounterLeases keeps the placement counters of each deployment in the memory of one webhook
+// replica at a time. A replica admitting a deployment's pods takes the deployment's
+// coordination.k8s.io Lease, named after its placement state ConfigMap, and from then on reads and
+// increments the counts in memory under the deployment's lock instead of reading the ConfigMap back
+// for every admission, so concurrent admissions cannot place against the same counts. The holder
+// still writes its increments to the ConfigMap, which the rebalancer reads. Replicas finding the
+// Lease held by another place against the ConfigMap and write their increments to it as remote
+// increments, which the holder adds to its counters. StateManager.Start renews the Leases, merges
+// remote increments, recounts the counters from pods once a deployment's admissions paused, and
+// releases the Leases of deployments this replica stopped admitting.
+type CounterLeases struct {
+	Client client.Client
+	// Reader reads Leases from the API server, so the Leases of the cluster are not cached
+	Reader client.Reader
+	// Identity is this replica's holder identity, its pod name
+	Identity string
+	Log      logr.Logger
+	// LeaseDuration is how long a Lease stays held without renewal (default: DefaultCounterLeaseDuration)
+	LeaseDuration time.Duration
+	// ResyncInterval is how often held counters are recounted from pods (default: DefaultCounterResyncInterval)
+	ResyncInterval time.Duration
+	// Wait bounds how long an admission retries taking a Lease that replicas race for (default: DefaultCounterLeaseWait)
+	Wait time.Duration
+
+	mu   sync.Mutex
+	held map[types.NamespacedName]*heldCounters
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// heldCounters are the in-memory counters of a deployment whose Lease this replica holds
+type heldCounters struct {
+	lease      *coordinationv1.Lease
+	deployment *appsv1.Deployment
+	state      *PlacementState
+	// renewed is when the Lease was last acquired or renewed
+	renewed time.Time
+	// used is when an admission last claimed the counters
+	used time.Time
+	// resynced is when the counters were last counted from pods
+	resynced time.Time
+}
+
+// NewCounterLeases returns counter Leases held as identity, read through reader
+func NewCounterLeases(c client.Client, reader client.Reader, identity string, log logr.Logger) *CounterLeases {
+	return &CounterLeases{
+		Client:         c,
+		Reader:         reader,
+		Identity:       identity,
+		Log:            log,
+		LeaseDuration:  DefaultCounterLeaseDuration,
+		ResyncInterval: DefaultCounterResyncInterval,
+		Wait:           DefaultCounterLeaseWait,
+		now:            time.Now,
+	}
+}
+
+func (c *CounterLeases) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+func (c *CounterLeases) leaseDuration() time.Duration {
+	if c.LeaseDuration <= 0 {
+		return DefaultCounterLeaseDuration
+	}
+	return c.LeaseDuration
+}
+
+func (c *CounterLeases) resyncInterval() time.Duration {
+	if c.ResyncInterval <= 0 {
+		return DefaultCounterResyncInterval
+	}
+	return c.ResyncInterval
+}
+
+func (c *CounterLeases) wait() time.Duration {
+	if c.Wait <= 0 {
+		return DefaultCounterLeaseWait
+	}
+	return c.Wait
+}
+
+// Holds reports whether this replica holds the Lease of the deployment's counters
+func (c *CounterLeases) Holds(deployment *appsv1.Deployment) bool {
+	return c.get(stateKey(deployment)) != nil
+}
+
+// get returns the deployment's held counters, or nil
+func (c *CounterLeases) get(key types.NamespacedName) *heldCounters {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.held[key]
+}
+
+// heldKeys returns the deployments whose counters this replica holds
+func (c *CounterLeases) heldKeys() []types.NamespacedName {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]types.NamespacedName, 0, len(c.held))
+	for key := range c.held {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// hold records the counters of a deployment whose Lease was acquired
+func (c *CounterLeases) hold(key types.NamespacedName, held *heldCounters) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held == nil {
+		c.held = make(map[types.NamespacedName]*heldCounters)
+	}
+	c.held[key] = held
+	counterLeasesHeld.Set(float64(len(c.held)))
+}
+
+// drop forgets the counters of a deployment whose Lease was released or lost
+func (c *CounterLeases) drop(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.held, key)
+	counterLeasesHeld.Set(float64(len(c.held)))
+}
+
+// snapshot returns a copy of the deployment's held counters as a placement state, or nil when
+// this replica does not hold them
+func (c *CounterLeases) snapshot(key types.NamespacedName) *PlacementState {
+	held := c.get(key)
+	if held == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := *held.state
+	state.PodCounts = make(map[RuleKey]int, len(held.state.PodCounts))
+	for ruleKey, count := range held.state.PodCounts {
+		state.PodCounts[ruleKey] = count
+	}
+	return &state
+}
+
+// increment counts a pod placed by the rule in the deployment's held counters, if held
+func (c *CounterLeases) increment(key types.NamespacedName, ruleKey RuleKey) {
+	held := c.get(key)
+	if held == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if held.state.PodCounts == nil {
+		held.state.PodCounts = make(map[RuleKey]int)
+	}
+	held.state.PodCounts[ruleKey]++
+	held.state.TotalPods++
+}
+
+// expired reports whether the Lease's holder let it run out
+func (c *CounterLeases) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return !c.clock().Before(lease.Spec.RenewTime.Add(duration))
+}
+
+// holder returns the Lease's holder identity, empty when released
+func holder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// tryAcquire takes the deployment's Lease when it is free, expired or already this replica's. It
+// returns the acquired Lease, or nil and the current holder, empty when another replica raced it.
+func (c *CounterLeases) tryAcquire(ctx context.Context, deployment *appsv1.Deployment, name string) (*coordinationv1.Lease, string, error) {
+	lease := &coordinationv1.Lease{}
+	err := c.Reader.Get(ctx, client.ObjectKey{Namespace: deployment.Namespace, Name: name}, lease)
+	if apierrors.IsNotFound(err) {
+		lease = c.newLease(deployment, name)
+		if err := c.Client.Create(ctx, lease); apierrors.IsAlreadyExists(err) {
+			// Another replica created it first; the next attempt reads its holder
+			return nil, "", nil
+		} else if err != nil {
+			return nil, "", fmt.Errorf("failed to create counter Lease: %w", err)
+		}
+		return lease, c.Identity, nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to get counter Lease: %w", err)
+	}
+
+	current := holder(lease)
+	if current != "" && current != c.Identity && !c.expired(lease) {
+		return nil, current, nil
+	}
+
+	now := metav1.NewMicroTime(c.clock())
+	if current != c.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &c.Identity
+	lease.Spec.LeaseDurationSeconds = c.leaseDurationSeconds()
+	lease.Spec.RenewTime = &now
+	if err := c.Client.Update(ctx, lease); apierrors.IsConflict(err) {
+		// Another replica took or renewed it meanwhile
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to acquire counter Lease: %w", err)
+	}
+	return lease, c.Identity, nil
+}
+
+// newLease returns the deployment's Lease held by this replica. It is owned by the workload, so
+// it is deleted with it.
+func (c *CounterLeases) newLease(deployment *appsv1.Deployment, name string) *coordinationv1.Lease {
+	now := metav1.NewMicroTime(c.clock())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: deployment.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":               "smart-scheduler",
+				"app.kubernetes.io/component":          "placement-counters",
+				stateOwnerLabel(stateKind(deployment)): deployment.Name,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &c.Identity,
+			LeaseDurationSeconds: c.leaseDurationSeconds(),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	if deployment.UID != "" {
+		lease.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       WorkloadKind(deployment),
+			Name:       deployment.Name,
+			UID:        deployment.UID,
+		}}
+	}
+	return lease
+}
+
+func (c *CounterLeases) leaseDurationSeconds() *int32 {
+	seconds := int32(max(c.leaseDuration()/time.Second, 1))
+	return &seconds
+}
+
+// renew renews the held Lease once a third of its duration passed. It returns false when the
+// Lease was lost: taken over by another replica or deleted.
+func (c *CounterLeases) renew(ctx context.Context, key types.NamespacedName, held *heldCounters) (bool, error) {
+	if c.clock().Sub(held.renewed) < c.leaseDuration()/3 {
+		return true, nil
+	}
+	lease := held.lease.DeepCopy()
+	now := metav1.NewMicroTime(c.clock())
+	lease.Spec.RenewTime = &now
+	err := c.Client.Update(ctx, lease)
+	if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+		c.Log.Info("Lost the placement counter Lease", "deployment", key.String())
+		counterLeaseEvents.WithLabelValues("lost").Inc()
+		c.drop(key)
+		return false, nil
+	} else if err != nil {
+		return true, fmt.Errorf("failed to renew counter Lease: %w", err)
+	}
+
+	c.mu.Lock()
+	held.lease = lease
+	held.renewed = now.Time
+	c.mu.Unlock()
+	counterLeaseEvents.WithLabelValues("renewed").Inc()
+	return true, nil
+}
+
+// release gives up the held Lease so another replica can take it at once
+func (c *CounterLeases) release(ctx context.Context, key types.NamespacedName, held *heldCounters) {
+	c.drop(key)
+	lease := held.lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	if err := c.Client.Update(ctx, lease); err != nil && !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+		// The Lease expires by itself
+		c.Log.Error(err, "Failed to release placement counter Lease", "deployment", key.String())
+		return
+	}
+	counterLeaseEvents.WithLabelValues("released").Inc()
+}
+
+// ClaimCounters makes this replica the holder of the deployment's placement counters when no other
+// replica holds them. Counters taken over are seeded from the stored placement state. Callers hold
+// the deployment's lock and place the pod against GetPlacementState afterwards. Without Counters it
+// does nothing. When another replica holds the counters, it returns at once with an error wrapping
+// ErrCountersHeld, classified as ErrStateConflict; the pod is then placed against the stored state
+// and its increment written for the holder to merge.
+func (sm *StateManager) ClaimCounters(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) error {
+	c := sm.Counters
+	if c == nil {
+		return nil
+	}
+	key := stateKey(deployment)
+	if held := c.get(key); held != nil {
+		kept, err := c.renew(ctx, key, held)
+		if err != nil {
+			return err
+		}
+		if kept {
+			c.mu.Lock()
+			held.used = c.clock()
+			held.deployment = deployment
+			held.state.Strategy = strategy
+			c.mu.Unlock()
+			return nil
+		}
+	}
+
+	name := sm.getConfigMapName(deployment)
+	deadline := time.Now().Add(c.wait())
+	for {
+		lease, current, err := c.tryAcquire(ctx, deployment, name)
+		if err != nil {
+			return err
+		}
+		if lease != nil {
+			state, err := sm.loadPlacementState(ctx, deployment, strategy)
+			if err == nil {
+				// The seeded counts already include the remote increments stored so far
+				err = sm.settleRemoteIncrements(ctx, deployment, state.RemoteIncrements)
+			}
+			if err != nil {
+				c.release(ctx, key, &heldCounters{lease: lease})
+				return err
+			}
+			state.RemoteIncrements = nil
+			sm.addPending(deployment, state)
+			now := c.clock()
+			c.hold(key, &heldCounters{lease: lease, deployment: deployment, state: state, renewed: now, used: now, resynced: now})
+			c.Log.Info("Acquired placement counter Lease", "deployment", key.String(), "counts", state.PodCounts)
+			counterLeaseEvents.WithLabelValues("acquired").Inc()
+			return nil
+		}
+
+		if current != "" {
+			counterLeaseEvents.WithLabelValues("contended").Inc()
+			return Classify(ErrStateConflict, fmt.Errorf("%w: %s holds the counter Lease of %s", ErrCountersHeld, current, key))
+		}
+		if !time.Now().Before(deadline) {
+			counterLeaseEvents.WithLabelValues("contended").Inc()
+			return Classify(ErrStateConflict, fmt.Errorf("%w: replicas raced for the counter Lease of %s", ErrCountersHeld, key))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(counterLeasePoll):
+		}
+	}
+}
+
+// maintainCounters maintains the counters of each deployment holding a counter Lease, under the
+// deployment's lock
+func (sm *StateManager) maintainCounters(ctx context.Context) {
+	c := sm.Counters
+	for _, key := range c.heldKeys() {
+		unlock, err := sm.locks.Lock(ctx, key)
+		if err != nil {
+			return
+		}
+		held := c.get(key)
+		if held != nil {
+			sm.maintainHeld(ctx, key, held)
+		}
+		unlock()
+	}
+}
+
+// maintainHeld releases the Lease of a deployment not admitted for a Lease duration once its
+// increments are written. Otherwise it renews the Lease, adds the pods other replicas admitted and,
+// after admissions paused, recounts the counters from the pods. Callers hold the deployment's lock.
+func (sm *StateManager) maintainHeld(ctx context.Context, key types.NamespacedName, held *heldCounters) {
+	c := sm.Counters
+	idle := c.clock().Sub(held.used)
+	if idle >= c.leaseDuration() && !sm.hasPending(key) {
+		c.Log.Info("Releasing placement counter Lease of idle deployment", "deployment", key.String())
+		c.release(ctx, key, held)
+		return
+	}
+
+	kept, err := c.renew(ctx, key, held)
+	if err != nil {
+		c.Log.Error(err, "Failed to renew placement counter Lease", "deployment", key.String())
+		return
+	}
+	if !kept {
+		return
+	}
+	if err := sm.mergeRemoteIncrements(ctx, held); err != nil {
+		// The increments stay stored for the next merge
+		c.Log.Error(err, "Failed to merge placement counts of other replicas", "deployment", key.String())
+	}
+	if idle < counterQuietPeriod || c.clock().Sub(held.resynced) < c.resyncInterval() {
+		return
+	}
+
+	counts, rulePods, err := sm.getCurrentPodCounts(ctx, held.deployment, held.state.Strategy)
+	if err != nil {
+		c.Log.Error(err, "Failed to recount placement counters from pods", "deployment", key.String())
+		return
+	}
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	c.mu.Lock()
+	if !maps.Equal(held.state.PodCounts, counts) {
+		c.Log.Info("Resynced placement counters from pods", "deployment", key.String(),
+			"oldCounts", held.state.PodCounts, "newCounts", counts)
+	}
+	held.state.PodCounts = counts
+	held.state.RulePods = rulePods
+	held.state.TotalPods = total
+	held.resynced = c.clock()
+	c.mu.Unlock()
+	counterLeaseEvents.WithLabelValues("resynced").Inc()
+}
+
+// mergeRemoteIncrements adds the increments other replicas stored since the last merge to the held
+// counters. Callers hold the deployment's lock.
+func (sm *StateManager) mergeRemoteIncrements(ctx context.Context, held *heldCounters) error {
+	_, stored, err := sm.readStoredState(ctx, held.deployment)
+	if err != nil || stored == nil || len(stored.RemoteIncrements) == 0 {
+		return err
+	}
+	if err := sm.settleRemoteIncrements(ctx, held.deployment, stored.RemoteIncrements); err != nil {
+		return err
+	}
+
+	c := sm.Counters
+	c.mu.Lock()
+	if held.state.PodCounts == nil {
+		held.state.PodCounts = make(map[RuleKey]int)
+	}
+	for ruleKey, increment := range stored.RemoteIncrements {
+		held.state.PodCounts[ruleKey] += increment
+		held.state.TotalPods += increment
+	}
+	c.mu.Unlock()
+	c.Log.Info("Merged placement counts of other replicas", "deployment", stateKey(held.deployment).String(),
+		"increments", stored.RemoteIncrements)
+	counterLeaseEvents.WithLabelValues("merged").Inc()
+	return nil
+}
+
+// settleRemoteIncrements removes increments the holder counted from the stored remote increments,
+// keeping those stored since
+func (sm *StateManager) settleRemoteIncrements(ctx context.Context, deployment *appsv1.Deployment, settled map[RuleKey]int) error {
+	if len(settled) == 0 {
+		return nil
+	}
+	configMap, stored, err := sm.readStoredState(ctx, deployment)
+	if err != nil || stored == nil || len(stored.RemoteIncrements) == 0 {
+		return err
+	}
+	for ruleKey, increment := range settled {
+		stored.RemoteIncrements[ruleKey] -= increment
+		if stored.RemoteIncrements[ruleKey] <= 0 {
+			delete(stored.RemoteIncrements, ruleKey)
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal placement state: %w", err)
+	}
+	configMap.Data["placement-state"] = string(data)
+	if err := sm.Client.Update(ctx, configMap); err != nil {
+		return fmt.Errorf("failed to update placement state ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// releaseCounters releases every held counter Lease, once the final flush wrote the increments
+func (sm *StateManager) releaseCounters(ctx context.Context) {
+	c := sm.Counters
+	for _, key := range c.heldKeys() {
+		if held := c.get(key); held != nil {
+			c.release(ctx, key, held)
+		}
+	}
+}
+
+// hasPending reports whether increments of the deployment are buffered and not written yet
+func (sm *StateManager) hasPending(key types.NamespacedName) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, ok := sm.pending[key]
+	return ok
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// Kinds of the smartscheduler.io API. Each CRD is optional: without PodPlacementPolicy the operator
+// runs in annotation-only mode, placing pods of workloads with a schedule-strategy annotation only.
+const (
+	KindPodPlacementPolicy = "PodPlacementPolicy"
+	KindMaintenanceWindow  = "MaintenanceWindow"
+	KindPlacementSummary   = "PlacementSummary"
+)
+
+// APIKinds lists the kinds of the smartscheduler.io API
+var APIKinds = []string{KindPodPlacementPolicy, KindMaintenanceWindow, KindPlacementSummary}
+
+var crdInstalled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "smart_scheduler_crd_installed",
+	Help: "1 when the API server serves the smartscheduler.io kind, 0 when its CRD is not installed",
+}, []string{"kind"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(crdInstalled)
+}
+
+// InstalledAPIs records which kinds of the smartscheduler.io API the API server served at start.
+// A nil InstalledAPIs reports every kind installed.
+type InstalledAPIs struct {
+	kinds map[string]bool
+}
+
+// DetectInstalledAPIs asks the API server which kinds of the smartscheduler.io API it serves and
+// records them in smart_scheduler_crd_installed. CRDs installed later are picked up on restart.
+func DetectInstalledAPIs(d discovery.DiscoveryInterface) (*InstalledAPIs, error) {
+	apis := &InstalledAPIs{kinds: make(map[string]bool)}
+	resources, err := d.ServerResourcesForGroupVersion(smartschedulerv1.GroupVersion.String())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to discover %s resources: %w", smartschedulerv1.GroupVersion, err)
+	}
+	if resources != nil {
+		for _, resource := range resources.APIResources {
+			// Subresources such as policies/status carry their parent's kind
+			if !strings.Contains(resource.Name, "/") {
+				apis.kinds[resource.Kind] = true
+			}
+		}
+	}
+
+	for _, kind := range APIKinds {
+		installed := 0.0
+		if apis.kinds[kind] {
+			installed = 1
+		}
+		crdInstalled.WithLabelValues(kind).Set(installed)
+	}
+	return apis, nil
+}
+
+// Installed reports whether the API server serves the kind
+func (a *InstalledAPIs) Installed(kind string) bool {
+	return a == nil || a.kinds[kind]
+}
+
+// Missing returns the kinds of the smartscheduler.io API that are not installed
+func (a *InstalledAPIs) Missing() []string {
+	var missing []string
+	for _, kind := range APIKinds {
+		if !a.Installed(kind) {
+			missing = append(missing, kind)
+		}
+	}
+	return missing
+}
+
+// AnnotationOnly reports whether PodPlacementPolicies are unavailable, so strategies come from
+// schedule-strategy annotations only
+func (a *InstalledAPIs) AnnotationOnly() bool {
+	return !a.Installed(KindPodPlacementPolicy)
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// OwnedPath serves admissions forwarded by another replica. They are decided locally and never
+	// forwarded again, so replicas that briefly disagree on the ring cannot bounce a request.
+	OwnedPath = "/mutate-v1-pod/owned"
+	// DefaultForwardTimeout bounds a forwarded admission, well within the API server's webhook timeout
+	DefaultForwardTimeout = 2 * time.Second
+)
+
+var admissionOwnership = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_admission_ownership_total",
+		Help: "Admissions of managed deployments by how they were decided: local (this replica owns the deployment), forwarded (to the owning replica) or deferred (owner unreachable, decided locally)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(admissionOwnership)
+}
+
+// ownedKey marks the context of an admission forwarded to this replica
+type ownedKey struct{}
+
+// isOwned reports whether the admission was forwarded here by another replica
+func isOwned(ctx context.Context) bool {
+	owned, _ := ctx.Value(ownedKey{}).(bool)
+	return owned
+}
+
+// DecisionForwarder sends admissions of deployments owned by another webhook replica to that replica.
+// Replicas verify each other with the webhook serving certificate's CA and Service DNS name.
+type DecisionForwarder struct {
+	Ring *DecisionRing
+	// Port is the webhook port of the other replicas
+	Port int
+	Log  logr.Logger
+
+	client *http.Client
+}
+
+// NewDecisionForwarder returns a forwarder trusting the CA in caFile for certificates issued to serverName
+func NewDecisionForwarder(ring *DecisionRing, port int, caFile, serverName string, timeout time.Duration, log logr.Logger) (*DecisionForwarder, error) {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	if timeout <= 0 {
+		timeout = DefaultForwardTimeout
+	}
+
+	return &DecisionForwarder{
+		Ring: ring,
+		Port: port,
+		Log:  log,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:     &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS12},
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: 16,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// Forward sends req to the replica owning key and returns its response. It returns false when this
+// replica owns key, the admission was already forwarded, or the owner cannot be reached, and the
+// admission is then decided locally.
+func (f *DecisionForwarder) Forward(ctx context.Context, key string, req admission.Request) (admission.Response, bool) {
+	if f == nil || isOwned(ctx) {
+		return admission.Response{}, false
+	}
+	address, self := f.Ring.Owner(key)
+	if self {
+		admissionOwnership.WithLabelValues("local").Inc()
+		return admission.Response{}, false
+	}
+
+	resp, err := f.send(ctx, address, req)
+	if err != nil {
+		f.Log.Error(err, "Failed to forward admission to the owning replica, deciding locally", "deployment", key, "owner", address)
+		admissionOwnership.WithLabelValues("deferred").Inc()
+		return admission.Response{}, false
+	}
+	admissionOwnership.WithLabelValues("forwarded").Inc()
+	return resp, true
+}
+
+// send posts the admission review to the replica at address
+func (f *DecisionForwarder) send(ctx context.Context, address string, req admission.Request) (admission.Response, error) {
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  &req.AdmissionRequest,
+	})
+	if err != nil {
+		return admission.Response{}, err
+	}
+
+	url := "https://" + net.JoinHostPort(address, strconv.Itoa(f.Port)) + OwnedPath
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return admission.Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := f.client.Do(httpReq)
+	if err != nil {
+		return admission.Response{}, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return admission.Response{}, fmt.Errorf("owner answered %s", httpResp.Status)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(httpResp.Body).Decode(&review); err != nil {
+		return admission.Response{}, fmt.Errorf("failed to decode owner response: %w", err)
+	}
+	if review.Response == nil {
+		return admission.Response{}, errors.New("owner returned no admission response")
+	}
+	return admission.Response{AdmissionResponse: *review.Response}, nil
+}
+
+// ownedHandler handles admissions forwarded by another replica
+type ownedHandler struct {
+	mutator *PodMutator
+}
+
+func (h ownedHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	return h.mutator.Handle(context.WithValue(ctx, ownedKey{}, true), req)
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestDecisionForwarder(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	owner := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		review.Response = &admissionv1.AdmissionResponse{
+			UID:     review.Request.UID,
+			Allowed: true,
+			Result:  &metav1.Status{Message: "decided by the owner"},
+		}
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	defer owner.Close()
+
+	serverURL, err := url.Parse(owner.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(serverURL.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: owner.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test server's certificate is issued to example.com and 127.0.0.1
+	ring := NewDecisionRing(nil, "", "", "webhook-a", logr.Discard())
+	ring.SetMembers(map[string]string{"webhook-a": "10.0.0.1", "webhook-b": "127.0.0.1"})
+	forwarder, err := NewDecisionForwarder(ring, port, caFile, "example.com", time.Second, logr.Discard())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := "default/web-" + strconv.Itoa(i)
+		if _, self := ring.Owner(key); self {
+			local = key
+		} else {
+			remote = key
+		}
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{UID: types.UID("review-1")}}
+	ctx := context.Background()
+
+	if _, forwarded := forwarder.Forward(ctx, local, req); forwarded {
+		t.Error("Expected an owned deployment to be decided locally")
+	}
+	if _, forwarded := forwarder.Forward(context.WithValue(ctx, ownedKey{}, true), remote, req); forwarded {
+		t.Error("Expected a forwarded admission never to be forwarded again")
+	}
+
+	resp, forwarded := forwarder.Forward(ctx, remote, req)
+	if !forwarded {
+		t.Fatal("Expected the admission to be forwarded to the owning replica")
+	}
+	if !resp.Allowed || resp.UID != "review-1" || resp.Result.Message != "decided by the owner" {
+		t.Errorf("Expected the owner's response, got %+v", resp.AdmissionResponse)
+	}
+	mu.Lock()
+	if len(paths) != 1 || paths[0] != OwnedPath {
+		t.Errorf("Expected one admission sent to %s, got %v", OwnedPath, paths)
+	}
+	mu.Unlock()
+
+	// An unreachable owner leaves the admission to this replica
+	owner.Close()
+	if _, forwarded := forwarder.Forward(ctx, remote, req); forwarded {
+		t.Error("Expected the admission to be decided locally when the owner is unreachable")
+	}
+}
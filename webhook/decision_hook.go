@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultDecisionHookTimeout bounds a call to the decision hook. Admissions wait for it, so it is
+// kept far below the API server's webhook timeout.
+const DefaultDecisionHookTimeout = 500 * time.Millisecond
+
+// ErrPlacementVetoed is returned when the decision hook vetoes a placement; the pod is then admitted
+// without one
+var ErrPlacementVetoed = errors.New("placement vetoed by decision hook")
+
+var decisionHookReviews = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_decision_hook_reviews_total",
+		Help: "Placements reviewed by the external decision hook, by result: approved, overridden, vetoed or error (the placement was kept)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(decisionHookReviews)
+}
+
+// PlacementReview is the candidate placement posted to the decision hook
+type PlacementReview struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	// Pod holds the identifying fields of the pod being admitted; its name is usually generated later
+	Pod PlacementReviewPod `json:"pod"`
+	// Rules are the rules of the strategy the pod is placed by
+	Rules []PlacementReviewRule `json:"rules"`
+	// Counts are the pods per rule the placement was decided against
+	Counts map[RuleKey]int `json:"counts"`
+	// Candidate is the key of the rule the webhook chose
+	Candidate RuleKey `json:"candidate"`
+}
+
+// PlacementReviewPod identifies the pod of a PlacementReview
+type PlacementReviewPod struct {
+	GenerateName      string            `json:"generateName,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	PriorityClassName string            `json:"priorityClassName,omitempty"`
+}
+
+// PlacementReviewRule is a rule of the strategy in a PlacementReview
+type PlacementReviewRule struct {
+	Key          RuleKey           `json:"key"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Weight       int               `json:"weight"`
+}
+
+// PlacementVerdict is the decision hook's answer. An empty verdict approves the candidate; Rule,
+// when set to the key of another rule of the strategy, places the pod by that rule instead.
+type PlacementVerdict struct {
+	Veto   bool    `json:"veto,omitempty"`
+	Rule   RuleKey `json:"rule,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// DecisionHook posts every placement to an external HTTP endpoint before it is finalized, so
+// organizations can veto or override rules with their own logic. It fails open: an unreachable,
+// slow or malformed hook keeps the webhook's placement. A nil hook approves everything.
+type DecisionHook struct {
+	URL string
+	Log logr.Logger
+
+	client *http.Client
+}
+
+// NewDecisionHook returns a hook posting to url within timeout. An https url is verified against the
+// CA in caFile when one is given, and the system roots otherwise.
+func NewDecisionHook(url, caFile string, timeout time.Duration, log logr.Logger) (*DecisionHook, error) {
+	if timeout <= 0 {
+		timeout = DefaultDecisionHookTimeout
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read decision hook CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &DecisionHook{
+		URL: url,
+		Log: log,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: 16,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// Review asks the hook about the candidate rule chosen for the pod. It returns the rule the pod is
+// placed by, which is the candidate unless the hook overrides it, or ErrPlacementVetoed.
+func (h *DecisionHook) Review(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy, counts map[RuleKey]int, candidate RuleKey) (RuleKey, error) {
+	if h == nil {
+		return candidate, nil
+	}
+
+	review := &PlacementReview{
+		Namespace:  deployment.Namespace,
+		Deployment: deployment.Name,
+		Pod: PlacementReviewPod{
+			GenerateName:      pod.GenerateName,
+			Labels:            pod.Labels,
+			PriorityClassName: pod.Spec.PriorityClassName,
+		},
+		Rules:     make([]PlacementReviewRule, 0, len(strategy.Rules)),
+		Counts:    counts,
+		Candidate: candidate,
+	}
+	for _, rule := range strategy.Rules {
+		review.Rules = append(review.Rules, PlacementReviewRule{Key: rule.Key(), NodeSelector: rule.NodeSelector, Weight: rule.Weight})
+	}
+
+	verdict, err := h.send(ctx, review)
+	if err != nil {
+		h.Log.Error(err, "Decision hook failed, keeping the placement", "deployment", deployment.Name, "rule", candidate)
+		decisionHookReviews.WithLabelValues("error").Inc()
+		return candidate, nil
+	}
+
+	switch {
+	case verdict.Veto:
+		h.Log.Info("Decision hook vetoed the placement", "deployment", deployment.Name, "rule", candidate, "reason", verdict.Reason)
+		decisionHookReviews.WithLabelValues("vetoed").Inc()
+		return "", ErrPlacementVetoed
+	case verdict.Rule != "" && CanonicalRuleKey(verdict.Rule.String()) != candidate:
+		override := CanonicalRuleKey(verdict.Rule.String())
+		if _, ok := ruleByKey(strategy, override); !ok {
+			h.Log.Info("Decision hook chose a rule the strategy does not have, keeping the placement",
+				"deployment", deployment.Name, "rule", candidate, "override", verdict.Rule)
+			decisionHookReviews.WithLabelValues("error").Inc()
+			return candidate, nil
+		}
+		h.Log.Info("Decision hook overrode the placement", "deployment", deployment.Name, "rule", candidate, "override", override, "reason", verdict.Reason)
+		decisionHookReviews.WithLabelValues("overridden").Inc()
+		return override, nil
+	default:
+		decisionHookReviews.WithLabelValues("approved").Inc()
+		return candidate, nil
+	}
+}
+
+// send posts the review and decodes the verdict
+func (h *DecisionHook) send(ctx context.Context, review *PlacementReview) (*PlacementVerdict, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("decision hook answered %s", httpResp.Status)
+	}
+
+	verdict := &PlacementVerdict{}
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, 1<<20)).Decode(verdict); err != nil {
+		return nil, fmt.Errorf("failed to decode decision hook verdict: %w", err)
+	}
+	return verdict, nil
+}
+
+// ruleByKey returns the strategy's rule with the key
+func ruleByKey(strategy *PlacementStrategy, key RuleKey) (PlacementRule, bool) {
+	for _, rule := range strategy.Rules {
+		if rule.Key() == key {
+			return rule, true
+		}
+	}
+	return PlacementRule{}, false
+}
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"maps"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultRingRefreshInterval is how often webhook replicas are re-read from the Service's EndpointSlices
+	DefaultRingRefreshInterval = 5 * time.Second
+	// ringVirtualNodes is the number of ring points per replica, which evens out ownership
+	ringVirtualNodes = 64
+)
+
+var decisionRingMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "smart_scheduler_decision_ring_members",
+	Help: "Ready webhook replicas sharing placement decisions on the consistent-hash ring",
+})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(decisionRingMembers)
+}
+
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+
+// DecisionRing assigns every managed deployment to one webhook replica with consistent hashing, so
+// a single replica decides the placement sequence of a deployment's pods and replicas never race on
+// its counts. Members are the ready endpoints of the webhook Service; when one joins or leaves, only
+// the deployments on its share of the ring change owner. It implements manager.Runnable.
+type DecisionRing struct {
+	// Reader lists EndpointSlices directly, since the cache may not cover the operator namespace
+	Reader      client.Reader
+	Namespace   string
+	ServiceName string
+	// Self is the pod name of this replica
+	Self string
+	Log  logr.Logger
+	// RefreshInterval is how often members are re-read (default: DefaultRingRefreshInterval)
+	RefreshInterval time.Duration
+
+	mu      sync.RWMutex
+	members map[string]string
+	points  []ringPoint
+}
+
+// ringPoint is one virtual node of a member on the ring
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// NewDecisionRing returns a ring of the ready endpoints of the named Service, as seen by the replica self
+func NewDecisionRing(reader client.Reader, namespace, serviceName, self string, log logr.Logger) *DecisionRing {
+	return &DecisionRing{Reader: reader, Namespace: namespace, ServiceName: serviceName, Self: self, Log: log}
+}
+
+// Start refreshes the members until the context is cancelled
+func (r *DecisionRing) Start(ctx context.Context) error {
+	interval := r.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRingRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.refresh(ctx); err != nil && ctx.Err() == nil {
+			r.Log.Error(err, "Failed to refresh webhook replicas, keeping the current ring")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false because every webhook replica keeps its own view of the ring
+func (r *DecisionRing) NeedLeaderElection() bool {
+	return false
+}
+
+// refresh reads the ready pod endpoints of the Service
+func (r *DecisionRing) refresh(ctx context.Context) error {
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.Reader.List(ctx, slices, client.InNamespace(r.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: r.ServiceName}); err != nil {
+		return err
+	}
+
+	members := make(map[string]string)
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			members[endpoint.TargetRef.Name] = endpoint.Addresses[0]
+		}
+	}
+	r.SetMembers(members)
+	return nil
+}
+
+// SetMembers replaces the ring members, mapping pod names to the addresses they serve on
+func (r *DecisionRing) SetMembers(members map[string]string) {
+	points := make([]ringPoint, 0, len(members)*ringVirtualNodes)
+	for member := range members {
+		for i := 0; i < ringVirtualNodes; i++ {
+			points = append(points, ringPoint{hash: ringHash(member + "#" + strconv.Itoa(i)), member: member})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	r.mu.Lock()
+	changed := !maps.Equal(r.members, members)
+	r.members = members
+	r.points = points
+	r.mu.Unlock()
+
+	decisionRingMembers.Set(float64(len(members)))
+	if changed {
+		names := make([]string, 0, len(members))
+		for member := range members {
+			names = append(names, member)
+		}
+		sort.Strings(names)
+		r.Log.Info("Webhook replicas changed, rebalancing decision ownership", "members", names, "self", r.Self)
+	}
+}
+
+// Owner returns the address of the replica owning key and whether that is this replica. Until this
+// replica is a ready member itself, it owns every key, so admissions never wait for the ring.
+func (r *DecisionRing) Owner(key string) (string, bool) {
+	if r == nil {
+		return "", true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, member := r.members[r.Self]; !member || len(r.points) == 0 {
+		return "", true
+	}
+	hash := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	owner := r.points[i].member
+	return r.members[owner], owner == r.Self
+}
+
+// ringHash places a key on the ring
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestDecisionRing(t *testing.T) {
+	var unset *DecisionRing
+	if _, self := unset.Owner("default/web"); !self {
+		t.Error("Expected a nil ring to own every deployment")
+	}
+
+	members := map[string]string{"webhook-a": "10.0.0.1", "webhook-b": "10.0.0.2", "webhook-c": "10.0.0.3"}
+	joining := NewDecisionRing(nil, "", "", "webhook-d", logr.Discard())
+	joining.SetMembers(members)
+	if _, self := joining.Owner("default/web"); !self {
+		t.Error("Expected a replica outside the ring to decide locally")
+	}
+
+	rings := make(map[string]*DecisionRing)
+	for name := range members {
+		rings[name] = NewDecisionRing(nil, "", "", name, logr.Discard())
+		rings[name].SetMembers(members)
+	}
+
+	owners := make(map[string]string)
+	owned := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("team-%d/deployment-%d", i%7, i)
+		var owner string
+		for name, ring := range rings {
+			address, self := ring.Owner(key)
+			if self {
+				if owner != "" {
+					t.Fatalf("Expected one owner of %s, got %s and %s", key, owner, name)
+				}
+				owner = name
+			} else if address == members[name] {
+				t.Errorf("Expected %s to forward %s to another replica", name, key)
+			}
+		}
+		if owner == "" {
+			t.Fatalf("Expected an owner of %s", key)
+		}
+		for name, ring := range rings {
+			if address, self := ring.Owner(key); !self && address != members[owner] {
+				t.Errorf("Expected %s to forward %s to %s, got %s", name, key, members[owner], address)
+			}
+		}
+		owners[key] = owner
+		owned[owner]++
+	}
+	for name := range members {
+		if owned[name] < 50 {
+			t.Errorf("Expected ownership to be spread across replicas, %s owns %d of 300", name, owned[name])
+		}
+	}
+
+	// Only the deployments of a leaving replica change owner
+	delete(members, "webhook-c")
+	ring := NewDecisionRing(nil, "", "", "webhook-a", logr.Discard())
+	ring.SetMembers(members)
+	for key, owner := range owners {
+		address, self := ring.Owner(key)
+		if owner == "webhook-a" && !self || owner == "webhook-b" && address != "10.0.0.2" {
+			t.Errorf("Expected %s to stay with %s", key, owner)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var stateLockWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "smart_scheduler_state_lock_wait_seconds",
+	Help:    "Time spent waiting for another admission or flush of the same deployment's placement state",
+	Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(stateLockWaitSeconds)
+}
+
+// DeploymentLocks is a mutex per deployment. Holding a deployment's lock from reading its placement
+// state until the increment is recorded keeps concurrent admissions of the deployment from placing
+// against the same counts, and keeps flushes from interleaving with them. Locks of deployments no
+// one holds or waits for are dropped. The zero value is ready to use.
+type DeploymentLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*deploymentLock
+}
+
+// deploymentLock is a mutex that can be waited for with a context, and the number of its holders
+// and waiters
+type deploymentLock struct {
+	held chan struct{}
+	refs int
+}
+
+// Lock waits for the deployment's lock. It returns the function releasing the lock, or the
+// context's error when it ends first.
+func (l *DeploymentLocks) Lock(ctx context.Context, key types.NamespacedName) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[types.NamespacedName]*deploymentLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &deploymentLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case lock.held <- struct{}{}:
+	default:
+		select {
+		case lock.held <- struct{}{}:
+			stateLockWaitSeconds.Observe(time.Since(start).Seconds())
+		case <-ctx.Done():
+			l.release(key, lock)
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-lock.held
+			l.release(key, lock)
+		})
+	}, nil
+}
+
+// release drops a holder or waiter of the lock, forgetting the lock after the last one
+func (l *DeploymentLocks) release(key types.NamespacedName, lock *deploymentLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
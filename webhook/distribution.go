@@ -0,0 +1,149 @@
+package webhook
+
+// ExpectedDistribution returns how many of totalPods each rule should hold. The first Base pods go
+// to the first rule; the rest are split by weight in the order the webhook places them, so the
+// rebalancer never expects a distribution the webhook would not produce. The counts always sum to
+// totalPods, each rule stays within one pod of its exact weighted share, and adding a pod never
+// lowers any rule's expected count.
+// If every weight is zero, pods beyond the base also go to the first rule.
+func ExpectedDistribution(strategy *PlacementStrategy, totalPods int) map[RuleKey]int {
+	expected := make(map[RuleKey]int, len(strategy.Rules))
+	for _, rule := range strategy.Rules {
+		expected[rule.Key()] = 0
+	}
+	if len(strategy.Rules) == 0 || totalPods <= 0 {
+		return expected
+	}
+
+	base := strategy.Base
+	if totalPods < base {
+		base = totalPods
+	}
+	if base < 0 {
+		base = 0
+	}
+
+	weighted := make([]int, len(strategy.Rules))
+	for placed := 0; placed < totalPods-base; placed++ {
+		weighted[nextWeightedRule(strategy, weighted, placed)]++
+	}
+
+	expected[strategy.Rules[0].Key()] += base
+	for i, rule := range strategy.Rules {
+		expected[rule.Key()] += weighted[i]
+	}
+	return expected
+}
+
+// ForecastRule returns the index of the rule of the next pod's slot in the plan for replicas pods:
+// the plan places them in the order ExpectedDistribution counts them, and the pod takes the first
+// slot whose rule holds fewer pods than the plan up to it. Pods on rules outside the strategy,
+// e.g. ones filtered out for this pod, are taken from the plan's size. It reports false when every
+// rule already holds its planned pods, e.g. for surge pods.
+func ForecastRule(strategy *PlacementStrategy, currentCounts map[RuleKey]int, replicas int) (int, bool) {
+	ruleKeys := make(map[RuleKey]bool, len(strategy.Rules))
+	for _, rule := range strategy.Rules {
+		ruleKeys[rule.Key()] = true
+	}
+	planned := replicas
+	for key, count := range currentCounts {
+		if !ruleKeys[key] {
+			planned -= count
+		}
+	}
+	if len(strategy.Rules) == 0 || planned <= 0 {
+		return 0, false
+	}
+
+	base := min(max(strategy.Base, 0), planned)
+	weighted := make([]int, len(strategy.Rules))
+	slotted := make(map[RuleKey]int, len(strategy.Rules))
+	for slot := 0; slot < planned; slot++ {
+		i := 0
+		if slot >= base {
+			i = nextWeightedRule(strategy, weighted, slot-base)
+			weighted[i]++
+		}
+		key := strategy.Rules[i].Key()
+		slotted[key]++
+		if slotted[key] > currentCounts[key] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// nextWeightedRule returns the index of the rule that should receive the next pod beyond the base.
+// weighted holds each rule's pods beyond the base and placed their total. The rule furthest below
+// its weighted share of placed+1 pods wins; ties go to the earlier rule.
+func nextWeightedRule(strategy *PlacementStrategy, weighted []int, placed int) int {
+	totalWeight := 0
+	for _, rule := range strategy.Rules {
+		totalWeight += rule.Weight
+	}
+	if totalWeight <= 0 {
+		return 0
+	}
+
+	// Deficits are scaled by totalWeight to stay in integer arithmetic
+	best, bestDeficit := 0, 0
+	for i, rule := range strategy.Rules {
+		deficit := rule.Weight*(placed+1) - weighted[i]*totalWeight
+		if i == 0 || deficit > bestDeficit {
+			best, bestDeficit = i, deficit
+		}
+	}
+	return best
+}
+
+// weightedCounts converts per-rule pod counts into counts beyond the base, removing the base pods
+// from the first rule. It returns the counts and their total.
+func weightedCounts(strategy *PlacementStrategy, currentCounts map[RuleKey]int) ([]int, int) {
+	weighted := make([]int, len(strategy.Rules))
+	placed := 0
+	for i, rule := range strategy.Rules {
+		weighted[i] = currentCounts[rule.Key()]
+		if i == 0 {
+			weighted[i] -= strategy.Base
+			if weighted[i] < 0 {
+				weighted[i] = 0
+			}
+		}
+		placed += weighted[i]
+	}
+	return weighted, placed
+}
+
+// ScaleDownOrder returns the rules successive scale-down steps should each remove a pod from so the
+// remaining pods move toward the strategy's distribution. Every step removes a pod from the rule
+// most over-represented at the current size, with ties going to the later rule so base pods are
+// kept longest; it stops once no rule is over-represented. Counts are not modified.
+func ScaleDownOrder(strategy *PlacementStrategy, counts map[RuleKey]int) []RuleKey {
+	remaining := make(map[RuleKey]int, len(strategy.Rules))
+	total := 0
+	for _, rule := range strategy.Rules {
+		if _, seen := remaining[rule.Key()]; !seen {
+			remaining[rule.Key()] = counts[rule.Key()]
+			total += counts[rule.Key()]
+		}
+	}
+
+	var order []RuleKey
+	for ; total > 0; total-- {
+		expected := ExpectedDistribution(strategy, total)
+		var worst RuleKey
+		worstExcess := 0
+		for i := len(strategy.Rules) - 1; i >= 0; i-- {
+			key := strategy.Rules[i].Key()
+			if excess := remaining[key] - expected[key]; excess > worstExcess {
+				worst, worstExcess = key, excess
+			}
+		}
+		if worstExcess == 0 {
+			break
+		}
+		remaining[worst]--
+		order = append(order, worst)
+	}
+	return order
+}
@@ -0,0 +1,59 @@
+package webhook
+
+// RebalanceDriftThreshold is the drift percentage above which the rebalancer evicts pods
+const RebalanceDriftThreshold = 20.0
+
+// ExpectedCounts returns how many of the pods counted in actual each rule should hold. Expected
+// counts are apportioned over the pods that actually exist, so both sides of the comparison hold
+// the same number of pods and rounding never shows up as drift. Failover chains expect the pods on
+// the first healthy rule, as reported by isHealthy.
+func ExpectedCounts(strategy *PlacementStrategy, actual map[RuleKey]int, isHealthy func(PlacementRule) bool) map[RuleKey]int {
+	totalPods := 0
+	for _, count := range actual {
+		totalPods += count
+	}
+
+	if !strategy.IsFailover() {
+		return ExpectedDistribution(strategy, totalPods)
+	}
+	return expectedFailoverCounts(strategy, actual, SelectFailoverRule(strategy, isHealthy), totalPods)
+}
+
+// expectedFailoverCounts calculates the expected distribution for a failover chain.
+// Pods on rules below the preferred (first healthy) rule are expected to migrate back up to it,
+// while pods on rules above it are left alone because their pool is currently unhealthy.
+func expectedFailoverCounts(strategy *PlacementStrategy, actual map[RuleKey]int, preferred, totalPods int) map[RuleKey]int {
+	expected := make(map[RuleKey]int)
+	for i, rule := range strategy.Rules {
+		ruleKey := rule.Key()
+		if i < preferred {
+			expected[ruleKey] = actual[ruleKey]
+			totalPods -= actual[ruleKey]
+		} else {
+			expected[ruleKey] = 0
+		}
+	}
+
+	expected[strategy.Rules[preferred].Key()] = totalPods
+	return expected
+}
+
+// DriftPercentage returns how far the actual counts are from the expected ones, as the sum of the
+// per-rule differences relative to the expected pods
+func DriftPercentage(expected, actual map[RuleKey]int) float64 {
+	totalDrift := 0
+	totalExpected := 0
+	for ruleKey, count := range expected {
+		drift := count - actual[ruleKey]
+		if drift < 0 {
+			drift = -drift
+		}
+		totalDrift += drift
+		totalExpected += count
+	}
+
+	if totalExpected == 0 {
+		return 0
+	}
+	return float64(totalDrift) / float64(totalExpected) * 100
+}
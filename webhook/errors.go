@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Error classes shared by the webhook and the controllers. Errors are wrapped with Classify, so
+// callers test them with errors.Is and pick requeue behaviour and metric labels from the class
+// instead of matching messages.
+var (
+	// ErrStrategyInvalid marks a placement strategy annotation or policy that cannot be parsed.
+	// Retrying does not help until the object is edited.
+	ErrStrategyInvalid = errors.New("invalid placement strategy")
+	// ErrStateConflict marks placement state writes that kept losing optimistic concurrency races
+	ErrStateConflict = errors.New("placement state conflict")
+	// ErrPoolUnhealthy marks work held because the node pools it targets have no healthy node
+	ErrPoolUnhealthy = errors.New("node pool unhealthy")
+	// ErrPDBBlocked marks an eviction refused because it would violate a PodDisruptionBudget
+	ErrPDBBlocked = errors.New("eviction blocked by PodDisruptionBudget")
+	// ErrThrottled marks requests the API server's priority and fairness throttled. They retry once
+	// the shared Throttle backed off.
+	ErrThrottled = errors.New("throttled by the API server")
+)
+
+// errorClasses lists every class with its metric label and retry delay. A zero delay retries
+// with the controller's rate-limited backoff; a negative delay does not retry.
+var errorClasses = []struct {
+	class  error
+	reason string
+	retry  time.Duration
+}{
+	{ErrStrategyInvalid, "strategy_invalid", -1},
+	{ErrStateConflict, "state_conflict", 0},
+	{ErrPoolUnhealthy, "pool_unhealthy", 2 * time.Minute},
+	{ErrPDBBlocked, "pdb_blocked", time.Minute},
+	{ErrThrottled, "throttled", MinThrottleBackoff},
+}
+
+// classifiedError keeps the message of the wrapped error while matching its class with errors.Is
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return []error{e.class, e.err} }
+
+// Classify wraps err so errors.Is(err, class) holds. It returns nil for a nil err.
+func Classify(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// ClassifyEviction classifies the error of an eviction request: the API server answers
+// TooManyRequests when a PodDisruptionBudget does not allow the disruption, and when it throttles
+func ClassifyEviction(err error) error {
+	if IsThrottled(err) {
+		return Classify(ErrThrottled, err)
+	}
+	if apierrors.IsTooManyRequests(err) {
+		return Classify(ErrPDBBlocked, err)
+	}
+	return err
+}
+
+// classifyThrottled classifies the API server's throttling errors, which are returned unwrapped
+// by any client call, as ErrThrottled
+func classifyThrottled(err error) error {
+	if !errors.Is(err, ErrThrottled) && IsThrottled(err) {
+		return Classify(ErrThrottled, err)
+	}
+	return err
+}
+
+// ErrorReason returns the metric label of err's class, or "unknown"
+func ErrorReason(err error) string {
+	err = classifyThrottled(err)
+	for _, c := range errorClasses {
+		if errors.Is(err, c.class) {
+			return c.reason
+		}
+	}
+	return "unknown"
+}
+
+// RetryAfter returns how long to wait before retrying after err and whether to retry at all.
+// Unclassified errors retry with the controller's backoff. Throttled requests retry after the
+// shared Throttle's backoff or the delay the API server asked for, whichever is longer.
+func RetryAfter(err error) (time.Duration, bool) {
+	err = classifyThrottled(err)
+	if errors.Is(err, ErrThrottled) {
+		seconds, _ := apierrors.SuggestsClientDelay(err)
+		return max(Throttle.Delay(), time.Duration(seconds)*time.Second, MinThrottleBackoff), true
+	}
+	for _, c := range errorClasses {
+		if errors.Is(err, c.class) {
+			return max(c.retry, 0), c.retry >= 0
+		}
+	}
+	return 0, true
+}
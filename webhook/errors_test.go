@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestErrorClassification(t *testing.T) {
+	_, parseErr := ParsePlacementStrategy("weight=abc")
+	pdbErr := ClassifyEviction(apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0))
+	staleBudget := apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+	staleBudget.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: policyv1.DisruptionBudgetCause}}
+	throttledErr := apierrors.NewTooManyRequests("Too many requests, please try again later.", 3)
+
+	tests := []struct {
+		name       string
+		err        error
+		class      error
+		reason     string
+		retryAfter time.Duration
+		retry      bool
+	}{
+		{"Invalid strategy is not retried", parseErr, ErrStrategyInvalid, "strategy_invalid", 0, false},
+		{"State conflict retries with backoff", Classify(ErrStateConflict, errors.New("conflict")), ErrStateConflict, "state_conflict", 0, true},
+		{"Unhealthy pool retries later", Classify(ErrPoolUnhealthy, errors.New("no nodes")), ErrPoolUnhealthy, "pool_unhealthy", 2 * time.Minute, true},
+		{"PDB blocked eviction retries later", pdbErr, ErrPDBBlocked, "pdb_blocked", time.Minute, true},
+		{"Budget refusing with a delay is still a PDB block", ClassifyEviction(staleBudget), ErrPDBBlocked, "pdb_blocked", time.Minute, true},
+		{"Throttled request retries after the delay asked for", throttledErr, nil, "throttled", 3 * time.Second, true},
+		{"Throttled eviction retries after the delay asked for", ClassifyEviction(throttledErr), ErrThrottled, "throttled", 3 * time.Second, true},
+		{"Unclassified errors retry with backoff", errors.New("boom"), nil, "unknown", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.class != nil && !errors.Is(tt.err, tt.class) {
+				t.Errorf("Expected %v to be classified as %v", tt.err, tt.class)
+			}
+			if got := ErrorReason(tt.err); got != tt.reason {
+				t.Errorf("Expected reason %q, got %q", tt.reason, got)
+			}
+			after, retry := RetryAfter(tt.err)
+			if after != tt.retryAfter || retry != tt.retry {
+				t.Errorf("Expected retry %v after %v, got %v after %v", tt.retry, tt.retryAfter, retry, after)
+			}
+		})
+	}
+
+	// Classification keeps the original message and the wrapped API error
+	if !apierrors.IsTooManyRequests(pdbErr) || strings.Contains(parseErr.Error(), ErrStrategyInvalid.Error()) {
+		t.Error("Expected classified errors to keep their message and cause")
+	}
+}
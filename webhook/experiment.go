@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ExperimentAnnotation runs a placement experiment on a deployment, e.g.
+	// {"name":"more-spot","percentage":20,"strategy":"base=0,weight=1,nodeSelector=node-type:spot"}
+	ExperimentAnnotation = "smart-scheduler.io/experiment"
+	// ExperimentLabel carries the name of the experiment a pod was admitted under
+	ExperimentLabel = "smart-scheduler.io/experiment"
+	// ExperimentArmLabel carries the arm of the experiment a pod follows
+	ExperimentArmLabel = "smart-scheduler.io/experiment-arm"
+
+	// ArmControl pods follow the deployment's current strategy
+	ArmControl = "control"
+	// ArmCandidate pods follow the experiment's candidate strategy
+	ArmCandidate = "candidate"
+)
+
+var experimentPlacements = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_experiment_placements_total",
+		Help: "Pods admitted under a placement experiment, by namespace, deployment, experiment and arm",
+	},
+	[]string{"namespace", "deployment", "experiment", "arm"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(experimentPlacements)
+}
+
+// Experiment sends a percentage of a deployment's pods to a candidate strategy, the rest keep
+// following the current one
+type Experiment struct {
+	Name string `json:"name"`
+	// Percentage of the deployment's pods placed by the candidate strategy (1-99)
+	Percentage int `json:"percentage"`
+	// Strategy is the candidate strategy in the schedule-strategy format
+	Strategy string `json:"strategy"`
+}
+
+// ParseExperiment decodes the experiment annotation and validates its candidate strategy
+func ParseExperiment(data string) (*Experiment, error) {
+	experiment := &Experiment{}
+	if err := json.Unmarshal([]byte(data), experiment); err != nil {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", ExperimentAnnotation, err))
+	}
+	if experiment.Name == "" {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("experiment has no name"))
+	}
+	if experiment.Percentage < 1 || experiment.Percentage > 99 {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("experiment percentage must be between 1 and 99: %d", experiment.Percentage))
+	}
+	if _, err := ParsePlacementStrategyCached(experiment.Strategy); err != nil {
+		return nil, fmt.Errorf("invalid candidate strategy of experiment %s: %w", experiment.Name, err)
+	}
+	return experiment, nil
+}
+
+// ExperimentArm returns the arm a pod follows in the experiment; pods admitted before it started or
+// under another experiment are in the control arm
+func ExperimentArm(pod *corev1.Pod, experiment *Experiment) string {
+	if pod.Labels[ExperimentLabel] == experiment.Name && pod.Labels[ExperimentArmLabel] == ArmCandidate {
+		return ArmCandidate
+	}
+	return ArmControl
+}
+
+// ChooseArm assigns the next pod to the candidate arm while the candidate arm holds less than the
+// experiment's percentage of the pods, counting the next pod
+func ChooseArm(experiment *Experiment, candidatePods, totalPods int) string {
+	if candidatePods*100 < experiment.Percentage*(totalPods+1) {
+		return ArmCandidate
+	}
+	return ArmControl
+}
+
+// applyExperiment places the pod by the strategy of the experiment arm it is assigned to. Each arm
+// is placed against the counts of its own pods, read from the informer cache, so neither arm skews
+// the other's distribution. The arm is recorded in the pod's labels.
+func (pm *PodMutator) applyExperiment(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, experiment *Experiment, controlStrategy string) (string, RuleKey, error) {
+	pods, err := ListStrategyPods(ctx, pm.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		return "", "", err
+	}
+
+	var candidatePods, controlPods []corev1.Pod
+	for _, existing := range pods {
+		if existing.DeletionTimestamp != nil {
+			continue
+		}
+		if ExperimentArm(&existing, experiment) == ArmCandidate {
+			candidatePods = append(candidatePods, existing)
+		} else {
+			controlPods = append(controlPods, existing)
+		}
+	}
+
+	arm := ChooseArm(experiment, len(candidatePods), len(candidatePods)+len(controlPods))
+	armStrategy, armPods := controlStrategy, controlPods
+	if arm == ArmCandidate {
+		armStrategy, armPods = experiment.Strategy, candidatePods
+	}
+	strategy, err := ParsePlacementStrategyCached(armStrategy)
+	if err != nil {
+		return "", "", err
+	}
+	strategy, err = ResolveBase(ctx, pm.Client, deployment, strategy)
+	if err != nil {
+		pm.Log.Error(err, "Failed to derive the base, using the configured base", "experiment", experiment.Name, "arm", arm)
+	}
+	if strategy, err = WithRuleRenames(deployment, strategy); err != nil {
+		pm.Log.Error(err, "Ignoring rule renames", "experiment", experiment.Name, "arm", arm)
+	}
+
+	originalNodeSelector := unplacedNodeSelector(pod)
+	// Arms hold a share of the replicas, so they are placed against their pods
+	if err := pm.applyStrategy(ctx, pod, deployment, strategy, CountPodsByRule(armPods, strategy), 0); err != nil {
+		return "", "", err
+	}
+
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	pod.Labels[ExperimentLabel] = experiment.Name
+	pod.Labels[ExperimentArmLabel] = arm
+	experimentPlacements.WithLabelValues(deployment.Namespace, deployment.Name, experiment.Name, arm).Inc()
+	return armStrategy, pm.getAppliedRuleKey(originalNodeSelector, pod, strategy), nil
+}
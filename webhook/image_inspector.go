@@ -0,0 +1,323 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// manifestAcceptTypes lists the manifest media types requested from registries, indexes first
+var manifestAcceptTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ImageInspector resolves the CPU architectures an image is published for
+type ImageInspector interface {
+	Architectures(ctx context.Context, image string) ([]string, error)
+}
+
+// cachedArchitectures is a registry lookup result with its expiry
+type cachedArchitectures struct {
+	architectures []string
+	expiresAt     time.Time
+}
+
+// RegistryInspector reads image manifests from OCI registries using anonymous pull access.
+// Results are cached per image reference so admission does not hit the registry for every pod.
+type RegistryInspector struct {
+	HTTPClient *http.Client
+	TTL        time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedArchitectures
+}
+
+// NewRegistryInspector creates a registry inspector with the given request timeout and cache TTL
+func NewRegistryInspector(timeout, ttl time.Duration) *RegistryInspector {
+	return &RegistryInspector{
+		HTTPClient: &http.Client{Timeout: timeout},
+		TTL:        ttl,
+		cache:      make(map[string]cachedArchitectures),
+	}
+}
+
+// Architectures returns the architectures the image is published for
+func (ri *RegistryInspector) Architectures(ctx context.Context, image string) ([]string, error) {
+	ri.mu.Lock()
+	cached, ok := ri.cache[image]
+	ri.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.architectures, nil
+	}
+
+	archs, err := ri.fetchArchitectures(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	ri.mu.Lock()
+	ri.cache[image] = cachedArchitectures{architectures: archs, expiresAt: time.Now().Add(ri.TTL)}
+	ri.mu.Unlock()
+
+	return archs, nil
+}
+
+// fetchArchitectures reads the image manifest and, for single-platform images, its config blob
+func (ri *RegistryInspector) fetchArchitectures(ctx context.Context, image string) ([]string, error) {
+	registry, repository, reference := parseImageReference(image)
+	baseURL := fmt.Sprintf("https://%s/v2/%s", registry, repository)
+
+	body, err := ri.get(ctx, baseURL+"/manifests/"+reference, strings.Join(manifestAcceptTypes, ","))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest for %s: %w", image, err)
+	}
+
+	var manifest struct {
+		Manifests []struct {
+			Platform *struct {
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest for %s: %w", image, err)
+	}
+
+	// Multi-platform index: every entry carries its platform
+	if len(manifest.Manifests) > 0 {
+		var archs []string
+		for _, entry := range manifest.Manifests {
+			// Attestation manifests are published with an "unknown" platform
+			if entry.Platform == nil || entry.Platform.Architecture == "" || entry.Platform.Architecture == "unknown" {
+				continue
+			}
+			archs = appendUnique(archs, entry.Platform.Architecture)
+		}
+		return archs, nil
+	}
+
+	// Single-platform image: the architecture lives in the config blob
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest for %s has neither platforms nor a config", image)
+	}
+
+	body, err = ri.get(ctx, baseURL+"/blobs/"+manifest.Config.Digest, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config for %s: %w", image, err)
+	}
+
+	var config struct {
+		Architecture string `json:"architecture"`
+	}
+	if err := json.Unmarshal(body, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode config for %s: %w", image, err)
+	}
+	if config.Architecture == "" {
+		return nil, fmt.Errorf("config for %s does not declare an architecture", image)
+	}
+
+	return []string{config.Architecture}, nil
+}
+
+// get performs a registry GET, retrying once with a bearer token when the registry asks for one
+func (ri *RegistryInspector) get(ctx context.Context, rawURL, accept string) ([]byte, error) {
+	resp, err := ri.do(ctx, rawURL, accept, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		token, err := ri.fetchToken(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = ri.do(ctx, rawURL, accept, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// do sends a single GET request to the registry
+func (ri *RegistryInspector) do(ctx context.Context, rawURL, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return ri.HTTPClient.Do(req)
+}
+
+// fetchToken requests an anonymous pull token from the realm named in a Bearer challenge
+func (ri *RegistryInspector) fetchToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry auth challenge: %q", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry auth realm: %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	resp, err := ri.do(ctx, realm.String(), "", "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseImageReference splits an image reference into registry host, repository and tag or digest,
+// applying the Docker Hub defaults for short names
+func parseImageReference(image string) (registry, repository, reference string) {
+	name := image
+	reference = "latest"
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i+1:]
+	}
+
+	registry = "registry-1.docker.io"
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, name = host, name[i+1:]
+			if registry == "docker.io" || registry == "index.docker.io" {
+				registry = "registry-1.docker.io"
+			}
+		}
+	}
+
+	if registry == "registry-1.docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return registry, name, reference
+}
+
+// ResolvePodArchitectures returns the architectures supported by every container image in the pod
+func ResolvePodArchitectures(ctx context.Context, inspector ImageInspector, pod *corev1.Pod) ([]string, error) {
+	var archs []string
+	first := true
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		imageArchs, err := inspector.Architectures(ctx, container.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		if first {
+			archs = imageArchs
+			first = false
+			continue
+		}
+
+		var common []string
+		for _, arch := range archs {
+			for _, imageArch := range imageArchs {
+				if arch == imageArch {
+					common = append(common, arch)
+					break
+				}
+			}
+		}
+		archs = common
+	}
+
+	if !first && len(archs) == 0 {
+		return nil, fmt.Errorf("pod images share no common architecture")
+	}
+
+	return archs, nil
+}
+
+// ResolvePlatform returns the pod's declared platform, asking the inspector for the image
+// architectures when the pod does not declare them. The declared platform is still returned
+// alongside any inspection error so callers can fall back to it.
+func ResolvePlatform(ctx context.Context, inspector ImageInspector, pod *corev1.Pod) (Platform, error) {
+	platform := PodPlatform(pod)
+	if len(platform.Architectures) > 0 || inspector == nil {
+		return platform, nil
+	}
+
+	archs, err := ResolvePodArchitectures(ctx, inspector, pod)
+	if err != nil {
+		return platform, fmt.Errorf("failed to resolve image architectures: %w", err)
+	}
+	platform.Architectures = archs
+
+	return platform, nil
+}
+
+// appendUnique appends value to values unless it is already present
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,208 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PodOwnerUIDField indexes pods by the UIDs of their owner references
+	PodOwnerUIDField = "metadata.ownerReferences.uid"
+	// ReplicaSetOwnerDeploymentField indexes ReplicaSets by the name of their owning Deployment
+	ReplicaSetOwnerDeploymentField = "metadata.ownerReferences.deployment"
+)
+
+// SetupIndexers registers the field indexes used for owner lookups on the manager cache.
+// It must be called once, before the cache starts.
+func SetupIndexers(ctx context.Context, indexer client.FieldIndexer) error {
+	err := indexer.IndexField(ctx, &corev1.Pod{}, PodOwnerUIDField, podOwnerUIDs)
+	if err != nil {
+		return fmt.Errorf("failed to index pods by owner UID: %w", err)
+	}
+
+	err = indexer.IndexField(ctx, &appsv1.ReplicaSet{}, ReplicaSetOwnerDeploymentField, replicaSetOwnerDeployment)
+	if err != nil {
+		return fmt.Errorf("failed to index replicasets by owner deployment: %w", err)
+	}
+
+	return nil
+}
+
+// podOwnerUIDs extracts the PodOwnerUIDField index values
+func podOwnerUIDs(obj client.Object) []string {
+	var uids []string
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		uids = append(uids, string(ownerRef.UID))
+	}
+	return uids
+}
+
+// replicaSetOwnerDeployment extracts the ReplicaSetOwnerDeploymentField index value
+func replicaSetOwnerDeployment(obj client.Object) []string {
+	if ownerRef := metav1.GetControllerOf(obj); ownerRef != nil && ownerRef.Kind == "Deployment" {
+		return []string{ownerRef.Name}
+	}
+	return nil
+}
+
+// ListDeploymentReplicaSets returns the ReplicaSets controlled by the deployment using the owner index
+func ListDeploymentReplicaSets(ctx context.Context, c client.Reader, deployment *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	rsList := &appsv1.ReplicaSetList{}
+	err := c.List(ctx, rsList,
+		client.InNamespace(deployment.Namespace),
+		client.MatchingFields{ReplicaSetOwnerDeploymentField: deployment.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replicasets: %w", err)
+	}
+	return rsList.Items, nil
+}
+
+// ListDeploymentPods returns the pods owned by the deployment's ReplicaSets using the owner indexes,
+// so only the deployment's own pods are read instead of everything matching its label selector.
+// The pods of a StatefulSet are owned by it directly. Extra options are applied to the pod lists,
+// e.g. client.UnsafeDisableDeepCopy for read-only callers.
+func ListDeploymentPods(ctx context.Context, c client.Reader, deployment *appsv1.Deployment, opts ...client.ListOption) ([]corev1.Pod, error) {
+	if IsStatefulSet(deployment) {
+		podList := &corev1.PodList{}
+		listOpts := append([]client.ListOption{
+			client.InNamespace(deployment.Namespace),
+			client.MatchingFields{PodOwnerUIDField: string(deployment.UID)},
+		}, opts...)
+		if err := c.List(ctx, podList, listOpts...); err != nil {
+			return nil, fmt.Errorf("failed to list pods for statefulset %s: %w", deployment.Name, err)
+		}
+		return podList.Items, nil
+	}
+
+	replicaSets, err := ListDeploymentReplicaSets(ctx, c, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, rs := range replicaSets {
+		podList := &corev1.PodList{}
+		listOpts := append([]client.ListOption{
+			client.InNamespace(deployment.Namespace),
+			client.MatchingFields{PodOwnerUIDField: string(rs.UID)},
+		}, opts...)
+		err := c.List(ctx, podList, listOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods for replicaset %s: %w", rs.Name, err)
+		}
+		pods = append(pods, podList.Items...)
+	}
+
+	return pods, nil
+}
+
+// ListDeploymentPodsPaged lists the deployment's pods directly from the API server using its label
+// selector, pageSize pods at a time. Pods not owned by one of the deployment's ReplicaSets, or by the
+// StatefulSet, are dropped. The cache does not support continuation, so apiReader must not be a
+// cached client.
+func ListDeploymentPodsPaged(ctx context.Context, c client.Reader, apiReader client.Reader, deployment *appsv1.Deployment, pageSize int64) ([]corev1.Pod, error) {
+	owned := map[types.UID]bool{}
+	if IsStatefulSet(deployment) {
+		owned[deployment.UID] = true
+	} else {
+		replicaSets, err := ListDeploymentReplicaSets(ctx, c, deployment)
+		if err != nil {
+			return nil, err
+		}
+		for _, rs := range replicaSets {
+			owned[rs.UID] = true
+		}
+	}
+
+	selector, err := DeploymentPodSelector(deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	continueToken := ""
+	for {
+		podList := &corev1.PodList{}
+		err := apiReader.List(ctx, podList, &client.ListOptions{
+			Namespace:     deployment.Namespace,
+			LabelSelector: selector,
+			Limit:         pageSize,
+			Continue:      continueToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+
+		for _, pod := range podList.Items {
+			if ownerRef := metav1.GetControllerOf(&pod); ownerRef != nil && owned[ownerRef.UID] {
+				pods = append(pods, pod)
+			}
+		}
+
+		continueToken = podList.Continue
+		if continueToken == "" {
+			return pods, nil
+		}
+	}
+}
+
+// DeploymentPodSelector returns the label selector of the deployment's pods, including its
+// MatchExpressions. A nil or empty selector selects every pod; callers must still check ownership.
+func DeploymentPodSelector(deployment *appsv1.Deployment) (labels.Selector, error) {
+	if deployment.Spec.Selector == nil {
+		return labels.Everything(), nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment selector: %w", err)
+	}
+	return selector, nil
+}
+
+// CountDeploymentPods returns the number of pods the deployment's ReplicaSets, or the StatefulSet,
+// report in their status. It is a count-only fast path for callers that don't need per-rule attribution.
+func CountDeploymentPods(ctx context.Context, c client.Reader, deployment *appsv1.Deployment) (int, error) {
+	if IsStatefulSet(deployment) {
+		return int(deployment.Status.Replicas), nil
+	}
+
+	replicaSets, err := ListDeploymentReplicaSets(ctx, c, deployment)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, rs := range replicaSets {
+		total += int(rs.Status.Replicas)
+	}
+	return total, nil
+}
+
+// ParentDeploymentName resolves the name of the Deployment that controls the pod through its ReplicaSet.
+// It returns false when the pod is not controlled by a Deployment.
+func ParentDeploymentName(ctx context.Context, c client.Reader, pod *corev1.Pod) (string, bool) {
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "ReplicaSet" {
+		return "", false
+	}
+
+	rs := &appsv1.ReplicaSet{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ownerRef.Name}, rs)
+	if err != nil {
+		return "", false
+	}
+
+	rsOwnerRef := metav1.GetControllerOf(rs)
+	if rsOwnerRef == nil || rsOwnerRef.Kind != "Deployment" {
+		return "", false
+	}
+
+	return rsOwnerRef.Name, true
+}
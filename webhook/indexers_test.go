@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeIndexer registers field indexes on a fake client builder
+type fakeIndexer struct {
+	builder *fake.ClientBuilder
+	fields  []string
+}
+
+func (f *fakeIndexer) IndexField(_ context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	f.builder.WithIndex(obj, field, extract)
+	f.fields = append(f.fields, field)
+	return nil
+}
+
+func ownerRef(kind, name string, uid types.UID, controller bool) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid, Controller: &controller}
+}
+
+func TestOwnerIndexValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		owners     []metav1.OwnerReference
+		uids       []string
+		deployment []string
+	}{
+		{
+			name:       "Controlled by a deployment",
+			owners:     []metav1.OwnerReference{ownerRef("Deployment", "web", "web-uid", true)},
+			uids:       []string{"web-uid"},
+			deployment: []string{"web"},
+		},
+		{
+			name:   "Owned but not controlled by a deployment",
+			owners: []metav1.OwnerReference{ownerRef("Deployment", "web", "web-uid", false)},
+			uids:   []string{"web-uid"},
+		},
+		{
+			name:   "Controlled by another kind",
+			owners: []metav1.OwnerReference{ownerRef("StatefulSet", "db", "db-uid", true)},
+			uids:   []string{"db-uid"},
+		},
+		{
+			name:       "Several owners",
+			owners:     []metav1.OwnerReference{ownerRef("ConfigMap", "cfg", "cfg-uid", false), ownerRef("Deployment", "web", "web-uid", true)},
+			uids:       []string{"cfg-uid", "web-uid"},
+			deployment: []string{"web"},
+		},
+		{
+			name: "No owners",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-abc", OwnerReferences: tt.owners}}
+			if got := podOwnerUIDs(obj); !reflect.DeepEqual(got, tt.uids) {
+				t.Errorf("podOwnerUIDs() = %v, want %v", got, tt.uids)
+			}
+			if got := replicaSetOwnerDeployment(obj); !reflect.DeepEqual(got, tt.deployment) {
+				t.Errorf("replicaSetOwnerDeployment() = %v, want %v", got, tt.deployment)
+			}
+		})
+	}
+}
+
+func TestListDeploymentPodsByOwnerIndex(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	web := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"}}
+	db := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: WorkloadStatefulSet},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "db-uid"},
+	}
+	replicaSet := func(name string, uid types.UID, owner, namespace string) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: namespace, UID: uid,
+			OwnerReferences: []metav1.OwnerReference{ownerRef("Deployment", owner, types.UID(owner+"-uid"), true)},
+		}}
+	}
+	pod := func(name, namespace string, owners ...metav1.OwnerReference) *corev1.Pod {
+		// Every pod carries the same labels, so only the owner indexes tell them apart
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: namespace, Labels: map[string]string{"app": "web"},
+			OwnerReferences: owners,
+		}}
+	}
+
+	objects := []client.Object{
+		web, db,
+		// The old and new ReplicaSets of a rollout, another deployment's and one of a namesake in
+		// another namespace
+		replicaSet("web-old", "web-old-uid", "web", "default"),
+		replicaSet("web-new", "web-new-uid", "web", "default"),
+		replicaSet("api-abc", "api-abc-uid", "api", "default"),
+		replicaSet("web-other", "web-other-uid", "web", "other"),
+		pod("web-old-0", "default", ownerRef("ReplicaSet", "web-old", "web-old-uid", true)),
+		pod("web-new-0", "default", ownerRef("ReplicaSet", "web-new", "web-new-uid", true)),
+		pod("web-new-1", "default", ownerRef("ReplicaSet", "web-new", "web-new-uid", true)),
+		pod("api-abc-0", "default", ownerRef("ReplicaSet", "api-abc", "api-abc-uid", true)),
+		pod("web-other-0", "other", ownerRef("ReplicaSet", "web-other", "web-other-uid", true)),
+		pod("db-0", "default", ownerRef("StatefulSet", "db", "db-uid", true)),
+		pod("bare", "default"),
+	}
+
+	indexer := &fakeIndexer{builder: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...)}
+	if err := SetupIndexers(context.Background(), indexer); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{PodOwnerUIDField, ReplicaSetOwnerDeploymentField}; !reflect.DeepEqual(indexer.fields, want) {
+		t.Errorf("SetupIndexers() registered %v, want %v", indexer.fields, want)
+	}
+	c := indexer.builder.Build()
+	ctx := context.Background()
+
+	replicaSets, err := ListDeploymentReplicaSets(ctx, c, web)
+	if err != nil {
+		t.Fatalf("ListDeploymentReplicaSets() error = %v", err)
+	}
+	var rsNames []string
+	for _, rs := range replicaSets {
+		rsNames = append(rsNames, rs.Name)
+	}
+	sort.Strings(rsNames)
+	if want := []string{"web-new", "web-old"}; !reflect.DeepEqual(rsNames, want) {
+		t.Errorf("ListDeploymentReplicaSets() = %v, want %v", rsNames, want)
+	}
+
+	tests := []struct {
+		name     string
+		workload *appsv1.Deployment
+		want     []string
+	}{
+		{"Pods of every ReplicaSet of the deployment", web, []string{"web-new-0", "web-new-1", "web-old-0"}},
+		{"Pods of a StatefulSet", db, []string{"db-0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods, err := ListDeploymentPods(ctx, c, tt.workload, client.UnsafeDisableDeepCopy)
+			if err != nil {
+				t.Fatalf("ListDeploymentPods() error = %v", err)
+			}
+			var names []string
+			for _, pod := range pods {
+				names = append(names, pod.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("ListDeploymentPods() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
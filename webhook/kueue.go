@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// KueueAdmissionGate is the scheduling gate Kueue holds the pods of queued workloads with until
+	// their quota is admitted. Kueue then removes it and adds the nodeSelector of the assigned
+	// ResourceFlavor in the same update.
+	KueueAdmissionGate = "kueue.x-k8s.io/admission"
+
+	// QueueAdmissionAnnotation records on a pod created behind a queue's scheduling gate that its
+	// placement waits for the queue: "pending" until the queue releases it
+	QueueAdmissionAnnotation = "smart-scheduler.io/queue-admission"
+	// QueueAdmissionPending marks a pod whose placement waits for the queue
+	QueueAdmissionPending = "pending"
+	// QueueAdmissionAdmitted marks a pod placed when the queue released it
+	QueueAdmissionAdmitted = "admitted"
+)
+
+var queuedPods = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_queued_pods_total",
+		Help: "Pods held by a queue's scheduling gate, by namespace and result: deferred (placement waits for the queue), released (placed within the nodes the queue assigned) or unplaced (no rule agrees with the nodes the queue assigned)",
+	},
+	[]string{"namespace", "result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(queuedPods)
+}
+
+// AwaitingQueueAdmission reports whether the pod is held by Kueue until its workload is admitted
+func AwaitingQueueAdmission(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.SchedulingGates {
+		if gate.Name == KueueAdmissionGate {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleasedByQueue reports whether the pod waited for a queue that has now released it
+func ReleasedByQueue(pod *corev1.Pod) bool {
+	return pod.Annotations[QueueAdmissionAnnotation] == QueueAdmissionPending && !AwaitingQueueAdmission(pod)
+}
+
+// WithinQueueAssignment returns the strategy a pod released by a queue can still be placed with. The
+// queue's quota is tied to the nodes it assigned, and a gated pod's nodeSelector may only be added
+// to, so rules overriding a key the pod already selects are dropped; pod affinity cannot be changed
+// at all and is left out of the remaining rules.
+func WithinQueueAssignment(pod *corev1.Pod, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	agrees := func(rule PlacementRule) bool {
+		for key, value := range rule.NodeSelector {
+			if assigned, ok := pod.Spec.NodeSelector[key]; ok && assigned != value {
+				return false
+			}
+		}
+		return true
+	}
+	if !slices.ContainsFunc(strategy.Rules, agrees) {
+		return nil, fmt.Errorf("no rule agrees with the nodeSelector %v the queue assigned", pod.Spec.NodeSelector)
+	}
+
+	filtered := keepRules(strategy, agrees)
+	withoutAffinity := *filtered
+	withoutAffinity.Rules = make([]PlacementRule, len(filtered.Rules))
+	for i, rule := range filtered.Rules {
+		rule.Affinity = nil
+		withoutAffinity.Rules[i] = rule
+	}
+	return &withoutAffinity, nil
+}
+
+// withinQueueAssignment restricts the strategy of a pod the queue just released to the nodes the
+// queue assigned, and marks the pod admitted
+func (pm *PodMutator) withinQueueAssignment(pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	strategy, err := WithinQueueAssignment(pod, strategy)
+	if err != nil {
+		queuedPods.WithLabelValues(deployment.Namespace, "unplaced").Inc()
+		return nil, err
+	}
+	pm.Log.Info("Placing pod released by its queue", "deployment", deployment.Name, "assigned", pod.Spec.NodeSelector)
+	queuedPods.WithLabelValues(deployment.Namespace, "released").Inc()
+	pod.Annotations[QueueAdmissionAnnotation] = QueueAdmissionAdmitted
+	return strategy, nil
+}
+
+// deferToQueue admits a pod held by a queue's scheduling gate unplaced, marking it so it is placed
+// when the queue releases it. Placing it now would pick nodes before the queue assigns a flavor.
+func deferToQueue(req admission.Request, pod *corev1.Pod, deployment *appsv1.Deployment, log logr.Logger) admission.Response {
+	if pod.Annotations[QueueAdmissionAnnotation] == QueueAdmissionPending {
+		return admission.Allowed("placement waits for the queue")
+	}
+	log.Info("Pod is held by a queue, deferring placement until it is admitted", "deployment", deployment.Name)
+	queuedPods.WithLabelValues(deployment.Namespace, "deferred").Inc()
+
+	resp := admission.Allowed("placement waits for the queue")
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[QueueAdmissionAnnotation] = QueueAdmissionPending
+	if patched, err := PatchResponse(req.Object.Raw, pod); err != nil {
+		log.Error(err, "Failed to compute the pod patch, admitting it unannotated")
+	} else {
+		resp = patched
+	}
+	return resp
+}
+
+// unplacedNodeSelector returns the nodeSelector of the pod before placement, which the applied rule
+// is told apart from. The nodeSelector a queue assigned is part of the placement of the pods it
+// releases.
+func unplacedNodeSelector(pod *corev1.Pod) map[string]string {
+	if ReleasedByQueue(pod) {
+		return nil
+	}
+	return copyStringMap(pod.Spec.NodeSelector)
+}
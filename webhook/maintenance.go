@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+// MaintenanceUncheckedAnnotation records on a pod why it was placed without excluding the nodes of
+// active MaintenanceWindows
+const MaintenanceUncheckedAnnotation = "smart-scheduler.io/maintenance-unchecked"
+
+// MaintenanceTracker answers questions about the MaintenanceWindows currently in effect
+type MaintenanceTracker struct {
+	Client client.Client
+	Log    logr.Logger
+	// APIs, when set, tells whether the MaintenanceWindow CRD is installed; without it no window is
+	// ever in effect and none are listed
+	APIs *InstalledAPIs
+}
+
+// NewMaintenanceTracker creates a new maintenance tracker
+func NewMaintenanceTracker(client client.Client, log logr.Logger) *MaintenanceTracker {
+	return &MaintenanceTracker{
+		Client: client,
+		Log:    log,
+	}
+}
+
+// ActiveWindows returns the maintenance windows that cover the current time
+func (mt *MaintenanceTracker) ActiveWindows(ctx context.Context) ([]smartschedulerv1.MaintenanceWindow, error) {
+	if !mt.APIs.Installed(KindMaintenanceWindow) {
+		return nil, nil
+	}
+
+	windowList := &smartschedulerv1.MaintenanceWindowList{}
+	if err := mt.Client.List(ctx, windowList); err != nil {
+		// Without the CRD installed there can be no maintenance in effect
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+
+	now := metav1.Now()
+	var active []smartschedulerv1.MaintenanceWindow
+	for _, window := range windowList.Items {
+		if window.IsActive(now) {
+			active = append(active, window)
+		}
+	}
+
+	return active, nil
+}
+
+// NodesUnderMaintenance returns the sorted names of the nodes selected by any active window
+func (mt *MaintenanceTracker) NodesUnderMaintenance(ctx context.Context) ([]string, error) {
+	windows, err := mt.ActiveWindows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeNames := make(map[string]bool)
+	for _, window := range windows {
+		nodeList := &corev1.NodeList{}
+		err := mt.Client.List(ctx, nodeList, &client.ListOptions{
+			LabelSelector: labels.SelectorFromSet(window.Spec.NodeSelector),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes for maintenance window %s: %w", window.Name, err)
+		}
+		for _, node := range nodeList.Items {
+			nodeNames[node.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(nodeNames))
+	for name := range nodeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// EvictionsHeld reports whether rebalancing evictions must be held, returning the name of the
+// window responsible. Any active window holds evictions cluster-wide.
+func (mt *MaintenanceTracker) EvictionsHeld(ctx context.Context) (bool, string, error) {
+	windows, err := mt.ActiveWindows(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	if len(windows) == 0 {
+		return false, "", nil
+	}
+	return true, windows[0].Name, nil
+}
+
+// ExcludeNodes adds a required node affinity keeping the pod off the given nodes.
+// The requirement is added to every existing node selector term so the pod's own terms still apply.
+func ExcludeNodes(pod *corev1.Pod, nodeNames []string) {
+	if len(nodeNames) == 0 {
+		return
+	}
+
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      "metadata.name",
+		Operator: corev1.NodeSelectorOpNotIn,
+		Values:   nodeNames,
+	}
+
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchFields: []corev1.NodeSelectorRequirement{requirement},
+			}},
+		}
+		return
+	}
+
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchFields = append(required.NodeSelectorTerms[i].MatchFields, requirement)
+	}
+}
@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManagedFieldsAnnotation records the pod fields the webhook set, as a JSON object from field path
+// to the value set, e.g. {"spec.nodeSelector[node-type]":"spot"}. Affinity and annotation values
+// are recorded as a hash. A field whose value later differs was changed by someone else.
+const ManagedFieldsAnnotation = "smart-scheduler.io/managed-fields"
+
+// Field paths of the managed fields: map entries are named by their key in brackets
+const (
+	nodeSelectorField = "spec.nodeSelector"
+	labelsField       = "metadata.labels"
+	annotationsField  = "metadata.annotations"
+	affinityField     = "spec.affinity"
+)
+
+// managedFieldValues returns the value of every field the webhook may set on the pod, by path
+func managedFieldValues(pod *corev1.Pod) map[string]string {
+	values := make(map[string]string, len(pod.Spec.NodeSelector)+len(pod.Labels)+len(pod.Annotations)+1)
+	for key, value := range pod.Spec.NodeSelector {
+		values[nodeSelectorField+"["+key+"]"] = value
+	}
+	for key, value := range pod.Labels {
+		values[labelsField+"["+key+"]"] = value
+	}
+	for key, value := range pod.Annotations {
+		// The rebalancer's scale-down hints adjust the deletion cost of surge pods afterwards
+		if key != ManagedFieldsAnnotation && key != PodDeletionCostAnnotation {
+			values[annotationsField+"["+key+"]"] = fieldHash([]byte(value))
+		}
+	}
+	if pod.Spec.Affinity != nil {
+		data, _ := json.Marshal(pod.Spec.Affinity)
+		values[affinityField] = fieldHash(data)
+	}
+	return values
+}
+
+// fieldHash returns the hash a field value too large to record is recorded as
+func fieldHash(data []byte) string {
+	hash := fnv.New64a()
+	hash.Write(data)
+	return fmt.Sprintf("fnv:%016x", hash.Sum64())
+}
+
+// RecordManagedFields annotates the pod with the fields that differ from the admitted object, whose
+// raw JSON is given. Nothing is recorded when the webhook changed none.
+func RecordManagedFields(raw []byte, pod *corev1.Pod) error {
+	original := &corev1.Pod{}
+	if err := json.Unmarshal(raw, original); err != nil {
+		return fmt.Errorf("failed to decode admitted pod: %w", err)
+	}
+	before := managedFieldValues(original)
+	managed := make(map[string]string)
+	for path, value := range managedFieldValues(pod) {
+		if previous, ok := before[path]; !ok || previous != value {
+			managed[path] = value
+		}
+	}
+	if len(managed) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(managed)
+	if err != nil {
+		return err
+	}
+	pod.Annotations[ManagedFieldsAnnotation] = string(data)
+	return nil
+}
+
+// ManagedFieldConflicts returns, sorted, the recorded fields whose value on the pod is no longer the
+// value the webhook set, including removed ones
+func ManagedFieldConflicts(pod *corev1.Pod) ([]string, error) {
+	data, ok := pod.Annotations[ManagedFieldsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var managed map[string]string
+	if err := json.Unmarshal([]byte(data), &managed); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ManagedFieldsAnnotation, err)
+	}
+
+	current := managedFieldValues(pod)
+	var conflicts []string
+	for path, value := range managed {
+		if current[path] != value {
+			conflicts = append(conflicts, path)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+// ConflictingFieldManager names the field manager that changed the fields after the pod was
+// created: the first manager owning one of them or, for removed fields, the manager of the latest
+// change. Changes made while the pod was created, e.g. by a later mutating webhook, are attributed
+// to its creator and cannot be told apart, so an empty name is returned for them.
+func ConflictingFieldManager(pod *corev1.Pod, paths []string) string {
+	var latest *metav1.ManagedFieldsEntry
+	for i := range pod.ManagedFields {
+		entry := &pod.ManagedFields[i]
+		if entry.Subresource != "" || entry.Time == nil || !entry.Time.After(pod.CreationTimestamp.Time) {
+			continue
+		}
+		for _, path := range paths {
+			if ownsField(entry, path) {
+				return entry.Manager
+			}
+		}
+		if latest == nil || entry.Time.After(latest.Time.Time) {
+			latest = entry
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Manager
+}
+
+// ownsField reports whether the managed fields entry owns the field path
+func ownsField(entry *metav1.ManagedFieldsEntry, path string) bool {
+	if entry.FieldsV1 == nil {
+		return false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+		return false
+	}
+
+	field, key, hasKey := strings.Cut(strings.TrimSuffix(path, "]"), "[")
+	segments := strings.Split(field, ".")
+	if hasKey {
+		segments = append(segments, key)
+	}
+	for _, segment := range segments {
+		next, ok := fields["f:"+segment].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		fields = next
+	}
+	return true
+}
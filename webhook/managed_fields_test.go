@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestManagedFields(t *testing.T) {
+	admitted := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-1",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"team": "checkout"},
+		},
+		Spec: corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/os": "linux"}},
+	}
+	raw, err := json.Marshal(admitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := admitted.DeepCopy()
+	pod.Spec.NodeSelector["node-type"] = "spot"
+	pod.Labels[RuleLabel] = "spot"
+	pod.Annotations["smart-scheduler.io/processed"] = "true"
+	if err := RecordManagedFields(raw, pod); err != nil {
+		t.Fatalf("RecordManagedFields() error = %v", err)
+	}
+
+	var managed map[string]string
+	if err := json.Unmarshal([]byte(pod.Annotations[ManagedFieldsAnnotation]), &managed); err != nil {
+		t.Fatalf("Invalid managed fields annotation: %v", err)
+	}
+	wantPaths := []string{"metadata.annotations[smart-scheduler.io/processed]", "metadata.labels[smart-scheduler.io/rule]", "spec.nodeSelector[node-type]"}
+	var paths []string
+	for path := range managed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Recorded fields = %v, want only the fields the webhook set %v", paths, wantPaths)
+	}
+
+	if conflicts, err := ManagedFieldConflicts(pod); err != nil || len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts on the admitted pod, got %v, %v", conflicts, err)
+	}
+
+	// Another mutator drops the nodeSelector, and a later update relabels the pod
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod.CreationTimestamp = created
+	delete(pod.Spec.NodeSelector, "node-type")
+	pod.Labels[RuleLabel] = "ondemand"
+	pod.Labels["app"] = "web-v2"
+	conflicts, err := ManagedFieldConflicts(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConflicts := []string{"metadata.labels[smart-scheduler.io/rule]", "spec.nodeSelector[node-type]"}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("ManagedFieldConflicts() = %v, want %v", conflicts, wantConflicts)
+	}
+
+	if manager := ConflictingFieldManager(pod, conflicts); manager != "" {
+		t.Errorf("Expected no manager for changes made at creation, got %q", manager)
+	}
+	later := metav1.NewTime(time.Now())
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{
+		{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, Time: &created,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:app":{}}}}`)}},
+		{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate, Time: &later, Subresource: "status",
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:status":{}}`)}},
+		{Manager: "relabeler", Operation: metav1.ManagedFieldsOperationUpdate, Time: &later,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:smart-scheduler.io/rule":{}}}}`)}},
+	}
+	if manager := ConflictingFieldManager(pod, conflicts); manager != "relabeler" {
+		t.Errorf("ConflictingFieldManager() = %q, want the manager owning the changed label", manager)
+	}
+}
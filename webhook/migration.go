@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MigrationAnnotation paces the rebalancing that follows a strategy change, e.g.
+	// {"stepPercent":10,"interval":"1h"} moves 10% of the replicas to the new distribution per hour
+	MigrationAnnotation = "smart-scheduler.io/migration"
+
+	// DefaultMigrationInterval is the time between the steps of a migration without an interval
+	DefaultMigrationInterval = time.Hour
+)
+
+// Migration moves a deployment's pods to the distribution of a changed strategy in steps, instead
+// of rebalancing them all at once
+type Migration struct {
+	// StepPercent of the deployment's replicas moved per step (1-100)
+	StepPercent int `json:"stepPercent"`
+	// Interval between steps; zero uses DefaultMigrationInterval
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// ParseMigration decodes the migration annotation
+func ParseMigration(data string) (*Migration, error) {
+	migration := &Migration{}
+	if err := json.Unmarshal([]byte(data), migration); err != nil {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", MigrationAnnotation, err))
+	}
+	if migration.StepPercent < 1 || migration.StepPercent > 100 {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("migration step must be between 1 and 100 percent: %d", migration.StepPercent))
+	}
+	if migration.Interval.Duration < 0 {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("migration interval must not be negative: %s", migration.Interval.Duration))
+	}
+	return migration, nil
+}
+
+// StepInterval returns the time between steps
+func (m *Migration) StepInterval() time.Duration {
+	if m.Interval.Duration > 0 {
+		return m.Interval.Duration
+	}
+	return DefaultMigrationInterval
+}
+
+// StepPods returns the pods of the deployment moved per step, at least one
+func (m *Migration) StepPods(deployment *appsv1.Deployment) int {
+	return max((int(deploymentReplicas(deployment))*m.StepPercent+99)/100, 1)
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// MinOnDemandAnnotation sets how many of a deployment's pods must run on on-demand rules, e.g. "3",
+// whatever the strategy's weights say. Admission places pods on on-demand rules until they hold
+// that many, and the rebalancer never evicts below it.
+const MinOnDemandAnnotation = "smart-scheduler.io/min-on-demand"
+
+// OnDemandRule reports whether the rule selects on-demand capacity: neither preemptible nor spot
+func OnDemandRule(rule PlacementRule) bool {
+	return !rule.Preemptible && !SpotRule(rule)
+}
+
+// ParseMinOnDemand parses the min-on-demand annotation; an empty annotation sets no floor
+func ParseMinOnDemand(annotation string) (int, error) {
+	if annotation == "" {
+		return 0, nil
+	}
+	floor, err := strconv.Atoi(annotation)
+	if err != nil || floor < 0 {
+		return 0, fmt.Errorf("invalid %s annotation, must be a non-negative number: %s", MinOnDemandAnnotation, annotation)
+	}
+	return floor, nil
+}
+
+// MinOnDemand returns the number of the deployment's pods that must run on on-demand rules
+func MinOnDemand(deployment *appsv1.Deployment) (int, error) {
+	return ParseMinOnDemand(deployment.Annotations[MinOnDemandAnnotation])
+}
+
+// OnDemandCount returns how many of the counted pods the strategy's on-demand rules hold
+func OnDemandCount(strategy *PlacementStrategy, counts map[RuleKey]int) int {
+	count := 0
+	for _, rule := range strategy.Rules {
+		if OnDemandRule(rule) {
+			count += counts[rule.Key()]
+		}
+	}
+	return count
+}
+
+// HoldMinOnDemand limits the strategy to its on-demand rules while they hold fewer than floor of
+// the pods placed so far, and reports whether it did. A strategy without on-demand rules, or with
+// nothing else, is returned unchanged.
+func HoldMinOnDemand(strategy *PlacementStrategy, currentCounts map[RuleKey]int, floor int) (*PlacementStrategy, bool) {
+	if floor <= 0 || OnDemandCount(strategy, currentCounts) >= floor {
+		return strategy, false
+	}
+	held := keepRules(strategy, OnDemandRule)
+	return held, held != strategy
+}
+
+// ExpectedMinOnDemand moves expected pods from the other rules, last rule first, onto the
+// on-demand rule expecting the most pods until the on-demand rules expect floor of them, or all of
+// them when there are fewer. Expected counts already meeting the floor are returned unchanged.
+func ExpectedMinOnDemand(strategy *PlacementStrategy, expected map[RuleKey]int, floor int) map[RuleKey]int {
+	total := 0
+	target := RuleKey("")
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		total += expected[key]
+		if OnDemandRule(rule) && (target == "" || expected[key] > expected[target]) {
+			target = key
+		}
+	}
+	short := min(floor, total) - OnDemandCount(strategy, expected)
+	if target == "" || short <= 0 {
+		return expected
+	}
+
+	held := make(map[RuleKey]int, len(expected))
+	for key, count := range expected {
+		held[key] = count
+	}
+	for i := len(strategy.Rules) - 1; i >= 0 && short > 0; i-- {
+		rule := strategy.Rules[i]
+		if OnDemandRule(rule) {
+			continue
+		}
+		moved := min(held[rule.Key()], short)
+		held[rule.Key()] -= moved
+		held[target] += moved
+		short -= moved
+	}
+	return held
+}
+
+// holdMinOnDemand limits the strategy to its on-demand rules while they hold fewer pods than the
+// deployment's min-on-demand annotation asks for. An invalid annotation sets no floor.
+func (pm *PodMutator) holdMinOnDemand(deployment *appsv1.Deployment, strategy *PlacementStrategy, currentCounts map[RuleKey]int) *PlacementStrategy {
+	floor, err := MinOnDemand(deployment)
+	if err != nil {
+		pm.Log.Error(err, "Ignoring the on-demand floor", "deployment", deployment.Name)
+		return strategy
+	}
+	held, ok := HoldMinOnDemand(strategy, currentCounts, floor)
+	if ok {
+		pm.Log.Info("Placing pod on on-demand rules to hold the on-demand floor", "deployment", deployment.Name,
+			"minOnDemand", floor, "onDemand", OnDemandCount(strategy, currentCounts))
+	} else if floor > OnDemandCount(strategy, currentCounts) && !hasOnDemandRule(strategy) {
+		pm.Log.Info("Strategy has no on-demand rule to hold the on-demand floor", "deployment", deployment.Name, "minOnDemand", floor)
+	}
+	return held
+}
+
+// hasOnDemandRule reports whether any of the strategy's rules selects on-demand capacity
+func hasOnDemandRule(strategy *PlacementStrategy) bool {
+	for _, rule := range strategy.Rules {
+		if OnDemandRule(rule) {
+			return true
+		}
+	}
+	return false
+}
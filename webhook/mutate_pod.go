@@ -2,16 +2,20 @@ package webhook
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -23,13 +27,65 @@ type PodMutator struct {
 	Log          logr.Logger
 	decoder      *admission.Decoder
 	StateManager *StateManager
+	PoolHealth   *PoolHealthChecker
+	Maintenance  *MaintenanceTracker
+	// ImageInspector, when set, resolves image architectures from the registry for pods
+	// that do not carry the image-arch annotation
+	ImageInspector ImageInspector
+	// Unmanaged short-circuits pods of deployments known to have no schedule strategy
+	Unmanaged *UnmanagedCache
+	// StateBreaker switches admissions to informer pod counts while the state store keeps failing
+	StateBreaker *StateCircuitBreaker
+	// Namespaces lists namespaces whose pods are never mutated; nil protects system namespaces only
+	Namespaces *NamespaceGuard
+	// Drainer tracks in-flight admissions for graceful shutdown
+	Drainer *AdmissionDrainer
+	// Forwarder, when set, sends admissions of deployments owned by another webhook replica to it
+	Forwarder *DecisionForwarder
+	// Packing, when set, ranks the nodes of rules with a packing mode by utilization
+	Packing *NodeUtilization
+	// ScaleDown, when set, steers pods away from nodes a node autoscaler is about to remove
+	ScaleDown *ScaleDownTracker
+	// Experiments places pods of deployments with the experiment annotation by their experiment arm
+	Experiments bool
+	// Forecast places each pod in its slot of the distribution planned for the deployment's replicas
+	// instead of against the pods placed so far
+	Forecast bool
+	// SlowStart, when set, keeps pods with large images off the pools of preemptible rules
+	SlowStart *SlowStartDetector
+	// Queue, when set, limits admissions using the state store, serving high-priority pods first
+	Queue *AdmissionQueue
+	// Unschedulable, when set, places pods away from rules whose pods are stuck Unschedulable
+	Unschedulable *UnschedulableSpill
+	// DecisionHook, when set, lets an external endpoint veto or override each placement
+	DecisionHook *DecisionHook
+	// BaseProtection gives pods filling the base a high deletion cost and the deployment's base
+	// PriorityClass, so scale-downs and preemption take weighted pods first
+	BaseProtection bool
+	// WeightTuning applies the weights the weight tuning controller lowered for rules whose pods are
+	// evicted often
+	WeightTuning bool
+	// SpotScores applies the weights the spot score controller lowered for spot rules whose pools
+	// score poorly
+	SpotScores bool
+	// StatefulSets places the pods of StatefulSets with a strategy, by their ordinals
+	StatefulSets bool
+	// APIs tells which smartscheduler.io CRDs are installed; nil assumes all of them
+	APIs *InstalledAPIs
 }
 
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=smartscheduler.io,resources=maintenancewindows,verbs=get;list;watch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims;persistentvolumes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch
 //+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.smart-scheduler.io,admissionReviewVersions=v1
 
 // Handle processes pod admission requests and applies smart scheduling logic
 func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
 	startTime := time.Now()
+	defer pm.Drainer.track()()
+
 	log := pm.Log.WithValues("pod", req.Name, "namespace", req.Namespace, "uid", req.UID, "operation", req.Operation)
 
 	// Add detailed request logging for debugging
@@ -49,6 +105,12 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 			"durationMs", duration.Milliseconds())
 	}()
 
+	// Protected namespaces are skipped even if the webhook configuration sends them here
+	if pm.Namespaces.Protected(req.Namespace) {
+		log.Info("Namespace is protected, skipping smart scheduling")
+		return admission.Allowed("namespace is protected")
+	}
+
 	pod := &corev1.Pod{}
 	err := pm.decoder.Decode(req, pod)
 	if err != nil {
@@ -68,11 +130,20 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 	// Skip if pod already has smart-scheduler annotations (to avoid infinite loops)
 	if pod.Annotations != nil {
 		if _, exists := pod.Annotations["smart-scheduler.io/processed"]; exists {
+			// A reinvocation after other webhooks injected sidecars shows the pod's full size
+			if req.Operation == admissionv1.Create {
+				pm.observeSidecars(ctx, pod, log)
+			}
 			log.Info("Pod already processed by smart scheduler, skipping")
 			return admission.Allowed("")
 		}
 	}
 
+	// DaemonSet and static pods are pinned to their node; skip them before any owner lookup
+	if reason, unsupported := UnsupportedPodReason(pod); unsupported {
+		return skipUnsupportedPod(log, reason)
+	}
+
 	// Check if pod has an owner (e.g., Deployment, ReplicaSet)
 	if len(pod.OwnerReferences) == 0 {
 		log.Info("Pod has no owner references, skipping smart scheduling")
@@ -90,8 +161,15 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 			"blockOwnerDeletion", ownerRef.BlockOwnerDeletion != nil && *ownerRef.BlockOwnerDeletion)
 	}
 
-	// Find the parent Deployment by traversing owner references
-	deployment, err := pm.findParentDeployment(ctx, pod)
+	// Pods of deployments known to be unmanaged need no lookups at all
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef != nil && pm.Unmanaged != nil && pm.Unmanaged.IsUnmanaged(ownerRef.UID) {
+		log.Info("Parent deployment has no schedule strategy (cached), allowing default scheduling")
+		return admission.Allowed("")
+	}
+
+	// Find the parent Deployment or StatefulSet by traversing owner references
+	deployment, err := pm.findOwner(ctx, pod)
 	if err != nil {
 		log.Error(err, "Failed to find parent deployment")
 		// Don't fail the request, allow default scheduling
@@ -104,6 +182,7 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 	}
 
 	log.Info("Found parent deployment",
+		"kind", WorkloadKind(deployment),
 		"deploymentName", deployment.Name,
 		"deploymentNamespace", deployment.Namespace,
 		"deploymentUID", deployment.UID,
@@ -114,34 +193,112 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 	annotations := deployment.Annotations
 	if annotations == nil {
 		log.Info("Deployment has no annotations, allowing default scheduling")
+		pm.markUnmanaged(ownerRef, deployment)
 		return admission.Allowed("")
 	}
 
 	log.Info("Deployment annotations found",
 		"annotationCount", len(annotations),
-		"hasScheduleStrategy", annotations["smart-scheduler.io/schedule-strategy"] != "")
+		"hasScheduleStrategy", annotations[ScheduleStrategyAnnotation] != "",
+		"hasPriorityStrategies", annotations[PriorityStrategiesAnnotation] != "")
 
-	scheduleStrategy, exists := annotations["smart-scheduler.io/schedule-strategy"]
+	// Priority tiers are resolved from the pod itself so each pod gets the strategy for its class
+	scheduleStrategy, exists, err := ResolveScheduleStrategy(annotations, pod.Spec.PriorityClassName)
+	if err != nil {
+		log.Error(err, "Ignoring priority strategies, using default schedule strategy")
+	}
 	if !exists {
 		log.Info("No schedule strategy annotation found, allowing default scheduling")
+		pm.markUnmanaged(ownerRef, deployment)
 		return admission.Allowed("")
 	}
 
-	log.Info("Found scheduling strategy", "strategy", scheduleStrategy, "deployment", deployment.Name)
+	log.Info("Found scheduling strategy", "strategy", scheduleStrategy, "deployment", deployment.Name,
+		"priorityClassName", pod.Spec.PriorityClassName)
+
+	// The deployment stays managed, so its other pods still need this webhook; an invalid selector
+	// places every pod
+	podSelector, err := StrategyPodSelector(deployment)
+	if err != nil {
+		log.Error(err, "Ignoring pod selector, placing every pod of the deployment")
+	}
+	if !podSelector.Matches(labels.Set(pod.Labels)) {
+		log.Info("Pod not selected by the strategy's pod selector, allowing default scheduling",
+			"podSelector", podSelector.String())
+		return admission.Allowed("pod not selected by the strategy")
+	}
+
+	// Pods created with a node name never reach the scheduler, so they cannot be redirected
+	if pod.Spec.NodeName != "" {
+		return skipPreBoundPod(req, pod, log)
+	}
+
+	// Pods held by a queue are placed once it admits them, on the nodes it assigned
+	if AwaitingQueueAdmission(pod) {
+		return deferToQueue(req, pod, deployment, log)
+	}
 
 	// Parse the placement strategy
-	strategy, err := ParsePlacementStrategy(scheduleStrategy)
+	strategy, err := ParsePlacementStrategyCached(scheduleStrategy)
 	if err != nil {
 		log.Error(err, "Failed to parse placement strategy", "strategy", scheduleStrategy)
 		// Don't fail the request, allow default scheduling
 		return pm.allowWithFallback(log, fmt.Sprintf("invalid placement strategy: %v", err))
 	}
 
+	// Percentage and PodDisruptionBudget bases follow the deployment as it scales
+	strategy, err = ResolveBase(ctx, pm.Client, deployment, strategy)
+	if err != nil {
+		log.Error(err, "Failed to derive the base, using the configured base", "baseFrom", strategy.BaseFrom)
+	}
+	strategy = pm.withWeightAdjustments(deployment, strategy)
+	if strategy, err = WithRuleRenames(deployment, strategy); err != nil {
+		log.Error(err, "Ignoring rule renames")
+	}
+
 	log.Info("Parsed placement strategy", "base", strategy.Base, "rules", len(strategy.Rules))
 
-	// Get current placement state using StateManager
-	placementState, err := pm.StateManager.GetPlacementState(ctx, deployment, strategy)
+	// Only the replica owning the deployment places its pods, so replicas never race on its counts
+	if resp, forwarded := pm.Forwarder.Forward(ctx, deployment.Namespace+"/"+deployment.Name, req); forwarded {
+		log.Info("Admission decided by the owning webhook replica", "allowed", resp.Allowed)
+		return resp
+	}
+
+	// A running experiment places each pod by the strategy of the arm it is assigned to
+	if experiment := pm.experimentFor(deployment, scheduleStrategy, log); experiment != nil {
+		return pm.handleExperiment(ctx, req, pod, deployment, experiment, scheduleStrategy, log)
+	}
+
+	// Under overload only high-priority pods wait for the state store; the others are placed statelessly
+	release, admitted := pm.Queue.Acquire(ctx, AdmissionPriorityOf(pod, deployment))
+	if !admitted {
+		log.Info("Webhook overloaded, using informer pod counts", "priority", pod.Spec.Priority)
+		return pm.applyStrategyWithFallback(ctx, req, pod, deployment, strategy, log)
+	}
+	defer release()
+
+	// Admissions of the same deployment take turns from reading the state to recording their pod
+	unlock, err := pm.StateManager.LockDeployment(ctx, deployment)
 	if err != nil {
+		log.Info("Timed out waiting for the deployment's placement state, using informer pod counts")
+		return pm.applyStrategyWithFallback(ctx, req, pod, deployment, strategy, log)
+	}
+	defer unlock()
+
+	// The replica holding the deployment's counter Lease places against the shared counts; the others
+	// place against the stored state and leave their pods for the holder to add
+	if err := pm.StateManager.ClaimCounters(ctx, deployment, strategy); errors.Is(err, ErrCountersHeld) {
+		log.Info("Another webhook replica holds the deployment's placement counters, using the stored placement state")
+	} else if err != nil {
+		log.Error(err, "Failed to claim placement counters, using the stored placement state")
+	}
+
+	// Get current placement state using StateManager
+	placementState, err := pm.getPlacementState(ctx, deployment, strategy)
+	if errors.Is(err, ErrStateStoreDegraded) {
+		log.Info("Placement state store is degraded, using informer pod counts")
+		return pm.applyStrategyWithFallback(ctx, req, pod, deployment, strategy, log)
+	} else if err != nil {
 		log.Error(err, "Failed to get placement state")
 		// Don't fail the request, try to continue with basic logic
 		return pm.applyStrategyWithFallback(ctx, req, pod, deployment, strategy, log)
@@ -149,56 +306,146 @@ func (pm *PodMutator) Handle(ctx context.Context, req admission.Request) admissi
 
 	log.Info("Current placement state", "totalPods", placementState.TotalPods, "counts", placementState.PodCounts)
 
-	// Apply the placement strategy to the pod
-	originalPod := pod.DeepCopy()
-	err = ApplyPlacementStrategy(pod, strategy, placementState.PodCounts)
+	// During a rollout the new ReplicaSet is placed as if it were alone
+	currentCounts, err := pm.currentGenerationCounts(ctx, deployment, ownerRef, strategy, placementState.PodCounts)
+	if err != nil {
+		log.Error(err, "Failed to exclude pods of older ReplicaSets, placing against all pods")
+		currentCounts = placementState.PodCounts
+	}
+
+	// Apply the placement strategy to the pod. Only the original nodeSelector is needed to work out
+	// the applied rule; the patch is computed against the raw request object.
+	originalNodeSelector := unplacedNodeSelector(pod)
+	err = pm.applyStrategy(ctx, pod, deployment, strategy, currentCounts, pm.forecastReplicas(deployment))
 	if err != nil {
 		log.Error(err, "Failed to apply placement strategy")
 		// Don't fail the request, allow default scheduling
 		return pm.allowWithFallback(log, fmt.Sprintf("failed to apply placement strategy: %v", err))
 	}
 
+	appliedRuleKey := pm.getAppliedRuleKey(originalNodeSelector, pod, strategy)
+
 	// Mark pod as processed
 	if pod.Annotations == nil {
 		pod.Annotations = make(map[string]string)
 	}
 	pod.Annotations["smart-scheduler.io/processed"] = "true"
 	pod.Annotations["smart-scheduler.io/strategy-applied"] = scheduleStrategy
-	pod.Annotations["smart-scheduler.io/placement-rule"] = pm.getAppliedRuleKey(originalPod, pod, strategy)
+	pod.Annotations["smart-scheduler.io/placement-rule"] = appliedRuleKey.String()
+	pm.propagatePlacement(pod, deployment, appliedRuleKey)
 
 	// Update placement state
-	appliedRuleKey := pm.getAppliedRuleKey(originalPod, pod, strategy)
 	if appliedRuleKey != "" {
 		log.Info("Updating placement state", "appliedRuleKey", appliedRuleKey)
-		err = pm.StateManager.IncrementPodCount(ctx, deployment, appliedRuleKey)
+		err = pm.incrementPodCount(ctx, deployment, strategy, appliedRuleKey)
 		if err != nil {
 			log.Error(err, "Failed to update placement state, continuing without state update")
 			// Don't fail the request, just log the error
 		}
 	}
 
-	// Marshal the modified pod object
-	modifiedPodBytes, err := json.Marshal(pod)
+	// Record what this admission set, so the placement audit can tell when others change it
+	if err := RecordManagedFields(req.Object.Raw, pod); err != nil {
+		log.Error(err, "Failed to record the managed fields")
+	}
+
+	// Patch every field placement changed between the admitted object and the modified pod
+	resp, err := PatchResponse(req.Object.Raw, pod)
 	if err != nil {
-		log.Error(err, "Failed to marshal modified pod")
-		return pm.allowWithFallback(log, fmt.Sprintf("failed to marshal pod: %v", err))
+		log.Error(err, "Failed to compute the pod patch")
+		return pm.allowWithFallback(log, fmt.Sprintf("failed to patch pod: %v", err))
 	}
 
 	log.Info("Successfully applied smart scheduling",
 		"nodeSelector", pod.Spec.NodeSelector,
 		"hasAffinity", pod.Spec.Affinity != nil,
 		"appliedRule", appliedRuleKey,
-		"modifiedObjectSize", len(modifiedPodBytes))
+		"patchOperations", len(resp.Patches))
+
+	return withPlacementWarnings(resp, pod)
+}
+
+// experimentFor returns the deployment's running experiment. Pods of priority tiers keep their tier's
+// strategy and are not part of the experiment.
+func (pm *PodMutator) experimentFor(deployment *appsv1.Deployment, scheduleStrategy string, log logr.Logger) *Experiment {
+	data, ok := deployment.Annotations[ExperimentAnnotation]
+	if !pm.Experiments || !ok || scheduleStrategy != deployment.Annotations[ScheduleStrategyAnnotation] {
+		return nil
+	}
+
+	experiment, err := ParseExperiment(data)
+	if err != nil {
+		log.Error(err, "Ignoring placement experiment")
+		return nil
+	}
+	return experiment
+}
 
-	// Create JSON patch between original and modified pod
-	originalBytes, err := json.Marshal(originalPod)
+// handleExperiment admits the pod with the placement of its experiment arm
+func (pm *PodMutator) handleExperiment(ctx context.Context, req admission.Request, pod *corev1.Pod, deployment *appsv1.Deployment, experiment *Experiment, scheduleStrategy string, log logr.Logger) admission.Response {
+	armStrategy, appliedRuleKey, err := pm.applyExperiment(ctx, pod, deployment, experiment, scheduleStrategy)
 	if err != nil {
-		log.Error(err, "Failed to marshal original pod")
-		return pm.allowWithFallback(log, fmt.Sprintf("failed to marshal original pod: %v", err))
+		log.Error(err, "Failed to apply experiment placement", "experiment", experiment.Name)
+		return pm.allowWithFallback(log, fmt.Sprintf("failed to apply experiment %s: %v", experiment.Name, err))
 	}
 
-	// Return patch response
-	return admission.PatchResponseFromRaw(originalBytes, modifiedPodBytes)
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations["smart-scheduler.io/processed"] = "true"
+	pod.Annotations["smart-scheduler.io/strategy-applied"] = armStrategy
+	pod.Annotations["smart-scheduler.io/placement-rule"] = appliedRuleKey.String()
+	pm.propagatePlacement(pod, deployment, appliedRuleKey)
+	if err := RecordManagedFields(req.Object.Raw, pod); err != nil {
+		log.Error(err, "Failed to record the managed fields")
+	}
+
+	resp, err := PatchResponse(req.Object.Raw, pod)
+	if err != nil {
+		log.Error(err, "Failed to compute the pod patch")
+		return pm.allowWithFallback(log, fmt.Sprintf("failed to patch pod: %v", err))
+	}
+
+	log.Info("Successfully applied experiment placement",
+		"experiment", experiment.Name,
+		"arm", pod.Labels[ExperimentArmLabel],
+		"nodeSelector", pod.Spec.NodeSelector,
+		"appliedRule", appliedRuleKey)
+	return withPlacementWarnings(resp, pod)
+}
+
+// getPlacementState reads the placement state through the circuit breaker, when one is configured
+func (pm *PodMutator) getPlacementState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, error) {
+	if pm.StateBreaker == nil {
+		return pm.StateManager.GetPlacementState(ctx, deployment, strategy)
+	}
+
+	var state *PlacementState
+	err := pm.StateBreaker.Do(ctx, func(ctx context.Context) error {
+		var err error
+		state, err = pm.StateManager.GetPlacementState(ctx, deployment, strategy)
+		return err
+	})
+	return state, err
+}
+
+// incrementPodCount records the placed pod through the circuit breaker, when one is configured
+func (pm *PodMutator) incrementPodCount(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy, ruleKey RuleKey) error {
+	if pm.StateBreaker == nil {
+		return pm.StateManager.IncrementPodCount(ctx, deployment, strategy, ruleKey)
+	}
+
+	return pm.StateBreaker.Do(ctx, func(ctx context.Context) error {
+		return pm.StateManager.IncrementPodCount(ctx, deployment, strategy, ruleKey)
+	})
+}
+
+// markUnmanaged remembers that pods of the owning ReplicaSet need no smart scheduling
+func (pm *PodMutator) markUnmanaged(ownerRef *metav1.OwnerReference, deployment *appsv1.Deployment) {
+	if pm.Unmanaged == nil || ownerRef == nil {
+		return
+	}
+	pm.Unmanaged.MarkUnmanaged(ownerRef.UID, types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name})
 }
 
 // allowWithFallback allows the request with a warning annotation
@@ -218,8 +465,16 @@ func (pm *PodMutator) applyStrategyWithFallback(ctx context.Context, req admissi
 		return pm.allowWithFallback(log, "failed to get pod counts")
 	}
 
-	originalPod := pod.DeepCopy()
-	err = ApplyPlacementStrategy(pod, strategy, currentCounts)
+	// During a rollout the new ReplicaSet is placed as if it were alone
+	generationCounts, err := pm.currentGenerationCounts(ctx, deployment, metav1.GetControllerOf(pod), strategy, currentCounts)
+	if err != nil {
+		log.Error(err, "Failed to exclude pods of older ReplicaSets, placing against all pods")
+	} else {
+		currentCounts = generationCounts
+	}
+
+	originalNodeSelector := unplacedNodeSelector(pod)
+	err = pm.applyStrategy(ctx, pod, deployment, strategy, currentCounts, pm.forecastReplicas(deployment))
 	if err != nil {
 		log.Error(err, "Failed to apply placement strategy in fallback mode")
 		return pm.allowWithFallback(log, "failed to apply strategy in fallback")
@@ -231,100 +486,337 @@ func (pm *PodMutator) applyStrategyWithFallback(ctx context.Context, req admissi
 	}
 	pod.Annotations["smart-scheduler.io/processed"] = "true"
 	pod.Annotations["smart-scheduler.io/fallback-mode"] = "true"
+	pm.propagatePlacement(pod, deployment, pm.getAppliedRuleKey(originalNodeSelector, pod, strategy))
 
-	// Marshal the modified pod object
-	modifiedPodBytes, err := json.Marshal(pod)
-	if err != nil {
-		log.Error(err, "Failed to marshal modified pod in fallback mode")
-		return pm.allowWithFallback(log, fmt.Sprintf("failed to marshal pod: %v", err))
+	if err := RecordManagedFields(req.Object.Raw, pod); err != nil {
+		log.Error(err, "Failed to record the managed fields")
 	}
 
-	// Create JSON patch between original and modified pod
-	originalBytes, err := json.Marshal(originalPod)
+	resp, err := PatchResponse(req.Object.Raw, pod)
 	if err != nil {
-		log.Error(err, "Failed to marshal original pod in fallback mode")
-		return pm.allowWithFallback(log, fmt.Sprintf("failed to marshal original pod: %v", err))
+		log.Error(err, "Failed to compute the pod patch in fallback mode")
+		return pm.allowWithFallback(log, fmt.Sprintf("failed to patch pod: %v", err))
 	}
 
 	log.Info("Successfully applied smart scheduling in fallback mode", "nodeSelector", pod.Spec.NodeSelector)
-	return admission.PatchResponseFromRaw(originalBytes, modifiedPodBytes)
+	return withPlacementWarnings(resp, pod)
+}
+
+// applyStrategy places the pod by the strategy according to its mode. With replicas above zero,
+// weighted strategies put the pod in its slot of the distribution planned for that many pods, and
+// StatefulSet pods take the slot of their ordinal. A rule is skipped when:
+//   - its nodes cannot run the pod's platform
+//   - it leaves the pod's topology spread constraints unsatisfiable
+//   - it is preemptible and the pod starts slowly
+//
+// Pods below the deployment's on-demand floor go to on-demand rules, surge pods of a rolling update
+// to the surge targets, and pods released by a queue keep to the nodes it assigned. The decision
+// hook, when set, reviews the rule before the node preferences are added.
+func (pm *PodMutator) applyStrategy(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy, currentCounts map[RuleKey]int, replicas int) error {
+	platform, err := ResolvePlatform(ctx, pm.ImageInspector, pod)
+	if err != nil {
+		pm.Log.Info("Skipping image architecture check", "pod", pod.Name, "reason", err.Error())
+	}
+
+	strategy, err = FilterCompatibleRules(platform, strategy)
+	if err != nil {
+		return err
+	}
+
+	// A pod released by a queue stays on the nodes the queue admitted its quota for
+	if ReleasedByQueue(pod) {
+		if strategy, err = pm.withinQueueAssignment(pod, deployment, strategy); err != nil {
+			return err
+		}
+	}
+
+	// The deployment's on-demand floor holds whatever the weights say
+	strategy = pm.holdMinOnDemand(deployment, strategy, currentCounts)
+
+	// Pods that take long to start would rarely be running before preemptible capacity is reclaimed
+	if strategy.HasPreemptibleRules() && pm.SlowStart.IsSlowStart(ctx, pod) {
+		pm.Log.Info("Pod is slow to start, avoiding preemptible rules", "pod", pod.Name)
+		strategy = AvoidPreemptibleRules(strategy)
+	}
+
+	// Pods sent to a rule whose pods cannot be scheduled would get stuck with them
+	strategy = pm.spillUnschedulable(ctx, deployment, strategy)
+
+	// A rule whose nodes cannot mount the pod's zonal volumes would keep it Pending
+	if strategy, err = pm.avoidVolumeConflicts(ctx, pod, deployment, strategy); err != nil {
+		return err
+	}
+
+	// A rule leaving the pod's topology spread constraints unsatisfiable would keep it Pending
+	strategy = pm.avoidSpreadConflicts(ctx, pod, deployment, strategy)
+
+	// The hook may override the rule, which is then applied to the pod as it was before placement
+	var unplaced *corev1.Pod
+	if pm.DecisionHook != nil {
+		unplaced = pod.DeepCopy()
+	}
+
+	surged := false
+	if pm.isSurgePod(ctx, pod, deployment, strategy) {
+		if surged, err = pm.applySurgeTarget(ctx, pod, strategy); err != nil {
+			return err
+		}
+	}
+
+	ordinal, ordered := pm.statefulSetOrdinal(pod, deployment)
+	switch {
+	case surged:
+		pm.Log.Info("Placed surge pod of a rolling update on a surge target", "deployment", deployment.Name)
+	case strategy.IsFailover():
+		err = ApplyFailoverStrategy(pod, strategy, pm.PoolHealth.HealthFunc(ctx))
+	case ordered:
+		err = ApplyOrdinalStrategy(pod, strategy, ordinal)
+	case replicas > 0:
+		err = ApplyForecastStrategy(pod, strategy, currentCounts, replicas)
+	default:
+		err = ApplyPlacementStrategy(pod, strategy, currentCounts)
+	}
+	if err != nil {
+		return err
+	}
+	if unplaced != nil {
+		if err := pm.reviewPlacement(ctx, pod, unplaced, deployment, strategy, currentCounts); err != nil {
+			return err
+		}
+	}
+	if !surged {
+		pm.protectBasePod(ctx, pod, deployment, strategy, currentCounts)
+	}
+
+	pm.avoidUnhealthyNodes(ctx, pod)
+	pm.avoidScaleDownNodes(ctx, pod)
+	pm.preferPackedNodes(ctx, pod, deployment, strategy)
+	pm.excludeMaintenanceNodes(ctx, pod)
+	return nil
+}
+
+// forecastReplicas returns the number of pods the deployment's placements are planned for, or 0 when
+// pods are placed against the ones placed so far
+func (pm *PodMutator) forecastReplicas(deployment *appsv1.Deployment) int {
+	if !pm.Forecast {
+		return 0
+	}
+	return ForecastReplicas(deployment)
+}
+
+// ForecastReplicas returns the number of pods a forecast plans the deployment's placements for: its
+// replicas. Strategies limited by a pod selector are not planned, since the replicas include the
+// pods they do not select, and 0 is returned.
+func ForecastReplicas(deployment *appsv1.Deployment) int {
+	if _, limited := deployment.Annotations[PodSelectorAnnotation]; limited {
+		return 0
+	}
+	return int(deploymentReplicas(deployment))
+}
+
+// reviewPlacement asks the decision hook about the rule applied to the pod. An override replaces the
+// pod with unplaced, its copy from before placement, and applies the hook's rule to it.
+func (pm *PodMutator) reviewPlacement(ctx context.Context, pod, unplaced *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy, currentCounts map[RuleKey]int) error {
+	candidate := pm.getAppliedRuleKey(unplaced.Spec.NodeSelector, pod, strategy)
+	ruleKey, err := pm.DecisionHook.Review(ctx, pod, deployment, strategy, currentCounts, candidate)
+	if err != nil || ruleKey == candidate {
+		return err
+	}
+
+	rule, _ := ruleByKey(strategy, ruleKey)
+	*pod = *unplaced
+	return applyRule(pod, rule)
+}
+
+// avoidScaleDownNodes steers the pod away from nodes scheduled for removal by a node autoscaler
+func (pm *PodMutator) avoidScaleDownNodes(ctx context.Context, pod *corev1.Pod) {
+	nodeNames, err := pm.ScaleDown.NodesScheduledForRemoval(ctx)
+	if err != nil {
+		pm.Log.Error(err, "Failed to list nodes scheduled for removal, skipping scale-down avoidance")
+		return
+	}
+
+	AvoidNodes(pod, nodeNames)
+}
+
+// preferPackedNodes steers the pod toward the nodes of its rule's pool ranked by the rule's packing mode
+func (pm *PodMutator) preferPackedNodes(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy) {
+	if pm.Packing == nil {
+		return
+	}
+	ruleKey, ok := MatchRuleKey(strategy, pod.Spec.NodeSelector)
+	if !ok {
+		return
+	}
+	for _, rule := range strategy.Rules {
+		if rule.Key() != ruleKey {
+			continue
+		}
+		if err := pm.Packing.Prefer(ctx, pod, deployment, rule); err != nil {
+			pm.Log.Error(err, "Failed to rank nodes by utilization, skipping bin-packing", "rule", ruleKey)
+		}
+		return
+	}
+}
+
+// observeSidecars learns the sidecars injected into the pod of a deployment after it was placed,
+// so the deployment's later pods are sized with them
+func (pm *PodMutator) observeSidecars(ctx context.Context, pod *corev1.Pod, log logr.Logger) {
+	if pm.Packing == nil || pm.Packing.Sidecars == nil {
+		return
+	}
+	deployment, err := pm.findOwner(ctx, pod)
+	if err != nil || deployment == nil {
+		return
+	}
+	if pm.Packing.Sidecars.Observe(pod, deployment) {
+		cpu, memory := pm.Packing.Sidecars.Requests(&corev1.Pod{}, deployment)
+		log.Info("Learned the sidecars injected into the deployment's pods",
+			"deployment", deployment.Name, "cpuMillis", cpu, "memoryBytes", memory)
+	}
+}
+
+// spillUnschedulable drops the rules whose pods are stuck Unschedulable from the strategy. Failing to
+// list the pods keeps the strategy, as stuck pods only delay their own rule.
+func (pm *PodMutator) spillUnschedulable(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) *PlacementStrategy {
+	if pm.Unschedulable == nil {
+		return strategy
+	}
+	pods, err := ListStrategyPods(ctx, pm.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		pm.Log.Error(err, "Failed to list pods, placing without checking for unschedulable pods", "deployment", deployment.Name)
+		return strategy
+	}
+
+	spilled, stuck := pm.Unschedulable.Spill(pods, strategy)
+	if spilled != strategy {
+		pm.Log.Info("Pods of some rules are stuck Unschedulable, placing on the other rules",
+			"deployment", deployment.Name, "unschedulableCounts", stuck, "timeout", pm.Unschedulable.Timeout.String())
+		unschedulableSpills.WithLabelValues(deployment.Namespace, deployment.Name).Inc()
+	}
+	return spilled
+}
+
+// excludeMaintenanceNodes keeps the pod off nodes selected by an active MaintenanceWindow. When the
+// windows cannot be read, the pod keeps its placement without the exclusion, which is recorded in
+// its MaintenanceUncheckedAnnotation and returned as an admission warning.
+func (pm *PodMutator) excludeMaintenanceNodes(ctx context.Context, pod *corev1.Pod) {
+	nodeNames, err := pm.Maintenance.NodesUnderMaintenance(ctx)
+	if err != nil {
+		pm.Log.Error(err, "Failed to determine nodes under maintenance, placing the pod without excluding them", "pod", pod.GenerateName)
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[MaintenanceUncheckedAnnotation] = err.Error()
+		return
+	}
+
+	ExcludeNodes(pod, nodeNames)
+}
+
+// avoidUnhealthyNodes steers the pod away from nodes that are NotReady or report NPD problems
+func (pm *PodMutator) avoidUnhealthyNodes(ctx context.Context, pod *corev1.Pod) {
+	unhealthy, err := pm.PoolHealth.UnhealthyNodes(ctx)
+	if err != nil {
+		pm.Log.Error(err, "Failed to list unhealthy nodes, skipping node avoidance")
+		return
+	}
+
+	nodeNames := make([]string, 0, len(unhealthy))
+	for name := range unhealthy {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+
+	AvoidNodes(pod, nodeNames)
 }
 
 // getAppliedRuleKey determines which rule was applied to the pod
-func (pm *PodMutator) getAppliedRuleKey(originalPod, modifiedPod *corev1.Pod, strategy *PlacementStrategy) string {
+func (pm *PodMutator) getAppliedRuleKey(originalNodeSelector map[string]string, modifiedPod *corev1.Pod, strategy *PlacementStrategy) RuleKey {
 	// Compare nodeSelectors to determine which rule was applied
 	appliedNodeSelector := make(map[string]string)
 
 	// Find newly added nodeSelector entries
 	if modifiedPod.Spec.NodeSelector != nil {
 		for key, value := range modifiedPod.Spec.NodeSelector {
-			if originalPod.Spec.NodeSelector == nil || originalPod.Spec.NodeSelector[key] != value {
+			if originalNodeSelector[key] != value {
 				appliedNodeSelector[key] = value
 			}
 		}
 	}
 
-	// Match against strategy rules
-	for _, rule := range strategy.Rules {
-		if isNodeSelectorSubset(rule.NodeSelector, appliedNodeSelector) {
-			return ruleToString(rule)
-		}
+	// Match against strategy rules with the same precedence used for counting
+	if ruleKey, ok := MatchRuleKey(strategy, appliedNodeSelector); ok {
+		return ruleKey
 	}
 
 	// Fallback to full nodeSelector
-	return nodeSelector2String(appliedNodeSelector)
+	return NodeSelectorKey(appliedNodeSelector)
 }
 
 // getBasicPodCounts gets pod counts without using StateManager
-func (pm *PodMutator) getBasicPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (map[string]int, error) {
-	counts := make(map[string]int)
-
-	// Initialize counts for all rules
-	for _, rule := range strategy.Rules {
-		ruleKey := ruleToString(rule)
-		counts[ruleKey] = 0
+func (pm *PodMutator) getBasicPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (map[RuleKey]int, error) {
+	// Get all pods for this deployment; they are only read, so skip the cache's deep copy
+	pods, err := ListStrategyPods(ctx, pm.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get all pods for this deployment
-	podList := &corev1.PodList{}
+	return CountPodsByRule(pods, strategy), nil
+}
 
-	// Create label selector from deployment
-	labelSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
+// currentGenerationCounts removes the pods of the deployment's other ReplicaSets from counts while a
+// rollout is in progress, so the pod's own ReplicaSet converges to the strategy by itself instead of
+// being skewed by pods that are about to be scaled down. Outside a rollout counts is returned as is.
+func (pm *PodMutator) currentGenerationCounts(ctx context.Context, deployment *appsv1.Deployment, ownerRef *metav1.OwnerReference, strategy *PlacementStrategy, counts map[RuleKey]int) (map[RuleKey]int, error) {
+	if ownerRef == nil || ownerRef.Kind != "ReplicaSet" {
+		return counts, nil
+	}
 
-	err := pm.Client.List(ctx, podList, &client.ListOptions{
-		Namespace:     deployment.Namespace,
-		LabelSelector: labelSelector,
-	})
+	replicaSets, err := ListDeploymentReplicaSets(ctx, pm.Client, deployment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
+		return nil, err
 	}
 
-	// Count pods by their nodeSelector
-	for _, pod := range podList.Items {
-		// Skip pods that are being deleted
-		if pod.DeletionTimestamp != nil {
+	var otherPods []corev1.Pod
+	for _, rs := range replicaSets {
+		if rs.UID == ownerRef.UID || rs.Status.Replicas == 0 {
 			continue
 		}
-
-		// Skip pods that are not running or pending
-		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
-			continue
+		podList := &corev1.PodList{}
+		err := pm.Client.List(ctx, podList,
+			client.InNamespace(deployment.Namespace),
+			client.MatchingFields{PodOwnerUIDField: string(rs.UID)},
+			client.UnsafeDisableDeepCopy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods for replicaset %s: %w", rs.Name, err)
 		}
+		otherPods = append(otherPods, podList.Items...)
+	}
+	otherPods = SelectStrategyPods(deployment, otherPods)
+	if len(otherPods) == 0 {
+		return counts, nil
+	}
 
-		// Convert pod's nodeSelector to string key
-		podKey := nodeSelector2String(pod.Spec.NodeSelector)
-
-		// Find matching rule
-		for _, rule := range strategy.Rules {
-			ruleKey := ruleToString(rule)
-			if podKey == ruleKey || isNodeSelectorSubset(rule.NodeSelector, pod.Spec.NodeSelector) {
-				counts[ruleKey]++
-				break
-			}
+	// Older ReplicaSets only shrink, so their cached pods are subtracted from counts that include
+	// the new ReplicaSet's not yet cached pods
+	generationCounts := make(map[RuleKey]int, len(counts))
+	for ruleKey, count := range counts {
+		generationCounts[ruleKey] = count
+	}
+	for ruleKey, count := range CountPodsByRule(otherPods, strategy) {
+		generationCounts[ruleKey] -= count
+		if generationCounts[ruleKey] < 0 {
+			generationCounts[ruleKey] = 0
 		}
 	}
 
-	return counts, nil
+	pm.Log.Info("Rollout in progress, placing against the pod's ReplicaSet only",
+		"deployment", deployment.Name,
+		"replicaSet", ownerRef.Name,
+		"olderPods", len(otherPods),
+		"counts", generationCounts)
+	return generationCounts, nil
 }
 
 // isNodeSelectorSubset checks if the rule's nodeSelector is a subset of the pod's nodeSelector
@@ -346,38 +838,6 @@ func isNodeSelectorSubset(ruleSelector, podSelector map[string]string) bool {
 	return true
 }
 
-// findParentDeployment finds the parent Deployment of a pod by traversing owner references
-func (pm *PodMutator) findParentDeployment(ctx context.Context, pod *corev1.Pod) (*appsv1.Deployment, error) {
-	for _, ownerRef := range pod.OwnerReferences {
-		if ownerRef.Kind == "ReplicaSet" {
-			// Get the ReplicaSet
-			rs := &appsv1.ReplicaSet{}
-			err := pm.Client.Get(ctx, client.ObjectKey{
-				Namespace: pod.Namespace,
-				Name:      ownerRef.Name,
-			}, rs)
-			if err != nil {
-				continue
-			}
-
-			// Check if ReplicaSet has a Deployment owner
-			for _, rsOwnerRef := range rs.OwnerReferences {
-				if rsOwnerRef.Kind == "Deployment" {
-					deployment := &appsv1.Deployment{}
-					err := pm.Client.Get(ctx, client.ObjectKey{
-						Namespace: pod.Namespace,
-						Name:      rsOwnerRef.Name,
-					}, deployment)
-					if err == nil {
-						return deployment, nil
-					}
-				}
-			}
-		}
-	}
-	return nil, nil
-}
-
 // SetupWebhookWithManager sets up the webhook with the manager
 func (pm *PodMutator) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	pm.decoder = admission.NewDecoder(mgr.GetScheme())
@@ -387,12 +847,52 @@ func (pm *PodMutator) SetupWebhookWithManager(mgr ctrl.Manager) error {
 		pm.StateManager = NewStateManager(mgr.GetClient(), pm.Log.WithName("StateManager"))
 	}
 
+	// Initialize the state store circuit breaker
+	if pm.StateBreaker == nil {
+		pm.StateBreaker = NewStateCircuitBreaker(0, 0, 0, pm.Log.WithName("StateBreaker"))
+	}
+
+	// Initialize PoolHealthChecker
+	if pm.PoolHealth == nil {
+		pm.PoolHealth = NewPoolHealthChecker(mgr.GetClient(), pm.Log.WithName("PoolHealth"))
+	}
+
+	// Initialize MaintenanceTracker
+	if pm.Maintenance == nil {
+		pm.Maintenance = NewMaintenanceTracker(mgr.GetClient(), pm.Log.WithName("Maintenance"))
+		pm.Maintenance.APIs = pm.APIs
+	}
+	if pm.APIs.AnnotationOnly() {
+		pm.Log.Info("PodPlacementPolicy CRD is not installed, placing pods by schedule-strategy annotations only")
+	}
+
+	// Initialize the unmanaged deployment cache and keep it in sync with strategy annotation changes
+	if pm.Unmanaged == nil {
+		pm.Unmanaged = NewUnmanagedCache(DefaultUnmanagedCacheTTL)
+	}
+	if err := pm.Unmanaged.RegisterInvalidation(context.Background(), mgr.GetCache()); err != nil {
+		return err
+	}
+	if pm.StatefulSets {
+		if err := pm.Unmanaged.RegisterStatefulSetInvalidation(context.Background(), mgr.GetCache()); err != nil {
+			return err
+		}
+	}
+
 	// Register the mutating admission webhook
 	mgr.GetWebhookServer().Register("/mutate-v1-pod", &admission.Webhook{
 		Handler: pm,
 	})
 
 	pm.Log.Info("Webhook registered successfully", "path", "/mutate-v1-pod")
+
+	// Other replicas forward admissions of the deployments this replica owns
+	if pm.Forwarder != nil {
+		mgr.GetWebhookServer().Register(OwnedPath, &admission.Webhook{
+			Handler: ownedHandler{mutator: pm},
+		})
+		pm.Log.Info("Webhook registered successfully", "path", OwnedPath)
+	}
 	return nil
 }
 
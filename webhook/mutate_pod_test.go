@@ -0,0 +1,2391 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	smartschedulerv1 "github.com/kube-smartscheduler/smart-scheduler/api/v1"
+)
+
+const benchmarkStrategy = "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot"
+
+// newBenchmarkMutator builds a PodMutator backed by a fake client holding a deployment with podCount pods
+func newBenchmarkMutator(b testing.TB, podCount int) (*PodMutator, *corev1.Pod) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+	if err := smartschedulerv1.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         types.UID("deployment-uid"),
+			Annotations: map[string]string{ScheduleStrategyAnnotation: benchmarkStrategy},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc",
+			Namespace: "default",
+			UID:       types.UID("rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller,
+			}},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: int32(podCount)},
+	}
+	ownerRefs := []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller,
+	}}
+
+	objects := []client.Object{deployment, rs}
+	for i := 0; i < podCount; i++ {
+		nodeType := "spot"
+		if i%4 == 0 {
+			nodeType = "ondemand"
+		}
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            fmt.Sprintf("web-abc-%d", i),
+				Namespace:       "default",
+				UID:             types.UID(fmt.Sprintf("pod-uid-%d", i)),
+				Labels:          map[string]string{"app": "web"},
+				OwnerReferences: ownerRefs,
+			},
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{"node-type": nodeType},
+				Containers:   []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithIndex(&corev1.Pod{}, PodOwnerUIDField, podOwnerUIDs).
+		WithIndex(&appsv1.ReplicaSet{}, ReplicaSetOwnerDeploymentField, replicaSetOwnerDeployment).
+		Build()
+
+	log := logr.Discard()
+	mutator := &PodMutator{
+		Client:       c,
+		Log:          log,
+		decoder:      admission.NewDecoder(scheme),
+		StateManager: NewStateManager(c, log),
+		PoolHealth:   NewPoolHealthChecker(c, log),
+		Maintenance:  NewMaintenanceTracker(c, log),
+	}
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    "web-abc-",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "web"},
+			OwnerReferences: ownerRefs,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+		},
+	}
+
+	return mutator, pod
+}
+
+// newAdmissionRequest wraps the pod in a CREATE admission request
+func newAdmissionRequest(b testing.TB, pod *corev1.Pod) admission.Request {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       types.UID("request-uid"),
+		Namespace: pod.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func benchmarkHandle(b *testing.B, podCount int) {
+	mutator, pod := newBenchmarkMutator(b, podCount)
+	req := newAdmissionRequest(b, pod)
+	ctx := context.Background()
+
+	// Prime the placement state so iterations measure the steady-state admission path
+	if resp := mutator.Handle(ctx, req); !resp.Allowed || len(resp.Patches) == 0 {
+		b.Fatalf("Expected a patched admission, got %+v", resp.Result)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mutator.Handle(ctx, req)
+	}
+}
+
+func BenchmarkHandle100Pods(b *testing.B) { benchmarkHandle(b, 100) }
+func BenchmarkHandle1kPods(b *testing.B)  { benchmarkHandle(b, 1000) }
+func BenchmarkHandle5kPods(b *testing.B)  { benchmarkHandle(b, 5000) }
+
+func benchmarkStateRefresh(b *testing.B, podCount int) {
+	mutator, _ := newBenchmarkMutator(b, podCount)
+	ctx := context.Background()
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		b.Fatal(err)
+	}
+	strategy, err := ParsePlacementStrategy(benchmarkStrategy)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := mutator.StateManager.getCurrentPodCounts(ctx, deployment, strategy); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStateRefresh100Pods(b *testing.B) { benchmarkStateRefresh(b, 100) }
+func BenchmarkStateRefresh1kPods(b *testing.B)  { benchmarkStateRefresh(b, 1000) }
+func BenchmarkStateRefresh5kPods(b *testing.B)  { benchmarkStateRefresh(b, 5000) }
+
+func TestStateManagerBufferedFlush(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := state.TotalPods
+
+	for i := 0; i < 3; i++ {
+		if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Buffered increments are visible to readers before they are written
+	state, _ = sm.GetPlacementState(ctx, deployment, strategy)
+	if state.TotalPods != initial+3 {
+		t.Errorf("Expected %d pods including buffered increments, got %d", initial+3, state.TotalPods)
+	}
+	stored, _ := sm.loadPlacementState(ctx, deployment, strategy)
+	if stored.TotalPods != initial {
+		t.Errorf("Expected stored state to be unchanged before the flush, got %d pods", stored.TotalPods)
+	}
+
+	sm.Flush(ctx)
+
+	stored, _ = sm.loadPlacementState(ctx, deployment, strategy)
+	if stored.TotalPods != initial+3 {
+		t.Errorf("Expected flushed state to hold %d pods, got %d", initial+3, stored.TotalPods)
+	}
+	state, _ = sm.GetPlacementState(ctx, deployment, strategy)
+	if state.TotalPods != initial+3 {
+		t.Errorf("Expected flushed increments not to be counted twice, got %d pods", state.TotalPods)
+	}
+}
+
+func TestStateManagerFlushDuringAdmission(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := state.TotalPods
+	for i := 0; i < 3; i++ {
+		if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An admission arrives as soon as the flush has written the ConfigMap
+	admitted := make(chan int, 1)
+	sm.Client = interceptor.NewClient(mutator.Client.(client.WithWatch), interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if err := c.Update(ctx, obj, opts...); err != nil {
+				return err
+			}
+			go func() {
+				unlock, err := sm.LockDeployment(ctx, deployment)
+				if err != nil {
+					admitted <- -1
+					return
+				}
+				defer unlock()
+				state, err := sm.GetPlacementState(ctx, deployment, strategy)
+				if err != nil {
+					admitted <- -1
+					return
+				}
+				admitted <- state.TotalPods
+				if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=ondemand"); err != nil {
+					t.Error(err)
+				}
+			}()
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+	})
+
+	sm.Flush(ctx)
+	if got := <-admitted; got != initial+3 {
+		t.Errorf("Expected the admission to see %d pods, got %d", initial+3, got)
+	}
+
+	// The increment of the admission is neither lost nor counted twice
+	sm.Client = mutator.Client
+	unlock, _ := sm.LockDeployment(ctx, deployment)
+	state, _ = sm.GetPlacementState(ctx, deployment, strategy)
+	unlock()
+	if state.TotalPods != initial+4 {
+		t.Errorf("Expected %d pods after the admission, got %d", initial+4, state.TotalPods)
+	}
+	sm.Flush(ctx)
+	stored, _ := sm.ReadPlacementState(ctx, "default", "web")
+	if stored.TotalPods != initial+4 {
+		t.Errorf("Expected the next flush to store %d pods, got %d", initial+4, stored.TotalPods)
+	}
+}
+
+func TestStateManagerRecountWhileBuffered(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := state.TotalPods
+
+	// Two pods are admitted and created before their increments are flushed
+	template := &corev1.Pod{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc-1"}, template); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+			t.Fatal(err)
+		}
+		pod := template.DeepCopy()
+		pod.ResourceVersion = ""
+		pod.Name = fmt.Sprintf("web-abc-new-%d", i)
+		pod.UID = types.UID(fmt.Sprintf("pod-uid-new-%d", i))
+		if err := mutator.Client.Create(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := &appsv1.ReplicaSet{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc"}, rs); err != nil {
+		t.Fatal(err)
+	}
+	rs.Status.Replicas = int32(initial + 2)
+	if err := mutator.Client.Status().Update(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored state ages past the refresh interval
+	configMap := &corev1.ConfigMap{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "smart-scheduler-web"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	var stored PlacementState
+	if err := json.Unmarshal([]byte(configMap.Data["placement-state"]), &stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.LastUpdated = time.Now().Add(-time.Minute)
+	data, _ := json.Marshal(stored)
+	configMap.Data["placement-state"] = string(data)
+	if err := mutator.Client.Update(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recount finds the new pods, so their buffered increments are not added on top
+	state, err = sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.TotalPods != initial+2 || state.PodCounts["node-type=spot"] != 5 {
+		t.Errorf("Expected %d pods with 5 on spot after the recount, got %d: %v", initial+2, state.TotalPods, state.PodCounts)
+	}
+
+	// Increments buffered after the recount are added to it
+	if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=ondemand"); err != nil {
+		t.Fatal(err)
+	}
+	sm.Flush(ctx)
+	if stored, _ := sm.ReadPlacementState(ctx, "default", "web"); stored.TotalPods != initial+3 {
+		t.Errorf("Expected the flush to store %d pods, got %d", initial+3, stored.TotalPods)
+	}
+}
+
+func TestStateManagerRetriesFailedRecount(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+
+	// A previous process crashed before flushing the increment of one of the four pods
+	if err := sm.UpdatePlacementState(ctx, &PlacementState{
+		DeploymentName:      "web",
+		DeploymentNamespace: "default",
+		Strategy:            strategy,
+		PodCounts:           map[RuleKey]int{"node-type=ondemand": 1, "node-type=spot": 2},
+		TotalPods:           3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first recount cannot list the pods, so the stored counts are used
+	failList := true
+	sm.Client = interceptor.NewClient(mutator.Client.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, pods := list.(*corev1.PodList); pods && failList {
+				return fmt.Errorf("connection refused")
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.TotalPods != 3 {
+		t.Errorf("Expected the stored 3 pods while pods cannot be listed, got %d", state.TotalPods)
+	}
+
+	// The next read retries the recount and finds the pod whose increment was lost
+	failList = false
+	state, err = sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.TotalPods != 4 || state.PodCounts["node-type=spot"] != 3 {
+		t.Errorf("Expected the recount to find 4 pods with 3 on spot, got %d: %v", state.TotalPods, state.PodCounts)
+	}
+
+	// Once recounted, the state is read as stored
+	if sm.needsRecount(deployment) {
+		t.Error("Expected the deployment marked recounted after a successful recount")
+	}
+}
+
+// ageStoredState moves the stored placement state of deployment "web" past the refresh interval
+func ageStoredState(t *testing.T, c client.Client) {
+	t.Helper()
+	ctx := context.Background()
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "smart-scheduler-web"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	var stored PlacementState
+	if err := json.Unmarshal([]byte(configMap.Data["placement-state"]), &stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.LastUpdated = time.Now().Add(-time.Minute)
+	data, _ := json.Marshal(stored)
+	configMap.Data["placement-state"] = string(data)
+	if err := c.Update(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateManagerRefreshesFromPods(t *testing.T) {
+	tests := []struct {
+		name string
+		// newPods are created, and counted in the ReplicaSet's status, after the state is stored
+		newPods int
+		// pageSize lists pods page by page from the API reader
+		pageSize int64
+		// wantLists is the number of pod lists the refresh makes
+		wantLists int
+		wantTotal int
+	}{
+		{name: "Totals match the ReplicaSets", wantLists: 0, wantTotal: 4},
+		{name: "Totals differ from the ReplicaSets", newPods: 1, wantLists: 1, wantTotal: 5},
+		{name: "Paged listing follows the continue token", newPods: 1, pageSize: 2, wantLists: 3, wantTotal: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutator, _ := newBenchmarkMutator(t, 4)
+			ctx := context.Background()
+			sm := mutator.StateManager
+
+			deployment := &appsv1.Deployment{}
+			if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+			if _, err := sm.GetPlacementState(ctx, deployment, strategy); err != nil {
+				t.Fatal(err)
+			}
+
+			template := &corev1.Pod{}
+			if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc-1"}, template); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.newPods; i++ {
+				pod := template.DeepCopy()
+				pod.ResourceVersion = ""
+				pod.Name = fmt.Sprintf("web-abc-new-%d", i)
+				pod.UID = types.UID(fmt.Sprintf("pod-uid-new-%d", i))
+				if err := mutator.Client.Create(ctx, pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rs := &appsv1.ReplicaSet{}
+			if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc"}, rs); err != nil {
+				t.Fatal(err)
+			}
+			rs.Status.Replicas = int32(4 + tt.newPods)
+			if err := mutator.Client.Status().Update(ctx, rs); err != nil {
+				t.Fatal(err)
+			}
+			ageStoredState(t, mutator.Client)
+
+			// Pod lists are counted; the API reader serves pages of pageSize pods with an offset
+			// as the continue token, since the fake client does not paginate
+			lists := 0
+			var tokens []string
+			countLists := interceptor.Funcs{
+				List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+					if _, pods := list.(*corev1.PodList); pods {
+						lists++
+					}
+					return c.List(ctx, list, opts...)
+				},
+			}
+			sm.Client = interceptor.NewClient(mutator.Client.(client.WithWatch), countLists)
+			if tt.pageSize > 0 {
+				sm.PodListPageSize = tt.pageSize
+				sm.APIReader = interceptor.NewClient(mutator.Client.(client.WithWatch), interceptor.Funcs{
+					List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+						listOpts := &client.ListOptions{}
+						listOpts.ApplyOptions(opts)
+						tokens = append(tokens, listOpts.Continue)
+						lists++
+						offset, _ := strconv.Atoi(listOpts.Continue)
+						podList := list.(*corev1.PodList)
+						if err := c.List(ctx, podList, client.InNamespace(listOpts.Namespace), client.MatchingLabelsSelector{Selector: listOpts.LabelSelector}); err != nil {
+							return err
+						}
+						all := podList.Items
+						sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+						end := min(offset+int(listOpts.Limit), len(all))
+						podList.Items = all[offset:end]
+						if end < len(all) {
+							podList.Continue = strconv.Itoa(end)
+						}
+						return nil
+					},
+				})
+			}
+
+			state, err := sm.GetPlacementState(ctx, deployment, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lists != tt.wantLists {
+				t.Errorf("Expected %d pod lists, got %d", tt.wantLists, lists)
+			}
+			if state.TotalPods != tt.wantTotal {
+				t.Errorf("Expected %d pods, got %d: %v", tt.wantTotal, state.TotalPods, state.PodCounts)
+			}
+			if tt.pageSize > 0 && !reflect.DeepEqual(tokens, []string{"", "2", "4"}) {
+				t.Errorf("Expected the pages requested with continue tokens [\"\" 2 4], got %q", tokens)
+			}
+		})
+	}
+}
+
+func TestStateManagerTracksRulePods(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+
+	// One pod runs on ondemand and three on spot
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.RulePods["node-type=ondemand"]; len(got) != 1 || got[0] != "pod-uid-0" {
+		t.Errorf("Expected pod-uid-0 on ondemand, got %v", got)
+	}
+	if got := state.PodRules()["pod-uid-2"]; got != "node-type=spot" {
+		t.Errorf("Expected pod-uid-2 attributed to spot, got %q", got)
+	}
+
+	stored, err := sm.ReadPlacementState(ctx, "default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || len(stored.RulePods["node-type=spot"]) != 3 {
+		t.Fatalf("Expected the stored state to list three spot pods, got %+v", stored)
+	}
+
+	// An admitted pod is counted but not listed until the next refresh from pods
+	if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = sm.ReadPlacementState(ctx, "default", "web")
+	stored.Strategy = strategy
+	if sm.countsUnchanged(ctx, deployment, stored) {
+		t.Error("Expected a state with unlisted pods to need a refresh")
+	}
+
+	pods, err := ListDeploymentPods(ctx, mutator.Client, deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AttributePodsByRule(pods, strategy, 2)["node-type=spot"]; len(got) != 2 {
+		t.Errorf("Expected the spot pods to be bounded to 2, got %v", got)
+	}
+
+	if state, err := sm.ReadPlacementState(ctx, "default", "missing"); err != nil || state != nil {
+		t.Errorf("Expected no state for a deployment without one, got %+v, %v", state, err)
+	}
+}
+
+func TestStateManagerShutdownFlush(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := state.TotalPods
+
+	if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopping the manager flushes buffered increments
+	startCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := sm.Start(startCtx); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := sm.loadPlacementState(ctx, deployment, strategy)
+	if stored.TotalPods != initial+1 {
+		t.Errorf("Expected the final flush to store %d pods, got %d", initial+1, stored.TotalPods)
+	}
+
+	// Admissions still in flight after the final flush write directly
+	if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = sm.loadPlacementState(ctx, deployment, strategy)
+	if stored.TotalPods != initial+2 {
+		t.Errorf("Expected an increment after shutdown to be written directly, got %d pods", stored.TotalPods)
+	}
+}
+
+func TestStateManagerFlushWaitsForDrain(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+	sm.Drainer = NewAdmissionDrainer(time.Second, logr.Discard())
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	state, err := sm.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := state.TotalPods
+	done := sm.Drainer.track()
+
+	// Both runnables stop together, as the manager stops them
+	stopCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	flushed := make(chan struct{})
+	go func() {
+		_ = sm.Start(stopCtx)
+		close(flushed)
+	}()
+	go func() {
+		_ = sm.Drainer.Start(stopCtx)
+	}()
+
+	select {
+	case <-flushed:
+		t.Fatal("Expected the final flush to wait for the in-flight admission")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The admission finishing during the drain is buffered and written by the final flush
+	if err := sm.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+	done()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the final flush once the admission completed")
+	}
+	stored, _ := sm.loadPlacementState(ctx, deployment, strategy)
+	if stored.TotalPods != initial+1 {
+		t.Errorf("Expected the final flush to store %d pods, got %d", initial+1, stored.TotalPods)
+	}
+}
+
+func TestCounterLeases(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	c := mutator.Client
+	log := logr.Discard()
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	replica := func(identity string) *StateManager {
+		sm := NewStateManager(c, log)
+		sm.Counters = NewCounterLeases(c, c, identity, log)
+		sm.Counters.Wait = 20 * time.Millisecond
+		sm.Counters.now = clock
+		return sm
+	}
+	a, b := replica("webhook-a"), replica("webhook-b")
+	leaseHolder := func() string {
+		lease := &coordinationv1.Lease{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "smart-scheduler-web"}, lease); err != nil {
+			t.Fatal(err)
+		}
+		return holder(lease)
+	}
+
+	// The first replica creates the Lease and seeds its counters from the pods
+	if err := a.ClaimCounters(ctx, deployment, strategy); err != nil {
+		t.Fatal(err)
+	}
+	if got := leaseHolder(); got != "webhook-a" {
+		t.Fatalf("Expected webhook-a to hold the Lease, got %q", got)
+	}
+	if err := a.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Held counters are read from memory, not from the ConfigMap
+	if err := c.Delete(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "smart-scheduler-web"}}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := a.GetPlacementState(ctx, deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.TotalPods != 5 || state.PodCounts["node-type=spot"] != 4 {
+		t.Errorf("Expected 5 pods with 4 on spot from memory, got %v", state.PodCounts)
+	}
+
+	// Another replica does not wait for the held Lease and reports a state conflict
+	err = b.ClaimCounters(ctx, deployment, strategy)
+	if !errors.Is(err, ErrCountersHeld) || !errors.Is(err, ErrStateConflict) {
+		t.Fatalf("Expected a held counters conflict, got %v", err)
+	}
+
+	// Once the holder stops renewing, the Lease is taken over and the old holder drops its counters
+	now = now.Add(DefaultCounterLeaseDuration + time.Second)
+	if err := b.ClaimCounters(ctx, deployment, strategy); err != nil {
+		t.Fatalf("Expected the expired Lease to be taken over, got %v", err)
+	}
+	if got := leaseHolder(); got != "webhook-b" {
+		t.Fatalf("Expected webhook-b to hold the Lease, got %q", got)
+	}
+	a.maintainCounters(ctx)
+	if a.Counters.Holds(deployment) {
+		t.Error("Expected webhook-a to drop the counters of the Lease it lost")
+	}
+
+	// An idle holder releases the Lease, which the other replica takes at once
+	now = now.Add(DefaultCounterLeaseDuration)
+	b.maintainCounters(ctx)
+	if got := leaseHolder(); got != "" {
+		t.Fatalf("Expected the idle Lease to be released, held by %q", got)
+	}
+	if err := a.ClaimCounters(ctx, deployment, strategy); err != nil {
+		t.Fatalf("Expected the released Lease to be acquired, got %v", err)
+	}
+
+	// Counters of a deployment whose admissions paused are recounted from its pods
+	if err := a.IncrementPodCount(ctx, deployment, strategy, "node-type=spot"); err != nil {
+		t.Fatal(err)
+	}
+	a.Counters.ResyncInterval = counterQuietPeriod
+	now = now.Add(counterQuietPeriod + time.Second)
+	a.maintainCounters(ctx)
+	state, _ = a.GetPlacementState(ctx, deployment, strategy)
+	if state.TotalPods != 4 || state.PodCounts["node-type=spot"] != 3 {
+		t.Errorf("Expected the counters to be resynced to the 4 pods, got %v", state.PodCounts)
+	}
+	if got := leaseHolder(); got != "webhook-a" {
+		t.Errorf("Expected the resync to keep the Lease, held by %q", got)
+	}
+}
+
+func TestCounterLeasesConcurrentReplicas(t *testing.T) {
+	const admissions = 12
+	ctx := context.Background()
+
+	// Two replicas share the cluster; each admits half of a burst of pods at once
+	a, pod := newBenchmarkMutator(t, 0)
+	c := a.Client
+	log := logr.Discard()
+	b := &PodMutator{
+		Client:       c,
+		Log:          log,
+		decoder:      a.decoder,
+		StateManager: NewStateManager(c, log),
+		PoolHealth:   NewPoolHealthChecker(c, log),
+		Maintenance:  NewMaintenanceTracker(c, log),
+	}
+	for identity, mutator := range map[string]*PodMutator{"webhook-a": a, "webhook-b": b} {
+		mutator.StateManager.FlushInterval = time.Hour
+		mutator.StateManager.Counters = NewCounterLeases(c, c, identity, log)
+	}
+
+	placed := make(chan string, admissions)
+	for i := 0; i < admissions; i++ {
+		mutator := a
+		if i%2 == 1 {
+			mutator = b
+		}
+		go func(mutator *PodMutator) {
+			resp := mutator.Handle(ctx, newAdmissionRequest(t, pod.DeepCopy()))
+			rule := ""
+			for _, patch := range resp.Patches {
+				if annotations, ok := patch.Value.(map[string]interface{}); ok && patch.Path == "/metadata/annotations" {
+					rule, _ = annotations["smart-scheduler.io/placement-rule"].(string)
+				}
+			}
+			placed <- rule
+		}(mutator)
+	}
+	want := map[RuleKey]int{}
+	for i := 0; i < admissions; i++ {
+		want[RuleKey(<-placed)]++
+	}
+	if want[""] > 0 {
+		t.Fatalf("Expected every pod to be placed, got %v", want)
+	}
+
+	holder, other := a.StateManager, b.StateManager
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if !holder.Counters.Holds(deployment) {
+		holder, other = other, holder
+	}
+	if !holder.Counters.Holds(deployment) || other.Counters.Holds(deployment) {
+		t.Fatal("Expected exactly one replica to hold the counters")
+	}
+
+	// Both replicas write their pods, then the holder adds those of the other replica to its counters
+	other.Flush(ctx)
+	holder.Flush(ctx)
+	holder.maintainCounters(ctx)
+
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	unlock, _ := holder.LockDeployment(ctx, deployment)
+	counters, err := holder.GetPlacementState(ctx, deployment, strategy)
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters.TotalPods != admissions || !maps.Equal(counters.PodCounts, want) {
+		t.Errorf("Expected the held counters to count the placed pods %v, got %v", want, counters.PodCounts)
+	}
+	stored, err := holder.ReadPlacementState(ctx, "default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TotalPods != admissions || !maps.Equal(stored.PodCounts, want) || len(stored.RemoteIncrements) != 0 {
+		t.Errorf("Expected the stored state to count the placed pods %v with none left to merge, got %v (remote %v)",
+			want, stored.PodCounts, stored.RemoteIncrements)
+	}
+}
+
+func TestAdmissionDrainer(t *testing.T) {
+	drainer := NewAdmissionDrainer(time.Second, logr.Discard())
+	done := drainer.track()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = drainer.Start(ctx)
+		close(stopped)
+	}()
+
+	if err := drainer.Checker(nil); err != nil {
+		t.Fatalf("Expected the drainer to be ready before shutdown, got %v", err)
+	}
+	cancel()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected shutdown to wait for the in-flight admission")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := drainer.Checker(nil); err == nil {
+		t.Error("Expected the drainer not to be ready while draining")
+	}
+
+	done()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected shutdown to finish once the admission completed")
+	}
+}
+
+func TestMaintenanceLookupFailureWarns(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 4)
+	mutator.Maintenance.Client = interceptor.NewClient(mutator.Client.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*smartschedulerv1.MaintenanceWindowList); ok {
+				return errors.New("cache not synced")
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+
+	resp := mutator.Handle(context.Background(), newAdmissionRequest(t, pod))
+	if !resp.Allowed {
+		t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+	}
+
+	// The pod keeps its placement and says it was not kept off maintenance
+	var placed, unchecked bool
+	for _, patch := range resp.Patches {
+		switch {
+		case strings.HasPrefix(patch.Path, "/spec/nodeSelector"):
+			placed = true
+		case strings.HasPrefix(patch.Path, "/metadata/annotations"):
+			unchecked = unchecked || strings.Contains(fmt.Sprint(patch.Value), "cache not synced")
+		}
+	}
+	if !placed {
+		t.Error("Expected the pod to be placed on a rule")
+	}
+	if !unchecked {
+		t.Errorf("Expected the %s annotation, got patches %+v", MaintenanceUncheckedAnnotation, resp.Patches)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "maintenance") {
+		t.Errorf("Expected a warning about the skipped maintenance check, got %v", resp.Warnings)
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	queue := NewAdmissionQueue(1, time.Second, logr.Discard())
+	ctx := context.Background()
+
+	release, admitted := queue.Acquire(ctx, AdmissionPriority{})
+	if !admitted {
+		t.Fatal("Expected the first admission to get the free slot")
+	}
+	if _, admitted := queue.Acquire(ctx, AdmissionPriority{Pod: 10}); admitted {
+		t.Error("Expected a low-priority admission to degrade while the slot is taken")
+	}
+
+	// waiting returns the number of queued admissions
+	waiting := func() int {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return len(queue.waiters)
+	}
+
+	order := make(chan string, 2)
+	enqueue := func(name string, priority AdmissionPriority) {
+		go func() {
+			done, admitted := queue.Acquire(ctx, priority)
+			if !admitted {
+				order <- name + " degraded"
+				return
+			}
+			order <- name
+			done()
+		}()
+	}
+	enqueue("policy", AdmissionPriority{Policy: 200})
+	for waiting() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	enqueue("critical", AdmissionPriority{Pod: 100000})
+	for waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	release()
+	for _, want := range []string{"critical", "policy"} {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Errorf("Expected %s to be served next, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to be served", want)
+		}
+	}
+
+	queue.MaxWait = 10 * time.Millisecond
+	release, _ = queue.Acquire(ctx, AdmissionPriority{})
+	if _, admitted := queue.Acquire(ctx, AdmissionPriority{Pod: 2000}); admitted {
+		t.Error("Expected a high-priority admission to degrade after waiting MaxWait")
+	}
+	release()
+
+	var unset *AdmissionQueue
+	if _, admitted := unset.Acquire(ctx, AdmissionPriority{}); !admitted {
+		t.Error("Expected a nil queue to admit everything")
+	}
+}
+
+func TestDeploymentLocks(t *testing.T) {
+	var locks DeploymentLocks
+	web := types.NamespacedName{Namespace: "default", Name: "web"}
+	ctx := context.Background()
+
+	unlock, err := locks.Lock(ctx, web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := locks.Lock(waitCtx, web); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a held lock to wait until the context ends, got %v", err)
+	}
+	unlockAPI, err := locks.Lock(ctx, types.NamespacedName{Namespace: "default", Name: "api"})
+	if err != nil {
+		t.Fatalf("Expected other deployments not to wait, got %v", err)
+	}
+	unlockAPI()
+
+	acquired := make(chan func())
+	go func() {
+		next, _ := locks.Lock(ctx, web)
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected the lock to be held until released")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	unlock()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to get the released lock")
+	}
+
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected released locks to be dropped, got %d", len(locks.locks))
+	}
+}
+
+func TestHandleSerializesAdmissionsPerDeployment(t *testing.T) {
+	const admissions = 8
+	ctx := context.Background()
+
+	// place admits the pod and returns the rule it was placed on
+	place := func(mutator *PodMutator, pod *corev1.Pod) string {
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		for _, patch := range resp.Patches {
+			if annotations, ok := patch.Value.(map[string]interface{}); ok && patch.Path == "/metadata/annotations" {
+				rule, _ := annotations["smart-scheduler.io/placement-rule"].(string)
+				return rule
+			}
+		}
+		return ""
+	}
+
+	mutator, pod := newBenchmarkMutator(t, 0)
+	want := map[string]int{}
+	for i := 0; i < admissions; i++ {
+		want[place(mutator, pod)]++
+	}
+
+	// Admitted at once, the pods see each other's placements as if admitted one by one, even with
+	// the state store slow enough for their reads to overlap
+	mutator, pod = newBenchmarkMutator(t, 0)
+	mutator.StateManager.Client = interceptor.NewClient(mutator.Client.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if _, ok := obj.(*corev1.ConfigMap); ok {
+				time.Sleep(5 * time.Millisecond)
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+	rules := make(chan string, admissions)
+	for i := 0; i < admissions; i++ {
+		go func() { rules <- place(mutator, pod.DeepCopy()) }()
+	}
+	got := map[string]int{}
+	for i := 0; i < admissions; i++ {
+		got[<-rules]++
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected concurrent admissions placed %v, got %v", want, got)
+	}
+
+	state, err := mutator.StateManager.ReadPlacementState(ctx, "default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.TotalPods != admissions {
+		t.Errorf("Expected the state to count %d pods, got %d", admissions, state.TotalPods)
+	}
+}
+
+func TestStateWarmer(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 4)
+	sm := mutator.StateManager
+	sm.FlushInterval = time.Hour
+	warmer := NewStateWarmer(mutator.Client, sm, time.Second, logr.Discard())
+
+	if err := warmer.Checker(nil); err == nil {
+		t.Fatal("Expected the warmer not to be ready before the warm-up")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = warmer.Start(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for warmer.Checker(nil) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the warm-up to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	// The state was stored, so the first admission reads it without counting pods
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	strategy, _ := ParsePlacementStrategy(benchmarkStrategy)
+	stored, err := sm.loadPlacementState(context.Background(), deployment, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TotalPods != 4 || time.Since(stored.LastUpdated) > time.Minute {
+		t.Errorf("Expected fresh stored state with 4 pods, got %d pods updated at %v", stored.TotalPods, stored.LastUpdated)
+	}
+}
+
+func TestOwnerResolver(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 0)
+	ctx := context.Background()
+	resolver := NewOwnerResolver(mutator.Client)
+
+	name, ok, err := resolver.DeploymentFor(ctx, pod)
+	if err != nil || !ok || name != "web" {
+		t.Fatalf("Expected pod to resolve to deployment web, got %q ok=%v err=%v", name, ok, err)
+	}
+	if resolver.Len() != 1 {
+		t.Errorf("Expected the ReplicaSet edge to be remembered, got %d edges", resolver.Len())
+	}
+
+	// A pod whose ReplicaSet is already gone resolves from its pod-template-hash
+	controller := true
+	orphan := pod.DeepCopy()
+	orphan.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "5d9c8f7b6"
+	orphan.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-5d9c8f7b6", UID: types.UID("gone-uid"), Controller: &controller,
+	}}
+	name, ok, err = resolver.DeploymentFor(ctx, orphan)
+	if err != nil || !ok || name != "api" {
+		t.Errorf("Expected pod of a deleted ReplicaSet to resolve to api, got %q ok=%v err=%v", name, ok, err)
+	}
+
+	// Pods of orphaned ReplicaSets have no parent deployment
+	rs := &appsv1.ReplicaSet{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc"}, rs); err != nil {
+		t.Fatal(err)
+	}
+	rs.OwnerReferences = nil
+	if err := mutator.Client.Update(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+	resolver.observe(rs)
+	if name, ok, _ := resolver.DeploymentFor(ctx, pod); ok {
+		t.Errorf("Expected pod of an orphaned ReplicaSet not to resolve, got %q", name)
+	}
+}
+
+func TestHandlePlacesNewReplicaSetOnItsOwn(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 0)
+	ctx := context.Background()
+
+	// The old ReplicaSet runs four pods, all on the base rule
+	oldRS := &appsv1.ReplicaSet{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-abc"}, oldRS); err != nil {
+		t.Fatal(err)
+	}
+	oldRS.Status.Replicas = 4
+	if err := mutator.Client.Status().Update(ctx, oldRS); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		oldPod := pod.DeepCopy()
+		oldPod.Name = fmt.Sprintf("web-abc-%d", i)
+		oldPod.Spec.NodeSelector = map[string]string{"node-type": "ondemand"}
+		oldPod.Status.Phase = corev1.PodRunning
+		if err := mutator.Client.Create(ctx, oldPod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controller := true
+	newRS := oldRS.DeepCopy()
+	newRS.ObjectMeta = metav1.ObjectMeta{
+		Name: "web-def", Namespace: "default", UID: types.UID("rs-new-uid"), OwnerReferences: oldRS.OwnerReferences,
+	}
+	newRS.Status = appsv1.ReplicaSetStatus{}
+	if err := mutator.Client.Create(ctx, newRS); err != nil {
+		t.Fatal(err)
+	}
+
+	// Counting the old pods would send the first new pod to spot; on its own it gets the base rule
+	newPod := pod.DeepCopy()
+	newPod.GenerateName = "web-def-"
+	newPod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: newRS.Name, UID: newRS.UID, Controller: &controller,
+	}}
+	resp := mutator.Handle(ctx, newAdmissionRequest(t, newPod))
+	if !resp.Allowed || len(resp.Patches) == 0 {
+		t.Fatalf("Expected a patched admission, got %+v", resp.Result)
+	}
+	for _, patch := range resp.Patches {
+		if patch.Path == "/spec/nodeSelector" {
+			if selector, _ := patch.Value.(map[string]interface{}); selector["node-type"] != "ondemand" {
+				t.Errorf("Expected the new ReplicaSet's first pod on ondemand, got %v", patch.Value)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected a nodeSelector patch, got %+v", resp.Patches)
+}
+
+func TestHandleSkipsUnsupportedPods(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 0)
+	controller := true
+
+	daemonSetPod := pod.DeepCopy()
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "DaemonSet", Name: "node-exporter", UID: types.UID("ds-uid"), Controller: &controller,
+	}}
+	mirrorPod := pod.DeepCopy()
+	mirrorPod.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "3f2a"}
+	mirrorPod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "v1", Kind: "Node", Name: "node-1", UID: types.UID("node-uid"), Controller: &controller,
+	}}
+
+	tests := []struct {
+		name   string
+		pod    *corev1.Pod
+		reason string
+	}{
+		{"daemonset pod", daemonSetPod, SkipReasonDaemonSet},
+		{"mirror pod", mirrorPod, SkipReasonStaticPod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := mutator.Handle(context.Background(), newAdmissionRequest(t, tt.pod))
+			if !resp.Allowed || len(resp.Patches) != 0 {
+				t.Fatalf("Expected the pod to be allowed unchanged, got allowed=%v patches=%+v", resp.Allowed, resp.Patches)
+			}
+			if got := resp.AuditAnnotations[SkipReasonAuditAnnotation]; got != tt.reason {
+				t.Errorf("Expected audit annotation %s=%s, got %v", SkipReasonAuditAnnotation, tt.reason, resp.AuditAnnotations)
+			}
+		})
+	}
+
+	// Pods of ReplicaSets are still placed
+	if reason, unsupported := UnsupportedPodReason(pod); unsupported {
+		t.Errorf("UnsupportedPodReason() of a ReplicaSet pod = %s, want supported", reason)
+	}
+}
+
+func TestHandleReviewsPlacementsWithDecisionHook(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		verdict  string
+		delay    time.Duration
+		expected string
+	}{
+		{name: "approved", status: http.StatusOK, verdict: `{}`, expected: "ondemand"},
+		{name: "overridden", status: http.StatusOK, verdict: `{"rule":"node-type=spot","reason":"budget"}`, expected: "spot"},
+		{name: "vetoed", status: http.StatusOK, verdict: `{"veto":true}`, expected: ""},
+		{name: "unknown rule keeps the placement", status: http.StatusOK, verdict: `{"rule":"node-type=gpu"}`, expected: "ondemand"},
+		{name: "error keeps the placement", status: http.StatusInternalServerError, verdict: `{"veto":true}`, expected: "ondemand"},
+		{name: "malformed verdict keeps the placement", status: http.StatusOK, verdict: `veto`, expected: "ondemand"},
+		{name: "timeout keeps the placement", status: http.StatusOK, verdict: `{"veto":true}`, delay: time.Second, expected: "ondemand"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reviews := make(chan PlacementReview, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var review PlacementReview
+				if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+					t.Errorf("Failed to decode placement review: %v", err)
+				}
+				reviews <- review
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.verdict)
+			}))
+			defer server.Close()
+
+			mutator, pod := newBenchmarkMutator(t, 0)
+			hook, err := NewDecisionHook(server.URL, "", 100*time.Millisecond, logr.Discard())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mutator.DecisionHook = hook
+
+			resp := mutator.Handle(context.Background(), newAdmissionRequest(t, pod))
+			if !resp.Allowed {
+				t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+			}
+			review := <-reviews
+			if review.Deployment != "web" || review.Candidate != "node-type=ondemand" || len(review.Rules) != 2 {
+				t.Errorf("Unexpected placement review %+v", review)
+			}
+
+			got := ""
+			for _, patch := range resp.Patches {
+				if patch.Path == "/spec/nodeSelector" {
+					selector, _ := patch.Value.(map[string]interface{})
+					got, _ = selector["node-type"].(string)
+				}
+			}
+			if got != tt.expected {
+				t.Errorf("Expected node-type %q, got %q (patches %+v)", tt.expected, got, resp.Patches)
+			}
+		})
+	}
+}
+
+func TestListDeploymentPodsPagedSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		expected int
+		wantErr  bool
+	}{
+		{name: "nil selector", selector: nil, expected: 4},
+		{name: "empty selector", selector: &metav1.LabelSelector{}, expected: 4},
+		{name: "match labels", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}, expected: 4},
+		{
+			name: "match expressions",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}},
+			}},
+			expected: 4,
+		},
+		{
+			name: "match expressions excluding the pods",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"web"}},
+			}},
+			expected: 0,
+		},
+		{
+			name: "invalid operator",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: "Matches", Values: []string{"web"}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutator, _ := newBenchmarkMutator(t, 4)
+			ctx := context.Background()
+			deployment := &appsv1.Deployment{}
+			if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			deployment.Spec.Selector = tt.selector
+
+			pods, err := ListDeploymentPodsPaged(ctx, mutator.Client, mutator.Client, deployment, 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListDeploymentPodsPaged() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(pods) != tt.expected {
+				t.Errorf("Expected %d pods, got %d", tt.expected, len(pods))
+			}
+		})
+	}
+}
+
+func TestNodeUtilizationPrefer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := func(name, nodeType string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-type": nodeType}},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("4"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	running := func(name, nodeName, cpu string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+				Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("1Gi")},
+				}}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	kata := &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kata"},
+		Handler:    "kata",
+		Overhead:   &nodev1.Overhead{PodFixed: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		node("spot-empty", "spot"), node("spot-half", "spot"), node("spot-full", "spot"), node("ondemand-busy", "ondemand"),
+		running("a", "spot-half", "2"), running("b", "spot-full", "3500m"), running("c", "ondemand-busy", "3"), kata,
+	).Build()
+	utilization := NewNodeUtilization(c, logr.Discard())
+	utilization.Sidecars = NewSidecarTracker()
+	spot := PlacementRule{NodeSelector: map[string]string{"node-type": "spot"}}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		}}},
+	}
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		}}}}}
+	}
+	var runtimeClass *string
+
+	preferred := func(packing string) []string {
+		pod := newPod()
+		pod.Spec.RuntimeClassName = runtimeClass
+		rule := spot
+		rule.Packing = packing
+		if err := utilization.Prefer(context.Background(), pod, deployment, rule); err != nil {
+			t.Fatal(err)
+		}
+		if pod.Spec.Affinity == nil {
+			return nil
+		}
+		var names []string
+		for _, term := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			names = append(names, term.Preference.MatchFields[0].Values[0])
+		}
+		return names
+	}
+
+	// spot-full has no room for another core and ondemand-busy is outside the rule's pool
+	if got := fmt.Sprint(preferred(PackingMostAllocated)); got != "[spot-half spot-empty]" {
+		t.Errorf("most allocated = %s, want [spot-half spot-empty]", got)
+	}
+	if got := fmt.Sprint(preferred(PackingLeastAllocated)); got != "[spot-empty spot-half]" {
+		t.Errorf("least allocated = %s, want [spot-empty spot-half]", got)
+	}
+	if got := preferred(""); got != nil {
+		t.Errorf("rule without packing mode got preferences %v", got)
+	}
+
+	// A pod with the overhead of its RuntimeClass no longer fits next to half a node's requests
+	runtimeClass = &kata.Name
+	if got := fmt.Sprint(preferred(PackingMostAllocated)); got != "[spot-empty]" {
+		t.Errorf("most allocated with RuntimeClass overhead = %s, want [spot-empty]", got)
+	}
+	runtimeClass = nil
+
+	// Nor does one whose sidecar, injected after placement, was seen on a reinvocation
+	injected := newPod()
+	injected.Spec.InitContainers = []corev1.Container{{
+		Name:          "proxy",
+		RestartPolicy: &[]corev1.ContainerRestartPolicy{corev1.ContainerRestartPolicyAlways}[0],
+		Resources:     corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}},
+	}}
+	if !utilization.Sidecars.Observe(injected, deployment) {
+		t.Fatal("Expected the injected sidecar to be learned")
+	}
+	if utilization.Sidecars.Observe(injected, deployment) {
+		t.Error("Expected the same sidecar to be learned once")
+	}
+	if got := fmt.Sprint(preferred(PackingMostAllocated)); got != "[spot-empty]" {
+		t.Errorf("most allocated with a learned sidecar = %s, want [spot-empty]", got)
+	}
+	if cpu, _ := podCPUMemory(&injected.Spec); cpu != 2500 {
+		t.Errorf("Expected a native sidecar to add to the pod's requests, got %dm", cpu)
+	}
+	if cpu, _, _ := utilization.podSize(context.Background(), injected, deployment); cpu != 2500 {
+		t.Errorf("Expected a pod with its sidecar not to count it twice, got %dm", cpu)
+	}
+}
+
+func TestHandleLearnsSidecarsOnReinvocation(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 0)
+	mutator.Packing = NewNodeUtilization(mutator.Client, logr.Discard())
+	mutator.Packing.Sidecars = NewSidecarTracker()
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: "nginx:1.25"}}
+	if err := mutator.Client.Update(context.Background(), deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	// The placed pod comes back with the proxy another webhook injected
+	pod.Annotations = map[string]string{"smart-scheduler.io/processed": "true"}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "istio-proxy", Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+	}})
+	if resp := mutator.Handle(context.Background(), newAdmissionRequest(t, pod)); !resp.Allowed || len(resp.Patches) != 0 {
+		t.Fatalf("Expected the reinvoked pod to be allowed unchanged, got allowed=%v patches=%+v", resp.Allowed, resp.Patches)
+	}
+
+	next := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}}}
+	if cpu, memory := mutator.Packing.Sidecars.Requests(next, deployment); cpu != 100 || memory != 128<<20 {
+		t.Errorf("Expected the next pod sized with the learned proxy, got %dm CPU and %d bytes", cpu, memory)
+	}
+}
+
+func TestSurgeCapacity(t *testing.T) {
+	replicas := int32(10)
+	two := intstr.FromInt(2)
+	half := intstr.FromString("50%")
+	tests := []struct {
+		name     string
+		strategy appsv1.DeploymentStrategy
+		expected int
+	}{
+		{name: "default 25% rounds up", strategy: appsv1.DeploymentStrategy{}, expected: 3},
+		{name: "absolute", strategy: appsv1.DeploymentStrategy{RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &two}}, expected: 2},
+		{name: "percentage", strategy: appsv1.DeploymentStrategy{RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &half}}, expected: 5},
+		{name: "recreate", strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &replicas, Strategy: tt.strategy}}
+			if got := SurgeCapacity(deployment); got != tt.expected {
+				t.Errorf("SurgeCapacity() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandlePlacesSurgePodsOnSurgeTarget(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(4)
+	deployment.Spec.Replicas = &replicas
+	deployment.Annotations[ScheduleStrategyAnnotation] = "base=0,weight=1,nodeSelector=node-type:ondemand;weight=0,surgeTarget=true,nodeSelector=node-type:spot"
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	spotNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "spot-0", Labels: map[string]string{"node-type": "spot"}},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+	if err := mutator.Client.Create(ctx, spotNode); err != nil {
+		t.Fatal(err)
+	}
+
+	// admit returns the node type and surge annotation the admitted pod is patched with
+	admit := func() (string, string) {
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		var nodeType, surge string
+		for _, patch := range resp.Patches {
+			switch patch.Path {
+			case "/spec/nodeSelector":
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ = selector["node-type"].(string)
+			case "/metadata/annotations":
+				annotations, _ := patch.Value.(map[string]interface{})
+				surge, _ = annotations[SurgePodAnnotation].(string)
+			}
+		}
+		return nodeType, surge
+	}
+
+	// With all four replicas running, the next pod is a rollout's surge pod
+	if nodeType, surge := admit(); nodeType != "spot" || surge != "true" {
+		t.Errorf("Expected the surge pod on spot and annotated, got %q (surge=%q)", nodeType, surge)
+	}
+
+	// Below the desired replicas, pods are placed by weight again
+	replicas = 5
+	deployment.Spec.Replicas = &replicas
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if nodeType, surge := admit(); nodeType != "ondemand" || surge != "" {
+		t.Errorf("Expected a regular pod on ondemand, got %q (surge=%q)", nodeType, surge)
+	}
+}
+
+func TestHandleAvoidsTopologySpreadConflicts(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 0)
+	ctx := context.Background()
+
+	zone := "topology.kubernetes.io/zone"
+	for _, node := range []struct{ name, nodeType, zone string }{
+		{"ondemand-a", "ondemand", "us-west-2a"},
+		{"spot-a", "spot", "us-west-2a"},
+		{"spot-b", "spot", "us-west-2b"},
+	} {
+		if err := mutator.Client.Create(ctx, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: node.name, Labels: map[string]string{"node-type": node.nodeType, zone: node.zone}},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// admit returns the node type the pod is placed on, its recorded conflict and the warnings
+	admit := func(constraint corev1.TopologySpreadConstraint) (string, string, []string) {
+		t.Helper()
+		spread := pod.DeepCopy()
+		spread.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{constraint}
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, spread))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		var nodeType, conflict string
+		for _, patch := range resp.Patches {
+			switch patch.Path {
+			case "/spec/nodeSelector":
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ = selector["node-type"].(string)
+			case "/metadata/annotations":
+				annotations, _ := patch.Value.(map[string]interface{})
+				conflict, _ = annotations[TopologySpreadConflictAnnotation].(string)
+			}
+		}
+		return nodeType, conflict, resp.Warnings
+	}
+
+	// The base pod would go to ondemand, which only reaches one of the two zones balanced across
+	ignore := corev1.NodeInclusionPolicyIgnore
+	nodeType, conflict, warnings := admit(corev1.TopologySpreadConstraint{
+		MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule, NodeAffinityPolicy: &ignore,
+	})
+	if nodeType != "spot" || conflict != "" || len(warnings) != 0 {
+		t.Errorf("Expected the pod on the compatible spot rule without warning, got %q (conflict=%q, warnings=%v)", nodeType, conflict, warnings)
+	}
+
+	// No rule reaches three zones; the pod is placed as usual and warned about
+	three := int32(3)
+	nodeType, conflict, warnings = admit(corev1.TopologySpreadConstraint{
+		MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule, MinDomains: &three,
+	})
+	if nodeType == "" || conflict == "" || len(warnings) != 1 {
+		t.Errorf("Expected the pod placed with a recorded conflict and a warning, got %q (conflict=%q, warnings=%v)", nodeType, conflict, warnings)
+	}
+}
+
+func TestHandlePlacesOnlySelectedPods(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+
+	// web-abc-0 is the only worker and runs on ondemand; the three leaders run on spot
+	for i := 0; i < 4; i++ {
+		existing := &corev1.Pod{}
+		if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: fmt.Sprintf("web-abc-%d", i)}, existing); err != nil {
+			t.Fatal(err)
+		}
+		existing.Labels["role"] = "leader"
+		if i == 0 {
+			existing.Labels["role"] = "worker"
+		}
+		if err := mutator.Client.Update(ctx, existing); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Annotations[PodSelectorAnnotation] = "role=worker"
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	// admit returns the node type the pod is placed on, or "" when it is not placed
+	admit := func(role string) string {
+		t.Helper()
+		labelled := pod.DeepCopy()
+		labelled.Labels["role"] = role
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, labelled))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		for _, patch := range resp.Patches {
+			if patch.Path == "/spec/nodeSelector" {
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ := selector["node-type"].(string)
+				return nodeType
+			}
+		}
+		return ""
+	}
+
+	if nodeType := admit("leader"); nodeType != "" {
+		t.Errorf("Expected a pod outside the pod selector to be left alone, got it placed on %q", nodeType)
+	}
+	// Counted alone, the worker on ondemand covers the base, so the next worker goes to spot
+	if nodeType := admit("worker"); nodeType != "spot" {
+		t.Errorf("Expected the worker placed on spot against the workers' counts, got %q", nodeType)
+	}
+
+	// An invalid selector places every pod
+	deployment.Annotations[PodSelectorAnnotation] = "role in (worker"
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if nodeType := admit("leader"); nodeType == "" {
+		t.Error("Expected the pod placed when the pod selector is invalid")
+	}
+}
+
+func TestHandlePlacesStatefulSetPodsByOrdinal(t *testing.T) {
+	mutator, _ := newBenchmarkMutator(t, 0)
+	ctx := context.Background()
+
+	controller := true
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "db",
+			Namespace:   "default",
+			UID:         types.UID("statefulset-uid"),
+			Annotations: map[string]string{ScheduleStrategyAnnotation: benchmarkStrategy},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+	}
+	if err := mutator.Client.Create(ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+	strategy, err := ParsePlacementStrategy(benchmarkStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// admit returns the node type the StatefulSet's pod of the ordinal is placed on, or "" when it is not placed
+	admit := func(ordinal int) string {
+		t.Helper()
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("db-%d", ordinal),
+				Namespace: "default",
+				Labels:    map[string]string{"app": "db", appsv1.PodIndexLabel: fmt.Sprint(ordinal)},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "StatefulSet", Name: sts.Name, UID: sts.UID, Controller: &controller,
+				}},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "db", Image: "postgres:16"}}},
+		}
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		for _, patch := range resp.Patches {
+			if patch.Path == "/spec/nodeSelector" {
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ := selector["node-type"].(string)
+				return nodeType
+			}
+		}
+		return ""
+	}
+
+	if nodeType := admit(0); nodeType != "" {
+		t.Errorf("Expected StatefulSet pods left alone without the feature, got db-0 placed on %q", nodeType)
+	}
+
+	mutator.StatefulSets = true
+	for ordinal := 0; ordinal < 5; ordinal++ {
+		want := strategy.Rules[OrdinalRule(strategy, ordinal)].NodeSelector["node-type"]
+		if nodeType := admit(ordinal); nodeType != want {
+			t.Errorf("Expected db-%d placed on %q, got %q", ordinal, want, nodeType)
+		}
+	}
+	// A recreated pod returns to its ordinal's rule, though the base is already filled
+	if nodeType := admit(0); nodeType != "ondemand" {
+		t.Errorf("Expected recreated db-0 placed on ondemand, got %q", nodeType)
+	}
+}
+
+func TestHandleSkipsPreBoundPods(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 2)
+	pod.Spec.NodeName = "node-7"
+
+	resp := mutator.Handle(context.Background(), newAdmissionRequest(t, pod))
+	if !resp.Allowed {
+		t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+	}
+	if got := resp.AuditAnnotations[SkipReasonAuditAnnotation]; got != SkipReasonPreBound {
+		t.Errorf("Expected audit annotation %q, got %q", SkipReasonPreBound, got)
+	}
+	annotated := false
+	for _, patch := range resp.Patches {
+		if strings.HasPrefix(patch.Path, "/spec") {
+			t.Errorf("Expected a pre-bound pod's spec left alone, got patch %s", patch.Path)
+		}
+		if patch.Path == "/metadata/annotations" {
+			annotations, _ := patch.Value.(map[string]interface{})
+			annotated = annotations[SkipReasonAnnotation] == SkipReasonPreBound
+		}
+	}
+	if !annotated {
+		t.Errorf("Expected the pod annotated with %s=%s, got patches %+v", SkipReasonAnnotation, SkipReasonPreBound, resp.Patches)
+	}
+}
+
+func TestHandleDefersPodsHeldByQueue(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 2)
+	ctx := context.Background()
+
+	// nodeSelectorPatch returns the nodeSelector the response sets, or nil when it sets none
+	nodeSelectorPatch := func(resp admission.Response) map[string]interface{} {
+		t.Helper()
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		for _, patch := range resp.Patches {
+			if patch.Path == "/spec/nodeSelector" {
+				selector, _ := patch.Value.(map[string]interface{})
+				return selector
+			}
+			if strings.HasPrefix(patch.Path, "/spec/nodeSelector/") {
+				return map[string]interface{}{strings.TrimPrefix(patch.Path, "/spec/nodeSelector/"): patch.Value}
+			}
+		}
+		return nil
+	}
+
+	gated := pod.DeepCopy()
+	gated.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: KueueAdmissionGate}}
+	resp := mutator.Handle(ctx, newAdmissionRequest(t, gated))
+	if selector := nodeSelectorPatch(resp); selector != nil {
+		t.Errorf("Expected a pod held by the queue left unplaced, got nodeSelector %v", selector)
+	}
+	deferred := false
+	for _, patch := range resp.Patches {
+		if annotations, ok := patch.Value.(map[string]interface{}); ok && patch.Path == "/metadata/annotations" {
+			deferred = annotations[QueueAdmissionAnnotation] == QueueAdmissionPending
+		}
+	}
+	if !deferred {
+		t.Fatalf("Expected the pod marked as waiting for the queue, got patches %+v", resp.Patches)
+	}
+
+	// release returns the update Kueue makes when it admits the workload to the given flavor
+	release := func(flavor map[string]string) admission.Request {
+		released := pod.DeepCopy()
+		released.Annotations = map[string]string{QueueAdmissionAnnotation: QueueAdmissionPending}
+		released.Spec.NodeSelector = flavor
+		req := newAdmissionRequest(t, released)
+		req.Operation = admissionv1.Update
+		return req
+	}
+
+	// The base is not covered yet, but the queue admitted the pod's quota on spot
+	resp = mutator.Handle(ctx, release(map[string]string{"node-type": "spot"}))
+	if selector := nodeSelectorPatch(resp); selector != nil {
+		t.Errorf("Expected the released pod kept on the flavor the queue assigned, got %v", selector)
+	}
+	placed := map[string]interface{}{}
+	for _, patch := range resp.Patches {
+		if key, ok := strings.CutPrefix(patch.Path, "/metadata/annotations/"); ok {
+			placed[strings.ReplaceAll(key, "~1", "/")] = patch.Value
+		}
+	}
+	if placed["smart-scheduler.io/placement-rule"] != "node-type=spot" || placed[QueueAdmissionAnnotation] != QueueAdmissionAdmitted {
+		t.Errorf("Expected the released pod placed on the spot rule, got annotation patches %v", placed)
+	}
+
+	// No rule agrees with the assigned flavor, so the pod is left on it
+	if selector := nodeSelectorPatch(mutator.Handle(ctx, release(map[string]string{"node-type": "gpu"}))); selector != nil {
+		t.Errorf("Expected a pod released to a flavor no rule selects left unplaced, got %v", selector)
+	}
+}
+
+func TestHandleProtectsBasePods(t *testing.T) {
+	ctx := context.Background()
+
+	// admit returns the annotations and spec fields the response sets
+	admit := func(mutator *PodMutator, pod *corev1.Pod) map[string]interface{} {
+		t.Helper()
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		set := map[string]interface{}{}
+		for _, patch := range resp.Patches {
+			if annotations, ok := patch.Value.(map[string]interface{}); ok && patch.Path == "/metadata/annotations" {
+				for key, value := range annotations {
+					set[key] = value
+				}
+				continue
+			}
+			set[strings.TrimPrefix(patch.Path, "/spec/")] = patch.Value
+		}
+		return set
+	}
+
+	// The first pod fills the base of one pod on ondemand
+	mutator, pod := newBenchmarkMutator(t, 0)
+	mutator.BaseProtection = true
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Annotations[BasePriorityClassAnnotation] = "base-critical"
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if err := mutator.Client.Create(ctx, &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "base-critical"}, Value: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	set := admit(mutator, pod)
+	if set[BasePodAnnotation] != "true" || set[PodDeletionCostAnnotation] != basePodDeletionCost {
+		t.Errorf("Expected the base pod marked with deletion cost %s, got %v", basePodDeletionCost, set)
+	}
+	if set["priorityClassName"] != "base-critical" || set["priority"] != float64(1000) {
+		t.Errorf("Expected the base pod given the base PriorityClass, got %v", set)
+	}
+
+	// With the base covered, pods placed by weight are not protected
+	mutator, pod = newBenchmarkMutator(t, 4)
+	mutator.BaseProtection = true
+	set = admit(mutator, pod)
+	if _, ok := set[BasePodAnnotation]; ok {
+		t.Errorf("Expected a weighted pod left unprotected, got %v", set)
+	}
+}
+
+func TestHandleAvoidsRulesThatCannotMountVolumes(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 4)
+	ctx := context.Background()
+	zone := "topology.kubernetes.io/zone"
+
+	objects := []client.Object{
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "ondemand-a", Labels: map[string]string{"node-type": "ondemand", zone: "us-west-2a"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-b", Labels: map[string]string{"node-type": "spot", zone: "us-west-2b"}}},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+			Spec: corev1.PersistentVolumeSpec{NodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: zone, Operator: corev1.NodeSelectorOpIn, Values: []string{"us-west-2a"}},
+				}}},
+			}}},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-data"},
+		},
+	}
+	for _, object := range objects {
+		if err := mutator.Client.Create(ctx, object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pod.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+	}}}
+
+	// admit returns the node type the pod is placed on, or "" when it is not placed, and its
+	// skipped-rules annotation
+	admit := func() (string, string) {
+		t.Helper()
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		nodeType, skipped := "", ""
+		for _, patch := range resp.Patches {
+			switch patch.Path {
+			case "/spec/nodeSelector":
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ = selector["node-type"].(string)
+			case "/metadata/annotations":
+				annotations, _ := patch.Value.(map[string]interface{})
+				skipped, _ = annotations[SkippedRulesAnnotation].(string)
+			case "/metadata/annotations/" + strings.ReplaceAll(SkippedRulesAnnotation, "/", "~1"):
+				skipped, _ = patch.Value.(string)
+			}
+		}
+		return nodeType, skipped
+	}
+
+	// The base is covered, so the pod would go to spot, whose nodes cannot mount the volume
+	nodeType, skipped := admit()
+	if nodeType != "ondemand" {
+		t.Errorf("Expected the pod placed on ondemand, where its volume is, got %q", nodeType)
+	}
+	if want := `{"node-type=spot":"VolumeTopologyConflict"}`; skipped != want {
+		t.Errorf("Expected skipped rules %s, got %q", want, skipped)
+	}
+
+	// No rule can mount a volume in another zone: the pod is left to the scheduler
+	pv := &corev1.PersistentVolume{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Name: "pv-data"}, pv); err != nil {
+		t.Fatal(err)
+	}
+	pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values = []string{"us-west-2c"}
+	if err := mutator.Client.Update(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+	if nodeType, _ := admit(); nodeType != "" {
+		t.Errorf("Expected the pod left unplaced, got it placed on %q", nodeType)
+	}
+}
+
+func TestScaleDownTracker(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	tainted := func(name string, taints ...corev1.Taint) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{Taints: taints}}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		tainted("autoscaler-candidate", corev1.Taint{Key: "DeletionCandidateOfClusterAutoscaler", Effect: corev1.TaintEffectPreferNoSchedule}),
+		tainted("karpenter-disrupted", corev1.Taint{Key: "karpenter.sh/disrupted", Effect: corev1.TaintEffectNoSchedule}),
+		tainted("dedicated", corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}),
+		tainted("plain"),
+	).Build()
+
+	tracker := NewScaleDownTracker(c, logr.Discard())
+	nodeNames, err := tracker.NodesScheduledForRemoval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(nodeNames); got != "[autoscaler-candidate karpenter-disrupted]" {
+		t.Errorf("NodesScheduledForRemoval() = %s, want [autoscaler-candidate karpenter-disrupted]", got)
+	}
+
+	var disabled *ScaleDownTracker
+	if nodeNames, err := disabled.NodesScheduledForRemoval(context.Background()); err != nil || nodeNames != nil {
+		t.Errorf("nil tracker returned %v, %v", nodeNames, err)
+	}
+}
+
+func TestHandlePlacesExperimentArms(t *testing.T) {
+	mutator, pod := newBenchmarkMutator(t, 4)
+	mutator.Experiments = true
+	ctx := context.Background()
+
+	deployment := &appsv1.Deployment{}
+	if err := mutator.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Annotations[ExperimentAnnotation] = `{"name":"gpu","percentage":50,"strategy":"base=0,weight=1,nodeSelector=node-type:gpu"}`
+	if err := mutator.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	// admit returns the node type and experiment arm the admitted pod is patched with
+	admit := func() (string, string) {
+		resp := mutator.Handle(ctx, newAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("Expected the pod to be allowed, got %+v", resp.Result)
+		}
+		var nodeType, arm string
+		for _, patch := range resp.Patches {
+			switch patch.Path {
+			case "/spec/nodeSelector":
+				selector, _ := patch.Value.(map[string]interface{})
+				nodeType, _ = selector["node-type"].(string)
+			case "/metadata/labels/smart-scheduler.io~1experiment-arm":
+				arm, _ = patch.Value.(string)
+			}
+		}
+		return nodeType, arm
+	}
+
+	// None of the four running pods is in the candidate arm yet
+	if nodeType, arm := admit(); nodeType != "gpu" || arm != ArmCandidate {
+		t.Errorf("Expected a candidate pod on gpu, got %q (arm=%q)", nodeType, arm)
+	}
+
+	// Once the next pod would push the candidate arm past half, it follows the deployment's strategy
+	for i := 0; i < 5; i++ {
+		candidate := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("web-abc-gpu-%d", i),
+				Namespace: "default",
+				Labels: map[string]string{
+					"app":              "web",
+					ExperimentLabel:    "gpu",
+					ExperimentArmLabel: ArmCandidate,
+				},
+				OwnerReferences: pod.OwnerReferences,
+			},
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{"node-type": "gpu"},
+				Containers:   []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+			},
+		}
+		if err := mutator.Client.Create(ctx, candidate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if nodeType, arm := admit(); nodeType == "gpu" || arm != ArmControl {
+		t.Errorf("Expected a control pod placed by the deployment's strategy, got %q (arm=%q)", nodeType, arm)
+	}
+}
+
+func TestSlowStartDetector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ondemand-0"},
+		Status: corev1.NodeStatus{Images: []corev1.ContainerImage{
+			{Names: []string{"docker.io/library/nginx:1.25"}, SizeBytes: 70 << 20},
+			{Names: []string{"registry.example.com/ml/model-loader:v3"}, SizeBytes: 6 << 30},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	detector := NewSlowStartDetector(c, DefaultSlowStartImageSize, logr.Discard())
+
+	podWith := func(annotations map[string]string, initImage string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}}},
+		}
+		if initImage != "" {
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: initImage}}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{name: "small images reported by nodes", pod: podWith(nil, ""), want: false},
+		{name: "large init container image", pod: podWith(nil, "registry.example.com/ml/model-loader:v3"), want: true},
+		{name: "declared image size", pod: podWith(map[string]string{ImageSizeAnnotation: "12Gi"}, ""), want: true},
+		{name: "override", pod: podWith(map[string]string{SlowStartAnnotation: "false"}, "registry.example.com/ml/model-loader:v3"), want: false},
+		{name: "image never pulled", pod: podWith(nil, "registry.example.com/unknown:v1"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detector.IsSlowStart(context.Background(), tt.pod); got != tt.want {
+				t.Errorf("IsSlowStart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,preemptible=true,nodeSelector=node-type:spot;weight=1,nodeSelector=node-type:ondemand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	avoided := AvoidPreemptibleRules(strategy)
+	if len(avoided.Rules) != 1 || avoided.Rules[0].NodeSelector["node-type"] != "ondemand" || avoided.Base != 0 {
+		t.Errorf("Expected only the ondemand rule without base, got %+v", avoided)
+	}
+
+	var disabled *SlowStartDetector
+	if disabled.IsSlowStart(context.Background(), podWith(map[string]string{SlowStartAnnotation: "true"}, "")) {
+		t.Error("Expected a nil detector to find no slow pods")
+	}
+}
+
+func TestResolveBaseFromPDB(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := int32(10)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}},
+		},
+	}
+	pdb := func(name string, selector map[string]string, minAvailable, maxUnavailable *intstr.IntOrString) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector:       &metav1.LabelSelector{MatchLabels: selector},
+				MinAvailable:   minAvailable,
+				MaxUnavailable: maxUnavailable,
+			},
+		}
+	}
+	percent := intstr.FromString("45%")
+	three := intstr.FromInt(3)
+	one := intstr.FromInt(1)
+
+	strategy, err := ParsePlacementStrategy("base=2,baseFrom=pdb,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pdbs []client.Object
+		want int
+	}{
+		{name: "no budget falls back to base", want: 2},
+		{name: "percentage rounds up", pdbs: []client.Object{pdb("half", map[string]string{"app": "web"}, &percent, nil)}, want: 5},
+		{
+			name: "strictest budget wins",
+			pdbs: []client.Object{
+				pdb("half", map[string]string{"app": "web"}, &percent, nil),
+				pdb("three", map[string]string{"app": "web"}, nil, &three),
+			},
+			want: 7,
+		},
+		{name: "other pods' budget is ignored", pdbs: []client.Object{pdb("api", map[string]string{"app": "api"}, nil, &one)}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.pdbs...).Build()
+			resolved, err := ResolveBase(context.Background(), c, deployment, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved.Base != tt.want {
+				t.Errorf("Base = %d, want %d", resolved.Base, tt.want)
+			}
+			if strategy.Base != 2 {
+				t.Errorf("Expected the parsed strategy to keep base 2, got %d", strategy.Base)
+			}
+		})
+	}
+}
+
+func TestPatchResponse(t *testing.T) {
+	// apply returns raw with the response's patch applied
+	apply := func(t *testing.T, raw []byte, resp admission.Response) map[string]interface{} {
+		t.Helper()
+		data, err := json.Marshal(resp.Patches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, err := patch.Apply(raw)
+		if err != nil {
+			t.Fatalf("patch does not apply to the admitted pod: %v", err)
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(patched, &object); err != nil {
+			t.Fatal(err)
+		}
+		return object
+	}
+
+	// A field of a newer Kubernetes version the API types do not know
+	raw := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web-1","namespace":"default"},` +
+		`"spec":{"futureField":"kept","containers":[{"name":"web","image":"nginx"}]}}`)
+
+	t.Run("patches every modified field and keeps unknown ones", func(t *testing.T) {
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(raw, pod); err != nil {
+			t.Fatal(err)
+		}
+		pod.Labels = map[string]string{RuleLabel: "spot"}
+		pod.Annotations = map[string]string{"smart-scheduler.io/processed": "true"}
+		pod.Spec.NodeSelector = map[string]string{"node-type": "spot"}
+		pod.Spec.Tolerations = []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}
+		pod.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+			MaxSkew: 1, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.ScheduleAnyway,
+		}}
+
+		resp, err := PatchResponse(raw, pod)
+		if err != nil || !resp.Allowed {
+			t.Fatalf("PatchResponse() = %+v, %v", resp.Result, err)
+		}
+		for _, op := range resp.Patches {
+			if op.Operation == "remove" {
+				t.Errorf("Unexpected remove of %s", op.Path)
+			}
+		}
+
+		object := apply(t, raw, resp)
+		spec := object["spec"].(map[string]interface{})
+		if spec["futureField"] != "kept" {
+			t.Errorf("Expected the unknown field kept, spec = %v", spec)
+		}
+		for _, field := range []string{"nodeSelector", "tolerations", "topologySpreadConstraints"} {
+			if spec[field] == nil {
+				t.Errorf("Expected %s patched, spec = %v", field, spec)
+			}
+		}
+		metadata := object["metadata"].(map[string]interface{})
+		if metadata["labels"] == nil || metadata["annotations"] == nil {
+			t.Errorf("Expected labels and annotations patched, metadata = %v", metadata)
+		}
+	})
+
+	t.Run("falls back to the admitted object when the difference does not apply", func(t *testing.T) {
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(raw, pod); err != nil {
+			t.Fatal(err)
+		}
+		// The API types encode resources as {} where the admitted pod has none
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+
+		resp, err := PatchResponse(raw, pod)
+		if err != nil || !resp.Allowed {
+			t.Fatalf("PatchResponse() = %+v, %v", resp.Result, err)
+		}
+		object := apply(t, raw, resp)
+		container := object["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})
+		if container["resources"] == nil {
+			t.Errorf("Expected the resources patched, container = %v", container)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// SystemNamespaces are always protected, whatever the configuration
+var SystemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// serviceAccountNamespaceFile holds the namespace of the pod's service account
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// NamespaceGuard decides which namespaces the operator must never mutate or evict pods in. System
+// namespaces and the operator's own namespace are always protected; DenyList adds glob patterns
+// such as "team-*-prod". A nil guard protects the system namespaces only.
+type NamespaceGuard struct {
+	operatorNamespace string
+	denyList          []string
+}
+
+// NewNamespaceGuard returns a guard protecting the operator's namespace and the deny-list patterns
+func NewNamespaceGuard(operatorNamespace string, denyList []string) (*NamespaceGuard, error) {
+	guard := &NamespaceGuard{operatorNamespace: operatorNamespace}
+	for _, pattern := range denyList {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid protected namespace pattern %q: %w", pattern, err)
+		}
+		guard.denyList = append(guard.denyList, pattern)
+	}
+	return guard, nil
+}
+
+// Protected reports whether the operator must leave pods in the namespace alone
+func (g *NamespaceGuard) Protected(namespace string) bool {
+	for _, system := range SystemNamespaces {
+		if namespace == system {
+			return true
+		}
+	}
+	if g == nil {
+		return false
+	}
+	if g.operatorNamespace != "" && namespace == g.operatorNamespace {
+		return true
+	}
+	for _, pattern := range g.denyList {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// OperatorNamespace returns the namespace the operator runs in, from the POD_NAMESPACE environment
+// variable or the mounted service account. It returns "" when running outside a cluster.
+func OperatorNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// parseNodeAffinity parses a rule's node affinity expressions
+// Format: "key:operator:value|value" expressions separated by commas, e.g.
+// "topology.kubernetes.io/zone:In:us-east-1a|us-east-1b,gpu:Exists,cpu-count:Gt:8".
+// In and NotIn take one or more values, Gt and Lt one integer, Exists and DoesNotExist none.
+func parseNodeAffinity(nodeAffinityStr string) ([]corev1.NodeSelectorRequirement, error) {
+	var requirements []corev1.NodeSelectorRequirement
+	for _, entry := range strings.Split(nodeAffinityStr, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid node affinity format, expected key:operator:values, got: %s", entry)
+		}
+		requirement := corev1.NodeSelectorRequirement{
+			Key:      strings.TrimSpace(parts[0]),
+			Operator: corev1.NodeSelectorOperator(strings.TrimSpace(parts[1])),
+		}
+		if len(parts) == 3 {
+			for _, value := range strings.Split(parts[2], "|") {
+				if value = strings.TrimSpace(value); value != "" {
+					requirement.Values = append(requirement.Values, value)
+				}
+			}
+		}
+		if err := validateNodeSelectorRequirement(requirement); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, entry)
+		}
+		requirements = append(requirements, requirement)
+	}
+
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("no valid node affinity expressions found")
+	}
+	return requirements, nil
+}
+
+// validateNodeSelectorRequirement checks the requirement's operator and values as the API server
+// would for a pod's node affinity
+func validateNodeSelectorRequirement(requirement corev1.NodeSelectorRequirement) error {
+	if requirement.Key == "" {
+		return fmt.Errorf("empty key in node affinity expression")
+	}
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn, corev1.NodeSelectorOpNotIn:
+		if len(requirement.Values) == 0 {
+			return fmt.Errorf("operator %s requires values", requirement.Operator)
+		}
+	case corev1.NodeSelectorOpExists, corev1.NodeSelectorOpDoesNotExist:
+		if len(requirement.Values) > 0 {
+			return fmt.Errorf("operator %s takes no values", requirement.Operator)
+		}
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if len(requirement.Values) != 1 {
+			return fmt.Errorf("operator %s requires a single value", requirement.Operator)
+		}
+		if _, err := strconv.ParseInt(requirement.Values[0], 10, 64); err != nil {
+			return fmt.Errorf("operator %s requires an integer value", requirement.Operator)
+		}
+	default:
+		return fmt.Errorf("invalid node affinity operator, must be one of In, NotIn, Exists, DoesNotExist, Gt or Lt: %s", requirement.Operator)
+	}
+	if _, err := labels.NewRequirement(requirement.Key, nodeSelectorOperators[requirement.Operator], requirement.Values); err != nil {
+		return fmt.Errorf("invalid node affinity expression: %w", err)
+	}
+	return nil
+}
+
+// FormatNodeAffinity formats node affinity expressions in the annotation format parseNodeAffinity reads
+func FormatNodeAffinity(requirements []corev1.NodeSelectorRequirement) string {
+	entries := make([]string, 0, len(requirements))
+	for _, requirement := range requirements {
+		entry := requirement.Key + ":" + string(requirement.Operator)
+		if len(requirement.Values) > 0 {
+			entry += ":" + strings.Join(requirement.Values, "|")
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ",")
+}
+
+// NodeLabelSelector returns the selector of the nodes in the rule's pool: those carrying its
+// nodeSelector and satisfying its node affinity expressions
+func (r PlacementRule) NodeLabelSelector() labels.Selector {
+	selector := labels.SelectorFromSet(r.NodeSelector)
+	for _, requirement := range r.NodeAffinity {
+		parsed, err := labels.NewRequirement(requirement.Key, nodeSelectorOperators[requirement.Operator], requirement.Values)
+		if err != nil {
+			// Expressions are validated when the strategy is parsed
+			continue
+		}
+		selector = selector.Add(*parsed)
+	}
+	return selector
+}
+
+// RequireNodeAffinity adds the expressions to the pod's required node affinity. Node selector
+// terms are ORed, so the expressions are added to each of the pod's terms to hold whichever term
+// the node satisfies; a pod without required node affinity gets a single term.
+func RequireNodeAffinity(pod *corev1.Pod, requirements []corev1.NodeSelectorRequirement) {
+	if len(requirements) == 0 {
+		return
+	}
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		for _, requirement := range requirements {
+			if !hasNodeSelectorRequirement(term.MatchExpressions, requirement) {
+				term.MatchExpressions = append(term.MatchExpressions, *requirement.DeepCopy())
+			}
+		}
+	}
+}
+
+// hasNodeSelectorRequirement reports whether the expressions already contain the requirement
+func hasNodeSelectorRequirement(expressions []corev1.NodeSelectorRequirement, requirement corev1.NodeSelectorRequirement) bool {
+	for _, existing := range expressions {
+		if existing.Key == requirement.Key && existing.Operator == requirement.Operator &&
+			strings.Join(existing.Values, "|") == strings.Join(requirement.Values, "|") {
+			return true
+		}
+	}
+	return false
+}
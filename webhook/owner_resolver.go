@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OwnerResolver maps pods to their parent Deployment, remembering which Deployment controls each
+// ReplicaSet so pod events don't need a ReplicaSet lookup. Pods whose ReplicaSet is already gone,
+// as happens for pod deletions during a cascading delete, are resolved from the pod-template-hash.
+type OwnerResolver struct {
+	Reader client.Reader
+
+	mu sync.RWMutex
+	// edges maps ReplicaSet UIDs to the name of their controlling Deployment
+	edges map[types.UID]string
+}
+
+// NewOwnerResolver creates an owner resolver reading ReplicaSets through reader
+func NewOwnerResolver(reader client.Reader) *OwnerResolver {
+	return &OwnerResolver{
+		Reader: reader,
+		edges:  make(map[types.UID]string),
+	}
+}
+
+// DeploymentFor returns the name of the Deployment controlling the pod through its ReplicaSet.
+// It returns false without an error when the pod is not owned by a ReplicaSet or the ReplicaSet
+// is orphaned, and an error only when the ReplicaSet could not be read.
+func (or *OwnerResolver) DeploymentFor(ctx context.Context, pod *corev1.Pod) (string, bool, error) {
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "ReplicaSet" {
+		return "", false, nil
+	}
+
+	or.mu.RLock()
+	name, ok := or.edges[ownerRef.UID]
+	or.mu.RUnlock()
+	if ok {
+		return name, true, nil
+	}
+
+	rs := &appsv1.ReplicaSet{}
+	err := or.Reader.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ownerRef.Name}, rs)
+	if apierrors.IsNotFound(err) {
+		name, ok := DeploymentNameFromPodTemplateHash(ownerRef.Name, pod)
+		return name, ok, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to get replicaset %s: %w", ownerRef.Name, err)
+	}
+
+	name, ok = or.observe(rs)
+	return name, ok, nil
+}
+
+// observe records the ReplicaSet's controlling Deployment, forgetting it when the ReplicaSet is orphaned
+func (or *OwnerResolver) observe(rs *appsv1.ReplicaSet) (string, bool) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	rsOwnerRef := metav1.GetControllerOf(rs)
+	if rsOwnerRef == nil || rsOwnerRef.Kind != "Deployment" {
+		delete(or.edges, rs.UID)
+		return "", false
+	}
+	or.edges[rs.UID] = rsOwnerRef.Name
+	return rsOwnerRef.Name, true
+}
+
+// forget drops the edge recorded for a ReplicaSet
+func (or *OwnerResolver) forget(replicaSetUID types.UID) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	delete(or.edges, replicaSetUID)
+}
+
+// Len returns the number of remembered ReplicaSet edges
+func (or *OwnerResolver) Len() int {
+	or.mu.RLock()
+	defer or.mu.RUnlock()
+	return len(or.edges)
+}
+
+// RegisterInvalidation keeps the edges in sync with ReplicaSets being adopted, orphaned and deleted
+func (or *OwnerResolver) RegisterInvalidation(ctx context.Context, informers cache.Informers) error {
+	replicaSetInformer, err := informers.GetInformer(ctx, &appsv1.ReplicaSet{})
+	if err != nil {
+		return fmt.Errorf("failed to get replicaset informer: %w", err)
+	}
+	_, err = replicaSetInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if rs, ok := newObj.(*appsv1.ReplicaSet); ok {
+				or.observe(rs)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if rs, ok := obj.(*appsv1.ReplicaSet); ok {
+				or.forget(rs.UID)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch replicasets for ownership changes: %w", err)
+	}
+
+	return nil
+}
+
+// DeploymentNameFromPodTemplateHash derives the Deployment name from a ReplicaSet name of the form
+// "<deployment>-<pod-template-hash>", using the hash label the Deployment controller sets on the pod
+func DeploymentNameFromPodTemplateHash(replicaSetName string, pod *corev1.Pod) (string, bool) {
+	hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+	if hash == "" {
+		return "", false
+	}
+	name, found := strings.CutSuffix(replicaSetName, "-"+hash)
+	if !found || name == "" {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// PatchResponse returns the admission response patching the admitted pod, raw, into pod. The patch
+// is the JSON difference between the admitted pod and pod as the webhook's API types encode both,
+// so every field placement changed is patched, from the nodeSelector and affinity to labels,
+// annotations, tolerations and topology spread constraints, while fields of newer Kubernetes
+// versions that the types do not know are left as they are instead of being removed. When the
+// difference does not apply to raw, because raw leaves out an empty object the types always
+// encode, the difference against raw is returned instead.
+func PatchResponse(raw []byte, pod *corev1.Pod) (admission.Response, error) {
+	original := &corev1.Pod{}
+	if err := json.Unmarshal(raw, original); err != nil {
+		return admission.Response{}, fmt.Errorf("failed to decode admitted pod: %w", err)
+	}
+	originalBytes, err := json.Marshal(original)
+	if err != nil {
+		return admission.Response{}, fmt.Errorf("failed to marshal admitted pod: %w", err)
+	}
+	modifiedBytes, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Response{}, fmt.Errorf("failed to marshal modified pod: %w", err)
+	}
+
+	resp := admission.PatchResponseFromRaw(originalBytes, modifiedBytes)
+	if len(resp.Patches) == 0 || appliesTo(resp, raw) {
+		return resp, nil
+	}
+	return admission.PatchResponseFromRaw(raw, modifiedBytes), nil
+}
+
+// appliesTo reports whether the response's patch applies to raw
+func appliesTo(resp admission.Response, raw []byte) bool {
+	data, err := json.Marshal(resp.Patches)
+	if err != nil {
+		return false
+	}
+	patch, err := jsonpatch.DecodePatch(data)
+	if err != nil {
+		return false
+	}
+	_, err = patch.Apply(raw)
+	return err == nil
+}
@@ -22,17 +22,79 @@ type PlacementRule struct {
 	Weight       int               `json:"weight"`
 	NodeSelector map[string]string `json:"nodeSelector"`
 	Affinity     []AffinityRule    `json:"affinity,omitempty"`
+	// Tolerations let the rule's pods onto the tainted nodes of its pool
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// NodeAffinity narrows the rule's pool to the nodes satisfying every expression, added to the
+	// pods it places as required node affinity
+	NodeAffinity []corev1.NodeSelectorRequirement `json:"nodeAffinity,omitempty"`
+	// Reserve is the number of placeholder pods that keep capacity free on the rule's pool
+	Reserve int `json:"reserve,omitempty"`
+	// Packing prefers the most ("most") or least ("least") utilized nodes of the rule's pool
+	Packing string `json:"packing,omitempty"`
+	// SurgeTarget takes the pods a rolling update creates above the deployment's replicas
+	SurgeTarget bool `json:"surgeTarget,omitempty"`
+	// Preemptible marks the rule's pool as capacity that can be reclaimed at short notice, which
+	// pods with a slow start avoid
+	Preemptible bool `json:"preemptible,omitempty"`
+	// FormerSelectors are selectors the rule had before its nodes were relabeled, set from
+	// RuleRenamesAnnotation; pods placed with them still count toward the rule
+	FormerSelectors []map[string]string `json:"-"`
+
+	// key caches the canonical RuleKey computed when the rule is parsed
+	key RuleKey
 }
 
+// MaxRules is the most rules a strategy may have, e.g. one per zone and capacity type of a large
+// region. Pods are matched to rules by hash lookup, so placement stays fast up to the limit.
+const MaxRules = 64
+
+// Strategy modes supported by the placement engine
+const (
+	// StrategyModeWeighted distributes pods across rules by weight after the base count (default)
+	StrategyModeWeighted = "weighted"
+	// StrategyModeFailover treats rules as an ordered chain and places pods on the first healthy pool
+	StrategyModeFailover = "failover"
+)
+
 // PlacementStrategy represents the complete placement strategy for a workload
 type PlacementStrategy struct {
-	Base  int             `json:"base"`
-	Rules []PlacementRule `json:"rules"`
+	Base int    `json:"base"`
+	Mode string `json:"mode,omitempty"`
+	// BasePercent sizes the base as a percentage of the deployment's replicas, rounded up, when set
+	BasePercent int `json:"basePercent,omitempty"`
+	// BaseFrom derives the base from another object at placement time ("pdb"); Base is the
+	// fallback when that object does not exist
+	BaseFrom string          `json:"baseFrom,omitempty"`
+	Rules    []PlacementRule `json:"rules"`
+}
+
+// IsFailover reports whether the strategy uses ordered failover instead of weighted distribution
+func (s *PlacementStrategy) IsFailover() bool {
+	return s.Mode == StrategyModeFailover
 }
 
 // ParsePlacementStrategy parses the custom scheduling annotation into a structured strategy
 // Enhanced format: "base=1,weight=1,nodeSelector=node-type:ondemand,affinity=app:web-app:zone:preferred;weight=2,nodeSelector=node-type:spot,anti-affinity=app:web-app:zone:required"
+// A rule may add "reserve=<n>" to keep n placeholder pods on its pool (see CapacityReservation).
+// A rule may add "packing=most" or "packing=least" to rank the nodes of its pool by utilization (see BinPacking).
+// A rule may add "surgeTarget=true" to take the surge pods of rolling updates.
+// The base may be a percentage of the deployment's replicas ("base=20%"), recomputed as it scales.
+// The first rule may set "baseFrom=pdb" to derive the base from the deployment's PodDisruptionBudget.
+// A rule may add "preemptible=true" to keep pods with a slow start off its pool (see SlowStartDetector).
+// A rule may add "tolerations=spot:true:NoSchedule,dedicated::NoExecute" to tolerate the taints of its pool.
+// A rule may add "nodeAffinity=topology.kubernetes.io/zone:In:us-east-1a|us-east-1b,gpu:Exists" to narrow its pool.
+// Failover format: "mode=failover,nodeSelector=zone:zone-a;nodeSelector=zone:zone-b;weight=1" (a rule without nodeSelector means "any")
+// Errors are classified as ErrStrategyInvalid.
 func ParsePlacementStrategy(annotation string) (*PlacementStrategy, error) {
+	strategy, err := parsePlacementStrategy(annotation)
+	if err != nil {
+		return nil, Classify(ErrStrategyInvalid, err)
+	}
+	return strategy, nil
+}
+
+// parsePlacementStrategy implements ParsePlacementStrategy
+func parsePlacementStrategy(annotation string) (*PlacementStrategy, error) {
 	if annotation == "" {
 		return nil, fmt.Errorf("empty annotation")
 	}
@@ -68,15 +130,38 @@ func ParsePlacementStrategy(annotation string) (*PlacementStrategy, error) {
 	if len(strategy.Rules) == 0 {
 		return nil, fmt.Errorf("no placement rules found")
 	}
+	if len(strategy.Rules) > MaxRules {
+		return nil, fmt.Errorf("strategy has %d rules, at most %d are supported", len(strategy.Rules), MaxRules)
+	}
+	if err := validateNodeAffinityKeys(strategy); err != nil {
+		return nil, err
+	}
 
 	return strategy, nil
 }
 
+// validateNodeAffinityKeys rejects rules with node affinity whose nodeSelector another rule has.
+// Pods are counted toward rules by their nodeSelector, so rules told apart by their node affinity
+// alone would share their pods.
+func validateNodeAffinityKeys(strategy *PlacementStrategy) error {
+	for i, rule := range strategy.Rules {
+		if len(rule.NodeAffinity) == 0 {
+			continue
+		}
+		for j, other := range strategy.Rules {
+			if i != j && other.Key() == rule.Key() {
+				return fmt.Errorf("rules %d and %d have the same nodeSelector %q, rules with nodeAffinity need a nodeSelector of their own", min(i, j), max(i, j), rule.Key())
+			}
+		}
+	}
+	return nil
+}
+
 // parseFirstRule parses the first rule which includes the base count
 // Format: "base=1,weight=1,nodeSelector=node-type:ondemand,affinity=app:web-app:zone:preferred"
 func parseFirstRule(part string, strategy *PlacementStrategy) error {
 	// Split by comma to get individual parameters
-	params := strings.Split(part, ",")
+	params := splitRuleParams(part)
 
 	rule := PlacementRule{
 		NodeSelector: make(map[string]string),
@@ -90,12 +175,23 @@ func parseFirstRule(part string, strategy *PlacementStrategy) error {
 		}
 
 		if strings.HasPrefix(param, "base=") {
-			baseStr := strings.TrimPrefix(param, "base=")
-			base, err := strconv.Atoi(baseStr)
+			base, percent, err := parseBase(strings.TrimPrefix(param, "base="))
 			if err != nil {
-				return fmt.Errorf("invalid base count: %s", baseStr)
+				return err
+			}
+			strategy.Base, strategy.BasePercent = base, percent
+		} else if strings.HasPrefix(param, "mode=") {
+			mode := strings.TrimPrefix(param, "mode=")
+			if mode != StrategyModeWeighted && mode != StrategyModeFailover {
+				return fmt.Errorf("invalid mode, must be '%s' or '%s': %s", StrategyModeWeighted, StrategyModeFailover, mode)
 			}
-			strategy.Base = base
+			strategy.Mode = mode
+		} else if strings.HasPrefix(param, "baseFrom=") {
+			baseFrom := strings.TrimPrefix(param, "baseFrom=")
+			if baseFrom != BaseFromPDB {
+				return fmt.Errorf("invalid baseFrom, must be '%s': %s", BaseFromPDB, baseFrom)
+			}
+			strategy.BaseFrom = baseFrom
 		} else if strings.HasPrefix(param, "weight=") {
 			weightStr := strings.TrimPrefix(param, "weight=")
 			weight, err := strconv.Atoi(weightStr)
@@ -103,6 +199,42 @@ func parseFirstRule(part string, strategy *PlacementStrategy) error {
 				return fmt.Errorf("invalid weight: %s", weightStr)
 			}
 			rule.Weight = weight
+		} else if strings.HasPrefix(param, "reserve=") {
+			reserve, err := parseReserve(strings.TrimPrefix(param, "reserve="))
+			if err != nil {
+				return err
+			}
+			rule.Reserve = reserve
+		} else if strings.HasPrefix(param, "packing=") {
+			packing, err := parsePacking(strings.TrimPrefix(param, "packing="))
+			if err != nil {
+				return err
+			}
+			rule.Packing = packing
+		} else if strings.HasPrefix(param, "surgeTarget=") {
+			surgeTarget, err := strconv.ParseBool(strings.TrimPrefix(param, "surgeTarget="))
+			if err != nil {
+				return fmt.Errorf("invalid surgeTarget: %s", strings.TrimPrefix(param, "surgeTarget="))
+			}
+			rule.SurgeTarget = surgeTarget
+		} else if strings.HasPrefix(param, "preemptible=") {
+			preemptible, err := strconv.ParseBool(strings.TrimPrefix(param, "preemptible="))
+			if err != nil {
+				return fmt.Errorf("invalid preemptible: %s", strings.TrimPrefix(param, "preemptible="))
+			}
+			rule.Preemptible = preemptible
+		} else if strings.HasPrefix(param, "tolerations=") {
+			tolerations, err := parseTolerations(strings.TrimPrefix(param, "tolerations="))
+			if err != nil {
+				return fmt.Errorf("invalid tolerations: %w", err)
+			}
+			rule.Tolerations = append(rule.Tolerations, tolerations...)
+		} else if strings.HasPrefix(param, "nodeAffinity=") {
+			nodeAffinity, err := parseNodeAffinity(strings.TrimPrefix(param, "nodeAffinity="))
+			if err != nil {
+				return fmt.Errorf("invalid nodeAffinity: %w", err)
+			}
+			rule.NodeAffinity = append(rule.NodeAffinity, nodeAffinity...)
 		} else if strings.HasPrefix(param, "nodeSelector=") {
 			nodeSelectorStr := strings.TrimPrefix(param, "nodeSelector=")
 			if err := parseNodeSelector(nodeSelectorStr, rule.NodeSelector); err != nil {
@@ -117,6 +249,7 @@ func parseFirstRule(part string, strategy *PlacementStrategy) error {
 		}
 	}
 
+	rule.key = NodeSelectorKey(rule.NodeSelector)
 	strategy.Rules = append(strategy.Rules, rule)
 	return nil
 }
@@ -130,7 +263,7 @@ func parseRule(part string) (*PlacementRule, error) {
 	}
 
 	// Split by comma to get individual parameters
-	params := strings.Split(part, ",")
+	params := splitRuleParams(part)
 
 	for _, param := range params {
 		param = strings.TrimSpace(param)
@@ -145,6 +278,42 @@ func parseRule(part string) (*PlacementRule, error) {
 				return nil, fmt.Errorf("invalid weight: %s", weightStr)
 			}
 			rule.Weight = weight
+		} else if strings.HasPrefix(param, "reserve=") {
+			reserve, err := parseReserve(strings.TrimPrefix(param, "reserve="))
+			if err != nil {
+				return nil, err
+			}
+			rule.Reserve = reserve
+		} else if strings.HasPrefix(param, "packing=") {
+			packing, err := parsePacking(strings.TrimPrefix(param, "packing="))
+			if err != nil {
+				return nil, err
+			}
+			rule.Packing = packing
+		} else if strings.HasPrefix(param, "surgeTarget=") {
+			surgeTarget, err := strconv.ParseBool(strings.TrimPrefix(param, "surgeTarget="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid surgeTarget: %s", strings.TrimPrefix(param, "surgeTarget="))
+			}
+			rule.SurgeTarget = surgeTarget
+		} else if strings.HasPrefix(param, "preemptible=") {
+			preemptible, err := strconv.ParseBool(strings.TrimPrefix(param, "preemptible="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid preemptible: %s", strings.TrimPrefix(param, "preemptible="))
+			}
+			rule.Preemptible = preemptible
+		} else if strings.HasPrefix(param, "tolerations=") {
+			tolerations, err := parseTolerations(strings.TrimPrefix(param, "tolerations="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid tolerations: %w", err)
+			}
+			rule.Tolerations = append(rule.Tolerations, tolerations...)
+		} else if strings.HasPrefix(param, "nodeAffinity=") {
+			nodeAffinity, err := parseNodeAffinity(strings.TrimPrefix(param, "nodeAffinity="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid nodeAffinity: %w", err)
+			}
+			rule.NodeAffinity = append(rule.NodeAffinity, nodeAffinity...)
 		} else if strings.HasPrefix(param, "nodeSelector=") {
 			nodeSelectorStr := strings.TrimPrefix(param, "nodeSelector=")
 			if err := parseNodeSelector(nodeSelectorStr, rule.NodeSelector); err != nil {
@@ -159,9 +328,57 @@ func parseRule(part string) (*PlacementRule, error) {
 		}
 	}
 
+	rule.key = NodeSelectorKey(rule.NodeSelector)
 	return rule, nil
 }
 
+// splitRuleParams splits a rule into its key=value parameters. A segment without "=" continues the
+// previous parameter, so "nodeSelector=node-type:spot,zone:us-west-1" keeps both selector pairs.
+func splitRuleParams(part string) []string {
+	var params []string
+	for _, segment := range strings.Split(part, ",") {
+		if len(params) > 0 && !strings.Contains(segment, "=") && strings.TrimSpace(segment) != "" {
+			params[len(params)-1] += "," + segment
+			continue
+		}
+		params = append(params, segment)
+	}
+	return params
+}
+
+// parseBase parses the base as a pod count ("2") or a percentage of the replicas ("20%")
+func parseBase(baseStr string) (int, int, error) {
+	if percentStr, ok := strings.CutSuffix(baseStr, "%"); ok {
+		percent, err := strconv.Atoi(percentStr)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("invalid base percentage, must be between 0%% and 100%%: %s", baseStr)
+		}
+		return 0, percent, nil
+	}
+	base, err := strconv.Atoi(baseStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid base count: %s", baseStr)
+	}
+	return base, 0, nil
+}
+
+// parseReserve parses a rule's placeholder pod count
+func parseReserve(reserveStr string) (int, error) {
+	reserve, err := strconv.Atoi(reserveStr)
+	if err != nil || reserve < 0 {
+		return 0, fmt.Errorf("invalid reserve, must be a non-negative number: %s", reserveStr)
+	}
+	return reserve, nil
+}
+
+// parsePacking parses a rule's bin-packing mode
+func parsePacking(packing string) (string, error) {
+	if packing != PackingMostAllocated && packing != PackingLeastAllocated {
+		return "", fmt.Errorf("invalid packing, must be '%s' or '%s': %s", PackingMostAllocated, PackingLeastAllocated, packing)
+	}
+	return packing, nil
+}
+
 // parseAffinityRule parses affinity or anti-affinity rule
 // Format: "affinity=app:web-app:zone:preferred" or "anti-affinity=app:web-app:zone:required"
 func parseAffinityRule(param string) (*AffinityRule, error) {
@@ -241,11 +458,71 @@ func parseNodeSelector(nodeSelectorStr string, nodeSelector map[string]string) e
 		return fmt.Errorf("no valid nodeSelector pairs found")
 	}
 
-	return nil
+	return validatePlatformSelector(nodeSelector)
+}
+
+// parseTolerations parses a rule's tolerations
+// Format: "key:value:effect" pairs separated by commas, e.g. "spot:true:NoSchedule,dedicated::NoExecute".
+// An empty value tolerates the taint whatever its value (operator Exists), an empty or missing
+// effect tolerates every effect.
+func parseTolerations(tolerationsStr string) ([]corev1.Toleration, error) {
+	var tolerations []corev1.Toleration
+	for _, entry := range strings.Split(tolerationsStr, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid toleration format, expected key:value:effect, got: %s", entry)
+		}
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+
+		toleration := corev1.Toleration{
+			Key:      strings.TrimSpace(parts[0]),
+			Operator: corev1.TolerationOpEqual,
+			Value:    strings.TrimSpace(parts[1]),
+			Effect:   corev1.TaintEffect(strings.TrimSpace(parts[2])),
+		}
+		if toleration.Key == "" {
+			return nil, fmt.Errorf("empty key in toleration: %s", entry)
+		}
+		if toleration.Value == "" {
+			toleration.Operator = corev1.TolerationOpExists
+		}
+		switch toleration.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid toleration effect, must be '%s', '%s' or '%s': %s",
+				corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute, toleration.Effect)
+		}
+		tolerations = append(tolerations, toleration)
+	}
+
+	if len(tolerations) == 0 {
+		return nil, fmt.Errorf("no valid tolerations found")
+	}
+	return tolerations, nil
+}
+
+// FormatTolerations formats tolerations in the annotation format parseTolerations reads
+func FormatTolerations(tolerations []corev1.Toleration) string {
+	entries := make([]string, 0, len(tolerations))
+	for _, toleration := range tolerations {
+		value := toleration.Value
+		if toleration.Operator == corev1.TolerationOpExists {
+			value = ""
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s:%s", toleration.Key, value, toleration.Effect))
+	}
+	return strings.Join(entries, ",")
 }
 
 // ApplyPlacementStrategy applies the placement strategy to a pod based on current pod counts
-func ApplyPlacementStrategy(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[string]int) error {
+func ApplyPlacementStrategy(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[RuleKey]int) error {
 	if strategy == nil || len(strategy.Rules) == 0 {
 		return fmt.Errorf("invalid placement strategy")
 	}
@@ -263,7 +540,40 @@ func ApplyPlacementStrategy(pod *corev1.Pod, strategy *PlacementStrategy, curren
 	}
 
 	// For pods beyond the base count, use weighted distribution
-	return applyWeightedRule(pod, strategy, currentCounts, totalPods)
+	return applyWeightedRule(pod, strategy, currentCounts)
+}
+
+// ApplyForecastStrategy applies the rule of the pod's slot in the plan for the deployment's replicas,
+// so its pods reach ExpectedDistribution for the replicas whatever order they are admitted in. Pods
+// beyond the plan are placed as ApplyPlacementStrategy places them.
+func ApplyForecastStrategy(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[RuleKey]int, replicas int) error {
+	if strategy == nil || len(strategy.Rules) == 0 {
+		return fmt.Errorf("invalid placement strategy")
+	}
+	if i, ok := ForecastRule(strategy, currentCounts, replicas); ok {
+		return applyRule(pod, strategy.Rules[i])
+	}
+	return ApplyPlacementStrategy(pod, strategy, currentCounts)
+}
+
+// ApplyFailoverStrategy applies the first rule in the failover chain whose node pool is healthy.
+// When no pool is healthy the last rule of the chain is used, since it is the broadest fallback.
+func ApplyFailoverStrategy(pod *corev1.Pod, strategy *PlacementStrategy, isHealthy func(PlacementRule) bool) error {
+	if strategy == nil || len(strategy.Rules) == 0 {
+		return fmt.Errorf("invalid placement strategy")
+	}
+
+	return applyRule(pod, strategy.Rules[SelectFailoverRule(strategy, isHealthy)])
+}
+
+// SelectFailoverRule returns the index of the first rule in the chain whose pool is healthy
+func SelectFailoverRule(strategy *PlacementStrategy, isHealthy func(PlacementRule) bool) int {
+	for i, rule := range strategy.Rules {
+		if isHealthy(rule) {
+			return i
+		}
+	}
+	return len(strategy.Rules) - 1
 }
 
 // applyRule applies a specific placement rule to the pod
@@ -279,6 +589,16 @@ func applyRule(pod *corev1.Pod, rule PlacementRule) error {
 		}
 	}
 
+	// Add tolerations the pod does not have yet
+	for _, toleration := range rule.Tolerations {
+		if !hasToleration(pod, toleration) {
+			pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+		}
+	}
+
+	// Require the rule's node affinity expressions on top of the pod's own
+	RequireNodeAffinity(pod, rule.NodeAffinity)
+
 	// Apply affinity rules
 	if len(rule.Affinity) > 0 {
 		if pod.Spec.Affinity == nil {
@@ -295,6 +615,16 @@ func applyRule(pod *corev1.Pod, rule PlacementRule) error {
 	return nil
 }
 
+// hasToleration reports whether the pod already has the toleration
+func hasToleration(pod *corev1.Pod, toleration corev1.Toleration) bool {
+	for _, existing := range pod.Spec.Tolerations {
+		if existing.MatchToleration(&toleration) {
+			return true
+		}
+	}
+	return false
+}
+
 // applyAffinityRule applies a single affinity rule to the pod
 func applyAffinityRule(pod *corev1.Pod, rule AffinityRule) error {
 	labelSelector := &metav1.LabelSelector{
@@ -354,8 +684,9 @@ func applyAffinityRule(pod *corev1.Pod, rule AffinityRule) error {
 	return nil
 }
 
-// applyWeightedRule applies weighted distribution logic beyond base count
-func applyWeightedRule(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[string]int, totalPods int) error {
+// applyWeightedRule applies the rule furthest below its weighted share of the pods beyond base.
+// It uses the same ordering as ExpectedDistribution so the webhook and the rebalancer agree.
+func applyWeightedRule(pod *corev1.Pod, strategy *PlacementStrategy, currentCounts map[RuleKey]int) error {
 	if len(strategy.Rules) == 0 {
 		return fmt.Errorf("no rules available for weighted distribution")
 	}
@@ -370,52 +701,6 @@ func applyWeightedRule(pod *corev1.Pod, strategy *PlacementStrategy, currentCoun
 		return fmt.Errorf("total weight is zero")
 	}
 
-	// Find the rule that should get the next pod based on current distribution
-	podsBeyondBase := totalPods - strategy.Base
-	if podsBeyondBase < 0 {
-		podsBeyondBase = 0
-	}
-
-	// Calculate expected distribution for each rule
-	bestRule := strategy.Rules[0]
-	bestDeficit := -1.0
-
-	for _, rule := range strategy.Rules {
-		ruleKey := ruleToString(rule)
-		currentCount := currentCounts[ruleKey]
-
-		// Calculate expected count for this rule
-		expectedRatio := float64(rule.Weight) / float64(totalWeight)
-		expectedCount := int(expectedRatio * float64(podsBeyondBase))
-
-		// Calculate deficit (how many pods this rule is behind)
-		deficit := float64(expectedCount - currentCount)
-
-		if deficit > bestDeficit {
-			bestDeficit = deficit
-			bestRule = rule
-		}
-	}
-
-	return applyRule(pod, bestRule)
-}
-
-// ruleToString converts a placement rule to a string key for tracking
-func ruleToString(rule PlacementRule) string {
-	return nodeSelector2String(rule.NodeSelector)
-}
-
-// nodeSelector2String converts a nodeSelector map to a string key for tracking
-func nodeSelector2String(nodeSelector map[string]string) string {
-	if len(nodeSelector) == 0 {
-		return ""
-	}
-
-	var parts []string
-	for key, value := range nodeSelector {
-		parts = append(parts, fmt.Sprintf("%s=%s", key, value))
-	}
-
-	// Sort for consistent string representation
-	return strings.Join(parts, ",")
+	weighted, placed := weightedCounts(strategy, currentCounts)
+	return applyRule(pod, strategy.Rules[nextWeightedRule(strategy, weighted, placed)])
 }
@@ -1,10 +1,27 @@
 package webhook
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 func TestParseePlacementStrategy(t *testing.T) {
@@ -58,6 +75,78 @@ func TestParseePlacementStrategy(t *testing.T) {
 			annotation:  "base=1,weight=abc,nodeSelector=node-type:ondemand",
 			expectError: true,
 		},
+		{
+			name:          "Valid strategy with reserved capacity",
+			annotation:    "base=1,weight=1,reserve=2,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  1,
+			expectedRules: 2,
+		},
+		{
+			name:        "Invalid format - negative reserve",
+			annotation:  "base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,reserve=-1,nodeSelector=node-type:spot",
+			expectError: true,
+		},
+		{
+			name:          "Valid strategy with bin-packing",
+			annotation:    "base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,packing=most,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  1,
+			expectedRules: 2,
+		},
+		{
+			name:          "Valid strategy with a surge target",
+			annotation:    "base=2,weight=1,nodeSelector=node-type:ondemand;weight=1,surgeTarget=true,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  2,
+			expectedRules: 2,
+		},
+		{
+			name:          "Valid strategy with a preemptible rule",
+			annotation:    "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,preemptible=true,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  1,
+			expectedRules: 2,
+		},
+		{
+			name:        "Invalid format - invalid preemptible",
+			annotation:  "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,preemptible=sometimes,nodeSelector=node-type:spot",
+			expectError: true,
+		},
+		{
+			name:          "Valid strategy with base from a PodDisruptionBudget",
+			annotation:    "base=2,baseFrom=pdb,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  2,
+			expectedRules: 2,
+		},
+		{
+			name:          "Valid strategy with a percentage base",
+			annotation:    "base=20%,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+			expectError:   false,
+			expectedBase:  0,
+			expectedRules: 2,
+		},
+		{
+			name:        "Invalid format - base percentage above 100%",
+			annotation:  "base=120%,weight=1,nodeSelector=node-type:ondemand",
+			expectError: true,
+		},
+		{
+			name:        "Invalid format - unknown baseFrom",
+			annotation:  "base=2,baseFrom=hpa,weight=1,nodeSelector=node-type:ondemand",
+			expectError: true,
+		},
+		{
+			name:        "Invalid format - invalid surgeTarget",
+			annotation:  "base=1,weight=1,surgeTarget=maybe,nodeSelector=node-type:ondemand",
+			expectError: true,
+		},
+		{
+			name:        "Invalid format - unknown packing mode",
+			annotation:  "base=1,weight=1,packing=tight,nodeSelector=node-type:ondemand",
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -91,13 +180,13 @@ func TestApplyPlacementStrategy(t *testing.T) {
 	tests := []struct {
 		name                 string
 		annotation           string
-		currentCounts        map[string]int
+		currentCounts        map[RuleKey]int
 		expectedNodeSelector map[string]string
 	}{
 		{
 			name:       "First pod should go to ondemand (base)",
 			annotation: "base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot",
-			currentCounts: map[string]int{
+			currentCounts: map[RuleKey]int{
 				"node-type=ondemand": 0,
 				"node-type=spot":     0,
 			},
@@ -108,7 +197,7 @@ func TestApplyPlacementStrategy(t *testing.T) {
 		{
 			name:       "Second pod should go to spot (weighted)",
 			annotation: "base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot",
-			currentCounts: map[string]int{
+			currentCounts: map[RuleKey]int{
 				"node-type=ondemand": 1,
 				"node-type=spot":     0,
 			},
@@ -151,11 +240,11 @@ func TestApplyPlacementStrategy(t *testing.T) {
 	}
 }
 
-func TestNodeSelector2String(t *testing.T) {
+func TestNodeSelectorKey(t *testing.T) {
 	tests := []struct {
 		name     string
 		selector map[string]string
-		expected string
+		expected RuleKey
 	}{
 		{
 			name:     "Single key-value",
@@ -168,27 +257,1747 @@ func TestNodeSelector2String(t *testing.T) {
 			expected: "",
 		},
 		{
-			name:     "Multiple key-values",
-			selector: map[string]string{"node-type": "spot", "zone": "us-west-1"},
-			// Note: order may vary due to map iteration
+			name:     "Multiple key-values are sorted by key",
+			selector: map[string]string{"zone": "us-west-1", "node-type": "spot"},
+			expected: "node-type=spot,zone=us-west-1",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := nodeSelector2String(tt.selector)
-
-			if tt.name == "Multiple key-values" {
-				// For multiple entries, just check that it contains both
-				if !(len(result) > 0 &&
-					(result == "node-type=spot,zone=us-west-1" || result == "zone=us-west-1,node-type=spot")) {
-					t.Errorf("Expected result to contain both key-value pairs, got %s", result)
-				}
-			} else {
-				if result != tt.expected {
-					t.Errorf("Expected %s, got %s", tt.expected, result)
+			// Repeat to catch map iteration order leaking into the key
+			for i := 0; i < 20; i++ {
+				if result := NodeSelectorKey(tt.selector); result != tt.expected {
+					t.Fatalf("Expected %s, got %s", tt.expected, result)
 				}
 			}
 		})
 	}
 }
+
+func TestSelectFailoverRule(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("mode=failover,nodeSelector=zone:a;nodeSelector=zone:b;weight=1")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+	if !strategy.IsFailover() {
+		t.Fatalf("Expected failover mode, got %q", strategy.Mode)
+	}
+
+	tests := []struct {
+		name     string
+		healthy  map[string]bool
+		expected int
+	}{
+		{name: "Preferred zone healthy", healthy: map[string]bool{"a": true, "b": true, "": true}, expected: 0},
+		{name: "Fail over to second zone", healthy: map[string]bool{"b": true, "": true}, expected: 1},
+		{name: "Fail over to any", healthy: map[string]bool{"": true}, expected: 2},
+		{name: "Nothing healthy uses last rule", healthy: map[string]bool{}, expected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SelectFailoverRule(strategy, func(rule PlacementRule) bool {
+				return tt.healthy[rule.NodeSelector["zone"]]
+			})
+			if got != tt.expected {
+				t.Errorf("Expected rule %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterCompatibleRules(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=kubernetes.io/os:linux;weight=1,nodeSelector=kubernetes.io/os:windows;weight=1,nodeSelector=zone:a")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		expectedBase  int
+		expectedRules int
+	}{
+		{
+			name:          "Pod without OS keeps every rule",
+			pod:           &corev1.Pod{},
+			expectedBase:  1,
+			expectedRules: 3,
+		},
+		{
+			name:          "Linux pod drops windows rule",
+			pod:           &corev1.Pod{Spec: corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Linux}}},
+			expectedBase:  1,
+			expectedRules: 2,
+		},
+		{
+			name: "Windows image annotation drops linux rule and base",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{ImageOSAnnotation: "Windows"},
+			}},
+			expectedBase:  0,
+			expectedRules: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := FilterCompatibleRules(PodPlatform(tt.pod), strategy)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if filtered.Base != tt.expectedBase {
+				t.Errorf("Expected base %d, got %d", tt.expectedBase, filtered.Base)
+			}
+			if len(filtered.Rules) != tt.expectedRules {
+				t.Errorf("Expected %d rules, got %d", tt.expectedRules, len(filtered.Rules))
+			}
+		})
+	}
+
+	if _, err := ParsePlacementStrategy("weight=1,nodeSelector=kubernetes.io/os:plan9"); err == nil {
+		t.Errorf("Expected error for unsupported operating system")
+	}
+}
+
+func TestArchitectureCompatibility(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=0,weight=1,nodeSelector=kubernetes.io/arch:arm64;weight=3,nodeSelector=kubernetes.io/arch:amd64")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	amd64Only := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{ImageArchAnnotation: "amd64"},
+	}}
+	filtered, err := FilterCompatibleRules(PodPlatform(amd64Only), strategy)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(filtered.Rules) != 1 || filtered.Rules[0].NodeSelector["kubernetes.io/arch"] != "amd64" {
+		t.Errorf("Expected only the amd64 rule, got %v", filtered.Rules)
+	}
+
+	multiArch := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{ImageArchAnnotation: "amd64, arm64"},
+	}}
+	filtered, err = FilterCompatibleRules(PodPlatform(multiArch), strategy)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(filtered.Rules) != 2 {
+		t.Errorf("Expected both rules for a multi-arch image, got %d", len(filtered.Rules))
+	}
+
+	s390x := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{ImageArchAnnotation: "s390x"},
+	}}
+	if _, err := FilterCompatibleRules(PodPlatform(s390x), strategy); err == nil {
+		t.Errorf("Expected error when no rule matches the image architecture")
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		image      string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{image: "nginx", registry: "registry-1.docker.io", repository: "library/nginx", reference: "latest"},
+		{image: "bitnami/redis:7.2", registry: "registry-1.docker.io", repository: "bitnami/redis", reference: "7.2"},
+		{image: "ghcr.io/org/app:v1", registry: "ghcr.io", repository: "org/app", reference: "v1"},
+		{image: "localhost:5000/app", registry: "localhost:5000", repository: "app", reference: "latest"},
+		{image: "quay.io/org/app@sha256:abc", registry: "quay.io", repository: "org/app", reference: "sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			registry, repository, reference := parseImageReference(tt.image)
+			if registry != tt.registry || repository != tt.repository || reference != tt.reference {
+				t.Errorf("Expected %s %s %s, got %s %s %s",
+					tt.registry, tt.repository, tt.reference, registry, repository, reference)
+			}
+		})
+	}
+}
+
+func TestResolveScheduleStrategy(t *testing.T) {
+	annotations := map[string]string{
+		ScheduleStrategyAnnotation:   "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+		PriorityStrategiesAnnotation: `{"critical":"weight=1,nodeSelector=node-type:ondemand","batch":"weight=1,nodeSelector=node-type:spot"}`,
+	}
+
+	tests := []struct {
+		name              string
+		annotations       map[string]string
+		priorityClassName string
+		expected          string
+		expectError       bool
+	}{
+		{
+			name:              "Matching tier wins",
+			annotations:       annotations,
+			priorityClassName: "batch",
+			expected:          "weight=1,nodeSelector=node-type:spot",
+		},
+		{
+			name:              "Unknown class uses default",
+			annotations:       annotations,
+			priorityClassName: "standard",
+			expected:          annotations[ScheduleStrategyAnnotation],
+		},
+		{
+			name:        "No class uses default",
+			annotations: annotations,
+			expected:    annotations[ScheduleStrategyAnnotation],
+		},
+		{
+			name: "Malformed tiers fall back to default",
+			annotations: map[string]string{
+				ScheduleStrategyAnnotation:   "weight=1,nodeSelector=node-type:spot",
+				PriorityStrategiesAnnotation: `{"critical":`,
+			},
+			priorityClassName: "critical",
+			expected:          "weight=1,nodeSelector=node-type:spot",
+			expectError:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, exists, err := ResolveScheduleStrategy(tt.annotations, tt.priorityClassName)
+			if (err != nil) != tt.expectError {
+				t.Errorf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if !exists || strategy != tt.expected {
+				t.Errorf("Expected strategy %q, got %q (exists=%v)", tt.expected, strategy, exists)
+			}
+		})
+	}
+}
+
+func TestExcludeNodes(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"b"}}}},
+			},
+		},
+	}}}}
+
+	ExcludeNodes(pod, []string{"node-1"})
+
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 {
+		t.Fatalf("Expected the existing 2 terms to be kept, got %d", len(terms))
+	}
+	for i, term := range terms {
+		if len(term.MatchFields) != 1 || term.MatchFields[0].Values[0] != "node-1" {
+			t.Errorf("Expected term %d to exclude node-1, got %v", i, term.MatchFields)
+		}
+	}
+
+	empty := &corev1.Pod{}
+	ExcludeNodes(empty, []string{"node-1"})
+	if empty.Spec.Affinity == nil || len(empty.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) != 1 {
+		t.Errorf("Expected a single exclusion term on a pod without node affinity")
+	}
+}
+
+func TestStrategyCache(t *testing.T) {
+	cache := NewStrategyCache(2)
+
+	first, err := cache.Parse("base=1,weight=1,nodeSelector=zone:a;weight=2,nodeSelector=zone:b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Mutating a returned strategy must not affect later lookups
+	first.Rules[0].NodeSelector["zone"] = "changed"
+	second, _ := cache.Parse("base=1,weight=1,nodeSelector=zone:a;weight=2,nodeSelector=zone:b")
+	if second.Rules[0].NodeSelector["zone"] != "a" {
+		t.Errorf("Expected cached strategy to be isolated from callers, got %v", second.Rules[0].NodeSelector)
+	}
+
+	if _, err := cache.Parse("base=x,weight=1"); err == nil {
+		t.Errorf("Expected parse error for invalid annotation")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Expected failed parses not to be cached, got %d entries", cache.Len())
+	}
+
+	cache.Parse("weight=1,nodeSelector=zone:a")
+	cache.Parse("weight=1,nodeSelector=zone:b")
+	if cache.Len() != 2 {
+		t.Errorf("Expected cache to be bounded at 2 entries, got %d", cache.Len())
+	}
+}
+
+func TestUnmanagedCache(t *testing.T) {
+	cache := NewUnmanagedCache(time.Minute)
+	web := types.NamespacedName{Namespace: "default", Name: "web"}
+	api := types.NamespacedName{Namespace: "default", Name: "api"}
+
+	cache.MarkUnmanaged("rs-web-1", web)
+	cache.MarkUnmanaged("rs-web-2", web)
+	cache.MarkUnmanaged("rs-api-1", api)
+
+	if !cache.IsUnmanaged("rs-web-1") || cache.IsUnmanaged("rs-unknown") {
+		t.Errorf("Expected only marked ReplicaSets to be unmanaged")
+	}
+
+	cache.InvalidateDeployment(web)
+	if cache.IsUnmanaged("rs-web-1") || cache.IsUnmanaged("rs-web-2") {
+		t.Errorf("Expected invalidation to drop every ReplicaSet of the deployment")
+	}
+	if !cache.IsUnmanaged("rs-api-1") {
+		t.Errorf("Expected other deployments to stay cached")
+	}
+
+	if !StrategyAnnotationsChanged(nil, map[string]string{ScheduleStrategyAnnotation: "weight=1"}) {
+		t.Errorf("Expected adding a strategy to count as a change")
+	}
+	if StrategyAnnotationsChanged(map[string]string{"team": "a"}, map[string]string{"team": "b"}) {
+		t.Errorf("Expected unrelated annotation changes to be ignored")
+	}
+}
+
+func TestParseReserve(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand,reserve=2;weight=2,reserve=0,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strategy.Rules[0].Reserve != 2 || strategy.Rules[1].Reserve != 0 {
+		t.Errorf("Expected reserves 2 and 0, got %d and %d", strategy.Rules[0].Reserve, strategy.Rules[1].Reserve)
+	}
+	if got := strategy.Rules[0].Key(); got != "node-type=ondemand" {
+		t.Errorf("Expected reserve after the selector to end it, got %s", got)
+	}
+}
+
+func TestParseTolerations(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,tolerations=spot:true:NoSchedule,dedicated::NoExecute,gpu,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strategy.Rules[0].Tolerations != nil {
+		t.Errorf("Expected no tolerations on the first rule, got %v", strategy.Rules[0].Tolerations)
+	}
+	want := []corev1.Toleration{
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		{Key: "gpu", Operator: corev1.TolerationOpExists},
+	}
+	if !reflect.DeepEqual(strategy.Rules[1].Tolerations, want) {
+		t.Errorf("Tolerations = %v, want %v", strategy.Rules[1].Tolerations, want)
+	}
+	if got := strategy.Rules[1].Key(); got != "node-type=spot" {
+		t.Errorf("Expected the selector after the tolerations to be parsed, got %s", got)
+	}
+	if got := FormatTolerations(want); got != "spot:true:NoSchedule,dedicated::NoExecute,gpu::" {
+		t.Errorf("FormatTolerations() = %s", got)
+	}
+
+	for _, annotation := range []string{
+		"base=1,weight=1,tolerations=spot:true:NoExecute:extra",
+		"base=1,weight=1,tolerations=spot:true:Evict",
+		"base=1,weight=1,tolerations=:true:NoSchedule",
+		"base=1,weight=1,tolerations=",
+	} {
+		if _, err := ParsePlacementStrategy(annotation); err == nil {
+			t.Errorf("Expected %q to be rejected", annotation)
+		}
+	}
+}
+
+func TestApplyRuleTolerations(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=0,weight=1,nodeSelector=node-type:spot,tolerations=spot:true:NoSchedule,dedicated::NoExecute")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{
+		{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoSchedule},
+	}}}
+
+	if err := ApplyPlacementStrategy(pod, strategy, map[RuleKey]int{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pod.Spec.NodeSelector["node-type"] != "spot" {
+		t.Errorf("Expected the nodeSelector applied, got %v", pod.Spec.NodeSelector)
+	}
+	want := []string{"node.kubernetes.io/not-ready", "spot", "dedicated"}
+	var got []string
+	for _, toleration := range pod.Spec.Tolerations {
+		got = append(got, toleration.Key)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Toleration keys = %v, want the template's kept and the rule's added once: %v", got, want)
+	}
+}
+
+func TestParseNodeAffinity(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeAffinity=topology.kubernetes.io/zone:In:us-east-1a|us-east-1b,node.kubernetes.io/instance-type:NotIn:t3.micro,gpu:Exists,legacy:DoesNotExist,cpu-count:Gt:8,cpu-count:Lt:64,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strategy.Rules[0].NodeAffinity != nil {
+		t.Errorf("Expected no node affinity on the first rule, got %v", strategy.Rules[0].NodeAffinity)
+	}
+	want := []corev1.NodeSelectorRequirement{
+		{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"us-east-1a", "us-east-1b"}},
+		{Key: "node.kubernetes.io/instance-type", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"t3.micro"}},
+		{Key: "gpu", Operator: corev1.NodeSelectorOpExists},
+		{Key: "legacy", Operator: corev1.NodeSelectorOpDoesNotExist},
+		{Key: "cpu-count", Operator: corev1.NodeSelectorOpGt, Values: []string{"8"}},
+		{Key: "cpu-count", Operator: corev1.NodeSelectorOpLt, Values: []string{"64"}},
+	}
+	if !reflect.DeepEqual(strategy.Rules[1].NodeAffinity, want) {
+		t.Errorf("NodeAffinity = %v, want %v", strategy.Rules[1].NodeAffinity, want)
+	}
+	if got := strategy.Rules[1].Key(); got != "node-type=spot" {
+		t.Errorf("Expected the selector after the node affinity to be parsed, got %s", got)
+	}
+	formatted := "topology.kubernetes.io/zone:In:us-east-1a|us-east-1b,node.kubernetes.io/instance-type:NotIn:t3.micro,gpu:Exists,legacy:DoesNotExist,cpu-count:Gt:8,cpu-count:Lt:64"
+	if got := FormatNodeAffinity(want); got != formatted {
+		t.Errorf("FormatNodeAffinity() = %s", got)
+	}
+
+	for _, annotation := range []string{
+		"base=1,weight=1,nodeAffinity=zone:In",
+		"base=1,weight=1,nodeAffinity=gpu:Exists:true",
+		"base=1,weight=1,nodeAffinity=cpu-count:Gt:eight",
+		"base=1,weight=1,nodeAffinity=cpu-count:Lt:8|16",
+		"base=1,weight=1,nodeAffinity=zone:Matches:a",
+		"base=1,weight=1,nodeAffinity=:Exists",
+		"base=1,weight=1,nodeAffinity=zone:In:a:b",
+		"base=1,weight=1,nodeAffinity=",
+		// Rules with node affinity need a nodeSelector of their own to count their pods
+		"base=1,weight=1,nodeAffinity=zone:In:a;weight=1,nodeAffinity=zone:In:b",
+		"base=1,weight=1,nodeSelector=node-type:spot,nodeAffinity=zone:In:a;weight=1,nodeSelector=node-type:spot",
+	} {
+		if _, err := ParsePlacementStrategy(annotation); err == nil {
+			t.Errorf("Expected %q to be rejected", annotation)
+		}
+	}
+}
+
+func TestApplyRuleNodeAffinity(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=0,weight=1,nodeSelector=node-type:spot,nodeAffinity=topology.kubernetes.io/zone:In:us-east-1a|us-east-1b,gpu:Exists")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	zones := corev1.NodeSelectorRequirement{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"us-east-1a", "us-east-1b"}}
+	gpu := corev1.NodeSelectorRequirement{Key: "gpu", Operator: corev1.NodeSelectorOpExists}
+	arm := corev1.NodeSelectorRequirement{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}}
+	amd := corev1.NodeSelectorRequirement{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}}
+
+	tests := []struct {
+		name     string
+		affinity *corev1.Affinity
+		want     []corev1.NodeSelectorTerm
+	}{
+		{
+			name: "no affinity",
+			want: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{zones, gpu}}},
+		},
+		{
+			name: "each of the template's terms",
+			affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{arm}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{amd, gpu}},
+				}},
+			}},
+			want: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{arm, zones, gpu}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{amd, gpu, zones}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Affinity: tt.affinity}}
+			if err := ApplyPlacementStrategy(pod, strategy, map[RuleKey]int{}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if pod.Spec.NodeSelector["node-type"] != "spot" {
+				t.Errorf("Expected the nodeSelector applied, got %v", pod.Spec.NodeSelector)
+			}
+			got := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NodeSelectorTerms = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeLabelSelector(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=0,weight=1,nodeSelector=node-type:spot,nodeAffinity=topology.kubernetes.io/zone:NotIn:us-east-1c,cpu-count:Gt:8")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	selector := strategy.Rules[0].NodeLabelSelector()
+
+	tests := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{"node-type": "spot", "topology.kubernetes.io/zone": "us-east-1a", "cpu-count": "16"}, true},
+		{map[string]string{"node-type": "spot", "cpu-count": "16"}, true},
+		{map[string]string{"node-type": "spot", "topology.kubernetes.io/zone": "us-east-1c", "cpu-count": "16"}, false},
+		{map[string]string{"node-type": "spot", "topology.kubernetes.io/zone": "us-east-1a", "cpu-count": "4"}, false},
+		{map[string]string{"node-type": "ondemand", "topology.kubernetes.io/zone": "us-east-1a", "cpu-count": "16"}, false},
+	}
+	for _, tt := range tests {
+		if got := selector.Matches(labels.Set(tt.labels)); got != tt.want {
+			t.Errorf("Matches(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestPercentBase(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=20%,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strategy.BasePercent != 20 {
+		t.Fatalf("Expected base percentage 20, got %d", strategy.BasePercent)
+	}
+
+	for _, tt := range []struct{ replicas, want int }{{0, 0}, {1, 1}, {10, 2}, {23, 5}, {100, 20}} {
+		replicas := int32(tt.replicas)
+		deployment := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &replicas}}
+		resolved, err := ResolveBase(context.Background(), nil, deployment, strategy)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resolved.Base != tt.want {
+			t.Errorf("At %d replicas expected base %d, got %d", tt.replicas, tt.want, resolved.Base)
+		}
+	}
+	if strategy.Base != 0 {
+		t.Errorf("Expected the parsed strategy to be left unchanged, got base %d", strategy.Base)
+	}
+}
+
+func TestDisruptionAllowance(t *testing.T) {
+	intOrPercent := func(value string) *intstr.IntOrString {
+		v := intstr.Parse(value)
+		return &v
+	}
+	rollingUpdate := func(maxUnavailable, maxSurge string) appsv1.DeploymentStrategy {
+		strategy := appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType, RollingUpdate: &appsv1.RollingUpdateDeployment{}}
+		if maxUnavailable != "" {
+			strategy.RollingUpdate.MaxUnavailable = intOrPercent(maxUnavailable)
+		}
+		if maxSurge != "" {
+			strategy.RollingUpdate.MaxSurge = intOrPercent(maxSurge)
+		}
+		return strategy
+	}
+
+	tests := []struct {
+		name               string
+		strategy           appsv1.DeploymentStrategy
+		replicas           int32
+		available          int32
+		wantMaxUnavailable int
+		wantAllowance      int
+	}{
+		{"defaults round down", appsv1.DeploymentStrategy{}, 10, 10, 2, 2},
+		{"pods already unavailable", rollingUpdate("3", "1"), 10, 8, 3, 1},
+		{"rollout used the allowance", rollingUpdate("2", "1"), 10, 7, 2, 0},
+		{"surged pods count as available", rollingUpdate("0", "2"), 4, 5, 0, 1},
+		{"no unavailability declared", rollingUpdate("0", "25%"), 3, 3, 0, 0},
+		{"both zero allow one", rollingUpdate("0", "0"), 5, 5, 1, 1},
+		{"percentage", rollingUpdate("50%", ""), 5, 5, 2, 2},
+		{"recreate", appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}, 5, 2, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas := tt.replicas
+			deployment := &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas, Strategy: tt.strategy},
+				Status: appsv1.DeploymentStatus{AvailableReplicas: tt.available},
+			}
+			if got := MaxUnavailable(deployment); got != tt.wantMaxUnavailable {
+				t.Errorf("MaxUnavailable() = %d, want %d", got, tt.wantMaxUnavailable)
+			}
+			if got := DisruptionAllowance(deployment); got != tt.wantAllowance {
+				t.Errorf("DisruptionAllowance() = %d, want %d", got, tt.wantAllowance)
+			}
+		})
+	}
+}
+
+func TestSpreadConflict(t *testing.T) {
+	zone := "topology.kubernetes.io/zone"
+	node := func(name, nodeType, zoneName string) corev1.Node {
+		nodeLabels := map[string]string{"node-type": nodeType}
+		if zoneName != "" {
+			nodeLabels[zone] = zoneName
+		}
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels}}
+	}
+	nodes := []corev1.Node{
+		node("ondemand-a", "ondemand", "us-west-2a"),
+		node("ondemand-b", "ondemand", "us-west-2b"),
+		node("spot-a", "spot", "us-west-2a"),
+		node("edge", "edge", ""),
+	}
+	ignore := corev1.NodeInclusionPolicyIgnore
+	two := int32(2)
+
+	tests := []struct {
+		name       string
+		constraint corev1.TopologySpreadConstraint
+		selector   string
+		want       map[RuleKey]bool
+	}{
+		{"honored selector narrows the domains", corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule},
+			"", map[RuleKey]bool{"node-type=edge": true}},
+		{"ignored selector balances across every zone", corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule, NodeAffinityPolicy: &ignore},
+			"", map[RuleKey]bool{"node-type=spot": true, "node-type=edge": true}},
+		{"min domains", corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule, MinDomains: &two},
+			"", map[RuleKey]bool{"node-type=spot": true, "node-type=edge": true}},
+		{"pod selector combines with the rule", corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.DoNotSchedule, MinDomains: &two},
+			"us-west-2a", map[RuleKey]bool{"node-type=ondemand": true, "node-type=spot": true}},
+		{"soft constraints never conflict", corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: zone, WhenUnsatisfiable: corev1.ScheduleAnyway, MinDomains: &two},
+			"", map[RuleKey]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot;" +
+				"weight=1,nodeSelector=node-type:edge;weight=1,nodeSelector=node-type:gpu")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{Spec: corev1.PodSpec{TopologySpreadConstraints: []corev1.TopologySpreadConstraint{tt.constraint}}}
+			if tt.selector != "" {
+				pod.Spec.NodeSelector = map[string]string{zone: tt.selector}
+			}
+
+			// The gpu rule matches no node and is never judged
+			for _, rule := range strategy.Rules {
+				got := SpreadConflict(pod, rule, nodes) != ""
+				if got != tt.want[rule.Key()] {
+					t.Errorf("SpreadConflict(%s) = %v, want %v", rule.Key(), got, tt.want[rule.Key()])
+				}
+			}
+
+			filtered, conflicts := AvoidSpreadConflicts(pod, strategy, nodes)
+			if len(conflicts) != len(tt.want) {
+				t.Errorf("AvoidSpreadConflicts() conflicts = %v, want %d", conflicts, len(tt.want))
+			}
+			if len(filtered.Rules) != len(strategy.Rules)-len(tt.want) {
+				t.Errorf("AvoidSpreadConflicts() kept %d rules, want %d", len(filtered.Rules), len(strategy.Rules)-len(tt.want))
+			}
+			if tt.want["node-type=ondemand"] == (filtered.Base != 0) {
+				t.Errorf("AvoidSpreadConflicts() base = %d, want it dropped only with the first rule", filtered.Base)
+			}
+		})
+	}
+}
+
+func TestVolumeConflict(t *testing.T) {
+	zone := "topology.kubernetes.io/zone"
+	node := func(name, nodeType, zoneName string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-type": nodeType, zone: zoneName}}}
+	}
+	nodes := []corev1.Node{
+		node("ondemand-a", "ondemand", "us-west-2a"),
+		node("ondemand-b", "ondemand", "us-west-2b"),
+		node("spot-b", "spot", "us-west-2b"),
+	}
+	inZone := func(zones ...string) *corev1.NodeSelector {
+		return &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: zone, Operator: corev1.NodeSelectorOpIn, Values: zones}},
+		}}}
+	}
+	onNode := func(name string) *corev1.NodeSelector {
+		return &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{name}}},
+		}}}
+	}
+
+	tests := []struct {
+		name       string
+		affinities []*corev1.NodeSelector
+		want       map[RuleKey]bool
+	}{
+		{"no bound volumes", nil, map[RuleKey]bool{}},
+		{"rule nodes in another zone", []*corev1.NodeSelector{inZone("us-west-2a")},
+			map[RuleKey]bool{"node-type=spot": true, "topology.kubernetes.io/zone=us-west-2c": true}},
+		{"rule pinned to another zone", []*corev1.NodeSelector{inZone("us-west-2b")},
+			map[RuleKey]bool{"topology.kubernetes.io/zone=us-west-2a": true, "topology.kubernetes.io/zone=us-west-2c": true}},
+		{"any of the zones", []*corev1.NodeSelector{inZone("us-west-2a", "us-west-2b")},
+			map[RuleKey]bool{"topology.kubernetes.io/zone=us-west-2c": true}},
+		{"one node must mount every volume", []*corev1.NodeSelector{inZone("us-west-2a"), inZone("us-west-2b")},
+			map[RuleKey]bool{"node-type=ondemand": true, "node-type=spot": true, "topology.kubernetes.io/zone=us-west-2a": true, "topology.kubernetes.io/zone=us-west-2c": true}},
+		{"local volume", []*corev1.NodeSelector{onNode("ondemand-b")},
+			map[RuleKey]bool{"node-type=spot": true, "topology.kubernetes.io/zone=us-west-2a": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot;" +
+				"weight=1,nodeSelector=topology.kubernetes.io/zone:us-west-2a;weight=1,nodeSelector=topology.kubernetes.io/zone:us-west-2c")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The us-west-2c rule matches no node and is judged on its nodeSelector alone
+			for _, rule := range strategy.Rules {
+				got := VolumeConflict(&corev1.Pod{}, rule, tt.affinities, nodes)
+				if got != tt.want[rule.Key()] {
+					t.Errorf("VolumeConflict(%s) = %v, want %v", rule.Key(), got, tt.want[rule.Key()])
+				}
+			}
+
+			filtered, conflicts := AvoidVolumeConflicts(&corev1.Pod{}, strategy, tt.affinities, nodes)
+			if len(conflicts) != len(tt.want) {
+				t.Errorf("AvoidVolumeConflicts() conflicts = %v, want %d", conflicts, len(tt.want))
+			}
+			if len(conflicts) < len(strategy.Rules) && len(filtered.Rules) != len(strategy.Rules)-len(tt.want) {
+				t.Errorf("AvoidVolumeConflicts() kept %d rules, want %d", len(filtered.Rules), len(strategy.Rules)-len(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseMultiKeyNodeSelector(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand,zone:us-west-1a;weight=2,nodeSelector=zone:us-west-1b,node-type:spot,anti-affinity=app:web:zone:preferred")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := strategy.Rules[0].Key(); got != "node-type=ondemand,zone=us-west-1a" {
+		t.Errorf("Expected both selector pairs in the first rule, got %s", got)
+	}
+	if got := strategy.Rules[1].Key(); got != "node-type=spot,zone=us-west-1b" {
+		t.Errorf("Expected both selector pairs in the second rule, got %s", got)
+	}
+	if len(strategy.Rules[1].Affinity) != 1 {
+		t.Errorf("Expected the anti-affinity after the selector to be parsed, got %d rules", len(strategy.Rules[1].Affinity))
+	}
+}
+
+func TestCanonicalRuleKey(t *testing.T) {
+	tests := []struct {
+		stored   string
+		expected RuleKey
+	}{
+		{stored: "node-type=spot", expected: "node-type=spot"},
+		{stored: "zone=a,node-type=spot", expected: "node-type=spot,zone=a"},
+		{stored: "[zone=a node-type=spot]", expected: "node-type=spot,zone=a"},
+		{stored: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalRuleKey(tt.stored); got != tt.expected {
+			t.Errorf("CanonicalRuleKey(%q) = %q, expected %q", tt.stored, got, tt.expected)
+		}
+	}
+
+	counts, migrated := migrateRuleKeys(map[RuleKey]int{"zone=a,node-type=spot": 2, "node-type=spot,zone=a": 1})
+	if !migrated || len(counts) != 1 || counts["node-type=spot,zone=a"] != 3 {
+		t.Errorf("Expected legacy keys to merge into one canonical key, got %v", counts)
+	}
+}
+
+func TestStateCircuitBreaker(t *testing.T) {
+	cb := NewStateCircuitBreaker(2, time.Hour, time.Second, logr.Discard())
+	ctx := context.Background()
+	failing := func(context.Context) error { return errors.New("unavailable") }
+	calls := 0
+	counting := func(context.Context) error { calls++; return nil }
+
+	cb.Do(ctx, failing)
+	if cb.Degraded() {
+		t.Fatal("Expected the breaker to stay closed below the failure threshold")
+	}
+	cb.Do(ctx, failing)
+	if !cb.Degraded() {
+		t.Fatal("Expected the breaker to open at the failure threshold")
+	}
+
+	if err := cb.Do(ctx, counting); !errors.Is(err, ErrStateStoreDegraded) || calls != 0 {
+		t.Fatalf("Expected calls to be skipped while degraded, got err=%v calls=%d", err, calls)
+	}
+
+	// Once the cooldown has passed a probe goes through and closes the breaker
+	cb.mu.Lock()
+	cb.openUntil = time.Now().Add(-time.Second)
+	cb.mu.Unlock()
+	if err := cb.Do(ctx, counting); err != nil || calls != 1 {
+		t.Fatalf("Expected the probe to reach the store, got err=%v calls=%d", err, calls)
+	}
+	if cb.Degraded() {
+		t.Error("Expected a successful probe to close the breaker")
+	}
+}
+
+func TestExpectedDistribution(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		totalPods  int
+		expected   map[RuleKey]int
+	}{
+		{
+			name:       "Fewer pods than base",
+			annotation: "base=3,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot",
+			totalPods:  2,
+			expected:   map[RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 0},
+		},
+		{
+			name:       "Base plus weighted share on the first rule",
+			annotation: "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+			totalPods:  9,
+			expected:   map[RuleKey]int{"node-type=ondemand": 3, "node-type=spot": 6},
+		},
+		{
+			name:       "Rounding remainder goes to the rule furthest below its share",
+			annotation: "base=0,weight=1,nodeSelector=zone:a;weight=1,nodeSelector=zone:b;weight=1,nodeSelector=zone:c",
+			totalPods:  5,
+			expected:   map[RuleKey]int{"zone=a": 2, "zone=b": 2, "zone=c": 1},
+		},
+		{
+			name:       "Zero-weight rule gets no pods beyond base",
+			annotation: "base=2,weight=0,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot",
+			totalPods:  5,
+			expected:   map[RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParsePlacementStrategy(tt.annotation)
+			if err != nil {
+				t.Fatalf("Failed to parse strategy: %v", err)
+			}
+			got := ExpectedDistribution(strategy, tt.totalPods)
+			for key, want := range tt.expected {
+				if got[key] != want {
+					t.Errorf("Expected %d pods on %s, got %d (%v)", want, key, got[key], got)
+				}
+			}
+		})
+	}
+}
+
+func TestExpectedCountsAndDrift(t *testing.T) {
+	weighted, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := map[RuleKey]int{"node-type=ondemand": 5, "node-type=spot": 4}
+	expected := ExpectedCounts(weighted, actual, nil)
+	if expected["node-type=ondemand"] != 3 || expected["node-type=spot"] != 6 {
+		t.Errorf("Expected 3 ondemand and 6 spot, got %v", expected)
+	}
+	if drift := DriftPercentage(expected, actual); drift < 44 || drift > 45 {
+		t.Errorf("Expected 4 of 9 pods to drift, got %.1f%%", drift)
+	}
+
+	failover, err := ParsePlacementStrategy("mode=failover,nodeSelector=zone:a;nodeSelector=zone:b;weight=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneAHealthy := func(rule PlacementRule) bool { return rule.NodeSelector["zone"] == "a" }
+	expected = ExpectedCounts(failover, map[RuleKey]int{"zone=a": 1, "zone=b": 3}, zoneAHealthy)
+	if expected["zone=a"] != 4 || expected["zone=b"] != 0 {
+		t.Errorf("Expected every pod back on the first healthy zone, got %v", expected)
+	}
+	if drift := DriftPercentage(map[RuleKey]int{}, actual); drift != 0 {
+		t.Errorf("Expected no drift without expected pods, got %.1f%%", drift)
+	}
+}
+
+func TestScaleDownOrder(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 12 pods should be 4 ondemand and 8 spot; the extra ondemand pods are removed first
+	counts := map[RuleKey]int{"node-type=ondemand": 7, "node-type=spot": 5}
+	order := ScaleDownOrder(strategy, counts)
+	if len(order) == 0 || order[0] != "node-type=ondemand" {
+		t.Fatalf("Expected the first scale-down step to remove an ondemand pod, got %v", order)
+	}
+	if counts["node-type=ondemand"] != 7 {
+		t.Errorf("Expected the counts to be left unchanged, got %v", counts)
+	}
+
+	remaining := map[RuleKey]int{"node-type=ondemand": 7, "node-type=spot": 5}
+	for _, ruleKey := range order {
+		if ruleKey != "node-type=ondemand" {
+			t.Errorf("Expected only over-represented ondemand pods to be removed, got %v", order)
+		}
+		remaining[ruleKey]--
+	}
+	total := remaining["node-type=ondemand"] + remaining["node-type=spot"]
+	expected := ExpectedDistribution(strategy, total)
+	if remaining["node-type=ondemand"] != expected["node-type=ondemand"] || remaining["node-type=spot"] != expected["node-type=spot"] {
+		t.Errorf("Expected the order to end at the distribution for %d pods %v, got %v", total, expected, remaining)
+	}
+
+	if order := ScaleDownOrder(strategy, ExpectedDistribution(strategy, 12)); len(order) != 0 {
+		t.Errorf("Expected no scale-down hints for a balanced deployment, got %v", order)
+	}
+}
+
+func TestUnschedulableSpill(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pendingPod := func(nodeType string, unschedulableFor time.Duration) corev1.Pod {
+		pod := corev1.Pod{
+			Spec:   corev1.PodSpec{NodeSelector: map[string]string{"node-type": nodeType}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending},
+		}
+		if unschedulableFor > 0 {
+			pod.Status.Conditions = []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+				LastTransitionTime: metav1.NewTime(now.Add(-unschedulableFor)),
+			}}
+		}
+		return pod
+	}
+
+	spill := NewUnschedulableSpill(5*time.Minute, logr.Discard())
+	spill.now = func() time.Time { return now }
+
+	// A pod that only just became unschedulable, or is still waiting for the scheduler, is not stuck
+	pods := []corev1.Pod{pendingPod("spot", time.Minute), pendingPod("spot", 0), pendingPod("ondemand", 0)}
+	if spilled, stuck := spill.Spill(pods, strategy); spilled != strategy || len(stuck) != 0 {
+		t.Errorf("Expected no spill before the timeout, got rules %v and stuck %v", spilled.Rules, stuck)
+	}
+
+	pods = append(pods, pendingPod("spot", 10*time.Minute))
+	spilled, stuck := spill.Spill(pods, strategy)
+	if stuck["node-type=spot"] != 1 || len(spilled.Rules) != 1 || spilled.Rules[0].Key() != "node-type=ondemand" {
+		t.Errorf("Expected pods to spill to ondemand, got rules %v and stuck %v", spilled.Rules, stuck)
+	}
+	if spilled.Base != 1 {
+		t.Errorf("Expected the base to stay with the first rule, got %d", spilled.Base)
+	}
+
+	// With every rule stuck the strategy is kept whole; dropping the first rule drops its base
+	pods = append(pods, pendingPod("ondemand", 10*time.Minute))
+	if spilled, _ := spill.Spill(pods, strategy); spilled != strategy {
+		t.Errorf("Expected the strategy unchanged when every rule is stuck, got %v", spilled.Rules)
+	}
+	onDemandOnly := []corev1.Pod{pendingPod("ondemand", 10*time.Minute)}
+	if spilled, _ := spill.Spill(onDemandOnly, strategy); spilled.Base != 0 || spilled.Rules[0].Key() != "node-type=spot" {
+		t.Errorf("Expected the base dropped with the first rule, got base %d rules %v", spilled.Base, spilled.Rules)
+	}
+
+	var disabled *UnschedulableSpill
+	if spilled, stuck := disabled.Spill(pods, strategy); spilled != strategy || stuck != nil {
+		t.Errorf("Expected a nil spill to keep the strategy, got %v", spilled.Rules)
+	}
+}
+
+func TestExpectedDistributionMatchesWebhookPlacement(t *testing.T) {
+	annotations := []string{
+		"base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot",
+		"base=0,weight=1,nodeSelector=zone:a;weight=1,nodeSelector=zone:b;weight=1,nodeSelector=zone:c",
+		"base=3,weight=5,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot;weight=2,nodeSelector=node-type:arm",
+		"base=2,weight=7,nodeSelector=zone:a;weight=1,nodeSelector=zone:b",
+		"base=0,weight=1,nodeSelector=zone:a;weight=100,nodeSelector=zone:b",
+	}
+
+	for _, annotation := range annotations {
+		strategy, err := ParsePlacementStrategy(annotation)
+		if err != nil {
+			t.Fatalf("Failed to parse strategy %q: %v", annotation, err)
+		}
+		totalWeight := 0
+		for _, rule := range strategy.Rules {
+			totalWeight += rule.Weight
+		}
+
+		counts := ExpectedDistribution(strategy, 0)
+		previous := counts
+		for total := 1; total <= 200; total++ {
+			// The webhook places one more pod on top of the previous counts
+			pod := &corev1.Pod{}
+			if err := ApplyPlacementStrategy(pod, strategy, counts); err != nil {
+				t.Fatalf("%q: failed to place pod %d: %v", annotation, total, err)
+			}
+			placed := make(map[RuleKey]int, len(counts))
+			for key, count := range counts {
+				placed[key] = count
+			}
+			placed[NodeSelectorKey(pod.Spec.NodeSelector)]++
+
+			expected := ExpectedDistribution(strategy, total)
+			sum := 0
+			for i, rule := range strategy.Rules {
+				key := rule.Key()
+				sum += expected[key]
+				if placed[key] != expected[key] {
+					t.Fatalf("%q with %d pods: webhook placed %v, expected %v", annotation, total, placed, expected)
+				}
+				if expected[key] < previous[key] {
+					t.Fatalf("%q: expected count for %s dropped from %d to %d at %d pods", annotation, key, previous[key], expected[key], total)
+				}
+
+				// Every rule stays within one pod of its exact share of the pods beyond base
+				beyondBase := total - strategy.Base
+				if beyondBase < 0 {
+					beyondBase = 0
+				}
+				count := expected[key]
+				if i == 0 {
+					count -= total - beyondBase
+				}
+				exact := float64(beyondBase) * float64(rule.Weight) / float64(totalWeight)
+				if float64(count) <= exact-1 || float64(count) >= exact+1 {
+					t.Fatalf("%q with %d pods: %s holds %d beyond base, exact share is %.2f", annotation, total, key, count, exact)
+				}
+			}
+			if sum != total {
+				t.Fatalf("%q: expected distribution holds %d pods, want %d", annotation, sum, total)
+			}
+
+			previous, counts = expected, placed
+		}
+	}
+}
+
+func TestExpectedDistributionSumsToTotal(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=2,weight=3,nodeSelector=zone:a;weight=3,nodeSelector=zone:b;weight=3,nodeSelector=zone:c")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	// Truncating each rule's share would lose up to one pod per rule at these totals
+	for _, total := range []int{0, 1, 2, 4, 10, 101, 1000, 10007} {
+		sum := 0
+		for _, count := range ExpectedDistribution(strategy, total) {
+			sum += count
+		}
+		if sum != total {
+			t.Errorf("Expected counts for %d pods sum to %d", total, sum)
+		}
+	}
+}
+
+func TestForecastRule(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=2,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		counts   map[RuleKey]int
+		replicas int
+		want     int
+		wantOk   bool
+	}{
+		{"empty deployment starts with the base", map[RuleKey]int{}, 10, 0, true},
+		{"rule furthest below its planned count", map[RuleKey]int{"node-type=ondemand": 4, "node-type=spot": 1}, 10, 1, true},
+		{"scaling back up refills the base rule", map[RuleKey]int{"node-type=spot": 6}, 10, 0, true},
+		{"pods on other rules shrink the plan", map[RuleKey]int{"node-type=ondemand": 2, "node-type=spot": 1, "zone=a": 2}, 5, 0, false},
+		{"plan complete", map[RuleKey]int{"node-type=ondemand": 4, "node-type=spot": 6}, 10, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ForecastRule(strategy, tt.counts, tt.replicas)
+			if ok != tt.wantOk || ok && got != tt.want {
+				t.Errorf("ForecastRule() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	// Whatever the pods already placed, filling the plan ends at the expected distribution
+	for _, start := range []map[RuleKey]int{{}, {"node-type=spot": 6}, {"node-type=ondemand": 3}, {"node-type=ondemand": 1, "node-type=spot": 2}} {
+		counts := make(map[RuleKey]int)
+		placed := 0
+		for key, count := range start {
+			counts[key] = count
+			placed += count
+		}
+		for ; placed < 10; placed++ {
+			pod := &corev1.Pod{}
+			if err := ApplyForecastStrategy(pod, strategy, counts, 10); err != nil {
+				t.Fatal(err)
+			}
+			counts[NodeSelectorKey(pod.Spec.NodeSelector)]++
+		}
+		if want := ExpectedDistribution(strategy, 10); counts["node-type=ondemand"] != want["node-type=ondemand"] || counts["node-type=spot"] != want["node-type=spot"] {
+			t.Errorf("Starting from %v, filled to %v, want %v", start, counts, want)
+		}
+	}
+}
+
+func TestMatchRuleKeyPrecedence(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=0,weight=1;weight=1,nodeSelector=zone:a;weight=1,nodeSelector=zone:a,node-type:spot;weight=1,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		nodeSelector map[string]string
+		expected     RuleKey
+	}{
+		{"Most specific selector wins", map[string]string{"zone": "a", "node-type": "spot"}, "node-type=spot,zone=a"},
+		{"Equally specific selectors go to the earlier rule", map[string]string{"zone": "b", "node-type": "spot"}, "node-type=spot"},
+		{"Selector-less rule only gets unmatched pods", map[string]string{"zone": "b"}, ""},
+		{"Single-pair match", map[string]string{"zone": "a"}, "zone=a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Counting must not depend on how many times or in what order it runs
+			for i := 0; i < 10; i++ {
+				got, ok := MatchRuleKey(strategy, tt.nodeSelector)
+				if !ok || got != tt.expected {
+					t.Fatalf("Expected %q, got %q (ok=%v)", tt.expected, got, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestRuleKeyNodeSelector(t *testing.T) {
+	nodeSelector := map[string]string{"zone": "a", "node-type": "spot"}
+	got, ok := NodeSelectorKey(nodeSelector).NodeSelector()
+	if !ok || len(got) != 2 || got["zone"] != "a" || got["node-type"] != "spot" {
+		t.Errorf("Expected the key to round-trip to %v, got %v (ok=%v)", nodeSelector, got, ok)
+	}
+
+	if _, ok := RuleKey("not-a-selector").NodeSelector(); ok {
+		t.Error("Expected a malformed key to be rejected")
+	}
+}
+
+func TestNamespaceGuard(t *testing.T) {
+	guard, err := NewNamespaceGuard("smart-scheduler-system", []string{"cert-manager", " team-*-prod", ""})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		namespace string
+		expected  bool
+	}{
+		{"kube-system", true},
+		{"kube-node-lease", true},
+		{"smart-scheduler-system", true},
+		{"cert-manager", true},
+		{"team-payments-prod", true},
+		{"team-payments-staging", false},
+		{"default", false},
+	}
+
+	for _, tt := range tests {
+		if got := guard.Protected(tt.namespace); got != tt.expected {
+			t.Errorf("Protected(%q): expected %v, got %v", tt.namespace, tt.expected, got)
+		}
+	}
+
+	var unset *NamespaceGuard
+	if !unset.Protected("kube-system") || unset.Protected("default") {
+		t.Error("Expected a nil guard to protect system namespaces only")
+	}
+
+	if _, err := NewNamespaceGuard("", []string{"team-["}); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestRuleLabelValue(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	tests := []struct {
+		key  RuleKey
+		want string
+	}{
+		{"node-type=ondemand", "ondemand"},
+		{"topology.kubernetes.io/zone=us-west-2a,node-type=ondemand", "ondemand.us-west-2a"},
+		{"node-type=spot,gpu=", "spot"},
+		{"", ""},
+		{"[unparseable", ""},
+	}
+	for _, tt := range tests {
+		if got := RuleLabelValue(tt.key); got != tt.want {
+			t.Errorf("RuleLabelValue(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	// Too long for a label: shortened, still valid and distinct
+	first := RuleLabelValue(RuleKey("a=" + long + ",b=" + long + "-1"))
+	second := RuleLabelValue(RuleKey("a=" + long + ",b=" + long + "-2"))
+	for _, value := range []string{first, second} {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			t.Errorf("RuleLabelValue() = %q is not a valid label value: %v", value, errs)
+		}
+	}
+	if first == second {
+		t.Errorf("Expected shortened values of different rules to differ, both are %q", first)
+	}
+}
+
+func TestParseExperiment(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "valid", data: `{"name":"more-spot","percentage":20,"strategy":"base=0,weight=1,nodeSelector=node-type:spot"}`},
+		{name: "invalid JSON", data: `{"name":`, wantErr: true},
+		{name: "missing name", data: `{"percentage":20,"strategy":"weight=1,nodeSelector=node-type:spot"}`, wantErr: true},
+		{name: "zero percentage", data: `{"name":"x","percentage":0,"strategy":"weight=1,nodeSelector=node-type:spot"}`, wantErr: true},
+		{name: "full percentage", data: `{"name":"x","percentage":100,"strategy":"weight=1,nodeSelector=node-type:spot"}`, wantErr: true},
+		{name: "invalid strategy", data: `{"name":"x","percentage":50,"strategy":"weight=abc"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			experiment, err := ParseExperiment(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", experiment)
+				} else if !errors.Is(err, ErrStrategyInvalid) {
+					t.Errorf("Expected a strategy_invalid error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if experiment.Name != "more-spot" || experiment.Percentage != 20 {
+				t.Errorf("Unexpected experiment %+v", experiment)
+			}
+		})
+	}
+}
+
+func TestChooseArm(t *testing.T) {
+	experiment := &Experiment{Name: "more-spot", Percentage: 20}
+
+	// Admitting pods one by one keeps the candidate arm at the experiment's percentage
+	candidates := 0
+	for total := 0; total < 100; total++ {
+		if ChooseArm(experiment, candidates, total) == ArmCandidate {
+			candidates++
+		}
+	}
+	if candidates != 20 {
+		t.Errorf("Expected 20 of 100 pods in the candidate arm, got %d", candidates)
+	}
+
+	if arm := ChooseArm(experiment, 5, 10); arm != ArmControl {
+		t.Errorf("Expected an over-represented candidate arm to get no pod, got %s", arm)
+	}
+	if arm := ChooseArm(experiment, 0, 10); arm != ArmCandidate {
+		t.Errorf("Expected an under-represented candidate arm to get the pod, got %s", arm)
+	}
+}
+
+func TestPropagationApply(t *testing.T) {
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			Spec: corev1.PodSpec{
+				NodeSelector:   map[string]string{"node-type": "spot"},
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers: []corev1.Container{
+					{Name: "app", Env: []corev1.EnvVar{{Name: CapacityTypeEnv, Value: "custom"}}},
+					{Name: "istio-proxy"},
+				},
+			},
+		}
+	}
+
+	propagation, err := ParsePropagation(`{"env":true,"annotations":true,"labels":true,"excludeContainers":["istio-proxy"]}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pod := newPod()
+	propagation.Apply(pod, RuleKey("node-type=spot"))
+
+	if got := pod.Annotations[CapacityTypeAnnotation]; got != "spot" {
+		t.Errorf("Expected capacity-type annotation spot, got %q", got)
+	}
+	if got := pod.Labels[RuleLabel]; got != "spot" {
+		t.Errorf("Expected rule label spot, got %q", got)
+	}
+	if got := pod.Spec.InitContainers[0].Env; len(got) != 2 || got[0].Value != "spot" || got[1].Value != "node-type=spot" {
+		t.Errorf("Expected both variables on the init container, got %+v", got)
+	}
+	if got := pod.Spec.Containers[0].Env; len(got) != 2 || got[0].Value != "custom" || got[1].Name != PlacementRuleEnv {
+		t.Errorf("Expected the container's own capacity type to be kept, got %+v", got)
+	}
+	if got := pod.Spec.Containers[1].Env; len(got) != 0 {
+		t.Errorf("Expected the excluded container to be left alone, got %+v", got)
+	}
+
+	// A capacityTypeLabel the nodeSelector does not set yields no capacity type
+	propagation = &Propagation{Annotations: true, CapacityTypeLabel: "karpenter.sh/capacity-type"}
+	pod = newPod()
+	propagation.Apply(pod, RuleKey("node-type=spot"))
+	if _, ok := pod.Annotations[CapacityTypeAnnotation]; ok {
+		t.Errorf("Expected no capacity-type annotation, got %v", pod.Annotations)
+	}
+	if _, ok := pod.Labels[RuleLabel]; ok {
+		t.Errorf("Expected no rule label without labels, got %v", pod.Labels)
+	}
+
+	if _, err := ParsePropagation(`{"env":`); !errors.Is(err, ErrStrategyInvalid) {
+		t.Errorf("Expected a strategy_invalid error, got %v", err)
+	}
+}
+
+func TestLintPlacementStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		replicas int
+		want     []string
+	}{
+		{"sound strategy", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", 4, nil},
+		{"zero total weight", "base=1,weight=0,nodeSelector=node-type:ondemand;weight=0,nodeSelector=node-type:spot", 4, []string{LintZeroWeight}},
+		{"base above replicas", "base=5,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot", 3, []string{LintBaseExceedsReplicas}},
+		{"scaled to zero", "base=5,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot", 0, nil},
+		{"rules on the same selector", "base=1,weight=1,nodeSelector=node-type:spot;weight=2,nodeSelector=node-type:spot", 4, []string{LintSameSelector}},
+		{"single rule", "base=0,weight=1,nodeSelector=node-type:spot", 4, nil},
+		{"failover ignores weights and base", "mode=failover,nodeSelector=zone:zone-a;nodeSelector=zone:zone-b", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParsePlacementStrategy(tt.strategy)
+			if err != nil {
+				t.Fatalf("Failed to parse strategy: %v", err)
+			}
+			var got []string
+			for _, lint := range LintPlacementStrategy(strategy, tt.replicas) {
+				got = append(got, lint.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LintPlacementStrategy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinOnDemand(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("weight=1,nodeSelector=node-type:ondemand;weight=1,preemptible=true,nodeSelector=zone:a;weight=8,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+	onDemand, preemptible, spot := strategy.Rules[0].Key(), strategy.Rules[1].Key(), strategy.Rules[2].Key()
+
+	for _, tt := range []struct {
+		annotation string
+		want       int
+		wantErr    bool
+	}{
+		{"", 0, false}, {"3", 3, false}, {"0", 0, false}, {"-1", 0, true}, {"three", 0, true},
+	} {
+		got, err := ParseMinOnDemand(tt.annotation)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMinOnDemand(%q) = %d, %v, want %d, error %v", tt.annotation, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// Below the floor only the on-demand rule is left, at the floor the strategy is unchanged
+	held, ok := HoldMinOnDemand(strategy, map[RuleKey]int{onDemand: 2, spot: 10}, 3)
+	if !ok || len(held.Rules) != 1 || held.Rules[0].Key() != onDemand {
+		t.Errorf("HoldMinOnDemand() below the floor = %v, %v, want only %s", held.Rules, ok, onDemand)
+	}
+	if held, ok := HoldMinOnDemand(strategy, map[RuleKey]int{onDemand: 3, spot: 10}, 3); ok || held != strategy {
+		t.Errorf("HoldMinOnDemand() at the floor = %v, %v, want the strategy unchanged", held.Rules, ok)
+	}
+	spotOnly, err := ParsePlacementStrategy("weight=1,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+	if held, ok := HoldMinOnDemand(spotOnly, nil, 3); ok || held != spotOnly {
+		t.Errorf("HoldMinOnDemand() without on-demand rules = %v, %v, want the strategy unchanged", held.Rules, ok)
+	}
+
+	tests := []struct {
+		name     string
+		expected map[RuleKey]int
+		floor    int
+		want     map[RuleKey]int
+	}{
+		{"floor held", map[RuleKey]int{onDemand: 3, preemptible: 1, spot: 6}, 3, map[RuleKey]int{onDemand: 3, preemptible: 1, spot: 6}},
+		{"taken from the last rule", map[RuleKey]int{onDemand: 1, preemptible: 1, spot: 8}, 4, map[RuleKey]int{onDemand: 4, preemptible: 1, spot: 5}},
+		{"taken from every other rule", map[RuleKey]int{onDemand: 0, preemptible: 1, spot: 1}, 3, map[RuleKey]int{onDemand: 2, preemptible: 0, spot: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpectedMinOnDemand(strategy, tt.expected, tt.floor); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpectedMinOnDemand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatefulSetOrdinals(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=node-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+
+	// kafka-0 fills the base, then the weights come round
+	var got []int
+	for ordinal := 0; ordinal < 4; ordinal++ {
+		got = append(got, OrdinalRule(strategy, ordinal))
+	}
+	if want := []int{0, 1, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OrdinalRule() for ordinals 0-3 = %v, want %v", got, want)
+	}
+
+	// Ordinals 0 to n-1 always hold the expected distribution of n pods
+	counts := map[RuleKey]int{strategy.Rules[0].Key(): 0, strategy.Rules[1].Key(): 0}
+	for n := 1; n <= 20; n++ {
+		counts[strategy.Rules[OrdinalRule(strategy, n-1)].Key()]++
+		if want := ExpectedDistribution(strategy, n); !reflect.DeepEqual(counts, want) {
+			t.Fatalf("Rules of ordinals below %d = %v, want %v", n, counts, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		labels map[string]string
+		want   int
+		wantOk bool
+	}{
+		{"kafka-3", map[string]string{appsv1.PodIndexLabel: "7"}, 7, true},
+		{"kafka-3", nil, 3, true},
+		{"kafka-x", nil, 0, false},
+		{"other-3", nil, 0, false},
+	} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: tt.name, Labels: tt.labels}}
+		if got, ok := StatefulSetOrdinal(pod, "kafka"); got != tt.want || ok != tt.wantOk {
+			t.Errorf("StatefulSetOrdinal(%s, %v) = %d, %v, want %d, %v", tt.name, tt.labels, got, ok, tt.want, tt.wantOk)
+		}
+	}
+
+	replicas := int32(3)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kafka", Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:       &replicas,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+		},
+		Status: appsv1.StatefulSetStatus{Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 3},
+	}
+	workload := StatefulSetWorkload(sts)
+	if !IsStatefulSet(workload) || WorkloadKind(workload) != WorkloadStatefulSet {
+		t.Errorf("StatefulSetWorkload() kind = %q, want %s", workload.Kind, WorkloadStatefulSet)
+	}
+	if workload.Status.UpdatedReplicas != 3 {
+		t.Errorf("OnDelete StatefulSet updated replicas = %d, want 3", workload.Status.UpdatedReplicas)
+	}
+	if got := MaxUnavailable(workload); got != 1 {
+		t.Errorf("MaxUnavailable() = %d, want 1", got)
+	}
+}
+
+func TestParseMigration(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotation   string
+		replicas     int32
+		wantInterval time.Duration
+		wantPods     int
+		wantErr      bool
+	}{
+		{"ten percent per hour", `{"stepPercent":10,"interval":"1h"}`, 20, time.Hour, 2, false},
+		{"rounds up", `{"stepPercent":10,"interval":"30m"}`, 15, 30 * time.Minute, 2, false},
+		{"at least one pod", `{"stepPercent":1}`, 3, DefaultMigrationInterval, 1, false},
+		{"whole deployment", `{"stepPercent":100,"interval":"5m"}`, 7, 5 * time.Minute, 7, false},
+		{"step missing", `{"interval":"1h"}`, 10, 0, 0, true},
+		{"step above 100", `{"stepPercent":150}`, 10, 0, 0, true},
+		{"negative interval", `{"stepPercent":10,"interval":"-1h"}`, 10, 0, 0, true},
+		{"invalid JSON", `{"stepPercent":`, 10, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migration, err := ParseMigration(tt.annotation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMigration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrStrategyInvalid) {
+					t.Errorf("error = %v, want ErrStrategyInvalid", err)
+				}
+				return
+			}
+			if got := migration.StepInterval(); got != tt.wantInterval {
+				t.Errorf("StepInterval() = %v, want %v", got, tt.wantInterval)
+			}
+			deployment := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &tt.replicas}}
+			if got := migration.StepPods(deployment); got != tt.wantPods {
+				t.Errorf("StepPods() = %d, want %d", got, tt.wantPods)
+			}
+		})
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenStrategies are representative strategies whose distributions are kept in
+// testdata/distributions.golden
+var goldenStrategies = []string{
+	"base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot",
+	"base=0,weight=1,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot",
+	"base=2,weight=1,nodeSelector=zone:a;weight=2,nodeSelector=zone:b;weight=2,nodeSelector=zone:c",
+	"base=3,weight=0,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot",
+	"base=0,weight=5,nodeSelector=node-type:spot,zone:a;weight=3,nodeSelector=node-type:spot,zone:b;weight=1,nodeSelector=node-type:ondemand",
+	"base=4,weight=2,nodeSelector=node-type:ondemand;weight=1,nodeSelector=node-type:spot;weight=1,nodeSelector=node-type:reserved",
+}
+
+// renderDistributions describes, for each golden strategy, the rules pods are admitted to one at a
+// time, the expected and forecast distributions at several replica counts, and the rules a
+// scale-down of pods crowded onto the last rule removes pods from. Rules are numbered in strategy
+// order.
+func renderDistributions(t *testing.T) string {
+	t.Helper()
+	var out strings.Builder
+	for _, annotation := range goldenStrategies {
+		strategy, err := ParsePlacementStrategy(annotation)
+		if err != nil {
+			t.Fatalf("Failed to parse strategy %q: %v", annotation, err)
+		}
+		index := make(map[RuleKey]int, len(strategy.Rules))
+		fmt.Fprintf(&out, "strategy %s\n", annotation)
+		for i, rule := range strategy.Rules {
+			index[rule.Key()] = i
+			fmt.Fprintf(&out, "  rule %d: %s\n", i, rule.Key())
+		}
+
+		// ruleOf returns the number of the rule the pod was placed by
+		ruleOf := func(pod *corev1.Pod) int {
+			key, ok := MatchRuleKey(strategy, pod.Spec.NodeSelector)
+			if !ok {
+				t.Fatalf("Pod placed outside strategy %q: %v", annotation, pod.Spec.NodeSelector)
+			}
+			return index[key]
+		}
+
+		counts := make(map[RuleKey]int)
+		admitted := make([]string, 0, 20)
+		for n := 0; n < 20; n++ {
+			pod := &corev1.Pod{}
+			if err := ApplyPlacementStrategy(pod, strategy, counts); err != nil {
+				t.Fatalf("ApplyPlacementStrategy() error = %v", err)
+			}
+			i := ruleOf(pod)
+			counts[strategy.Rules[i].Key()]++
+			admitted = append(admitted, fmt.Sprint(i))
+		}
+		fmt.Fprintf(&out, "  admitted: %s\n", strings.Join(admitted, " "))
+
+		for _, replicas := range []int{1, 2, 3, 5, 8, 10, 16, 25} {
+			expected := ExpectedDistribution(strategy, replicas)
+			perRule := make([]string, len(strategy.Rules))
+			for i, rule := range strategy.Rules {
+				perRule[i] = fmt.Sprint(expected[rule.Key()])
+			}
+
+			counts := make(map[RuleKey]int)
+			forecast := make([]string, 0, replicas)
+			for n := 0; n < replicas; n++ {
+				pod := &corev1.Pod{}
+				if err := ApplyForecastStrategy(pod, strategy, counts, replicas); err != nil {
+					t.Fatalf("ApplyForecastStrategy() error = %v", err)
+				}
+				i := ruleOf(pod)
+				counts[strategy.Rules[i].Key()]++
+				forecast = append(forecast, fmt.Sprint(i))
+			}
+			fmt.Fprintf(&out, "  replicas %d: expected %s forecast %s\n", replicas, strings.Join(perRule, " "), strings.Join(forecast, " "))
+		}
+
+		last := len(strategy.Rules) - 1
+		removed := []string{}
+		for _, key := range ScaleDownOrder(strategy, map[RuleKey]int{strategy.Rules[0].Key(): 2, strategy.Rules[last].Key(): 10}) {
+			removed = append(removed, fmt.Sprint(index[key]))
+		}
+		fmt.Fprintf(&out, "  scale-down from 2 on rule 0 and 10 on rule %d: %s\n", last, strings.Join(removed, " "))
+	}
+	return out.String()
+}
+
+// largeStrategy returns a strategy with a rule per zone and capacity type
+func largeStrategy(zones int, capacityTypes ...string) string {
+	rules := make([]string, 0, zones*len(capacityTypes))
+	for zone := 0; zone < zones; zone++ {
+		for i, capacityType := range capacityTypes {
+			rules = append(rules, fmt.Sprintf("weight=%d,nodeSelector=zone:zone-%d,capacity-type:%s", i+1, zone, capacityType))
+		}
+	}
+	return "base=1," + strings.Join(rules, ";")
+}
+
+func TestLargeStrategy(t *testing.T) {
+	if _, err := ParsePlacementStrategy(largeStrategy(MaxRules/2+1, "spot", "ondemand")); !errors.Is(err, ErrStrategyInvalid) {
+		t.Errorf("Expected a strategy with more than %d rules to be invalid, got %v", MaxRules, err)
+	}
+	strategy, err := ParsePlacementStrategy(largeStrategy(MaxRules/4, "spot", "ondemand", "reserved", "savings-plan"))
+	if err != nil {
+		t.Fatalf("Expected a strategy with %d rules to parse, got %v", MaxRules, err)
+	}
+	if len(strategy.Rules) != MaxRules {
+		t.Fatalf("Expected %d rules, got %d", MaxRules, len(strategy.Rules))
+	}
+	// A zone-wide rule overlaps the zone's capacity type rules, which are more specific
+	strategy.Rules = append(strategy.Rules, PlacementRule{Weight: 1, NodeSelector: map[string]string{"zone": "zone-0"}})
+
+	selectors := []map[string]string{
+		nil,
+		{"zone": "zone-3", "capacity-type": "spot"},
+		{"zone": "zone-3", "capacity-type": "spot", "arch": "arm64"},
+		{"zone": "zone-0"},
+		{"zone": "zone-0", "capacity-type": "gpu"},
+		{"zone": "zone-99", "capacity-type": "spot"},
+		{"capacity-type": "reserved"},
+	}
+	matcher := NewRuleMatcher(strategy)
+	for round := 0; round < 2; round++ {
+		for _, selector := range selectors {
+			wantKey, wantOK := MatchRuleKey(strategy, selector)
+			if key, ok := matcher.Match(selector); key != wantKey || ok != wantOK {
+				t.Errorf("Match(%v) = %q, %v, want %q, %v", selector, key, ok, wantKey, wantOK)
+			}
+		}
+	}
+
+	pods := make([]corev1.Pod, 0, len(selectors))
+	for _, selector := range selectors {
+		pods = append(pods, corev1.Pod{Spec: corev1.PodSpec{NodeSelector: selector}, Status: corev1.PodStatus{Phase: corev1.PodRunning}})
+	}
+	counts := CountPodsByRule(pods, strategy)
+	if len(counts) != MaxRules+1 {
+		t.Errorf("Expected a count for each of the %d rules, got %d", MaxRules+1, len(counts))
+	}
+	if counts["capacity-type=spot,zone=zone-3"] != 2 || counts["zone=zone-0"] != 2 {
+		t.Errorf("Expected pods matched to the most specific rules, got %v", counts)
+	}
+}
+
+func BenchmarkCountPodsByRuleLargeStrategy(b *testing.B) {
+	strategy, err := ParsePlacementStrategy(largeStrategy(MaxRules/4, "spot", "ondemand", "reserved", "savings-plan"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	pods := make([]corev1.Pod, 5000)
+	for i := range pods {
+		rule := strategy.Rules[i%len(strategy.Rules)]
+		pods[i] = corev1.Pod{Spec: corev1.PodSpec{NodeSelector: rule.NodeSelector}, Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CountPodsByRule(pods, strategy)
+	}
+}
+
+func TestDistributionsGolden(t *testing.T) {
+	got := renderDistributions(t)
+	// Nothing may depend on map iteration order or the time
+	if again := renderDistributions(t); again != got {
+		t.Fatalf("Distributions differ between runs:\n%s\n---\n%s", got, again)
+	}
+
+	path := filepath.Join("testdata", "distributions.golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file, run with -update to create it: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Distributions differ from %s, run with -update if the change is intended:\n%s", path, got)
+	}
+}
+
+func TestDetectInstalledAPIs(t *testing.T) {
+	tests := []struct {
+		name           string
+		resources      []*metav1.APIResourceList
+		wantMissing    []string
+		annotationOnly bool
+	}{
+		{
+			name: "all CRDs installed",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "smartscheduler.io/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "podplacementpolicies", Kind: KindPodPlacementPolicy},
+					{Name: "podplacementpolicies/status", Kind: KindPodPlacementPolicy},
+					{Name: "maintenancewindows", Kind: KindMaintenanceWindow},
+					{Name: "placementsummaries", Kind: KindPlacementSummary},
+				},
+			}},
+		},
+		{
+			name: "only a subresource of the policy kind",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "smartscheduler.io/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "podplacementpolicies/status", Kind: KindPodPlacementPolicy},
+					{Name: "maintenancewindows", Kind: KindMaintenanceWindow},
+					{Name: "placementsummaries", Kind: KindPlacementSummary},
+				},
+			}},
+			wantMissing:    []string{KindPodPlacementPolicy},
+			annotationOnly: true,
+		},
+		{
+			name:           "group not served",
+			wantMissing:    []string{KindPodPlacementPolicy, KindMaintenanceWindow, KindPlacementSummary},
+			annotationOnly: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apis, err := DetectInstalledAPIs(&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: tt.resources}})
+			if err != nil {
+				t.Fatalf("DetectInstalledAPIs() error = %v", err)
+			}
+			if got := apis.Missing(); !reflect.DeepEqual(got, tt.wantMissing) {
+				t.Errorf("Missing() = %v, want %v", got, tt.wantMissing)
+			}
+			if got := apis.AnnotationOnly(); got != tt.annotationOnly {
+				t.Errorf("AnnotationOnly() = %v, want %v", got, tt.annotationOnly)
+			}
+		})
+	}
+
+	var unknown *InstalledAPIs
+	if !unknown.Installed(KindPodPlacementPolicy) || unknown.AnnotationOnly() {
+		t.Error("Expected a nil InstalledAPIs to report every kind installed")
+	}
+
+	// Without the MaintenanceWindow CRD no window is listed, so placements never fail on it
+	tracker := &MaintenanceTracker{Log: logr.Discard(), APIs: &InstalledAPIs{}}
+	if windows, err := tracker.ActiveWindows(context.Background()); err != nil || windows != nil {
+		t.Errorf("ActiveWindows() = %v, %v, want none", windows, err)
+	}
+}
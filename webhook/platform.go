@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ImageOSAnnotation declares the operating system the pod's images are built for.
+// It is only consulted when the pod does not set spec.os.
+const ImageOSAnnotation = "smart-scheduler.io/image-os"
+
+// ImageArchAnnotation declares the comma-separated CPU architectures the pod's images are built for,
+// e.g. "amd64" or "amd64,arm64".
+const ImageArchAnnotation = "smart-scheduler.io/image-arch"
+
+// supportedOperatingSystems lists the values accepted for the kubernetes.io/os node label
+var supportedOperatingSystems = map[string]bool{
+	string(corev1.Linux):   true,
+	string(corev1.Windows): true,
+}
+
+// Platform describes the operating system and CPU architectures a pod can run on.
+// Empty fields mean the pod places no constraint on that dimension.
+type Platform struct {
+	OS            string
+	Architectures []string
+}
+
+// validatePlatformSelector rejects rules that pin an operating system the cluster cannot run
+func validatePlatformSelector(nodeSelector map[string]string) error {
+	if os, ok := nodeSelector[corev1.LabelOSStable]; ok && !supportedOperatingSystems[os] {
+		return fmt.Errorf("unsupported %s value, must be 'linux' or 'windows': %s", corev1.LabelOSStable, os)
+	}
+	if arch, ok := nodeSelector[corev1.LabelArchStable]; ok && strings.Contains(arch, "/") {
+		return fmt.Errorf("invalid %s value, expected an architecture such as 'amd64' or 'arm64': %s", corev1.LabelArchStable, arch)
+	}
+	return nil
+}
+
+// PodPlatform returns the platform a pod declares.
+// spec.os takes precedence over the image-os annotation, which takes precedence over an existing nodeSelector.
+// Architectures come from the image-arch annotation, falling back to an existing nodeSelector.
+func PodPlatform(pod *corev1.Pod) Platform {
+	platform := Platform{}
+
+	if pod.Spec.OS != nil && pod.Spec.OS.Name != "" {
+		platform.OS = string(pod.Spec.OS.Name)
+	} else if os := strings.TrimSpace(pod.Annotations[ImageOSAnnotation]); os != "" {
+		platform.OS = strings.ToLower(os)
+	} else {
+		platform.OS = pod.Spec.NodeSelector[corev1.LabelOSStable]
+	}
+
+	if archs := parseArchitectures(pod.Annotations[ImageArchAnnotation]); len(archs) > 0 {
+		platform.Architectures = archs
+	} else if arch := pod.Spec.NodeSelector[corev1.LabelArchStable]; arch != "" {
+		platform.Architectures = []string{arch}
+	}
+
+	return platform
+}
+
+// parseArchitectures splits a comma-separated architecture list, dropping empty entries
+func parseArchitectures(value string) []string {
+	var archs []string
+	for _, arch := range strings.Split(value, ",") {
+		arch = strings.ToLower(strings.TrimSpace(arch))
+		if arch != "" {
+			archs = append(archs, arch)
+		}
+	}
+	return archs
+}
+
+// SupportsArchitecture reports whether the platform can run on the given architecture
+func (p Platform) SupportsArchitecture(arch string) bool {
+	if len(p.Architectures) == 0 {
+		return true
+	}
+	for _, supported := range p.Architectures {
+		if supported == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRuleCompatible reports whether the nodes selected by the rule can run a pod on this platform.
+// A rule that does not pin an operating system or architecture is compatible with every pod.
+func (p Platform) IsRuleCompatible(rule PlacementRule) bool {
+	if ruleOS, ok := rule.NodeSelector[corev1.LabelOSStable]; ok && p.OS != "" && p.OS != ruleOS {
+		return false
+	}
+	if ruleArch, ok := rule.NodeSelector[corev1.LabelArchStable]; ok && !p.SupportsArchitecture(ruleArch) {
+		return false
+	}
+	return true
+}
+
+// String returns a human readable form of the platform for logs and errors
+func (p Platform) String() string {
+	return fmt.Sprintf("os=%q arch=%q", p.OS, strings.Join(p.Architectures, ","))
+}
+
+// FilterCompatibleRules returns a copy of the strategy without the rules a pod on this platform cannot be placed on.
+// The base count only survives when the first rule is still present, since base pods always go to it.
+func FilterCompatibleRules(platform Platform, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	filtered := &PlacementStrategy{
+		Base:        strategy.Base,
+		Mode:        strategy.Mode,
+		BasePercent: strategy.BasePercent,
+		BaseFrom:    strategy.BaseFrom,
+		Rules:       make([]PlacementRule, 0, len(strategy.Rules)),
+	}
+
+	for i, rule := range strategy.Rules {
+		if platform.IsRuleCompatible(rule) {
+			filtered.Rules = append(filtered.Rules, rule)
+		} else if i == 0 {
+			filtered.Base, filtered.BasePercent, filtered.BaseFrom = 0, 0, ""
+		}
+	}
+
+	if len(filtered.Rules) == 0 {
+		return nil, fmt.Errorf("no placement rule is compatible with pod platform (%s)", platform)
+	}
+
+	return filtered, nil
+}
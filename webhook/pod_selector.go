@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodSelectorAnnotation limits a deployment's strategy to the pods whose labels match it, in label
+// selector syntax, e.g. "role=worker" or "role in (worker,batch)"
+const PodSelectorAnnotation = "smart-scheduler.io/pod-selector"
+
+// StrategyPodSelector returns the selector of the deployment's pods its strategy applies to. Without
+// the annotation every pod is selected. An invalid annotation selects every pod and is returned as
+// an error, so callers can report it and place the pods as if it were absent.
+func StrategyPodSelector(deployment *appsv1.Deployment) (labels.Selector, error) {
+	annotation, ok := deployment.Annotations[PodSelectorAnnotation]
+	if !ok {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(annotation)
+	if err != nil {
+		return labels.Everything(), Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", PodSelectorAnnotation, err))
+	}
+	return selector, nil
+}
+
+// SelectStrategyPods returns the pods the deployment's strategy applies to. The pods are filtered in
+// place, so the input slice must not be used afterwards.
+func SelectStrategyPods(deployment *appsv1.Deployment, pods []corev1.Pod) []corev1.Pod {
+	selector, _ := StrategyPodSelector(deployment)
+	if selector.Empty() {
+		return pods
+	}
+	selected := pods[:0]
+	for i := range pods {
+		if selector.Matches(labels.Set(pods[i].Labels)) {
+			selected = append(selected, pods[i])
+		}
+	}
+	return selected
+}
+
+// ListStrategyPods returns the deployment's pods its strategy applies to, the ones counted against
+// its rules. Callers comparing pods with the deployment's replicas use ListDeploymentPods instead.
+func ListStrategyPods(ctx context.Context, c client.Reader, deployment *appsv1.Deployment, opts ...client.ListOption) ([]corev1.Pod, error) {
+	pods, err := ListDeploymentPods(ctx, c, deployment, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return SelectStrategyPods(deployment, pods), nil
+}
@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultNodeProblemConditions are the Node Problem Detector conditions that mark a node as unhealthy.
+// NPD sets these to True when it detects the problem.
+var DefaultNodeProblemConditions = []corev1.NodeConditionType{
+	"KernelDeadlock",
+	"ReadonlyFilesystem",
+	"NTPProblem",
+	"FrequentKubeletRestart",
+	"FrequentDockerRestart",
+	"FrequentContainerdRestart",
+	"CorruptDockerOverlay2",
+}
+
+// PoolHealth summarises the nodes selected by a placement rule
+type PoolHealth struct {
+	TotalNodes   int `json:"totalNodes"`
+	HealthyNodes int `json:"healthyNodes"`
+	// ProblemNodes counts Ready nodes that Node Problem Detector reports a problem on
+	ProblemNodes int `json:"problemNodes"`
+}
+
+// Healthy reports whether the pool has at least one node able to accept new pods
+func (h *PoolHealth) Healthy() bool {
+	return h.HealthyNodes > 0
+}
+
+// PoolHealthChecker evaluates the health of the node pools targeted by placement rules
+type PoolHealthChecker struct {
+	Client client.Client
+	Log    logr.Logger
+	// ProblemConditions are the node conditions that mark a node unhealthy when True
+	ProblemConditions []corev1.NodeConditionType
+}
+
+// NewPoolHealthChecker creates a new pool health checker using the default NPD conditions
+func NewPoolHealthChecker(client client.Client, log logr.Logger) *PoolHealthChecker {
+	return &PoolHealthChecker{
+		Client:            client,
+		Log:               log,
+		ProblemConditions: DefaultNodeProblemConditions,
+	}
+}
+
+// GetPoolHealth lists the nodes matching the rule's nodeSelector and counts the healthy ones.
+// A rule without a nodeSelector targets every node in the cluster.
+func (pc *PoolHealthChecker) GetPoolHealth(ctx context.Context, rule PlacementRule) (*PoolHealth, error) {
+	nodeList := &corev1.NodeList{}
+	err := pc.Client.List(ctx, nodeList, &client.ListOptions{
+		LabelSelector: rule.NodeLabelSelector(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	health := &PoolHealth{TotalNodes: len(nodeList.Items)}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if !isNodeHealthy(node) {
+			continue
+		}
+		if len(pc.NodeProblems(node)) > 0 {
+			health.ProblemNodes++
+			continue
+		}
+		health.HealthyNodes++
+	}
+
+	return health, nil
+}
+
+// NodeHealthy reports whether the node is counted as a healthy node of its pools: Ready,
+// schedulable and without a problem condition
+func (pc *PoolHealthChecker) NodeHealthy(node *corev1.Node) bool {
+	return isNodeHealthy(node) && len(pc.NodeProblems(node)) == 0
+}
+
+// RequireHealthy returns an ErrPoolUnhealthy error unless at least one of the rules targets a
+// pool with a healthy node
+func (pc *PoolHealthChecker) RequireHealthy(ctx context.Context, rules []PlacementRule) error {
+	for _, rule := range rules {
+		health, err := pc.GetPoolHealth(ctx, rule)
+		if err != nil {
+			return err
+		}
+		if health.Healthy() {
+			return nil
+		}
+	}
+	return Classify(ErrPoolUnhealthy, fmt.Errorf("none of the %d target pools has a healthy node", len(rules)))
+}
+
+// UnhealthyNodes returns the names of all nodes that are not Ready or have an NPD problem condition
+func (pc *PoolHealthChecker) UnhealthyNodes(ctx context.Context) (map[string]bool, error) {
+	nodeList := &corev1.NodeList{}
+	if err := pc.Client.List(ctx, nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	unhealthy := make(map[string]bool)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if !isNodeHealthy(node) || len(pc.NodeProblems(node)) > 0 {
+			unhealthy[node.Name] = true
+		}
+	}
+
+	return unhealthy, nil
+}
+
+// NodeProblems returns the configured problem conditions that are currently True on the node
+func (pc *PoolHealthChecker) NodeProblems(node *corev1.Node) []string {
+	var problems []string
+	for _, condition := range node.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		for _, problem := range pc.ProblemConditions {
+			if condition.Type == problem {
+				problems = append(problems, string(condition.Type))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// AvoidNodes adds a preferred node affinity term steering the pod away from the given nodes.
+// It is a preference rather than a requirement so pods can still land there if nothing else fits.
+func AvoidNodes(pod *corev1.Pod, nodeNames []string) {
+	if len(nodeNames) == 0 {
+		return
+	}
+
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.PreferredSchedulingTerm{
+			Weight: 100,
+			Preference: corev1.NodeSelectorTerm{
+				MatchFields: []corev1.NodeSelectorRequirement{{
+					Key:      "metadata.name",
+					Operator: corev1.NodeSelectorOpNotIn,
+					Values:   nodeNames,
+				}},
+			},
+		})
+}
+
+// HealthFunc returns a predicate suitable for SelectFailoverRule. Pools whose health cannot be
+// determined are treated as unhealthy so the chain moves on to the next rule.
+func (pc *PoolHealthChecker) HealthFunc(ctx context.Context) func(PlacementRule) bool {
+	return func(rule PlacementRule) bool {
+		health, err := pc.GetPoolHealth(ctx, rule)
+		if err != nil {
+			pc.Log.Error(err, "Failed to determine pool health", "nodeSelector", rule.NodeSelector)
+			return false
+		}
+		return health.Healthy()
+	}
+}
+
+// isNodeHealthy reports whether a node is Ready, schedulable and not tainted as unreachable
+func isNodeHealthy(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	for _, taint := range node.Spec.Taints {
+		switch taint.Key {
+		case corev1.TaintNodeNotReady, corev1.TaintNodeUnreachable, corev1.TaintNodeUnschedulable:
+			return false
+		}
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
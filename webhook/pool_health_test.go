@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// poolNode returns a Ready node of the pool with the given conditions set to True
+func poolNode(name, pool string, problems ...corev1.NodeConditionType) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-type": pool}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	for _, problem := range problems {
+		node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{Type: problem, Status: corev1.ConditionTrue})
+	}
+	return node
+}
+
+func newPoolHealthChecker(t *testing.T, nodes ...*corev1.Node) *PoolHealthChecker {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]client.Object, 0, len(nodes))
+	for _, node := range nodes {
+		objects = append(objects, node)
+	}
+	return NewPoolHealthChecker(fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(), logr.Discard())
+}
+
+func TestNodeProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []corev1.NodeCondition
+		want       []string
+	}{
+		{
+			name:       "No problem conditions",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+		{
+			name: "Problem reported",
+			conditions: []corev1.NodeCondition{
+				{Type: "KernelDeadlock", Status: corev1.ConditionTrue},
+				{Type: "ReadonlyFilesystem", Status: corev1.ConditionTrue},
+			},
+			want: []string{"KernelDeadlock", "ReadonlyFilesystem"},
+		},
+		{
+			name: "Problem cleared",
+			conditions: []corev1.NodeCondition{
+				{Type: "KernelDeadlock", Status: corev1.ConditionFalse},
+				{Type: "NTPProblem", Status: corev1.ConditionUnknown},
+			},
+		},
+		{
+			name:       "Condition NPD does not report as a problem",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	checker := newPoolHealthChecker(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{Status: corev1.NodeStatus{Conditions: tt.conditions}}
+			if got := checker.NodeProblems(node); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NodeProblems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Configured conditions replace the defaults
+	checker.ProblemConditions = []corev1.NodeConditionType{"GPUProblem"}
+	node := poolNode("gpu-0", "gpu", "GPUProblem", "KernelDeadlock")
+	if got := checker.NodeProblems(node); !reflect.DeepEqual(got, []string{"GPUProblem"}) {
+		t.Errorf("NodeProblems() = %v, want only the configured GPUProblem", got)
+	}
+}
+
+func TestGetPoolHealth(t *testing.T) {
+	notReady := poolNode("spot-not-ready", "spot")
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	cordoned := poolNode("spot-cordoned", "spot")
+	cordoned.Spec.Unschedulable = true
+	unreachable := poolNode("spot-unreachable", "spot")
+	unreachable.Spec.Taints = []corev1.Taint{{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoExecute}}
+
+	checker := newPoolHealthChecker(t,
+		poolNode("spot-0", "spot"),
+		poolNode("spot-deadlock", "spot", "KernelDeadlock"),
+		notReady, cordoned, unreachable,
+		poolNode("ondemand-readonly", "ondemand", "ReadonlyFilesystem"),
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		pool    string
+		want    PoolHealth
+		healthy bool
+	}{
+		{"Pool with a healthy node", "spot", PoolHealth{TotalNodes: 5, HealthyNodes: 1, ProblemNodes: 1}, true},
+		{"Pool whose only node has a problem", "ondemand", PoolHealth{TotalNodes: 1, ProblemNodes: 1}, false},
+		{"Pool without nodes", "gpu", PoolHealth{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := PlacementRule{NodeSelector: map[string]string{"node-type": tt.pool}}
+			health, err := checker.GetPoolHealth(ctx, rule)
+			if err != nil {
+				t.Fatalf("GetPoolHealth() error = %v", err)
+			}
+			if *health != tt.want {
+				t.Errorf("GetPoolHealth() = %+v, want %+v", *health, tt.want)
+			}
+			if health.Healthy() != tt.healthy {
+				t.Errorf("Healthy() = %v, want %v", health.Healthy(), tt.healthy)
+			}
+			if got := checker.HealthFunc(ctx)(rule); got != tt.healthy {
+				t.Errorf("HealthFunc() = %v, want %v", got, tt.healthy)
+			}
+		})
+	}
+
+	unhealthy, err := checker.UnhealthyNodes(ctx)
+	if err != nil {
+		t.Fatalf("UnhealthyNodes() error = %v", err)
+	}
+	want := map[string]bool{
+		"spot-deadlock": true, "spot-not-ready": true, "spot-cordoned": true, "spot-unreachable": true, "ondemand-readonly": true,
+	}
+	if !reflect.DeepEqual(unhealthy, want) {
+		t.Errorf("UnhealthyNodes() = %v, want %v", unhealthy, want)
+	}
+
+	ondemand := PlacementRule{NodeSelector: map[string]string{"node-type": "ondemand"}}
+	spot := PlacementRule{NodeSelector: map[string]string{"node-type": "spot"}}
+	if err := checker.RequireHealthy(ctx, []PlacementRule{ondemand, spot}); err != nil {
+		t.Errorf("RequireHealthy() error = %v, want nil with the spot pool healthy", err)
+	}
+	if err := checker.RequireHealthy(ctx, []PlacementRule{ondemand}); !errors.Is(err, ErrPoolUnhealthy) {
+		t.Errorf("RequireHealthy() error = %v, want ErrPoolUnhealthy", err)
+	}
+}
+
+func TestAvoidNodes(t *testing.T) {
+	pod := &corev1.Pod{}
+	AvoidNodes(pod, nil)
+	if pod.Spec.Affinity != nil {
+		t.Fatalf("Expected no affinity without nodes to avoid, got %+v", pod.Spec.Affinity)
+	}
+
+	AvoidNodes(pod, []string{"spot-deadlock", "ondemand-readonly"})
+	terms := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 {
+		t.Fatalf("Expected one preferred term, got %d", len(terms))
+	}
+	if pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		t.Error("Expected the nodes only avoided by preference")
+	}
+	want := corev1.NodeSelectorRequirement{
+		Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"spot-deadlock", "ondemand-readonly"},
+	}
+	if fields := terms[0].Preference.MatchFields; len(fields) != 1 || !reflect.DeepEqual(fields[0], want) {
+		t.Errorf("MatchFields = %+v, want %+v", fields, want)
+	}
+}
@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// ScheduleStrategyAnnotation holds the deployment's default placement strategy
+	ScheduleStrategyAnnotation = "smart-scheduler.io/schedule-strategy"
+	// PriorityStrategiesAnnotation maps priorityClassName to a placement strategy in the
+	// schedule-strategy format, e.g. {"critical":"base=0,weight=1,nodeSelector=node-type:ondemand"}
+	PriorityStrategiesAnnotation = "smart-scheduler.io/priority-strategies"
+)
+
+// HasScheduleStrategy reports whether the annotations carry any Smart Scheduler strategy
+func HasScheduleStrategy(annotations map[string]string) bool {
+	return annotations[ScheduleStrategyAnnotation] != "" || annotations[PriorityStrategiesAnnotation] != ""
+}
+
+// StrategyAnnotationsChanged reports whether any annotation that decides the applied strategy differs
+func StrategyAnnotationsChanged(oldAnnotations, newAnnotations map[string]string) bool {
+	return oldAnnotations[ScheduleStrategyAnnotation] != newAnnotations[ScheduleStrategyAnnotation] ||
+		oldAnnotations[PriorityStrategiesAnnotation] != newAnnotations[PriorityStrategiesAnnotation] ||
+		oldAnnotations[ExperimentAnnotation] != newAnnotations[ExperimentAnnotation]
+}
+
+// ResolveScheduleStrategy returns the strategy annotation that applies to a pod with the given
+// priorityClassName. A matching priority tier wins over the default schedule-strategy annotation.
+// A malformed priority-strategies annotation is reported as an error, but the default strategy is
+// still returned so callers can keep scheduling.
+func ResolveScheduleStrategy(annotations map[string]string, priorityClassName string) (string, bool, error) {
+	defaultStrategy, hasDefault := annotations[ScheduleStrategyAnnotation]
+
+	tiersData, hasTiers := annotations[PriorityStrategiesAnnotation]
+	if !hasTiers || priorityClassName == "" {
+		return defaultStrategy, hasDefault, nil
+	}
+
+	tiers, err := ParsePriorityStrategies(tiersData)
+	if err != nil {
+		return defaultStrategy, hasDefault, err
+	}
+
+	if tierStrategy, ok := tiers[priorityClassName]; ok {
+		return tierStrategy, true, nil
+	}
+
+	return defaultStrategy, hasDefault, nil
+}
+
+// ParsePriorityStrategies decodes the priority-strategies annotation and validates every tier strategy
+func ParsePriorityStrategies(data string) (map[string]string, error) {
+	tiers := make(map[string]string)
+	if err := json.Unmarshal([]byte(data), &tiers); err != nil {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", PriorityStrategiesAnnotation, err))
+	}
+
+	for priorityClassName, strategy := range tiers {
+		if _, err := ParsePlacementStrategyCached(strategy); err != nil {
+			return nil, fmt.Errorf("invalid strategy for priority class %s: %w", priorityClassName, err)
+		}
+	}
+
+	return tiers, nil
+}
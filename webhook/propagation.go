@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// PropagationAnnotation exposes a pod's placement to its containers, e.g.
+	// {"env":true,"annotations":true,"labels":true,"capacityTypeLabel":"node-type","excludeContainers":["istio-proxy"]}
+	PropagationAnnotation = "smart-scheduler.io/propagate-placement"
+	// CapacityTypeAnnotation carries the capacity type of the rule a pod was placed on, e.g. "spot"
+	CapacityTypeAnnotation = "smart-scheduler.io/capacity-type"
+	// RuleLabel names the rule a pod was placed on, so services, network policies and
+	// PodDisruptionBudgets can select the pods of one rule, e.g. "ondemand"
+	RuleLabel = "smart-scheduler.io/rule"
+	// RuleDisruptionBudgetAnnotation, set to "true", has the operator keep a PodDisruptionBudget
+	// guarding the base of the deployment's first rule, selecting its pods by the rule label
+	RuleDisruptionBudgetAnnotation = "smart-scheduler.io/rule-disruption-budget"
+
+	// CapacityTypeEnv is the environment variable carrying the capacity type of the pod's rule
+	CapacityTypeEnv = "SMART_SCHEDULER_CAPACITY_TYPE"
+	// PlacementRuleEnv is the environment variable carrying the key of the pod's rule
+	PlacementRuleEnv = "SMART_SCHEDULER_PLACEMENT_RULE"
+)
+
+// DefaultCapacityTypeLabels are the node labels checked, in order, for a rule's capacity type when
+// the propagation sets no capacityTypeLabel
+var DefaultCapacityTypeLabels = []string{
+	"karpenter.sh/capacity-type",
+	"eks.amazonaws.com/capacityType",
+	"node-type",
+}
+
+// Propagation selects how a pod's placement is exposed to application code
+type Propagation struct {
+	// Env sets environment variables on the pod's containers
+	Env bool `json:"env,omitempty"`
+	// Annotations sets the capacity-type annotation, readable through the downward API
+	Annotations bool `json:"annotations,omitempty"`
+	// Labels sets the rule label, for selecting the pods of one rule
+	Labels bool `json:"labels,omitempty"`
+	// CapacityTypeLabel is the nodeSelector key holding the capacity type; empty checks DefaultCapacityTypeLabels
+	CapacityTypeLabel string `json:"capacityTypeLabel,omitempty"`
+	// ExcludeContainers lists containers that get no environment variables, e.g. sidecars
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+}
+
+// ParsePropagation decodes the propagation annotation
+func ParsePropagation(data string) (*Propagation, error) {
+	propagation := &Propagation{}
+	if err := json.Unmarshal([]byte(data), propagation); err != nil {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", PropagationAnnotation, err))
+	}
+	return propagation, nil
+}
+
+// CapacityType returns the capacity type the pod's nodeSelector selects, or "" when it selects none
+func (p *Propagation) CapacityType(nodeSelector map[string]string) string {
+	labels := DefaultCapacityTypeLabels
+	if p.CapacityTypeLabel != "" {
+		labels = []string{p.CapacityTypeLabel}
+	}
+	for _, label := range labels {
+		if value, ok := nodeSelector[label]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// Apply exposes the placement of a pod placed on the rule with the given key. Environment
+// variables the containers already define are left alone.
+func (p *Propagation) Apply(pod *corev1.Pod, ruleKey RuleKey) {
+	capacityType := p.CapacityType(pod.Spec.NodeSelector)
+	if p.Annotations && capacityType != "" {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[CapacityTypeAnnotation] = capacityType
+	}
+	if value := RuleLabelValue(ruleKey); p.Labels && value != "" {
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		pod.Labels[RuleLabel] = value
+	}
+	if !p.Env {
+		return
+	}
+
+	var env []corev1.EnvVar
+	if capacityType != "" {
+		env = append(env, corev1.EnvVar{Name: CapacityTypeEnv, Value: capacityType})
+	}
+	if ruleKey != "" {
+		env = append(env, corev1.EnvVar{Name: PlacementRuleEnv, Value: ruleKey.String()})
+	}
+	p.setEnv(pod.Spec.InitContainers, env)
+	p.setEnv(pod.Spec.Containers, env)
+}
+
+// RuleLabelValue returns the rule label value of the rule with the given key: the values of its
+// nodeSelector in key order, joined by dots, e.g. "spot" or "ondemand.us-west-2a". Values longer
+// than a label allows are shortened and suffixed with a hash of the key. Rules without a
+// nodeSelector, and keys that cannot be parsed, have no value.
+func RuleLabelValue(key RuleKey) string {
+	nodeSelector, ok := key.NodeSelector()
+	if !ok || len(nodeSelector) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(nodeSelector))
+	for k := range nodeSelector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if nodeSelector[k] != "" {
+			values = append(values, nodeSelector[k])
+		}
+	}
+
+	value := strings.Join(values, ".")
+	if len(value) <= validation.LabelValueMaxLength {
+		return value
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	suffix := fmt.Sprintf("-%016x", hash.Sum64())
+	return strings.TrimRight(value[:validation.LabelValueMaxLength-len(suffix)], "-_.") + suffix
+}
+
+// setEnv adds the variables to every container that is not excluded and does not define them
+func (p *Propagation) setEnv(containers []corev1.Container, env []corev1.EnvVar) {
+	for i := range containers {
+		container := &containers[i]
+		if p.excluded(container.Name) {
+			continue
+		}
+		for _, variable := range env {
+			if !hasEnv(container, variable.Name) {
+				container.Env = append(container.Env, variable)
+			}
+		}
+	}
+}
+
+// excluded reports whether the container is listed in ExcludeContainers
+func (p *Propagation) excluded(name string) bool {
+	for _, excluded := range p.ExcludeContainers {
+		if excluded == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEnv reports whether the container defines the environment variable
+func hasEnv(container *corev1.Container, name string) bool {
+	for _, variable := range container.Env {
+		if variable.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// propagatePlacement exposes the pod's placement as the deployment's propagation annotation asks.
+// Deployments with rule PodDisruptionBudgets always get the rule label, which the budgets select.
+// An invalid annotation is logged and ignored.
+func (pm *PodMutator) propagatePlacement(pod *corev1.Pod, deployment *appsv1.Deployment, ruleKey RuleKey) {
+	propagation := &Propagation{}
+	if data, ok := deployment.Annotations[PropagationAnnotation]; ok {
+		parsed, err := ParsePropagation(data)
+		if err != nil {
+			pm.Log.Error(err, "Ignoring placement propagation", "deployment", deployment.Name)
+		} else {
+			propagation = parsed
+		}
+	}
+	if deployment.Annotations[RuleDisruptionBudgetAnnotation] == "true" {
+		propagation.Labels = true
+	}
+	propagation.Apply(pod, ruleKey)
+}
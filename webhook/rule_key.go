@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RuleKey identifies a placement rule in pod counts and placement state. It is the rule's
+// nodeSelector in canonical form: "key=value" pairs sorted by key and joined by commas.
+// A rule without a nodeSelector has the empty key.
+type RuleKey string
+
+// String returns the key as stored in state objects and annotations
+func (k RuleKey) String() string {
+	return string(k)
+}
+
+// Key returns the rule's canonical key, using the value cached at parse time when present
+func (r PlacementRule) Key() RuleKey {
+	if r.key != "" || len(r.NodeSelector) == 0 {
+		return r.key
+	}
+	return NodeSelectorKey(r.NodeSelector)
+}
+
+// NodeSelectorKey returns the canonical key for a nodeSelector
+func NodeSelectorKey(nodeSelector map[string]string) RuleKey {
+	switch len(nodeSelector) {
+	case 0:
+		return ""
+	case 1:
+		for key, value := range nodeSelector {
+			return RuleKey(key + "=" + value)
+		}
+	}
+
+	keys := make([]string, 0, len(nodeSelector))
+	size := len(nodeSelector) - 1
+	for key, value := range nodeSelector {
+		keys = append(keys, key)
+		size += len(key) + len(value) + 1
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.Grow(size)
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(nodeSelector[key])
+	}
+	return RuleKey(b.String())
+}
+
+// NodeSelector returns the nodeSelector the key was built from. It reports false for keys that are
+// not in "key=value,key2=value2" form.
+func (k RuleKey) NodeSelector() (map[string]string, bool) {
+	return parseRuleKeyPairs(string(k), ",")
+}
+
+// CanonicalRuleKey rewrites a key stored by an older release into canonical form. Older webhooks
+// joined nodeSelector pairs in map iteration order and the rebalance controller formatted them as
+// "[k=v k2=v2]"; both are accepted. Keys that cannot be parsed are returned unchanged.
+func CanonicalRuleKey(stored string) RuleKey {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(stored, "["), "]")
+	separator := ","
+	if strings.HasPrefix(stored, "[") {
+		separator = " "
+	}
+
+	nodeSelector, ok := parseRuleKeyPairs(trimmed, separator)
+	if !ok {
+		return RuleKey(stored)
+	}
+	return NodeSelectorKey(nodeSelector)
+}
+
+// parseRuleKeyPairs splits "k=v" pairs joined by separator into a nodeSelector
+func parseRuleKeyPairs(pairs, separator string) (map[string]string, bool) {
+	nodeSelector := make(map[string]string)
+	if pairs == "" {
+		return nodeSelector, true
+	}
+
+	for _, pair := range strings.Split(pairs, separator) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, false
+		}
+		nodeSelector[kv[0]] = kv[1]
+	}
+	return nodeSelector, true
+}
+
+// migrateRuleKeys returns counts keyed canonically, merging keys that only differed in pair order.
+// The boolean reports whether any key was rewritten.
+func migrateRuleKeys(counts map[RuleKey]int) (map[RuleKey]int, bool) {
+	migrated := false
+	for key := range counts {
+		if CanonicalRuleKey(string(key)) != key {
+			migrated = true
+			break
+		}
+	}
+	if !migrated {
+		return counts, false
+	}
+
+	out := make(map[RuleKey]int, len(counts))
+	for key, count := range counts {
+		out[CanonicalRuleKey(string(key))] += count
+	}
+	return out, true
+}
+
+// MatchRuleKey returns the key of the rule a pod with the given nodeSelector belongs to. When the
+// selectors of several rules overlap, the most specific rule wins: the one with the most selector
+// pairs, then the earliest. A rule without a nodeSelector only gets pods no other rule matches.
+// Pods no rule matches belong to the first rule whose former selectors match them. Use a
+// RuleMatcher to match many pods.
+func MatchRuleKey(strategy *PlacementStrategy, podNodeSelector map[string]string) (RuleKey, bool) {
+	best := -1
+	for i, rule := range strategy.Rules {
+		if best >= 0 && len(rule.NodeSelector) <= len(strategy.Rules[best].NodeSelector) {
+			continue
+		}
+		if isNodeSelectorSubset(rule.NodeSelector, podNodeSelector) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return strategy.Rules[best].Key(), true
+	}
+	for _, rule := range strategy.Rules {
+		for _, selector := range rule.FormerSelectors {
+			if len(selector) > 0 && isNodeSelectorSubset(selector, podNodeSelector) {
+				return rule.Key(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// PlacedRuleAnnotation records the key of the rule whose node the scheduler plugin bound a pod to.
+// Pods placed by the plugin keep the nodeSelector of their template.
+const PlacedRuleAnnotation = "smart-scheduler.io/placed-rule"
+
+// PlacementSelector returns the nodeSelector a pod is matched to rules by: the one the webhook set,
+// with the selector of the rule the scheduler plugin recorded merged in
+func PlacementSelector(pod *corev1.Pod) map[string]string {
+	key, ok := pod.Annotations[PlacedRuleAnnotation]
+	if !ok {
+		return pod.Spec.NodeSelector
+	}
+	selector, ok := RuleKey(key).NodeSelector()
+	if !ok {
+		return pod.Spec.NodeSelector
+	}
+	for k, v := range pod.Spec.NodeSelector {
+		if _, set := selector[k]; !set {
+			selector[k] = v
+		}
+	}
+	return selector
+}
+
+// RuleMatcher matches the pods of a deployment to the rules of its strategy like MatchRuleKey, in
+// time independent of the number of rules. A pod whose nodeSelector is exactly a rule's belongs to
+// the first rule with that key, and the result for every other nodeSelector is computed once, as
+// a deployment's pods share a few nodeSelectors. It is not safe for concurrent use.
+type RuleMatcher struct {
+	strategy *PlacementStrategy
+	// rules maps each rule key to the first rule with it
+	rules map[RuleKey]int
+	// matched memoizes the matches of nodeSelectors that are no rule's, by their key
+	matched map[RuleKey]ruleMatch
+}
+
+// ruleMatch is a memoized MatchRuleKey result
+type ruleMatch struct {
+	key RuleKey
+	ok  bool
+}
+
+// NewRuleMatcher indexes the rules of the strategy by key
+func NewRuleMatcher(strategy *PlacementStrategy) *RuleMatcher {
+	m := &RuleMatcher{
+		strategy: strategy,
+		rules:    make(map[RuleKey]int, len(strategy.Rules)),
+		matched:  make(map[RuleKey]ruleMatch),
+	}
+	for i, rule := range strategy.Rules {
+		if _, ok := m.rules[rule.Key()]; !ok {
+			m.rules[rule.Key()] = i
+		}
+	}
+	return m
+}
+
+// Match returns the key of the rule a pod with the given nodeSelector belongs to, as MatchRuleKey
+func (m *RuleMatcher) Match(podNodeSelector map[string]string) (RuleKey, bool) {
+	// No rule has more pairs than an exactly matching one, and its earliest wins ties
+	key := NodeSelectorKey(podNodeSelector)
+	if _, ok := m.rules[key]; ok && key != "" {
+		return key, true
+	}
+	if match, ok := m.matched[key]; ok {
+		return match.key, match.ok
+	}
+	ruleKey, ok := MatchRuleKey(m.strategy, podNodeSelector)
+	m.matched[key] = ruleMatch{key: ruleKey, ok: ok}
+	return ruleKey, ok
+}
+
+// MaxRulePods bounds the pods listed per rule in the placement state, so the state of large
+// deployments stays well within the ConfigMap size limit
+const MaxRulePods = 500
+
+// AttributePodsByRule returns the UIDs of the pods CountPodsByRule counts for each rule, at most
+// limit per rule. Every rule has an entry, even when no pod matches it.
+func AttributePodsByRule(pods []corev1.Pod, strategy *PlacementStrategy, limit int) map[RuleKey][]types.UID {
+	uids := make(map[RuleKey][]types.UID, len(strategy.Rules))
+	for _, rule := range strategy.Rules {
+		uids[rule.Key()] = []types.UID{}
+	}
+
+	matcher := NewRuleMatcher(strategy)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || (pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending) {
+			continue
+		}
+		if ruleKey, ok := matcher.Match(PlacementSelector(pod)); ok && len(uids[ruleKey]) < limit {
+			uids[ruleKey] = append(uids[ruleKey], pod.UID)
+		}
+	}
+
+	return uids
+}
+
+// CountPodsByRule counts running and pending pods per rule, attributing each pod to the rule chosen
+// by MatchRuleKey. Every rule has an entry, even when no pod matches it.
+func CountPodsByRule(pods []corev1.Pod, strategy *PlacementStrategy) map[RuleKey]int {
+	counts := make(map[RuleKey]int, len(strategy.Rules))
+	for _, rule := range strategy.Rules {
+		counts[rule.Key()] = 0
+	}
+
+	matcher := NewRuleMatcher(strategy)
+	for _, pod := range pods {
+		// Skip pods that are being deleted
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		// Skip pods that are not running or pending
+		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+
+		if ruleKey, ok := matcher.Match(PlacementSelector(&pod)); ok {
+			counts[ruleKey]++
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// RuleRenamesAnnotation maps the keys of rules whose nodes were relabeled to the keys of their new
+// selectors, as a JSON object, e.g. {"node-type=spot":"pool=spot-v2"}. Pods placed with the old
+// selector count toward the rule with the new one, and placement state kept under the old key
+// moves to the new one.
+const RuleRenamesAnnotation = "smart-scheduler.io/rule-renames"
+
+// ParseRuleRenames parses the rule renames annotation; an empty annotation renames nothing
+func ParseRuleRenames(annotation string) (map[RuleKey]RuleKey, error) {
+	if annotation == "" {
+		return nil, nil
+	}
+	var renames map[RuleKey]RuleKey
+	if err := json.Unmarshal([]byte(annotation), &renames); err != nil {
+		return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %w", RuleRenamesAnnotation, err))
+	}
+	out := make(map[RuleKey]RuleKey, len(renames))
+	for from, to := range renames {
+		if _, ok := from.NodeSelector(); !ok || from == "" {
+			return nil, Classify(ErrStrategyInvalid, fmt.Errorf("invalid %s annotation: %q is not a rule key", RuleRenamesAnnotation, from))
+		}
+		out[CanonicalRuleKey(string(from))] = CanonicalRuleKey(string(to))
+	}
+	return out, nil
+}
+
+// ApplyRuleRenames returns the strategy with the former selectors of its renamed rules, so their
+// pods are matched as before. Renames are followed through chains, e.g. a pool renamed twice.
+// Renames to keys no rule has, and from keys a rule still has, are ignored. The strategy is
+// returned unchanged without renames.
+func ApplyRuleRenames(strategy *PlacementStrategy, renames map[RuleKey]RuleKey) *PlacementStrategy {
+	if len(renames) == 0 {
+		return strategy
+	}
+	index := make(map[RuleKey]int, len(strategy.Rules))
+	for i, rule := range strategy.Rules {
+		index[rule.Key()] = i
+	}
+	froms := make([]RuleKey, 0, len(renames))
+	for from := range renames {
+		froms = append(froms, from)
+	}
+	sort.Slice(froms, func(i, j int) bool { return froms[i] < froms[j] })
+
+	var renamed *PlacementStrategy
+	for _, from := range froms {
+		to := from
+		for steps := 0; steps < len(renames); steps++ {
+			next, ok := renames[to]
+			if !ok {
+				break
+			}
+			to = next
+		}
+		// A former selector still in use by a rule keeps its own pods
+		i, ok := index[to]
+		if _, inUse := index[from]; !ok || inUse || to == from {
+			continue
+		}
+		selector, _ := from.NodeSelector()
+		if renamed == nil {
+			copied := *strategy
+			copied.Rules = append([]PlacementRule(nil), strategy.Rules...)
+			renamed = &copied
+		}
+		rule := &renamed.Rules[i]
+		rule.FormerSelectors = append(append([]map[string]string(nil), rule.FormerSelectors...), selector)
+	}
+	if renamed == nil {
+		return strategy
+	}
+	return renamed
+}
+
+// WithRuleRenames applies the deployment's rule renames to the strategy. An invalid annotation
+// renames nothing.
+func WithRuleRenames(deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	renames, err := ParseRuleRenames(deployment.Annotations[RuleRenamesAnnotation])
+	if err != nil {
+		return strategy, err
+	}
+	return ApplyRuleRenames(strategy, renames), nil
+}
+
+// rekeyRenamedRules moves the counts and pods the state keeps under the former selectors of the
+// strategy's rules to the rules' keys. It reports whether anything moved.
+func rekeyRenamedRules(state *PlacementState, strategy *PlacementStrategy) bool {
+	moved := false
+	for _, rule := range strategy.Rules {
+		key := rule.Key()
+		for _, selector := range rule.FormerSelectors {
+			former := NodeSelectorKey(selector)
+			count, counted := state.PodCounts[former]
+			pods, listed := state.RulePods[former]
+			if !counted && !listed {
+				continue
+			}
+			if counted {
+				state.PodCounts[key] += count
+				delete(state.PodCounts, former)
+			}
+			if listed {
+				state.RulePods[key] = append(state.RulePods[key], pods...)
+				delete(state.RulePods, former)
+			}
+			moved = true
+		}
+	}
+	return moved
+}
+
+// RenamedSelector returns the nodeSelector that selects a node selected by selector after a relabel
+// that removed and added the given labels. Each pair the relabel removed is replaced by the added
+// pair with the same key, i.e. a new value, or else by the only added pair with the same value
+// under a key the selector does not use, i.e. a new key. It reports false when the relabel left
+// the selector untouched or a removed pair has no unique replacement.
+func RenamedSelector(selector, removed, added map[string]string) (map[string]string, bool) {
+	renamed := make(map[string]string, len(selector))
+	changed := false
+	for key, value := range selector {
+		if old, ok := removed[key]; !ok || old != value {
+			renamed[key] = value
+			continue
+		}
+		changed = true
+		if newValue, ok := added[key]; ok {
+			renamed[key] = newValue
+			continue
+		}
+
+		replacement := ""
+		for addedKey, addedValue := range added {
+			if _, used := selector[addedKey]; used || addedValue != value {
+				continue
+			}
+			if replacement != "" {
+				return nil, false
+			}
+			replacement = addedKey
+		}
+		if replacement == "" {
+			return nil, false
+		}
+		renamed[replacement] = value
+	}
+	if !changed {
+		return nil, false
+	}
+	return renamed, true
+}
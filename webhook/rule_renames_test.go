@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRenamedSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector map[string]string
+		removed  map[string]string
+		added    map[string]string
+		want     map[string]string
+		wantOK   bool
+	}{
+		{
+			name:     "new value",
+			selector: map[string]string{"pool": "spot", "zone": "a"},
+			removed:  map[string]string{"pool": "spot"},
+			added:    map[string]string{"pool": "spot-v2"},
+			want:     map[string]string{"pool": "spot-v2", "zone": "a"},
+			wantOK:   true,
+		},
+		{
+			name:     "new key",
+			selector: map[string]string{"node-type": "spot"},
+			removed:  map[string]string{"node-type": "spot"},
+			added:    map[string]string{"pool": "spot", "team": "web"},
+			want:     map[string]string{"pool": "spot"},
+			wantOK:   true,
+		},
+		{
+			name:     "ambiguous new key",
+			selector: map[string]string{"node-type": "spot"},
+			removed:  map[string]string{"node-type": "spot"},
+			added:    map[string]string{"pool": "spot", "tier": "spot"},
+		},
+		{
+			name:     "selector untouched",
+			selector: map[string]string{"zone": "a"},
+			removed:  map[string]string{"pool": "spot"},
+			added:    map[string]string{"pool": "spot-v2"},
+		},
+		{
+			name:     "label removed",
+			selector: map[string]string{"pool": "spot"},
+			removed:  map[string]string{"pool": "spot"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RenamedSelector(tt.selector, tt.removed, tt.added)
+			if ok != tt.wantOK {
+				t.Fatalf("RenamedSelector() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenamedSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRuleRenames(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=2,nodeSelector=pool:spot-v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := func(selector map[string]string) corev1.Pod {
+		return corev1.Pod{Spec: corev1.PodSpec{NodeSelector: selector}, Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+	}
+	pods := []corev1.Pod{
+		pod(map[string]string{"node-type": "ondemand"}),
+		pod(map[string]string{"node-type": "spot"}),
+		pod(map[string]string{"pool": "spot-v2"}),
+		pod(map[string]string{"pool": "spot-v3"}),
+	}
+
+	// node-type=spot was renamed to pool=spot-v2 and then to pool=spot-v3; the rename of the
+	// on-demand rule's key is ignored since a rule still uses it
+	renames, err := ParseRuleRenames(`{"node-type=spot":"pool=spot-v2","pool=spot-v2":"pool=spot-v3","node-type=ondemand":"pool=spot-v3"}`)
+	if err != nil {
+		t.Fatalf("ParseRuleRenames() error = %v", err)
+	}
+	renamed := ApplyRuleRenames(strategy, renames)
+	if len(strategy.Rules[1].FormerSelectors) != 0 {
+		t.Errorf("ApplyRuleRenames() modified the original strategy")
+	}
+
+	want := map[RuleKey]int{"node-type=ondemand": 1, "pool=spot-v3": 3}
+	if got := CountPodsByRule(pods, renamed); !reflect.DeepEqual(got, want) {
+		t.Errorf("CountPodsByRule() = %v, want %v", got, want)
+	}
+
+	state := &PlacementState{
+		PodCounts: map[RuleKey]int{"node-type=spot": 2, "pool=spot-v3": 1},
+		RulePods:  map[RuleKey][]types.UID{"node-type=spot": {"a", "b"}},
+	}
+	if !rekeyRenamedRules(state, renamed) {
+		t.Fatalf("rekeyRenamedRules() moved nothing")
+	}
+	if want := map[RuleKey]int{"pool=spot-v3": 3}; !reflect.DeepEqual(state.PodCounts, want) {
+		t.Errorf("PodCounts = %v, want %v", state.PodCounts, want)
+	}
+	if want := map[RuleKey][]types.UID{"pool=spot-v3": {"a", "b"}}; !reflect.DeepEqual(state.RulePods, want) {
+		t.Errorf("RulePods = %v, want %v", state.RulePods, want)
+	}
+
+	if _, err := ParseRuleRenames(`{"spot":"pool=spot"}`); !errors.Is(err, ErrStrategyInvalid) {
+		t.Errorf("ParseRuleRenames() error = %v, want ErrStrategyInvalid", err)
+	}
+}
@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultScaleDownTaints are the taints node autoscalers put on nodes they are about to remove:
+// cluster-autoscaler marks unneeded nodes as deletion candidates and taints them again when it
+// starts deleting them, Karpenter taints the nodes it is disrupting (v1 and earlier releases).
+var DefaultScaleDownTaints = []string{
+	"ToBeDeletedByClusterAutoscaler",
+	"DeletionCandidateOfClusterAutoscaler",
+	"karpenter.sh/disrupted",
+	"karpenter.sh/disruption",
+}
+
+// ScaleDownTracker finds nodes that a node autoscaler scheduled for removal, so new pods are not
+// placed on them only to be evicted again minutes later
+type ScaleDownTracker struct {
+	Client client.Client
+	Log    logr.Logger
+	// Taints are the taint keys that mark a node as scheduled for removal
+	Taints []string
+}
+
+// NewScaleDownTracker creates a tracker recognising the default autoscaler taints
+func NewScaleDownTracker(client client.Client, log logr.Logger) *ScaleDownTracker {
+	return &ScaleDownTracker{
+		Client: client,
+		Log:    log,
+		Taints: DefaultScaleDownTaints,
+	}
+}
+
+// ScheduledForRemoval reports whether the node carries one of the scale-down taints. It is nil-safe.
+func (t *ScaleDownTracker) ScheduledForRemoval(node *corev1.Node) bool {
+	if t == nil {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, key := range t.Taints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NodesScheduledForRemoval returns the sorted names of the nodes scheduled for removal
+func (t *ScaleDownTracker) NodesScheduledForRemoval(ctx context.Context) ([]string, error) {
+	if t == nil || len(t.Taints) == 0 {
+		return nil, nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := t.Client.List(ctx, nodeList, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var nodeNames []string
+	for i := range nodeList.Items {
+		if t.ScheduledForRemoval(&nodeList.Items[i]) {
+			nodeNames = append(nodeNames, nodeList.Items[i].Name)
+		}
+	}
+	sort.Strings(nodeNames)
+	return nodeNames, nil
+}
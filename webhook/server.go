@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/http2"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// tlsVersions are the accepted --webhook-tls-min-version values
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// ServerTLSOptions hardens the webhook server's TLS and HTTP/2 settings
+type ServerTLSOptions struct {
+	// MinVersion is the lowest accepted TLS version (default: TLS 1.2)
+	MinVersion uint16
+	// CipherSuites restricts the TLS 1.2 cipher suites; TLS 1.3 suites are not configurable
+	CipherSuites []uint16
+	// EnableHTTP2 offers HTTP/2 to the API server; otherwise only HTTP/1.1 is served
+	EnableHTTP2 bool
+	// MaxConcurrentStreams limits HTTP/2 streams per connection (0: the Go default of 250)
+	MaxConcurrentStreams uint32
+}
+
+// ParseServerTLSOptions validates the webhook TLS flags. minVersion is VersionTLS12 or VersionTLS13
+// and cipherSuites a comma-separated list of IANA names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func ParseServerTLSOptions(minVersion, cipherSuites string, enableHTTP2 bool, maxConcurrentStreams uint32) (ServerTLSOptions, error) {
+	opts := ServerTLSOptions{MinVersion: tls.VersionTLS12, EnableHTTP2: enableHTTP2, MaxConcurrentStreams: maxConcurrentStreams}
+
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return opts, fmt.Errorf("unknown TLS version %q, expected VersionTLS12 or VersionTLS13", minVersion)
+		}
+		opts.MinVersion = version
+	}
+
+	supported := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+	for _, name := range strings.Split(cipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := supported[name]
+		if !ok {
+			return opts, fmt.Errorf("unsupported or insecure cipher suite %q", name)
+		}
+		opts.CipherSuites = append(opts.CipherSuites, id)
+	}
+	if len(opts.CipherSuites) > 0 && opts.MinVersion == tls.VersionTLS13 {
+		return opts, errors.New("cipher suites cannot be configured when only TLS 1.3 is accepted")
+	}
+	if maxConcurrentStreams > 0 && !enableHTTP2 {
+		return opts, errors.New("max concurrent streams requires HTTP/2 to be enabled")
+	}
+	return opts, nil
+}
+
+// apply sets the options on a server TLS config
+func (o ServerTLSOptions) apply(cfg *tls.Config) {
+	cfg.MinVersion = o.MinVersion
+	if len(o.CipherSuites) > 0 {
+		cfg.CipherSuites = o.CipherSuites
+	}
+	if o.EnableHTTP2 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	} else {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+}
+
+// NewServer returns the webhook server for opts hardened with tlsOpts. The controller-runtime
+// server is used unless an HTTP/2 stream limit is set, which it cannot configure.
+func NewServer(opts ctrlwebhook.Options, tlsOpts ServerTLSOptions, log logr.Logger) ctrlwebhook.Server {
+	opts.TLSOpts = append(opts.TLSOpts, tlsOpts.apply)
+	if tlsOpts.MaxConcurrentStreams == 0 {
+		return ctrlwebhook.NewServer(opts)
+	}
+	return &streamLimitedServer{
+		Server:  ctrlwebhook.NewServer(opts),
+		options: opts,
+		http2:   &http2.Server{MaxConcurrentStreams: tlsOpts.MaxConcurrentStreams},
+		log:     log,
+	}
+}
+
+// streamLimitedServer serves the controller-runtime server's webhooks with a tuned HTTP/2 server
+type streamLimitedServer struct {
+	ctrlwebhook.Server
+	options ctrlwebhook.Options
+	http2   *http2.Server
+	log     logr.Logger
+
+	mu      sync.Mutex
+	started bool
+}
+
+// Start serves the registered webhooks until the context is cancelled
+func (s *streamLimitedServer) Start(ctx context.Context) error {
+	cfg := &tls.Config{} //nolint:gosec // MinVersion is set by the TLS options
+	for _, op := range s.options.TLSOpts {
+		op(cfg)
+	}
+
+	certName, keyName := s.options.CertName, s.options.KeyName
+	if certName == "" {
+		certName = "tls.crt"
+	}
+	if keyName == "" {
+		keyName = "tls.key"
+	}
+	certWatcher, err := certwatcher.New(filepath.Join(s.options.CertDir, certName), filepath.Join(s.options.CertDir, keyName))
+	if err != nil {
+		return err
+	}
+	cfg.GetCertificate = certWatcher.GetCertificate
+	go func() {
+		if err := certWatcher.Start(ctx); err != nil {
+			s.log.Error(err, "certificate watcher error")
+		}
+	}()
+
+	listener, err := tls.Listen("tcp", s.address(), cfg)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.WebhookMux(),
+		MaxHeaderBytes:    1 << 20,
+		IdleTimeout:       90 * time.Second,
+		ReadHeaderTimeout: 32 * time.Second,
+	}
+	if err := http2.ConfigureServer(srv, s.http2); err != nil {
+		return fmt.Errorf("failed to configure HTTP/2: %w", err)
+	}
+
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.log.Error(err, "error shutting down the webhook server")
+		}
+		close(idleConnsClosed)
+	}()
+
+	s.log.Info("Serving webhook server", "address", s.address(), "maxConcurrentStreams", s.http2.MaxConcurrentStreams)
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	<-idleConnsClosed
+	return nil
+}
+
+// StartedChecker reports ready once the server accepts TLS connections
+func (s *streamLimitedServer) StartedChecker() healthz.Checker {
+	config := &tls.Config{InsecureSkipVerify: true} //nolint:gosec // only connects to our own port
+	return func(_ *http.Request) error {
+		s.mu.Lock()
+		started := s.started
+		s.mu.Unlock()
+		if !started {
+			return errors.New("webhook server has not been started yet")
+		}
+
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", s.address(), config)
+		if err != nil {
+			return fmt.Errorf("webhook server is not reachable: %w", err)
+		}
+		return conn.Close()
+	}
+}
+
+// address returns the host:port the server listens on
+func (s *streamLimitedServer) address() string {
+	port := s.options.Port
+	if port <= 0 {
+		port = ctrlwebhook.DefaultPort
+	}
+	return net.JoinHostPort(s.options.Host, strconv.Itoa(port))
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseServerTLSOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		minVersion   string
+		cipherSuites string
+		enableHTTP2  bool
+		streams      uint32
+		wantVersion  uint16
+		wantSuites   int
+		wantErr      bool
+	}{
+		{"Defaults", "", "", true, 0, tls.VersionTLS12, 0, false},
+		{"TLS 1.3 only", "VersionTLS13", "", true, 0, tls.VersionTLS13, 0, false},
+		{"Restricted cipher suites", "VersionTLS12", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", true, 0, tls.VersionTLS12, 2, false},
+		{"Stream limit", "", "", true, 100, tls.VersionTLS12, 0, false},
+		{"Unknown version", "VersionTLS10", "", true, 0, 0, 0, true},
+		{"Insecure cipher suite", "", "TLS_RSA_WITH_RC4_128_SHA", true, 0, 0, 0, true},
+		{"Cipher suites with TLS 1.3 only", "VersionTLS13", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", true, 0, 0, 0, true},
+		{"Stream limit without HTTP/2", "", "", false, 100, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := ParseServerTLSOptions(tt.minVersion, tt.cipherSuites, tt.enableHTTP2, tt.streams)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if opts.MinVersion != tt.wantVersion || len(opts.CipherSuites) != tt.wantSuites {
+				t.Errorf("Expected version %x with %d suites, got %x with %d", tt.wantVersion, tt.wantSuites, opts.MinVersion, len(opts.CipherSuites))
+			}
+
+			cfg := &tls.Config{}
+			opts.apply(cfg)
+			if tt.enableHTTP2 && cfg.NextProtos[0] != "h2" {
+				t.Errorf("Expected HTTP/2 to be offered, got %v", cfg.NextProtos)
+			}
+		})
+	}
+}
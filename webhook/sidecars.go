@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SidecarTracker learns the CPU and memory requests of the sidecars other webhooks inject into the
+// pods of each deployment. Injecting webhooks may run after this one, so a pod is placed before
+// its sidecars are added; when the API server reinvokes the webhook with the injected pod, the
+// containers missing from the deployment's template are recorded, and counted in the size of the
+// deployment's later pods. A nil tracker learns nothing.
+type SidecarTracker struct {
+	mu       sync.Mutex
+	sidecars map[types.NamespacedName]map[string]containerRequests
+}
+
+// containerRequests is the CPU (in millicores) and memory a container requests, and whether it
+// runs alongside the app containers: a regular container or a restartable init container
+type containerRequests struct {
+	cpu     int64
+	memory  int64
+	running bool
+}
+
+// NewSidecarTracker creates an empty sidecar tracker
+func NewSidecarTracker() *SidecarTracker {
+	return &SidecarTracker{sidecars: make(map[types.NamespacedName]map[string]containerRequests)}
+}
+
+// Observe records the containers of the pod missing from the deployment's template as its
+// sidecars, replacing those learned before. It reports whether they changed.
+func (t *SidecarTracker) Observe(pod *corev1.Pod, deployment *appsv1.Deployment) bool {
+	if t == nil {
+		return false
+	}
+	template := make(map[string]bool)
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		template[container.Name] = true
+	}
+	for _, container := range deployment.Spec.Template.Spec.InitContainers {
+		template[container.Name] = true
+	}
+
+	injected := make(map[string]containerRequests)
+	record := func(container corev1.Container, running bool) {
+		if template[container.Name] {
+			return
+		}
+		injected[container.Name] = containerRequests{
+			cpu:     container.Resources.Requests.Cpu().MilliValue(),
+			memory:  container.Resources.Requests.Memory().Value(),
+			running: running,
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		record(container, true)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		record(container, restartableInitContainer(container))
+	}
+
+	key := types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	known, ok := t.sidecars[key]
+	if ok && len(known) == len(injected) {
+		unchanged := true
+		for name, requests := range injected {
+			if known[name] != requests {
+				unchanged = false
+				break
+			}
+		}
+		if unchanged {
+			return false
+		}
+	}
+	if len(injected) == 0 {
+		delete(t.sidecars, key)
+	} else {
+		t.sidecars[key] = injected
+	}
+	return true
+}
+
+// Requests returns the CPU (in millicores) and memory of the deployment's sidecars the pod does
+// not have yet. Injected init containers that run to completion before the app starts take no
+// room next to it and are not counted.
+func (t *SidecarTracker) Requests(pod *corev1.Pod, deployment *appsv1.Deployment) (int64, int64) {
+	if t == nil || deployment == nil {
+		return 0, 0
+	}
+	present := make(map[string]bool)
+	for _, container := range pod.Spec.Containers {
+		present[container.Name] = true
+	}
+	for _, container := range pod.Spec.InitContainers {
+		present[container.Name] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cpu, memory int64
+	for name, requests := range t.sidecars[types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}] {
+		if present[name] || !requests.running {
+			continue
+		}
+		cpu += requests.cpu
+		memory += requests.memory
+	}
+	return cpu, memory
+}
+
+// restartableInitContainer reports whether the init container is a native sidecar, which keeps
+// running next to the app containers
+func restartableInitContainer(container corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// RuntimeClassOverhead returns the overhead the pod's RuntimeClass adds to its requests. Pods get
+// it from the RuntimeClass admission plugin, so a pod with an overhead keeps it; pod templates
+// never have one and are resolved through their RuntimeClass. A missing RuntimeClass adds nothing.
+func RuntimeClassOverhead(ctx context.Context, c client.Reader, spec *corev1.PodSpec) (corev1.ResourceList, error) {
+	if spec.Overhead != nil || spec.RuntimeClassName == nil || *spec.RuntimeClassName == "" {
+		return spec.Overhead, nil
+	}
+	runtimeClass := &nodev1.RuntimeClass{}
+	if err := c.Get(ctx, client.ObjectKey{Name: *spec.RuntimeClassName}, runtimeClass); apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get RuntimeClass %s: %w", *spec.RuntimeClassName, err)
+	}
+	if runtimeClass.Overhead == nil {
+		return nil, nil
+	}
+	return runtimeClass.Overhead.PodFixed, nil
+}
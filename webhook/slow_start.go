@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SlowStartAnnotation marks a pod as slow ("true") or quick ("false") to start, overriding detection
+	SlowStartAnnotation = "smart-scheduler.io/slow-start"
+	// ImageSizeAnnotation declares the total size of a pod's images, e.g. "12Gi"
+	ImageSizeAnnotation = "smart-scheduler.io/image-size"
+
+	// DefaultSlowStartImageSize is the total image size from which a pod counts as slow to start
+	DefaultSlowStartImageSize = 5 << 30
+	// imageSizeRefreshInterval is how long the image sizes reported by nodes are reused
+	imageSizeRefreshInterval = 5 * time.Minute
+)
+
+// SlowStartDetector finds pods whose images take long to pull, so they are kept off preemptible
+// pools where they would rarely finish starting before being reclaimed. A pod's image size is
+// declared with ImageSizeAnnotation or learned from the images nodes report having pulled before;
+// init container images count too. A nil detector finds no slow pods.
+type SlowStartDetector struct {
+	Client client.Client
+	Log    logr.Logger
+	// ImageSizeThreshold is the total image size in bytes from which a pod is slow to start
+	ImageSizeThreshold int64
+
+	mu          sync.Mutex
+	sizes       map[string]int64
+	refreshedAt time.Time
+}
+
+// NewSlowStartDetector creates a detector treating pods with threshold bytes of images as slow to start
+func NewSlowStartDetector(client client.Client, threshold int64, log logr.Logger) *SlowStartDetector {
+	return &SlowStartDetector{
+		Client:             client,
+		Log:                log,
+		ImageSizeThreshold: threshold,
+	}
+}
+
+// IsSlowStart reports whether the pod is slow to start. Lookup failures are logged and treated as quick.
+func (d *SlowStartDetector) IsSlowStart(ctx context.Context, pod *corev1.Pod) bool {
+	if d == nil {
+		return false
+	}
+	if value, ok := pod.Annotations[SlowStartAnnotation]; ok {
+		if slow, err := strconv.ParseBool(value); err == nil {
+			return slow
+		}
+	}
+	if value, ok := pod.Annotations[ImageSizeAnnotation]; ok {
+		if size, err := resource.ParseQuantity(value); err == nil {
+			return size.Value() >= d.ImageSizeThreshold
+		}
+		d.Log.Info("Ignoring invalid image size annotation", "pod", pod.Name, "value", value)
+	}
+
+	sizes, err := d.imageSizes(ctx)
+	if err != nil {
+		d.Log.Error(err, "Failed to look up image sizes, treating pod as quick to start", "pod", pod.Name)
+		return false
+	}
+	var total int64
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			total += imageSize(sizes, container.Image)
+		}
+	}
+	return total >= d.ImageSizeThreshold
+}
+
+// imageSizes returns the largest size nodes report for each image name, refreshed every few minutes
+func (d *SlowStartDetector) imageSizes(ctx context.Context) (map[string]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sizes != nil && time.Since(d.refreshedAt) < imageSizeRefreshInterval {
+		return d.sizes, nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := d.Client.List(ctx, nodeList, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	sizes := make(map[string]int64)
+	for i := range nodeList.Items {
+		for _, image := range nodeList.Items[i].Status.Images {
+			for _, name := range image.Names {
+				if image.SizeBytes > sizes[name] {
+					sizes[name] = image.SizeBytes
+				}
+			}
+		}
+	}
+
+	d.sizes, d.refreshedAt = sizes, time.Now()
+	d.Log.V(1).Info("Refreshed image sizes reported by nodes", "images", len(sizes))
+	return sizes, nil
+}
+
+// imageSize returns the size of the image, matching the short names pods use ("nginx:1.25")
+// against the fully qualified names nodes report ("docker.io/library/nginx:1.25")
+func imageSize(sizes map[string]int64, image string) int64 {
+	if size, ok := sizes[image]; ok {
+		return size
+	}
+	for _, name := range []string{"docker.io/" + image, "docker.io/library/" + image} {
+		if size, ok := sizes[name]; ok {
+			return size
+		}
+	}
+	if !strings.ContainsAny(image, ":@") {
+		return imageSize(sizes, image+":latest")
+	}
+	return 0
+}
+
+// HasPreemptibleRules reports whether any rule of the strategy is preemptible
+func (s *PlacementStrategy) HasPreemptibleRules() bool {
+	for _, rule := range s.Rules {
+		if rule.Preemptible {
+			return true
+		}
+	}
+	return false
+}
+
+// AvoidPreemptibleRules drops the preemptible rules of the strategy. The strategy is returned
+// unchanged when every rule is preemptible, since the pod has to run somewhere.
+func AvoidPreemptibleRules(strategy *PlacementStrategy) *PlacementStrategy {
+	return keepRules(strategy, func(rule PlacementRule) bool { return !rule.Preemptible })
+}
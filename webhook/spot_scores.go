@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// SpotScoreWeightsAnnotation holds the weights the spot score controller lowered for a deployment's
+// spot rules whose pools have a poor spot placement score, as a JSON object from rule key to percent
+// of the configured weight, e.g. {"node-type=spot":40}. Rules not listed keep their configured weight.
+const SpotScoreWeightsAnnotation = "smart-scheduler.io/spot-score-weights"
+
+// spotCapacityLabels are the node labels marking spot capacity, with their spot value, across
+// provisioners and clouds. Values are compared case-insensitively, as EKS uses "SPOT".
+var spotCapacityLabels = map[string]string{
+	"karpenter.sh/capacity-type":            "spot",
+	"eks.amazonaws.com/capacityType":        "spot",
+	"node-type":                             "spot",
+	"cloud.google.com/gke-spot":             "true",
+	"cloud.google.com/gke-preemptible":      "true",
+	"kubernetes.azure.com/scalesetpriority": "spot",
+}
+
+// SpotRule reports whether the rule selects spot capacity through one of the well-known capacity
+// type labels
+func SpotRule(rule PlacementRule) bool {
+	for label, spot := range spotCapacityLabels {
+		if value, ok := rule.NodeSelector[label]; ok && strings.EqualFold(value, spot) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpotScorePercent returns the percent of its configured weight a spot rule keeps at a spot
+// placement score from 1 to 10. Scores of at least threshold keep the whole weight; lower scores
+// lose weight linearly down to minPercent at a score of 1.
+func SpotScorePercent(score, threshold, minPercent int) int {
+	if score >= threshold || threshold <= 1 {
+		return 100
+	}
+	score = max(score, 1)
+	return minPercent + (100-minPercent)*(score-1)/(threshold-1)
+}
+
+// ParseSpotScoreWeights parses the spot score weights annotation. Percents outside 0-100 are
+// rejected; an empty annotation lowers no weight.
+func ParseSpotScoreWeights(annotation string) (map[RuleKey]int, error) {
+	return parseWeightPercents(SpotScoreWeightsAnnotation, annotation)
+}
+
+// WithSpotScoreWeights applies the deployment's spot score weights to the strategy, on top of any
+// other weight adjustments. An invalid annotation lowers no weight.
+func WithSpotScoreWeights(deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	weights, err := ParseSpotScoreWeights(deployment.Annotations[SpotScoreWeightsAnnotation])
+	if err != nil {
+		return strategy, err
+	}
+	return ApplyWeightAdjustments(strategy, weights), nil
+}
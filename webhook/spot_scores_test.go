@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSpotScoreWeights(t *testing.T) {
+	strategy, err := ParsePlacementStrategy("base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot;weight=1,nodeSelector=karpenter.sh/capacity-type:spot")
+	if err != nil {
+		t.Fatalf("Failed to parse strategy: %v", err)
+	}
+	var spot []bool
+	for _, rule := range strategy.Rules {
+		spot = append(spot, SpotRule(rule))
+	}
+	if want := []bool{false, true, true}; !reflect.DeepEqual(spot, want) {
+		t.Errorf("SpotRule() = %v, want %v", spot, want)
+	}
+	eks := PlacementRule{NodeSelector: map[string]string{"eks.amazonaws.com/capacityType": "SPOT"}}
+	if !SpotRule(eks) {
+		t.Error("SpotRule() = false for an EKS SPOT node group, want true")
+	}
+
+	for _, tt := range []struct {
+		score, want int
+	}{
+		{10, 100}, {7, 100}, {6, 87}, {4, 62}, {1, 25}, {0, 25},
+	} {
+		if got := SpotScorePercent(tt.score, 7, 25); got != tt.want {
+			t.Errorf("SpotScorePercent(%d) = %d, want %d", tt.score, got, tt.want)
+		}
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		WeightAdjustmentsAnnotation: `{"node-type=spot":50}`,
+		SpotScoreWeightsAnnotation:  `{"node-type=spot":40}`,
+	}}}
+	pm := &PodMutator{WeightTuning: true, SpotScores: true}
+	var weights []int
+	for _, rule := range pm.withWeightAdjustments(deployment, strategy).Rules {
+		weights = append(weights, rule.Weight)
+	}
+	// Both adjustments apply, spot keeping 20% of its weight
+	if want := []int{10000, 6000, 10000}; !reflect.DeepEqual(weights, want) {
+		t.Errorf("weights = %v, want %v", weights, want)
+	}
+}
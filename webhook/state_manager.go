@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -12,6 +13,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -20,15 +22,69 @@ type PlacementState struct {
 	DeploymentName      string             `json:"deploymentName"`
 	DeploymentNamespace string             `json:"deploymentNamespace"`
 	Strategy            *PlacementStrategy `json:"strategy"`
-	PodCounts           map[string]int     `json:"podCounts"`
+	PodCounts           map[RuleKey]int    `json:"podCounts"`
 	LastUpdated         time.Time          `json:"lastUpdated"`
 	TotalPods           int                `json:"totalPods"`
+	// RulePods lists the UIDs of the pods attributed to each rule when the state was last refreshed
+	// from pods, at most MaxRulePods per rule. Pods admitted since are only in PodCounts.
+	RulePods map[RuleKey][]types.UID `json:"rulePods,omitempty"`
+	// Kind is the kind of the workload, empty for a Deployment
+	Kind string `json:"kind,omitempty"`
+	// RemoteIncrements are the pods, already in PodCounts, that replicas not holding the counter
+	// Lease admitted and the holder has not added to its counters yet
+	RemoteIncrements map[RuleKey]int `json:"remoteIncrements,omitempty"`
+}
+
+// PodRules returns the rule each pod listed in RulePods is attributed to. Pods listed under a rule
+// the current strategy no longer has are left out. A nil state attributes no pods.
+func (s *PlacementState) PodRules() map[types.UID]RuleKey {
+	if s == nil || s.Strategy == nil {
+		return nil
+	}
+	rules := make(map[types.UID]RuleKey, s.TotalPods)
+	for _, rule := range s.Strategy.Rules {
+		key := rule.Key()
+		for _, uid := range s.RulePods[key] {
+			rules[uid] = key
+		}
+	}
+	return rules
 }
 
 // StateManager manages placement state using ConfigMaps for atomic updates
 type StateManager struct {
 	Client client.Client
 	Log    logr.Logger
+	// APIReader, when set together with PodListPageSize, makes state refreshes list pods directly
+	// from the API server in pages instead of reading the informer cache
+	APIReader       client.Reader
+	PodListPageSize int64
+	// FlushInterval, when set, buffers pod count increments in memory and writes them in a single
+	// ConfigMap update per deployment every interval. Start must be running for buffered writes.
+	FlushInterval time.Duration
+	// Counters, when set, keeps the counts of each deployment in the memory of the replica holding
+	// its counter Lease. Start must be running to renew, resync and release the Leases.
+	Counters *CounterLeases
+	// Drainer, when set, holds the final flush until the admissions in flight at shutdown finished
+	Drainer *AdmissionDrainer
+
+	mu sync.Mutex
+	// pending holds increments not yet written, keyed by deployment
+	pending map[types.NamespacedName]*pendingIncrements
+	// recounted tracks deployments whose state was rebuilt from pods since this process started,
+	// so increments lost by a crash before their flush are recovered
+	recounted map[types.NamespacedName]bool
+	// stopped is set by the final flush; later increments are written directly
+	stopped bool
+	// locks serializes the reads and writes of each deployment's state within the process
+	locks DeploymentLocks
+}
+
+// pendingIncrements are buffered pod count increments for one deployment
+type pendingIncrements struct {
+	deployment *appsv1.Deployment
+	strategy   *PlacementStrategy
+	counts     map[RuleKey]int
 }
 
 // NewStateManager creates a new state manager
@@ -39,8 +95,47 @@ func NewStateManager(client client.Client, log logr.Logger) *StateManager {
 	}
 }
 
-// GetPlacementState retrieves the current placement state for a deployment
+// LockDeployment waits for the deployment's lock, shared by every user of the manager in the
+// process. Callers reading the state to place a pod hold it until the pod's increment is recorded,
+// so concurrent admissions of the deployment see each other's pods. It returns the function
+// releasing the lock, or the context's error when it ends first.
+func (sm *StateManager) LockDeployment(ctx context.Context, deployment *appsv1.Deployment) (func(), error) {
+	return sm.locks.Lock(ctx, stateKey(deployment))
+}
+
+// GetPlacementState retrieves the current placement state for a deployment, including increments
+// buffered in memory that have not been flushed yet. The counts of a deployment whose counter
+// Lease this replica holds are read from memory. Callers hold the deployment's lock.
 func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, error) {
+	if state := sm.Counters.snapshot(stateKey(deployment)); state != nil {
+		state.Strategy = strategy
+		return state, nil
+	}
+
+	state, recounted, err := sm.loadState(ctx, deployment, strategy)
+	if err != nil {
+		return nil, err
+	}
+	// The recount replaced the buffered increments, so it is stored for the next flush to add to
+	if recounted && sm.FlushInterval > 0 {
+		if err := sm.UpdatePlacementState(ctx, state); err != nil {
+			sm.Log.Error(err, "Failed to store recounted placement state", "deployment", deployment.Name)
+		}
+	}
+
+	sm.addPending(deployment, state)
+	return state, nil
+}
+
+// loadPlacementState reads the stored placement state, creating or refreshing it from pods as needed
+func (sm *StateManager) loadPlacementState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, error) {
+	state, _, err := sm.loadState(ctx, deployment, strategy)
+	return state, err
+}
+
+// loadState is loadPlacementState, also reporting whether the counts were rebuilt from pods. A
+// rebuild counts the pods of the buffered increments, so it drops them from the buffer.
+func (sm *StateManager) loadState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, bool, error) {
 	configMapName := sm.getConfigMapName(deployment)
 
 	// Try to get existing ConfigMap
@@ -52,9 +147,9 @@ func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv
 
 	if apierrors.IsNotFound(err) {
 		// ConfigMap doesn't exist, create initial state
-		return sm.createInitialState(ctx, deployment, strategy)
+		return sm.recreateState(ctx, deployment, strategy)
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to get placement state ConfigMap: %w", err)
+		return nil, false, fmt.Errorf("failed to get placement state ConfigMap: %w", err)
 	}
 
 	// Parse existing state
@@ -62,7 +157,7 @@ func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv
 	if !exists {
 		sm.Log.Info("ConfigMap exists but no placement-state data, recreating",
 			"configMap", configMapName)
-		return sm.createInitialState(ctx, deployment, strategy)
+		return sm.recreateState(ctx, deployment, strategy)
 	}
 
 	var state PlacementState
@@ -70,16 +165,50 @@ func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv
 	if err != nil {
 		sm.Log.Error(err, "Failed to unmarshal placement state, recreating",
 			"configMap", configMapName)
-		return sm.createInitialState(ctx, deployment, strategy)
+		return sm.recreateState(ctx, deployment, strategy)
+	}
+
+	// States written before rule keys were canonical may key multi-key selectors in any pair order
+	if counts, migrated := migrateRuleKeys(state.PodCounts); migrated {
+		sm.Log.Info("Migrated legacy rule keys in placement state",
+			"configMap", configMapName,
+			"oldCounts", state.PodCounts,
+			"newCounts", counts)
+		state.PodCounts = counts
+	}
+	// Counts kept under the selectors of relabeled nodes belong to the renamed rules
+	if state.PodCounts != nil && rekeyRenamedRules(&state, strategy) {
+		sm.Log.Info("Moved placement state of renamed rules to their new selectors",
+			"configMap", configMapName,
+			"newCounts", state.PodCounts)
 	}
 
 	// Update strategy if it has changed
 	state.Strategy = strategy
+	state.Kind = stateKind(deployment)
+
+	// With buffered writes, the persisted state may be missing increments lost by a previous crash,
+	// so the first read of each deployment rebuilds the counts from its pods
+	recount := sm.FlushInterval > 0 && sm.needsRecount(deployment)
+	if recount {
+		state.LastUpdated = time.Time{}
+	}
 
 	// Only refresh pod counts if the state is older than 30 seconds
 	// This prevents race conditions during rapid pod creation
-	if time.Since(state.LastUpdated) > 30*time.Second {
-		actualCounts, err := sm.getCurrentPodCounts(ctx, deployment, strategy)
+	recounted := false
+	if time.Since(state.LastUpdated) > 30*time.Second && sm.countsUnchanged(ctx, deployment, &state) {
+		// The ReplicaSets report the same number of pods and every rule is tracked, so the
+		// cached per-rule attribution is still valid and the pod list can be skipped
+		sm.Log.Info("Placement state totals match ReplicaSet status, skipping pod refresh",
+			"deployment", deployment.Name,
+			"totalPods", state.TotalPods)
+		state.LastUpdated = time.Now()
+		if recount {
+			sm.markRecounted(deployment)
+		}
+	} else if time.Since(state.LastUpdated) > 30*time.Second {
+		actualCounts, rulePods, err := sm.getCurrentPodCounts(ctx, deployment, strategy)
 		if err != nil {
 			sm.Log.Error(err, "Failed to get actual pod counts, using cached counts")
 		} else {
@@ -89,11 +218,15 @@ func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv
 				"oldCounts", state.PodCounts,
 				"newCounts", actualCounts)
 			state.PodCounts = actualCounts
+			state.RulePods = rulePods
 			state.TotalPods = 0
 			for _, count := range actualCounts {
 				state.TotalPods += count
 			}
 			state.LastUpdated = time.Now()
+			sm.clearPending(deployment)
+			sm.markRecounted(deployment)
+			recounted = true
 		}
 	} else {
 		sm.Log.Info("Using cached placement state",
@@ -102,12 +235,41 @@ func (sm *StateManager) GetPlacementState(ctx context.Context, deployment *appsv
 			"counts", state.PodCounts)
 	}
 
-	return &state, nil
+	return &state, recounted, nil
+}
+
+// recreateState creates the state from pods, dropping the buffered increments it counts
+func (sm *StateManager) recreateState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, bool, error) {
+	state, err := sm.createInitialState(ctx, deployment, strategy)
+	if err != nil {
+		return nil, false, err
+	}
+	sm.clearPending(deployment)
+	sm.markRecounted(deployment)
+	return state, true, nil
+}
+
+// Warm loads the deployment's placement state so its informers are synced before admissions need
+// them. With buffered writes the first load after a restart recounts pods; the result is stored
+// so admissions find fresh counts.
+func (sm *StateManager) Warm(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) error {
+	unlock, err := sm.LockDeployment(ctx, deployment)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, recounted, err := sm.loadState(ctx, deployment, strategy)
+	if err != nil || !recounted {
+		return err
+	}
+	return sm.UpdatePlacementState(ctx, state)
 }
 
 // UpdatePlacementState atomically updates the placement state
 func (sm *StateManager) UpdatePlacementState(ctx context.Context, state *PlacementState) error {
 	configMapName := sm.getConfigMapName(&appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: state.Kind},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      state.DeploymentName,
 			Namespace: state.DeploymentNamespace,
@@ -128,11 +290,7 @@ func (sm *StateManager) UpdatePlacementState(ctx context.Context, state *Placeme
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      configMapName,
 			Namespace: state.DeploymentNamespace,
-			Labels: map[string]string{
-				"app.kubernetes.io/name":        "smart-scheduler",
-				"app.kubernetes.io/component":   "placement-state",
-				"smart-scheduler.io/deployment": state.DeploymentName,
-			},
+			Labels:    stateLabels(state.Kind, state.DeploymentName),
 		},
 		Data: map[string]string{
 			"placement-state": string(stateData),
@@ -156,10 +314,17 @@ func (sm *StateManager) UpdatePlacementState(ctx context.Context, state *Placeme
 	} else if err != nil {
 		return fmt.Errorf("failed to get existing placement state ConfigMap: %w", err)
 	} else {
-		// Update existing ConfigMap
+		// Update existing ConfigMap, keeping the entries controllers store next to the state
 		configMap.ObjectMeta.ResourceVersion = existing.ObjectMeta.ResourceVersion
+		for key, value := range existing.Data {
+			if _, ok := configMap.Data[key]; !ok {
+				configMap.Data[key] = value
+			}
+		}
 		err = sm.Client.Update(ctx, configMap)
-		if err != nil {
+		if apierrors.IsConflict(err) {
+			return Classify(ErrStateConflict, fmt.Errorf("failed to update placement state ConfigMap: %w", err))
+		} else if err != nil {
 			return fmt.Errorf("failed to update placement state ConfigMap: %w", err)
 		}
 	}
@@ -167,36 +332,58 @@ func (sm *StateManager) UpdatePlacementState(ctx context.Context, state *Placeme
 	return nil
 }
 
-// IncrementPodCount atomically increments the count for a specific rule
-func (sm *StateManager) IncrementPodCount(ctx context.Context, deployment *appsv1.Deployment, ruleKey string) error {
+// IncrementPodCount atomically increments the count for a specific rule of the strategy applied to the pod.
+// With a FlushInterval the increment is buffered and written by the next flush, unless the
+// final flush already ran during shutdown. Held counters are incremented in memory as well.
+// Callers hold the deployment's lock.
+func (sm *StateManager) IncrementPodCount(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy, ruleKey RuleKey) error {
+	sm.Counters.increment(stateKey(deployment), ruleKey)
+	if sm.FlushInterval > 0 && sm.bufferIncrement(deployment, strategy, ruleKey) {
+		return nil
+	}
+
+	return sm.applyIncrements(ctx, deployment, strategy, map[RuleKey]int{ruleKey: 1}, false)
+}
+
+// applyIncrements adds the given per-rule increments to the stored state, retrying on conflicts.
+// Buffered increments are of pods created since, so they are not added to counts rebuilt from pods.
+func (sm *StateManager) applyIncrements(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy, increments map[RuleKey]int, buffered bool) error {
 	maxRetries := 3
 
 	for i := 0; i < maxRetries; i++ {
 		// Get current state
-		strategy, err := ParsePlacementStrategy(deployment.Annotations["smart-scheduler.io/schedule-strategy"])
-		if err != nil {
-			return fmt.Errorf("failed to parse strategy: %w", err)
-		}
-
-		state, err := sm.GetPlacementState(ctx, deployment, strategy)
+		state, recounted, err := sm.loadState(ctx, deployment, strategy)
 		if err != nil {
 			return fmt.Errorf("failed to get placement state: %w", err)
 		}
 
-		// Increment count
+		// Increment counts, unless the pods of buffered increments were just counted
 		if state.PodCounts == nil {
-			state.PodCounts = make(map[string]int)
+			state.PodCounts = make(map[RuleKey]int)
+		}
+		if !buffered || !recounted {
+			for ruleKey, increment := range increments {
+				state.PodCounts[ruleKey] += increment
+				state.TotalPods += increment
+			}
+		}
+		// The holder of the counter Lease adds the pods of other replicas to its counters
+		if sm.Counters != nil && !sm.Counters.Holds(deployment) {
+			if state.RemoteIncrements == nil {
+				state.RemoteIncrements = make(map[RuleKey]int)
+			}
+			for ruleKey, increment := range increments {
+				state.RemoteIncrements[ruleKey] += increment
+			}
 		}
-		state.PodCounts[ruleKey]++
-		state.TotalPods++
 
 		// Try to update
 		err = sm.UpdatePlacementState(ctx, state)
 		if err == nil {
-			sm.Log.Info("Successfully incremented pod count",
+			sm.Log.Info("Successfully incremented pod counts",
 				"deployment", deployment.Name,
-				"ruleKey", ruleKey,
-				"newCount", state.PodCounts[ruleKey])
+				"increments", increments,
+				"newCounts", state.PodCounts)
 			return nil
 		}
 
@@ -211,12 +398,204 @@ func (sm *StateManager) IncrementPodCount(ctx context.Context, deployment *appsv
 		return err
 	}
 
-	return fmt.Errorf("failed to increment pod count after %d retries", maxRetries)
+	return Classify(ErrStateConflict, fmt.Errorf("failed to increment pod count after %d retries", maxRetries))
+}
+
+// bufferIncrement records an increment to be written by the next flush. It returns false once
+// the manager is stopping, as no flush would write it.
+func (sm *StateManager) bufferIncrement(deployment *appsv1.Deployment, strategy *PlacementStrategy, ruleKey RuleKey) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.stopped {
+		return false
+	}
+
+	if sm.pending == nil {
+		sm.pending = make(map[types.NamespacedName]*pendingIncrements)
+	}
+	key := stateKey(deployment)
+	p, ok := sm.pending[key]
+	if !ok {
+		p = &pendingIncrements{counts: make(map[RuleKey]int)}
+		sm.pending[key] = p
+	}
+	p.deployment = deployment
+	p.strategy = strategy
+	p.counts[ruleKey]++
+	return true
+}
+
+// addPending adds the deployment's buffered increments to the state so admissions between
+// flushes see every pod placed so far
+func (sm *StateManager) addPending(deployment *appsv1.Deployment, state *PlacementState) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	p, ok := sm.pending[stateKey(deployment)]
+	if !ok {
+		return
+	}
+	if state.PodCounts == nil {
+		state.PodCounts = make(map[RuleKey]int)
+	}
+	for ruleKey, increment := range p.counts {
+		state.PodCounts[ruleKey] += increment
+		state.TotalPods += increment
+	}
+}
+
+// clearPending drops the deployment's buffered increments after its counts were rebuilt from
+// pods, which include the pods the increments were buffered for
+func (sm *StateManager) clearPending(deployment *appsv1.Deployment) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.pending, stateKey(deployment))
+}
+
+// needsRecount reports whether the deployment's state has not been rebuilt from pods yet. A failed
+// recount leaves it unmarked, so the next read retries.
+func (sm *StateManager) needsRecount(deployment *appsv1.Deployment) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return !sm.recounted[stateKey(deployment)]
+}
+
+// markRecounted records that the deployment's state was created or rebuilt from pods and needs no recount
+func (sm *StateManager) markRecounted(deployment *appsv1.Deployment) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.recounted == nil {
+		sm.recounted = make(map[types.NamespacedName]bool)
+	}
+	sm.recounted[stateKey(deployment)] = true
+}
+
+// Flush writes all buffered increments, one ConfigMap update per deployment.
+// Increments that fail to be written are kept for the next flush.
+func (sm *StateManager) Flush(ctx context.Context) {
+	sm.mu.Lock()
+	keys := make([]types.NamespacedName, 0, len(sm.pending))
+	for key := range sm.pending {
+		keys = append(keys, key)
+	}
+	sm.mu.Unlock()
+
+	for _, key := range keys {
+		unlock, err := sm.locks.Lock(ctx, key)
+		if err != nil {
+			sm.Log.Error(err, "Failed to flush placement state, keeping increments for the next flush",
+				"deployment", key.String())
+			continue
+		}
+
+		// The increments are taken and dropped under the deployment's lock, so no admission
+		// sees them both in the stored state and in the buffer
+		batch := sm.pendingBatch(key)
+		if batch == nil {
+			unlock()
+			continue
+		}
+		err = sm.applyIncrements(ctx, batch.deployment, batch.strategy, batch.counts, true)
+		if err == nil {
+			sm.dropPending(key, batch.counts)
+		}
+		unlock()
+		if err != nil {
+			sm.Log.Error(err, "Failed to flush placement state, keeping increments for the next flush",
+				"deployment", key.String(), "increments", batch.counts)
+		}
+	}
+}
+
+// pendingBatch returns a copy of the deployment's buffered increments, nil when there are none
+func (sm *StateManager) pendingBatch(key types.NamespacedName) *pendingIncrements {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	p, ok := sm.pending[key]
+	if !ok || len(p.counts) == 0 {
+		return nil
+	}
+	counts := make(map[RuleKey]int, len(p.counts))
+	for ruleKey, increment := range p.counts {
+		counts[ruleKey] = increment
+	}
+	return &pendingIncrements{deployment: p.deployment, strategy: p.strategy, counts: counts}
+}
+
+// dropPending removes written increments from the deployment's buffer
+func (sm *StateManager) dropPending(key types.NamespacedName, counts map[RuleKey]int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	p, ok := sm.pending[key]
+	if !ok {
+		return
+	}
+	for ruleKey, increment := range counts {
+		p.counts[ruleKey] -= increment
+		if p.counts[ruleKey] <= 0 {
+			delete(p.counts, ruleKey)
+		}
+	}
+	if len(p.counts) == 0 {
+		delete(sm.pending, key)
+	}
+}
+
+// Start flushes buffered increments every FlushInterval and, with Counters, maintains the held
+// counter Leases at least every third of their duration. Once the context is cancelled and the
+// Drainer drained the admissions in flight, it flushes a last time and releases the Leases. It
+// implements manager.Runnable.
+func (sm *StateManager) Start(ctx context.Context) error {
+	if sm.FlushInterval <= 0 && sm.Counters == nil {
+		return nil
+	}
+
+	interval := sm.FlushInterval
+	if sm.Counters != nil {
+		if maintain := sm.Counters.leaseDuration() / 3; interval <= 0 || maintain < interval {
+			interval = maintain
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sm.Flush(ctx)
+			if sm.Counters != nil {
+				sm.maintainCounters(ctx)
+			}
+		case <-ctx.Done():
+			<-sm.Drainer.Drained()
+			sm.mu.Lock()
+			sm.stopped = true
+			sm.mu.Unlock()
+
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			sm.Flush(flushCtx)
+			if sm.Counters != nil {
+				sm.releaseCounters(flushCtx)
+			}
+			cancel()
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection returns false because every webhook replica buffers its own increments and
+// holds its own counter Leases
+func (sm *StateManager) NeedLeaderElection() bool {
+	return false
 }
 
 // createInitialState creates initial placement state by counting existing pods
 func (sm *StateManager) createInitialState(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementState, error) {
-	counts, err := sm.getCurrentPodCounts(ctx, deployment, strategy)
+	counts, rulePods, err := sm.getCurrentPodCounts(ctx, deployment, strategy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get initial pod counts: %w", err)
 	}
@@ -229,10 +608,12 @@ func (sm *StateManager) createInitialState(ctx context.Context, deployment *apps
 	state := &PlacementState{
 		DeploymentName:      deployment.Name,
 		DeploymentNamespace: deployment.Namespace,
+		Kind:                stateKind(deployment),
 		Strategy:            strategy,
 		PodCounts:           counts,
 		LastUpdated:         time.Now(),
 		TotalPods:           totalPods,
+		RulePods:            rulePods,
 	}
 
 	// Save initial state
@@ -245,63 +626,234 @@ func (sm *StateManager) createInitialState(ctx context.Context, deployment *apps
 	return state, nil
 }
 
-// getCurrentPodCounts gets the current pod distribution for a deployment
-func (sm *StateManager) getCurrentPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (map[string]int, error) {
-	counts := make(map[string]int)
+// countsUnchanged reports whether the cached state still covers every rule, lists the pods it
+// counts, and its total matches the pod count reported by the deployment's ReplicaSets
+func (sm *StateManager) countsUnchanged(ctx context.Context, deployment *appsv1.Deployment, state *PlacementState) bool {
+	for _, rule := range state.Strategy.Rules {
+		count, ok := state.PodCounts[rule.Key()]
+		if !ok {
+			return false
+		}
+		// Pods admitted since the last refresh are counted but not listed yet
+		if len(state.RulePods[rule.Key()]) < min(count, MaxRulePods) {
+			return false
+		}
+	}
 
-	// Initialize counts for all rules
-	for _, rule := range strategy.Rules {
-		ruleKey := nodeSelector2String(rule.NodeSelector)
-		counts[ruleKey] = 0
+	// A pod selector leaves pods out of the counts, so the ReplicaSets' total cannot confirm them
+	if _, selected := deployment.Annotations[PodSelectorAnnotation]; selected {
+		return false
 	}
 
-	// Get all pods for this deployment
-	podList := &corev1.PodList{}
+	total, err := CountDeploymentPods(ctx, sm.Client, deployment)
+	if err != nil {
+		sm.Log.Error(err, "Failed to count pods from ReplicaSet status", "deployment", deployment.Name)
+		return false
+	}
 
-	// Create label selector from deployment
-	labelSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
+	return total == state.TotalPods
+}
 
-	err := sm.Client.List(ctx, podList, &client.ListOptions{
-		Namespace:     deployment.Namespace,
-		LabelSelector: labelSelector,
-	})
+// listDeploymentPods lists the deployment's pods from the cache, or page by page from the API server
+// when a page size is configured
+func (sm *StateManager) listDeploymentPods(ctx context.Context, deployment *appsv1.Deployment) ([]corev1.Pod, error) {
+	if sm.APIReader == nil || sm.PodListPageSize <= 0 {
+		// The pods are only counted, so skip the cache's deep copy
+		return ListStrategyPods(ctx, sm.Client, deployment, client.UnsafeDisableDeepCopy)
+	}
+	pods, err := ListDeploymentPodsPaged(ctx, sm.Client, sm.APIReader, deployment, sm.PodListPageSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
+		return nil, err
 	}
+	return SelectStrategyPods(deployment, pods), nil
+}
 
-	// Count pods by their nodeSelector
-	for _, pod := range podList.Items {
-		// Skip pods that are being deleted
-		if pod.DeletionTimestamp != nil {
-			continue
-		}
+// getCurrentPodCounts gets the current pod distribution for a deployment and the pods of each rule
+func (sm *StateManager) getCurrentPodCounts(ctx context.Context, deployment *appsv1.Deployment, strategy *PlacementStrategy) (map[RuleKey]int, map[RuleKey][]types.UID, error) {
+	// Get all pods for this deployment
+	pods, err := sm.listDeploymentPods(ctx, deployment)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Skip pods that are not running or pending
-		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
-			continue
-		}
+	return CountPodsByRule(pods, strategy), AttributePodsByRule(pods, strategy, MaxRulePods), nil
+}
+
+// ReadPlacementState returns the placement state stored for the named deployment as it is, without
+// refreshing it from pods. It returns nil without an error when no state is stored.
+func (sm *StateManager) ReadPlacementState(ctx context.Context, namespace, name string) (*PlacementState, error) {
+	configMap := &corev1.ConfigMap{}
+	err := sm.Client.Get(ctx, client.ObjectKey{
+		Namespace: namespace,
+		Name:      sm.getConfigMapName(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name}}),
+	}, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get placement state ConfigMap: %w", err)
+	}
+
+	stateData, exists := configMap.Data["placement-state"]
+	if !exists {
+		return nil, nil
+	}
+	var state PlacementState
+	if err := json.Unmarshal([]byte(stateData), &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal placement state: %w", err)
+	}
+	if counts, migrated := migrateRuleKeys(state.PodCounts); migrated {
+		state.PodCounts = counts
+	}
+	return &state, nil
+}
+
+// readStoredState returns the workload's placement state ConfigMap and the state it stores as is,
+// both nil when none is stored
+func (sm *StateManager) readStoredState(ctx context.Context, deployment *appsv1.Deployment) (*corev1.ConfigMap, *PlacementState, error) {
+	configMap := &corev1.ConfigMap{}
+	err := sm.Client.Get(ctx, client.ObjectKey{Namespace: deployment.Namespace, Name: sm.getConfigMapName(deployment)}, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to get placement state ConfigMap: %w", err)
+	}
+
+	stateData, exists := configMap.Data["placement-state"]
+	if !exists {
+		return nil, nil, nil
+	}
+	var state PlacementState
+	if err := json.Unmarshal([]byte(stateData), &state); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal placement state: %w", err)
+	}
+	return configMap, &state, nil
+}
 
-		// Convert pod's nodeSelector to string key
-		podKey := nodeSelector2String(pod.Spec.NodeSelector)
+// ReadStateEntry returns the value controllers stored under key in the workload's placement state
+// ConfigMap, empty when there is none
+func (sm *StateManager) ReadStateEntry(ctx context.Context, deployment *appsv1.Deployment, key string) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := sm.Client.Get(ctx, client.ObjectKey{Namespace: deployment.Namespace, Name: sm.getConfigMapName(deployment)}, configMap)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get placement state ConfigMap: %w", err)
+	}
+	return configMap.Data[key], nil
+}
 
-		// Find matching rule
-		for _, rule := range strategy.Rules {
-			ruleKey := nodeSelector2String(rule.NodeSelector)
-			if podKey == ruleKey || isNodeSelectorSubset(rule.NodeSelector, pod.Spec.NodeSelector) {
-				counts[ruleKey]++
-				break
+// WriteStateEntry stores value under key in the workload's placement state ConfigMap, creating it
+// when needed. An empty value removes the key. Controllers keep their bookkeeping of a workload
+// there rather than on the workload, where GitOps tools would see it as drift.
+func (sm *StateManager) WriteStateEntry(ctx context.Context, deployment *appsv1.Deployment, key, value string) error {
+	maxRetries := 3
+
+	for i := 0; i < maxRetries; i++ {
+		configMap := &corev1.ConfigMap{}
+		err := sm.Client.Get(ctx, client.ObjectKey{Namespace: deployment.Namespace, Name: sm.getConfigMapName(deployment)}, configMap)
+		if apierrors.IsNotFound(err) {
+			if value == "" {
+				return nil
+			}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      sm.getConfigMapName(deployment),
+					Namespace: deployment.Namespace,
+					Labels:    stateLabels(stateKind(deployment), deployment.Name),
+				},
+				Data: map[string]string{key: value},
+			}
+			err = sm.Client.Create(ctx, configMap)
+		} else if err != nil {
+			return fmt.Errorf("failed to get placement state ConfigMap: %w", err)
+		} else {
+			if configMap.Data[key] == value {
+				return nil
 			}
+			if configMap.Data == nil {
+				configMap.Data = make(map[string]string)
+			}
+			if value == "" {
+				delete(configMap.Data, key)
+			} else {
+				configMap.Data[key] = value
+			}
+			err = sm.Client.Update(ctx, configMap)
 		}
+
+		// The webhook writes the state concurrently, so retry on conflicts
+		if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to write %s to placement state ConfigMap: %w", key, err)
+		}
+		return nil
 	}
 
-	return counts, nil
+	return Classify(ErrStateConflict, fmt.Errorf("failed to write %s to placement state ConfigMap after %d retries", key, maxRetries))
 }
 
 // getConfigMapName generates a consistent ConfigMap name for a deployment
 func (sm *StateManager) getConfigMapName(deployment *appsv1.Deployment) string {
+	if IsStatefulSet(deployment) {
+		return fmt.Sprintf("smart-scheduler-statefulset-%s", deployment.Name)
+	}
 	return fmt.Sprintf("smart-scheduler-%s", deployment.Name)
 }
 
+// StatefulSetStateLabel names the StatefulSet a placement state ConfigMap belongs to, as
+// smart-scheduler.io/deployment names the Deployment of the others
+const StatefulSetStateLabel = "smart-scheduler.io/statefulset"
+
+// stateOwnerLabel returns the label naming the workload of a placement state of the kind
+func stateOwnerLabel(kind string) string {
+	if kind == WorkloadStatefulSet {
+		return StatefulSetStateLabel
+	}
+	return "smart-scheduler.io/deployment"
+}
+
+// stateLabels returns the labels of the placement state ConfigMap of a workload of the kind
+func stateLabels(kind, name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":      "smart-scheduler",
+		"app.kubernetes.io/component": "placement-state",
+		stateOwnerLabel(kind):         name,
+	}
+}
+
+// stateKind returns the kind recorded in the workload's placement state
+func stateKind(deployment *appsv1.Deployment) string {
+	if IsStatefulSet(deployment) {
+		return WorkloadStatefulSet
+	}
+	return ""
+}
+
+// stateKey keys the workload's lock and buffers; StatefulSets get their own keys, apart from
+// Deployments of the same name
+func stateKey(deployment *appsv1.Deployment) types.NamespacedName {
+	if IsStatefulSet(deployment) {
+		return types.NamespacedName{Namespace: deployment.Namespace, Name: "statefulset/" + deployment.Name}
+	}
+	return types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}
+}
+
+// DeleteStatefulSetState deletes the placement state of the named StatefulSet
+func (sm *StateManager) DeleteStatefulSetState(ctx context.Context, namespace, name string) error {
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: namespace,
+		Name: sm.getConfigMapName(&appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: WorkloadStatefulSet},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}),
+	}}
+	if err := sm.Client.Delete(ctx, configMap); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete placement state ConfigMap: %w", err)
+	}
+	return nil
+}
+
 // CleanupStaleStates removes ConfigMaps for deleted deployments
 func (sm *StateManager) CleanupStaleStates(ctx context.Context, namespace string) error {
 	// List all smart-scheduler ConfigMaps in the namespace
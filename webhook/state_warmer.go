@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultWarmupTimeout bounds the startup warm-up; the webhook reports ready after it either way
+	DefaultWarmupTimeout = time.Minute
+	// warmupWorkers is the number of deployments warmed in parallel
+	warmupWorkers = 4
+)
+
+var stateWarmupSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "smart_scheduler_state_warmup_seconds",
+	Help: "Time spent loading managed deployments' strategies and placement state at startup",
+})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(stateWarmupSeconds)
+}
+
+// StateWarmer loads the strategy and placement state of every managed deployment when the manager
+// starts, and keeps the webhook unready until it is done. The first admissions after a restart then
+// find parsed strategies, synced informers and recounted state instead of paying for them.
+// It implements manager.Runnable.
+type StateWarmer struct {
+	Client       client.Client
+	StateManager *StateManager
+	Log          logr.Logger
+	// Timeout bounds the warm-up (default: DefaultWarmupTimeout)
+	Timeout time.Duration
+
+	done atomic.Bool
+}
+
+// NewStateWarmer returns a warmer loading state through stateManager within timeout
+func NewStateWarmer(c client.Client, stateManager *StateManager, timeout time.Duration, log logr.Logger) *StateWarmer {
+	return &StateWarmer{Client: c, StateManager: stateManager, Timeout: timeout, Log: log}
+}
+
+// Start warms every managed deployment, then waits for the manager to stop
+func (w *StateWarmer) Start(ctx context.Context) error {
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWarmupTimeout
+	}
+	warmCtx, cancel := context.WithTimeout(ctx, timeout)
+	start := time.Now()
+	warmed, failed := w.warm(warmCtx)
+	cancel()
+
+	duration := time.Since(start)
+	stateWarmupSeconds.Set(duration.Seconds())
+	w.done.Store(true)
+
+	if errors.Is(warmCtx.Err(), context.DeadlineExceeded) {
+		w.Log.Info("Placement state warm-up timed out, remaining deployments load on first admission",
+			"warmed", warmed, "failed", failed, "timeout", timeout.String())
+	} else {
+		w.Log.Info("Placement state warm-up complete", "warmed", warmed, "failed", failed, "duration", duration.String())
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// warm loads every deployment carrying a strategy, returning how many were loaded and how many failed
+func (w *StateWarmer) warm(ctx context.Context) (int, int) {
+	deployments := &appsv1.DeploymentList{}
+	if err := w.Client.List(ctx, deployments); err != nil {
+		w.Log.Error(err, "Failed to list deployments for warm-up")
+		return 0, 0
+	}
+
+	work := make(chan *appsv1.Deployment)
+	var warmed, failed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < warmupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for deployment := range work {
+				if err := w.warmDeployment(ctx, deployment); err != nil {
+					w.Log.Error(err, "Failed to warm placement state", "deployment", deployment.Namespace+"/"+deployment.Name)
+					failed.Add(1)
+					continue
+				}
+				warmed.Add(1)
+			}
+		}()
+	}
+
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		if !HasScheduleStrategy(deployment.Annotations) {
+			continue
+		}
+		select {
+		case work <- deployment:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return int(warmed.Load()), int(failed.Load())
+}
+
+// warmDeployment parses the deployment's strategies and loads its placement state
+func (w *StateWarmer) warmDeployment(ctx context.Context, deployment *appsv1.Deployment) error {
+	if tiers, ok := deployment.Annotations[PriorityStrategiesAnnotation]; ok {
+		if _, err := ParsePriorityStrategies(tiers); err != nil {
+			return err
+		}
+	}
+
+	annotation, ok := deployment.Annotations[ScheduleStrategyAnnotation]
+	if !ok || annotation == "" {
+		return nil
+	}
+	strategy, err := ParsePlacementStrategyCached(annotation)
+	if err != nil {
+		return err
+	}
+	return w.StateManager.Warm(ctx, deployment, strategy)
+}
+
+// NeedLeaderElection returns false because every webhook replica warms its own caches
+func (w *StateWarmer) NeedLeaderElection() bool {
+	return false
+}
+
+// Checker is a readiness check that fails until the warm-up finished or timed out
+func (w *StateWarmer) Checker(_ *http.Request) error {
+	if !w.done.Load() {
+		return errors.New("placement state warm-up in progress")
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultStrategyCacheSize is the number of distinct strategy annotations kept parsed in memory
+const DefaultStrategyCacheSize = 512
+
+var (
+	strategyCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "smart_scheduler_strategy_cache_hits_total",
+		Help: "Number of placement strategy lookups served from the parse cache",
+	})
+	strategyCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "smart_scheduler_strategy_cache_misses_total",
+		Help: "Number of placement strategy lookups that required parsing the annotation",
+	})
+	strategyCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "smart_scheduler_strategy_cache_evictions_total",
+		Help: "Number of parsed placement strategies evicted from the parse cache",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(strategyCacheHits, strategyCacheMisses, strategyCacheEvictions)
+}
+
+// sharedStrategyCache is used by the webhook and the controllers so each annotation is parsed once
+var sharedStrategyCache = NewStrategyCache(DefaultStrategyCacheSize)
+
+// strategyCacheEntry is a parsed strategy stored in the LRU list
+type strategyCacheEntry struct {
+	annotation string
+	strategy   *PlacementStrategy
+}
+
+// StrategyCache is a size-bounded LRU cache of parsed placement strategies keyed by annotation string.
+// Only successfully parsed strategies are cached.
+type StrategyCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewStrategyCache creates a strategy cache holding at most size entries
+func NewStrategyCache(size int) *StrategyCache {
+	if size <= 0 {
+		size = DefaultStrategyCacheSize
+	}
+	return &StrategyCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Parse returns the parsed strategy for the annotation, parsing and caching it on a miss.
+// Callers get their own copy and may modify it freely.
+func (sc *StrategyCache) Parse(annotation string) (*PlacementStrategy, error) {
+	sc.mu.Lock()
+	if elem, ok := sc.entries[annotation]; ok {
+		sc.order.MoveToFront(elem)
+		strategy := elem.Value.(*strategyCacheEntry).strategy
+		sc.mu.Unlock()
+		strategyCacheHits.Inc()
+		return strategy.DeepCopy(), nil
+	}
+	sc.mu.Unlock()
+
+	strategyCacheMisses.Inc()
+	strategy, err := ParsePlacementStrategy(annotation)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// Another caller may have parsed the same annotation meanwhile
+	if elem, ok := sc.entries[annotation]; ok {
+		sc.order.MoveToFront(elem)
+		return strategy, nil
+	}
+
+	sc.entries[annotation] = sc.order.PushFront(&strategyCacheEntry{annotation: annotation, strategy: strategy.DeepCopy()})
+	for sc.order.Len() > sc.size {
+		oldest := sc.order.Back()
+		sc.order.Remove(oldest)
+		delete(sc.entries, oldest.Value.(*strategyCacheEntry).annotation)
+		strategyCacheEvictions.Inc()
+	}
+
+	return strategy, nil
+}
+
+// Len returns the number of cached strategies
+func (sc *StrategyCache) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.order.Len()
+}
+
+// SetStrategyCacheSize replaces the shared strategy cache with an empty one of the given size.
+// It is meant to be called once during startup.
+func SetStrategyCacheSize(size int) {
+	sharedStrategyCache = NewStrategyCache(size)
+}
+
+// ParsePlacementStrategyCached parses the annotation through the shared LRU cache
+func ParsePlacementStrategyCached(annotation string) (*PlacementStrategy, error) {
+	return sharedStrategyCache.Parse(annotation)
+}
+
+// DeepCopy returns a copy of the strategy that shares no maps or slices with the original
+func (s *PlacementStrategy) DeepCopy() *PlacementStrategy {
+	if s == nil {
+		return nil
+	}
+
+	out := &PlacementStrategy{
+		Base:        s.Base,
+		Mode:        s.Mode,
+		BasePercent: s.BasePercent,
+		BaseFrom:    s.BaseFrom,
+		Rules:       make([]PlacementRule, len(s.Rules)),
+	}
+	for i, rule := range s.Rules {
+		out.Rules[i] = rule
+		out.Rules[i].NodeSelector = copyStringMap(rule.NodeSelector)
+		if rule.Tolerations != nil {
+			out.Rules[i].Tolerations = make([]corev1.Toleration, len(rule.Tolerations))
+			for j := range rule.Tolerations {
+				rule.Tolerations[j].DeepCopyInto(&out.Rules[i].Tolerations[j])
+			}
+		}
+		if rule.NodeAffinity != nil {
+			out.Rules[i].NodeAffinity = make([]corev1.NodeSelectorRequirement, len(rule.NodeAffinity))
+			for j := range rule.NodeAffinity {
+				rule.NodeAffinity[j].DeepCopyInto(&out.Rules[i].NodeAffinity[j])
+			}
+		}
+		if rule.Affinity != nil {
+			out.Rules[i].Affinity = make([]AffinityRule, len(rule.Affinity))
+			for j, affinity := range rule.Affinity {
+				affinity.LabelSelector = copyStringMap(affinity.LabelSelector)
+				out.Rules[i].Affinity[j] = affinity
+			}
+		}
+	}
+
+	return out
+}
+
+// copyStringMap returns a copy of m, preserving nil
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import "fmt"
+
+// Reasons of strategy lint findings, used as the reasons of the Warning events they are reported as
+const (
+	// LintZeroWeight flags a weighted strategy whose rules all have weight 0, so pods beyond the base
+	// are left to the scheduler
+	LintZeroWeight = "StrategyZeroWeight"
+	// LintBaseExceedsReplicas flags a base larger than the deployment's replicas, so every pod goes to
+	// the first rule and the weights never apply
+	LintBaseExceedsReplicas = "StrategyBaseExceedsReplicas"
+	// LintSameSelector flags a strategy whose rules all select the same nodes, so it distributes nothing
+	LintSameSelector = "StrategyRulesShareSelector"
+)
+
+// StrategyLint is a suspicious but valid strategy configuration
+type StrategyLint struct {
+	Reason  string
+	Message string
+}
+
+// LintPlacementStrategy returns the suspicious configurations of a valid strategy for a deployment
+// with the given replicas. The base is checked as resolved; a deployment scaled to zero has no
+// base to check. Failover strategies ignore the weights and the base.
+func LintPlacementStrategy(strategy *PlacementStrategy, replicas int) []StrategyLint {
+	var lints []StrategyLint
+	if !strategy.IsFailover() {
+		totalWeight := 0
+		for _, rule := range strategy.Rules {
+			totalWeight += rule.Weight
+		}
+		if totalWeight == 0 && len(strategy.Rules) > 0 {
+			lints = append(lints, StrategyLint{
+				Reason:  LintZeroWeight,
+				Message: fmt.Sprintf("Every rule has weight 0: pods beyond the base of %d are not placed", strategy.Base),
+			})
+		}
+		if replicas > 0 && strategy.Base > replicas {
+			lints = append(lints, StrategyLint{
+				Reason:  LintBaseExceedsReplicas,
+				Message: fmt.Sprintf("Base %d exceeds the %d replicas: every pod is placed on the first rule", strategy.Base, replicas),
+			})
+		}
+	}
+
+	if len(strategy.Rules) > 1 {
+		key := strategy.Rules[0].Key()
+		same := true
+		for _, rule := range strategy.Rules[1:] {
+			if rule.Key() != key {
+				same = false
+				break
+			}
+		}
+		if same {
+			selected := key.String()
+			if key == "" {
+				selected = "any node"
+			}
+			lints = append(lints, StrategyLint{
+				Reason:  LintSameSelector,
+				Message: fmt.Sprintf("All %d rules select the same nodes (%s): the strategy does not spread pods", len(strategy.Rules), selected),
+			})
+		}
+	}
+	return lints
+}
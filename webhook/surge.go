@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SurgePodAnnotation marks pods placed on a surge target rule because they were created above
+	// the deployment's replicas during a rollout
+	SurgePodAnnotation = "smart-scheduler.io/surge-pod"
+	// PodDeletionCostAnnotation ranks pods for ReplicaSet scale-down; lower costs are deleted first
+	PodDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+	// surgePodDeletionCost makes surge pods the first ones removed on scale-down
+	surgePodDeletionCost = "-100"
+	// ScaleDownHintAnnotation marks pods whose deletion cost the rebalancer lowered because their
+	// rule is over-represented, so its hints are told apart from deletion costs set by others
+	ScaleDownHintAnnotation = "smart-scheduler.io/scale-down-hint"
+)
+
+var (
+	// defaultMaxSurge is the Deployment default used when a rolling update sets no maxSurge
+	defaultMaxSurge = intstr.FromString("25%")
+	// defaultMaxUnavailable is the Deployment default used when a rolling update sets no maxUnavailable
+	defaultMaxUnavailable = intstr.FromString("25%")
+)
+
+// SurgeTargets returns the rules that take the pods a rollout creates above the deployment's replicas
+func (s *PlacementStrategy) SurgeTargets() []PlacementRule {
+	var targets []PlacementRule
+	for _, rule := range s.Rules {
+		if rule.SurgeTarget {
+			targets = append(targets, rule)
+		}
+	}
+	return targets
+}
+
+// SurgeCapacity returns how many pods a rolling update may run above the deployment's replicas.
+// Recreate deployments never surge.
+func SurgeCapacity(deployment *appsv1.Deployment) int {
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return 0
+	}
+
+	maxSurge := &defaultMaxSurge
+	if deployment.Spec.Strategy.RollingUpdate != nil && deployment.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+		maxSurge = deployment.Spec.Strategy.RollingUpdate.MaxSurge
+	}
+	surge, err := intstr.GetScaledValueFromIntOrPercent(maxSurge, int(deploymentReplicas(deployment)), true)
+	if err != nil || surge < 0 {
+		return 0
+	}
+	return surge
+}
+
+// MaxUnavailable returns how many of the deployment's replicas its rolling update lets be
+// unavailable, resolved like the deployment controller does: rounded down, and 1 when maxSurge
+// resolves to 0 as well. Recreate deployments declare no limit and return -1.
+func MaxUnavailable(deployment *appsv1.Deployment) int {
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return -1
+	}
+
+	maxUnavailable := &defaultMaxUnavailable
+	if deployment.Spec.Strategy.RollingUpdate != nil && deployment.Spec.Strategy.RollingUpdate.MaxUnavailable != nil {
+		maxUnavailable = deployment.Spec.Strategy.RollingUpdate.MaxUnavailable
+	}
+	unavailable, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, int(deploymentReplicas(deployment)), false)
+	if err != nil || unavailable < 0 {
+		unavailable = 0
+	}
+	if unavailable == 0 && SurgeCapacity(deployment) == 0 {
+		return 1
+	}
+	return unavailable
+}
+
+// DisruptionAllowance returns how many more of the deployment's pods may be made unavailable before
+// its available pods drop below replicas minus maxUnavailable. Pods a rollout surged count as
+// available and pods it took down as unavailable, so a concurrent rollout and evictions share one
+// allowance. Recreate deployments are allowed one pod at a time.
+func DisruptionAllowance(deployment *appsv1.Deployment) int {
+	maxUnavailable := MaxUnavailable(deployment)
+	if maxUnavailable < 0 {
+		return 1
+	}
+	minAvailable := int(deploymentReplicas(deployment)) - maxUnavailable
+	return max(int(deployment.Status.AvailableReplicas)-minAvailable, 0)
+}
+
+// isSurgePod reports whether the pod is created above the deployment's replicas by a rolling update,
+// within its maxSurge. Only strategies with a surge target rule are checked.
+func (pm *PodMutator) isSurgePod(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy) bool {
+	if deployment == nil || strategy.IsFailover() || len(strategy.SurgeTargets()) == 0 {
+		return false
+	}
+	if ownerRef := metav1.GetControllerOf(pod); ownerRef == nil || ownerRef.Kind != "ReplicaSet" {
+		return false
+	}
+	capacity := SurgeCapacity(deployment)
+	if capacity == 0 {
+		return false
+	}
+
+	pods, err := ListDeploymentPods(ctx, pm.Client, deployment, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		pm.Log.Error(err, "Failed to count deployment pods, placing without surge targets", "deployment", deployment.Name)
+		return false
+	}
+	running := 0
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil && pods[i].Status.Phase != corev1.PodSucceeded && pods[i].Status.Phase != corev1.PodFailed {
+			running++
+		}
+	}
+
+	above := running - int(deploymentReplicas(deployment))
+	return above >= 0 && above < capacity
+}
+
+// applySurgeTarget places a surge pod on the first surge target rule whose pool is healthy and
+// marks it to be removed first. It reports false when no surge target pool is healthy.
+func (pm *PodMutator) applySurgeTarget(ctx context.Context, pod *corev1.Pod, strategy *PlacementStrategy) (bool, error) {
+	isHealthy := pm.PoolHealth.HealthFunc(ctx)
+	for _, rule := range strategy.SurgeTargets() {
+		if !isHealthy(rule) {
+			continue
+		}
+		if err := applyRule(pod, rule); err != nil {
+			return false, err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[SurgePodAnnotation] = "true"
+		if _, ok := pod.Annotations[PodDeletionCostAnnotation]; !ok {
+			pod.Annotations[PodDeletionCostAnnotation] = surgePodDeletionCost
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// deploymentReplicas returns the deployment's desired replicas, defaulting to 1
+func deploymentReplicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Bounds of the backoff after API Priority and Fairness throttled a request
+const (
+	MinThrottleBackoff = time.Second
+	MaxThrottleBackoff = 30 * time.Second
+)
+
+var (
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "smart_scheduler_api_throttled_requests_total",
+		Help: "Requests the API server rejected with 429 Too Many Requests by HTTP method, excluding evictions refused by a PodDisruptionBudget",
+	}, []string{"method"})
+	throttleBackoff = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smart_scheduler_api_throttle_backoff_seconds",
+		Help: "Current backoff the operator's API requests wait out after the API server throttled them, 0 when not throttled",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(throttledRequests, throttleBackoff)
+}
+
+// Throttle is the backoff shared by every API client of the process: the controllers, the webhook
+// and the impersonated tenant clients all talk to the same API server under the same identity, so
+// one of them being throttled means all of them should slow down
+var Throttle = NewAPIThrottle(MinThrottleBackoff, MaxThrottleBackoff)
+
+// APIThrottle backs off API requests while the API server's priority and fairness throttles them.
+// Each 429 response doubles the backoff, at least to the Retry-After the server asked for, and
+// successful responses after the backoff ran out halve it again.
+type APIThrottle struct {
+	Min time.Duration
+	Max time.Duration
+
+	mu      sync.Mutex
+	backoff time.Duration
+	until   time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewAPIThrottle creates a throttle backing off between minBackoff and maxBackoff
+func NewAPIThrottle(minBackoff, maxBackoff time.Duration) *APIThrottle {
+	return &APIThrottle{Min: minBackoff, Max: maxBackoff, now: time.Now}
+}
+
+// Throttled records a throttled request whose response asked to retry after retryAfter
+func (t *APIThrottle) Throttled(retryAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backoff = min(max(t.backoff*2, retryAfter, t.Min), t.Max)
+	t.until = t.now().Add(t.backoff)
+	throttleBackoff.Set(t.backoff.Seconds())
+}
+
+// Succeeded records a request the API server served. Once the backoff ran out it is halved, and
+// dropped below Min.
+func (t *APIThrottle) Succeeded() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.backoff == 0 || t.now().Before(t.until) {
+		return
+	}
+	if t.backoff /= 2; t.backoff < t.Min {
+		t.backoff = 0
+	}
+	throttleBackoff.Set(t.backoff.Seconds())
+}
+
+// Delay returns how long requests still wait before they are sent
+func (t *APIThrottle) Delay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return max(t.until.Sub(t.now()), 0)
+}
+
+// Wait waits out the backoff. Requests whose context ends before the backoff, such as the
+// webhook's within its admission timeout, are sent at once rather than failed, since waiting
+// could only make them time out.
+func (t *APIThrottle) Wait(ctx context.Context) error {
+	delay := t.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WrapTransport wraps an API client's transport so its requests wait out the backoff and its
+// responses feed it. Use it with rest.Config.Wrap; copies of the config share the throttle.
+func (t *APIThrottle) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{throttle: t, next: rt}
+}
+
+// throttledTransport applies an APIThrottle to the requests of one transport
+type throttledTransport struct {
+	throttle *APIThrottle
+	next     http.RoundTripper
+}
+
+func (rt *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.throttle.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests && !strings.HasSuffix(req.URL.Path, "/eviction"):
+		// Evictions answer 429 when a PodDisruptionBudget refuses them, which is no reason to slow down
+		throttledRequests.WithLabelValues(strings.ToLower(req.Method)).Inc()
+		rt.throttle.Throttled(retryAfter(resp))
+	case resp.StatusCode < http.StatusInternalServerError:
+		rt.throttle.Succeeded()
+	}
+	return resp, nil
+}
+
+// retryAfter returns the delay the response's Retry-After header asks for, in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// IsThrottled reports whether err is the API server throttling a request: 429 Too Many Requests
+// with a delay to retry after, unlike the 429 of an eviction refused by a PodDisruptionBudget
+func IsThrottled(err error) bool {
+	if !apierrors.IsTooManyRequests(err) || apierrors.HasStatusCause(err, policyv1.DisruptionBudgetCause) {
+		return false
+	}
+	_, delay := apierrors.SuggestsClientDelay(err)
+	return delay
+}
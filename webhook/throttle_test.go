@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIThrottle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	throttle := NewAPIThrottle(time.Second, 8*time.Second)
+	throttle.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		throttled bool
+		after     time.Duration
+		delay     time.Duration
+	}{
+		{"first throttle waits the minimum", 0, true, 0, time.Second},
+		{"throttles double the backoff", 0, true, 0, 2 * time.Second},
+		{"Retry-After longer than the backoff wins", 0, true, 5 * time.Second, 5 * time.Second},
+		{"backoff is capped", 0, true, 0, 8 * time.Second},
+		{"success during the backoff keeps it", 4 * time.Second, false, 0, 4 * time.Second},
+		{"success after the backoff halves it", 4 * time.Second, false, 0, 0},
+		{"next throttle doubles the halved backoff", 0, true, 0, 8 * time.Second},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.throttled {
+			throttle.Throttled(step.after)
+		} else {
+			throttle.Succeeded()
+		}
+		if got := throttle.Delay(); got != step.delay {
+			t.Errorf("%s: Delay() = %v, want %v", step.name, got, step.delay)
+		}
+	}
+
+	// Successes after each backoff ran out decay it to nothing
+	for _, backoff := range []time.Duration{4 * time.Second, 2 * time.Second, time.Second, 0} {
+		now = now.Add(10 * time.Second)
+		throttle.Succeeded()
+		if throttle.backoff != backoff {
+			t.Errorf("backoff = %v, want %v", throttle.backoff, backoff)
+		}
+	}
+}
+
+func TestThrottledTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("throttle") != "" {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	throttle := NewAPIThrottle(time.Second, time.Minute)
+	throttle.now = func() time.Time { return now }
+	client := &http.Client{Transport: throttle.WrapTransport(http.DefaultTransport)}
+	get := func(ctx context.Context, path string) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	get(context.Background(), "/api/v1/namespaces/default/pods/web/eviction?throttle=1")
+	if got := throttle.Delay(); got != 0 {
+		t.Errorf("Expected a refused eviction not to back off, Delay() = %v", got)
+	}
+
+	get(context.Background(), "/api/v1/pods?throttle=1")
+	if got := throttle.Delay(); got != 3*time.Second {
+		t.Errorf("Expected the Retry-After to set the backoff, Delay() = %v", got)
+	}
+
+	// A request that could only time out waiting is sent at once
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	get(ctx, "/api/v1/pods")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request with a short deadline not to wait, took %v", elapsed)
+	}
+
+	now = now.Add(3 * time.Second)
+	get(context.Background(), "/api/v1/pods")
+	if throttle.backoff != 1500*time.Millisecond || throttle.Delay() != 0 {
+		t.Errorf("Expected the success after the backoff to halve it, backoff = %v", throttle.backoff)
+	}
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// TopologySpreadConflictAnnotation records on a pod why its placement may leave its topology spread
+// constraints unsatisfiable, when every rule of its strategy conflicts with them
+const TopologySpreadConflictAnnotation = "smart-scheduler.io/topology-spread-conflict"
+
+var topologySpreadConflicts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_topology_spread_conflicts_total",
+		Help: "Placements whose strategy has rules conflicting with the pod's topology spread constraints, by namespace, deployment and result: avoided (placed on a compatible rule) or unresolved (placed with a warning)",
+	},
+	[]string{"namespace", "deployment", "result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(topologySpreadConflicts)
+}
+
+// HasHardSpreadConstraints reports whether the pod has topology spread constraints the scheduler
+// enforces, which a nodeSelector can make unsatisfiable
+func HasHardSpreadConstraints(pod *corev1.Pod) bool {
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable == corev1.DoNotSchedule {
+			return true
+		}
+	}
+	return false
+}
+
+// SpreadConflict returns why the rule's nodeSelector would make one of the pod's DoNotSchedule
+// topology spread constraints unsatisfiable, or "" when it would not. The rule conflicts when its
+// nodes span fewer topology domains than the constraint's minDomains, or, with nodeAffinityPolicy
+// Ignore, fewer than the domains the scheduler balances across: a rule pinned to one zone with
+// maxSkew=1 over three zones stops admitting pods after the first one. A rule matching no node is
+// not judged, since its pool may be scaled from zero.
+func SpreadConflict(pod *corev1.Pod, rule PlacementRule, nodes []corev1.Node) string {
+	selector := copyStringMap(pod.Spec.NodeSelector)
+	if selector == nil {
+		selector = make(map[string]string, len(rule.NodeSelector))
+	}
+	for key, value := range rule.NodeSelector {
+		selector[key] = value
+	}
+	matching := labels.SelectorFromSet(selector)
+
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable != corev1.DoNotSchedule {
+			continue
+		}
+		matched := 0
+		reached := make(map[string]bool)
+		counted := make(map[string]bool)
+		for i := range nodes {
+			domain, ok := nodes[i].Labels[constraint.TopologyKey]
+			if ok {
+				counted[domain] = true
+			}
+			if !matching.Matches(labels.Set(nodes[i].Labels)) {
+				continue
+			}
+			matched++
+			if ok {
+				reached[domain] = true
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+
+		minDomains := 1
+		if constraint.MinDomains != nil {
+			minDomains = int(*constraint.MinDomains)
+		}
+		if len(reached) < minDomains {
+			return fmt.Sprintf("rule %s reaches %d %s domains, fewer than the %d required by a topology spread constraint",
+				rule.Key(), len(reached), constraint.TopologyKey, minDomains)
+		}
+		ignoresSelector := constraint.NodeAffinityPolicy != nil && *constraint.NodeAffinityPolicy == corev1.NodeInclusionPolicyIgnore
+		if ignoresSelector && len(reached) < len(counted) {
+			return fmt.Sprintf("rule %s reaches %d of the %d %s domains a topology spread constraint with maxSkew=%d balances across",
+				rule.Key(), len(reached), len(counted), constraint.TopologyKey, constraint.MaxSkew)
+		}
+	}
+	return ""
+}
+
+// AvoidSpreadConflicts returns the strategy without the rules conflicting with the pod's topology
+// spread constraints, and the conflicts by rule. The strategy is returned unchanged when every rule
+// conflicts, since the pod has to go somewhere.
+func AvoidSpreadConflicts(pod *corev1.Pod, strategy *PlacementStrategy, nodes []corev1.Node) (*PlacementStrategy, map[RuleKey]string) {
+	conflicts := make(map[RuleKey]string)
+	for _, rule := range strategy.Rules {
+		if conflict := SpreadConflict(pod, rule, nodes); conflict != "" {
+			conflicts[rule.Key()] = conflict
+		}
+	}
+	if len(conflicts) == 0 {
+		return strategy, nil
+	}
+	return keepRules(strategy, func(rule PlacementRule) bool { return conflicts[rule.Key()] == "" }), conflicts
+}
+
+// avoidSpreadConflicts drops the rules that would leave the pod's topology spread constraints
+// unsatisfiable. When every rule conflicts, the pod is placed as usual and the conflict recorded on
+// it, to be returned as an admission warning. Failing to list the nodes keeps the strategy.
+func (pm *PodMutator) avoidSpreadConflicts(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy) *PlacementStrategy {
+	if !HasHardSpreadConstraints(pod) {
+		return strategy
+	}
+	nodes := &corev1.NodeList{}
+	if err := pm.Client.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
+		pm.Log.Error(err, "Failed to list nodes, placing without checking topology spread constraints", "deployment", deployment.Name)
+		return strategy
+	}
+
+	compatible, conflicts := AvoidSpreadConflicts(pod, strategy, nodes.Items)
+	if len(conflicts) == 0 {
+		return strategy
+	}
+	if len(conflicts) < len(strategy.Rules) {
+		pm.Log.Info("Placing on the rules compatible with the pod's topology spread constraints",
+			"deployment", deployment.Name, "conflicts", conflicts)
+		topologySpreadConflicts.WithLabelValues(deployment.Namespace, deployment.Name, "avoided").Inc()
+		return compatible
+	}
+
+	messages := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		messages = append(messages, conflict)
+	}
+	sort.Strings(messages)
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[TopologySpreadConflictAnnotation] = strings.Join(messages, "; ")
+	pm.Log.Info("Every rule conflicts with the pod's topology spread constraints, the pod may stay Pending",
+		"deployment", deployment.Name, "conflicts", conflicts)
+	topologySpreadConflicts.WithLabelValues(deployment.Namespace, deployment.Name, "unresolved").Inc()
+	return strategy
+}
+
+// withPlacementWarnings adds the topology spread conflict and the skipped maintenance check recorded
+// on the pod to the response's warnings
+func withPlacementWarnings(resp admission.Response, pod *corev1.Pod) admission.Response {
+	if conflict := pod.Annotations[TopologySpreadConflictAnnotation]; conflict != "" {
+		resp.Warnings = append(resp.Warnings, "SmartScheduler: placement conflicts with topologySpreadConstraints: "+conflict)
+	}
+	if reason := pod.Annotations[MaintenanceUncheckedAnnotation]; reason != "" {
+		resp.Warnings = append(resp.Warnings, "SmartScheduler: pod placed without excluding nodes under maintenance: "+reason)
+	}
+	return resp
+}
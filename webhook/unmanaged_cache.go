@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// DefaultUnmanagedCacheTTL bounds how long a "no strategy" entry is trusted without an invalidating event
+const DefaultUnmanagedCacheTTL = 5 * time.Minute
+
+// unmanagedEntry records that a ReplicaSet belongs to a deployment without a schedule strategy
+type unmanagedEntry struct {
+	deployment types.NamespacedName
+	expiresAt  time.Time
+}
+
+// UnmanagedCache remembers ReplicaSets whose deployments carry no schedule strategy, so pods they
+// create can be admitted without looking up the ReplicaSet and Deployment.
+// Entries are dropped when the deployment's strategy annotations change or the ReplicaSet is deleted.
+type UnmanagedCache struct {
+	TTL time.Duration
+
+	mu          sync.RWMutex
+	replicaSets map[types.UID]unmanagedEntry
+}
+
+// NewUnmanagedCache creates an empty unmanaged deployment cache
+func NewUnmanagedCache(ttl time.Duration) *UnmanagedCache {
+	if ttl <= 0 {
+		ttl = DefaultUnmanagedCacheTTL
+	}
+	return &UnmanagedCache{
+		TTL:         ttl,
+		replicaSets: make(map[types.UID]unmanagedEntry),
+	}
+}
+
+// IsUnmanaged reports whether the ReplicaSet is known to belong to a deployment without a strategy
+func (uc *UnmanagedCache) IsUnmanaged(replicaSetUID types.UID) bool {
+	uc.mu.RLock()
+	entry, ok := uc.replicaSets[replicaSetUID]
+	uc.mu.RUnlock()
+	return ok && time.Now().Before(entry.expiresAt)
+}
+
+// MarkUnmanaged records that the ReplicaSet belongs to the given deployment, which has no strategy
+func (uc *UnmanagedCache) MarkUnmanaged(replicaSetUID types.UID, deployment types.NamespacedName) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.replicaSets[replicaSetUID] = unmanagedEntry{deployment: deployment, expiresAt: time.Now().Add(uc.TTL)}
+}
+
+// InvalidateDeployment drops every entry recorded for the deployment
+func (uc *UnmanagedCache) InvalidateDeployment(deployment types.NamespacedName) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	for uid, entry := range uc.replicaSets {
+		if entry.deployment == deployment {
+			delete(uc.replicaSets, uid)
+		}
+	}
+}
+
+// ForgetReplicaSet drops the entry for a ReplicaSet
+func (uc *UnmanagedCache) ForgetReplicaSet(replicaSetUID types.UID) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	delete(uc.replicaSets, replicaSetUID)
+}
+
+// Len returns the number of cached entries, including expired ones not yet replaced
+func (uc *UnmanagedCache) Len() int {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	return len(uc.replicaSets)
+}
+
+// RegisterInvalidation hooks the cache up to the Deployment and ReplicaSet informers
+func (uc *UnmanagedCache) RegisterInvalidation(ctx context.Context, informers cache.Informers) error {
+	deploymentInformer, err := informers.GetInformer(ctx, &appsv1.Deployment{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment informer: %w", err)
+	}
+	_, err = deploymentInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDep, oldOk := oldObj.(*appsv1.Deployment)
+			newDep, newOk := newObj.(*appsv1.Deployment)
+			if !oldOk || !newOk {
+				return
+			}
+			if StrategyAnnotationsChanged(oldDep.Annotations, newDep.Annotations) {
+				uc.InvalidateDeployment(types.NamespacedName{Namespace: newDep.Namespace, Name: newDep.Name})
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if dep, ok := obj.(*appsv1.Deployment); ok {
+				uc.InvalidateDeployment(types.NamespacedName{Namespace: dep.Namespace, Name: dep.Name})
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch deployments for strategy changes: %w", err)
+	}
+
+	replicaSetInformer, err := informers.GetInformer(ctx, &appsv1.ReplicaSet{})
+	if err != nil {
+		return fmt.Errorf("failed to get replicaset informer: %w", err)
+	}
+	_, err = replicaSetInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if rs, ok := obj.(*appsv1.ReplicaSet); ok {
+				uc.ForgetReplicaSet(rs.UID)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch replicasets for deletion: %w", err)
+	}
+
+	return nil
+}
+
+// RegisterStatefulSetInvalidation hooks the cache up to the StatefulSet informer. Pods of a
+// StatefulSet are owned by it directly, so its entry is keyed by the StatefulSet's own UID.
+func (uc *UnmanagedCache) RegisterStatefulSetInvalidation(ctx context.Context, informers cache.Informers) error {
+	statefulSetInformer, err := informers.GetInformer(ctx, &appsv1.StatefulSet{})
+	if err != nil {
+		return fmt.Errorf("failed to get statefulset informer: %w", err)
+	}
+	_, err = statefulSetInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSts, oldOk := oldObj.(*appsv1.StatefulSet)
+			newSts, newOk := newObj.(*appsv1.StatefulSet)
+			if oldOk && newOk && StrategyAnnotationsChanged(oldSts.Annotations, newSts.Annotations) {
+				uc.ForgetReplicaSet(newSts.UID)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if sts, ok := obj.(*appsv1.StatefulSet); ok {
+				uc.ForgetReplicaSet(sts.UID)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch statefulsets for strategy changes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultUnschedulableTimeout is how long a pod may stay Unschedulable before new pods of its
+// deployment spill away from its rule
+const DefaultUnschedulableTimeout = 5 * time.Minute
+
+var unschedulableSpills = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_unschedulable_spills_total",
+		Help: "Pods placed away from rules whose pods are stuck Unschedulable, by namespace and deployment",
+	},
+	[]string{"namespace", "deployment"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(unschedulableSpills)
+}
+
+// UnschedulableSince returns when the scheduler reported the pod Unschedulable. It reports false
+// for pods that are scheduled, being deleted, or not yet tried by the scheduler.
+func UnschedulableSince(pod *corev1.Pod) (time.Time, bool) {
+	if pod.DeletionTimestamp != nil || pod.Spec.NodeName != "" || pod.Status.Phase != corev1.PodPending {
+		return time.Time{}, false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == corev1.PodReasonUnschedulable {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// CountUnschedulableByRule counts the pods per rule the scheduler reported Unschedulable before
+// cutoff. They are a subset of the pods CountPodsByRule attributes to each rule.
+func CountUnschedulableByRule(pods []corev1.Pod, strategy *PlacementStrategy, cutoff time.Time) map[RuleKey]int {
+	counts := make(map[RuleKey]int)
+	matcher := NewRuleMatcher(strategy)
+	for i := range pods {
+		since, ok := UnschedulableSince(&pods[i])
+		if !ok || since.After(cutoff) {
+			continue
+		}
+		if ruleKey, ok := matcher.Match(PlacementSelector(&pods[i])); ok {
+			counts[ruleKey]++
+		}
+	}
+	return counts
+}
+
+// UnschedulableSpill sends new pods away from rules whose pods have been stuck Unschedulable for
+// longer than Timeout. Stuck pods still count toward their rule, so without spilling the weighted
+// distribution keeps sending pods to a pool that cannot take them. A nil spill never spills.
+type UnschedulableSpill struct {
+	Timeout time.Duration
+	Log     logr.Logger
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewUnschedulableSpill creates a spill for pods stuck Unschedulable longer than timeout
+func NewUnschedulableSpill(timeout time.Duration, log logr.Logger) *UnschedulableSpill {
+	return &UnschedulableSpill{Timeout: timeout, Log: log, now: time.Now}
+}
+
+// Stuck returns the pods per rule stuck Unschedulable for longer than the timeout
+func (s *UnschedulableSpill) Stuck(pods []corev1.Pod, strategy *PlacementStrategy) map[RuleKey]int {
+	if s == nil {
+		return nil
+	}
+	return s.StuckAt(pods, strategy, s.now())
+}
+
+// StuckAt returns the pods per rule that were stuck Unschedulable for longer than the timeout at now
+func (s *UnschedulableSpill) StuckAt(pods []corev1.Pod, strategy *PlacementStrategy, now time.Time) map[RuleKey]int {
+	if s == nil {
+		return nil
+	}
+	return CountUnschedulableByRule(pods, strategy, now.Add(-s.Timeout))
+}
+
+// Spill returns the strategy without the rules holding pods stuck Unschedulable for longer than
+// the timeout, and the stuck pods per rule. The strategy is returned unchanged when every rule has
+// stuck pods, since the pod has to go somewhere.
+func (s *UnschedulableSpill) Spill(pods []corev1.Pod, strategy *PlacementStrategy) (*PlacementStrategy, map[RuleKey]int) {
+	if s == nil {
+		return strategy, nil
+	}
+	return s.SpillAt(pods, strategy, s.now())
+}
+
+// SpillAt is Spill for the pods stuck at now
+func (s *UnschedulableSpill) SpillAt(pods []corev1.Pod, strategy *PlacementStrategy, now time.Time) (*PlacementStrategy, map[RuleKey]int) {
+	stuck := s.StuckAt(pods, strategy, now)
+	if len(stuck) == 0 {
+		return strategy, stuck
+	}
+	return keepRules(strategy, func(rule PlacementRule) bool { return stuck[rule.Key()] == 0 }), stuck
+}
+
+// keepRules returns the strategy with only the rules keep accepts. The base belongs to the first
+// rule and is dropped with it. The strategy is returned unchanged when keep accepts every rule or
+// none.
+func keepRules(strategy *PlacementStrategy, keep func(PlacementRule) bool) *PlacementStrategy {
+	filtered := &PlacementStrategy{
+		Base:        strategy.Base,
+		Mode:        strategy.Mode,
+		BasePercent: strategy.BasePercent,
+		BaseFrom:    strategy.BaseFrom,
+		Rules:       make([]PlacementRule, 0, len(strategy.Rules)),
+	}
+	for i, rule := range strategy.Rules {
+		if keep(rule) {
+			filtered.Rules = append(filtered.Rules, rule)
+		} else if i == 0 {
+			filtered.Base, filtered.BasePercent, filtered.BaseFrom = 0, 0, ""
+		}
+	}
+	if len(filtered.Rules) == 0 || len(filtered.Rules) == len(strategy.Rules) {
+		return strategy
+	}
+	return filtered
+}
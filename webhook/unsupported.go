@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// SkipReasonAuditAnnotation is the audit annotation recording why the webhook left a pod of an
+	// unsupported kind alone. The API server prefixes it with the webhook name.
+	SkipReasonAuditAnnotation = "skip-reason"
+
+	// SkipReasonDaemonSet marks pods of DaemonSets, which the DaemonSet controller pins to one node each
+	SkipReasonDaemonSet = "daemonset"
+	// SkipReasonStaticPod marks mirror pods the kubelet creates for static pods already running on its node
+	SkipReasonStaticPod = "static-pod"
+	// SkipReasonPreBound marks pods created with spec.nodeName set, which the scheduler never sees
+	SkipReasonPreBound = "PreBound"
+
+	// SkipReasonAnnotation records on a managed pod why the webhook did not place it
+	SkipReasonAnnotation = "smart-scheduler.io/skip-reason"
+)
+
+var unsupportedPods = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_unsupported_pods_total",
+		Help: "Pods the webhook skipped because their kind is never placed, by reason (daemonset, static-pod, PreBound)",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(unsupportedPods)
+}
+
+// UnsupportedPodReason reports why a pod is of a kind the webhook never places. DaemonSet pods are
+// bound to their node through node affinity set by the DaemonSet controller, and mirror pods stand
+// for static pods the kubelet already runs; a nodeSelector would conflict with either.
+func UnsupportedPodReason(pod *corev1.Pod) (string, bool) {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return SkipReasonStaticPod, true
+	}
+
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil {
+		return "", false
+	}
+	switch ownerRef.Kind {
+	case "DaemonSet":
+		return SkipReasonDaemonSet, true
+	case "Node":
+		// Mirror pods are owned by their node
+		return SkipReasonStaticPod, true
+	}
+	return "", false
+}
+
+// skipUnsupportedPod allows a pod of an unsupported kind unchanged, recording the reason in the
+// audit log and in metrics
+func skipUnsupportedPod(log logr.Logger, reason string) admission.Response {
+	log.Info("Pod kind is not placed by smart scheduling, skipping", "reason", reason)
+	unsupportedPods.WithLabelValues(reason).Inc()
+
+	resp := admission.Allowed(fmt.Sprintf("SmartScheduler does not place %s pods", reason))
+	resp.AuditAnnotations = map[string]string{SkipReasonAuditAnnotation: reason}
+	return resp
+}
+
+// skipPreBoundPod admits a managed pod created with spec.nodeName set without placing it: the pod
+// is bound before the scheduler sees it, so a nodeSelector could only make the kubelet reject it.
+// The pod is annotated with the reason, which keeps the rebalancer from evicting it for drift.
+func skipPreBoundPod(req admission.Request, pod *corev1.Pod, log logr.Logger) admission.Response {
+	log.Info("Pod is bound at creation and cannot be placed, skipping", "nodeName", pod.Spec.NodeName)
+	unsupportedPods.WithLabelValues(SkipReasonPreBound).Inc()
+
+	resp := admission.Allowed("SmartScheduler does not place pods bound at creation")
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[SkipReasonAnnotation] = SkipReasonPreBound
+	if patched, err := PatchResponse(req.Object.Raw, pod); err != nil {
+		log.Error(err, "Failed to compute the pod patch, admitting it unannotated")
+	} else {
+		resp = patched
+	}
+	resp.AuditAnnotations = map[string]string{SkipReasonAuditAnnotation: SkipReasonPreBound}
+	return resp
+}
@@ -0,0 +1,253 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// SkippedRulesAnnotation records on a pod the rules its placement skipped and why, as a JSON
+	// object from rule key to reason, e.g. {"topology.kubernetes.io/zone=us-west-2b":"VolumeTopologyConflict"}
+	SkippedRulesAnnotation = "smart-scheduler.io/skipped-rules"
+	// VolumeTopologyConflict is the reason a rule is skipped when its nodes cannot mount one of the
+	// pod's bound volumes
+	VolumeTopologyConflict = "VolumeTopologyConflict"
+)
+
+var volumeTopologyConflicts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "smart_scheduler_volume_topology_conflicts_total",
+		Help: "Placements whose strategy has rules that cannot mount the pod's bound volumes, by namespace, deployment and result: avoided (placed on a compatible rule) or unplaced (no rule can mount them, left to the scheduler)",
+	},
+	[]string{"namespace", "deployment", "result"},
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(volumeTopologyConflicts)
+}
+
+// BoundVolumeAffinities returns the node affinities of the PersistentVolumes bound to the pod's
+// claims. Claims that are missing or not bound yet, and volumes without a node affinity, impose
+// nothing: the scheduler binds them where the pod goes.
+func BoundVolumeAffinities(ctx context.Context, c client.Reader, namespace string, pod *corev1.Pod) ([]*corev1.NodeSelector, error) {
+	var affinities []*corev1.NodeSelector
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim := &corev1.PersistentVolumeClaim{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: volume.PersistentVolumeClaim.ClaimName}, claim)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get persistentvolumeclaim %s: %w", volume.PersistentVolumeClaim.ClaimName, err)
+		}
+		if claim.Spec.VolumeName == "" {
+			continue
+		}
+
+		pv := &corev1.PersistentVolume{}
+		err = c.Get(ctx, client.ObjectKey{Name: claim.Spec.VolumeName}, pv)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get persistentvolume %s: %w", claim.Spec.VolumeName, err)
+		}
+		if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+			affinities = append(affinities, pv.Spec.NodeAffinity.Required)
+		}
+	}
+	return affinities, nil
+}
+
+// VolumeConflict reports whether the rule keeps the pod off every node that can mount its volumes.
+// The rule's nodeSelector is checked against each volume's node affinity directly, so a rule pinned
+// to another zone conflicts even while its pool is scaled to zero; when the rule matches nodes, one
+// of them must also satisfy every affinity.
+func VolumeConflict(pod *corev1.Pod, rule PlacementRule, affinities []*corev1.NodeSelector, nodes []corev1.Node) bool {
+	if len(affinities) == 0 {
+		return false
+	}
+	selector := copyStringMap(pod.Spec.NodeSelector)
+	if selector == nil {
+		selector = make(map[string]string, len(rule.NodeSelector))
+	}
+	for key, value := range rule.NodeSelector {
+		selector[key] = value
+	}
+	for _, affinity := range affinities {
+		if !selectorCanSatisfy(selector, affinity) {
+			return true
+		}
+	}
+
+	// The rule's node affinity narrows the nodes the pod may be scheduled on further
+	matching := labels.SelectorFromSet(selector)
+	if requirements, selectable := rule.NodeLabelSelector().Requirements(); selectable {
+		matching = matching.Add(requirements...)
+	}
+	matched := false
+	for i := range nodes {
+		if !matching.Matches(labels.Set(nodes[i].Labels)) {
+			continue
+		}
+		matched = true
+		mountable := true
+		for _, affinity := range affinities {
+			if !nodeSatisfies(&nodes[i], affinity) {
+				mountable = false
+				break
+			}
+		}
+		if mountable {
+			return false
+		}
+	}
+	return matched
+}
+
+// selectorCanSatisfy reports whether a node with the nodeSelector's labels may satisfy one of the
+// affinity's terms. Only requirements on the nodeSelector's keys are judged; the others depend on
+// the node.
+func selectorCanSatisfy(nodeSelector map[string]string, affinity *corev1.NodeSelector) bool {
+	for _, term := range affinity.NodeSelectorTerms {
+		possible := true
+		for _, requirement := range term.MatchExpressions {
+			value, ok := nodeSelector[requirement.Key]
+			if !ok {
+				continue
+			}
+			if !requirementMatches(requirement, labels.Set{requirement.Key: value}) {
+				possible = false
+				break
+			}
+		}
+		if possible {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeSatisfies reports whether the node satisfies one of the affinity's terms
+func nodeSatisfies(node *corev1.Node, affinity *corev1.NodeSelector) bool {
+	fields := labels.Set{"metadata.name": node.Name}
+	for _, term := range affinity.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		satisfied := true
+		for _, requirement := range term.MatchExpressions {
+			if !requirementMatches(requirement, labels.Set(node.Labels)) {
+				satisfied = false
+				break
+			}
+		}
+		for _, requirement := range term.MatchFields {
+			if !satisfied {
+				break
+			}
+			satisfied = requirementMatches(requirement, fields)
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeSelectorOperators maps node selector operators to label selector operators
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// requirementMatches evaluates a node selector requirement against a label set. Requirements
+// that cannot be evaluated do not match, as the scheduler treats them.
+func requirementMatches(requirement corev1.NodeSelectorRequirement, set labels.Set) bool {
+	operator, ok := nodeSelectorOperators[requirement.Operator]
+	if !ok {
+		return false
+	}
+	parsed, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+	if err != nil {
+		return false
+	}
+	return parsed.Matches(set)
+}
+
+// AvoidVolumeConflicts returns the strategy without the rules that cannot mount the volumes, and
+// the conflicting rules. The strategy is returned unchanged when every rule conflicts.
+func AvoidVolumeConflicts(pod *corev1.Pod, strategy *PlacementStrategy, affinities []*corev1.NodeSelector, nodes []corev1.Node) (*PlacementStrategy, []RuleKey) {
+	var conflicts []RuleKey
+	for _, rule := range strategy.Rules {
+		if VolumeConflict(pod, rule, affinities, nodes) {
+			conflicts = append(conflicts, rule.Key())
+		}
+	}
+	if len(conflicts) == 0 {
+		return strategy, nil
+	}
+	conflicting := make(map[RuleKey]bool, len(conflicts))
+	for _, key := range conflicts {
+		conflicting[key] = true
+	}
+	return keepRules(strategy, func(rule PlacementRule) bool { return !conflicting[rule.Key()] }), conflicts
+}
+
+// avoidVolumeConflicts drops the rules whose nodes cannot mount the pod's bound zonal volumes and
+// records them in the pod's skipped-rules annotation. When no rule can mount them, an error is
+// returned so the pod is left to the scheduler, which places it where its volumes are.
+func (pm *PodMutator) avoidVolumeConflicts(ctx context.Context, pod *corev1.Pod, deployment *appsv1.Deployment, strategy *PlacementStrategy) (*PlacementStrategy, error) {
+	affinities, err := BoundVolumeAffinities(ctx, pm.Client, deployment.Namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+	if len(affinities) == 0 {
+		return strategy, nil
+	}
+	nodes := &corev1.NodeList{}
+	if err := pm.Client.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	compatible, conflicts := AvoidVolumeConflicts(pod, strategy, affinities, nodes.Items)
+	if len(conflicts) == 0 {
+		return strategy, nil
+	}
+	if len(conflicts) == len(strategy.Rules) {
+		volumeTopologyConflicts.WithLabelValues(deployment.Namespace, deployment.Name, "unplaced").Inc()
+		return nil, fmt.Errorf("%s: no rule can mount the pod's bound volumes", VolumeTopologyConflict)
+	}
+
+	skipped := make(map[string]string, len(conflicts))
+	for _, key := range conflicts {
+		skipped[key.String()] = VolumeTopologyConflict
+	}
+	data, err := json.Marshal(skipped)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[SkippedRulesAnnotation] = string(data)
+	pm.Log.Info("Placing on the rules that can mount the pod's bound volumes",
+		"deployment", deployment.Name, "skipped", conflicts)
+	volumeTopologyConflicts.WithLabelValues(deployment.Namespace, deployment.Name, "avoided").Inc()
+	return compatible, nil
+}
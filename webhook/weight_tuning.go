@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// WeightAdjustmentsAnnotation holds the weights the weight tuning controller lowered for a
+// deployment's rules, as a JSON object from rule key to percent of the configured weight, e.g.
+// {"node-type=spot":70}. Rules not listed keep their configured weight.
+const WeightAdjustmentsAnnotation = "smart-scheduler.io/weight-adjustments"
+
+// ParseWeightAdjustments parses the weight adjustments annotation. Percents outside 0-100 are
+// rejected; an empty annotation has no adjustments.
+func ParseWeightAdjustments(annotation string) (map[RuleKey]int, error) {
+	return parseWeightPercents(WeightAdjustmentsAnnotation, annotation)
+}
+
+// parseWeightPercents parses an annotation mapping rule keys to percents of their configured weight
+func parseWeightPercents(name, annotation string) (map[RuleKey]int, error) {
+	if annotation == "" {
+		return nil, nil
+	}
+	var adjustments map[RuleKey]int
+	if err := json.Unmarshal([]byte(annotation), &adjustments); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", name, err)
+	}
+	for key, percent := range adjustments {
+		if percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid %s annotation: weight of rule %s is %d percent, expected 0-100", name, key, percent)
+		}
+	}
+	return adjustments, nil
+}
+
+// ApplyWeightAdjustments returns the strategy with the weights of adjusted rules scaled to their
+// percent. Every weight is scaled by 100 so they stay whole, which leaves the shares of the other
+// rules unchanged relative to each other. Failover strategies have no weights, and adjustments of
+// rules no longer in the strategy are ignored.
+func ApplyWeightAdjustments(strategy *PlacementStrategy, adjustments map[RuleKey]int) *PlacementStrategy {
+	if len(adjustments) == 0 || strategy.IsFailover() {
+		return strategy
+	}
+	adjusted := *strategy
+	adjusted.Rules = make([]PlacementRule, len(strategy.Rules))
+	for i, rule := range strategy.Rules {
+		percent, ok := adjustments[rule.Key()]
+		if !ok {
+			percent = 100
+		}
+		rule.Weight *= percent
+		adjusted.Rules[i] = rule
+	}
+	return &adjusted
+}
+
+// withWeightAdjustments applies the deployment's weight adjustments when weight tuning is enabled,
+// and its spot score weights when spot placement scores are. An invalid annotation leaves the
+// weights it would have lowered.
+func (pm *PodMutator) withWeightAdjustments(deployment *appsv1.Deployment, strategy *PlacementStrategy) *PlacementStrategy {
+	if pm.WeightTuning {
+		adjustments, err := ParseWeightAdjustments(deployment.Annotations[WeightAdjustmentsAnnotation])
+		if err != nil {
+			pm.Log.Error(err, "Using the configured weights", "deployment", deployment.Name)
+		} else {
+			strategy = ApplyWeightAdjustments(strategy, adjustments)
+		}
+	}
+	if pm.SpotScores {
+		var err error
+		if strategy, err = WithSpotScoreWeights(deployment, strategy); err != nil {
+			pm.Log.Error(err, "Ignoring spot placement scores", "deployment", deployment.Name)
+		}
+	}
+	return strategy
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyWeightAdjustments(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   string
+		annotation string
+		want       []int
+		wantErr    bool
+	}{
+		{"no adjustments", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", "", []int{1, 3}, false},
+		{"spot lowered", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", `{"node-type=spot":70}`, []int{100, 210}, false},
+		{"removed rule ignored", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", `{"node-type=gpu":50}`, []int{100, 300}, false},
+		{"failover untouched", "mode=failover,nodeSelector=zone:zone-a;nodeSelector=zone:zone-b", `{"zone=zone-a":50}`, []int{0, 0}, false},
+		{"percent above 100", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", `{"node-type=spot":150}`, nil, true},
+		{"invalid JSON", "base=1,weight=1,nodeSelector=node-type:ondemand;weight=3,nodeSelector=node-type:spot", `{"node-type=spot"`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParsePlacementStrategy(tt.strategy)
+			if err != nil {
+				t.Fatalf("Failed to parse strategy: %v", err)
+			}
+			adjustments, err := ParseWeightAdjustments(tt.annotation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWeightAdjustments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			weights := func(strategy *PlacementStrategy) []int {
+				var weights []int
+				for _, rule := range strategy.Rules {
+					weights = append(weights, rule.Weight)
+				}
+				return weights
+			}
+			configured := weights(strategy)
+			if got := weights(ApplyWeightAdjustments(strategy, adjustments)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("weights = %v, want %v", got, tt.want)
+			}
+			// Parsed strategies are shared through the strategy cache
+			if got := weights(strategy); !reflect.DeepEqual(got, configured) {
+				t.Errorf("configured weights changed to %v", got)
+			}
+		})
+	}
+}
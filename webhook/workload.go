@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kinds of the workloads whose pods are placed
+const (
+	WorkloadDeployment  = "Deployment"
+	WorkloadStatefulSet = "StatefulSet"
+)
+
+// StatefulSetWorkload returns the StatefulSet as the workload the webhook and the rebalancer place
+// pods for: a Deployment carrying its metadata, replicas, selector and pod template, whose kind is
+// StatefulSet. StatefulSets never surge and take down at most maxUnavailable pods at a time, one
+// by default. Pods of an OnDelete StatefulSet are only replaced when deleted, so it is never
+// reported as rolling out.
+func StatefulSetWorkload(statefulSet *appsv1.StatefulSet) *appsv1.Deployment {
+	sts := statefulSet.DeepCopy()
+	noSurge := intstr.FromInt(0)
+	maxUnavailable := intstr.FromInt(1)
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.MaxUnavailable != nil {
+		maxUnavailable = *rollingUpdate.MaxUnavailable
+	}
+
+	workload := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: WorkloadStatefulSet},
+		ObjectMeta: sts.ObjectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: sts.Spec.Replicas,
+			Selector: sts.Spec.Selector,
+			Template: sts.Spec.Template,
+			Strategy: appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &noSurge, MaxUnavailable: &maxUnavailable},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: sts.Status.ObservedGeneration,
+			Replicas:           sts.Status.Replicas,
+			UpdatedReplicas:    sts.Status.UpdatedReplicas,
+			ReadyReplicas:      sts.Status.ReadyReplicas,
+			AvailableReplicas:  sts.Status.AvailableReplicas,
+		},
+	}
+	if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		workload.Status.UpdatedReplicas = sts.Status.Replicas
+	}
+	return workload
+}
+
+// IsStatefulSet reports whether the workload is a StatefulSet
+func IsStatefulSet(workload *appsv1.Deployment) bool {
+	return workload.Kind == WorkloadStatefulSet
+}
+
+// WorkloadKind returns the kind of the workload: Deployment or StatefulSet
+func WorkloadKind(workload *appsv1.Deployment) string {
+	if IsStatefulSet(workload) {
+		return WorkloadStatefulSet
+	}
+	return WorkloadDeployment
+}
+
+// GetWorkload reads the named workload of the kind, returning StatefulSets as StatefulSetWorkload
+// does
+func GetWorkload(ctx context.Context, c client.Reader, kind string, key types.NamespacedName) (*appsv1.Deployment, error) {
+	if kind == WorkloadStatefulSet {
+		sts := &appsv1.StatefulSet{}
+		if err := c.Get(ctx, key, sts); err != nil {
+			return nil, err
+		}
+		return StatefulSetWorkload(sts), nil
+	}
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, key, deployment); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// ParentWorkload resolves the kind and name of the workload controlling the pod: a Deployment
+// through its ReplicaSet or, when statefulSets is set, a StatefulSet. It returns false when the pod
+// is controlled by neither.
+func ParentWorkload(ctx context.Context, c client.Reader, pod *corev1.Pod, statefulSets bool) (string, string, bool) {
+	if ownerRef := metav1.GetControllerOf(pod); statefulSets && ownerRef != nil && ownerRef.Kind == WorkloadStatefulSet {
+		return WorkloadStatefulSet, ownerRef.Name, true
+	}
+	name, ok := ParentDeploymentName(ctx, c, pod)
+	return WorkloadDeployment, name, ok
+}
+
+// PatchWorkload applies the patch, computed against the workload, to the Deployment or StatefulSet
+// it stands for. Only metadata changes carry over to a StatefulSet.
+func PatchWorkload(ctx context.Context, c client.Writer, workload *appsv1.Deployment, patch client.Patch) error {
+	if !IsStatefulSet(workload) {
+		return c.Patch(ctx, workload, patch)
+	}
+	data, err := patch.Data(workload)
+	if err != nil {
+		return err
+	}
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: workload.Namespace, Name: workload.Name}}
+	if err := c.Patch(ctx, sts, client.RawPatch(patch.Type(), data)); err != nil {
+		return err
+	}
+	workload.ResourceVersion = sts.ResourceVersion
+	return nil
+}
+
+// StatefulSetOrdinal returns the ordinal of a pod of the named StatefulSet, from its pod index
+// label or else its "<statefulset>-<ordinal>" name
+func StatefulSetOrdinal(pod *corev1.Pod, statefulSet string) (int, bool) {
+	index, ok := pod.Labels[appsv1.PodIndexLabel]
+	if !ok {
+		index, ok = strings.CutPrefix(pod.Name, statefulSet+"-")
+	}
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(index)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// OrdinalRule returns the index of the rule of the slot a StatefulSet pod's ordinal takes in the
+// plan ExpectedDistribution counts: the first base ordinals go to the first rule and the others
+// follow the weights. A StatefulSet scales down from its highest ordinal, so its pods 0 to n-1
+// always hold the distribution of n pods, and a pod recreated with its ordinal returns to its rule.
+func OrdinalRule(strategy *PlacementStrategy, ordinal int) int {
+	base := max(strategy.Base, 0)
+	if ordinal < base {
+		return 0
+	}
+	weighted := make([]int, len(strategy.Rules))
+	i := 0
+	for placed := 0; placed <= ordinal-base; placed++ {
+		i = nextWeightedRule(strategy, weighted, placed)
+		weighted[i]++
+	}
+	return i
+}
+
+// ApplyOrdinalStrategy applies the rule of the pod's ordinal
+func ApplyOrdinalStrategy(pod *corev1.Pod, strategy *PlacementStrategy, ordinal int) error {
+	if strategy == nil || len(strategy.Rules) == 0 {
+		return fmt.Errorf("invalid placement strategy")
+	}
+	return applyRule(pod, strategy.Rules[OrdinalRule(strategy, ordinal)])
+}
+
+// findOwner finds the workload controlling the pod by following its controller references, or nil
+// when there is none or it is gone
+func (pm *PodMutator) findOwner(ctx context.Context, pod *corev1.Pod) (*appsv1.Deployment, error) {
+	kind, name, ok := ParentWorkload(ctx, pm.Client, pod, pm.StatefulSets)
+	if !ok {
+		return nil, nil
+	}
+
+	workload, err := GetWorkload(ctx, pm.Client, kind, types.NamespacedName{Namespace: pod.Namespace, Name: name})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", strings.ToLower(kind), name, err)
+	}
+	return workload, nil
+}
+
+// statefulSetOrdinal returns the ordinal of a pod of a StatefulSet workload. Pods of experiment
+// arms are placed against their arm's pods instead, so their ordinals give no slot.
+func (pm *PodMutator) statefulSetOrdinal(pod *corev1.Pod, workload *appsv1.Deployment) (int, bool) {
+	if !IsStatefulSet(workload) {
+		return 0, false
+	}
+	if _, ok := workload.Annotations[ExperimentAnnotation]; ok {
+		return 0, false
+	}
+	return StatefulSetOrdinal(pod, workload.Name)
+}